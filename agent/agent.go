@@ -0,0 +1,432 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/taskctl/taskctl/pkg/task"
+	"github.com/taskctl/taskctl/pkg/variables"
+
+	"github.com/Flowpack/prunner/condition"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+const (
+	// PollTimeout is the maximum time the coordinator holds a poll request of an agent without an assignment
+	PollTimeout = 25 * time.Second
+	// retryInterval is the time to wait after the coordinator was not reachable
+	retryInterval = 5 * time.Second
+)
+
+// Heartbeat is sent by an agent for a running assignment with the output since the last heartbeat
+type Heartbeat struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+}
+
+// Agent polls a coordinator for assignments and runs them
+type Agent struct {
+	coordinatorURL string
+	name           string
+	token          string
+	concurrency    int
+	client         *http.Client
+}
+
+// AgentOpts is an agent configuration function.
+type AgentOpts func(*Agent)
+
+// WithConcurrency sets the number of assignments that are run concurrently (defaults to 1)
+func WithConcurrency(concurrency int) AgentOpts {
+	return func(a *Agent) {
+		a.concurrency = concurrency
+	}
+}
+
+// WithHTTPClient sets the HTTP client for requests to the coordinator
+func WithHTTPClient(client *http.Client) AgentOpts {
+	return func(a *Agent) {
+		a.client = client
+	}
+}
+
+// NewAgent creates an agent with the name for the coordinator at the URL (the base URL of the prunner HTTP API).
+// The token must be a JWT with the agent scope.
+func NewAgent(coordinatorURL string, name string, token string, opts ...AgentOpts) (*Agent, error) {
+	u, err := url.Parse(coordinatorURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing coordinator URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported coordinator URL scheme %q, expected http or https", u.Scheme)
+	}
+	if name == "" {
+		return nil, errors.New("agent name must not be empty")
+	}
+
+	a := &Agent{
+		coordinatorURL: strings.TrimSuffix(coordinatorURL, "/"),
+		name:           name,
+		token:          token,
+		concurrency:    1,
+		client:         &http.Client{},
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	if a.concurrency < 1 {
+		return nil, errors.New("concurrency must be greater than 0")
+	}
+
+	return a, nil
+}
+
+// Run polls for assignments and runs them until the context is done, running tasks are canceled then.
+// Errors of requests to the coordinator are logged and retried.
+func (a *Agent) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < a.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (a *Agent) work(ctx context.Context) {
+	logger := log.
+		WithField("component", "agent").
+		WithField("agent", a.name)
+
+	for ctx.Err() == nil {
+		assignment, err := a.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.
+				WithError(err).
+				Warn("Error polling coordinator for assignments, retrying")
+
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if assignment == nil {
+			continue
+		}
+
+		a.execute(ctx, *assignment)
+	}
+}
+
+func (a *Agent) poll(ctx context.Context) (*Assignment, error) {
+	var assignment Assignment
+	status, err := a.request(ctx, "/agents/poll?name="+url.QueryEscape(a.name), nil, &assignment)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		return nil, nil
+	}
+	return &assignment, nil
+}
+
+// execute runs the task of the assignment with a local task runner and sends heartbeats with the output until it
+// is finished, the task is canceled if the coordinator does not know the assignment anymore
+func (a *Agent) execute(ctx context.Context, assignment Assignment) {
+	logger := log.
+		WithField("component", "agent").
+		WithField("agent", a.name).
+		WithField("jobID", assignment.JobID).
+		WithField("task", assignment.Task)
+
+	logger.Info("Running task")
+
+	output := newOutputBuffer()
+	taskRunner, t, jobOpts := newTask(output, assignment)
+
+	taskCtx, cancelTask := context.WithCancel(taskctl.WithJobOptions(context.Background(), jobOpts))
+	defer cancelTask()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- taskRunner.Run(taskCtx, t)
+	}()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	var (
+		runErr   error
+		canceled bool
+		stopped  bool
+	)
+loop:
+	for {
+		select {
+		case runErr = <-done:
+			break loop
+		case <-ticker.C:
+			err := a.heartbeat(ctx, assignment.ID, output)
+			if errors.Is(err, ErrAssignmentNotFound) && !canceled {
+				logger.Info("Task was canceled by the coordinator")
+				canceled = true
+				cancelTask()
+			} else if err != nil && ctx.Err() == nil {
+				logger.
+					WithError(err).
+					Warn("Error sending heartbeat")
+			}
+		case <-ctx.Done():
+			if !canceled {
+				canceled = true
+				stopped = true
+				cancelTask()
+			}
+		}
+	}
+	taskRunner.Finish()
+
+	if canceled && !stopped {
+		return
+	}
+
+	// The result is also reported if the agent is stopped, so the coordinator does not wait for the agent timeout
+	reportCtx, cancel := context.WithTimeout(context.Background(), retryInterval)
+	defer cancel()
+
+	// Send the remaining output before the result, the coordinator closes the output with the result
+	err := a.heartbeat(reportCtx, assignment.ID, output)
+	if errors.Is(err, ErrAssignmentNotFound) {
+		logger.Info("Task was canceled by the coordinator")
+		return
+	}
+	if err != nil {
+		logger.
+			WithError(err).
+			Warn("Error sending output")
+	}
+
+	result := Result{
+		ExitCode: t.ExitCode,
+		Skipped:  t.Skipped,
+		Errored:  t.Errored,
+	}
+	switch {
+	case stopped:
+		result.Errored = true
+		result.Error = fmt.Sprintf("agent %s was stopped", a.name)
+	case t.Error != nil:
+		result.Error = t.Error.Error()
+	case runErr != nil:
+		// The task could not be started (e.g. rendering the script failed)
+		result.Errored = true
+		result.Error = runErr.Error()
+	}
+	_, err = a.request(reportCtx, "/agents/assignments/"+url.PathEscape(assignment.ID)+"/result", result, nil)
+	if err != nil {
+		logger.
+			WithError(err).
+			Error("Error reporting task result")
+		return
+	}
+
+	logger.
+		WithField("errored", result.Errored).
+		Info("Task finished")
+}
+
+func (a *Agent) heartbeat(ctx context.Context, id string, output *outputBuffer) error {
+	stdout, stderr := output.take()
+	_, err := a.request(ctx, "/agents/assignments/"+url.PathEscape(id)+"/heartbeat", Heartbeat{Stdout: stdout, Stderr: stderr}, nil)
+	if err != nil {
+		// Send the output again with the next heartbeat
+		output.restore(stdout, stderr)
+	}
+	return err
+}
+
+// request sends a POST request with the body as JSON and decodes the response into result (if not nil)
+func (a *Agent) request(ctx context.Context, path string, body interface{}, result interface{}) (int, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.coordinatorURL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, ErrAssignmentNotFound
+	case resp.StatusCode >= 300:
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return resp.StatusCode, fmt.Errorf("unexpected status %d from coordinator: %s", resp.StatusCode, errResp.Error)
+	case resp.StatusCode == http.StatusOK && result != nil:
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			return resp.StatusCode, errors.Wrap(err, "decoding response")
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// newTask builds a task runner, task and job options for the assignment like prunner.NewTaskRunner and
+// buildPipelineGraph
+func newTask(output *outputBuffer, assignment Assignment) (*taskctl.TaskRunner, *task.Task, taskctl.JobOptions) {
+	// The env was rendered by the coordinator, so it is not passed as task env with templates
+	jobOpts := taskctl.JobOptions{
+		Env:             assignment.Env,
+		ProcessPriority: assignment.Priority,
+	}
+	if !assignment.Interpreter.IsDefault() {
+		jobOpts.Interpreters = map[string]taskctl.Interpreter{assignment.Task: assignment.Interpreter}
+	}
+	if assignment.Retries > 0 {
+		jobOpts.Retries = map[string]int{assignment.Task: assignment.Retries}
+	}
+	if assignment.NoOutput != nil {
+		jobOpts.NoOutput = map[string]taskctl.NoOutputWatch{assignment.Task: *assignment.NoOutput}
+	}
+	if assignment.ChangedPaths != nil {
+		jobOpts.ChangedPaths = map[string]taskctl.ChangedPaths{assignment.Task: *assignment.ChangedPaths}
+	}
+	if assignment.When != "" {
+		// The condition was validated by the coordinator
+		if expr, err := condition.Parse(assignment.When); err == nil {
+			jobOpts.Conditions = map[string]*condition.Expr{assignment.Task: expr}
+		}
+	}
+	// taskctl.NewTaskRunner never actually returns an error
+	taskRunner, _ := taskctl.NewTaskRunner(output)
+	taskRunner.Stdout = io.Discard
+	taskRunner.Stderr = io.Discard
+
+	t := task.FromCommands(assignment.Script...)
+	t.Name = assignment.Task
+	t.AllowFailure = assignment.AllowFailure
+	t.Dir = assignment.Dir
+	if assignment.Timeout > 0 {
+		timeout := assignment.Timeout
+		t.Timeout = &timeout
+	}
+
+	vars := variables.NewVariables()
+	for name, value := range assignment.Variables {
+		vars.Set(name, value)
+	}
+	// The timestamp of the job is a time for the timestamp function, it is sent as string
+	if timestamp, ok := assignment.Variables[taskctl.TimestampVariableName].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			vars.Set(taskctl.TimestampVariableName, parsed)
+		}
+	}
+	t.Variables = vars
+
+	return taskRunner, t, jobOpts
+}
+
+// outputBuffer is an output store that buffers the output of a task until it is sent with a heartbeat
+type outputBuffer struct {
+	mx     sync.Mutex
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+var _ taskctl.OutputStore = &outputBuffer{}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{}
+}
+
+func (o *outputBuffer) Writer(_ string, _ string, outputName string) (io.WriteCloser, error) {
+	return &outputWriter{output: o, stderr: outputName == "stderr"}, nil
+}
+
+func (o *outputBuffer) Reader(_ string, _ string, _ string) (io.ReadCloser, error) {
+	return nil, errors.New("reading output is not supported by agents")
+}
+
+func (o *outputBuffer) Remove(_ string) error {
+	return nil
+}
+
+// take returns and resets the buffered output
+func (o *outputBuffer) take() ([]byte, []byte) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	stdout := append([]byte(nil), o.stdout.Bytes()...)
+	stderr := append([]byte(nil), o.stderr.Bytes()...)
+	o.stdout.Reset()
+	o.stderr.Reset()
+	return stdout, stderr
+}
+
+// restore prepends output that could not be sent
+func (o *outputBuffer) restore(stdout, stderr []byte) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	stdout = append(stdout, o.stdout.Bytes()...)
+	stderr = append(stderr, o.stderr.Bytes()...)
+	o.stdout.Reset()
+	o.stderr.Reset()
+	o.stdout.Write(stdout)
+	o.stderr.Write(stderr)
+}
+
+type outputWriter struct {
+	output *outputBuffer
+	stderr bool
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.output.mx.Lock()
+	defer w.output.mx.Unlock()
+
+	if w.stderr {
+		return w.output.stderr.Write(p)
+	}
+	return w.output.stdout.Write(p)
+}
+
+func (w *outputWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,184 @@
+package agent_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/agent"
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/server"
+	"github.com/Flowpack/prunner/taskctl"
+	"github.com/Flowpack/prunner/test"
+)
+
+// startCoordinator starts a pipeline runner with a coordinator and the HTTP API, it returns the API URL and a token
+// with the agent scope
+func startCoordinator(t *testing.T, ctx context.Context, defs *definition.PipelinesDef, outputStore taskctl.OutputStore) (*prunner.PipelineRunner, string, string) {
+	t.Helper()
+
+	coordinator := agent.NewCoordinator(outputStore)
+	pRunner, err := prunner.NewPipelineRunner(
+		ctx,
+		defs,
+		coordinator.WrapTaskRunner(prunner.NewTaskRunner(outputStore)),
+		test.NewMockStore(),
+		outputStore,
+		prunner.WithJobContext(coordinator.JobContext),
+	)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := server.NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, server.WithCoordinator(coordinator))
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+
+	claims := map[string]interface{}{"scope": server.ScopeAgent}
+	jwtauth.SetIssuedNow(claims)
+	_, token, _ := tokenAuth.Encode(claims)
+
+	return pRunner, httpSrv.URL, token
+}
+
+func runAgent(t *testing.T, ctx context.Context, url string, name string, token string) {
+	t.Helper()
+
+	a, err := agent.NewAgent(url, name, token)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitForJob(t *testing.T, completedJobs chan *prunner.PipelineJob) *prunner.PipelineJob {
+	t.Helper()
+
+	select {
+	case job := <-completedJobs:
+		return job
+	case <-time.After(10 * time.Second):
+		t.Fatal("job was not completed")
+		return nil
+	}
+}
+
+func TestAgent_RunsTasks(t *testing.T) {
+	defs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"deploy": {
+				Concurrency: 1,
+				Env:         map[string]string{"TARGET": "production"},
+				Tasks: map[string]definition.TaskDef{
+					"build": {
+						Script: []string{"echo building"},
+					},
+					"deploy": {
+						Script:    []string{"echo deploying {{ .tag }} to $TARGET on $HOST_NAME", "echo done >&2"},
+						Env:       map[string]string{"HOST_NAME": "web1"},
+						DependsOn: []string{"build"},
+						Agent:     "web",
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outputStore := test.NewMockOutputStore()
+	pRunner, url, token := startCoordinator(t, ctx, defs, outputStore)
+
+	completedJobs := make(chan *prunner.PipelineJob, 1)
+	prunner.Subscribe(pRunner, func(e prunner.JobCompleted) {
+		completedJobs <- e.Job
+	})
+
+	runAgent(t, ctx, url, "web", token)
+
+	job, err := pRunner.ScheduleAsync("deploy", prunner.ScheduleOpts{Variables: map[string]interface{}{"tag": "v1.0.0"}})
+	require.NoError(t, err)
+
+	completedJob := waitForJob(t, completedJobs)
+	assert.Equal(t, job.ID, completedJob.ID)
+	assert.True(t, completedJob.Completed)
+	assert.Nil(t, completedJob.LastError)
+
+	jobID := job.ID.String()
+	assert.Equal(t, "building\n", string(outputStore.GetBytes(jobID, "build", "stdout")))
+	assert.Equal(t, "deploying v1.0.0 to production on web1\n", string(outputStore.GetBytes(jobID, "deploy", "stdout")))
+	assert.Equal(t, "done\n", string(outputStore.GetBytes(jobID, "deploy", "stderr")))
+}
+
+func TestAgent_CancelsTasks(t *testing.T) {
+	defs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"deploy": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"deploy": {
+						Script: []string{"sleep 10"},
+						Agent:  "web",
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outputStore := test.NewMockOutputStore()
+	pRunner, url, token := startCoordinator(t, ctx, defs, outputStore)
+
+	completedJobs := make(chan *prunner.PipelineJob, 1)
+	prunner.Subscribe(pRunner, func(e prunner.JobCompleted) {
+		completedJobs <- e.Job
+	})
+
+	runAgent(t, ctx, url, "web", token)
+
+	job, err := pRunner.ScheduleAsync("deploy", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	test.WaitForCondition(t, func() bool {
+		var started bool
+		_ = pRunner.ReadJob(job.ID, func(j *prunner.PipelineJob) {
+			started = j.Tasks[0].Start != nil
+		})
+		return started
+	}, 10*time.Millisecond, "task was picked up by the agent")
+
+	err = pRunner.CancelJob(job.ID)
+	require.NoError(t, err)
+
+	completedJob := waitForJob(t, completedJobs)
+	assert.True(t, completedJob.Canceled)
+}
+
+func TestNewAgent_Invalid(t *testing.T) {
+	_, err := agent.NewAgent("ftp://localhost", "web", "")
+	assert.EqualError(t, err, `unsupported coordinator URL scheme "ftp", expected http or https`)
+
+	_, err = agent.NewAgent("http://localhost:9009", "", "")
+	assert.EqualError(t, err, "agent name must not be empty")
+
+	_, err = agent.NewAgent("http://localhost:9009", "web", "", agent.WithConcurrency(0))
+	assert.EqualError(t, err, "concurrency must be greater than 0")
+}
@@ -0,0 +1,354 @@
+// Package agent runs tasks of a job on other hosts. The prunner process acts as coordinator: tasks with an agent
+// in the definition are assigned to an agent process with that name instead of being executed locally.
+//
+// Agents poll the coordinator for assignments over the HTTP API, run the task with a local task runner and send the
+// output with heartbeats, so the output is stored by the coordinator like the output of local tasks. A task fails if
+// an agent does not send a heartbeat for the agent timeout.
+package agent
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+const (
+	// DefaultTimeout is the time after which a task fails if its agent does not send a heartbeat
+	DefaultTimeout = 30 * time.Second
+	// heartbeatInterval is the interval of heartbeats (and output uploads) of a running task
+	heartbeatInterval = 1 * time.Second
+)
+
+// ErrAssignmentNotFound is returned for heartbeats and results of assignments that are unknown or were canceled,
+// the agent must stop the task
+var ErrAssignmentNotFound = errors.New("assignment not found")
+
+// Assignment is a task that is executed by an agent
+type Assignment struct {
+	ID       string `json:"id"`
+	JobID    string `json:"jobId"`
+	Pipeline string `json:"pipeline"`
+	Task     string `json:"task"`
+
+	// Script contains the commands of the task, they are rendered with the variables by the agent
+	Script    []string               `json:"script"`
+	Variables map[string]interface{} `json:"variables"`
+	// Env contains the environment of the pipeline merged with the environment of the task
+	Env          map[string]string `json:"env,omitempty"`
+	Dir          string            `json:"dir,omitempty"`
+	Timeout      time.Duration     `json:"timeout,omitempty"`
+	AllowFailure bool              `json:"allowFailure,omitempty"`
+	// When is the condition over the variables to skip the task
+	When string `json:"when,omitempty"`
+	// ChangedPaths skips the task unless files changed in the git repository of the agent
+	ChangedPaths *taskctl.ChangedPaths   `json:"changedPaths,omitempty"`
+	Retries      int                     `json:"retries,omitempty"`
+	NoOutput     *taskctl.NoOutputWatch  `json:"noOutput,omitempty"`
+	Interpreter  taskctl.Interpreter     `json:"interpreter"`
+	Priority     taskctl.ProcessPriority `json:"priority"`
+}
+
+// Result is reported by an agent after a task has finished
+type Result struct {
+	ExitCode int16  `json:"exitCode"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Errored  bool   `json:"errored,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Info describes an agent that polled the coordinator
+type Info struct {
+	Name     string    `json:"name"`
+	LastSeen time.Time `json:"lastSeen"`
+	// Pending is the number of assignments that wait for an agent with this name
+	Pending int `json:"pending"`
+	// Running is the number of assignments that are executed by agents with this name
+	Running int `json:"running"`
+}
+
+type assignment struct {
+	Assignment
+	agentName string
+
+	// pickedUp is closed when an agent received the assignment
+	pickedUp chan struct{}
+	result   chan Result
+	lastSeen time.Time
+
+	// outputMx guards writing the output and closing the writers
+	outputMx sync.Mutex
+	closed   bool
+	stdout   io.WriteCloser
+	stderr   io.WriteCloser
+}
+
+// Coordinator assigns tasks to agents
+type Coordinator struct {
+	outputStore taskctl.OutputStore
+	timeout     time.Duration
+
+	mx sync.Mutex
+	// pending contains the assignments that were not picked up by agent name
+	pending map[string][]*assignment
+	// assignments contains all pending and running assignments by id
+	assignments map[string]*assignment
+	lastSeen    map[string]time.Time
+	// changed is closed and replaced when assignments are added, so polling agents are woken up
+	changed chan struct{}
+}
+
+// Opts is a coordinator configuration function.
+type Opts func(*Coordinator)
+
+// WithTimeout sets the time after which a task fails if its agent does not send a heartbeat
+func WithTimeout(timeout time.Duration) Opts {
+	return func(c *Coordinator) {
+		c.timeout = timeout
+	}
+}
+
+// NewCoordinator creates a coordinator that stores the output of assigned tasks in the output store
+func NewCoordinator(outputStore taskctl.OutputStore, opts ...Opts) *Coordinator {
+	c := &Coordinator{
+		outputStore: outputStore,
+		timeout:     DefaultTimeout,
+		pending:     make(map[string][]*assignment),
+		assignments: make(map[string]*assignment),
+		lastSeen:    make(map[string]time.Time),
+		changed:     make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// Dispatch assigns the task to an agent with the name and waits until the agent reported the result.
+// The onPickUp function is called when an agent received the assignment. If the context is done, the assignment is
+// canceled and the agent stops the task with its next heartbeat.
+func (c *Coordinator) Dispatch(ctx context.Context, agentName string, a Assignment, onPickUp func()) (Result, error) {
+	a.ID = uuid.Must(uuid.NewV4()).String()
+
+	stdout, err := c.outputStore.Writer(a.JobID, a.Task, "stdout")
+	if err != nil {
+		return Result{}, err
+	}
+	stderr, err := c.outputStore.Writer(a.JobID, a.Task, "stderr")
+	if err != nil {
+		stdout.Close()
+		return Result{}, err
+	}
+
+	as := &assignment{
+		Assignment: a,
+		agentName:  agentName,
+		pickedUp:   make(chan struct{}),
+		result:     make(chan Result, 1),
+		stdout:     stdout,
+		stderr:     stderr,
+	}
+	defer as.closeOutput()
+
+	c.mx.Lock()
+	c.assignments[a.ID] = as
+	c.pending[agentName] = append(c.pending[agentName], as)
+	c.notifyChanged()
+	c.mx.Unlock()
+	defer c.remove(as)
+
+	log.
+		WithField("component", "agent").
+		WithField("jobID", a.JobID).
+		WithField("agent", agentName).
+		Debugf("Assigned task %s to agent", a.Task)
+
+	pickedUp := as.pickedUp
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case result := <-as.result:
+			return result, nil
+		case <-pickedUp:
+			// The channel is closed, so it is only selected once
+			pickedUp = nil
+			if onPickUp != nil {
+				onPickUp()
+			}
+		case <-ticker.C:
+			c.mx.Lock()
+			lost := !as.lastSeen.IsZero() && time.Since(as.lastSeen) > c.timeout
+			c.mx.Unlock()
+			if lost {
+				return Result{}, errors.Errorf("agent %s did not send a heartbeat for %s", agentName, c.timeout)
+			}
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+	}
+}
+
+// Poll returns the next assignment for an agent with the name, it waits until an assignment is available or the
+// context is done (nil is returned in that case)
+func (c *Coordinator) Poll(ctx context.Context, agentName string) *Assignment {
+	for {
+		c.mx.Lock()
+		c.lastSeen[agentName] = time.Now()
+		if pending := c.pending[agentName]; len(pending) > 0 {
+			as := pending[0]
+			c.pending[agentName] = pending[1:]
+			as.lastSeen = time.Now()
+			close(as.pickedUp)
+			c.mx.Unlock()
+
+			a := as.Assignment
+			return &a
+		}
+		changed := c.changed
+		c.mx.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Heartbeat marks the agent of a running assignment as alive and appends the output of the task
+func (c *Coordinator) Heartbeat(id string, stdout, stderr []byte) error {
+	c.mx.Lock()
+	as, ok := c.assignments[id]
+	if ok {
+		as.lastSeen = time.Now()
+		c.lastSeen[as.agentName] = as.lastSeen
+	}
+	c.mx.Unlock()
+	if !ok {
+		return ErrAssignmentNotFound
+	}
+
+	return as.writeOutput(stdout, stderr)
+}
+
+// Complete reports the result of an assignment
+func (c *Coordinator) Complete(id string, result Result) error {
+	c.mx.Lock()
+	as, ok := c.assignments[id]
+	c.mx.Unlock()
+	if !ok {
+		return ErrAssignmentNotFound
+	}
+
+	select {
+	case as.result <- result:
+	default:
+		// The result was already reported
+	}
+	return nil
+}
+
+// Agents returns all agents that polled the coordinator or have pending assignments, sorted by name
+func (c *Coordinator) Agents() []Info {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	infos := make(map[string]*Info)
+	info := func(name string) *Info {
+		if _, exists := infos[name]; !exists {
+			infos[name] = &Info{Name: name, LastSeen: c.lastSeen[name]}
+		}
+		return infos[name]
+	}
+	for name := range c.lastSeen {
+		info(name)
+	}
+	for _, as := range c.assignments {
+		if isClosed(as.pickedUp) {
+			info(as.agentName).Running++
+		} else {
+			info(as.agentName).Pending++
+		}
+	}
+
+	result := make([]Info, 0, len(infos))
+	for _, i := range infos {
+		result = append(result, *i)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (c *Coordinator) remove(as *assignment) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	delete(c.assignments, as.ID)
+	pending := c.pending[as.agentName]
+	for i, p := range pending {
+		if p == as {
+			c.pending[as.agentName] = append(pending[:i:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(c.pending[as.agentName]) == 0 {
+		delete(c.pending, as.agentName)
+	}
+}
+
+// notifyChanged wakes up polling agents, the lock must be held
+func (c *Coordinator) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (as *assignment) writeOutput(stdout, stderr []byte) error {
+	as.outputMx.Lock()
+	defer as.outputMx.Unlock()
+
+	if as.closed {
+		return ErrAssignmentNotFound
+	}
+	if len(stdout) > 0 {
+		if _, err := as.stdout.Write(stdout); err != nil {
+			return errors.Wrap(err, "writing stdout")
+		}
+	}
+	if len(stderr) > 0 {
+		if _, err := as.stderr.Write(stderr); err != nil {
+			return errors.Wrap(err, "writing stderr")
+		}
+	}
+	return nil
+}
+
+func (as *assignment) closeOutput() {
+	as.outputMx.Lock()
+	defer as.outputMx.Unlock()
+
+	as.closed = true
+	as.stdout.Close()
+	as.stderr.Close()
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
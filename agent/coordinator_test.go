@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner/test"
+)
+
+func TestCoordinator_Dispatch(t *testing.T) {
+	outputStore := test.NewMockOutputStore()
+	c := NewCoordinator(outputStore)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type dispatchResult struct {
+		result Result
+		err    error
+	}
+	pickedUp := make(chan struct{})
+	done := make(chan dispatchResult, 1)
+	go func() {
+		result, err := c.Dispatch(ctx, "web", Assignment{JobID: "job-1", Task: "deploy"}, func() {
+			close(pickedUp)
+		})
+		done <- dispatchResult{result, err}
+	}()
+
+	assignment := c.Poll(ctx, "web")
+	require.NotNil(t, assignment)
+	assert.Equal(t, "deploy", assignment.Task)
+	<-pickedUp
+
+	assert.Equal(t, []Info{{Name: "web", LastSeen: c.Agents()[0].LastSeen, Running: 1}}, c.Agents())
+
+	require.NoError(t, c.Heartbeat(assignment.ID, []byte("deployed\n"), nil))
+	require.NoError(t, c.Complete(assignment.ID, Result{ExitCode: 0}))
+
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, Result{ExitCode: 0}, res.result)
+	assert.Equal(t, "deployed\n", string(outputStore.GetBytes("job-1", "deploy", "stdout")))
+
+	// The assignment is removed after the result
+	assert.ErrorIs(t, c.Heartbeat(assignment.ID, nil, nil), ErrAssignmentNotFound)
+}
+
+func TestCoordinator_DispatchCanceled(t *testing.T) {
+	c := NewCoordinator(test.NewMockOutputStore())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Dispatch(ctx, "web", Assignment{JobID: "job-1", Task: "deploy"}, nil)
+		done <- err
+	}()
+
+	test.WaitForCondition(t, func() bool {
+		agents := c.Agents()
+		return len(agents) == 1 && agents[0].Pending == 1
+	}, 10*time.Millisecond, "assignment is pending")
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// Canceled assignments are not picked up
+	pollCtx, pollCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer pollCancel()
+	assert.Nil(t, c.Poll(pollCtx, "web"))
+}
+
+func TestCoordinator_DispatchAgentLost(t *testing.T) {
+	c := NewCoordinator(test.NewMockOutputStore(), WithTimeout(100*time.Millisecond))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Dispatch(context.Background(), "web", Assignment{JobID: "job-1", Task: "deploy"}, nil)
+		done <- err
+	}()
+
+	assignment := c.Poll(context.Background(), "web")
+	require.NotNil(t, assignment)
+
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "agent web did not send a heartbeat for 100ms")
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatch did not fail without heartbeats")
+	}
+}
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/taskctl/taskctl/pkg/task"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// WrapTaskRunner returns a task runner for prunner.NewPipelineRunner that dispatches tasks with an agent to the
+// coordinator, all other tasks are run by the given task runner. The agents of tasks are taken from the context of the
+// job, so JobContext must be passed to the pipeline runner with prunner.WithJobContext.
+func (c *Coordinator) WrapTaskRunner(localRunner taskctl.Runner) taskctl.Runner {
+	return &remoteRunner{
+		Runner:      localRunner,
+		coordinator: c,
+	}
+}
+
+// JobContext adds the agents of the tasks of a job to the context of the job (see prunner.WithJobContext)
+func (c *Coordinator) JobContext(ctx context.Context, j *prunner.PipelineJob) context.Context {
+	agents := make(map[string]string)
+	for _, t := range j.Tasks {
+		if t.Agent != "" {
+			agents[t.Name] = t.Agent
+		}
+	}
+	if len(agents) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, jobAgentsKey{}, jobAgents{
+		pipeline: j.Pipeline,
+		agents:   agents,
+	})
+}
+
+type jobAgentsKey struct{}
+
+type jobAgents struct {
+	pipeline string
+	// agents contains the agent name by task name
+	agents map[string]string
+}
+
+// remoteRunner dispatches tasks with an agent to the coordinator, it implements taskctl.Runner
+type remoteRunner struct {
+	taskctl.Runner
+
+	coordinator *Coordinator
+
+	onTaskChange func(t *task.Task)
+}
+
+var _ taskctl.Runner = &remoteRunner{}
+var _ taskctl.LiveOutput = &remoteRunner{}
+var _ taskctl.TaskInput = &remoteRunner{}
+var _ taskctl.OutputStream = &remoteRunner{}
+var _ taskctl.ServiceRunner = &remoteRunner{}
+
+func (r *remoteRunner) SetOnTaskChange(f func(t *task.Task)) {
+	r.onTaskChange = f
+	r.Runner.SetOnTaskChange(f)
+}
+
+// CurrentTaskOutput returns the live output of tasks that are run by the local task runner, tasks of agents have no
+// live output
+func (r *remoteRunner) CurrentTaskOutput(jobID, taskName, outputName string) ([]string, bool) {
+	liveOutput, ok := r.Runner.(taskctl.LiveOutput)
+	if !ok {
+		return nil, false
+	}
+	return liveOutput.CurrentTaskOutput(jobID, taskName, outputName)
+}
+
+// WriteTaskInput writes to the stdin of interactive tasks of the local task runner (tasks of agents cannot be
+// interactive)
+func (r *remoteRunner) WriteTaskInput(jobID, taskName string, data []byte) error {
+	taskInput, ok := r.Runner.(taskctl.TaskInput)
+	if !ok {
+		return taskctl.ErrNoTaskInput
+	}
+	return taskInput.WriteTaskInput(jobID, taskName, data)
+}
+
+// CloseTaskInput closes the stdin of interactive tasks of the local task runner
+func (r *remoteRunner) CloseTaskInput(jobID, taskName string) error {
+	taskInput, ok := r.Runner.(taskctl.TaskInput)
+	if !ok {
+		return taskctl.ErrNoTaskInput
+	}
+	return taskInput.CloseTaskInput(jobID, taskName)
+}
+
+// SubscribeTaskOutput streams the output of tasks of the local task runner, the output of tasks of agents is only
+// available in the output store
+func (r *remoteRunner) SubscribeTaskOutput(jobID, taskName string) (<-chan taskctl.OutputChunk, func(), error) {
+	outputStream, ok := r.Runner.(taskctl.OutputStream)
+	if !ok {
+		return nil, nil, taskctl.ErrTaskNotRunning
+	}
+	return outputStream.SubscribeTaskOutput(jobID, taskName)
+}
+
+// StartService starts services with the local task runner, services always run on the host of prunner
+func (r *remoteRunner) StartService(ctx context.Context, jobID string, s taskctl.Service, vars map[string]interface{}, priority taskctl.ProcessPriority) (*taskctl.RunningService, error) {
+	serviceRunner, ok := r.Runner.(taskctl.ServiceRunner)
+	if !ok {
+		return nil, errors.New("task runner cannot start services")
+	}
+	return serviceRunner.StartService(ctx, jobID, s, vars, priority)
+}
+
+// Run dispatches the task to its agent or runs it with the local task runner
+func (r *remoteRunner) Run(ctx context.Context, t *task.Task) error {
+	job, _ := ctx.Value(jobAgentsKey{}).(jobAgents)
+	agentName, ok := job.agents[t.Name]
+	if !ok {
+		return r.Runner.Run(ctx, t)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	jobOpts := taskctl.JobOptionsFromContext(ctx)
+	env, err := taskEnv(jobOpts, t)
+	if err != nil {
+		t.Errored = true
+		t.Error = errors.Wrap(err, "rendering env")
+		r.notifyTaskChange(t)
+		return t.Error
+	}
+	a := Assignment{
+		JobID:        t.Variables.Get(taskctl.JobIDVariableName).(string),
+		Pipeline:     job.pipeline,
+		Task:         t.Name,
+		Script:       t.Commands,
+		Variables:    t.Variables.Map(),
+		Env:          env,
+		Dir:          t.Dir,
+		AllowFailure: t.AllowFailure,
+		Retries:      jobOpts.Retries[t.Name],
+		Interpreter:  jobOpts.Interpreters[t.Name],
+		Priority:     jobOpts.ProcessPriority,
+	}
+	if t.Timeout != nil {
+		a.Timeout = *t.Timeout
+	}
+	if noOutput, ok := jobOpts.NoOutput[t.Name]; ok {
+		a.NoOutput = &noOutput
+	}
+	if expr, ok := jobOpts.Conditions[t.Name]; ok {
+		a.When = expr.String()
+	}
+	if changedPaths, ok := jobOpts.ChangedPaths[t.Name]; ok {
+		a.ChangedPaths = &changedPaths
+	}
+
+	result, err := r.coordinator.Dispatch(ctx, agentName, a, func() {
+		t.Start = time.Now()
+		r.notifyTaskChange(t)
+	})
+	if err != nil {
+		t.Errored = true
+		t.Error = err
+		r.notifyTaskChange(t)
+		return t.Error
+	}
+
+	t.ExitCode = result.ExitCode
+	if result.Skipped {
+		t.Skipped = true
+		return nil
+	}
+	if result.Errored {
+		t.Errored = true
+		t.Error = errors.New(result.Error)
+		r.notifyTaskChange(t)
+		return t.Error
+	}
+
+	t.End = time.Now()
+	r.notifyTaskChange(t)
+
+	return nil
+}
+
+// taskEnv merges the job env with the env of the task, templates in the task env are rendered by the coordinator
+// since the agent does not know the job
+func taskEnv(jobOpts taskctl.JobOptions, t *task.Task) (map[string]string, error) {
+	env := make(map[string]string, len(jobOpts.Env))
+	for k, v := range jobOpts.Env {
+		env[k] = v
+	}
+	if t.Env != nil {
+		rendered, err := taskctl.RenderEnv(t.Env.Map(), jobOpts.EnvTemplateData)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range rendered {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+func (r *remoteRunner) notifyTaskChange(t *task.Task) {
+	if r.onTaskChange != nil {
+		r.onTaskChange(t)
+	}
+}
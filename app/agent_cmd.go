@@ -0,0 +1,101 @@
+package app
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/urfave/cli/v2"
+
+	"github.com/Flowpack/prunner/agent"
+	"github.com/Flowpack/prunner/server"
+)
+
+func newAgentCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "agent",
+		Usage: "Run tasks with a matching agent in the definition for a coordinating prunner process",
+		Description: "The agent polls the HTTP API of the coordinator for tasks and runs them on this host, " +
+			"the output of tasks is sent to the coordinator. " +
+			"Without a token, a token is generated with the JWT secret of the config (which must match the coordinator).",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "coordinator",
+				Usage:    "Base URL of the HTTP API of the coordinating prunner process (e.g. http://prunner.local:9009)",
+				Required: true,
+				EnvVars:  []string{"PRUNNER_AGENT_COORDINATOR"},
+			},
+			&cli.StringFlag{
+				Name:    "name",
+				Usage:   "Name of the agent as used in task definitions, defaults to the hostname",
+				EnvVars: []string{"PRUNNER_AGENT_NAME"},
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "JWT for the coordinator with the agent scope",
+				EnvVars: []string{"PRUNNER_AGENT_TOKEN"},
+			},
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Usage:   "Number of tasks that are run concurrently",
+				Value:   1,
+				EnvVars: []string{"PRUNNER_AGENT_CONCURRENCY"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			name := c.String("name")
+			if name == "" {
+				hostname, err := os.Hostname()
+				if err != nil {
+					return errors.Wrap(err, "getting hostname for agent name")
+				}
+				name = hostname
+			}
+
+			token := c.String("token")
+			if token == "" {
+				conf, err := loadConfig(c)
+				if err != nil {
+					return err
+				}
+
+				tokenAuth := jwtauth.New("HS256", []byte(conf.JWTSecret), nil)
+				claims := map[string]interface{}{
+					"sub":   "agent:" + name,
+					"scope": server.ScopeAgent,
+				}
+				jwtauth.SetIssuedNow(claims)
+				_, token, err = tokenAuth.Encode(claims)
+				if err != nil {
+					return errors.Wrap(err, "generating token")
+				}
+			}
+
+			a, err := agent.NewAgent(
+				c.String("coordinator"),
+				name,
+				token,
+				agent.WithConcurrency(c.Int("concurrency")),
+			)
+			if err != nil {
+				return err
+			}
+
+			// Running tasks are canceled on shutdown
+			ctx, cancel := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			log.
+				WithField("coordinator", c.String("coordinator")).
+				WithField("agent", name).
+				Info("Agent started, waiting for tasks")
+
+			a.Run(ctx)
+
+			return nil
+		},
+	}
+}
@@ -3,16 +3,16 @@ package app
 import (
 	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/apex/log"
+	json_handler "github.com/apex/log/handlers/json"
 	logfmt_handler "github.com/apex/log/handlers/logfmt"
 	text_handler "github.com/apex/log/handlers/text"
 	"github.com/friendsofgo/errors"
@@ -20,13 +20,23 @@ import (
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/joho/godotenv"
 	"github.com/mattn/go-isatty"
-	"github.com/taskctl/taskctl/pkg/variables"
 	"github.com/urfave/cli/v2"
 
 	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/agent"
+	"github.com/Flowpack/prunner/archive"
 	"github.com/Flowpack/prunner/config"
 	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/exechook"
+	"github.com/Flowpack/prunner/hoststat"
+	"github.com/Flowpack/prunner/natspub"
+	"github.com/Flowpack/prunner/redistrigger"
+	"github.com/Flowpack/prunner/remotedefs"
+	"github.com/Flowpack/prunner/sdnotify"
+	"github.com/Flowpack/prunner/sentry"
 	"github.com/Flowpack/prunner/server"
+	"github.com/Flowpack/prunner/sharedstate"
+	"github.com/Flowpack/prunner/statsd"
 	"github.com/Flowpack/prunner/store"
 	"github.com/Flowpack/prunner/taskctl"
 )
@@ -37,26 +47,49 @@ func New(info Info) *cli.App {
 	app.Usage = "Pipeline runner"
 
 	app.Before = func(c *cli.Context) error {
-		setLogHandler(c)
+		err := setLogHandler(c)
+		if err != nil {
+			return err
+		}
 		log.
 			WithField("version", info.Version).
 			Debug("Starting Prunner")
-		err := loadDotenv(c)
+		envBeforeLoading := lookupFlagEnv(c.App.Flags)
+		err = loadDotenv(c)
 		if err != nil {
 			return err
 		}
-
-		return nil
+		err = applyEnvFilesToFlags(c, envBeforeLoading)
+		if err != nil {
+			return err
+		}
+		err = applyConfigFileToFlags(c)
+		if err != nil {
+			return err
+		}
+		// Log settings could have been changed by env files or the config file
+		return setLogHandler(c)
 	}
 	app.Action = appAction
 	app.Flags = []cli.Flag{
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Aliases: []string{"v"},
-			Usage:   "Enable verbose log output",
+			Usage:   "Enable verbose log output (same as --log-level debug)",
 			Value:   false,
 			EnvVars: []string{"PRUNNER_VERBOSE"},
 		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   "Minimum level of log output (debug, info, warn or error)",
+			Value:   "info",
+			EnvVars: []string{"PRUNNER_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Format of log output (text, logfmt or json), defaults to text on a terminal and logfmt otherwise",
+			EnvVars: []string{"PRUNNER_LOG_FORMAT"},
+		},
 		&cli.BoolFlag{
 			Name:    "enable-profiling",
 			Usage:   "Enable the Profiling endpoints underneath /debug/pprof",
@@ -71,7 +104,7 @@ func New(info Info) *cli.App {
 		},
 		&cli.StringFlag{
 			Name:    "config",
-			Usage:   "Dynamic config filename (will be created on first run if jwt-secret is not set)",
+			Usage:   "Config filename with the JWT secret and optional server settings (will be created on first run if jwt-secret is not set)",
 			Value:   ".prunner.yml",
 			EnvVars: []string{"PRUNNER_CONFIG"},
 		},
@@ -80,6 +113,11 @@ func New(info Info) *cli.App {
 			Usage:   "Pre-generated shared secret for JWT authentication (at least 16 characters)",
 			EnvVars: []string{"PRUNNER_JWT_SECRET"},
 		},
+		&cli.StringFlag{
+			Name:    "pid-file",
+			Usage:   "Write the process id to this file, prunner refuses to start if it contains the id of a running process",
+			EnvVars: []string{"PRUNNER_PID_FILE"},
+		},
 		&cli.StringFlag{
 			Name:    "data",
 			Usage:   "Base directory to use for storing data (metadata and job outputs)",
@@ -94,16 +132,60 @@ func New(info Info) *cli.App {
 		},
 		&cli.StringFlag{
 			Name:    "path",
-			Usage:   "Base directory to use for pipeline configuration scan",
+			Usage:   "Base directory to use for pipeline configuration scan, multiple directories can be separated by comma (pipelines of later directories replace pipelines with the same name)",
 			Value:   ".",
 			EnvVars: []string{"PRUNNER_PATH"},
 		},
+		&cli.StringFlag{
+			Name:    "duplicate-pipelines",
+			Usage:   "Policy for a pipeline name declared in more than one file: error, first_wins or override (a later declaration with override: true replaces the pipeline)",
+			Value:   "error",
+			EnvVars: []string{"PRUNNER_DUPLICATE_PIPELINES"},
+		},
+		&cli.StringFlag{
+			Name:    "definitions-url",
+			Usage:   "Fetch a signed bundle (tar.gz) of pipeline definitions from this URL, pipelines of local paths replace pipelines with the same name",
+			EnvVars: []string{"PRUNNER_DEFINITIONS_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "definitions-public-key",
+			Usage:   "Base64 encoded ed25519 public key for verifying the signature of the definitions bundle (required if definitions-url is set)",
+			EnvVars: []string{"PRUNNER_DEFINITIONS_PUBLIC_KEY"},
+		},
+		&cli.DurationFlag{
+			Name:    "definitions-poll-interval",
+			Usage:   "Poll interval for changes of the definitions bundle (if definitions-url is set)",
+			Value:   time.Minute,
+			EnvVars: []string{"PRUNNER_DEFINITIONS_POLL_INTERVAL"},
+		},
 		&cli.StringFlag{
 			Name:    "address",
-			Usage:   "Listen address for HTTP API",
+			Usage:   "Listen address for HTTP API (host:port or unix:/path/to/socket), multiple addresses can be separated by comma",
 			Value:   "localhost:9009",
 			EnvVars: []string{"PRUNNER_ADDRESS"},
 		},
+		&cli.StringFlag{
+			Name:    "admin-address",
+			Usage:   "Separate listen address for admin endpoints and profiling (host:port or unix:/path/to/socket), multiple addresses can be separated by comma. If set, these endpoints are not served on the HTTP API address",
+			EnvVars: []string{"PRUNNER_ADMIN_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:    "admin-scope",
+			Usage:   "Scope that is required in the JWT for all requests to the admin address",
+			EnvVars: []string{"PRUNNER_ADMIN_SCOPE"},
+		},
+		&cli.IntFlag{
+			Name:    "compression-level",
+			Usage:   "Level (1-9) for compressing API responses with gzip or deflate if accepted by the client, 0 disables compression",
+			Value:   server.DefaultCompressionLevel,
+			EnvVars: []string{"PRUNNER_COMPRESSION_LEVEL"},
+		},
+		&cli.Int64Flag{
+			Name:    "max-body-size",
+			Usage:   "Maximum size of JSON request bodies in bytes, larger requests are rejected",
+			Value:   server.DefaultMaxBodySize,
+			EnvVars: []string{"PRUNNER_MAX_BODY_SIZE"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "env-files",
 			Usage:   "Filenames with environment variables to load (dotenv style), will override existing env vars, set empty to skip loading",
@@ -121,10 +203,209 @@ func New(info Info) *cli.App {
 			Value:   30 * time.Second,
 			EnvVars: []string{"PRUNNER_POLL_INTERVAL"},
 		},
+		&cli.Float64Flag{
+			Name:    "max-load-average",
+			Usage:   "Defer starting jobs while the 1-minute load average of the host exceeds this value (0 to disable)",
+			EnvVars: []string{"PRUNNER_MAX_LOAD_AVERAGE"},
+		},
+		&cli.Float64Flag{
+			Name:    "max-memory-usage",
+			Usage:   "Defer starting jobs while the used memory of the host exceeds this percentage (0 to disable)",
+			EnvVars: []string{"PRUNNER_MAX_MEMORY_USAGE"},
+		},
+		&cli.Float64Flag{
+			Name:    "max-memory-pressure",
+			Usage:   "Defer starting jobs while the memory pressure (PSI some avg10) of the host exceeds this percentage (0 to disable)",
+			EnvVars: []string{"PRUNNER_MAX_MEMORY_PRESSURE"},
+		},
+		&cli.Float64Flag{
+			Name:    "max-cpu-usage",
+			Usage:   "Defer starting jobs while the CPU usage of the host exceeds this percentage (0 to disable)",
+			EnvVars: []string{"PRUNNER_MAX_CPU_USAGE"},
+		},
+		&cli.Uint64Flag{
+			Name:    "min-free-disk-space",
+			Usage:   "Refuse to start jobs while the available disk space of the data directory is below this value in MiB (0 to disable)",
+			EnvVars: []string{"PRUNNER_MIN_FREE_DISK_SPACE"},
+		},
+		&cli.DurationFlag{
+			Name:    "load-check-interval",
+			Usage:   "Interval for checking the host load and disk space and starting deferred jobs (if a limit is set)",
+			Value:   10 * time.Second,
+			EnvVars: []string{"PRUNNER_LOAD_CHECK_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "persist-interval",
+			Usage:   "Minimum interval between saves of the job state after changes",
+			Value:   3 * time.Second,
+			EnvVars: []string{"PRUNNER_PERSIST_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:    "flush-on-completion",
+			Usage:   "Save the job state immediately when a job is completed",
+			EnvVars: []string{"PRUNNER_FLUSH_ON_COMPLETION"},
+		},
+		&cli.IntFlag{
+			Name:    "max-cached-jobs",
+			Usage:   "Maximum number of jobs kept in memory, older finished jobs are loaded from the data directory on demand (0 keeps all jobs)",
+			EnvVars: []string{"PRUNNER_MAX_CACHED_JOBS"},
+		},
+		&cli.IntFlag{
+			Name:    "workers",
+			Usage:   "Maximum total weight of concurrently executing tasks of all jobs, a task uses the weight of its pipeline (0 for no limit)",
+			EnvVars: []string{"PRUNNER_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "live-output-lines",
+			Usage:   "Number of last lines of stdout and stderr of running tasks that are kept in memory for the live output (0 to disable)",
+			Value:   1000,
+			EnvVars: []string{"PRUNNER_LIVE_OUTPUT_LINES"},
+		},
+		&cli.StringFlag{
+			Name:    "timezone",
+			Usage:   "Default IANA timezone for schedule windows of pipelines without a timezone (defaults to the local timezone)",
+			EnvVars: []string{"PRUNNER_TIMEZONE"},
+		},
+		&cli.StringFlag{
+			Name:    "on-schedule-hook",
+			Usage:   "Command that gets the schedule request as JSON on stdin before a job is scheduled, the job is rejected if it fails",
+			EnvVars: []string{"PRUNNER_ON_SCHEDULE_HOOK"},
+		},
+		&cli.StringFlag{
+			Name:    "on-complete-hook",
+			Usage:   "Command that gets the job as JSON on stdin after a job is finished",
+			EnvVars: []string{"PRUNNER_ON_COMPLETE_HOOK"},
+		},
+		&cli.DurationFlag{
+			Name:    "hook-timeout",
+			Usage:   "Timeout for the on-schedule and on-complete hook commands",
+			Value:   30 * time.Second,
+			EnvVars: []string{"PRUNNER_HOOK_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:    "hook-retries",
+			Usage:   "Number of retries of a failed on-complete hook before the notification is added to the dead letters",
+			Value:   3,
+			EnvVars: []string{"PRUNNER_HOOK_RETRIES"},
+		},
+		&cli.DurationFlag{
+			Name:    "hook-retry-delay",
+			Usage:   "Delay before the first retry of a failed on-complete hook, doubled for each further retry",
+			Value:   2 * time.Second,
+			EnvVars: []string{"PRUNNER_HOOK_RETRY_DELAY"},
+		},
+		&cli.StringFlag{
+			Name:    "on-alert-hook",
+			Usage:   "Command that gets an alert of a pipeline as JSON on stdin when it is raised or resolved",
+			EnvVars: []string{"PRUNNER_ON_ALERT_HOOK"},
+		},
+		&cli.DurationFlag{
+			Name:    "alert-check-interval",
+			Usage:   "Interval for checking the alerts of pipelines",
+			Value:   time.Minute,
+			EnvVars: []string{"PRUNNER_ALERT_CHECK_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "archive",
+			Usage:   "Move old finished jobs with their logs to this directory or S3 bucket (s3://bucket[/prefix])",
+			EnvVars: []string{"PRUNNER_ARCHIVE"},
+		},
+		&cli.DurationFlag{
+			Name:    "archive-after",
+			Usage:   "Age of finished jobs (since they ended) before they are archived",
+			Value:   30 * 24 * time.Hour,
+			EnvVars: []string{"PRUNNER_ARCHIVE_AFTER"},
+		},
+		&cli.DurationFlag{
+			Name:    "archive-check-interval",
+			Usage:   "Interval for archiving old finished jobs",
+			Value:   time.Hour,
+			EnvVars: []string{"PRUNNER_ARCHIVE_CHECK_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "nats-url",
+			Usage:   "Publish job and task events to this NATS server (nats://[user:password@]host[:port])",
+			EnvVars: []string{"PRUNNER_NATS_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "nats-subject-prefix",
+			Usage:   "Prefix of the subjects for published events (e.g. prunner.job.completed)",
+			Value:   natspub.DefaultPrefix,
+			EnvVars: []string{"PRUNNER_NATS_SUBJECT_PREFIX"},
+		},
+		&cli.StringFlag{
+			Name:    "statsd-address",
+			Usage:   "Send job and task metrics to this StatsD server (host:port), e.g. a Datadog agent",
+			EnvVars: []string{"PRUNNER_STATSD_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:    "statsd-prefix",
+			Usage:   "Prefix of the names of sent metrics (e.g. prunner.job.duration)",
+			Value:   statsd.DefaultPrefix,
+			EnvVars: []string{"PRUNNER_STATSD_PREFIX"},
+		},
+		&cli.StringFlag{
+			Name:    "statsd-tags",
+			Usage:   "Comma separated tags (name:value) added to all sent metrics, e.g. env:production,service:ci",
+			EnvVars: []string{"PRUNNER_STATSD_TAGS"},
+		},
+		&cli.StringFlag{
+			Name:    "sentry-dsn",
+			Usage:   "Report errors to Sentry with this DSN (https://<key>@<host>/<project>)",
+			EnvVars: []string{"PRUNNER_SENTRY_DSN"},
+		},
+		&cli.StringFlag{
+			Name:    "sentry-environment",
+			Usage:   "Environment of events reported to Sentry (e.g. production)",
+			EnvVars: []string{"PRUNNER_SENTRY_ENVIRONMENT"},
+		},
+		&cli.BoolFlag{
+			Name:    "sentry-task-failures",
+			Usage:   "Also report failed tasks with the last lines of their output to Sentry",
+			EnvVars: []string{"PRUNNER_SENTRY_TASK_FAILURES"},
+		},
+		&cli.IntFlag{
+			Name:    "sentry-output-lines",
+			Usage:   "Number of last lines of the output of a failed task that are reported to Sentry",
+			Value:   50,
+			EnvVars: []string{"PRUNNER_SENTRY_OUTPUT_LINES"},
+		},
+		&cli.StringFlag{
+			Name:    "redis-url",
+			Usage:   "Schedule jobs from requests in a Redis list on this server (redis://[:password@]host[:port][/db])",
+			EnvVars: []string{"PRUNNER_REDIS_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "redis-queue",
+			Usage:   "Key of the Redis list with schedule requests",
+			Value:   "prunner:schedule",
+			EnvVars: []string{"PRUNNER_REDIS_QUEUE"},
+		},
+		&cli.StringFlag{
+			Name:    "shared-state-url",
+			Usage:   "Share the concurrency of pipelines with other instances using this Redis server (redis://[:password@]host[:port][/db])",
+			EnvVars: []string{"PRUNNER_SHARED_STATE_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "shared-state-prefix",
+			Usage:   "Prefix of the keys for shared state, instances with the same prefix share pipelines",
+			Value:   "prunner:",
+			EnvVars: []string{"PRUNNER_SHARED_STATE_PREFIX"},
+		},
+		&cli.StringFlag{
+			Name:    "instance-id",
+			Usage:   "Unique ID of this instance for shared state (defaults to the hostname)",
+			EnvVars: []string{"PRUNNER_INSTANCE_ID"},
+		},
 	}
 
 	app.Commands = []*cli.Command{
 		newDebugCmd(),
+		newBackupCmd(),
+		newRestoreCmd(),
+		newCompactCmd(),
+		newLintCmd(),
+		newAgentCmd(),
 		{
 			Name:  "version",
 			Usage: "Print the current version",
@@ -180,11 +461,69 @@ func appAction(c *cli.Context) error {
 		return err
 	}
 
+	sentryClient, err := useSentry(c)
+	if err != nil {
+		return err
+	}
+	if sentryClient != nil {
+		defer func() {
+			err := sentryClient.Close(5 * time.Second)
+			if err != nil {
+				log.
+					WithError(err).
+					Warn("Error closing Sentry client")
+			}
+		}()
+	}
+
+	compressionLevel := c.Int("compression-level")
+	if compressionLevel < 0 || compressionLevel > 9 {
+		return errors.Errorf("invalid compression level %d: must be between 0 and 9", compressionLevel)
+	}
+
+	maxBodySize := c.Int64("max-body-size")
+	if maxBodySize <= 0 {
+		return errors.Errorf("invalid max body size %d: must be positive", maxBodySize)
+	}
+
+	// Tickers panic for intervals that are not positive
+	for _, name := range []string{"poll-interval", "definitions-poll-interval"} {
+		if interval := c.Duration(name); interval <= 0 {
+			return errors.Errorf("invalid %s %s: must be positive", name, interval)
+		}
+	}
+
 	tokenAuth := jwtauth.New("HS256", []byte(conf.JWTSecret), nil)
 
+	// Listeners of a previous process if it hands off to this process (see handOff)
+	inherited, err := takeOverFromEnv()
+	if err != nil {
+		return err
+	}
+
+	pidFile := c.String("pid-file")
+	if pidFile != "" {
+		err := writePIDFile(pidFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := removePIDFile(pidFile); err != nil {
+				log.
+					WithError(err).
+					Warnf("Error removing PID file %s", pidFile)
+			}
+		}()
+	}
+
 	// Load declared pipelines recursively
 
-	defs, err := definition.LoadRecursively(filepath.Join(c.String("path"), c.String("pattern")))
+	remoteDefs, err := useRemoteDefinitions(c)
+	if err != nil {
+		return err
+	}
+
+	defs, err := loadDefinitions(c)
 	if err != nil {
 		return errors.Wrap(err, "loading definitions")
 	}
@@ -195,19 +534,22 @@ func appAction(c *cli.Context) error {
 
 	// TODO Handle signal USR1 for reloading config
 
-	outputStore, err := taskctl.NewOutputStore(path.Join(c.String("data"), "logs"))
+	outputStore, err := taskctl.NewOutputStore(filepath.Join(c.String("data"), "logs"))
 	if err != nil {
 		return errors.Wrap(err, "building output store")
 	}
 
-	dataStore, err := store.NewJSONDataStore(path.Join(c.String("data")))
+	dataStore, err := store.NewJSONDataStore(c.String("data"))
 	if err != nil {
 		return errors.Wrap(err, "building pipeline runner store")
 	}
 
 	// How signals are handled:
-	// - SIGINT: Shutdown gracefully and wait for jobs to be finished completely
+	// - SIGINT: Shutdown gracefully and wait for jobs to be finished completely, a second SIGINT cancels running jobs
 	// - SIGTERM: Cancel running jobs
+	// - SIGUSR1: Reload pipeline definitions (see handleDefinitionChanges)
+	// - SIGUSR2: Dump the runner state and goroutines to the log (see handleDumpSignal)
+	// - SIGHUP: Hand off to a new process after running jobs are finished (see handOff)
 
 	gracefulShutdownCtx, gracefulCancel := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
 	defer gracefulCancel()
@@ -215,64 +557,225 @@ func appAction(c *cli.Context) error {
 	forcedShutdownCtx, forcedCancel := signal.NotifyContext(c.Context, syscall.SIGTERM)
 	defer forcedCancel()
 
-	// Set up pipeline runner
-	pRunner, err := prunner.NewPipelineRunner(gracefulShutdownCtx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		// taskctl.NewTaskRunner never actually returns an error
-		taskRunner, _ := taskctl.NewTaskRunner(outputStore, taskctl.WithEnv(variables.FromMap(j.Env)))
-
-		// Do not output task stdout / stderr to the server process. NOTE: Before/After execution logs won't be visible because of this
-		taskRunner.Stdout = io.Discard
-		taskRunner.Stderr = io.Discard
-
-		return taskRunner
-	}, dataStore, outputStore)
+	// The job state must be loaded after the previous process saved it
+	err = inherited.waitForState()
 	if err != nil {
 		return err
 	}
 
-	handleDefinitionChanges(gracefulShutdownCtx, c, pRunner, defs)
+	var taskRunnerOpts []prunner.TaskRunnerOpts
+	if workers := c.Int("workers"); workers > 0 {
+		taskRunnerOpts = append(taskRunnerOpts, prunner.WithWorkerPool(taskctl.NewWorkerPool(workers)))
+	}
+	taskRunnerOpts = append(taskRunnerOpts, prunner.WithLiveOutputLines(c.Int("live-output-lines")))
 
-	srv := server.NewServer(
-		pRunner,
+	timezone := time.Local
+	if name := c.String("timezone"); name != "" {
+		timezone, err = time.LoadLocation(name)
+		if err != nil {
+			return errors.Wrap(err, "loading timezone")
+		}
+	}
+
+	// Tasks with an agent are assigned to agents that poll the HTTP API
+	coordinator := agent.NewCoordinator(outputStore)
+
+	// Set up pipeline runner
+	pRunner, err := prunner.NewPipelineRunner(
+		gracefulShutdownCtx,
+		defs,
+		coordinator.WrapTaskRunner(prunner.NewTaskRunner(outputStore, taskRunnerOpts...)),
+		dataStore,
 		outputStore,
-		middleware.RequestLogger(createLogFormatter(c)),
-		tokenAuth,
-		c.Bool("enable-profiling"),
+		prunner.WithPersistInterval(c.Duration("persist-interval")),
+		prunner.WithFlushOnCompletion(c.Bool("flush-on-completion")),
+		prunner.WithMaxCachedJobs(c.Int("max-cached-jobs")),
+		prunner.WithJobContext(coordinator.JobContext),
+		prunner.WithTimezone(timezone),
 	)
+	if err != nil {
+		return err
+	}
 
-	// Set up a simple REST API for listing jobs and scheduling pipelines
-	address := c.String("address")
-	log.
-		Infof("HTTP API listening on %s", address)
+	deadLetters, err := useExecHooks(c, pRunner)
+	if err != nil {
+		return err
+	}
+	err = useAlerts(gracefulShutdownCtx, c, pRunner)
+	if err != nil {
+		return err
+	}
+	err = useArchive(gracefulShutdownCtx, c, pRunner)
+	if err != nil {
+		return err
+	}
+	natsPublisher, err := useNATSPublisher(c, pRunner)
+	if err != nil {
+		return err
+	}
+	statsdSink, err := useStatsDSink(c, pRunner)
+	if err != nil {
+		return err
+	}
+	if sentryClient != nil && c.Bool("sentry-task-failures") {
+		prunner.Subscribe(pRunner, sentryClient.TaskFailureReporter(outputStore, c.Int("sentry-output-lines")))
+	}
+	useHostLoadGate(gracefulShutdownCtx, c, pRunner)
+	useDiskSpaceGate(gracefulShutdownCtx, c, pRunner)
+	err = useSharedState(gracefulShutdownCtx, c, pRunner)
+	if err != nil {
+		return err
+	}
+	// Start jobs that were queued by a previous process after all gates are set up
+	pRunner.ResumeWaitList()
 
-	// Start http server and handle graceful shutdown
-	httpSrv := http.Server{
-		Addr:    address,
-		Handler: srv,
+	err = useRedisTrigger(gracefulShutdownCtx, c, pRunner)
+	if err != nil {
+		return err
 	}
-	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting HTTP API: %s", err)
+
+	handleDefinitionChanges(gracefulShutdownCtx, c, pRunner, defs, remoteDefs)
+	// Dumping the state is also useful while waiting for jobs on shutdown
+	handleDumpSignal(c.Context, pRunner)
+
+	requestLogger := middleware.RequestLogger(createLogFormatter(c))
+	addresses := parseAddresses(c.String("address"))
+	adminAddresses := parseAddresses(c.String("admin-address"))
+
+	// Options of all servers
+	commonOpts := []server.Opts{server.WithCompression(compressionLevel), server.WithMaxBodySize(maxBodySize)}
+	adminOpts := append([]server.Opts{server.WithDataDir(c.String("data"))}, commonOpts...)
+	if deadLetters != nil {
+		adminOpts = append(adminOpts, server.WithDeadLetters(deadLetters, c.Duration("hook-timeout")))
+	}
+
+	var httpSrvs []*httpServer
+	if len(adminAddresses) == 0 {
+		srv := server.NewServer(
+			pRunner,
+			outputStore,
+			requestLogger,
+			tokenAuth,
+			c.Bool("enable-profiling"),
+			append(adminOpts, server.WithCoordinator(coordinator))...,
+		)
+
+		// Set up a simple REST API for listing jobs and scheduling pipelines
+		httpSrv, err := serveHTTP("HTTP API", addresses, srv, inherited)
+		if err != nil {
+			return err
 		}
-	}()
+		httpSrvs = append(httpSrvs, httpSrv)
+	} else {
+		// Admin endpoints and profiling are only served on the admin addresses
+		srv := server.NewServer(
+			pRunner,
+			outputStore,
+			requestLogger,
+			tokenAuth,
+			false,
+			append(commonOpts, server.WithoutAdminEndpoints(), server.WithCoordinator(coordinator))...,
+		)
+		if scope := c.String("admin-scope"); scope != "" {
+			adminOpts = append(adminOpts, server.WithRequiredScope(scope))
+		}
+		adminSrv := server.NewServer(
+			pRunner,
+			outputStore,
+			requestLogger,
+			tokenAuth,
+			c.Bool("enable-profiling"),
+			adminOpts...,
+		)
+
+		httpSrv, err := serveHTTP("HTTP API", addresses, srv, inherited)
+		if err != nil {
+			return err
+		}
+		httpSrvs = append(httpSrvs, httpSrv)
+
+		adminHTTPSrv, err := serveHTTP("Admin HTTP API", adminAddresses, adminSrv, inherited)
+		if err != nil {
+			return err
+		}
+		httpSrvs = append(httpSrvs, adminHTTPSrv)
+	}
+
+	inherited.closeUnusedListeners()
+
+	// Definitions are loaded and the HTTP API is listening
+	notifySystemd(sdnotify.Ready)
+	useSystemdWatchdog(gracefulShutdownCtx, pRunner)
+
+	notifyHandOff := make(chan os.Signal, 1)
+	notifyHandOffSignal(notifyHandOff)
+	defer signal.Stop(notifyHandOff)
 
-	// Wait for SIGINT or SIGTERM
-	<-gracefulShutdownCtx.Done()
+	// Wait for SIGINT or SIGTERM, or hand off to a new process on SIGHUP
+waitForSignal:
+	for {
+		select {
+		case <-gracefulShutdownCtx.Done():
+			break waitForSignal
+		case <-notifyHandOff:
+			handedOff, err := handOff(gracefulShutdownCtx, pRunner, httpSrvs, pidFile)
+			if handedOff {
+				return err
+			}
+			log.
+				WithError(err).
+				Error("Hand-off to new process failed, continuing")
+		}
+	}
 
+	notifySystemd(sdnotify.Stopping)
 	log.Info("Received signal, waiting until jobs are finished...")
+
+	// Cancel running jobs on a second SIGINT, so an impatient Ctrl+C does not need a separate SIGTERM
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, syscall.SIGINT)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			log.Warn("Received second SIGINT, canceling running jobs...")
+			forcedCancel()
+		case <-forcedShutdownCtx.Done():
+		}
+	}()
 	_ = pRunner.Shutdown(forcedShutdownCtx)
 
 	log.Debugf("Shutting down HTTP API...")
-	_ = httpSrv.Shutdown(forcedShutdownCtx)
+	for _, httpSrv := range httpSrvs {
+		_ = httpSrv.Shutdown(forcedShutdownCtx)
+	}
+
+	// No more events are published after the HTTP API was shut down
+	if natsPublisher != nil {
+		err := natsPublisher.Close(5 * time.Second)
+		if err != nil {
+			log.
+				WithError(err).
+				Warn("Error closing NATS publisher")
+		}
+	}
+	if statsdSink != nil {
+		err := statsdSink.Close(5 * time.Second)
+		if err != nil {
+			log.
+				WithError(err).
+				Warn("Error closing StatsD sink")
+		}
+	}
 
 	log.Info("Shutdown complete")
 
 	return nil
 }
 
-func handleDefinitionChanges(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner, defs *definition.PipelinesDef) {
+func handleDefinitionChanges(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner, defs *definition.PipelinesDef, remoteDefs *remotedefs.Source) {
 	reloadDefinitions := func() {
-		newDefs, err := definition.LoadRecursively(filepath.Join(c.String("path"), c.String("pattern")))
+		newDefs, err := loadDefinitions(c)
 		if err != nil {
 			log.Errorf("Error loading pipeline definitions: %s", err)
 			return
@@ -301,12 +804,32 @@ func handleDefinitionChanges(ctx context.Context, c *cli.Context, pRunner *prunn
 		defer close(notifyReload)
 		notifyReloadSignal(notifyReload)
 
+		// A nil channel never receives, so the remote definitions are only polled if a URL is set
+		var remoteTick <-chan time.Time
+		if remoteDefs != nil {
+			remoteTicker := time.NewTicker(c.Duration("definitions-poll-interval"))
+			defer remoteTicker.Stop()
+			remoteTick = remoteTicker.C
+		}
+
 		for {
 			select {
 			case <-t.C:
 				if watchEnabled {
 					reloadDefinitions()
 				}
+			case <-remoteTick:
+				changed, err := remoteDefs.Fetch(ctx)
+				if err != nil {
+					log.
+						WithError(err).
+						Warn("Error fetching remote definitions, keeping the current bundle")
+					continue
+				}
+				if changed {
+					log.Info("Remote definitions changed, reloading pipeline definitions")
+					reloadDefinitions()
+				}
 			case <-notifyReload:
 				log.Info("Received SIGUSR1, reloading pipeline definitions")
 				reloadDefinitions()
@@ -317,6 +840,420 @@ func handleDefinitionChanges(ctx context.Context, c *cli.Context, pRunner *prunn
 	}()
 }
 
+// handleDumpSignal logs the state of the runner and the stacks of all goroutines on SIGUSR2 for debugging stuck instances
+func handleDumpSignal(ctx context.Context, pRunner *prunner.PipelineRunner) {
+	notifyDump := make(chan os.Signal, 1)
+	notifyDumpSignal(notifyDump)
+
+	go func() {
+		defer signal.Stop(notifyDump)
+
+		for {
+			select {
+			case <-notifyDump:
+				var state strings.Builder
+				pRunner.State().Write(&state)
+				log.Infof("Received SIGUSR2, runner state:\n%s", state.String())
+
+				stack := make([]byte, 4<<20)
+				n := runtime.Stack(stack, true)
+				log.Infof("Goroutines:\n%s", stack[:n])
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func notifySystemd(state string) {
+	sent, err := sdnotify.Notify(state)
+	if err != nil {
+		log.
+			WithError(err).
+			Warnf("Error notifying systemd about %s", state)
+		return
+	}
+	if sent {
+		log.Debugf("Notified systemd about %s", state)
+	}
+}
+
+// useSystemdWatchdog sends watchdog notifications if enabled by WatchdogSec in the systemd unit. Notifications are
+// only sent while the runner is responsive, so systemd restarts prunner if it hangs.
+func useSystemdWatchdog(ctx context.Context, pRunner *prunner.PipelineRunner) {
+	interval, enabled, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		log.
+			WithError(err).
+			Warn("Invalid systemd watchdog settings, watchdog disabled")
+		return
+	}
+	if !enabled {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				checkCtx, cancel := context.WithTimeout(ctx, interval)
+				err := pRunner.CheckResponsive(checkCtx)
+				cancel()
+				if err != nil {
+					log.
+						WithError(err).
+						Error("Runner is not responsive, skipping systemd watchdog notification")
+					continue
+				}
+				notifySystemd(sdnotify.Watchdog)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	log.
+		WithField("interval", interval).
+		Info("Systemd watchdog enabled")
+}
+
+func useHostLoadGate(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner) {
+	limits := hoststat.Limits{
+		MaxLoadAverage:    c.Float64("max-load-average"),
+		MaxMemoryUsage:    c.Float64("max-memory-usage"),
+		MaxMemoryPressure: c.Float64("max-memory-pressure"),
+		MaxCPUUsage:       c.Float64("max-cpu-usage"),
+	}
+	if !limits.IsEnabled() {
+		return
+	}
+
+	checkInterval := c.Duration("load-check-interval")
+	pRunner.UseStartGate(ctx, hoststat.NewLoadGate(limits, checkInterval), checkInterval)
+
+	log.
+		WithField("limits", fmt.Sprintf("%+v", limits)).
+		Info("Host load gating enabled")
+}
+
+// useExecHooks adds the hook commands to the runner, it returns the dead letters of the on-complete hook if it is set
+func useExecHooks(c *cli.Context, pRunner *prunner.PipelineRunner) (*exechook.DeadLetterStore, error) {
+	timeout := c.Duration("hook-timeout")
+
+	if command := c.String("on-schedule-hook"); command != "" {
+		hook, err := exechook.NewScheduleHook(command, timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "on-schedule hook")
+		}
+		pRunner.UsePreScheduleHook(hook)
+
+		log.
+			WithField("command", command).
+			Info("On-schedule hook enabled")
+	}
+
+	var deadLetters *exechook.DeadLetterStore
+	if command := c.String("on-complete-hook"); command != "" {
+		deadLetters = exechook.NewDeadLetterStore(filepath.Join(c.String("data"), "dead-letters.json"))
+		hook, err := exechook.NewCompleteHook(
+			command,
+			timeout,
+			exechook.WithRetries(c.Int("hook-retries"), c.Duration("hook-retry-delay")),
+			exechook.WithDeadLetters(deadLetters),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "on-complete hook")
+		}
+		pRunner.UsePostCompleteHook(hook)
+
+		log.
+			WithField("command", command).
+			Info("On-complete hook enabled")
+	}
+
+	return deadLetters, nil
+}
+
+// useAlerts checks the alerts of pipelines periodically and calls the on-alert hook if it is set
+func useAlerts(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner) error {
+	var hooks []prunner.AlertHook
+	if command := c.String("on-alert-hook"); command != "" {
+		hook, err := exechook.NewAlertHook(command, c.Duration("hook-timeout"))
+		if err != nil {
+			return errors.Wrap(err, "on-alert hook")
+		}
+		hooks = append(hooks, hook)
+
+		log.
+			WithField("command", command).
+			Info("On-alert hook enabled")
+	}
+
+	pRunner.UseAlerts(ctx, c.Duration("alert-check-interval"), hooks...)
+	return nil
+}
+
+// useArchive moves old finished jobs to the archive periodically if an archive location is set
+func useArchive(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner) error {
+	location := c.String("archive")
+	if location == "" {
+		return nil
+	}
+
+	a, err := archive.Open(location)
+	if err != nil {
+		return errors.Wrap(err, "opening archive")
+	}
+	pRunner.UseArchive(ctx, a, c.Duration("archive-after"), c.Duration("archive-check-interval"))
+
+	log.
+		WithField("archiveAfter", c.Duration("archive-after")).
+		Info("Archiving jobs enabled")
+
+	return nil
+}
+
+// useNATSPublisher publishes the events of the runner to NATS if a URL is set, it returns nil otherwise
+func useNATSPublisher(c *cli.Context, pRunner *prunner.PipelineRunner) (*natspub.Publisher, error) {
+	natsURL := c.String("nats-url")
+	if natsURL == "" {
+		return nil, nil
+	}
+
+	publisher, err := natspub.New(natsURL, c.String("nats-subject-prefix"))
+	if err != nil {
+		return nil, errors.Wrap(err, "building NATS publisher")
+	}
+	prunner.Subscribe(pRunner, publisher.HandleEvent)
+
+	log.
+		WithField("subjectPrefix", c.String("nats-subject-prefix")).
+		Info("Publishing events to NATS enabled")
+
+	return publisher, nil
+}
+
+// useStatsDSink sends metrics for the events of the runner to StatsD if an address is set, it returns nil otherwise
+func useStatsDSink(c *cli.Context, pRunner *prunner.PipelineRunner) (*statsd.Sink, error) {
+	address := c.String("statsd-address")
+	if address == "" {
+		return nil, nil
+	}
+
+	var tags []string
+	if tagsValue := c.String("statsd-tags"); tagsValue != "" {
+		for _, tag := range strings.Split(tagsValue, ",") {
+			tags = append(tags, strings.TrimSpace(tag))
+		}
+	}
+
+	sink, err := statsd.New(address, c.String("statsd-prefix"), tags)
+	if err != nil {
+		return nil, errors.Wrap(err, "building StatsD sink")
+	}
+	prunner.Subscribe(pRunner, sink.HandleEvent)
+
+	log.
+		WithField("address", address).
+		WithField("prefix", c.String("statsd-prefix")).
+		Info("Sending metrics to StatsD enabled")
+
+	return sink, nil
+}
+
+// useSentry reports logged errors to Sentry if a DSN is set, it returns nil otherwise
+func useSentry(c *cli.Context) (*sentry.Client, error) {
+	dsn := c.String("sentry-dsn")
+	if dsn == "" {
+		return nil, nil
+	}
+
+	client, err := sentry.New(dsn, c.String("sentry-environment"))
+	if err != nil {
+		return nil, errors.Wrap(err, "building Sentry client")
+	}
+	handler, err := newLogHandler(c)
+	if err != nil {
+		return nil, err
+	}
+	log.SetHandler(client.Handler(handler))
+
+	log.
+		WithField("taskFailures", c.Bool("sentry-task-failures")).
+		Info("Reporting errors to Sentry enabled")
+
+	return client, nil
+}
+
+// useRedisTrigger schedules jobs from a Redis list if a URL is set
+func useRedisTrigger(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner) error {
+	redisURL := c.String("redis-url")
+	if redisURL == "" {
+		return nil
+	}
+
+	consumer, err := redistrigger.NewConsumer(redisURL, c.String("redis-queue"), pRunner)
+	if err != nil {
+		return errors.Wrap(err, "building Redis consumer")
+	}
+	go consumer.Run(ctx)
+
+	log.
+		WithField("queue", c.String("redis-queue")).
+		Info("Scheduling jobs from Redis enabled")
+
+	return nil
+}
+
+// sharedStateRecheckInterval is the interval for starting queued jobs after other instances released slots
+const sharedStateRecheckInterval = time.Second
+
+// useSharedState shares the concurrency of pipelines with other instances if a URL is set
+func useSharedState(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner) error {
+	sharedStateURL := c.String("shared-state-url")
+	if sharedStateURL == "" {
+		return nil
+	}
+
+	instanceID := c.String("instance-id")
+	if instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Wrap(err, "getting hostname for instance ID")
+		}
+		instanceID = hostname
+	}
+
+	backend, err := sharedstate.NewRedisBackend(sharedStateURL, c.String("shared-state-prefix"))
+	if err != nil {
+		return errors.Wrap(err, "building shared state backend")
+	}
+	go func() {
+		<-ctx.Done()
+		_ = backend.Close()
+	}()
+
+	slots := sharedstate.NewSlots(backend, instanceID)
+	go slots.Run(ctx)
+	// Slots that are released by other instances are noticed by rechecking the wait lists
+	pRunner.UseSlotClaimer(ctx, slots, sharedStateRecheckInterval)
+
+	log.
+		WithField("instanceID", instanceID).
+		Info("Sharing pipeline concurrency with other instances enabled")
+
+	return nil
+}
+
+func useDiskSpaceGate(ctx context.Context, c *cli.Context, pRunner *prunner.PipelineRunner) {
+	minFreeDiskSpace := c.Uint64("min-free-disk-space")
+	if minFreeDiskSpace == 0 {
+		return
+	}
+
+	checkInterval := c.Duration("load-check-interval")
+	gate := hoststat.NewDiskSpaceGate(c.String("data"), minFreeDiskSpace*1024*1024, checkInterval)
+	// Refuse new jobs and do not start queued jobs while the disk space is low
+	pRunner.UseScheduleGate(gate)
+	pRunner.UseStartGate(ctx, gate, checkInterval)
+
+	log.
+		WithField("minFreeDiskSpace", fmt.Sprintf("%d MiB", minFreeDiskSpace)).
+		Info("Disk space gating enabled")
+}
+
+// applyConfigFileToFlags sets global flags from the server settings of the config file,
+// flags set on the command line or via environment variables take precedence
+func applyConfigFileToFlags(c *cli.Context) error {
+	conf, err := config.LoadConfigFile(c.String("config"))
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		return nil
+	}
+
+	for name, value := range conf.ServerConfig.Settings() {
+		if c.IsSet(name) {
+			continue
+		}
+		err := c.Set(name, value)
+		if err != nil {
+			return errors.Wrapf(err, "setting %s from config file", name)
+		}
+	}
+	return nil
+}
+
+// remoteDefinitionsDir is the directory for the fetched bundle of remote definitions
+func remoteDefinitionsDir(c *cli.Context) string {
+	return filepath.Join(c.String("data"), "remote-definitions")
+}
+
+// useRemoteDefinitions fetches the bundle of remote definitions if a URL is set. If the bundle cannot be fetched, the
+// last fetched bundle is used, so prunner can start while the URL cannot be reached.
+func useRemoteDefinitions(c *cli.Context) (*remotedefs.Source, error) {
+	definitionsURL := c.String("definitions-url")
+	if definitionsURL == "" {
+		return nil, nil
+	}
+
+	publicKey, err := remotedefs.ParsePublicKey(c.String("definitions-public-key"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing definitions public key")
+	}
+	source, err := remotedefs.NewSource(definitionsURL, remoteDefinitionsDir(c), publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "building remote definitions source")
+	}
+
+	_, err = source.Fetch(c.Context)
+	if err != nil {
+		if _, statErr := os.Stat(source.Dir()); statErr != nil {
+			return nil, errors.Wrap(err, "fetching remote definitions")
+		}
+		log.
+			WithError(err).
+			Warn("Error fetching remote definitions, using the last fetched bundle")
+	}
+
+	log.
+		WithField("url", definitionsURL).
+		Info("Remote definitions enabled")
+
+	return source, nil
+}
+
+// loadDefinitions loads the pipeline definitions with the global path, pattern and duplicate policy. Multiple paths are
+// separated by comma, pipelines of a later path replace pipelines with the same name of earlier paths. A fetched bundle
+// of remote definitions is loaded before all paths.
+func loadDefinitions(c *cli.Context) (*definition.PipelinesDef, error) {
+	duplicatePolicy, err := definition.ParseDuplicatePolicy(c.String("duplicate-pipelines"))
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	// The remote bundle is the first layer, so pipelines of local paths replace remote pipelines
+	if c.String("definitions-url") != "" {
+		remoteDir := remotedefs.CurrentDir(remoteDefinitionsDir(c))
+		if _, err := os.Stat(remoteDir); err == nil {
+			patterns = append(patterns, filepath.Join(remoteDir, c.String("pattern")))
+		}
+	}
+	for _, path := range strings.Split(c.String("path"), ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			patterns = append(patterns, filepath.Join(path, c.String("pattern")))
+		}
+	}
+
+	return definition.LoadLayers(patterns, definition.WithDuplicatePolicy(duplicatePolicy))
+}
+
 func loadConfig(c *cli.Context) (*config.Config, error) {
 	conf, err := config.LoadOrCreateConfig(
 		c.String("config"),
@@ -333,15 +1270,42 @@ func createLogFormatter(c *cli.Context) middleware.LogFormatter {
 	}
 }
 
-func setLogHandler(c *cli.Context) {
+// setLogHandler sets the level and format of log output, including the output of the taskctl packages
+func setLogHandler(c *cli.Context) error {
+	level, err := log.ParseLevel(c.String("log-level"))
+	if err != nil {
+		return errors.Wrap(err, "invalid log level")
+	}
 	if c.Bool("verbose") {
-		log.SetLevel(log.DebugLevel)
+		level = log.DebugLevel
+	}
+	handler, err := newLogHandler(c)
+	if err != nil {
+		return err
 	}
 
-	if useAnsiOutput(c) {
-		log.SetHandler(text_handler.New(os.Stderr))
-	} else {
-		log.SetHandler(logfmt_handler.New(os.Stderr))
+	log.SetLevel(level)
+	log.SetHandler(handler)
+	taskctl.ForwardLogrus(log.Log, level)
+
+	return nil
+}
+
+func newLogHandler(c *cli.Context) (log.Handler, error) {
+	switch format := c.String("log-format"); format {
+	case "":
+		if useAnsiOutput(c) {
+			return text_handler.New(os.Stderr), nil
+		}
+		return logfmt_handler.New(os.Stderr), nil
+	case "text":
+		return text_handler.New(os.Stderr), nil
+	case "logfmt":
+		return logfmt_handler.New(os.Stderr), nil
+	case "json":
+		return json_handler.New(os.Stderr), nil
+	default:
+		return nil, errors.Errorf("invalid log format %q, expected text, logfmt or json", format)
 	}
 }
 
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppAction_RejectsNonPositivePollIntervals(t *testing.T) {
+	for _, envVar := range []string{"PRUNNER_POLL_INTERVAL", "PRUNNER_DEFINITIONS_POLL_INTERVAL", "PRUNNER_PID_FILE"} {
+		unsetEnv(t, envVar)
+	}
+
+	for _, flag := range []string{"poll-interval", "definitions-poll-interval"} {
+		t.Run(flag, func(t *testing.T) {
+			dir := t.TempDir()
+			app := New(Info{})
+			err := app.Run([]string{
+				"prunner",
+				"--config", filepath.Join(dir, ".prunner.yml"),
+				"--jwt-secret", "not-very-secret-at-all",
+				"--env-files", "",
+				"--data", filepath.Join(dir, "data"),
+				"--" + flag, "0s",
+			})
+			assert.EqualError(t, err, "invalid "+flag+" 0s: must be positive")
+		})
+	}
+}
@@ -11,3 +11,17 @@ import (
 func notifyReloadSignal(notifyReload chan os.Signal) {
 	signal.Notify(notifyReload, syscall.SIGUSR1)
 }
+
+func notifyDumpSignal(notifyDump chan os.Signal) {
+	signal.Notify(notifyDump, syscall.SIGUSR2)
+}
+
+func notifyHandOffSignal(notifyHandOff chan os.Signal) {
+	signal.Notify(notifyHandOff, syscall.SIGHUP)
+}
+
+// processExists checks if a process with the pid is running by sending signal 0
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -9,3 +9,16 @@ import (
 func notifyReloadSignal(notifyReload chan os.Signal) {
 	// No op, windows does not support user signals
 }
+
+func notifyDumpSignal(notifyDump chan os.Signal) {
+	// No op, windows does not support user signals
+}
+
+func notifyHandOffSignal(notifyHandOff chan os.Signal) {
+	// No op, listeners cannot be passed to a new process on windows
+}
+
+// processExists cannot reliably check processes on Windows, so PID files are always treated as stale
+func processExists(pid int) bool {
+	return false
+}
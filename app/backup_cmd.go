@@ -0,0 +1,133 @@
+package app
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/Flowpack/prunner/backup"
+	"github.com/Flowpack/prunner/store"
+)
+
+func newBackupCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Write a backup of the job state and logs from the data directory as a gzipped tarball",
+		Description: "The backup can be taken while prunner is running, since the job state is saved atomically. " +
+			"To back up the current in-memory state of a running server use the /admin/backup endpoint.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Filename of the backup (- for stdout)",
+				Value:   "prunner-backup.tar.gz",
+				EnvVars: []string{"PRUNNER_BACKUP_OUTPUT"},
+			},
+			&cli.BoolFlag{
+				Name:    "without-logs",
+				Usage:   "Do not include job logs",
+				EnvVars: []string{"PRUNNER_BACKUP_WITHOUT_LOGS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "pipeline",
+				Usage:   "Only include logs of jobs of the pipeline (can be repeated)",
+				EnvVars: []string{"PRUNNER_BACKUP_PIPELINE"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			dataDir := c.String("data")
+			dataStore, err := store.NewJSONDataStore(dataDir)
+			if err != nil {
+				return errors.Wrap(err, "building data store")
+			}
+			data, err := dataStore.Load()
+			if err != nil {
+				return errors.Wrap(err, "loading data")
+			}
+
+			var w io.Writer = os.Stdout
+			output := c.String("output")
+			if output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return errors.Wrap(err, "creating backup file")
+				}
+				defer f.Close()
+				w = f
+			}
+
+			result, err := backup.Write(w, data, filepath.Join(dataDir, "logs"), backup.Options{
+				SkipLogs:  c.Bool("without-logs"),
+				Pipelines: c.StringSlice("pipeline"),
+			})
+			if err != nil {
+				return errors.Wrap(err, "writing backup")
+			}
+
+			log.
+				WithField("jobs", result.Jobs).
+				WithField("logFiles", result.LogFiles).
+				Infof("Backup of %s written to %s", dataDir, output)
+
+			return nil
+		},
+	}
+}
+
+func newRestoreCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "Restore the job state and logs from a backup into the data directory",
+		Description: "Prunner must not be running on the data directory while restoring, " +
+			"otherwise the restored job state is overwritten on the next save.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "Filename of the backup (- for stdin)",
+				Value:   "prunner-backup.tar.gz",
+				EnvVars: []string{"PRUNNER_RESTORE_INPUT"},
+			},
+			&cli.BoolFlag{
+				Name:    "force",
+				Usage:   "Replace existing job state in the data directory",
+				EnvVars: []string{"PRUNNER_RESTORE_FORCE"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			dataDir := c.String("data")
+			if !c.Bool("force") {
+				if _, err := os.Stat(filepath.Join(dataDir, "data.json")); err == nil {
+					return errors.Errorf("data directory %s already contains job state, use --force to replace it", dataDir)
+				}
+			}
+
+			var r io.Reader = os.Stdin
+			input := c.String("input")
+			if input != "-" {
+				f, err := os.Open(input)
+				if err != nil {
+					return errors.Wrap(err, "opening backup file")
+				}
+				defer f.Close()
+				r = f
+			}
+
+			result, err := backup.Restore(r, dataDir)
+			if err != nil {
+				return errors.Wrap(err, "restoring backup")
+			}
+
+			log.
+				WithField("jobs", result.Jobs).
+				WithField("logFiles", result.LogFiles).
+				Infof("Backup %s restored to %s", input, dataDir)
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/hoststat"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+func newCompactCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "compact",
+		Usage: "Remove pruned jobs, orphaned logs and temporary files from the data directory",
+		Description: "Jobs are removed according to the retention settings of the pipeline definitions, " +
+			"jobs of pipelines that are not defined anymore are removed as well. " +
+			"Prunner must not be running on the data directory while compacting.",
+		Action: func(c *cli.Context) error {
+			dataDir := c.String("data")
+
+			defs, err := loadDefinitions(c)
+			if err != nil {
+				return errors.Wrap(err, "loading definitions")
+			}
+			// Without definitions all jobs would be removed, which is most likely caused by a wrong path or pattern
+			if len(defs.Pipelines) == 0 {
+				return errors.New("no pipeline definitions found, check the path and pattern")
+			}
+
+			sizeBefore, err := hoststat.DirSize(dataDir)
+			if err != nil {
+				return errors.Wrap(err, "reading data directory size")
+			}
+
+			dataStore, err := store.NewJSONDataStore(dataDir)
+			if err != nil {
+				return errors.Wrap(err, "building data store")
+			}
+			outputStore, err := taskctl.NewOutputStore(filepath.Join(dataDir, "logs"))
+			if err != nil {
+				return errors.Wrap(err, "building output store")
+			}
+
+			removedTempFiles, err := dataStore.RemoveTempFiles()
+			if err != nil {
+				return err
+			}
+
+			result, err := prunner.Compact(defs, dataStore, outputStore)
+			if err != nil {
+				return errors.Wrap(err, "compacting data")
+			}
+
+			sizeAfter, err := hoststat.DirSize(dataDir)
+			if err != nil {
+				return errors.Wrap(err, "reading data directory size")
+			}
+			var reclaimed uint64
+			if sizeAfter < sizeBefore {
+				reclaimed = uint64(sizeBefore - sizeAfter)
+			}
+
+			log.
+				WithField("removedJobs", result.RemovedJobs).
+				WithField("removedOrphanedLogs", result.RemovedOrphanedLogs).
+				WithField("removedTempFiles", removedTempFiles).
+				Infof("Compacted %s, reclaimed %s", dataDir, hoststat.FormatBytes(reclaimed))
+
+			return nil
+		},
+	}
+}
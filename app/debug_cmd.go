@@ -6,12 +6,20 @@ import (
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/urfave/cli/v2"
 	"os"
+	"strings"
 )
 
 func newDebugCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "debug",
 		Usage: "Get authorization information for debugging",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:    "scope",
+				Usage:   "Add a scope to the generated token (e.g. pipelines:priority)",
+				EnvVars: []string{"PRUNNER_DEBUG_SCOPE"},
+			},
+		},
 		Action: func(c *cli.Context) error {
 			conf, err := loadConfig(c)
 			if err != nil {
@@ -22,6 +30,9 @@ func newDebugCmd() *cli.Command {
 
 			claims := make(map[string]interface{})
 			jwtauth.SetIssuedNow(claims)
+			if scopes := c.StringSlice("scope"); len(scopes) > 0 {
+				claims["scope"] = strings.Join(scopes, " ")
+			}
 			_, tokenString, _ := tokenAuth.Encode(claims)
 			if os.Getenv("MINIMAL_OUTPUT") == "1" {
 				// for scripting
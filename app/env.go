@@ -0,0 +1,83 @@
+package app
+
+import (
+	"os"
+	"reflect"
+
+	"github.com/friendsofgo/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// flagEnvVars returns the environment variables of a flag (all flag types have an EnvVars field)
+func flagEnvVars(flag cli.Flag) []string {
+	v := reflect.Indirect(reflect.ValueOf(flag))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.FieldByName("EnvVars")
+	if !field.IsValid() {
+		return nil
+	}
+	envVars, _ := field.Interface().([]string)
+	return envVars
+}
+
+// lookupFlagEnv returns the values of the environment variables of the given flags
+func lookupFlagEnv(flags []cli.Flag) map[string]string {
+	values := make(map[string]string)
+	for _, flag := range flags {
+		for _, envVar := range flagEnvVars(flag) {
+			if value, ok := os.LookupEnv(envVar); ok {
+				values[envVar] = value
+			}
+		}
+	}
+	return values
+}
+
+// applyEnvFilesToFlags sets global flags from environment variables that were loaded from env files.
+//
+// Global flags are parsed before the env files are loaded, so their environment variables have to be applied again.
+// Flags given on the command line take precedence. Slice flags are not supported, since values read from the process
+// environment cannot be replaced.
+func applyEnvFilesToFlags(c *cli.Context, envBeforeLoading map[string]string) error {
+	setOnCommandLine := make(map[string]bool)
+	for _, name := range c.LocalFlagNames() {
+		setOnCommandLine[name] = true
+	}
+
+	for _, flag := range c.App.Flags {
+		if _, isSlice := flag.(*cli.StringSliceFlag); isSlice {
+			continue
+		}
+		if isFlagSet(flag, setOnCommandLine) {
+			continue
+		}
+		name := flag.Names()[0]
+
+		for _, envVar := range flagEnvVars(flag) {
+			value, ok := os.LookupEnv(envVar)
+			if !ok {
+				continue
+			}
+			if before, existed := envBeforeLoading[envVar]; existed && before == value {
+				// Already applied when parsing the flags
+				break
+			}
+			if err := c.Set(name, value); err != nil {
+				return errors.Wrapf(err, "setting flag %s from env var %s", name, envVar)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func isFlagSet(flag cli.Flag, setNames map[string]bool) bool {
+	for _, name := range flag.Names() {
+		if setNames[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestNew_AllFlagsHaveEnvVars(t *testing.T) {
+	app := New(Info{})
+
+	assertEnvVars := func(flags []cli.Flag) {
+		for _, flag := range flags {
+			envVars := flagEnvVars(flag)
+			if assert.NotEmpty(t, envVars, "flag %s has no env var", flag.Names()[0]) {
+				assert.True(t, strings.HasPrefix(envVars[0], "PRUNNER_"), "env var %s of flag %s has no PRUNNER_ prefix", envVars[0], flag.Names()[0])
+			}
+		}
+	}
+
+	assertEnvVars(app.Flags)
+	for _, cmd := range app.Commands {
+		assertEnvVars(cmd.Flags)
+	}
+}
+
+func TestNew_AppliesEnvFilesToFlags(t *testing.T) {
+	for _, envVar := range []string{"PRUNNER_ADDRESS", "PRUNNER_DATA", "PRUNNER_POLL_INTERVAL", "PRUNNER_PATH"} {
+		unsetEnv(t, envVar)
+	}
+	// Values from the process environment are overridden by env files
+	require.NoError(t, os.Setenv("PRUNNER_PATH", "from-process-env"))
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(envFile, []byte("PRUNNER_ADDRESS=:1234\nPRUNNER_DATA=from-env-file\nPRUNNER_POLL_INTERVAL=5s\nPRUNNER_PATH=from-env-file\n"), 0644)
+	require.NoError(t, err)
+
+	app := New(Info{})
+	var values map[string]string
+	app.Action = func(c *cli.Context) error {
+		values = map[string]string{
+			"address":       c.String("address"),
+			"data":          c.String("data"),
+			"poll-interval": c.Duration("poll-interval").String(),
+			"path":          c.String("path"),
+		}
+		return nil
+	}
+
+	err = app.Run([]string{"prunner", "--env-files", envFile, "--data", "from-flag"})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"address":       ":1234",
+		"data":          "from-flag",
+		"poll-interval": "5s",
+		"path":          "from-env-file",
+	}, values)
+}
+
+// unsetEnv unsets an environment variable and restores it after the test
+func unsetEnv(t *testing.T, key string) {
+	t.Helper()
+
+	value, ok := os.LookupEnv(key)
+	_ = os.Unsetenv(key)
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, value)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func TestNew_AppliesConfigFileToFlags(t *testing.T) {
+	for _, envVar := range []string{"PRUNNER_ADDRESS", "PRUNNER_DATA", "PRUNNER_WATCH", "PRUNNER_CONFIG"} {
+		unsetEnv(t, envVar)
+	}
+	require.NoError(t, os.Setenv("PRUNNER_DATA", "from-env"))
+
+	configPath := filepath.Join(t.TempDir(), ".prunner.yml")
+	err := os.WriteFile(configPath, []byte("address: \":1234\"\ndata: from-config\nwatch: true\n"), 0644)
+	require.NoError(t, err)
+
+	app := New(Info{})
+	var values map[string]string
+	app.Action = func(c *cli.Context) error {
+		values = map[string]string{
+			"address": c.String("address"),
+			"data":    c.String("data"),
+			"watch":   fmt.Sprint(c.Bool("watch")),
+		}
+		return nil
+	}
+
+	err = app.Run([]string{"prunner", "--env-files", "", "--config", configPath, "--watch=false"})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"address": ":1234",
+		"data":    "from-env",
+		"watch":   "false",
+	}, values)
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/sdnotify"
+)
+
+const (
+	// listenFDsEnv contains the addresses of the listeners passed to a new process (starting at fd 3)
+	listenFDsEnv = "PRUNNER_LISTEN_FDS"
+	// handOffFDEnv contains the fd of a pipe that signals the new process when the job state was handed off
+	handOffFDEnv = "PRUNNER_HANDOFF_FD"
+
+	// handOffDrainTimeout is the maximum time to wait for active requests before handing off the job state
+	handOffDrainTimeout = 10 * time.Second
+)
+
+// takeOver contains the listeners and hand-off pipe of a previous prunner process that hands off to this process
+type takeOver struct {
+	listeners map[string]net.Listener
+	handOff   *os.File
+}
+
+// takeOverFromEnv returns the listeners of a previous process, it returns nil if the process was not started for a hand-off
+func takeOverFromEnv() (*takeOver, error) {
+	fdValue, ok := os.LookupEnv(handOffFDEnv)
+	if !ok {
+		return nil, nil
+	}
+	addresses := parseAddresses(os.Getenv(listenFDsEnv))
+	// The variables must not be passed on to tasks or another new process
+	_ = os.Unsetenv(handOffFDEnv)
+	_ = os.Unsetenv(listenFDsEnv)
+
+	fd, err := strconv.Atoi(fdValue)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", handOffFDEnv)
+	}
+
+	t := &takeOver{
+		listeners: make(map[string]net.Listener, len(addresses)),
+		handOff:   os.NewFile(uintptr(fd), "handoff"),
+	}
+	for i, address := range addresses {
+		f := os.NewFile(uintptr(3+i), address)
+		l, err := net.FileListener(f)
+		// The listener has its own copy of the fd
+		_ = f.Close()
+		if err != nil {
+			t.closeUnusedListeners()
+			return nil, errors.Wrapf(err, "using listener for %s of previous process", address)
+		}
+		t.listeners[address] = l
+	}
+
+	return t, nil
+}
+
+// waitForState blocks until the previous process has handed off the job state
+func (t *takeOver) waitForState() error {
+	if t == nil {
+		return nil
+	}
+	defer t.handOff.Close()
+
+	log.Info("Waiting for previous process to hand off job state...")
+	_, err := t.handOff.Read(make([]byte, 1))
+	if err == io.EOF {
+		return errors.New("previous process aborted the hand-off")
+	} else if err != nil {
+		return errors.Wrap(err, "waiting for hand-off")
+	}
+	return nil
+}
+
+// listener returns the listener of the previous process for the address (it can only be used once)
+func (t *takeOver) listener(address string) (net.Listener, bool) {
+	if t == nil {
+		return nil, false
+	}
+	l, ok := t.listeners[address]
+	delete(t.listeners, address)
+	return l, ok
+}
+
+// closeUnusedListeners closes listeners of the previous process for addresses that are not configured anymore
+func (t *takeOver) closeUnusedListeners() {
+	if t == nil {
+		return
+	}
+	for address, l := range t.listeners {
+		log.Infof("Closing listener on %s of previous process, the address is not configured anymore", address)
+		_ = l.Close()
+	}
+	t.listeners = nil
+}
+
+// handOff starts a new prunner process (e.g. after upgrading the binary) and hands off the listeners and job state.
+//
+// Running jobs are finished first while the API stays available, new jobs are kept on the wait list. Then the HTTP
+// servers stop accepting connections and the job state is saved. Connections in the meantime wait in the listen
+// backlog until the new process accepts them. It returns true if this process should exit. If the hand-off failed
+// before the job state was handed off, this process continues to serve.
+func handOff(ctx context.Context, pRunner *prunner.PipelineRunner, httpSrvs []*httpServer, pidFile string) (bool, error) {
+	log.Info("Received SIGHUP, waiting for running jobs to hand off to a new process...")
+
+	err := pRunner.PrepareHandOff(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// The listeners are duplicated, so they stay open when the HTTP servers are shut down
+	var (
+		addresses []string
+		files     []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for _, s := range httpSrvs {
+		for i, l := range s.listeners {
+			f, err := listenerFile(l)
+			if err != nil {
+				pRunner.CancelHandOff()
+				return false, errors.Wrapf(err, "duplicating listener on %s", s.addresses[i])
+			}
+			addresses = append(addresses, s.addresses[i])
+			files = append(files, f)
+		}
+	}
+
+	handOffReader, handOffWriter, err := os.Pipe()
+	if err != nil {
+		pRunner.CancelHandOff()
+		return false, errors.Wrap(err, "creating hand-off pipe")
+	}
+	defer handOffWriter.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), handOffReader)
+	cmd.Env = append(
+		handOffEnv(),
+		listenFDsEnv+"="+strings.Join(addresses, ","),
+		handOffFDEnv+"="+strconv.Itoa(3+len(files)),
+	)
+
+	// The new process refuses to start if the PID file contains the id of this process
+	if pidFile != "" {
+		_ = removePIDFile(pidFile)
+	}
+
+	err = cmd.Start()
+	_ = handOffReader.Close()
+	if err != nil {
+		restorePIDFile(pidFile)
+		pRunner.CancelHandOff()
+		return false, errors.Wrap(err, "starting new process")
+	}
+
+	// Stop accepting connections and wait for active requests, so the job state does not change anymore
+	drainCtx, cancel := context.WithTimeout(ctx, handOffDrainTimeout)
+	defer cancel()
+	for _, s := range httpSrvs {
+		_ = s.Shutdown(drainCtx)
+	}
+
+	err = pRunner.HandOff()
+	if err != nil {
+		// Closing the pipe without a signal aborts the new process
+		_ = handOffWriter.Close()
+		_ = cmd.Wait()
+
+		resumeErr := resumeHTTPServers(httpSrvs, files)
+		restorePIDFile(pidFile)
+		pRunner.CancelHandOff()
+		if resumeErr != nil {
+			return false, resumeErr
+		}
+		return false, err
+	}
+
+	_, err = handOffWriter.Write([]byte{1})
+	if err != nil {
+		return true, errors.Wrap(err, "signaling hand-off to new process")
+	}
+
+	notifySystemd(sdnotify.MainPID(cmd.Process.Pid))
+	log.Infof("Handed off to new process %d", cmd.Process.Pid)
+
+	return true, nil
+}
+
+// handOffEnv returns the environment for the new process
+func handOffEnv() []string {
+	var env []string
+	for _, e := range os.Environ() {
+		// The systemd watchdog is meant for the new process after the hand-off
+		if strings.HasPrefix(e, "WATCHDOG_PID=") {
+			continue
+		}
+		env = append(env, e)
+	}
+	return env
+}
+
+func listenerFile(l net.Listener) (*os.File, error) {
+	switch l := l.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		// The socket file must not be removed when the listener is closed
+		l.SetUnlinkOnClose(false)
+		return l.File()
+	default:
+		return nil, errors.Errorf("unsupported listener type %T", l)
+	}
+}
+
+// resumeHTTPServers serves again on the duplicated listeners after the HTTP servers were shut down
+func resumeHTTPServers(httpSrvs []*httpServer, files []*os.File) error {
+	for _, s := range httpSrvs {
+		listeners := make([]net.Listener, len(s.listeners))
+		for i := range s.listeners {
+			l, err := net.FileListener(files[0])
+			if err != nil {
+				return errors.Wrapf(err, "resuming listener on %s", s.addresses[i])
+			}
+			listeners[i] = l
+			files = files[1:]
+		}
+		s.serve(s.Handler, listeners)
+	}
+	return nil
+}
+
+func restorePIDFile(pidFile string) {
+	if pidFile == "" {
+		return
+	}
+	if err := writePIDFile(pidFile); err != nil {
+		log.
+			WithError(err).
+			Warnf("Error restoring PID file %s", pidFile)
+	}
+}
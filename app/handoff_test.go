@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeOver_WaitForState(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	_, err = w.Write([]byte{1})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.NoError(t, (&takeOver{handOff: r}).waitForState())
+
+	r, w, err = os.Pipe()
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.ErrorContains(t, (&takeOver{handOff: r}).waitForState(), "aborted")
+}
+
+func TestServeHTTP_WithInheritedListener(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+
+	previousSrv, err := serveHTTP("Previous API", []string{"localhost:0"}, handler, nil)
+	require.NoError(t, err)
+	address := previousSrv.listeners[0].Addr().String()
+
+	f, err := listenerFile(previousSrv.listeners[0])
+	require.NoError(t, err)
+	l, err := net.FileListener(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The duplicated listener stays open after the previous server is shut down
+	require.NoError(t, previousSrv.Shutdown(context.Background()))
+
+	inherited := &takeOver{
+		listeners: map[string]net.Listener{address: l},
+	}
+	httpSrv, err := serveHTTP("Test API", []string{address}, handler, inherited)
+	require.NoError(t, err)
+	defer httpSrv.Shutdown(context.Background())
+	assert.Empty(t, inherited.listeners, "inherited listener was used")
+
+	resp, err := http.Get("http://" + address + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
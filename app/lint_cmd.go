@@ -0,0 +1,41 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/friendsofgo/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/Flowpack/prunner"
+)
+
+func newLintCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "lint",
+		Usage: "Check the pipeline definitions for settings that are most likely a mistake",
+		Description: "Prints a warning per line for unreachable tasks, isolated tasks in pipelines with dependencies, " +
+			"shadowed environment variables and pipelines without description. Invalid definitions fail to load as usual.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Exit with status 1 if there are warnings (e.g. in CI)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			defs, err := loadDefinitions(c)
+			if err != nil {
+				return errors.Wrap(err, "loading definitions")
+			}
+
+			warnings := prunner.LintDefinitions(defs)
+			for _, warning := range warnings {
+				fmt.Println(warning)
+			}
+
+			if c.Bool("strict") && len(warnings) > 0 {
+				return cli.Exit(fmt.Sprintf("%d warnings", len(warnings)), 1)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+)
+
+const unixAddressPrefix = "unix:"
+
+// parseAddresses splits a comma separated list of listen addresses
+func parseAddresses(value string) []string {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// listen creates a listener for a TCP address (host:port) or a Unix domain socket (unix:/path/to/socket)
+func listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixAddressPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	socketPath := strings.TrimPrefix(address, unixAddressPrefix)
+	// Remove a stale socket of a previous process that was not shut down properly
+	if info, err := os.Stat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(socketPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "removing stale socket")
+		}
+	}
+	return net.Listen("unix", socketPath)
+}
+
+// httpServer is an HTTP server with its listeners, so the listeners can be handed off to a new process
+type httpServer struct {
+	*http.Server
+
+	name      string
+	addresses []string
+	listeners []net.Listener
+}
+
+// serveHTTP listens on all addresses and serves the handler with a single HTTP server (for a common shutdown).
+// Listeners of a previous instance that handed off to this process are used instead of listening again.
+func serveHTTP(name string, addresses []string, handler http.Handler, inherited *takeOver) (*httpServer, error) {
+	if len(addresses) == 0 {
+		return nil, errors.Errorf("no listen address for %s", name)
+	}
+
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		if l, ok := inherited.listener(address); ok {
+			listeners = append(listeners, l)
+			continue
+		}
+
+		l, err := listen(address)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, errors.Wrapf(err, "listening on %s for %s", address, name)
+		}
+		listeners = append(listeners, l)
+	}
+
+	s := &httpServer{
+		name:      name,
+		addresses: addresses,
+	}
+	s.serve(handler, listeners)
+
+	return s, nil
+}
+
+func (s *httpServer) serve(handler http.Handler, listeners []net.Listener) {
+	s.Server = &http.Server{
+		Handler: handler,
+	}
+	s.listeners = listeners
+
+	for i, l := range listeners {
+		log.
+			Infof("%s listening on %s", s.name, s.addresses[i])
+
+		go func(httpSrv *http.Server, l net.Listener) {
+			if err := httpSrv.Serve(l); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Error serving %s: %s", s.name, err)
+			}
+		}(s.Server, l)
+	}
+}
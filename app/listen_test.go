@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddresses(t *testing.T) {
+	assert.Equal(t, []string{"localhost:9009", "unix:/run/prunner.sock"}, parseAddresses("localhost:9009, unix:/run/prunner.sock,"))
+	assert.Empty(t, parseAddresses(""))
+}
+
+func TestServeHTTP_WithUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "prunner.sock")
+
+	// Simulate a stale socket of a previous process
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+
+	httpSrv, err := serveHTTP("Test API", []string{"unix:" + socketPath}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}), nil)
+	require.NoError(t, err)
+	defer httpSrv.Shutdown(context.Background())
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	resp, err := client.Get("http://prunner/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
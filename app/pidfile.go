@@ -0,0 +1,44 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// writePIDFile writes the pid of the current process to a file. It fails if the file contains the pid of another
+// running process, a file of a process that is not running anymore is replaced.
+func writePIDFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err == nil && pid != os.Getpid() && processExists(pid) {
+			return errors.Errorf("PID file %s exists, prunner is already running with pid %d", path, pid)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "reading PID file")
+	}
+
+	err = os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	if err != nil {
+		return errors.Wrap(err, "writing PID file")
+	}
+	return nil
+}
+
+// removePIDFile removes the PID file if it still contains the pid of the current process
+func removePIDFile(path string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "reading PID file")
+	}
+	if strings.TrimSpace(string(content)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
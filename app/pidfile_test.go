@@ -0,0 +1,52 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndRemovePIDFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "prunner.pid")
+
+	require.NoError(t, writePIDFile(pidFile))
+	content, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(content))
+
+	require.NoError(t, removePIDFile(pidFile))
+	assert.NoFileExists(t, pidFile)
+}
+
+func TestWritePIDFile_WithStalePIDFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "prunner.pid")
+	// A pid above the default pid_max of Linux
+	require.NoError(t, os.WriteFile(pidFile, []byte("4194305\n"), 0644))
+
+	require.NoError(t, writePIDFile(pidFile))
+	content, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(content))
+}
+
+func TestWritePIDFile_WithRunningProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("processes cannot be checked on Windows")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "prunner.pid")
+	// The parent process (go test) is running
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())), 0644))
+
+	err := writePIDFile(pidFile)
+	assert.ErrorContains(t, err, "already running")
+
+	// The PID file of another process is not removed
+	require.NoError(t, removePIDFile(pidFile))
+	assert.FileExists(t, pidFile)
+}
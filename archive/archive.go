@@ -0,0 +1,339 @@
+// Package archive moves finished jobs with their logs to cold storage (a directory or an S3 bucket) and restores them
+// on demand.
+//
+// Each job is stored as a gzipped tarball <job id>/<pipeline>.tar.gz, so a job is found by its id without listing the
+// whole archive. It contains the persisted job as data.json (in
+// the format of the JSON data store, like a backup) and the logs of its tasks and services in logs/<task>/<output>.log.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+const (
+	dataFilename = "data.json"
+	logsDirname  = "logs"
+	keySuffix    = ".tar.gz"
+)
+
+// outputNames are the outputs of tasks and services in the output store
+var outputNames = []string{"stdout", "stderr"}
+
+// ErrNotFound is returned if a job or object is not in the archive
+var ErrNotFound = errors.New("not found in archive")
+
+// Storage stores the archived jobs as objects by key
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns ErrNotFound if the object does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the objects with a key starting with prefix (all objects if prefix is empty)
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete does not return an error if the object does not exist
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an object in the storage
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Entry is an archived job
+type Entry struct {
+	JobID    uuid.UUID
+	Pipeline string
+	// Size of the compressed job and logs in bytes
+	Size     int64
+	Archived time.Time
+}
+
+// Archive stores jobs in a storage
+type Archive struct {
+	storage Storage
+}
+
+// New builds an archive for the storage
+func New(storage Storage) *Archive {
+	return &Archive{storage: storage}
+}
+
+// Open builds an archive for a location, which is either an S3 URL (s3://<bucket>[/<prefix>], see NewS3Storage) or a
+// directory
+func Open(location string) (*Archive, error) {
+	if strings.HasPrefix(location, "s3://") {
+		storage, err := NewS3Storage(location)
+		if err != nil {
+			return nil, err
+		}
+		return New(storage), nil
+	}
+
+	storage, err := NewDirStorage(location)
+	if err != nil {
+		return nil, err
+	}
+	return New(storage), nil
+}
+
+func jobKey(pipeline string, id uuid.UUID) string {
+	name := url.PathEscape(pipeline)
+	// Prevent hidden files in directories
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return jobKeyPrefix(id) + name + keySuffix
+}
+
+// jobKeyPrefix is the prefix of the key of an archived job, which does not depend on the pipeline
+func jobKeyPrefix(id uuid.UUID) string {
+	return id.String() + "/"
+}
+
+// parseKey returns the entry of an archived job from the key of an object (ok is false for other objects)
+func parseKey(obj Object) (entry Entry, ok bool) {
+	parts := strings.Split(obj.Key, "/")
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], keySuffix) {
+		return Entry{}, false
+	}
+	pipeline, err := url.PathUnescape(strings.TrimSuffix(parts[1], keySuffix))
+	if err != nil {
+		return Entry{}, false
+	}
+	id, err := uuid.FromString(parts[0])
+	if err != nil {
+		return Entry{}, false
+	}
+	return Entry{
+		JobID:    id,
+		Pipeline: pipeline,
+		Size:     obj.Size,
+		Archived: obj.Modified,
+	}, true
+}
+
+// Put archives the job with the logs from the output store, an archived job with the same id is replaced
+func (a *Archive) Put(ctx context.Context, job store.PersistedJob, outputStore taskctl.OutputStore) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	var data bytes.Buffer
+	err := store.WriteData(&data, &store.PersistedData{Jobs: []store.PersistedJob{job}})
+	if err != nil {
+		return err
+	}
+	err = writeFile(tw, dataFilename, data.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var logNames []string
+	for _, t := range job.Tasks {
+		logNames = append(logNames, t.Name)
+	}
+	for _, s := range job.Services {
+		logNames = append(logNames, taskctl.ServiceOutputName(s.Name))
+	}
+	for _, logName := range logNames {
+		for _, outputName := range outputNames {
+			content, ok := readOutput(outputStore, job.ID.String(), logName, outputName)
+			if !ok {
+				continue
+			}
+			err = writeFile(tw, path.Join(logsDirname, url.PathEscape(logName), outputName+".log"), content)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing tar writer")
+	}
+	err = gw.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing gzip writer")
+	}
+
+	return a.storage.Put(ctx, jobKey(job.Pipeline, job.ID), buf.Bytes())
+}
+
+// readOutput returns the output of a task, ok is false if there is no output (e.g. the task was skipped)
+func readOutput(outputStore taskctl.OutputStore, jobID, logName, outputName string) ([]byte, bool) {
+	r, err := outputStore.Reader(jobID, logName, outputName)
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	return content, true
+}
+
+func writeFile(tw *tar.Writer, name string, content []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "writing header of %s", name)
+	}
+	_, err = tw.Write(content)
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	return nil
+}
+
+// List returns all archived jobs, the newest archived job first
+func (a *Archive) List(ctx context.Context) ([]Entry, error) {
+	objects, err := a.storage.List(ctx, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing objects")
+	}
+
+	entries := []Entry{}
+	for _, obj := range objects {
+		if entry, ok := parseKey(obj); ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Archived.After(entries[j].Archived)
+	})
+	return entries, nil
+}
+
+// find returns the archived job with the id or ErrNotFound, it only lists the objects with the key prefix of the job
+func (a *Archive) find(ctx context.Context, id uuid.UUID) (Entry, error) {
+	objects, err := a.storage.List(ctx, jobKeyPrefix(id))
+	if err != nil {
+		return Entry{}, errors.Wrap(err, "listing objects")
+	}
+	for _, obj := range objects {
+		if entry, ok := parseKey(obj); ok && entry.JobID == id {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+// Restore writes the logs of an archived job to the output store and returns the job, it is kept in the archive until
+// it is deleted. ErrNotFound is returned if the job is not archived.
+func (a *Archive) Restore(ctx context.Context, id uuid.UUID, outputStore taskctl.OutputStore) (*store.PersistedJob, error) {
+	entry, err := a.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	content, err := a.storage.Get(ctx, jobKey(entry.Pipeline, entry.JobID))
+	if err != nil {
+		return nil, err
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading gzip")
+	}
+	defer gr.Close()
+
+	var data *store.PersistedData
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "reading tar")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if hdr.Name == dataFilename {
+			data, err = store.ReadData(tr)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading data")
+			}
+			continue
+		}
+
+		logName, outputName, err := parseLogFileName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		err = restoreOutput(tr, outputStore, id.String(), logName, outputName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "restoring %s", hdr.Name)
+		}
+	}
+
+	if data == nil || len(data.Jobs) != 1 || data.Jobs[0].ID != id {
+		return nil, errors.Errorf("archive of job %s does not contain the job", id)
+	}
+	return &data.Jobs[0], nil
+}
+
+// parseLogFileName returns the task (or service output) and output name of a log file in logs/<task>/<output>.log
+func parseLogFileName(name string) (logName string, outputName string, err error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != logsDirname || !strings.HasSuffix(parts[2], ".log") {
+		return "", "", errors.Errorf("unexpected file %q in archive", name)
+	}
+	logName, err = url.PathUnescape(parts[1])
+	if err != nil || logName == "" {
+		return "", "", errors.Errorf("invalid task name in %q", name)
+	}
+	outputName = strings.TrimSuffix(parts[2], ".log")
+	for _, n := range outputNames {
+		if n == outputName {
+			return logName, outputName, nil
+		}
+	}
+	return "", "", errors.Errorf("invalid output name in %q", name)
+}
+
+func restoreOutput(r io.Reader, outputStore taskctl.OutputStore, jobID, logName, outputName string) error {
+	w, err := outputStore.Writer(jobID, logName, outputName)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete removes an archived job, it does not return an error if the job is not archived
+func (a *Archive) Delete(ctx context.Context, id uuid.UUID) error {
+	entry, err := a.find(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return a.storage.Delete(ctx, jobKey(entry.Pipeline, entry.JobID))
+}
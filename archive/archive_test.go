@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+	"github.com/Flowpack/prunner/test"
+)
+
+func writeOutput(t *testing.T, outputStore taskctl.OutputStore, jobID, taskName, outputName, output string) {
+	t.Helper()
+
+	w, err := outputStore.Writer(jobID, taskName, outputName)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(output))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func TestArchive_PutListRestoreDelete(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := NewDirStorage(t.TempDir())
+	require.NoError(t, err)
+	a := New(storage)
+
+	jobID := uuid.Must(uuid.NewV4())
+	job := store.PersistedJob{
+		ID:        jobID,
+		Pipeline:  "deploy/production",
+		Completed: true,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Tasks: []store.PersistedTask{
+			{Name: "build", Status: "done"},
+			{Name: "deploy", Status: "done"},
+			{Name: "notify", Status: "skipped", Skipped: true},
+		},
+	}
+
+	outputStore := test.NewMockOutputStore()
+	writeOutput(t, outputStore, jobID.String(), "build", "stdout", "compiled")
+	writeOutput(t, outputStore, jobID.String(), "build", "stderr", "")
+	writeOutput(t, outputStore, jobID.String(), "deploy", "stdout", "deployed")
+
+	require.NoError(t, a.Put(ctx, job, outputStore))
+
+	otherJobID := uuid.Must(uuid.NewV4())
+	require.NoError(t, a.Put(ctx, store.PersistedJob{ID: otherJobID, Pipeline: "deploy", Completed: true}, outputStore))
+
+	entries, err := a.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		if entry.JobID == otherJobID {
+			assert.Equal(t, "deploy", entry.Pipeline)
+		}
+	}
+
+	require.NoError(t, a.Delete(ctx, otherJobID))
+	entries, err = a.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, jobID, entries[0].JobID)
+	assert.Equal(t, "deploy/production", entries[0].Pipeline)
+	assert.Greater(t, entries[0].Size, int64(0))
+
+	restoredOutputStore := test.NewMockOutputStore()
+	restoredJob, err := a.Restore(ctx, jobID, restoredOutputStore)
+	require.NoError(t, err)
+	assert.Equal(t, job, *restoredJob)
+	assert.Equal(t, "compiled", string(restoredOutputStore.GetBytes(jobID.String(), "build", "stdout")))
+	assert.Equal(t, "deployed", string(restoredOutputStore.GetBytes(jobID.String(), "deploy", "stdout")))
+	assert.Nil(t, restoredOutputStore.GetBytes(jobID.String(), "notify", "stdout"), "skipped task has no output")
+
+	_, err = a.Restore(ctx, uuid.Must(uuid.NewV4()), restoredOutputStore)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, a.Delete(ctx, jobID))
+	entries, err = a.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Deleting a job that is not archived is not an error
+	require.NoError(t, a.Delete(ctx, jobID))
+}
+
+func TestJobKey(t *testing.T) {
+	id := uuid.Must(uuid.FromString("52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8"))
+
+	for pipeline, expectedKey := range map[string]string{
+		"deploy":            "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8/deploy.tar.gz",
+		"deploy/production": "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8/deploy%2Fproduction.tar.gz",
+		"..":                "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8/%2E..tar.gz",
+	} {
+		key := jobKey(pipeline, id)
+		assert.Equal(t, expectedKey, key)
+
+		entry, ok := parseKey(Object{Key: key})
+		require.True(t, ok, key)
+		assert.Equal(t, pipeline, entry.Pipeline)
+		assert.Equal(t, id, entry.JobID)
+	}
+
+	for _, key := range []string{"52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8/other.txt", "deploy/52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8.tar.gz"} {
+		_, ok := parseKey(Object{Key: key})
+		assert.False(t, ok, key)
+	}
+}
+
+func TestParseLogFileName(t *testing.T) {
+	logName, outputName, err := parseLogFileName("logs/build%2Fall/stderr.log")
+	require.NoError(t, err)
+	assert.Equal(t, "build/all", logName)
+	assert.Equal(t, "stderr", outputName)
+
+	for _, name := range []string{"logs/build/stdout.txt", "logs/build/other.log", "other/build/stdout.log", "logs//stdout.log"} {
+		_, _, err := parseLogFileName(name)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// DirStorage stores objects as files in a directory (e.g. a mounted network share)
+type DirStorage struct {
+	path string
+}
+
+var _ Storage = &DirStorage{}
+
+// NewDirStorage builds a storage for the directory, it is created if it does not exist
+func NewDirStorage(path string) (*DirStorage, error) {
+	err := os.MkdirAll(path, 0777)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating archive directory")
+	}
+	return &DirStorage{path: path}, nil
+}
+
+// Put writes the object to a temporary file first, so a partially written object is never listed
+func (d *DirStorage) Put(_ context.Context, key string, data []byte) error {
+	filename := filepath.Join(d.path, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(filename), 0777)
+	if err != nil {
+		return errors.Wrap(err, "creating directory")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errors.Wrap(err, "writing temporary file")
+	}
+
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errors.Wrap(err, "renaming temporary file")
+	}
+	return nil
+}
+
+func (d *DirStorage) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.path, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading file")
+	}
+	return data, nil
+}
+
+// List only walks the directory of the prefix (up to its last slash)
+func (d *DirStorage) List(_ context.Context, prefix string) ([]Object, error) {
+	root := d.path
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = filepath.Join(d.path, filepath.FromSlash(prefix[:i]))
+	}
+
+	var objects []Object
+	err := filepath.WalkDir(root, func(filename string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && filename == root {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || filepath.Base(filename)[0] == '.' {
+			return nil
+		}
+		key, err := filepath.Rel(d.path, filename)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{
+			Key:      key,
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "walking archive directory")
+	}
+	return objects, nil
+}
+
+func (d *DirStorage) Delete(_ context.Context, key string) error {
+	err := os.Remove(filepath.Join(d.path, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "removing file")
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Package backup writes and restores backups of the runner state as gzipped tarballs.
+//
+// A backup contains the persisted job data as data.json and the log files of jobs in logs/<jobID>/ (the same layout as
+// the data directory), so it can also be inspected or restored manually.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/store"
+)
+
+const (
+	dataFilename = "data.json"
+	logsDirname  = "logs"
+)
+
+// Options select the logs that are included in a backup
+type Options struct {
+	// SkipLogs excludes all logs from the backup
+	SkipLogs bool
+	// Pipelines only includes logs of jobs of the given pipelines (all if empty), the job data is always included
+	Pipelines []string
+}
+
+func (o Options) includeLogs(pipeline string) bool {
+	if o.SkipLogs {
+		return false
+	}
+	if len(o.Pipelines) == 0 {
+		return true
+	}
+	for _, p := range o.Pipelines {
+		if p == pipeline {
+			return true
+		}
+	}
+	return false
+}
+
+// Result describes the contents of a backup
+type Result struct {
+	Jobs     int
+	LogFiles int
+}
+
+// Write writes a backup of the data and the selected logs from logsDir to w.
+//
+// The data should be a consistent snapshot (see prunner.PipelineRunner.Snapshot or store.JsonDataStore.Load). Log files
+// of running jobs only grow, so they are included with the size they had when the backup reached them.
+func Write(w io.Writer, data *store.PersistedData, logsDir string, opts Options) (Result, error) {
+	var result Result
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	var buf bytes.Buffer
+	err := store.WriteData(&buf, data)
+	if err != nil {
+		return result, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    dataFilename,
+		Mode:    0644,
+		Size:    int64(buf.Len()),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "writing data header")
+	}
+	_, err = io.Copy(tw, &buf)
+	if err != nil {
+		return result, errors.Wrap(err, "writing data")
+	}
+	result.Jobs = len(data.Jobs)
+
+	for _, job := range data.Jobs {
+		if !opts.includeLogs(job.Pipeline) {
+			continue
+		}
+		n, err := writeJobLogs(tw, logsDir, job.ID.String())
+		if err != nil {
+			return result, errors.Wrapf(err, "writing logs of job %s", job.ID)
+		}
+		result.LogFiles += n
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return result, errors.Wrap(err, "closing tar writer")
+	}
+	err = gw.Close()
+	if err != nil {
+		return result, errors.Wrap(err, "closing gzip writer")
+	}
+
+	return result, nil
+}
+
+func writeJobLogs(tw *tar.Writer, logsDir string, jobID string) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(logsDir, jobID))
+	if errors.Is(err, os.ErrNotExist) {
+		// Jobs that were never started have no logs
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "reading log directory")
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		err := writeLogFile(tw, filepath.Join(logsDir, jobID, entry.Name()), path.Join(logsDirname, jobID, entry.Name()))
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func writeLogFile(tw *tar.Writer, filename string, name string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "opening log file")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "reading log file info")
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "writing log file header")
+	}
+	// Only copy the size from the header, the log could still be written to
+	_, err = io.CopyN(tw, f, info.Size())
+	if err != nil {
+		return errors.Wrap(err, "writing log file")
+	}
+	return nil
+}
+
+// Restore extracts a backup from r into dataDir, existing log files of the same jobs are overwritten.
+//
+// The data file is written last and atomically, so an interrupted restore does not leave partial job data.
+// Restore must not be used while prunner is running on dataDir, it would overwrite the restored data on the next save.
+func Restore(r io.Reader, dataDir string) (Result, error) {
+	var result Result
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return result, errors.Wrap(err, "reading gzip")
+	}
+	defer gr.Close()
+
+	var data *store.PersistedData
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return result, errors.Wrap(err, "reading tar")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if hdr.Name == dataFilename {
+			data, err = store.ReadData(tr)
+			if err != nil {
+				return result, errors.Wrap(err, "reading data")
+			}
+			continue
+		}
+
+		jobID, filename, err := parseLogFileName(hdr.Name)
+		if err != nil {
+			return result, err
+		}
+		err = restoreLogFile(tr, filepath.Join(dataDir, logsDirname, jobID), filename)
+		if err != nil {
+			return result, errors.Wrapf(err, "restoring %s", hdr.Name)
+		}
+		result.LogFiles++
+	}
+
+	if data == nil {
+		return result, errors.Errorf("backup does not contain %s", dataFilename)
+	}
+
+	dataStore, err := store.NewJSONDataStore(dataDir)
+	if err != nil {
+		return result, errors.Wrap(err, "building data store")
+	}
+	err = dataStore.Save(data)
+	if err != nil {
+		return result, errors.Wrap(err, "saving data")
+	}
+	result.Jobs = len(data.Jobs)
+
+	return result, nil
+}
+
+// parseLogFileName checks that the name of a tar entry is a log file in logs/<jobID>/<filename> to prevent writing
+// files outside the data directory
+func parseLogFileName(name string) (jobID string, filename string, err error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != logsDirname {
+		return "", "", errors.Errorf("unexpected file %q in backup", name)
+	}
+	if _, err := uuid.FromString(parts[1]); err != nil {
+		return "", "", errors.Errorf("invalid job id in %q", name)
+	}
+	if parts[2] == "" || parts[2] == "." || parts[2] == ".." || strings.Contains(parts[2], `\`) {
+		return "", "", errors.Errorf("invalid log filename in %q", name)
+	}
+	return parts[1], parts[2], nil
+}
+
+func restoreLogFile(r io.Reader, dir string, filename string) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return errors.Wrap(err, "creating job logs directory")
+	}
+	f, err := os.Create(filepath.Join(dir, filename))
+	if err != nil {
+		return errors.Wrap(err, "creating log file")
+	}
+	_, err = io.Copy(f, r)
+	// In any case close the file
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "writing log file")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner/store"
+)
+
+func TestWriteAndRestore(t *testing.T) {
+	logsDir := filepath.Join(t.TempDir(), "logs")
+
+	deployJobID := uuid.Must(uuid.NewV4())
+	cleanupJobID := uuid.Must(uuid.NewV4())
+	writeFile(t, filepath.Join(logsDir, deployJobID.String(), "deploy-stdout.log"), "deployed")
+	writeFile(t, filepath.Join(logsDir, deployJobID.String(), "deploy-stderr.log"), "")
+	writeFile(t, filepath.Join(logsDir, cleanupJobID.String(), "cleanup-stdout.log"), "cleaned up")
+
+	data := &store.PersistedData{
+		Jobs: []store.PersistedJob{
+			{ID: deployJobID, Pipeline: "deploy", Created: time.Now().UTC().Truncate(time.Second), Completed: true},
+			{ID: cleanupJobID, Pipeline: "cleanup", Created: time.Now().UTC().Truncate(time.Second)},
+		},
+	}
+
+	var buf bytes.Buffer
+	result, err := Write(&buf, data, logsDir, Options{Pipelines: []string{"deploy"}})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Jobs: 2, LogFiles: 2}, result)
+
+	dataDir := t.TempDir()
+	result, err = Restore(&buf, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Jobs: 2, LogFiles: 2}, result)
+
+	dataStore, err := store.NewJSONDataStore(dataDir)
+	require.NoError(t, err)
+	restoredData, err := dataStore.Load()
+	require.NoError(t, err)
+	assert.Equal(t, data.Jobs, restoredData.Jobs)
+
+	content, err := os.ReadFile(filepath.Join(dataDir, "logs", deployJobID.String(), "deploy-stdout.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "deployed", string(content))
+	assert.NoFileExists(t, filepath.Join(dataDir, "logs", cleanupJobID.String(), "cleanup-stdout.log"), "logs of other pipelines are not included")
+}
+
+func TestWrite_SkipLogs(t *testing.T) {
+	logsDir := filepath.Join(t.TempDir(), "logs")
+
+	jobID := uuid.Must(uuid.NewV4())
+	writeFile(t, filepath.Join(logsDir, jobID.String(), "deploy-stdout.log"), "deployed")
+
+	var buf bytes.Buffer
+	result, err := Write(&buf, &store.PersistedData{Jobs: []store.PersistedJob{{ID: jobID, Pipeline: "deploy"}}}, logsDir, Options{SkipLogs: true})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Jobs: 1}, result)
+}
+
+func TestRestore_RejectsFilesOutsideOfDataDir(t *testing.T) {
+	for _, name := range []string{
+		"../data.json",
+		"logs/../../evil.log",
+		"logs/" + uuid.Must(uuid.NewV4()).String() + "/../../evil.log",
+		"other/file.txt",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gw)
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 4, Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte("evil"))
+			require.NoError(t, err)
+			require.NoError(t, tw.Close())
+			require.NoError(t, gw.Close())
+
+			_, err = Restore(&buf, t.TempDir())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func writeFile(t *testing.T, filename string, content string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0777))
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+}
@@ -0,0 +1,87 @@
+package condition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpr_Evaluate(t *testing.T) {
+	vars := map[string]interface{}{
+		"env":      "production",
+		"replicas": float64(3),
+		"count":    "12",
+		"dry_run":  false,
+		"tags":     []interface{}{"a"},
+		"empty":    "",
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`vars.env == "production"`, true},
+		{`vars.env == 'staging'`, false},
+		{`vars.env != "staging"`, true},
+		{`vars.replicas > 1`, true},
+		{`vars.replicas <= 2`, false},
+		{`vars.count >= 12`, true},
+		{`vars.count < 9`, false},
+		{`vars.env == "production" && vars.replicas >= 3`, true},
+		{`vars.env == "staging" || vars.replicas == 3`, true},
+		{`!vars.dry_run`, true},
+		{`vars.dry_run == false`, true},
+		{`vars.tags`, true},
+		{`vars.empty`, false},
+		{`vars.missing`, false},
+		{`vars.missing == null`, true},
+		{`vars.missing > 1`, false},
+		{`!(vars.env == "production" && vars.dry_run)`, true},
+		{`vars.replicas == -1`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Parse(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, expr.Evaluate(vars))
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, source := range []string{
+		``,
+		`env == "production"`,
+		`vars.env == "production`,
+		`vars.env = "production"`,
+		`(vars.env == "production"`,
+		`vars.env == "production")`,
+		`vars.env ==`,
+		`vars.env == $FOO`,
+	} {
+		t.Run(source, func(t *testing.T) {
+			_, err := Parse(source)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpr_Variables(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{`vars.env == "production"`, []string{"env"}},
+		{`vars.env == "production" && (vars.replicas > 1 || !vars.dry_run) && vars.env != "staging"`, []string{"env", "replicas", "dry_run"}},
+		{`false`, nil},
+		{`!(1 > 2)`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Parse(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, expr.Variables())
+		})
+	}
+}
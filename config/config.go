@@ -1,7 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/friendsofgo/errors"
@@ -12,6 +17,85 @@ import (
 
 type Config struct {
 	JWTSecret string `yaml:"jwt_secret"`
+
+	ServerConfig `yaml:",inline"`
+}
+
+// ServerConfig contains optional server settings. The keys are the names of the global flags (with underscores instead
+// of dashes) and are only used if the flag is not set on the command line or via environment variables.
+type ServerConfig struct {
+	Verbose         *bool `yaml:"verbose,omitempty"`
+	EnableProfiling *bool `yaml:"enable_profiling,omitempty"`
+	DisableAnsi     *bool `yaml:"disable_ansi,omitempty"`
+
+	LogLevel  *string `yaml:"log_level,omitempty"`
+	LogFormat *string `yaml:"log_format,omitempty"`
+
+	Address      *string `yaml:"address,omitempty"`
+	AdminAddress *string `yaml:"admin_address,omitempty"`
+	AdminScope   *string `yaml:"admin_scope,omitempty"`
+	PIDFile      *string `yaml:"pid_file,omitempty"`
+	Data         *string `yaml:"data,omitempty"`
+	Path         *string `yaml:"path,omitempty"`
+	Pattern      *string `yaml:"pattern,omitempty"`
+
+	DuplicatePipelines *string `yaml:"duplicate_pipelines,omitempty"`
+
+	DefinitionsURL          *string        `yaml:"definitions_url,omitempty"`
+	DefinitionsPublicKey    *string        `yaml:"definitions_public_key,omitempty"`
+	DefinitionsPollInterval *time.Duration `yaml:"definitions_poll_interval,omitempty"`
+
+	CompressionLevel *int   `yaml:"compression_level,omitempty"`
+	MaxBodySize      *int64 `yaml:"max_body_size,omitempty"`
+
+	Watch        *bool          `yaml:"watch,omitempty"`
+	PollInterval *time.Duration `yaml:"poll_interval,omitempty"`
+
+	MaxLoadAverage    *float64       `yaml:"max_load_average,omitempty"`
+	MaxMemoryUsage    *float64       `yaml:"max_memory_usage,omitempty"`
+	MaxMemoryPressure *float64       `yaml:"max_memory_pressure,omitempty"`
+	MaxCPUUsage       *float64       `yaml:"max_cpu_usage,omitempty"`
+	MinFreeDiskSpace  *uint64        `yaml:"min_free_disk_space,omitempty"`
+	LoadCheckInterval *time.Duration `yaml:"load_check_interval,omitempty"`
+
+	PersistInterval   *time.Duration `yaml:"persist_interval,omitempty"`
+	FlushOnCompletion *bool          `yaml:"flush_on_completion,omitempty"`
+	MaxCachedJobs     *int           `yaml:"max_cached_jobs,omitempty"`
+	Workers           *int           `yaml:"workers,omitempty"`
+	LiveOutputLines   *int           `yaml:"live_output_lines,omitempty"`
+	Timezone          *string        `yaml:"timezone,omitempty"`
+
+	OnScheduleHook *string        `yaml:"on_schedule_hook,omitempty"`
+	OnCompleteHook *string        `yaml:"on_complete_hook,omitempty"`
+	HookTimeout    *time.Duration `yaml:"hook_timeout,omitempty"`
+	HookRetries    *int           `yaml:"hook_retries,omitempty"`
+	HookRetryDelay *time.Duration `yaml:"hook_retry_delay,omitempty"`
+	OnAlertHook    *string        `yaml:"on_alert_hook,omitempty"`
+
+	AlertCheckInterval *time.Duration `yaml:"alert_check_interval,omitempty"`
+
+	Archive              *string        `yaml:"archive,omitempty"`
+	ArchiveAfter         *time.Duration `yaml:"archive_after,omitempty"`
+	ArchiveCheckInterval *time.Duration `yaml:"archive_check_interval,omitempty"`
+
+	NATSURL           *string `yaml:"nats_url,omitempty"`
+	NATSSubjectPrefix *string `yaml:"nats_subject_prefix,omitempty"`
+
+	StatsDAddress *string `yaml:"statsd_address,omitempty"`
+	StatsDPrefix  *string `yaml:"statsd_prefix,omitempty"`
+	StatsDTags    *string `yaml:"statsd_tags,omitempty"`
+
+	SentryDSN          *string `yaml:"sentry_dsn,omitempty"`
+	SentryEnvironment  *string `yaml:"sentry_environment,omitempty"`
+	SentryTaskFailures *bool   `yaml:"sentry_task_failures,omitempty"`
+	SentryOutputLines  *int    `yaml:"sentry_output_lines,omitempty"`
+
+	RedisURL   *string `yaml:"redis_url,omitempty"`
+	RedisQueue *string `yaml:"redis_queue,omitempty"`
+
+	SharedStateURL    *string `yaml:"shared_state_url,omitempty"`
+	SharedStatePrefix *string `yaml:"shared_state_prefix,omitempty"`
+	InstanceID        *string `yaml:"instance_id,omitempty"`
 }
 
 var ErrMissingJWTSecret = errors.New("missing jwt_secret")
@@ -28,19 +112,132 @@ func (c Config) validate() error {
 	return nil
 }
 
-func LoadOrCreateConfig(configPath string, cliConfig Config) (*Config, error) {
-	if err := cliConfig.validate(); err == nil {
-		log.Debug("Using config from CLI")
-		return &cliConfig, nil
-	} else if err != ErrMissingJWTSecret {
-		return nil, errors.Wrap(err, "invalid CLI config")
+func (c ServerConfig) validate() error {
+	if c.LogLevel != nil {
+		if _, err := log.ParseLevel(*c.LogLevel); err != nil {
+			return errors.Errorf("log_level: must be debug, info, warn or error, got %q", *c.LogLevel)
+		}
+	}
+	if c.LogFormat != nil && *c.LogFormat != "text" && *c.LogFormat != "logfmt" && *c.LogFormat != "json" {
+		return errors.Errorf("log_format: must be text, logfmt or json, got %q", *c.LogFormat)
+	}
+	if c.Address != nil && *c.Address == "" {
+		return errors.New("address: must not be empty")
+	}
+	if c.CompressionLevel != nil && (*c.CompressionLevel < 0 || *c.CompressionLevel > 9) {
+		return errors.Errorf("compression_level: must be between 0 and 9, got %d", *c.CompressionLevel)
+	}
+	if c.MaxBodySize != nil && *c.MaxBodySize <= 0 {
+		return errors.Errorf("max_body_size: must be positive, got %d", *c.MaxBodySize)
+	}
+	if c.Data != nil && *c.Data == "" {
+		return errors.New("data: must not be empty")
+	}
+	if c.Pattern != nil && *c.Pattern == "" {
+		return errors.New("pattern: must not be empty")
+	}
+	if c.DuplicatePipelines != nil && *c.DuplicatePipelines != "error" && *c.DuplicatePipelines != "first_wins" && *c.DuplicatePipelines != "override" {
+		return errors.Errorf("duplicate_pipelines: must be error, first_wins or override, got %q", *c.DuplicatePipelines)
+	}
+	if c.NATSSubjectPrefix != nil && *c.NATSSubjectPrefix == "" {
+		return errors.New("nats_subject_prefix: must not be empty")
+	}
+	if c.RedisQueue != nil && *c.RedisQueue == "" {
+		return errors.New("redis_queue: must not be empty")
+	}
+	if c.SharedStatePrefix != nil && *c.SharedStatePrefix == "" {
+		return errors.New("shared_state_prefix: must not be empty")
+	}
+	if c.Timezone != nil {
+		if _, err := time.LoadLocation(*c.Timezone); err != nil {
+			return errors.Errorf("timezone: %v", err)
+		}
+	}
+	if c.PollInterval != nil && *c.PollInterval <= 0 {
+		return errors.Errorf("poll_interval: must be positive, got %s", *c.PollInterval)
+	}
+	if c.DefinitionsPollInterval != nil && *c.DefinitionsPollInterval <= 0 {
+		return errors.Errorf("definitions_poll_interval: must be positive, got %s", *c.DefinitionsPollInterval)
+	}
+	if c.LoadCheckInterval != nil && *c.LoadCheckInterval <= 0 {
+		return errors.Errorf("load_check_interval: must be positive, got %s", *c.LoadCheckInterval)
+	}
+	if c.PersistInterval != nil && *c.PersistInterval <= 0 {
+		return errors.Errorf("persist_interval: must be positive, got %s", *c.PersistInterval)
+	}
+	if c.HookTimeout != nil && *c.HookTimeout <= 0 {
+		return errors.Errorf("hook_timeout: must be positive, got %s", *c.HookTimeout)
+	}
+	if c.AlertCheckInterval != nil && *c.AlertCheckInterval <= 0 {
+		return errors.Errorf("alert_check_interval: must be positive, got %s", *c.AlertCheckInterval)
+	}
+	if c.ArchiveAfter != nil && *c.ArchiveAfter <= 0 {
+		return errors.Errorf("archive_after: must be positive, got %s", *c.ArchiveAfter)
+	}
+	if c.ArchiveCheckInterval != nil && *c.ArchiveCheckInterval <= 0 {
+		return errors.Errorf("archive_check_interval: must be positive, got %s", *c.ArchiveCheckInterval)
+	}
+	if c.HookRetries != nil && *c.HookRetries < 0 {
+		return errors.Errorf("hook_retries: must not be negative, got %d", *c.HookRetries)
+	}
+	if c.HookRetryDelay != nil && *c.HookRetryDelay < 0 {
+		return errors.Errorf("hook_retry_delay: must not be negative, got %s", *c.HookRetryDelay)
+	}
+	if c.MaxCachedJobs != nil && *c.MaxCachedJobs < 0 {
+		return errors.Errorf("max_cached_jobs: must not be negative, got %d", *c.MaxCachedJobs)
+	}
+	if c.Workers != nil && *c.Workers < 0 {
+		return errors.Errorf("workers: must not be negative, got %d", *c.Workers)
+	}
+	if c.SentryOutputLines != nil && *c.SentryOutputLines < 0 {
+		return errors.Errorf("sentry_output_lines: must not be negative, got %d", *c.SentryOutputLines)
+	}
+	if c.LiveOutputLines != nil && *c.LiveOutputLines < 0 {
+		return errors.Errorf("live_output_lines: must not be negative, got %d", *c.LiveOutputLines)
+	}
+	if c.MaxLoadAverage != nil && *c.MaxLoadAverage < 0 {
+		return errors.Errorf("max_load_average: must not be negative, got %g", *c.MaxLoadAverage)
+	}
+	for _, percentage := range []struct {
+		key   string
+		value *float64
+	}{
+		{"max_memory_usage", c.MaxMemoryUsage},
+		{"max_memory_pressure", c.MaxMemoryPressure},
+		{"max_cpu_usage", c.MaxCPUUsage},
+	} {
+		if percentage.value != nil && (*percentage.value < 0 || *percentage.value > 100) {
+			return errors.Errorf("%s: must be a percentage between 0 and 100, got %g", percentage.key, *percentage.value)
+		}
 	}
 
-	log.Debugf("Reading config from %s", configPath)
+	return nil
+}
+
+// Settings returns the server settings that are set as a map of flag names to values
+func (c ServerConfig) Settings() map[string]string {
+	settings := make(map[string]string)
+
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.IsNil() {
+			continue
+		}
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		flagName := strings.ReplaceAll(key, "_", "-")
+		settings[flagName] = fmt.Sprint(field.Elem().Interface())
+	}
+
+	return settings
+}
+
+// LoadConfigFile reads and validates a config file, it returns nil if the file does not exist.
+// Unknown keys and invalid values are reported with the key (and line if available).
+func LoadConfigFile(configPath string) (*Config, error) {
 	f, err := os.Open(configPath)
 	if os.IsNotExist(err) {
-		log.Infof("No config found, creating file at %s", configPath)
-		return createDefaultConfig(configPath)
+		return nil, nil
 	} else if err != nil {
 		return nil, errors.Wrap(err, "opening config file")
 	}
@@ -48,14 +245,43 @@ func LoadOrCreateConfig(configPath string, cliConfig Config) (*Config, error) {
 
 	c := new(Config)
 
-	err = yaml.NewDecoder(f).Decode(c)
+	decoder := yaml.NewDecoder(f)
+	decoder.SetStrict(true)
+	err = decoder.Decode(c)
+	// An empty file is a valid (empty) config
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "decoding config file %s", configPath)
+	}
+
+	err = c.ServerConfig.validate()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid config file %s", configPath)
+	}
+
+	return c, nil
+}
+
+func LoadOrCreateConfig(configPath string, cliConfig Config) (*Config, error) {
+	if err := cliConfig.validate(); err == nil {
+		log.Debug("Using config from CLI")
+		return &cliConfig, nil
+	} else if err != ErrMissingJWTSecret {
+		return nil, errors.Wrap(err, "invalid CLI config")
+	}
+
+	log.Debugf("Reading config from %s", configPath)
+	c, err := LoadConfigFile(configPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "decoding config")
+		return nil, err
+	}
+	if c == nil {
+		log.Infof("No config found, creating file at %s", configPath)
+		return createDefaultConfig(configPath)
 	}
 
 	err = c.validate()
 	if err != nil {
-		return nil, errors.Wrap(err, "invalid config")
+		return nil, errors.Wrapf(err, "invalid config file %s", configPath)
 	}
 
 	return c, nil
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	configPath := writeConfigFile(t, `
+jwt_secret: a-very-secret-secret
+address: ":9009"
+watch: true
+poll_interval: 1m
+max_cpu_usage: 90
+min_free_disk_space: 512
+`)
+
+	c, err := LoadConfigFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "a-very-secret-secret", c.JWTSecret)
+	assert.Equal(t, map[string]string{
+		"address":             ":9009",
+		"watch":               "true",
+		"poll-interval":       "1m0s",
+		"max-cpu-usage":       "90",
+		"min-free-disk-space": "512",
+	}, c.ServerConfig.Settings())
+}
+
+func TestLoadConfigFile_NotExisting(t *testing.T) {
+	c, err := LoadConfigFile(filepath.Join(t.TempDir(), ".prunner.yml"))
+	require.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestLoadConfigFile_Empty(t *testing.T) {
+	c, err := LoadConfigFile(writeConfigFile(t, ""))
+	require.NoError(t, err)
+	assert.Empty(t, c.ServerConfig.Settings())
+}
+
+func TestLoadConfigFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{
+			name:        "unknown key",
+			config:      "jwt_secret: a-very-secret-secret\nadress: \":9009\"\n",
+			expectedErr: "line 2: field adress not found",
+		},
+		{
+			name:        "invalid duration",
+			config:      "poll_interval: often\n",
+			expectedErr: "line 1: cannot unmarshal !!str `often`",
+		},
+		{
+			name:        "invalid percentage",
+			config:      "max_memory_usage: 120\n",
+			expectedErr: "max_memory_usage: must be a percentage between 0 and 100, got 120",
+		},
+		{
+			name:        "negative persist interval",
+			config:      "persist_interval: -1s\n",
+			expectedErr: "persist_interval: must be positive, got -1s",
+		},
+		{
+			name:        "zero hook timeout",
+			config:      "hook_timeout: 0s\n",
+			expectedErr: "hook_timeout: must be positive, got 0s",
+		},
+		{
+			name:        "zero alert check interval",
+			config:      "alert_check_interval: 0s\n",
+			expectedErr: "alert_check_interval: must be positive, got 0s",
+		},
+		{
+			name:        "zero archive after",
+			config:      "archive_after: 0s\n",
+			expectedErr: "archive_after: must be positive, got 0s",
+		},
+		{
+			name:        "negative hook retries",
+			config:      "hook_retries: -1\n",
+			expectedErr: "hook_retries: must not be negative, got -1",
+		},
+		{
+			name:        "negative max cached jobs",
+			config:      "max_cached_jobs: -1\n",
+			expectedErr: "max_cached_jobs: must not be negative, got -1",
+		},
+		{
+			name:        "negative workers",
+			config:      "workers: -1\n",
+			expectedErr: "workers: must not be negative, got -1",
+		},
+		{
+			name:        "negative live output lines",
+			config:      "live_output_lines: -1\n",
+			expectedErr: "live_output_lines: must not be negative, got -1",
+		},
+		{
+			name:        "negative Sentry output lines",
+			config:      "sentry_output_lines: -1\n",
+			expectedErr: "sentry_output_lines: must not be negative, got -1",
+		},
+		{
+			name:        "unknown timezone",
+			config:      "timezone: Mars/Olympus\n",
+			expectedErr: "timezone: unknown time zone Mars/Olympus",
+		},
+		{
+			name:        "empty NATS subject prefix",
+			config:      "nats_subject_prefix: \"\"\n",
+			expectedErr: "nats_subject_prefix: must not be empty",
+		},
+		{
+			name:        "empty Redis queue",
+			config:      "redis_queue: \"\"\n",
+			expectedErr: "redis_queue: must not be empty",
+		},
+		{
+			name:        "empty shared state prefix",
+			config:      "shared_state_prefix: \"\"\n",
+			expectedErr: "shared_state_prefix: must not be empty",
+		},
+		{
+			name:        "unknown log level",
+			config:      "log_level: verbose\n",
+			expectedErr: "log_level: must be debug, info, warn or error, got \"verbose\"",
+		},
+		{
+			name:        "unknown log format",
+			config:      "log_format: xml\n",
+			expectedErr: "log_format: must be text, logfmt or json, got \"xml\"",
+		},
+		{
+			name:        "unknown duplicate pipelines policy",
+			config:      "duplicate_pipelines: last_wins\n",
+			expectedErr: "duplicate_pipelines: must be error, first_wins or override, got \"last_wins\"",
+		},
+		{
+			name:        "non-positive definitions poll interval",
+			config:      "definitions_poll_interval: 0s\n",
+			expectedErr: "definitions_poll_interval: must be positive, got 0s",
+		},
+		{
+			name:        "empty address",
+			config:      "address: \"\"\n",
+			expectedErr: "address: must not be empty",
+		},
+		{
+			name:        "compression level out of range",
+			config:      "compression_level: 10\n",
+			expectedErr: "compression_level: must be between 0 and 9, got 10",
+		},
+		{
+			name:        "max body size not positive",
+			config:      "max_body_size: 0\n",
+			expectedErr: "max_body_size: must be positive, got 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfigFile(t, tt.config))
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	configPath := filepath.Join(t.TempDir(), ".prunner.yml")
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	return configPath
+}
@@ -0,0 +1,65 @@
+package definition
+
+import (
+	"sort"
+)
+
+// findDependencyCycle returns the tasks of a cycle in depends_on starting and ending with the same task, each task
+// depends on the next one (e.g. build, test, build). It returns nil if the tasks have no cycle. The search is deterministic, so the same cycle is
+// reported for the same definition.
+func findDependencyCycle(tasks map[string]TaskDef) []string {
+	taskNames := make([]string, 0, len(tasks))
+	for taskName := range tasks {
+		taskNames = append(taskNames, taskName)
+	}
+	sort.Strings(taskNames)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(tasks))
+	// path contains the tasks that are currently visited, each task depends on the next one
+	var path []string
+
+	var visit func(taskName string) []string
+	visit = func(taskName string) []string {
+		state[taskName] = visiting
+		path = append(path, taskName)
+
+		for _, dependency := range tasks[taskName].DependsOn {
+			switch state[dependency] {
+			case visiting:
+				for i, t := range path {
+					if t == dependency {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dependency)
+					}
+				}
+			case unvisited:
+				// Missing tasks are reported by validation
+				if _, exists := tasks[dependency]; !exists {
+					continue
+				}
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[taskName] = visited
+		return nil
+	}
+
+	for _, taskName := range taskNames {
+		if state[taskName] != unvisited {
+			continue
+		}
+		if cycle := visit(taskName); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
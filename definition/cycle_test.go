@@ -0,0 +1,23 @@
+package definition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDependencyCycle(t *testing.T) {
+	require.Nil(t, findDependencyCycle(map[string]TaskDef{
+		"a": {},
+		"b": {DependsOn: []string{"a"}},
+		"c": {DependsOn: []string{"a", "b"}},
+	}))
+	require.Equal(t, []string{"a", "a"}, findDependencyCycle(map[string]TaskDef{
+		"a": {DependsOn: []string{"a"}},
+	}))
+	require.Equal(t, []string{"b", "c", "b"}, findDependencyCycle(map[string]TaskDef{
+		"a": {DependsOn: []string{"b"}},
+		"b": {DependsOn: []string{"c"}},
+		"c": {DependsOn: []string{"b"}},
+	}))
+}
@@ -0,0 +1,222 @@
+package definition
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fingerprint contains all settings of a pipeline that affect the execution of a job
+type fingerprint struct {
+	Env           map[string]string          `json:",omitempty"`
+	PriorityClass PriorityClass              `json:",omitempty"`
+	Interpreter   Interpreter                `json:",omitempty"`
+	Git           *GitCheckoutDef            `json:",omitempty"`
+	Contexts      map[string]ContextDef      `json:",omitempty"`
+	Services      map[string]ServiceDef      `json:",omitempty"`
+	Tasks         map[string]taskFingerprint `json:",omitempty"`
+}
+
+type taskFingerprint struct {
+	Script          []string          `json:",omitempty"`
+	DependsOn       []string          `json:",omitempty"`
+	AllowFailure    bool              `json:",omitempty"`
+	When            string            `json:",omitempty"`
+	ChangedPaths    *ChangedPathsDef  `json:",omitempty"`
+	Context         string            `json:",omitempty"`
+	Env             map[string]string `json:",omitempty"`
+	Interpreter     Interpreter       `json:",omitempty"`
+	Dir             string            `json:",omitempty"`
+	Timeout         time.Duration     `json:",omitempty"`
+	Retries         int               `json:",omitempty"`
+	Agent           string            `json:",omitempty"`
+	NoOutputTimeout time.Duration     `json:",omitempty"`
+	NoOutputAction  NoOutputAction    `json:",omitempty"`
+	Interactive     bool              `json:",omitempty"`
+}
+
+// Hash returns a short hash of all settings of the pipeline that affect the execution of a job (environment, priority
+// class, interpreter and tasks). It can be used as a version to detect changes of the definition.
+func (d PipelineDef) Hash() string {
+	f := fingerprint{
+		Env:           d.Env,
+		PriorityClass: d.PriorityClass,
+		Interpreter:   d.Interpreter,
+		Git:           d.Git,
+		Contexts:      d.Contexts,
+		Services:      d.Services,
+		Tasks:         make(map[string]taskFingerprint, len(d.Tasks)),
+	}
+	for taskName, taskDef := range d.Tasks {
+		dependsOn := append([]string(nil), taskDef.DependsOn...)
+		sort.Strings(dependsOn)
+
+		f.Tasks[taskName] = taskFingerprint{
+			Script:          taskDef.Script,
+			DependsOn:       dependsOn,
+			AllowFailure:    taskDef.AllowFailure,
+			When:            taskDef.When,
+			ChangedPaths:    taskDef.ChangedPaths,
+			Context:         taskDef.Context,
+			Env:             taskDef.Env,
+			Interpreter:     taskDef.Interpreter,
+			Dir:             taskDef.Dir,
+			Timeout:         taskDef.Timeout,
+			Retries:         taskDef.RetryCount(),
+			Agent:           taskDef.Agent,
+			NoOutputTimeout: taskDef.NoOutputTimeout,
+			NoOutputAction:  taskDef.NoOutputAction,
+			Interactive:     taskDef.Interactive,
+		}
+	}
+
+	// Maps are encoded with sorted keys, so the encoding is deterministic
+	data, _ := json.Marshal(f)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ChangeKind is the kind of change of a definition value
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// Change is a single difference between two pipeline definitions
+type Change struct {
+	// Task is the name of the changed task, empty for settings of the pipeline
+	Task string
+	// Field is the name of the changed setting (as in the YAML definition), environment variables are named "env.NAME".
+	// If a whole task was added or removed, the field is empty.
+	Field string
+	Kind  ChangeKind
+	// Old and New are the formatted values before and after the change (Old is empty if added, New if removed)
+	Old string
+	New string
+}
+
+// Diff returns the changes of settings that affect the execution of a job from one definition to another,
+// sorted by task and field
+func Diff(from, to PipelineDef) []Change {
+	changes := []Change{}
+
+	changes = appendEnvChanges(changes, "", from.Env, to.Env)
+	changes = appendChange(changes, "", "priority_class", from.PriorityClass.String(), to.PriorityClass.String())
+	changes = appendChange(changes, "", "interpreter", from.Interpreter.String(), to.Interpreter.String())
+	changes = appendChange(changes, "", "git", from.Git.String(), to.Git.String())
+	changes = appendContextChanges(changes, from.Contexts, to.Contexts)
+	changes = appendServiceChanges(changes, from.Services, to.Services)
+
+	for taskName, fromTask := range from.Tasks {
+		toTask, exists := to.Tasks[taskName]
+		if !exists {
+			changes = append(changes, Change{Task: taskName, Kind: ChangeRemoved})
+			continue
+		}
+
+		changes = appendChange(changes, taskName, "script", strings.Join(fromTask.Script, "\n"), strings.Join(toTask.Script, "\n"))
+		changes = appendChange(changes, taskName, "depends_on", formatTaskNames(fromTask.DependsOn), formatTaskNames(toTask.DependsOn))
+		changes = appendChange(changes, taskName, "allow_failure", fmt.Sprint(fromTask.AllowFailure), fmt.Sprint(toTask.AllowFailure))
+		changes = appendChange(changes, taskName, "when", fromTask.When, toTask.When)
+		changes = appendChange(changes, taskName, "changed_paths", fromTask.ChangedPaths.String(), toTask.ChangedPaths.String())
+		changes = appendChange(changes, taskName, "context", fromTask.Context, toTask.Context)
+		changes = appendEnvChanges(changes, taskName, fromTask.Env, toTask.Env)
+		changes = appendChange(changes, taskName, "interpreter", fromTask.Interpreter.String(), toTask.Interpreter.String())
+		changes = appendChange(changes, taskName, "dir", fromTask.Dir, toTask.Dir)
+		changes = appendChange(changes, taskName, "timeout", fromTask.Timeout.String(), toTask.Timeout.String())
+		changes = appendChange(changes, taskName, "retries", fmt.Sprint(fromTask.RetryCount()), fmt.Sprint(toTask.RetryCount()))
+		changes = appendChange(changes, taskName, "no_output_timeout", fromTask.NoOutputTimeout.String(), toTask.NoOutputTimeout.String())
+		changes = appendChange(changes, taskName, "no_output_action", fromTask.NoOutputAction.String(), toTask.NoOutputAction.String())
+		changes = appendChange(changes, taskName, "interactive", fmt.Sprint(fromTask.Interactive), fmt.Sprint(toTask.Interactive))
+		changes = appendChange(changes, taskName, "agent", fromTask.Agent, toTask.Agent)
+	}
+	for taskName := range to.Tasks {
+		if _, exists := from.Tasks[taskName]; !exists {
+			changes = append(changes, Change{Task: taskName, Kind: ChangeAdded})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Task != changes[j].Task {
+			return changes[i].Task < changes[j].Task
+		}
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+func appendChange(changes []Change, task, field, from, to string) []Change {
+	if from == to {
+		return changes
+	}
+	return append(changes, Change{Task: task, Field: field, Kind: ChangeChanged, Old: from, New: to})
+}
+
+func appendEnvChanges(changes []Change, task string, from, to map[string]string) []Change {
+	for name, fromValue := range from {
+		toValue, exists := to[name]
+		if !exists {
+			changes = append(changes, Change{Task: task, Field: "env." + name, Kind: ChangeRemoved, Old: fromValue})
+			continue
+		}
+		changes = appendChange(changes, task, "env."+name, fromValue, toValue)
+	}
+	for name, toValue := range to {
+		if _, exists := from[name]; !exists {
+			changes = append(changes, Change{Task: task, Field: "env." + name, Kind: ChangeAdded, New: toValue})
+		}
+	}
+	return changes
+}
+
+func appendContextChanges(changes []Change, from, to map[string]ContextDef) []Change {
+	for name, fromContext := range from {
+		toContext, exists := to[name]
+		if !exists {
+			changes = append(changes, Change{Field: "contexts." + name, Kind: ChangeRemoved, Old: fromContext.String()})
+			continue
+		}
+		changes = appendChange(changes, "", "contexts."+name, fromContext.String(), toContext.String())
+	}
+	for name, toContext := range to {
+		if _, exists := from[name]; !exists {
+			changes = append(changes, Change{Field: "contexts." + name, Kind: ChangeAdded, New: toContext.String()})
+		}
+	}
+	return changes
+}
+
+// appendServiceChanges adds changes of the commands of services, other settings of a service are compared by all fields
+// since the command is shown as value
+func appendServiceChanges(changes []Change, from, to map[string]ServiceDef) []Change {
+	for name, fromService := range from {
+		toService, exists := to[name]
+		if !exists {
+			changes = append(changes, Change{Field: "services." + name, Kind: ChangeRemoved, Old: fromService.Command})
+			continue
+		}
+		if !fromService.Equals(toService) {
+			changes = append(changes, Change{Field: "services." + name, Kind: ChangeChanged, Old: fromService.Command, New: toService.Command})
+		}
+	}
+	for name, toService := range to {
+		if _, exists := from[name]; !exists {
+			changes = append(changes, Change{Field: "services." + name, Kind: ChangeAdded, New: toService.Command})
+		}
+	}
+	return changes
+}
+
+func formatTaskNames(taskNames []string) string {
+	sorted := append([]string(nil), taskNames...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
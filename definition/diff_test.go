@@ -0,0 +1,67 @@
+package definition
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipelineDef_Hash(t *testing.T) {
+	pipelineDef := PipelineDef{
+		Concurrency: 1,
+		Env:         map[string]string{"APP_ENV": "production"},
+		Tasks: map[string]TaskDef{
+			"build":  {Script: []string{"make build"}},
+			"deploy": {Script: []string{"./deploy.sh"}, DependsOn: []string{"build", "test"}},
+			"test":   {Script: []string{"make test"}},
+		},
+	}
+
+	hash := pipelineDef.Hash()
+	assert.Len(t, hash, 12)
+
+	// Settings that do not affect the execution of a job and the order of dependencies are ignored
+	otherDef := pipelineDef
+	otherDef.Concurrency = 2
+	otherDef.Tasks = map[string]TaskDef{
+		"build":  {Script: []string{"make build"}, ScriptFile: "build.sh"},
+		"deploy": {Script: []string{"./deploy.sh"}, DependsOn: []string{"test", "build"}},
+		"test":   {Script: []string{"make test"}, Env: map[string]string{}},
+	}
+	assert.Equal(t, hash, otherDef.Hash())
+
+	otherDef.Tasks["test"] = TaskDef{Script: []string{"make test"}, Timeout: time.Minute}
+	assert.NotEqual(t, hash, otherDef.Hash())
+}
+
+func TestDiff(t *testing.T) {
+	from := PipelineDef{
+		Env: map[string]string{"APP_ENV": "production", "DEBUG": "0"},
+		Tasks: map[string]TaskDef{
+			"build":  {Script: []string{"make build"}},
+			"deploy": {Script: []string{"./deploy.sh"}, DependsOn: []string{"build"}},
+		},
+	}
+	to := PipelineDef{
+		Env:           map[string]string{"APP_ENV": "staging", "REGION": "eu"},
+		PriorityClass: PriorityClassLow,
+		Tasks: map[string]TaskDef{
+			"build":  {Script: []string{"make build"}, Timeout: time.Minute, Agent: "builder"},
+			"notify": {Script: []string{"./notify.sh"}},
+		},
+	}
+
+	assert.Equal(t, []Change{
+		{Field: "env.APP_ENV", Kind: ChangeChanged, Old: "production", New: "staging"},
+		{Field: "env.DEBUG", Kind: ChangeRemoved, Old: "0"},
+		{Field: "env.REGION", Kind: ChangeAdded, New: "eu"},
+		{Field: "priority_class", Kind: ChangeChanged, Old: "normal", New: "low"},
+		{Task: "build", Field: "agent", Kind: ChangeChanged, Old: "", New: "builder"},
+		{Task: "build", Field: "timeout", Kind: ChangeChanged, Old: "0s", New: "1m0s"},
+		{Task: "deploy", Kind: ChangeRemoved},
+		{Task: "notify", Kind: ChangeAdded},
+	}, Diff(from, to))
+
+	assert.Empty(t, Diff(from, from))
+}
@@ -0,0 +1,212 @@
+package definition
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Flowpack/prunner/condition"
+)
+
+// LintCheck identifies the kind of a lint warning
+type LintCheck string
+
+const (
+	// LintCheckUnreachableTask warns about tasks that are always skipped, because their when condition has no variables
+	// and is false, and about tasks depending on them directly or through other tasks
+	LintCheckUnreachableTask LintCheck = "unreachable-task"
+	// LintCheckIsolatedTask warns about tasks without dependencies and dependents in a pipeline that otherwise uses
+	// dependencies, which often is a forgotten depends_on
+	LintCheckIsolatedTask LintCheck = "isolated-task"
+	// LintCheckShadowedEnv warns about environment variables that never take effect, because they are overridden by
+	// prunner or by every task
+	LintCheckShadowedEnv LintCheck = "shadowed-env"
+	// LintCheckMissingDescription warns about pipelines without description
+	LintCheckMissingDescription LintCheck = "missing-description"
+)
+
+// isolatedTaskMinTasks is the number of tasks a pipeline needs before isolated tasks are reported, small pipelines
+// often run independent tasks on purpose
+const isolatedTaskMinTasks = 5
+
+// LintWarning is a finding of Lint. Other than validation errors, warnings do not prevent loading a definition.
+type LintWarning struct {
+	Pipeline string
+	// Task is empty for warnings about the pipeline
+	Task    string
+	Check   LintCheck
+	Message string
+}
+
+func (w LintWarning) String() string {
+	location := w.Pipeline
+	if w.Task != "" {
+		location += "." + w.Task
+	}
+	return fmt.Sprintf("%s: %s (%s)", location, w.Message, w.Check)
+}
+
+// LintOpts configures Lint
+type LintOpts struct {
+	// JobEnv are names of environment variables prunner sets for all tasks of a job, they override the env of the
+	// pipeline
+	JobEnv []string
+	// TaskEnv are names of environment variables prunner sets for each task, they override the env of the pipeline and
+	// the task
+	TaskEnv []string
+}
+
+// Lint checks the pipelines for settings that are valid but most likely a mistake. The warnings are ordered by
+// pipeline and task.
+func (d *PipelinesDef) Lint(opts LintOpts) []LintWarning {
+	jobEnv := make(map[string]bool, len(opts.JobEnv)+len(opts.TaskEnv))
+	taskEnv := make(map[string]bool, len(opts.TaskEnv))
+	for _, name := range opts.JobEnv {
+		jobEnv[name] = true
+	}
+	for _, name := range opts.TaskEnv {
+		jobEnv[name] = true
+		taskEnv[name] = true
+	}
+
+	warnings := []LintWarning{}
+	for pipeline, pipelineDef := range d.Pipelines {
+		warnings = append(warnings, pipelineDef.lint(pipeline, jobEnv, taskEnv)...)
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		a, b := warnings[i], warnings[j]
+		if a.Pipeline != b.Pipeline {
+			return a.Pipeline < b.Pipeline
+		}
+		if a.Task != b.Task {
+			return a.Task < b.Task
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Message < b.Message
+	})
+
+	return warnings
+}
+
+func (d PipelineDef) lint(pipeline string, jobEnv, taskEnv map[string]bool) []LintWarning {
+	var warnings []LintWarning
+	warn := func(task string, check LintCheck, format string, args ...interface{}) {
+		warnings = append(warnings, LintWarning{
+			Pipeline: pipeline,
+			Task:     task,
+			Check:    check,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if strings.TrimSpace(d.Description) == "" {
+		warn("", LintCheckMissingDescription, "pipeline has no description")
+	}
+
+	for name := range d.Env {
+		if jobEnv[name] {
+			warn("", LintCheckShadowedEnv, "env %s is overridden by prunner", name)
+			continue
+		}
+		if len(d.Tasks) > 0 && d.isOverriddenByAllTasks(name) {
+			warn("", LintCheckShadowedEnv, "env %s is overridden by every task", name)
+		}
+	}
+
+	hasDependents := make(map[string]bool)
+	usesDependencies := false
+	for _, taskDef := range d.Tasks {
+		for _, dependency := range taskDef.DependsOn {
+			hasDependents[dependency] = true
+			usesDependencies = true
+		}
+	}
+
+	alwaysSkipped := make(map[string]bool)
+	for taskName, taskDef := range d.Tasks {
+		if taskDef.When != "" {
+			// Invalid conditions are reported by validation
+			if expr, err := condition.Parse(taskDef.When); err == nil && len(expr.Variables()) == 0 && !expr.Evaluate(nil) {
+				alwaysSkipped[taskName] = true
+			}
+		}
+	}
+
+	for taskName, taskDef := range d.Tasks {
+		for name := range taskDef.Env {
+			if taskEnv[name] {
+				warn(taskName, LintCheckShadowedEnv, "env %s is overridden by prunner", name)
+			}
+		}
+
+		if alwaysSkipped[taskName] {
+			warn(taskName, LintCheckUnreachableTask, "task is always skipped, when condition %q is never true", taskDef.When)
+		} else if skipped, via := d.skippedDependency(taskName, alwaysSkipped); skipped != "" {
+			if len(via) == 0 {
+				warn(taskName, LintCheckUnreachableTask, "task depends on always skipped task %q", skipped)
+			} else {
+				warn(taskName, LintCheckUnreachableTask, "task depends on always skipped task %q via %s", skipped, quoteJoin(via))
+			}
+		}
+
+		if usesDependencies && len(d.Tasks) >= isolatedTaskMinTasks && len(taskDef.DependsOn) == 0 && !hasDependents[taskName] {
+			warn(taskName, LintCheckIsolatedTask, "task has no dependencies and no dependents")
+		}
+	}
+
+	return warnings
+}
+
+// skippedDependency returns the first always skipped task (in order of depends_on) the task depends on directly or
+// through other tasks and the tasks in between, skipped is empty if there is none
+func (d PipelineDef) skippedDependency(task string, alwaysSkipped map[string]bool) (skipped string, via []string) {
+	visited := make(map[string]bool)
+	var find func(task string, via []string) (string, []string)
+	find = func(task string, via []string) (string, []string) {
+		// Cycles are reported by validation, tasks are visited once anyway
+		if visited[task] {
+			return "", nil
+		}
+		visited[task] = true
+
+		for _, dependency := range d.Tasks[task].DependsOn {
+			if alwaysSkipped[dependency] {
+				return dependency, via
+			}
+		}
+		for _, dependency := range d.Tasks[task].DependsOn {
+			if skipped, chain := find(dependency, append(via[:len(via):len(via)], dependency)); skipped != "" {
+				return skipped, chain
+			}
+		}
+		return "", nil
+	}
+	return find(task, nil)
+}
+
+func quoteJoin(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// isOverriddenByAllTasks returns true if every task and service sets the pipeline environment variable, so the value
+// of the pipeline is never used
+func (d PipelineDef) isOverriddenByAllTasks(name string) bool {
+	for _, taskDef := range d.Tasks {
+		if _, ok := taskDef.Env[name]; !ok {
+			return false
+		}
+	}
+	for _, serviceDef := range d.Services {
+		if _, ok := serviceDef.Env[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
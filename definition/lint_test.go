@@ -0,0 +1,66 @@
+package definition_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+func TestPipelinesDef_Lint(t *testing.T) {
+	defs := definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Env: map[string]string{
+					"PRUNNER_JOB_ID": "123",
+					"TARGET":         "production",
+				},
+				Tasks: map[string]definition.TaskDef{
+					"generate": {Env: map[string]string{"TARGET": "staging"}},
+					"build":    {DependsOn: []string{"generate"}, Env: map[string]string{"TARGET": "staging"}},
+					"test":     {DependsOn: []string{"build"}, Env: map[string]string{"TARGET": "staging", "PRUNNER_TASK": "test"}},
+					"deploy":   {DependsOn: []string{"test"}, Env: map[string]string{"TARGET": "staging"}},
+					"lint":     {Env: map[string]string{"TARGET": "staging"}},
+					"notify":   {DependsOn: []string{"deploy"}, Env: map[string]string{"TARGET": "staging"}, When: `false && true`},
+				},
+			},
+			"cleanup_it": {
+				Description: "Cleans up after a release",
+				Tasks: map[string]definition.TaskDef{
+					"prepare": {When: "false"},
+					"migrate": {DependsOn: []string{"prepare"}},
+					"verify":  {DependsOn: []string{"migrate"}},
+					"report":  {DependsOn: []string{"verify"}},
+				},
+			},
+			"parallel_it": {
+				Description: "Runs independent checks",
+				Tasks: map[string]definition.TaskDef{
+					"a": {}, "b": {}, "c": {}, "d": {}, "e": {},
+					"f": {When: `vars.env == "production"`},
+				},
+			},
+		},
+	}
+
+	warnings := defs.Lint(definition.LintOpts{
+		JobEnv:  []string{"PRUNNER_JOB_ID"},
+		TaskEnv: []string{"PRUNNER_TASK"},
+	})
+
+	assert.Equal(t, []definition.LintWarning{
+		{Pipeline: "cleanup_it", Task: "migrate", Check: definition.LintCheckUnreachableTask, Message: `task depends on always skipped task "prepare"`},
+		{Pipeline: "cleanup_it", Task: "prepare", Check: definition.LintCheckUnreachableTask, Message: `task is always skipped, when condition "false" is never true`},
+		{Pipeline: "cleanup_it", Task: "report", Check: definition.LintCheckUnreachableTask, Message: `task depends on always skipped task "prepare" via "verify", "migrate"`},
+		{Pipeline: "cleanup_it", Task: "verify", Check: definition.LintCheckUnreachableTask, Message: `task depends on always skipped task "prepare" via "migrate"`},
+		{Pipeline: "release_it", Check: definition.LintCheckMissingDescription, Message: "pipeline has no description"},
+		{Pipeline: "release_it", Check: definition.LintCheckShadowedEnv, Message: "env PRUNNER_JOB_ID is overridden by prunner"},
+		{Pipeline: "release_it", Check: definition.LintCheckShadowedEnv, Message: "env TARGET is overridden by every task"},
+		{Pipeline: "release_it", Task: "lint", Check: definition.LintCheckIsolatedTask, Message: "task has no dependencies and no dependents"},
+		{Pipeline: "release_it", Task: "notify", Check: definition.LintCheckUnreachableTask, Message: `task is always skipped, when condition "false && true" is never true`},
+		{Pipeline: "release_it", Task: "test", Check: definition.LintCheckShadowedEnv, Message: "env PRUNNER_TASK is overridden by prunner"},
+	}, warnings)
+
+	assert.Equal(t, "release_it.lint: task has no dependencies and no dependents (isolated-task)", warnings[7].String())
+}
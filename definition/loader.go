@@ -2,6 +2,7 @@ package definition
 
 import (
 	"os"
+	"path/filepath"
 	"sort"
 
 	"github.com/friendsofgo/errors"
@@ -9,7 +10,56 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-func LoadRecursively(pattern string) (*PipelinesDef, error) {
+// definitionFile is the structure of a single definition file
+type definitionFile struct {
+	// Defaults are applied to all pipelines of the file
+	Defaults DefaultsDef `yaml:"defaults"`
+	// TaskLibrary contains tasks that can be used by all pipelines of the file
+	TaskLibrary TaskLibrary  `yaml:"task_library"`
+	Pipelines   PipelinesMap `yaml:"pipelines"`
+}
+
+// DuplicatePolicy decides how a pipeline name that is declared in more than one definition file is handled, the files
+// are loaded in lexical order of their paths
+type DuplicatePolicy string
+
+const (
+	// DuplicatePolicyError fails loading the definitions (default)
+	DuplicatePolicyError DuplicatePolicy = "error"
+	// DuplicatePolicyFirstWins keeps the pipeline of the first file, later declarations are ignored
+	DuplicatePolicyFirstWins DuplicatePolicy = "first_wins"
+	// DuplicatePolicyOverride replaces the pipeline with a later declaration that sets override: true, other
+	// declarations fail loading the definitions
+	DuplicatePolicyOverride DuplicatePolicy = "override"
+)
+
+// ParseDuplicatePolicy parses the name of a duplicate policy, an empty name is the default policy
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(name); policy {
+	case "":
+		return DuplicatePolicyError, nil
+	case DuplicatePolicyError, DuplicatePolicyFirstWins, DuplicatePolicyOverride:
+		return policy, nil
+	}
+	return "", errors.Errorf("invalid duplicate policy %q: must be error, first_wins or override", name)
+}
+
+// LoadOpts is a configuration function for LoadRecursively and Load
+type LoadOpts func(*loadConfig)
+
+type loadConfig struct {
+	duplicatePolicy DuplicatePolicy
+}
+
+// WithDuplicatePolicy sets how pipelines with the same name in more than one definition file are handled (defaults to
+// DuplicatePolicyError)
+func WithDuplicatePolicy(policy DuplicatePolicy) LoadOpts {
+	return func(c *loadConfig) {
+		c.duplicatePolicy = policy
+	}
+}
+
+func LoadRecursively(pattern string, opts ...LoadOpts) (*PipelinesDef, error) {
 	matches, err := zglob.GlobFollowSymlinks(pattern)
 	if err != nil {
 		return nil, errors.Wrap(err, "finding files with glob")
@@ -23,7 +73,7 @@ func LoadRecursively(pattern string) (*PipelinesDef, error) {
 	}
 
 	for _, path := range matches {
-		err = pipelinesDef.Load(path)
+		err = pipelinesDef.Load(path, opts...)
 		if err != nil {
 			return nil, errors.Wrapf(err, "loading %s", path)
 		}
@@ -32,34 +82,154 @@ func LoadRecursively(pattern string) (*PipelinesDef, error) {
 	return pipelinesDef, nil
 }
 
-func (d *PipelinesDef) Load(path string) error {
+// LoadLayers loads the definitions of each pattern like LoadRecursively, pipelines of a later pattern replace the
+// pipelines with the same name of earlier patterns (e.g. site-specific pipelines override a shared base set). The
+// duplicate policy only applies to the files of a single pattern.
+func LoadLayers(patterns []string, opts ...LoadOpts) (*PipelinesDef, error) {
+	pipelinesDef := &PipelinesDef{
+		Pipelines: make(map[string]PipelineDef),
+	}
+
+	for _, pattern := range patterns {
+		layerDef, err := LoadRecursively(pattern, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		for pipelineName, pipelineDef := range layerDef.Pipelines {
+			if previousDef, exists := pipelinesDef.Pipelines[pipelineName]; exists {
+				shadowedSourcePaths := append([]string{}, previousDef.ShadowedSourcePaths...)
+				shadowedSourcePaths = append(shadowedSourcePaths, previousDef.SourcePath)
+				pipelineDef.ShadowedSourcePaths = append(shadowedSourcePaths, pipelineDef.ShadowedSourcePaths...)
+			}
+			pipelinesDef.Pipelines[pipelineName] = pipelineDef
+		}
+	}
+
+	return pipelinesDef, nil
+}
+
+func (d *PipelinesDef) Load(path string, opts ...LoadOpts) error {
+	c := &loadConfig{duplicatePolicy: DuplicatePolicyError}
+	for _, o := range opts {
+		o(c)
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return errors.Wrap(err, "opening file")
 	}
 	defer f.Close()
 
-	var localDef PipelinesDef
+	var file definitionFile
 
-	err = yaml.NewDecoder(f).Decode(&localDef)
+	err = yaml.NewDecoder(f).Decode(&file)
 	if err != nil {
 		return errors.Wrap(err, "decoding YAML")
 	}
+	err = file.TaskLibrary.validate()
+	if err != nil {
+		return errors.Wrap(err, "invalid task library")
+	}
+	localDef := PipelinesDef{Pipelines: file.Pipelines}
 	localDef.setDefaults()
 
 	for pipelineName, pipelineDef := range localDef.Pipelines {
+		var shadowedSourcePaths []string
 		if p, exists := d.Pipelines[pipelineName]; exists {
-			return errors.Errorf("pipeline %q was already declared in %s", pipelineName, p.SourcePath)
+			switch {
+			case c.duplicatePolicy == DuplicatePolicyFirstWins:
+				p.ShadowedSourcePaths = append(p.ShadowedSourcePaths, path)
+				d.Pipelines[pipelineName] = p
+				continue
+			case c.duplicatePolicy == DuplicatePolicyOverride && pipelineDef.Override:
+				shadowedSourcePaths = append(p.ShadowedSourcePaths, p.SourcePath)
+			case c.duplicatePolicy == DuplicatePolicyOverride:
+				return errors.Errorf("pipeline %q was already declared in %s, set override: true to replace it", pipelineName, p.SourcePath)
+			default:
+				return errors.Errorf("pipeline %q was already declared in %s", pipelineName, p.SourcePath)
+			}
+		}
+
+		err := file.TaskLibrary.resolve(&pipelineDef)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pipeline definition %q", pipelineName)
 		}
+		file.Defaults.apply(&pipelineDef)
+		pipelineDef.resolveTaskDirs(filepath.Dir(path))
 
-		err := pipelineDef.validate()
+		err = pipelineDef.validate()
+		if err != nil {
+			return errors.Wrapf(err, "invalid pipeline definition %q", pipelineName)
+		}
+
+		err = pipelineDef.loadScriptFiles(filepath.Dir(path))
 		if err != nil {
 			return errors.Wrapf(err, "invalid pipeline definition %q", pipelineName)
 		}
 
 		pipelineDef.SourcePath = path
+		pipelineDef.ShadowedSourcePaths = shadowedSourcePaths
 		d.Pipelines[pipelineName] = pipelineDef
 	}
 
 	return nil
 }
+
+// loadScriptFiles reads the script files of tasks relative to baseDir and sets the content as script
+func (d PipelineDef) loadScriptFiles(baseDir string) error {
+	for taskName, taskDef := range d.Tasks {
+		if taskDef.ScriptFile == "" {
+			continue
+		}
+		if len(taskDef.Script) > 0 {
+			return errors.Errorf("task %q must not have both script and script_file", taskName)
+		}
+
+		scriptPath := taskDef.ScriptFile
+		if !filepath.IsAbs(scriptPath) {
+			scriptPath = filepath.Join(baseDir, scriptPath)
+		}
+
+		content, err := os.ReadFile(scriptPath)
+		if err != nil {
+			return errors.Wrapf(err, "reading script_file of task %q", taskName)
+		}
+
+		// The script file is executed as a whole, so multi-line constructs (functions, loops) work as expected
+		taskDef.Script = []string{string(content)}
+		d.Tasks[taskName] = taskDef
+	}
+	return nil
+}
+
+// resolveTaskDirs resolves relative working directories of tasks and services, the git checkout and compose files
+// against baseDir.
+// Compose files of a pipeline with a git checkout are relative to the checkout and resolved when a job starts.
+func (d PipelineDef) resolveTaskDirs(baseDir string) {
+	if d.Git != nil && d.Git.Dir != "" && !filepath.IsAbs(d.Git.Dir) {
+		d.Git.Dir = filepath.Join(baseDir, d.Git.Dir)
+	}
+	for name, serviceDef := range d.Services {
+		if serviceDef.Dir != "" && !filepath.IsAbs(serviceDef.Dir) {
+			serviceDef.Dir = filepath.Join(baseDir, serviceDef.Dir)
+			d.Services[name] = serviceDef
+		}
+	}
+	if d.Git == nil {
+		for name, contextDef := range d.Contexts {
+			if contextDef.Compose != nil && !filepath.IsAbs(contextDef.Compose.File) {
+				compose := *contextDef.Compose
+				compose.File = filepath.Join(baseDir, compose.File)
+				d.Contexts[name] = ContextDef{Compose: &compose}
+			}
+		}
+	}
+	for taskName, taskDef := range d.Tasks {
+		if taskDef.Dir == "" || filepath.IsAbs(taskDef.Dir) {
+			continue
+		}
+		taskDef.Dir = filepath.Join(baseDir, taskDef.Dir)
+		d.Tasks[taskName] = taskDef
+	}
+}
@@ -1,7 +1,11 @@
 package definition
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -19,7 +23,245 @@ func TestLoadRecursively_WithDuplicate(t *testing.T) {
 	require.EqualError(t, err, `loading ../test/fixtures/pipelines.yml: pipeline "test_it" was already declared in ../test/fixtures/dup.yml`)
 }
 
+func TestLoadRecursively_WithDuplicatePolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeDefinition := func(name, content string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	writeDefinition("a.yml", `
+pipelines:
+  release_it:
+    description: first
+    tasks:
+      build:
+        script:
+          - go build
+`)
+	writeDefinition("b.yml", `
+pipelines:
+  release_it:
+    description: second
+    tasks:
+      build:
+        script:
+          - go build
+`)
+	pattern := filepath.Join(dir, "*.yml")
+
+	_, err := LoadRecursively(pattern)
+	require.ErrorContains(t, err, fmt.Sprintf(`pipeline "release_it" was already declared in %s`, filepath.Join(dir, "a.yml")))
+
+	defs, err := LoadRecursively(pattern, WithDuplicatePolicy(DuplicatePolicyFirstWins))
+	require.NoError(t, err)
+	require.Equal(t, "first", defs.Pipelines["release_it"].Description)
+	require.Equal(t, filepath.Join(dir, "a.yml"), defs.Pipelines["release_it"].SourcePath)
+	require.Equal(t, []string{filepath.Join(dir, "b.yml")}, defs.Pipelines["release_it"].ShadowedSourcePaths)
+
+	_, err = LoadRecursively(pattern, WithDuplicatePolicy(DuplicatePolicyOverride))
+	require.ErrorContains(t, err, "set override: true to replace it")
+
+	writeDefinition("b.yml", `
+pipelines:
+  release_it:
+    description: second
+    override: true
+    tasks:
+      build:
+        script:
+          - go build
+`)
+	defs, err = LoadRecursively(pattern, WithDuplicatePolicy(DuplicatePolicyOverride))
+	require.NoError(t, err)
+	require.Equal(t, "second", defs.Pipelines["release_it"].Description)
+	require.Equal(t, filepath.Join(dir, "b.yml"), defs.Pipelines["release_it"].SourcePath)
+	require.Equal(t, []string{filepath.Join(dir, "a.yml")}, defs.Pipelines["release_it"].ShadowedSourcePaths)
+
+	_, err = LoadRecursively(pattern)
+	require.Error(t, err, "override is not allowed with the default policy")
+}
+
+func TestLoadLayers(t *testing.T) {
+	baseDir := t.TempDir()
+	siteDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "pipelines.yml"), []byte(`
+pipelines:
+  release_it:
+    description: base release
+    tasks:
+      deploy:
+        script:
+          - ./deploy.sh
+  cleanup_it:
+    tasks:
+      cleanup:
+        script:
+          - ./cleanup.sh
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(siteDir, "pipelines.yml"), []byte(`
+pipelines:
+  release_it:
+    description: site release
+    tasks:
+      deploy:
+        script:
+          - ./deploy-site.sh
+`), 0644))
+
+	defs, err := LoadLayers([]string{
+		filepath.Join(baseDir, "**/pipelines.yml"),
+		filepath.Join(siteDir, "**/pipelines.yml"),
+	})
+	require.NoError(t, err)
+	require.Len(t, defs.Pipelines, 2)
+
+	releaseDef := defs.Pipelines["release_it"]
+	require.Equal(t, "site release", releaseDef.Description)
+	require.Equal(t, filepath.Join(siteDir, "pipelines.yml"), releaseDef.SourcePath)
+	require.Equal(t, []string{filepath.Join(baseDir, "pipelines.yml")}, releaseDef.ShadowedSourcePaths)
+
+	cleanupDef := defs.Pipelines["cleanup_it"]
+	require.Equal(t, filepath.Join(baseDir, "pipelines.yml"), cleanupDef.SourcePath)
+	require.Empty(t, cleanupDef.ShadowedSourcePaths)
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	policy, err := ParseDuplicatePolicy("")
+	require.NoError(t, err)
+	require.Equal(t, DuplicatePolicyError, policy)
+
+	policy, err = ParseDuplicatePolicy("first_wins")
+	require.NoError(t, err)
+	require.Equal(t, DuplicatePolicyFirstWins, policy)
+
+	_, err = ParseDuplicatePolicy("last_wins")
+	require.EqualError(t, err, `invalid duplicate policy "last_wins": must be error, first_wins or override`)
+}
+
 func TestLoadRecursively_WithMissingDependency(t *testing.T) {
 	_, err := LoadRecursively("../test/fixtures/missingDep.yml")
 	require.EqualError(t, err, `loading ../test/fixtures/missingDep.yml: invalid pipeline definition "test_it": missing task "not_existing" referenced in depends_on of task "test"`)
 }
+
+func TestLoadRecursively_WithCycle(t *testing.T) {
+	_, err := LoadRecursively("../test/fixtures/cycle.yml")
+	require.EqualError(t, err, `loading ../test/fixtures/cycle.yml: invalid pipeline definition "release_it": depends_on forms a cycle: build -> test -> package -> build`)
+}
+
+func TestLoadRecursively_WithInterpreter(t *testing.T) {
+	defs, err := LoadRecursively("../test/fixtures/interpreter.yml")
+	require.NoError(t, err)
+
+	pipelineDef := defs.Pipelines["windows_it"]
+	require.Equal(t, InterpreterPowerShell, pipelineDef.Interpreter)
+	require.Equal(t, InterpreterDefault, pipelineDef.Tasks["build"].Interpreter)
+	require.Equal(t, InterpreterCmd, pipelineDef.Tasks["cleanup"].Interpreter)
+}
+
+func TestLoadRecursively_WithScriptFile(t *testing.T) {
+	defs, err := LoadRecursively("../test/fixtures/scriptFile.yml")
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("../test/fixtures/scripts/deploy.sh")
+	require.NoError(t, err)
+
+	taskDef := defs.Pipelines["deploy_it"].Tasks["deploy"]
+	require.Equal(t, "./scripts/deploy.sh", taskDef.ScriptFile)
+	require.Equal(t, []string{string(content)}, taskDef.Script)
+}
+
+func TestLoadRecursively_WithDefaults(t *testing.T) {
+	defs, err := LoadRecursively("../test/fixtures/defaults.yml")
+	require.NoError(t, err)
+
+	deployDef := defs.Pipelines["deploy_it"]
+	require.Equal(t, map[string]string{"APP_ENV": "production", "LOG_LEVEL": "debug"}, deployDef.Env)
+	require.Equal(t, InterpreterPwsh, deployDef.Interpreter)
+
+	deployTask := deployDef.Tasks["deploy"]
+	require.Equal(t, filepath.Join("../test/fixtures", "app"), deployTask.Dir)
+	require.Equal(t, 10*time.Minute, deployTask.Timeout)
+	require.Equal(t, 2, deployTask.RetryCount())
+
+	notifyTask := deployDef.Tasks["notify"]
+	require.Equal(t, "/tmp", notifyTask.Dir)
+	require.Equal(t, 30*time.Second, notifyTask.Timeout)
+	require.Equal(t, 0, notifyTask.RetryCount())
+
+	require.Equal(t, InterpreterCmd, defs.Pipelines["cmd_it"].Interpreter)
+}
+
+func TestLoadRecursively_WithTaskLibrary(t *testing.T) {
+	defs, err := LoadRecursively("../test/fixtures/taskLibrary.yml")
+	require.NoError(t, err)
+
+	flushDeployTask := defs.Pipelines["deploy_it"].Tasks["flush"]
+	require.Equal(t, []string{`./flow flow:cache:flush --cache "$CACHE"`}, flushDeployTask.Script)
+	require.Equal(t, []string{"deploy"}, flushDeployTask.DependsOn)
+	require.Equal(t, map[string]string{"CACHE": "all"}, flushDeployTask.Env)
+	require.Equal(t, 1, flushDeployTask.RetryCount())
+
+	flushImportTask := defs.Pipelines["import_it"].Tasks["flush"]
+	require.Equal(t, []string{`./flow flow:cache:flush --cache "$CACHE"`}, flushImportTask.Script)
+	require.Equal(t, map[string]string{"CACHE": "Neos_Fusion_Content"}, flushImportTask.Env)
+	require.Equal(t, 0, flushImportTask.RetryCount())
+}
+
+func TestLoadRecursively_WithUnknownLibraryTask(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "pipelines.yml"), []byte(`
+pipelines:
+  release_it:
+    tasks:
+      flush:
+        uses: not_existing
+`), 0644)
+	require.NoError(t, err)
+
+	_, err = LoadRecursively(filepath.Join(dir, "pipelines.yml"))
+	require.ErrorContains(t, err, `task "flush" uses unknown library task "not_existing"`)
+}
+
+func TestLoadRecursively_WithDescriptionAndTags(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "pipelines.yml"), []byte(`
+pipelines:
+  release_it:
+    description: Builds and deploys a release
+    tags: [deployment, production]
+    tasks:
+      deploy:
+        script:
+          - ./deploy.sh
+`), 0644)
+	require.NoError(t, err)
+
+	defs, err := LoadRecursively(filepath.Join(dir, "pipelines.yml"))
+	require.NoError(t, err)
+
+	releaseDef := defs.Pipelines["release_it"]
+	require.Equal(t, "Builds and deploys a release", releaseDef.Description)
+	require.Equal(t, []string{"deployment", "production"}, releaseDef.Tags)
+	require.True(t, releaseDef.HasTag("production"))
+	require.False(t, releaseDef.HasTag("nightly"))
+
+	for tags, expectedErr := range map[string]string{
+		`[deployment, ""]`:         `tag 2 must not be empty`,
+		`["nightly build"]`:        `tag "nightly build" must not contain whitespace or commas`,
+		`[deployment, deployment]`: `tag "deployment" is declared more than once`,
+	} {
+		err := os.WriteFile(filepath.Join(dir, "pipelines.yml"), []byte(`
+pipelines:
+  release_it:
+    tags: `+tags+`
+    tasks:
+      deploy:
+        script:
+          - ./deploy.sh
+`), 0644)
+		require.NoError(t, err)
+
+		_, err = LoadRecursively(filepath.Join(dir, "pipelines.yml"))
+		require.ErrorContains(t, err, expectedErr, tags)
+	}
+}
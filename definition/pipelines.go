@@ -5,30 +5,105 @@ import (
 	"time"
 
 	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/condition"
 )
 
 type TaskDef struct {
+	// Uses references a task of the task library, fields set on this task override the library task
+	Uses string `yaml:"uses"`
+
 	// Script is a list of shell commands that are executed for this task
 	Script []string `yaml:"script"`
+	// ScriptFile is the path of a script file (relative to the definition file) that is executed instead of Script,
+	// it is read when loading the definition
+	ScriptFile string `yaml:"script_file"`
 	// DependsOn is a list of task names this task depends on (must be finished before it can start)
 	DependsOn []string `yaml:"depends_on"`
 	// AllowFailure should be set, if the pipeline should continue event if this task had an error
 	AllowFailure bool `yaml:"allow_failure"`
+	// When is an expression over the job variables (e.g. vars.env == "production"), the task is skipped if it is false
+	When string `yaml:"when"`
+	// ChangedPaths skips the task unless files matching the paths changed in git
+	ChangedPaths *ChangedPathsDef `yaml:"changed_paths"`
+	// Context is the name of a context of the pipeline that is started before the task runs (e.g. docker compose services)
+	Context string `yaml:"context"`
 
 	// Env sets/overrides environment variables for this task (takes precedence over pipeline environment)
 	Env map[string]string `yaml:"env"`
+
+	// Interpreter executes the script of this task (defaults to the interpreter of the pipeline)
+	Interpreter Interpreter `yaml:"interpreter"`
+	// Dir is the working directory of the script (relative to the definition file), defaults to the working directory of prunner
+	Dir string `yaml:"dir"`
+	// Timeout cancels a command of the script if it runs longer than the timeout (defaults to 0, no timeout)
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of times the task is retried after a failure (defaults to 0)
+	Retries *int `yaml:"retries"`
+	// NoOutputTimeout marks the task as stuck if it writes no output for the duration (defaults to 0, no watchdog)
+	NoOutputTimeout time.Duration `yaml:"no_output_timeout"`
+	// NoOutputAction is executed if the task is stuck (defaults to kill)
+	NoOutputAction NoOutputAction `yaml:"no_output_action"`
+
+	// Interactive connects the stdin of the task to a pipe, data can be sent to it while the task is running (defaults to false)
+	Interactive bool `yaml:"interactive"`
+
+	// Agent is the name of the agent that executes this task on another host (defaults to the prunner process)
+	Agent string `yaml:"agent"`
 }
 
 func (d TaskDef) Equals(otherDef TaskDef) bool {
+	if d.Uses != otherDef.Uses {
+		return false
+	}
 	if !strSliceEquals(d.Script, otherDef.Script) {
 		return false
 	}
+	if d.ScriptFile != otherDef.ScriptFile {
+		return false
+	}
 	if !strSliceEquals(d.DependsOn, otherDef.DependsOn) {
 		return false
 	}
 	if d.AllowFailure != otherDef.AllowFailure {
 		return false
 	}
+	if d.When != otherDef.When {
+		return false
+	}
+	if !d.ChangedPaths.Equals(otherDef.ChangedPaths) {
+		return false
+	}
+	if d.Context != otherDef.Context {
+		return false
+	}
+	if d.Interpreter != otherDef.Interpreter {
+		return false
+	}
+	if d.Dir != otherDef.Dir {
+		return false
+	}
+	if d.Timeout != otherDef.Timeout {
+		return false
+	}
+	if (d.Retries == nil) != (otherDef.Retries == nil) {
+		return false
+	}
+	if d.Retries != nil && otherDef.Retries != nil && *d.Retries != *otherDef.Retries {
+		return false
+	}
+	if d.NoOutputTimeout != otherDef.NoOutputTimeout {
+		return false
+	}
+	if d.NoOutputAction != otherDef.NoOutputAction {
+		return false
+	}
+	if d.Interactive != otherDef.Interactive {
+		return false
+	}
+	if d.Agent != otherDef.Agent {
+		return false
+	}
 	if len(d.Env) != len(otherDef.Env) {
 		return false
 	}
@@ -40,15 +115,75 @@ func (d TaskDef) Equals(otherDef TaskDef) bool {
 	return true
 }
 
+// ChangedPathsDef skips a task unless files matching one of the paths changed compared to a git ref
+type ChangedPathsDef struct {
+	// Base is the git ref to compare with, it is rendered with the job variables (defaults to HEAD~1)
+	Base string `yaml:"base"`
+	// Paths are globs relative to the root of the repository, ** matches any number of directories
+	Paths []string `yaml:"paths"`
+}
+
+func (d *ChangedPathsDef) Equals(other *ChangedPathsDef) bool {
+	if d == nil || other == nil {
+		return d == other
+	}
+	return d.Base == other.Base && strSliceEquals(d.Paths, other.Paths)
+}
+
+func (d *ChangedPathsDef) String() string {
+	if d == nil {
+		return ""
+	}
+	if d.Base == "" {
+		return strings.Join(d.Paths, ", ")
+	}
+	return strings.Join(d.Paths, ", ") + " (base " + d.Base + ")"
+}
+
+// RetryCount returns the number of retries of the task after a failure
+func (d TaskDef) RetryCount() int {
+	if d.Retries == nil {
+		return 0
+	}
+	return *d.Retries
+}
+
 type PipelineDef struct {
+	// Description is a short human-readable summary of what the pipeline does
+	Description string `yaml:"description"`
+	// Tags group pipelines of a large catalog (e.g. deployment, nightly), pipelines can be filtered by tag
+	Tags []string `yaml:"tags"`
+	// Override replaces a pipeline with the same name of a previous definition file, it is only allowed with the
+	// duplicate policy override (see DuplicatePolicyOverride)
+	Override bool `yaml:"override"`
+
 	// Concurrency declares how many instances of this pipeline are allowed to execute concurrently (defaults to 1)
 	Concurrency int `yaml:"concurrency"`
 	// QueueLimit is the number of slots for queueing jobs if the allowed concurrency is exceeded, defaults to unbounded (nil)
 	QueueLimit *int `yaml:"queue_limit"`
 	// QueueStrategy to use when adding jobs to the queue (defaults to append)
 	QueueStrategy QueueStrategy `yaml:"queue_strategy"`
+	// ReplaceGracePeriod is the time a running job can continue before it is canceled by a new job with queue strategy
+	// replace_running (defaults to 0, cancel immediately)
+	ReplaceGracePeriod time.Duration `yaml:"replace_grace_period"`
 	// StartDelay will delay the start of a job if the value is greater than zero (defaults to 0)
 	StartDelay time.Duration `yaml:"start_delay"`
+	// ScheduleWindow restricts the start of jobs to a daily time window, jobs scheduled outside the window are queued
+	// until it opens (defaults to nil, no restriction)
+	ScheduleWindow *ScheduleWindow `yaml:"schedule_window"`
+	// Timezone for evaluating the schedule window if the window has no timezone (defaults to the timezone of the server)
+	Timezone Timezone `yaml:"timezone"`
+	// QueueTimeout cancels a job as expired if it is on the wait list longer than the timeout (defaults to 0, no timeout)
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	// ScheduleQuotas limit the number of jobs in sliding periods (e.g. max 5 per hour), to guard against runaway
+	// triggers (defaults to no quotas)
+	ScheduleQuotas []ScheduleQuotaDef `yaml:"schedule_quotas"`
+	// JobTimeout cancels a running job if it runs longer than the timeout (defaults to 0, no timeout)
+	JobTimeout time.Duration `yaml:"job_timeout"`
+	// Weight is the number of workers of the worker pool a task of this pipeline uses while it is executed (defaults to 1)
+	Weight int `yaml:"weight"`
+	// Alerts are raised for conditions of the pipeline that need attention (defaults to no alerts)
+	Alerts AlertsDef `yaml:"alerts"`
 
 	// ContinueRunningTasksAfterFailure should be set to true if you want to continue working through all jobs whose
 	// predecessors have not failed. false by default; so by default, if the first job aborts, all others are terminated as well.
@@ -56,17 +191,190 @@ type PipelineDef struct {
 
 	RetentionPeriod time.Duration `yaml:"retention_period"`
 	RetentionCount  int           `yaml:"retention_count"`
+	// LogQuota limits the stored output of all jobs of the pipeline, the logs of the oldest finished jobs are removed
+	// if it is exceeded (defaults to 0, no quota)
+	LogQuota ByteSize `yaml:"log_quota"`
+
+	// PriorityClass sets the CPU and IO priority of task processes (defaults to normal)
+	PriorityClass PriorityClass `yaml:"priority_class"`
+
+	// Interpreter executes the scripts of all tasks (defaults to the built-in shell interpreter)
+	Interpreter Interpreter `yaml:"interpreter"`
 
 	// Env sets/overrides environment variables for all tasks (takes precedence over process environment)
 	Env map[string]string `yaml:"env"`
 
+	// Git checks out a repository before the tasks of a job run, tasks without a dir run in the checkout
+	Git *GitCheckoutDef `yaml:"git"`
+
+	// KeepTmpOnFailure keeps the temporary directory of a job (PRUNNER_TMP) if the job failed, so it can be inspected
+	// (defaults to false, the directory is always removed)
+	KeepTmpOnFailure bool `yaml:"keep_tmp_on_failure"`
+
+	// Contexts are services by name that are started before the first task of a job using them and stopped after the job
+	Contexts map[string]ContextDef `yaml:"contexts"`
+
+	// Services are long-running commands by name (e.g. a dev server) that are started before the tasks of a job and
+	// terminated when the job ends
+	Services map[string]ServiceDef `yaml:"services"`
+
 	Tasks map[string]TaskDef `yaml:"tasks"`
 
 	// SourcePath stores the source path where the pipeline was defined
 	SourcePath string
+	// ShadowedSourcePaths stores the source paths of other declarations of the pipeline that were ignored or overridden
+	// (see DuplicatePolicy)
+	ShadowedSourcePaths []string
+}
+
+// HasTag returns true if the pipeline is tagged with the tag
+func (d PipelineDef) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// GitCheckoutDef configures the git checkout of a pipeline
+type GitCheckoutDef struct {
+	// URL of the repository
+	URL string `yaml:"url"`
+	// Ref is the branch, tag or commit to check out, it is rendered with the job variables (defaults to the default
+	// branch of the repository)
+	Ref string `yaml:"ref"`
+	// Depth limits the fetched history to the number of commits (defaults to 0, the full history)
+	Depth int `yaml:"depth"`
+	// Dir is the directory of the checkout (relative to the definition file), it is reused by all jobs. Defaults to a
+	// temporary directory of each job that is removed after the job.
+	Dir string `yaml:"dir"`
+	// Username for HTTP authentication (defaults to x-access-token if a password is set)
+	Username string `yaml:"username"`
+	// PasswordEnv is the name of the environment variable of the prunner process that contains the password or token
+	// for HTTP authentication
+	PasswordEnv string `yaml:"password_env"`
+}
+
+func (d *GitCheckoutDef) Equals(other *GitCheckoutDef) bool {
+	if d == nil || other == nil {
+		return d == other
+	}
+	return *d == *other
+}
+
+func (d *GitCheckoutDef) String() string {
+	if d == nil {
+		return ""
+	}
+	if d.Ref == "" {
+		return d.URL
+	}
+	return d.URL + "@" + d.Ref
+}
+
+// ContextDef is a context of tasks of a pipeline, a task uses it by setting its context
+type ContextDef struct {
+	// Compose starts services with docker compose
+	Compose *ComposeDef `yaml:"compose"`
+}
+
+// ComposeDef configures services of a context that are managed with docker compose
+type ComposeDef struct {
+	// File is the path of the compose file (relative to the git checkout of the pipeline or the definition file)
+	File string `yaml:"file"`
+	// Services are started (defaults to all services of the compose file)
+	Services []string `yaml:"services"`
+}
+
+func (d ContextDef) Equals(other ContextDef) bool {
+	if d.Compose == nil || other.Compose == nil {
+		return d.Compose == other.Compose
+	}
+	return d.Compose.File == other.Compose.File && strSliceEquals(d.Compose.Services, other.Compose.Services)
+}
+
+func (d ContextDef) String() string {
+	if d.Compose == nil {
+		return ""
+	}
+	if len(d.Compose.Services) == 0 {
+		return "compose " + d.Compose.File
+	}
+	return "compose " + d.Compose.File + " (" + strings.Join(d.Compose.Services, ", ") + ")"
+}
+
+// ServiceDef is a long-running command that runs alongside the tasks of a job
+type ServiceDef struct {
+	// Command is a shell command that runs until it is terminated at the end of the job, it is rendered with the job
+	// variables
+	Command string `yaml:"command"`
+	// Env sets environment variables for the command and the health check
+	Env map[string]string `yaml:"env"`
+	// Dir is the working directory (relative to the definition file), defaults to the git checkout or the working
+	// directory of prunner
+	Dir string `yaml:"dir"`
+	// HealthCheck is a shell command that is repeated until it succeeds before the tasks are started (defaults to no
+	// check)
+	HealthCheck string `yaml:"health_check"`
+	// HealthCheckInterval is the time between health checks (defaults to 1s)
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// StartTimeout fails the job if the service is not healthy within this duration (defaults to 1m)
+	StartTimeout time.Duration `yaml:"start_timeout"`
+}
+
+func (d ServiceDef) Equals(other ServiceDef) bool {
+	if d.Command != other.Command || d.Dir != other.Dir || d.HealthCheck != other.HealthCheck {
+		return false
+	}
+	if d.HealthCheckInterval != other.HealthCheckInterval || d.StartTimeout != other.StartTimeout {
+		return false
+	}
+	if len(d.Env) != len(other.Env) {
+		return false
+	}
+	for k, v := range d.Env {
+		if other.Env[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AlertsDef configures the alerts of a pipeline, an alert is disabled if its duration is 0
+type AlertsDef struct {
+	// QueueFullFor raises an alert if the queue limit is reached for at least this duration
+	QueueFullFor time.Duration `yaml:"queue_full_for"`
+	// NoSuccessFor raises an alert if no job of the pipeline succeeded for this duration
+	NoSuccessFor time.Duration `yaml:"no_success_for"`
+	// SLA is the expected maximum duration of a job, jobs running longer raise an alert and are flagged as breaching it
+	SLA time.Duration `yaml:"sla"`
+	// FailureRate raises an alert if too many of the last finished jobs failed
+	FailureRate FailureRateDef `yaml:"failure_rate"`
+}
+
+// FailureRateDef configures the failure rate alert, it is disabled if Runs is 0
+type FailureRateDef struct {
+	// Runs is the number of the last finished jobs the failure rate is computed from
+	Runs int `yaml:"runs"`
+	// Percent of failed jobs that must be exceeded to raise the alert
+	Percent float64 `yaml:"percent"`
 }
 
 func (d PipelineDef) validate() error {
+	for i, tag := range d.Tags {
+		if tag == "" {
+			return errors.Errorf("tag %d must not be empty", i+1)
+		}
+		if strings.ContainsAny(tag, " \t\n,") {
+			return errors.Errorf("tag %q must not contain whitespace or commas", tag)
+		}
+		for _, other := range d.Tags[:i] {
+			if other == tag {
+				return errors.Errorf("tag %q is declared more than once", tag)
+			}
+		}
+	}
 	if d.Concurrency <= 0 {
 		return errors.New("concurrency must be greater than 0")
 	}
@@ -79,8 +387,115 @@ func (d PipelineDef) validate() error {
 	if d.StartDelay > 0 && d.QueueLimit != nil && *d.QueueLimit == 0 {
 		return errors.New("start_delay needs queue_limit > 0")
 	}
+	if d.ScheduleWindow != nil && d.QueueLimit != nil && *d.QueueLimit == 0 {
+		return errors.New("schedule_window needs queue_limit > 0")
+	}
+	if d.JobTimeout < 0 {
+		return errors.New("job_timeout must not be negative")
+	}
+	if d.ReplaceGracePeriod < 0 {
+		return errors.New("replace_grace_period must not be negative")
+	}
+	if d.QueueTimeout < 0 {
+		return errors.New("queue_timeout must not be negative")
+	}
+	for i, quota := range d.ScheduleQuotas {
+		if quota.Max <= 0 {
+			return errors.Errorf("max of schedule quota %d must be greater than 0", i+1)
+		}
+		if quota.Per <= 0 {
+			return errors.Errorf("per of schedule quota %d must be greater than 0", i+1)
+		}
+		if quota.Action == QuotaActionQueue && d.QueueLimit != nil && *d.QueueLimit == 0 {
+			return errors.Errorf("schedule quota %d with action queue needs queue_limit > 0", i+1)
+		}
+		for _, other := range d.ScheduleQuotas[:i] {
+			if other.Per == quota.Per && other.Action == quota.Action {
+				return errors.Errorf("schedule quota %d has the same period and action as another quota", i+1)
+			}
+		}
+	}
+	if d.Weight < 0 {
+		return errors.New("weight must not be negative")
+	}
+	if d.Alerts.QueueFullFor < 0 {
+		return errors.New("alerts.queue_full_for must not be negative")
+	}
+	if d.Alerts.QueueFullFor > 0 && d.QueueLimit == nil {
+		return errors.New("alerts.queue_full_for needs a queue_limit")
+	}
+	if d.Alerts.NoSuccessFor < 0 {
+		return errors.New("alerts.no_success_for must not be negative")
+	}
+	if d.Alerts.SLA < 0 {
+		return errors.New("alerts.sla must not be negative")
+	}
+	if d.Alerts.FailureRate.Runs < 0 {
+		return errors.New("alerts.failure_rate.runs must not be negative")
+	}
+	if d.Alerts.FailureRate.Runs > 0 && (d.Alerts.FailureRate.Percent < 0 || d.Alerts.FailureRate.Percent >= 100) {
+		return errors.New("alerts.failure_rate.percent must be between 0 and 100")
+	}
+
+	if d.Git != nil {
+		if d.Git.URL == "" {
+			return errors.New("git.url is required")
+		}
+		if d.Git.Depth < 0 {
+			return errors.New("git.depth must not be negative")
+		}
+	}
+
+	for contextName, contextDef := range d.Contexts {
+		if contextDef.Compose == nil {
+			return errors.Errorf("context %q needs compose", contextName)
+		}
+		if contextDef.Compose.File == "" {
+			return errors.Errorf("compose.file of context %q is required", contextName)
+		}
+	}
+
+	for serviceName, serviceDef := range d.Services {
+		if serviceDef.Command == "" {
+			return errors.Errorf("command of service %q is required", serviceName)
+		}
+		if serviceDef.HealthCheckInterval < 0 {
+			return errors.Errorf("health_check_interval of service %q must not be negative", serviceName)
+		}
+		if serviceDef.StartTimeout < 0 {
+			return errors.Errorf("start_timeout of service %q must not be negative", serviceName)
+		}
+	}
 
 	for taskName, taskDef := range d.Tasks {
+		if taskDef.Timeout < 0 {
+			return errors.Errorf("timeout of task %q must not be negative", taskName)
+		}
+		if taskDef.Retries != nil && *taskDef.Retries < 0 {
+			return errors.Errorf("retries of task %q must not be negative", taskName)
+		}
+		if taskDef.NoOutputTimeout < 0 {
+			return errors.Errorf("no_output_timeout of task %q must not be negative", taskName)
+		}
+		if taskDef.When != "" {
+			if _, err := condition.Parse(taskDef.When); err != nil {
+				return errors.Wrapf(err, "invalid when condition of task %q", taskName)
+			}
+		}
+		if taskDef.ChangedPaths != nil && len(taskDef.ChangedPaths.Paths) == 0 {
+			return errors.Errorf("changed_paths of task %q needs at least one path", taskName)
+		}
+		if taskDef.Context != "" {
+			if _, exists := d.Contexts[taskDef.Context]; !exists {
+				return errors.Errorf("missing context %q referenced in context of task %q", taskDef.Context, taskName)
+			}
+			if taskDef.Agent != "" {
+				return errors.Errorf("task %q with a context cannot be executed by an agent", taskName)
+			}
+		}
+		if taskDef.Interactive && taskDef.Agent != "" {
+			return errors.Errorf("interactive task %q cannot be executed by an agent", taskName)
+		}
 		for _, dependentTask := range taskDef.DependsOn {
 			_, exists := d.Tasks[dependentTask]
 			if !exists {
@@ -88,11 +503,23 @@ func (d PipelineDef) validate() error {
 			}
 		}
 	}
+	if cycle := findDependencyCycle(d.Tasks); cycle != nil {
+		return errors.Errorf("depends_on forms a cycle: %s", strings.Join(cycle, " -> "))
+	}
 
 	return nil
 }
 
 func (d PipelineDef) Equals(otherDef PipelineDef) bool {
+	if d.Description != otherDef.Description {
+		return false
+	}
+	if !strSliceEquals(d.Tags, otherDef.Tags) {
+		return false
+	}
+	if d.Override != otherDef.Override {
+		return false
+	}
 	if d.Concurrency != otherDef.Concurrency {
 		return false
 	}
@@ -105,9 +532,41 @@ func (d PipelineDef) Equals(otherDef PipelineDef) bool {
 	if d.QueueStrategy != otherDef.QueueStrategy {
 		return false
 	}
+	if d.ReplaceGracePeriod != otherDef.ReplaceGracePeriod {
+		return false
+	}
 	if d.StartDelay != otherDef.StartDelay {
 		return false
 	}
+	if d.QueueTimeout != otherDef.QueueTimeout {
+		return false
+	}
+	if len(d.ScheduleQuotas) != len(otherDef.ScheduleQuotas) {
+		return false
+	}
+	for i, quota := range d.ScheduleQuotas {
+		if quota != otherDef.ScheduleQuotas[i] {
+			return false
+		}
+	}
+	if d.Timezone.String() != otherDef.Timezone.String() {
+		return false
+	}
+	if (d.ScheduleWindow == nil) != (otherDef.ScheduleWindow == nil) {
+		return false
+	}
+	if d.ScheduleWindow != nil && otherDef.ScheduleWindow != nil && d.ScheduleWindow.String() != otherDef.ScheduleWindow.String() {
+		return false
+	}
+	if d.JobTimeout != otherDef.JobTimeout {
+		return false
+	}
+	if d.Weight != otherDef.Weight {
+		return false
+	}
+	if d.Alerts != otherDef.Alerts {
+		return false
+	}
 	if d.ContinueRunningTasksAfterFailure != otherDef.ContinueRunningTasksAfterFailure {
 		return false
 	}
@@ -117,6 +576,39 @@ func (d PipelineDef) Equals(otherDef PipelineDef) bool {
 	if d.RetentionCount != otherDef.RetentionCount {
 		return false
 	}
+	if d.LogQuota != otherDef.LogQuota {
+		return false
+	}
+	if d.PriorityClass != otherDef.PriorityClass {
+		return false
+	}
+	if d.Interpreter != otherDef.Interpreter {
+		return false
+	}
+	if !d.Git.Equals(otherDef.Git) {
+		return false
+	}
+	if d.KeepTmpOnFailure != otherDef.KeepTmpOnFailure {
+		return false
+	}
+	if len(d.Contexts) != len(otherDef.Contexts) {
+		return false
+	}
+	for name, contextDef := range d.Contexts {
+		otherContextDef, exists := otherDef.Contexts[name]
+		if !exists || !contextDef.Equals(otherContextDef) {
+			return false
+		}
+	}
+	if len(d.Services) != len(otherDef.Services) {
+		return false
+	}
+	for name, serviceDef := range d.Services {
+		otherServiceDef, exists := otherDef.Services[name]
+		if !exists || !serviceDef.Equals(otherServiceDef) {
+			return false
+		}
+	}
 	if len(d.Env) != len(otherDef.Env) {
 		return false
 	}
@@ -141,6 +633,9 @@ func (d PipelineDef) Equals(otherDef PipelineDef) bool {
 	if d.SourcePath != otherDef.SourcePath {
 		return false
 	}
+	if !strSliceEquals(d.ShadowedSourcePaths, otherDef.ShadowedSourcePaths) {
+		return false
+	}
 	return true
 }
 
@@ -151,6 +646,9 @@ const (
 	QueueStrategyAppend QueueStrategy = 0
 	// QueueStrategyReplace replaces pending jobs (with same variables) instead of appending to the queue
 	QueueStrategyReplace QueueStrategy = 1
+	// QueueStrategyReplaceRunning replaces pending jobs like QueueStrategyReplace and additionally cancels the running
+	// job (after the replace grace period), so the new job starts as soon as possible
+	QueueStrategyReplaceRunning QueueStrategy = 2
 )
 
 func (s *QueueStrategy) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -165,6 +663,8 @@ func (s *QueueStrategy) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		*s = QueueStrategyAppend
 	case "replace":
 		*s = QueueStrategyReplace
+	case "replace_running":
+		*s = QueueStrategyReplaceRunning
 	default:
 		return errors.Errorf("unknown queue strategy: %q", strategyName)
 	}
@@ -172,6 +672,280 @@ func (s *QueueStrategy) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+func (s QueueStrategy) String() string {
+	switch s {
+	case QueueStrategyReplace:
+		return "replace"
+	case QueueStrategyReplaceRunning:
+		return "replace_running"
+	}
+	return "append"
+}
+
+type PriorityClass int
+
+const (
+	// PriorityClassNormal starts task processes with the same priority as prunner
+	PriorityClassNormal PriorityClass = 0
+	// PriorityClassLow starts task processes with an increased niceness and lowest best-effort IO priority
+	PriorityClassLow PriorityClass = 1
+	// PriorityClassIdle starts task processes with the highest niceness and idle IO class, so they only run if the host is otherwise idle
+	PriorityClassIdle PriorityClass = 2
+)
+
+func (c *PriorityClass) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var className string
+	err := unmarshal(&className)
+	if err != nil {
+		return err
+	}
+
+	switch className {
+	case "normal":
+		*c = PriorityClassNormal
+	case "low":
+		*c = PriorityClassLow
+	case "idle":
+		*c = PriorityClassIdle
+	default:
+		return errors.Errorf("unknown priority class: %q", className)
+	}
+
+	return nil
+}
+
+// String returns the name of the priority class as used in the definition
+func (c PriorityClass) String() string {
+	switch c {
+	case PriorityClassLow:
+		return "low"
+	case PriorityClassIdle:
+		return "idle"
+	}
+	return "normal"
+}
+
+type NoOutputAction int
+
+const (
+	// NoOutputActionKill cancels a stuck task, so it fails (or is retried)
+	NoOutputActionKill NoOutputAction = 0
+	// NoOutputActionWarn only logs a warning for a stuck task and lets it continue
+	NoOutputActionWarn NoOutputAction = 1
+)
+
+func (a *NoOutputAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var actionName string
+	err := unmarshal(&actionName)
+	if err != nil {
+		return err
+	}
+
+	switch actionName {
+	case "kill":
+		*a = NoOutputActionKill
+	case "warn":
+		*a = NoOutputActionWarn
+	default:
+		return errors.Errorf("unknown no output action: %q", actionName)
+	}
+
+	return nil
+}
+
+// String returns the name of the action as used in the definition
+func (a NoOutputAction) String() string {
+	if a == NoOutputActionWarn {
+		return "warn"
+	}
+	return "kill"
+}
+
+type Interpreter int
+
+const (
+	// InterpreterDefault uses the interpreter of the pipeline for tasks and the built-in shell interpreter for pipelines
+	InterpreterDefault Interpreter = 0
+	// InterpreterShell executes scripts with the built-in POSIX shell interpreter
+	InterpreterShell Interpreter = 1
+	// InterpreterPowerShell executes scripts with Windows PowerShell (powershell)
+	InterpreterPowerShell Interpreter = 2
+	// InterpreterPwsh executes scripts with PowerShell (pwsh)
+	InterpreterPwsh Interpreter = 3
+	// InterpreterCmd executes scripts with the Windows command interpreter (cmd)
+	InterpreterCmd Interpreter = 4
+)
+
+func (i *Interpreter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var interpreterName string
+	err := unmarshal(&interpreterName)
+	if err != nil {
+		return err
+	}
+
+	switch interpreterName {
+	case "sh":
+		*i = InterpreterShell
+	case "powershell":
+		*i = InterpreterPowerShell
+	case "pwsh":
+		*i = InterpreterPwsh
+	case "cmd":
+		*i = InterpreterCmd
+	default:
+		return errors.Errorf("unknown interpreter: %q", interpreterName)
+	}
+
+	return nil
+}
+
+// String returns the name of the interpreter as used in the definition ("default" if not set)
+func (i Interpreter) String() string {
+	switch i {
+	case InterpreterShell:
+		return "sh"
+	case InterpreterPowerShell:
+		return "powershell"
+	case InterpreterPwsh:
+		return "pwsh"
+	case InterpreterCmd:
+		return "cmd"
+	}
+	return "default"
+}
+
+// DefaultsDef declares defaults for all pipelines and tasks of a definition file, they are applied when loading the file
+type DefaultsDef struct {
+	// Env sets environment variables for all pipelines (pipeline and task environment take precedence)
+	Env map[string]string `yaml:"env"`
+	// Dir is the working directory for all tasks (relative to the definition file)
+	Dir string `yaml:"dir"`
+	// Interpreter executes the scripts of all pipelines
+	Interpreter Interpreter `yaml:"interpreter"`
+	// Timeout for commands of all tasks
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of retries for all tasks
+	Retries *int `yaml:"retries"`
+}
+
+// apply sets the defaults for values that are not set in the pipeline or its tasks
+func (d DefaultsDef) apply(pipelineDef *PipelineDef) {
+	if len(d.Env) > 0 {
+		env := make(map[string]string, len(d.Env)+len(pipelineDef.Env))
+		for k, v := range d.Env {
+			env[k] = v
+		}
+		for k, v := range pipelineDef.Env {
+			env[k] = v
+		}
+		pipelineDef.Env = env
+	}
+	if pipelineDef.Interpreter == InterpreterDefault {
+		pipelineDef.Interpreter = d.Interpreter
+	}
+
+	for taskName, taskDef := range pipelineDef.Tasks {
+		if taskDef.Dir == "" {
+			taskDef.Dir = d.Dir
+		}
+		if taskDef.Timeout == 0 {
+			taskDef.Timeout = d.Timeout
+		}
+		if taskDef.Retries == nil {
+			taskDef.Retries = d.Retries
+		}
+		pipelineDef.Tasks[taskName] = taskDef
+	}
+}
+
+// TaskLibrary contains reusable task definitions by name, they can be referenced by tasks with "uses"
+type TaskLibrary map[string]TaskDef
+
+func (l TaskLibrary) validate() error {
+	for name, taskDef := range l {
+		if taskDef.Uses != "" {
+			return errors.Errorf("library task %q must not use another library task", name)
+		}
+		if len(taskDef.DependsOn) > 0 {
+			return errors.Errorf("library task %q must not declare depends_on", name)
+		}
+	}
+	return nil
+}
+
+// resolve replaces tasks that use a library task with the library task merged with the fields set on the task
+func (l TaskLibrary) resolve(pipelineDef *PipelineDef) error {
+	for taskName, taskDef := range pipelineDef.Tasks {
+		if taskDef.Uses == "" {
+			continue
+		}
+		libraryDef, exists := l[taskDef.Uses]
+		if !exists {
+			return errors.Errorf("task %q uses unknown library task %q", taskName, taskDef.Uses)
+		}
+		pipelineDef.Tasks[taskName] = libraryDef.overrideWith(taskDef)
+	}
+	return nil
+}
+
+// overrideWith returns a copy of the task with all fields that are set in override replaced, env is merged
+func (d TaskDef) overrideWith(override TaskDef) TaskDef {
+	result := d
+	result.Uses = override.Uses
+	if len(override.Script) > 0 || override.ScriptFile != "" {
+		result.Script = override.Script
+		result.ScriptFile = override.ScriptFile
+	}
+	result.DependsOn = override.DependsOn
+	if override.AllowFailure {
+		result.AllowFailure = true
+	}
+	if len(override.Env) > 0 {
+		env := make(map[string]string, len(d.Env)+len(override.Env))
+		for k, v := range d.Env {
+			env[k] = v
+		}
+		for k, v := range override.Env {
+			env[k] = v
+		}
+		result.Env = env
+	}
+	if override.Interpreter != InterpreterDefault {
+		result.Interpreter = override.Interpreter
+	}
+	if override.Dir != "" {
+		result.Dir = override.Dir
+	}
+	if override.When != "" {
+		result.When = override.When
+	}
+	if override.ChangedPaths != nil {
+		result.ChangedPaths = override.ChangedPaths
+	}
+	if override.Context != "" {
+		result.Context = override.Context
+	}
+	if override.Timeout != 0 {
+		result.Timeout = override.Timeout
+	}
+	if override.Retries != nil {
+		result.Retries = override.Retries
+	}
+	if override.NoOutputTimeout != 0 {
+		result.NoOutputTimeout = override.NoOutputTimeout
+	}
+	if override.NoOutputAction != NoOutputActionKill {
+		result.NoOutputAction = override.NoOutputAction
+	}
+	if override.Interactive {
+		result.Interactive = true
+	}
+	if override.Agent != "" {
+		result.Agent = override.Agent
+	}
+	return result
+}
+
 type PipelinesMap map[string]PipelineDef
 
 type PipelinesDef struct {
@@ -185,6 +959,10 @@ func (d *PipelinesDef) setDefaults() {
 			pipelineDef.Concurrency = 1
 			d.Pipelines[pipeline] = pipelineDef
 		}
+		if pipelineDef.Weight == 0 {
+			pipelineDef.Weight = 1
+			d.Pipelines[pipeline] = pipelineDef
+		}
 	}
 }
 
@@ -0,0 +1,57 @@
+package definition
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ScheduleQuotaDef limits the number of jobs of a pipeline in a sliding period, e.g. "max 5 per 1h"
+type ScheduleQuotaDef struct {
+	// Max is the number of jobs allowed in the period
+	Max int `yaml:"max"`
+	// Per is the length of the sliding period
+	Per time.Duration `yaml:"per"`
+	// Action if the quota is exceeded (defaults to reject)
+	Action QuotaAction `yaml:"action"`
+}
+
+func (d ScheduleQuotaDef) String() string {
+	return fmt.Sprintf("%d per %s", d.Max, d.Per)
+}
+
+type QuotaAction int
+
+const (
+	// QuotaActionReject rejects scheduling a job if Max jobs were scheduled within the period
+	QuotaActionReject QuotaAction = 0
+	// QuotaActionQueue queues a job if Max jobs were started within the period, it is started when the period allows it
+	QuotaActionQueue QuotaAction = 1
+)
+
+func (a *QuotaAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var actionName string
+	err := unmarshal(&actionName)
+	if err != nil {
+		return err
+	}
+
+	switch actionName {
+	case "reject", "":
+		*a = QuotaActionReject
+	case "queue":
+		*a = QuotaActionQueue
+	default:
+		return errors.Errorf("unknown quota action: %q", actionName)
+	}
+
+	return nil
+}
+
+func (a QuotaAction) String() string {
+	if a == QuotaActionQueue {
+		return "queue"
+	}
+	return "reject"
+}
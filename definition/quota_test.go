@@ -0,0 +1,35 @@
+package definition_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+func TestScheduleQuotaDef_UnmarshalYAML(t *testing.T) {
+	var def struct {
+		Quotas []definition.ScheduleQuotaDef `yaml:"schedule_quotas"`
+	}
+	err := yaml.Unmarshal([]byte(`
+schedule_quotas:
+  - max: 5
+    per: 1h
+  - max: 50
+    per: 24h
+    action: queue
+`), &def)
+	require.NoError(t, err)
+	assert.Equal(t, []definition.ScheduleQuotaDef{
+		{Max: 5, Per: time.Hour, Action: definition.QuotaActionReject},
+		{Max: 50, Per: 24 * time.Hour, Action: definition.QuotaActionQueue},
+	}, def.Quotas)
+	assert.Equal(t, "5 per 1h0m0s", def.Quotas[0].String())
+
+	err = yaml.Unmarshal([]byte("schedule_quotas: [{max: 5, per: 1h, action: drop}]"), &def)
+	assert.Error(t, err)
+}
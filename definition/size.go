@@ -0,0 +1,73 @@
+package definition
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ByteSize is a size in bytes, it is set in the definition as a number of bytes or with a unit, e.g. "500MB" or "2GiB"
+type ByteSize int64
+
+// byteUnits are the units of a ByteSize by suffix (KB, MB, ... are decimal, KiB, MiB, ... are binary)
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	// Longer suffixes first, so "KiB" is not matched as "B"
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size in bytes with an optional unit (B, KB, MB, GB, TB, KiB, MiB, GiB or TiB)
+func ParseByteSize(s string) (ByteSize, error) {
+	value := strings.TrimSpace(s)
+	factor := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid size %q, expected a number of bytes with an optional unit like MB or GiB", s)
+	}
+	return ByteSize(n * float64(factor)), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// String returns the size with the largest binary unit that represents it exactly
+func (b ByteSize) String() string {
+	for i := 3; i >= 0; i-- {
+		unit := byteUnits[i]
+		if b != 0 && int64(b)%unit.factor == 0 {
+			return strconv.FormatInt(int64(b)/unit.factor, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
@@ -0,0 +1,48 @@
+package definition_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected definition.ByteSize
+	}{
+		{"1024", 1024},
+		{"100B", 100},
+		{"500MB", 500 * 1000 * 1000},
+		{"1.5 kb", 1500},
+		{"2GiB", 2 << 30},
+		{"512mib", 512 << 20},
+	}
+	for _, tt := range tests {
+		size, err := definition.ParseByteSize(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, size, tt.input)
+	}
+
+	for _, invalid := range []string{"", "MB", "-1MB", "10 apples"} {
+		_, err := definition.ParseByteSize(invalid)
+		assert.Error(t, err, "size %q should be invalid", invalid)
+	}
+}
+
+func TestByteSize_UnmarshalYAML(t *testing.T) {
+	var def struct {
+		Quota definition.ByteSize `yaml:"quota"`
+		Plain definition.ByteSize `yaml:"plain"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte("quota: 1GiB\nplain: 2048\n"), &def))
+	assert.Equal(t, definition.ByteSize(1<<30), def.Quota)
+	assert.Equal(t, definition.ByteSize(2048), def.Plain)
+	assert.Equal(t, "1GiB", def.Quota.String())
+	assert.Equal(t, "2KiB", def.Plain.String())
+	assert.Equal(t, "1000B", definition.ByteSize(1000).String())
+}
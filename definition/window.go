@@ -0,0 +1,157 @@
+package definition
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ScheduleWindow is a daily time window in which jobs of a pipeline are started, e.g. "22:00-06:00" or with a
+// timezone "22:00-06:00 Europe/Berlin"
+type ScheduleWindow struct {
+	// Start and End are the times of day as offset from midnight, a window with End before Start spans midnight
+	Start time.Duration
+	End   time.Duration
+	// Location of the times of day, nil for the local time of the server
+	Location *time.Location
+}
+
+// ParseScheduleWindow parses a window in the format "HH:MM-HH:MM" with an optional IANA timezone after a space
+func ParseScheduleWindow(s string) (ScheduleWindow, error) {
+	var w ScheduleWindow
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, errors.Errorf("invalid schedule window %q, expected HH:MM-HH:MM with an optional timezone", s)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, errors.Errorf("invalid schedule window %q, expected HH:MM-HH:MM with an optional timezone", s)
+	}
+	var err error
+	w.Start, err = parseTimeOfDay(times[0])
+	if err != nil {
+		return w, errors.Wrapf(err, "invalid start of schedule window %q", s)
+	}
+	w.End, err = parseTimeOfDay(times[1])
+	if err != nil {
+		return w, errors.Wrapf(err, "invalid end of schedule window %q", s)
+	}
+	if w.Start == w.End {
+		return w, errors.Errorf("start and end of schedule window %q must not be equal", s)
+	}
+
+	if len(fields) == 2 {
+		w.Location, err = time.LoadLocation(fields[1])
+		if err != nil {
+			return w, errors.Wrapf(err, "invalid timezone of schedule window %q", s)
+		}
+	}
+
+	return w, nil
+}
+
+// InLocation returns the window in the given location if the window has no timezone
+func (w ScheduleWindow) InLocation(loc *time.Location) ScheduleWindow {
+	if w.Location == nil {
+		w.Location = loc
+	}
+	return w
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w *ScheduleWindow) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	*w, err = ParseScheduleWindow(s)
+	return err
+}
+
+func (w ScheduleWindow) String() string {
+	s := fmt.Sprintf("%s-%s", formatTimeOfDay(w.Start), formatTimeOfDay(w.End))
+	if w.Location != nil {
+		s += " " + w.Location.String()
+	}
+	return s
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func (w ScheduleWindow) location() *time.Location {
+	if w.Location == nil {
+		return time.Local
+	}
+	return w.Location
+}
+
+// Contains returns true if the time is inside the window
+func (w ScheduleWindow) Contains(t time.Time) bool {
+	t = t.In(w.location())
+	timeOfDay := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.Start < w.End {
+		return timeOfDay >= w.Start && timeOfDay < w.End
+	}
+	// The window spans midnight
+	return timeOfDay >= w.Start || timeOfDay < w.End
+}
+
+// NextOpen returns the next time after t when the window opens
+func (w ScheduleWindow) NextOpen(t time.Time) time.Time {
+	loc := w.location()
+	year, month, day := t.In(loc).Date()
+	hour, minute := int(w.Start/time.Hour), int(w.Start%time.Hour/time.Minute)
+
+	open := time.Date(year, month, day, hour, minute, 0, 0, loc)
+	if !open.After(t) {
+		open = time.Date(year, month, day+1, hour, minute, 0, 0, loc)
+	}
+	return open
+}
+
+// Timezone is an IANA timezone (e.g. "Europe/Berlin") for evaluating the time-based settings of a pipeline
+type Timezone struct {
+	// Location is nil if no timezone is set
+	Location *time.Location
+}
+
+func (tz *Timezone) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	err := unmarshal(&name)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		tz.Location = nil
+		return nil
+	}
+	tz.Location, err = time.LoadLocation(name)
+	if err != nil {
+		return errors.Wrapf(err, "invalid timezone %q", name)
+	}
+	return nil
+}
+
+func (tz Timezone) String() string {
+	if tz.Location == nil {
+		return ""
+	}
+	return tz.Location.String()
+}
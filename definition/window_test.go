@@ -0,0 +1,77 @@
+package definition_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+func TestParseScheduleWindow(t *testing.T) {
+	w, err := definition.ParseScheduleWindow("22:00-06:30 Europe/Berlin")
+	require.NoError(t, err)
+	assert.Equal(t, 22*time.Hour, w.Start)
+	assert.Equal(t, 6*time.Hour+30*time.Minute, w.End)
+	assert.Equal(t, "Europe/Berlin", w.Location.String())
+	assert.Equal(t, "22:00-06:30 Europe/Berlin", w.String())
+
+	w, err = definition.ParseScheduleWindow("09:00-17:00")
+	require.NoError(t, err)
+	assert.Nil(t, w.Location)
+
+	for _, invalid := range []string{"", "22:00", "22:00-25:00", "10:00-10:00", "22:00-06:00 Mars/Olympus"} {
+		_, err = definition.ParseScheduleWindow(invalid)
+		assert.Error(t, err, "window %q should be invalid", invalid)
+	}
+}
+
+func TestScheduleWindow_Contains(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	overnight := definition.ScheduleWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: berlin}
+	assert.True(t, overnight.Contains(time.Date(2021, 10, 11, 23, 0, 0, 0, berlin)))
+	assert.True(t, overnight.Contains(time.Date(2021, 10, 11, 5, 59, 0, 0, berlin)))
+	assert.False(t, overnight.Contains(time.Date(2021, 10, 11, 6, 0, 0, 0, berlin)))
+	assert.False(t, overnight.Contains(time.Date(2021, 10, 11, 12, 0, 0, 0, berlin)))
+	// 20:30 UTC is 22:30 in Berlin during summer time
+	assert.True(t, overnight.Contains(time.Date(2021, 7, 1, 20, 30, 0, 0, time.UTC)))
+
+	daytime := definition.ScheduleWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Location: berlin}
+	assert.True(t, daytime.Contains(time.Date(2021, 10, 11, 9, 0, 0, 0, berlin)))
+	assert.False(t, daytime.Contains(time.Date(2021, 10, 11, 17, 0, 0, 0, berlin)))
+}
+
+func TestScheduleWindow_NextOpen(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	w := definition.ScheduleWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: berlin}
+	assert.Equal(t,
+		time.Date(2021, 10, 11, 22, 0, 0, 0, berlin),
+		w.NextOpen(time.Date(2021, 10, 11, 12, 0, 0, 0, berlin)),
+	)
+
+	// The window opens at the same time of day after a change of the daylight saving time
+	assert.Equal(t,
+		time.Date(2021, 10, 31, 22, 0, 0, 0, berlin),
+		w.NextOpen(time.Date(2021, 10, 30, 22, 30, 0, 0, berlin)),
+	)
+}
+
+func TestTimezone_UnmarshalYAML(t *testing.T) {
+	var def struct {
+		Timezone definition.Timezone `yaml:"timezone"`
+	}
+
+	err := yaml.Unmarshal([]byte("timezone: America/New_York\n"), &def)
+	require.NoError(t, err)
+	assert.Equal(t, "America/New_York", def.Timezone.String())
+
+	err = yaml.Unmarshal([]byte("timezone: Mars/Olympus\n"), &def)
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+// Package engine embeds prunner in a Go application: the pipeline runner and the HTTP API run in-process instead of
+// starting the prunner binary.
+//
+// It is a separate package, since the server package depends on the prunner package.
+package engine
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+
+	"github.com/friendsofgo/errors"
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/server"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// DefaultPattern is the default search pattern for pipeline configurations (like the --pattern flag)
+const DefaultPattern = "**/pipelines.{yml,yaml}"
+
+// minJWTSecretLength is the same as for the config file
+const minJWTSecretLength = 16
+
+// Options configures an embedded prunner
+type Options struct {
+	// Path is the base directory for the pipeline configuration scan (defaults to the working directory)
+	Path string
+	// Pattern is the search pattern (glob) for pipeline configurations in Path (defaults to DefaultPattern)
+	Pattern string
+	// DuplicatePolicy decides how a pipeline declared in more than one configuration is handled (defaults to
+	// definition.DuplicatePolicyError)
+	DuplicatePolicy definition.DuplicatePolicy
+	// Definitions are used instead of scanning for pipeline configurations if set
+	Definitions *definition.PipelinesDef
+
+	// DataDir is the directory for the job state and logs of tasks, it is only optional if DataStore and OutputStore
+	// are set (e.g. store.NewMemoryDataStore to not persist jobs)
+	DataDir     string
+	DataStore   store.DataStore
+	OutputStore taskctl.OutputStore
+
+	// JWTSecret is used for authenticating requests to the HTTP API, the HTTP API is not available if it is empty
+	JWTSecret string
+	// RequestLogger is a middleware for logging requests to the HTTP API, requests are not logged if it is nil
+	RequestLogger func(http.Handler) http.Handler
+	// EnableProfiling serves the pprof endpoints in the HTTP API
+	EnableProfiling bool
+	// ServerOpts are additional options for the HTTP API
+	ServerOpts []server.Opts
+	// RunnerOpts are options for the pipeline runner (e.g. prunner.WithPersistInterval)
+	RunnerOpts []prunner.Opts
+	// TaskRunnerOpts are options for the task runner of all jobs (e.g. prunner.WithWorkerPool)
+	TaskRunnerOpts []prunner.TaskRunnerOpts
+}
+
+// App is an embedded prunner with a pipeline runner and the HTTP API
+type App struct {
+	Runner      *prunner.PipelineRunner
+	Definitions *definition.PipelinesDef
+	DataStore   store.DataStore
+	OutputStore taskctl.OutputStore
+
+	handler http.Handler
+}
+
+// New loads the pipeline definitions and the job state and creates the pipeline runner. The context controls
+// background operations like saving the job state, Shutdown should be called before it is canceled.
+func New(ctx context.Context, opts Options) (*App, error) {
+	if opts.JWTSecret != "" && len(opts.JWTSecret) < minJWTSecretLength {
+		return nil, errors.Errorf("JWT secret must be at least %d characters long", minJWTSecretLength)
+	}
+
+	defs := opts.Definitions
+	if defs == nil {
+		pattern := opts.Pattern
+		if pattern == "" {
+			pattern = DefaultPattern
+		}
+		var err error
+		var loadOpts []definition.LoadOpts
+		if opts.DuplicatePolicy != "" {
+			loadOpts = append(loadOpts, definition.WithDuplicatePolicy(opts.DuplicatePolicy))
+		}
+		defs, err = definition.LoadRecursively(filepath.Join(opts.Path, pattern), loadOpts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading definitions")
+		}
+	}
+
+	outputStore := opts.OutputStore
+	if outputStore == nil {
+		if opts.DataDir == "" {
+			return nil, errors.New("data dir or output store must be set")
+		}
+		var err error
+		outputStore, err = taskctl.NewOutputStore(filepath.Join(opts.DataDir, "logs"))
+		if err != nil {
+			return nil, errors.Wrap(err, "building output store")
+		}
+	}
+
+	dataStore := opts.DataStore
+	if dataStore == nil {
+		if opts.DataDir == "" {
+			return nil, errors.New("data dir or data store must be set")
+		}
+		var err error
+		dataStore, err = store.NewJSONDataStore(opts.DataDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "building pipeline runner store")
+		}
+	}
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, prunner.NewTaskRunner(outputStore, opts.TaskRunnerOpts...), dataStore, outputStore, opts.RunnerOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &App{
+		Runner:      pRunner,
+		Definitions: defs,
+		DataStore:   dataStore,
+		OutputStore: outputStore,
+	}
+
+	if opts.JWTSecret != "" {
+		requestLogger := opts.RequestLogger
+		if requestLogger == nil {
+			requestLogger = func(next http.Handler) http.Handler { return next }
+		}
+		var serverOpts []server.Opts
+		if opts.DataDir != "" {
+			serverOpts = append(serverOpts, server.WithDataDir(opts.DataDir))
+		}
+		serverOpts = append(serverOpts, opts.ServerOpts...)
+
+		app.handler = server.NewServer(
+			pRunner,
+			outputStore,
+			requestLogger,
+			jwtauth.New("HS256", []byte(opts.JWTSecret), nil),
+			opts.EnableProfiling,
+			serverOpts...,
+		)
+	}
+
+	return app, nil
+}
+
+// Handler returns the HTTP API, requests must be authenticated with a JWT signed by the JWTSecret of the options.
+// It returns nil if no JWTSecret was set.
+func (a *App) Handler() http.Handler {
+	return a.handler
+}
+
+// Shutdown cancels jobs on the wait list, waits until running jobs are finished (they are canceled when the context
+// is done) and saves the job state
+func (a *App) Shutdown(ctx context.Context) error {
+	return a.Runner.Shutdown(ctx)
+}
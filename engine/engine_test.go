@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/test"
+)
+
+func TestNew(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "pipelines.yml"), []byte(`pipelines:
+  hello:
+    tasks:
+      greet:
+        script:
+          - echo "Hello"
+`), 0644)
+	require.NoError(t, err)
+
+	app, err := New(ctx, Options{
+		Path:      dir,
+		DataDir:   filepath.Join(dir, ".prunner"),
+		JWTSecret: "not-very-secret-but-long",
+	})
+	require.NoError(t, err)
+
+	job, err := app.Runner.ScheduleAsync("hello", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	require.NoError(t, app.Runner.WaitForJob(waitCtx, job.ID))
+
+	_ = app.Runner.ReadJob(job.ID, func(j *prunner.PipelineJob) {
+		assert.True(t, j.Completed)
+		assert.Nil(t, j.LastError)
+	})
+
+	_, tokenString, _ := jwtauth.New("HS256", []byte("not-very-secret-but-long"), nil).Encode(map[string]interface{}{})
+	req := httptest.NewRequest(http.MethodGet, "/pipelines/jobs", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), job.ID.String())
+
+	require.NoError(t, app.Shutdown(ctx))
+	assert.FileExists(t, filepath.Join(dir, ".prunner", "logs", job.ID.String(), "greet-stdout.log"))
+}
+
+func TestNew_WithMemoryStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := New(ctx, Options{
+		Path:      t.TempDir(),
+		DataStore: store.NewMemoryDataStore(),
+	})
+	assert.EqualError(t, err, "data dir or output store must be set")
+
+	app, err := New(ctx, Options{
+		Path:        t.TempDir(),
+		DataStore:   store.NewMemoryDataStore(),
+		OutputStore: test.NewMockOutputStore(),
+	})
+	require.NoError(t, err)
+	assert.Nil(t, app.Handler(), "no HTTP API without JWT secret")
+
+	_, err = New(ctx, Options{
+		Path:      t.TempDir(),
+		DataDir:   t.TempDir(),
+		JWTSecret: "short",
+	})
+	assert.EqualError(t, err, "JWT secret must be at least 16 characters long")
+}
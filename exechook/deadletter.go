@@ -0,0 +1,167 @@
+package exechook
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+)
+
+// DeadLetter is a notification of the on-complete hook that still failed after all retries
+type DeadLetter struct {
+	ID       string `json:"id"`
+	JobID    string `json:"jobId"`
+	Pipeline string `json:"pipeline"`
+	// Command is the hook command the notification was sent to
+	Command string `json:"command"`
+	// Payload is the input of the command (the Job as JSON)
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of failed attempts including redeliveries
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	Failed    time.Time `json:"failed"`
+}
+
+// ErrDeadLetterNotFound is returned if no dead letter with the ID exists
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetterStore persists dead letters in a JSON file, so failed notifications survive restarts and can be
+// redelivered
+type DeadLetterStore struct {
+	path string
+
+	mx sync.Mutex
+}
+
+// NewDeadLetterStore returns a store that keeps dead letters in the file at path
+func NewDeadLetterStore(path string) *DeadLetterStore {
+	return &DeadLetterStore{path: path}
+}
+
+// Add adds a dead letter, a new ID is assigned if it has none
+func (s *DeadLetterStore) Add(letter DeadLetter) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if letter.ID == "" {
+		letter.ID = uuid.Must(uuid.NewV4()).String()
+	}
+
+	letters, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(append(letters, letter))
+}
+
+// List returns all dead letters ordered by the time they were added
+func (s *DeadLetterStore) List() ([]DeadLetter, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.read()
+}
+
+// Remove removes a dead letter, e.g. after it was handled manually
+func (s *DeadLetterStore) Remove(id string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	letters, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i, letter := range letters {
+		if letter.ID == id {
+			return s.write(append(letters[:i], letters[i+1:]...))
+		}
+	}
+	return ErrDeadLetterNotFound
+}
+
+// Redeliver runs the command of a dead letter again with its payload. The dead letter is removed if the command
+// succeeds, otherwise its attempts and last error are updated and the error is returned.
+func (s *DeadLetterStore) Redeliver(id string, timeout time.Duration) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	letters, err := s.read()
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, letter := range letters {
+		if letter.ID == id {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return ErrDeadLetterNotFound
+	}
+
+	letter := &letters[index]
+	args, err := parseCommand(letter.Command)
+	if err != nil {
+		return err
+	}
+	_, runErr := run(args, letter.Payload, timeout)
+	if runErr == nil {
+		return s.write(append(letters[:index], letters[index+1:]...))
+	}
+
+	letter.Attempts++
+	letter.LastError = runErr.Error()
+	letter.Failed = time.Now()
+	if err := s.write(letters); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// read must be called with the lock held
+func (s *DeadLetterStore) read() ([]DeadLetter, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return []DeadLetter{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading dead letters")
+	}
+
+	var letters []DeadLetter
+	err = json.Unmarshal(data, &letters)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding dead letters")
+	}
+	return letters, nil
+}
+
+// write replaces the file with the dead letters via a temporary file, it must be called with the lock held
+func (s *DeadLetterStore) write(letters []DeadLetter) error {
+	data, err := json.Marshal(letters)
+	if err != nil {
+		return errors.Wrap(err, "encoding dead letters")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), "dead-letters.*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	tmpFilename := f.Name()
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		os.Remove(tmpFilename)
+		return errors.Wrap(err, "writing dead letters")
+	}
+
+	err = os.Rename(tmpFilename, s.path)
+	if err != nil {
+		return errors.Wrap(err, "replacing dead letters file by rename")
+	}
+	return nil
+}
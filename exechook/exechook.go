@@ -0,0 +1,338 @@
+// Package exechook runs external commands as hooks of the pipeline runner, so prunner can be extended without
+// embedding it in a Go application.
+//
+// A command gets a JSON document on stdin (see ScheduleRequest and Job). It is started directly (not via a shell),
+// arguments are split like in a shell.
+package exechook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"mvdan.cc/sh/v3/shell"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/helper"
+)
+
+// ScheduleRequest is passed to an on-schedule hook
+type ScheduleRequest struct {
+	Pipeline  string                 `json:"pipeline"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	User      string                 `json:"user"`
+	Priority  string                 `json:"priority"`
+	Labels    map[string]string      `json:"labels,omitempty"`
+}
+
+// ScheduleResponse can be written to stdout by an on-schedule hook to add or override variables and labels of the job
+type ScheduleResponse struct {
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	Labels    map[string]string      `json:"labels,omitempty"`
+}
+
+// Job is passed to an on-complete hook, the fields are the same as in the job details of the HTTP API
+type Job struct {
+	ID         string     `json:"id"`
+	Pipeline   string     `json:"pipeline"`
+	Tasks      []Task     `json:"tasks"`
+	Completed  bool       `json:"completed"`
+	Canceled   bool       `json:"canceled"`
+	Incomplete bool       `json:"incomplete"`
+	Expired    bool       `json:"expired"`
+	Errored    bool       `json:"errored"`
+	Created    time.Time  `json:"created"`
+	Start      *time.Time `json:"start,omitempty"`
+	End        *time.Time `json:"end,omitempty"`
+	LastError  *string    `json:"lastError,omitempty"`
+
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	User      string                 `json:"user"`
+	Priority  string                 `json:"priority"`
+	Labels    map[string]string      `json:"labels,omitempty"`
+}
+
+// Alert is passed to an on-alert hook when an alert of a pipeline is raised or resolved
+type Alert struct {
+	Pipeline string `json:"pipeline"`
+	// Alert is the kind of the alert (queue_full or no_success)
+	Alert   string     `json:"alert"`
+	Active  bool       `json:"active"`
+	Since   *time.Time `json:"since,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// Task is a task of a Job
+type Task struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Skipped  bool       `json:"skipped"`
+	ExitCode int16      `json:"exitCode"`
+	Errored  bool       `json:"errored"`
+	// AllowedFailure is set if the task errored but has allow_failure
+	AllowedFailure bool    `json:"allowedFailure"`
+	Error          *string `json:"error,omitempty"`
+}
+
+// NewScheduleHook returns a pre-schedule hook that runs the command with a ScheduleRequest on stdin.
+// The job is rejected if the command fails, the (trimmed) stderr output is used as the reason. The command can write a
+// ScheduleResponse to stdout.
+func NewScheduleHook(command string, timeout time.Duration) (prunner.PreScheduleHook, error) {
+	args, err := parseCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(pipeline string, opts *prunner.ScheduleOpts) error {
+		input, err := json.Marshal(ScheduleRequest{
+			Pipeline:  pipeline,
+			Variables: opts.Variables,
+			User:      opts.User,
+			Priority:  opts.Priority.String(),
+			Labels:    opts.Labels,
+		})
+		if err != nil {
+			return errors.Wrap(err, "encoding schedule request")
+		}
+
+		output, err := run(args, input, timeout)
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(output)) == 0 {
+			return nil
+		}
+
+		var resp ScheduleResponse
+		err = json.Unmarshal(output, &resp)
+		if err != nil {
+			return errors.Wrap(err, "decoding output of on-schedule hook")
+		}
+		if len(resp.Variables) > 0 && opts.Variables == nil {
+			opts.Variables = make(map[string]interface{}, len(resp.Variables))
+		}
+		for name, value := range resp.Variables {
+			opts.Variables[name] = value
+		}
+		if len(resp.Labels) > 0 && opts.Labels == nil {
+			opts.Labels = make(map[string]string, len(resp.Labels))
+		}
+		for name, value := range resp.Labels {
+			opts.Labels[name] = value
+		}
+
+		return nil
+	}, nil
+}
+
+// CompleteHookOpts configures an on-complete hook
+type CompleteHookOpts func(*completeHookOptions)
+
+type completeHookOptions struct {
+	retries     int
+	retryDelay  time.Duration
+	deadLetters *DeadLetterStore
+}
+
+// WithRetries retries a failed on-complete hook up to retries times, the delay doubles after each attempt
+func WithRetries(retries int, delay time.Duration) CompleteHookOpts {
+	return func(o *completeHookOptions) {
+		o.retries = retries
+		o.retryDelay = delay
+	}
+}
+
+// WithDeadLetters adds notifications to the store if the on-complete hook still fails after all retries
+func WithDeadLetters(store *DeadLetterStore) CompleteHookOpts {
+	return func(o *completeHookOptions) {
+		o.deadLetters = store
+	}
+}
+
+// NewCompleteHook returns a post-complete hook that runs the command with the finished Job on stdin, errors are
+// logged since the job cannot be changed anymore
+func NewCompleteHook(command string, timeout time.Duration, opts ...CompleteHookOpts) (prunner.PostCompleteHook, error) {
+	args, err := parseCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var options completeHookOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	return func(j *prunner.PipelineJob) {
+		logger := log.
+			WithField("component", "exechook").
+			WithField("jobID", j.ID).
+			WithField("pipeline", j.Pipeline)
+
+		input, err := json.Marshal(jobFromPipelineJob(j))
+		if err != nil {
+			logger.
+				WithError(err).
+				Error("Error encoding job for on-complete hook")
+			return
+		}
+
+		attempts := 0
+		delay := options.retryDelay
+		for {
+			attempts++
+			_, err = run(args, input, timeout)
+			if err == nil {
+				return
+			}
+			if attempts > options.retries {
+				break
+			}
+			logger.
+				WithError(err).
+				WithField("attempt", attempts).
+				Debugf("On-complete hook failed, retrying in %s", delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		logger.
+			WithError(err).
+			WithField("attempts", attempts).
+			Warn("On-complete hook failed")
+
+		if options.deadLetters == nil {
+			return
+		}
+		err = options.deadLetters.Add(DeadLetter{
+			JobID:     j.ID.String(),
+			Pipeline:  j.Pipeline,
+			Command:   command,
+			Payload:   input,
+			Attempts:  attempts,
+			LastError: err.Error(),
+			Failed:    time.Now(),
+		})
+		if err != nil {
+			logger.
+				WithError(err).
+				Error("Error adding failed on-complete hook to dead letters")
+		}
+	}, nil
+}
+
+// NewAlertHook returns an alert hook that runs the command with the Alert on stdin, errors are logged
+func NewAlertHook(command string, timeout time.Duration) (prunner.AlertHook, error) {
+	args, err := parseCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(alert prunner.Alert) {
+		logger := log.
+			WithField("component", "exechook").
+			WithField("pipeline", alert.Pipeline).
+			WithField("alert", alert.Kind)
+
+		input, err := json.Marshal(Alert{
+			Pipeline: alert.Pipeline,
+			Alert:    string(alert.Kind),
+			Active:   alert.Active,
+			Since:    alert.Since,
+			Message:  alert.Message,
+		})
+		if err != nil {
+			logger.
+				WithError(err).
+				Error("Error encoding alert for on-alert hook")
+			return
+		}
+
+		_, err = run(args, input, timeout)
+		if err != nil {
+			logger.
+				WithError(err).
+				Warn("On-alert hook failed")
+		}
+	}, nil
+}
+
+func parseCommand(command string) ([]string, error) {
+	args, err := shell.Fields(command, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing hook command %q", command)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("hook command must not be empty")
+	}
+	return args, nil
+}
+
+// run starts the command with input on stdin and returns its stdout, the process is killed after the timeout
+func run(args []string, input []byte, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("hook %s timed out after %s", args[0], timeout)
+	}
+	if err != nil {
+		if reason := strings.TrimSpace(stderr.String()); reason != "" {
+			return nil, errors.New(reason)
+		}
+		return nil, fmt.Errorf("hook %s failed: %w", args[0], err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func jobFromPipelineJob(j *prunner.PipelineJob) Job {
+	job := Job{
+		ID:         j.ID.String(),
+		Pipeline:   j.Pipeline,
+		Completed:  j.Completed,
+		Canceled:   j.Canceled,
+		Incomplete: j.Incomplete,
+		Expired:    j.Expired,
+		Created:    j.Created,
+		Start:      j.Start,
+		End:        j.End,
+		LastError:  helper.ErrToStrPtr(j.LastError),
+
+		Variables: j.Variables,
+		User:      j.User,
+		Priority:  j.Priority.String(),
+		Labels:    j.Labels,
+	}
+	for _, t := range j.Tasks {
+		job.Tasks = append(job.Tasks, Task{
+			Name:     t.Name,
+			Status:   t.Status,
+			Start:    t.Start,
+			End:      t.End,
+			Skipped:  t.Skipped,
+			ExitCode: t.ExitCode,
+			Errored:  t.Errored,
+			Error:    helper.ErrToStrPtr(t.Error),
+
+			AllowedFailure: t.Errored && t.AllowFailure,
+		})
+		// Failures of tasks with allow_failure do not count, like in the job details of the API
+		job.Errored = job.Errored || (t.Errored && !t.AllowFailure)
+	}
+	return job
+}
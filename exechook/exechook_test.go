@@ -0,0 +1,216 @@
+package exechook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/taskctl/taskctl/pkg/task"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/test"
+)
+
+func TestNewScheduleHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available on Windows")
+	}
+
+	hook, err := NewScheduleHook(`sh -c 'cat > /dev/null; echo "{\"variables\": {\"approved\": true}, \"labels\": {\"source\": \"hook\"}}"'`, time.Second)
+	require.NoError(t, err)
+
+	opts := prunner.ScheduleOpts{Variables: map[string]interface{}{"tag": "v1.0.0"}}
+	err = hook("deploy", &opts)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"tag": "v1.0.0", "approved": true}, opts.Variables)
+	assert.Equal(t, map[string]string{"source": "hook"}, opts.Labels)
+}
+
+func TestNewScheduleHook_Reject(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available on Windows")
+	}
+
+	hook, err := NewScheduleHook(`sh -c 'grep -q "\"user\":\"mallory\"" && echo "user is not allowed" >&2 && exit 1; exit 0'`, time.Second)
+	require.NoError(t, err)
+
+	err = hook("deploy", &prunner.ScheduleOpts{User: "alice"})
+	assert.NoError(t, err)
+
+	err = hook("deploy", &prunner.ScheduleOpts{User: "mallory"})
+	assert.EqualError(t, err, "user is not allowed")
+}
+
+func TestNewScheduleHook_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep is not an executable on Windows")
+	}
+
+	hook, err := NewScheduleHook("sleep 5", 100*time.Millisecond)
+	require.NoError(t, err)
+
+	err = hook("deploy", &prunner.ScheduleOpts{})
+	assert.EqualError(t, err, "hook sleep timed out after 100ms")
+}
+
+func TestNewCompleteHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available on Windows")
+	}
+
+	outputFile := filepath.Join(t.TempDir(), "job.json")
+	hook, err := NewCompleteHook(`sh -c 'cat > "$0"' `+outputFile, time.Second)
+	require.NoError(t, err)
+
+	jobID := uuid.Must(uuid.NewV4())
+	hook(&prunner.PipelineJob{
+		ID:        jobID,
+		Pipeline:  "deploy",
+		Completed: true,
+		User:      "alice",
+	})
+
+	data, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	var job Job
+	require.NoError(t, json.Unmarshal(data, &job))
+	assert.Equal(t, jobID.String(), job.ID)
+	assert.Equal(t, "deploy", job.Pipeline)
+	assert.True(t, job.Completed)
+	assert.Equal(t, "alice", job.User)
+	assert.Equal(t, "normal", job.Priority)
+}
+
+func TestNewCompleteHook_WithAllowedFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available on Windows")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"check": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"lint": {
+						Script:       []string{"exit 1"},
+						AllowFailure: true,
+					},
+					"test": {
+						Script: []string{"go test"},
+					},
+				},
+				SourcePath: "fixtures",
+			},
+		},
+	}
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			if t.Name == "lint" {
+				t.Errored = true
+				t.Error = errors.New("exit 1")
+				return t.Error
+			}
+			return nil
+		},
+	}, nil, test.NewMockOutputStore())
+	require.NoError(t, err)
+
+	outputFile := filepath.Join(t.TempDir(), "job.json")
+	hook, err := NewCompleteHook(`sh -c 'cat > "$0"' `+outputFile, time.Second)
+	require.NoError(t, err)
+
+	scheduledJob, err := pRunner.ScheduleAsync("check", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(scheduledJob.ID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 50*time.Millisecond, "job is completed")
+	_ = pRunner.ReadJob(scheduledJob.ID, func(j *prunner.PipelineJob) {
+		hook(j)
+	})
+
+	data, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	var job Job
+	require.NoError(t, json.Unmarshal(data, &job))
+	assert.False(t, job.Errored, "job is not errored by a task with allow_failure")
+	require.Len(t, job.Tasks, 2)
+	for _, jobTask := range job.Tasks {
+		assert.Equal(t, jobTask.Name == "lint", jobTask.Errored, "errored of %s", jobTask.Name)
+		assert.Equal(t, jobTask.Name == "lint", jobTask.AllowedFailure, "allowed failure of %s", jobTask.Name)
+	}
+}
+
+func TestNewHook_InvalidCommand(t *testing.T) {
+	_, err := NewScheduleHook("", time.Second)
+	assert.EqualError(t, err, "hook command must not be empty")
+
+	_, err = NewCompleteHook("notify 'unclosed", time.Second)
+	assert.Error(t, err)
+}
+
+func TestNewCompleteHook_DeadLetters(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available on Windows")
+	}
+
+	dir := t.TempDir()
+	markerFile := filepath.Join(dir, "receiver-up")
+	store := NewDeadLetterStore(filepath.Join(dir, "dead-letters.json"))
+
+	// The hook fails until the marker file exists, e.g. while the receiver of notifications is down
+	hook, err := NewCompleteHook(`sh -c 'cat > /dev/null; test -f "$0" || { echo "receiver down" >&2; exit 1; }' `+markerFile, time.Second, WithRetries(2, 10*time.Millisecond), WithDeadLetters(store))
+	require.NoError(t, err)
+
+	jobID := uuid.Must(uuid.NewV4())
+	hook(&prunner.PipelineJob{
+		ID:       jobID,
+		Pipeline: "deploy",
+		Canceled: true,
+	})
+
+	letters, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	letter := letters[0]
+	assert.NotEmpty(t, letter.ID)
+	assert.Equal(t, jobID.String(), letter.JobID)
+	assert.Equal(t, "deploy", letter.Pipeline)
+	assert.Equal(t, 3, letter.Attempts)
+	assert.Equal(t, "receiver down", letter.LastError)
+	var job Job
+	require.NoError(t, json.Unmarshal(letter.Payload, &job))
+	assert.True(t, job.Canceled)
+
+	err = store.Redeliver(letter.ID, time.Second)
+	assert.EqualError(t, err, "receiver down")
+	letters, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, 4, letters[0].Attempts)
+
+	require.NoError(t, os.WriteFile(markerFile, nil, 0644))
+	err = store.Redeliver(letter.ID, time.Second)
+	require.NoError(t, err)
+	letters, err = store.List()
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+
+	err = store.Redeliver(letter.ID, time.Second)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+}
@@ -0,0 +1,137 @@
+package hoststat
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// DiskUsage is the usage of the file system (volume) containing a path in bytes
+type DiskUsage struct {
+	// Total is the size of the file system
+	Total uint64
+	// Free is the number of free bytes (including bytes reserved for root)
+	Free uint64
+	// Available is the number of free bytes available to unprivileged users
+	Available uint64
+}
+
+// UsedPercent returns the percentage of the file system that is used
+func (d DiskUsage) UsedPercent() float64 {
+	if d.Total == 0 {
+		return 0
+	}
+	return float64(d.Total-d.Free) / float64(d.Total) * 100
+}
+
+// DirSize returns the accumulated size of all regular files inside a directory
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// DiskSpaceGate refuses the start of jobs while the available disk space of a path is below a minimum
+//
+// The disk usage is read at most once per sample interval, so CanStart is cheap to call.
+type DiskSpaceGate struct {
+	path           string
+	minAvailable   uint64
+	sampleInterval time.Duration
+
+	mx        sync.Mutex
+	lastCheck time.Time
+	reason    string
+
+	readDiskUsage func(path string) (DiskUsage, error)
+}
+
+// NewDiskSpaceGate creates a gate that checks the available disk space of path (in bytes) at most once per sample interval
+func NewDiskSpaceGate(path string, minAvailable uint64, sampleInterval time.Duration) *DiskSpaceGate {
+	return &DiskSpaceGate{
+		path:           path,
+		minAvailable:   minAvailable,
+		sampleInterval: sampleInterval,
+		readDiskUsage:  ReadDiskUsage,
+	}
+}
+
+// CanStart returns false and the reason if a job should not be started because the disk space is too low
+func (g *DiskSpaceGate) CanStart() (bool, string) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.lastCheck.IsZero() || time.Since(g.lastCheck) >= g.sampleInterval {
+		g.check()
+	}
+
+	return g.reason == "", g.reason
+}
+
+func (g *DiskSpaceGate) check() {
+	g.lastCheck = time.Now()
+
+	usage, err := g.readDiskUsage(g.path)
+	if err != nil {
+		// Do not block jobs if the disk usage cannot be determined, but keep the previous result for read errors
+		if g.reason == "" {
+			log.
+				WithField("component", "hoststat").
+				WithField("path", g.path).
+				WithError(err).
+				Warn("Failed to read disk usage, ignoring minimum free disk space")
+		}
+		return
+	}
+
+	var reason string
+	if usage.Available < g.minAvailable {
+		reason = fmt.Sprintf("available disk space %s of %s is below %s", FormatBytes(usage.Available), g.path, FormatBytes(g.minAvailable))
+	}
+
+	if reason != g.reason {
+		if reason != "" {
+			log.
+				WithField("component", "hoststat").
+				WithField("reason", reason).
+				Error("Disk space is low, refusing to start jobs")
+		} else {
+			log.
+				WithField("component", "hoststat").
+				WithField("path", g.path).
+				Info("Disk space is above minimum, starting jobs again")
+		}
+	}
+	g.reason = reason
+}
+
+// FormatBytes formats a number of bytes with binary units (e.g. 1.5 GiB)
+func FormatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package hoststat
+
+// ReadDiskUsage is only supported on Linux, macOS and FreeBSD
+func ReadDiskUsage(path string) (DiskUsage, error) {
+	return DiskUsage{}, ErrNotSupported
+}
@@ -0,0 +1,48 @@
+package hoststat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskSpaceGate_CanStart(t *testing.T) {
+	usage := DiskUsage{Total: 10 << 30, Free: 5 << 30, Available: 4 << 30}
+	var readErr error
+
+	g := NewDiskSpaceGate("/data", 1<<30, 0)
+	g.readDiskUsage = func(path string) (DiskUsage, error) { return usage, readErr }
+
+	canStart, reason := g.CanStart()
+	assert.True(t, canStart)
+	assert.Empty(t, reason)
+
+	usage.Available = 512 << 20
+	canStart, reason = g.CanStart()
+	assert.False(t, canStart)
+	assert.Equal(t, "available disk space 512.0 MiB of /data is below 1.0 GiB", reason)
+
+	readErr = errors.New("some error")
+	canStart, _ = g.CanStart()
+	assert.False(t, canStart, "keeps previous result on read errors")
+
+	readErr = nil
+	usage.Available = 2 << 30
+	canStart, _ = g.CanStart()
+	assert.True(t, canStart)
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "logs", "job"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "store.json"), make([]byte, 100), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "logs", "job", "task.stdout.log"), make([]byte, 23), 0644))
+
+	size, err := DirSize(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(123), size)
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+package hoststat
+
+import (
+	"syscall"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ReadDiskUsage reads the usage of the file system containing path
+func ReadDiskUsage(path string) (DiskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return DiskUsage{}, errors.Wrapf(err, "reading file system stats of %s", path)
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return DiskUsage{
+		Total:     uint64(stat.Blocks) * blockSize,
+		Free:      uint64(stat.Bfree) * blockSize,
+		Available: uint64(stat.Bavail) * blockSize,
+	}, nil
+}
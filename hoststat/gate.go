@@ -0,0 +1,152 @@
+package hoststat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+)
+
+// Limits are thresholds for the load of the host, a zero value disables the limit
+type Limits struct {
+	// MaxLoadAverage is the maximum 1-minute load average
+	MaxLoadAverage float64
+	// MaxMemoryUsage is the maximum percentage of memory that is not available
+	MaxMemoryUsage float64
+	// MaxMemoryPressure is the maximum percentage of time some processes were stalled on memory (Linux PSI, avg10)
+	MaxMemoryPressure float64
+	// MaxCPUUsage is the maximum CPU usage in percent since the last check
+	MaxCPUUsage float64
+}
+
+// IsEnabled returns true if at least one limit is set
+func (l Limits) IsEnabled() bool {
+	return l.MaxLoadAverage > 0 || l.MaxMemoryUsage > 0 || l.MaxMemoryPressure > 0 || l.MaxCPUUsage > 0
+}
+
+// LoadGate defers the start of jobs while the load of the host exceeds the configured limits
+//
+// The statistics are read at most once per sample interval, so CanStart is cheap to call.
+type LoadGate struct {
+	limits         Limits
+	sampleInterval time.Duration
+
+	mx           sync.Mutex
+	lastCheck    time.Time
+	lastCPUTimes CPUTimes
+	reason       string
+	// unsupported keeps statistics that could not be read, they are ignored after a warning
+	unsupported map[string]struct{}
+
+	readLoadAverage    func() (float64, error)
+	readMemoryUsage    func() (float64, error)
+	readMemoryPressure func() (float64, error)
+	readCPUTimes       func() (CPUTimes, error)
+}
+
+// NewLoadGate creates a gate that checks the host load against the limits at most once per sample interval
+func NewLoadGate(limits Limits, sampleInterval time.Duration) *LoadGate {
+	return &LoadGate{
+		limits:             limits,
+		sampleInterval:     sampleInterval,
+		unsupported:        make(map[string]struct{}),
+		readLoadAverage:    ReadLoadAverage,
+		readMemoryUsage:    ReadMemoryUsage,
+		readMemoryPressure: ReadMemoryPressure,
+		readCPUTimes:       ReadCPUTimes,
+	}
+}
+
+// CanStart returns false and the reason if a job should not be started because the host is overloaded
+func (g *LoadGate) CanStart() (bool, string) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.lastCheck.IsZero() || time.Since(g.lastCheck) >= g.sampleInterval {
+		g.check()
+	}
+
+	return g.reason == "", g.reason
+}
+
+func (g *LoadGate) check() {
+	g.lastCheck = time.Now()
+
+	var reason string
+
+	if g.limits.MaxCPUUsage > 0 {
+		cpuTimes, err := g.readCPUTimes()
+		if g.handleReadError("cpu_usage", err) {
+			// The first sample has no previous CPU times to compare against
+			if g.lastCPUTimes.Total > 0 {
+				if usage := cpuTimes.UsageSince(g.lastCPUTimes); usage > g.limits.MaxCPUUsage {
+					reason = fmt.Sprintf("CPU usage %.1f%% exceeds %.1f%%", usage, g.limits.MaxCPUUsage)
+				}
+			}
+			g.lastCPUTimes = cpuTimes
+		}
+	}
+
+	if reason == "" && g.limits.MaxLoadAverage > 0 {
+		loadAverage, err := g.readLoadAverage()
+		if g.handleReadError("load_average", err) && loadAverage > g.limits.MaxLoadAverage {
+			reason = fmt.Sprintf("load average %.2f exceeds %.2f", loadAverage, g.limits.MaxLoadAverage)
+		}
+	}
+
+	if reason == "" && g.limits.MaxMemoryUsage > 0 {
+		memoryUsage, err := g.readMemoryUsage()
+		if g.handleReadError("memory_usage", err) && memoryUsage > g.limits.MaxMemoryUsage {
+			reason = fmt.Sprintf("memory usage %.1f%% exceeds %.1f%%", memoryUsage, g.limits.MaxMemoryUsage)
+		}
+	}
+
+	if reason == "" && g.limits.MaxMemoryPressure > 0 {
+		memoryPressure, err := g.readMemoryPressure()
+		if g.handleReadError("memory_pressure", err) && memoryPressure > g.limits.MaxMemoryPressure {
+			reason = fmt.Sprintf("memory pressure %.1f%% exceeds %.1f%%", memoryPressure, g.limits.MaxMemoryPressure)
+		}
+	}
+
+	if reason != g.reason {
+		if reason != "" {
+			log.
+				WithField("component", "hoststat").
+				WithField("reason", reason).
+				Warn("Host is overloaded, deferring start of jobs")
+		} else {
+			log.
+				WithField("component", "hoststat").
+				Info("Host load is below limits, starting jobs again")
+		}
+	}
+	g.reason = reason
+}
+
+// handleReadError returns true if the statistic was read successfully.
+// Errors are logged, unsupported statistics are only reported once and ignored afterwards.
+func (g *LoadGate) handleReadError(stat string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrNotSupported) {
+		if _, warned := g.unsupported[stat]; !warned {
+			g.unsupported[stat] = struct{}{}
+			log.
+				WithField("component", "hoststat").
+				WithField("stat", stat).
+				Warn("Host statistic is not supported, ignoring limit")
+		}
+		return false
+	}
+
+	log.
+		WithField("component", "hoststat").
+		WithField("stat", stat).
+		WithError(err).
+		Error("Failed to read host statistic")
+	return false
+}
@@ -0,0 +1,66 @@
+package hoststat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadGate_CanStart(t *testing.T) {
+	loadAverage := 1.0
+	memoryUsage := 50.0
+
+	g := NewLoadGate(Limits{MaxLoadAverage: 4, MaxMemoryUsage: 90, MaxMemoryPressure: 10}, 0)
+	g.readLoadAverage = func() (float64, error) { return loadAverage, nil }
+	g.readMemoryUsage = func() (float64, error) { return memoryUsage, nil }
+	g.readMemoryPressure = func() (float64, error) { return 0, ErrNotSupported }
+
+	canStart, reason := g.CanStart()
+	assert.True(t, canStart)
+	assert.Empty(t, reason)
+
+	loadAverage = 5.5
+	canStart, reason = g.CanStart()
+	assert.False(t, canStart)
+	assert.Equal(t, "load average 5.50 exceeds 4.00", reason)
+
+	loadAverage = 1.0
+	memoryUsage = 95
+	canStart, reason = g.CanStart()
+	assert.False(t, canStart)
+	assert.Equal(t, "memory usage 95.0% exceeds 90.0%", reason)
+
+	memoryUsage = 50
+	canStart, _ = g.CanStart()
+	assert.True(t, canStart)
+}
+
+func TestLoadGate_CanStart_WithCPUUsage(t *testing.T) {
+	cpuTimes := CPUTimes{Idle: 100, Total: 200}
+
+	g := NewLoadGate(Limits{MaxCPUUsage: 80}, 0)
+	g.readCPUTimes = func() (CPUTimes, error) { return cpuTimes, nil }
+
+	canStart, _ := g.CanStart()
+	assert.True(t, canStart, "first sample has no CPU usage")
+
+	cpuTimes = CPUTimes{Idle: 105, Total: 300}
+	canStart, reason := g.CanStart()
+	assert.False(t, canStart)
+	assert.Equal(t, "CPU usage 95.0% exceeds 80.0%", reason)
+}
+
+func TestLoadGate_CanStart_CachesResultForSampleInterval(t *testing.T) {
+	loadAverage := 1.0
+
+	g := NewLoadGate(Limits{MaxLoadAverage: 4}, time.Hour)
+	g.readLoadAverage = func() (float64, error) { return loadAverage, nil }
+
+	canStart, _ := g.CanStart()
+	assert.True(t, canStart)
+
+	loadAverage = 5
+	canStart, _ = g.CanStart()
+	assert.True(t, canStart, "result should be cached")
+}
@@ -0,0 +1,25 @@
+// Package hoststat reads statistics of the host and provides gates to defer or refuse starting jobs if the host is overloaded or low on disk space
+package hoststat
+
+import (
+	"github.com/friendsofgo/errors"
+)
+
+// ErrNotSupported is returned if a statistic cannot be read on the current OS
+var ErrNotSupported = errors.New("not supported on this OS")
+
+// CPUTimes are accumulated CPU times of all CPUs in clock ticks
+type CPUTimes struct {
+	Idle  uint64
+	Total uint64
+}
+
+// UsageSince calculates the CPU usage in percent between an earlier snapshot and this snapshot
+func (c CPUTimes) UsageSince(earlier CPUTimes) float64 {
+	if c.Total <= earlier.Total {
+		return 0
+	}
+	total := c.Total - earlier.Total
+	idle := c.Idle - earlier.Idle
+	return float64(total-idle) / float64(total) * 100
+}
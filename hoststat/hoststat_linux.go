@@ -0,0 +1,121 @@
+//go:build linux
+
+package hoststat
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ReadLoadAverage reads the 1-minute load average from /proc/loadavg
+func ReadLoadAverage() (float64, error) {
+	content, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, errors.Wrap(err, "reading load average")
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, errors.New("unexpected format of /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// ReadMemoryUsage reads the percentage of memory that is not available from /proc/meminfo
+func ReadMemoryUsage() (float64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, errors.Wrap(err, "reading memory info")
+	}
+	defer f.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseUint(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, errors.Wrap(err, "reading memory info")
+	}
+	if total == 0 {
+		return 0, errors.New("missing MemTotal in /proc/meminfo")
+	}
+
+	return float64(total-available) / float64(total) * 100, nil
+}
+
+// ReadMemoryPressure reads the "some avg10" memory pressure stall information from /proc/pressure/memory
+func ReadMemoryPressure() (float64, error) {
+	content, err := os.ReadFile("/proc/pressure/memory")
+	if errors.Is(err, os.ErrNotExist) {
+		// Kernel is built without PSI support
+		return 0, ErrNotSupported
+	} else if err != nil {
+		return 0, errors.Wrap(err, "reading memory pressure")
+	}
+
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value := strings.TrimPrefix(field, "avg10="); value != field {
+				return strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+
+	return 0, errors.New("unexpected format of /proc/pressure/memory")
+}
+
+// ReadCPUTimes reads the accumulated CPU times of all CPUs from /proc/stat
+func ReadCPUTimes() (CPUTimes, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return CPUTimes{}, errors.Wrap(err, "reading CPU stats")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return CPUTimes{}, errors.New("unexpected format of /proc/stat")
+	}
+	// Format: cpu user nice system idle iowait irq softirq steal guest guest_nice
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return CPUTimes{}, errors.New("unexpected format of /proc/stat")
+	}
+
+	var times CPUTimes
+	// Guest times are already included in user and nice
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return CPUTimes{}, errors.Wrap(err, "parsing CPU stats")
+		}
+		times.Total += value
+		// idle and iowait
+		if i == 3 || i == 4 {
+			times.Idle += value
+		}
+	}
+
+	return times, nil
+}
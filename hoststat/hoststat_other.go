@@ -0,0 +1,23 @@
+//go:build !linux
+
+package hoststat
+
+// ReadLoadAverage is only supported on Linux
+func ReadLoadAverage() (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// ReadMemoryUsage is only supported on Linux
+func ReadMemoryUsage() (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// ReadMemoryPressure is only supported on Linux
+func ReadMemoryPressure() (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// ReadCPUTimes is only supported on Linux
+func ReadCPUTimes() (CPUTimes, error) {
+	return CPUTimes{}, ErrNotSupported
+}
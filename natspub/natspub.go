@@ -0,0 +1,377 @@
+// Package natspub publishes the events of the pipeline runner to NATS subjects, so other services can react to
+// pipeline activity. It implements the publishing part of the NATS client protocol (see
+// https://docs.nats.io/reference/reference-protocols/nats-protocol), TLS is not supported.
+package natspub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner"
+)
+
+// DefaultPrefix is the default prefix of subjects
+const DefaultPrefix = "prunner"
+
+const (
+	defaultPort = "4222"
+	// queueSize is the number of messages that are buffered while publishing, further messages are dropped
+	queueSize = 1024
+	// dialTimeout is also used for the handshake after connecting
+	dialTimeout = 5 * time.Second
+	// reconnectInterval is the minimum time between connection attempts, messages are dropped in between
+	reconnectInterval = 5 * time.Second
+)
+
+// Message is published as JSON for an event to the subject <prefix>.<type>
+type Message struct {
+	// Type is one of job.scheduled, job.started, task.started, task.status, task.finished or job.completed
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	JobID    string    `json:"jobId"`
+	Pipeline string    `json:"pipeline"`
+
+	// Queued is set for job.scheduled if the job was added to the wait list
+	Queued bool `json:"queued,omitempty"`
+	// Task is set for task events
+	Task string `json:"task,omitempty"`
+	// Status is set for task.status (the task status) and job.completed (done, error, canceled or expired)
+	Status string `json:"status,omitempty"`
+	// Skipped, Errored and ExitCode are set for task.finished
+	Skipped  bool   `json:"skipped,omitempty"`
+	Errored  bool   `json:"errored,omitempty"`
+	ExitCode *int16 `json:"exitCode,omitempty"`
+	// LastError is set for job.completed if the job had an error
+	LastError string `json:"lastError,omitempty"`
+}
+
+var errNotConnected = errors.New("not connected")
+
+type message struct {
+	subject string
+	data    []byte
+}
+
+// Publisher publishes events asynchronously, HandleEvent can be used as a subscription of the runner
+type Publisher struct {
+	address  string
+	user     string
+	password string
+	token    string
+	prefix   string
+
+	// mx guards sending messages and closing, so events after Close are ignored
+	mx       sync.Mutex
+	closed   bool
+	messages chan message
+	done     chan struct{}
+	dropped  uint64
+
+	// conn is only used by the publish loop, writeMx serializes its writes with the PONGs of the reader
+	conn          net.Conn
+	writeMx       sync.Mutex
+	lastConnectAt time.Time
+}
+
+// New parses the URL (nats://[user:password@]host[:port] or nats://token@host[:port]) and starts the publish loop.
+// The connection is established on the first message and re-established after errors.
+func New(rawURL string, prefix string) (*Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing NATS URL")
+	}
+	if u.Scheme != "nats" {
+		return nil, errors.Errorf("unsupported NATS URL scheme %q, expected nats", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("NATS URL must contain a host")
+	}
+	if prefix == "" {
+		return nil, errors.New("subject prefix must not be empty")
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	p := &Publisher{
+		address:  address,
+		prefix:   prefix,
+		messages: make(chan message, queueSize),
+		done:     make(chan struct{}),
+	}
+	if u.User != nil {
+		if password, hasPassword := u.User.Password(); hasPassword {
+			p.user = u.User.Username()
+			p.password = password
+		} else {
+			p.token = u.User.Username()
+		}
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// HandleEvent converts the event to a message and queues it for publishing without blocking.
+// Messages are dropped if the queue is full (e.g. the NATS server is not reachable).
+func (p *Publisher) HandleEvent(e prunner.Event) {
+	msg, ok := messageFromEvent(e)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.closed {
+		return
+	}
+
+	select {
+	case p.messages <- message{subject: p.prefix + "." + msg.Type, data: data}:
+	default:
+		if atomic.AddUint64(&p.dropped, 1) == 1 {
+			log.
+				WithField("component", "natspub").
+				Warn("Queue of NATS messages is full, dropping messages")
+		}
+	}
+}
+
+// Close publishes the queued messages and closes the connection, later events are ignored.
+// Messages that are not published until the timeout are dropped.
+func (p *Publisher) Close(timeout time.Duration) error {
+	p.mx.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.messages)
+	}
+	p.mx.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout publishing queued NATS messages")
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	defer p.disconnect()
+
+	for msg := range p.messages {
+		err := p.publish(msg)
+		// The connection error was logged already
+		if err != nil && err != errNotConnected {
+			log.
+				WithField("component", "natspub").
+				WithField("subject", msg.subject).
+				WithError(err).
+				Warn("Error publishing NATS message")
+		}
+	}
+}
+
+func (p *Publisher) publish(msg message) error {
+	if p.conn == nil {
+		// Do not slow down publishing by connecting for every message if the server is not reachable
+		if time.Since(p.lastConnectAt) < reconnectInterval {
+			return errNotConnected
+		}
+		p.lastConnectAt = time.Now()
+		err := p.connect()
+		if err != nil {
+			return errors.Wrap(err, "connecting")
+		}
+		// Messages could have been dropped while not connected
+		if dropped := atomic.SwapUint64(&p.dropped, 0); dropped > 0 {
+			log.
+				WithField("component", "natspub").
+				WithField("dropped", dropped).
+				Warn("Dropped NATS messages while the queue was full")
+		}
+	}
+
+	err := p.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", msg.subject, len(msg.data), msg.data))
+	if err != nil {
+		p.disconnect()
+		return err
+	}
+	return nil
+}
+
+// connect dials the server and sends the CONNECT message, a PING is sent to wait for the result of the authentication
+func (p *Publisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	reader := bufio.NewReader(conn)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "reading INFO")
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return errors.Errorf("unexpected message from server: %q", strings.TrimSpace(line))
+	}
+
+	connectOpts, _ := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		Lang     string `json:"lang"`
+		User     string `json:"user,omitempty"`
+		Pass     string `json:"pass,omitempty"`
+		Token    string `json:"auth_token,omitempty"`
+	}{
+		Name:  "prunner",
+		Lang:  "go",
+		User:  p.user,
+		Pass:  p.password,
+		Token: p.token,
+	})
+	_, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connectOpts)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "writing CONNECT")
+	}
+	for {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return errors.Wrap(err, "reading CONNECT result")
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return errors.Errorf("server error: %s", strings.TrimPrefix(line, "-ERR "))
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	p.conn = conn
+	go p.read(conn, reader)
+
+	log.
+		WithField("component", "natspub").
+		WithField("address", p.address).
+		Info("Connected to NATS server")
+
+	return nil
+}
+
+// read answers PINGs of the server, so the connection is kept alive, and logs errors
+func (p *Publisher) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// The connection was closed, the next write fails and reconnects
+			conn.Close()
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			p.writeMx.Lock()
+			_, _ = conn.Write([]byte("PONG\r\n"))
+			p.writeMx.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.
+				WithField("component", "natspub").
+				Warnf("NATS server error: %s", strings.TrimPrefix(line, "-ERR "))
+		}
+	}
+}
+
+func (p *Publisher) write(s string) error {
+	p.writeMx.Lock()
+	defer p.writeMx.Unlock()
+
+	_ = p.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := p.conn.Write([]byte(s))
+	return err
+}
+
+func (p *Publisher) disconnect() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func messageFromEvent(e prunner.Event) (Message, bool) {
+	var msg Message
+	switch e := e.(type) {
+	case prunner.JobScheduled:
+		msg = newMessage("job.scheduled", e.JobEvent)
+		msg.Queued = e.Queued
+	case prunner.JobStarted:
+		msg = newMessage("job.started", e.JobEvent)
+	case prunner.TaskStarted:
+		msg = newMessage("task.started", e.JobEvent)
+		msg.Task = e.Task
+	case prunner.TaskStatusChanged:
+		msg = newMessage("task.status", e.JobEvent)
+		msg.Task = e.Task
+		msg.Status = e.Status
+	case prunner.TaskFinished:
+		msg = newMessage("task.finished", e.JobEvent)
+		msg.Task = e.Task
+		msg.Skipped = e.Skipped
+		msg.Errored = e.Errored
+		exitCode := e.ExitCode
+		msg.ExitCode = &exitCode
+	case prunner.JobCompleted:
+		msg = newMessage("job.completed", e.JobEvent)
+		switch {
+		case e.Job.Expired:
+			msg.Status = "expired"
+		case e.Job.Canceled:
+			msg.Status = "canceled"
+		case e.Job.LastError != nil:
+			msg.Status = "error"
+		default:
+			msg.Status = "done"
+		}
+		if e.Job.LastError != nil {
+			msg.LastError = e.Job.LastError.Error()
+		}
+	default:
+		// Other changes (e.g. processes) are not published
+		return msg, false
+	}
+	return msg, true
+}
+
+func newMessage(typ string, e prunner.JobEvent) Message {
+	return Message{
+		Type:     typ,
+		Time:     time.Now(),
+		JobID:    e.JobID.String(),
+		Pipeline: e.Pipeline,
+	}
+}
@@ -3,6 +3,8 @@ package prunner
 import (
 	"context"
 	"fmt"
+	"os"
+	"regexp"
 	"sort"
 	"sync"
 	"time"
@@ -12,11 +14,12 @@ import (
 	"github.com/apex/log"
 	"github.com/friendsofgo/errors"
 	"github.com/gofrs/uuid"
-	"github.com/taskctl/taskctl/pkg/runner"
 	"github.com/taskctl/taskctl/pkg/scheduler"
 	"github.com/taskctl/taskctl/pkg/task"
 	"github.com/taskctl/taskctl/pkg/variables"
 
+	"github.com/Flowpack/prunner/archive"
+	"github.com/Flowpack/prunner/condition"
 	"github.com/Flowpack/prunner/definition"
 	"github.com/Flowpack/prunner/helper"
 	"github.com/Flowpack/prunner/taskctl"
@@ -28,8 +31,21 @@ import (
 type PipelineRunner struct {
 	defs               *definition.PipelinesDef
 	jobsByID           map[uuid.UUID]*PipelineJob
-	jobsByPipeline     map[string][]*PipelineJob
+	jobsByPipeline     map[string]jobList
+	jobsByCreation     jobList
 	waitListByPipeline map[string][]*PipelineJob
+	// windowTimerByPipeline starts queued jobs of pipelines when their schedule window opens
+	windowTimerByPipeline map[string]*time.Timer
+	// quotaTimerByPipeline starts queued jobs of pipelines when their exceeded schedule quotas allow it again
+	quotaTimerByPipeline map[string]*time.Timer
+	// drainedPipelines do not start jobs from their wait list (see DrainPipeline)
+	drainedPipelines map[string]bool
+	// quotaExceeded counts jobs that were rejected or queued because of a schedule quota
+	quotaExceeded map[quotaKey]int
+	// taskUsage accumulates the resource usage of finished tasks since the start (see TaskResourceUsage)
+	taskUsage map[taskUsageKey]TaskResourceUsage
+	// timezone is the default timezone for schedule windows
+	timezone *time.Location
 
 	// store is the implementation for persisting data
 	store store.DataStore
@@ -37,59 +53,233 @@ type PipelineRunner struct {
 	// outputStore persists the log output. We need the reference here to trigger cleanup logic
 	outputStore taskctl.OutputStore
 
-	// persistRequests is for triggering saving-the-store, which is then handled asynchronously, at most every 3 seconds (see NewPipelineRunner)
+	// persistRequests is for triggering saving-the-store, which is then handled asynchronously, at most every persistInterval (see NewPipelineRunner)
 	// externally, call requestPersist()
 	persistRequests chan struct{}
+	// saveMx serializes saves, so a saved state is never overwritten by an older one
+	saveMx sync.Mutex
+	// changedJobs and removedJobs are saved by the next incremental save (see SaveToStore)
+	changedJobs map[uuid.UUID]*PipelineJob
+	removedJobs []uuid.UUID
+	// stateVersion is incremented on every change or removal of a job, stateEpoch distinguishes versions of different
+	// runner instances (see StateVersion)
+	stateVersion uint64
+	stateEpoch   int64
+	// journaledChanges is the number of incrementally saved changes since the last full save (guarded by saveMx)
+	journaledChanges int
+	// fullSaveRequired is set if a save failed (guarded by saveMx)
+	fullSaveRequired bool
 
 	// Mutex for reading or writing jobs and job state
-	mx               sync.RWMutex
-	createTaskRunner func(j *PipelineJob) taskctl.Runner
+	mx sync.RWMutex
 
-	// Wait group for waiting for asynchronous operations like job.Cancel
+	// taskRunner and sched execute the tasks of all jobs, a job is canceled with its own context (see startJob)
+	taskRunner taskctl.Runner
+	sched      *taskctl.Scheduler
+	// jobContextFuncs add values to the context of a job before it is started (see WithJobContext)
+	jobContextFuncs []func(ctx context.Context, j *PipelineJob) context.Context
+
+	// Wait group for waiting for asynchronous operations like running jobs
 	wg sync.WaitGroup
 	// Flag if the runner is shutting down
 	isShuttingDown bool
 
 	// Poll interval for completed jobs for graceful shutdown
 	ShutdownPollInterval time.Duration
+	// persistInterval is the minimum interval between saves of the job state that are triggered by changes
+	persistInterval time.Duration
+	// flushOnCompletion saves the job state immediately when a job is completed instead of waiting for the next save
+	flushOnCompletion bool
+	// maxCachedJobs is the number of jobs kept in memory if the store can load jobs on demand (0 keeps all jobs).
+	// Older finished jobs are evicted after they were saved (see evictJobs).
+	maxCachedJobs int
+
+	// now returns the current time for job timestamps and retention
+	now func() time.Time
+	// newID generates ids for new jobs
+	newID func() (uuid.UUID, error)
+	// logger is used for all log output of the runner (see WithLogger)
+	logger log.Interface
+
+	// evictedJobs contains stubs of finished jobs that were evicted from memory and are loaded from the store on demand
+	evictedJobs           map[uuid.UUID]*PipelineJob
+	evictedJobsByPipeline map[string]jobList
+
+	// logSizes caches the size of the stored output of finished jobs for the log quota (see enforceLogQuotas)
+	logSizes map[uuid.UUID]int64
+
+	// startGates can defer the start of jobs (e.g. if the host is overloaded)
+	startGates []StartGate
+	// scheduleGates can refuse scheduling new jobs (e.g. if the disk is almost full)
+	scheduleGates []StartGate
+	// slotClaimer shares the concurrency of pipelines with other instances (see UseSlotClaimer)
+	slotClaimer SlotClaimer
+
+	// preScheduleHooks and postCompleteHooks are callbacks for custom policies (see UsePreScheduleHook)
+	preScheduleHooks  []PreScheduleHook
+	postCompleteHooks []PostCompleteHook
+
+	// alertHooks are called for raised and resolved alerts of pipelines (see UseAlerts)
+	alertHooks    []AlertHook
+	alertsWatched bool
+	// alertsSince is the reference for the no_success alert of pipelines without a successful job
+	alertsSince time.Time
+	// activeAlerts are the alerts of the last check, queueFullSince is the time queues were found full first
+	activeAlerts   map[alertKey]Alert
+	queueFullSince map[string]time.Time
+
+	// subscriptions receive the events of state changes (see Subscribe)
+	subscriptions []*subscription
+
+	// jobWaiters are closed when the job is finished (see WaitForJob)
+	jobWaiters map[uuid.UUID][]chan struct{}
+
+	// jobIndex contains all jobs by labels and variables (see FindJobs)
+	jobIndex *jobIndex
+
+	// archive stores old finished jobs in cold storage (see UseArchive)
+	archive      *archive.Archive
+	archiveAfter time.Duration
+
+	// Flag if jobs are not started because the state is handed off to a new instance (see PrepareHandOff)
+	isHandingOff bool
+	// Flag if the state was handed off, no more saves are allowed (see HandOff)
+	isHandedOff bool
+}
+
+// StartGate decides if jobs can be started now, jobs are kept on the wait list while a gate is closed
+type StartGate interface {
+	// CanStart returns false and a reason if no job should be started
+	CanStart() (bool, string)
+}
+
+// Opts configures a PipelineRunner (see NewPipelineRunner)
+type Opts func(*PipelineRunner)
+
+// WithClock sets the function for the current time that is used for job timestamps and retention periods
+func WithClock(now func() time.Time) Opts {
+	return func(r *PipelineRunner) {
+		r.now = now
+	}
+}
+
+// WithUUIDGenerator sets the function for generating the ids of new jobs
+func WithUUIDGenerator(newID func() (uuid.UUID, error)) Opts {
+	return func(r *PipelineRunner) {
+		r.newID = newID
+	}
+}
+
+// WithPersistInterval sets the minimum interval between saves of the job state that are triggered by changes
+func WithPersistInterval(interval time.Duration) Opts {
+	return func(r *PipelineRunner) {
+		r.persistInterval = interval
+	}
+}
+
+// WithFlushOnCompletion saves the job state immediately when a job is completed instead of waiting for the next save
+func WithFlushOnCompletion(flush bool) Opts {
+	return func(r *PipelineRunner) {
+		r.flushOnCompletion = flush
+	}
+}
+
+// WithMaxCachedJobs sets the number of jobs kept in memory if the store can load jobs on demand (0 keeps all jobs)
+func WithMaxCachedJobs(maxCachedJobs int) Opts {
+	return func(r *PipelineRunner) {
+		r.maxCachedJobs = maxCachedJobs
+	}
+}
+
+// WithLogger sets the logger for the runner (defaults to the global apex/log logger)
+func WithLogger(logger log.Interface) Opts {
+	return func(r *PipelineRunner) {
+		r.logger = logger
+	}
+}
+
+// WithJobContext adds a function that can add values to the context of a job before its tasks are executed, e.g. for
+// a task runner that needs more information about the job
+func WithJobContext(f func(ctx context.Context, j *PipelineJob) context.Context) Opts {
+	return func(r *PipelineRunner) {
+		r.jobContextFuncs = append(r.jobContextFuncs, f)
+	}
 }
 
-// NewPipelineRunner creates the central data structure which controls the full runner state; so this knows what is currently running
-func NewPipelineRunner(ctx context.Context, defs *definition.PipelinesDef, createTaskRunner func(j *PipelineJob) taskctl.Runner, store store.DataStore, outputStore taskctl.OutputStore) (*PipelineRunner, error) {
+// NewPipelineRunner creates the central data structure which controls the full runner state; so this knows what is currently running.
+// The task runner and a scheduler are shared by all jobs (see NewTaskRunner).
+func NewPipelineRunner(ctx context.Context, defs *definition.PipelinesDef, taskRunner taskctl.Runner, store store.DataStore, outputStore taskctl.OutputStore, opts ...Opts) (*PipelineRunner, error) {
 	pRunner := &PipelineRunner{
 		defs: defs,
 		// jobsByID contains ALL jobs, no matter whether they are on the waitlist or are scheduled or cancelled.
 		jobsByID: make(map[uuid.UUID]*PipelineJob),
 		// jobsByPipeline contains ALL jobs, no matter whether they are on the waitlist or are scheduled or cancelled.
-		jobsByPipeline: make(map[string][]*PipelineJob),
+		// Like jobsByCreation the jobs are ordered by creation time.
+		jobsByPipeline: make(map[string]jobList),
+		// evictedJobsByPipeline contains the stubs of evicted jobs ordered by creation time
+		evictedJobsByPipeline: make(map[string]jobList),
 		// waitListByPipeline additionally contains all the jobs currently waiting, but not yet started (because concurrency limits have been reached)
-		waitListByPipeline: make(map[string][]*PipelineJob),
-		store:              store,
-		outputStore:        outputStore,
+		waitListByPipeline:    make(map[string][]*PipelineJob),
+		windowTimerByPipeline: make(map[string]*time.Timer),
+		quotaTimerByPipeline:  make(map[string]*time.Timer),
+		drainedPipelines:      make(map[string]bool),
+		quotaExceeded:         make(map[quotaKey]int),
+		taskUsage:             make(map[taskUsageKey]TaskResourceUsage),
+		jobWaiters:            make(map[uuid.UUID][]chan struct{}),
+		jobIndex:              newJobIndex(),
+		changedJobs:           make(map[uuid.UUID]*PipelineJob),
+		evictedJobs:           make(map[uuid.UUID]*PipelineJob),
+		logSizes:              make(map[uuid.UUID]int64),
+		store:                 store,
+		outputStore:           outputStore,
 		// Use channel buffered with one extra slot, so we can keep save requests while a save is running without blocking
 		persistRequests:      make(chan struct{}, 1),
-		createTaskRunner:     createTaskRunner,
+		stateEpoch:           time.Now().UnixNano(),
+		taskRunner:           taskRunner,
+		sched:                taskctl.NewScheduler(taskRunner),
 		ShutdownPollInterval: 3 * time.Second,
+		persistInterval:      3 * time.Second,
+		now:                  time.Now,
+		timezone:             time.Local,
+		newID:                uuid.NewV4,
+		logger:               log.Log,
 	}
+	for _, opt := range opts {
+		opt(pRunner)
+	}
+	pRunner.subscribeInternalConsumers()
+
+	// Listen on task and stage changes for syncing the job / task state
+	taskRunner.SetOnTaskChange(pRunner.HandleTaskChange)
+	taskRunner.SetOnProcessChange(pRunner.HandleProcessChange)
+	taskRunner.SetOnCommandChange(pRunner.HandleCommandChange)
+	pRunner.sched.OnStageChange(pRunner.HandleStageChange)
 
 	if store != nil {
 		err := pRunner.initialLoadFromStore()
 		if err != nil {
 			return nil, errors.Wrap(err, "loading from store")
 		}
+		// Loaded jobs are saved already, so they can be evicted right away
+		if pRunner.isEvicting() {
+			pRunner.evictJobs()
+		}
 
 		go func() {
 			for {
 				select {
 				case <-ctx.Done():
-					log.
+					pRunner.logger.
 						WithField("component", "runner").
 						Debug("Stopping persist loop")
 					return
 				case <-pRunner.persistRequests:
 					pRunner.SaveToStore()
-					// Perform save at most every 3 seconds
-					time.Sleep(3 * time.Second)
+					// Perform save at most every persist interval
+					select {
+					case <-time.After(pRunner.persistInterval):
+					case <-ctx.Done():
+					}
 				}
 			}
 		}()
@@ -108,9 +298,58 @@ type PipelineJob struct {
 	Env        map[string]string
 	Variables  map[string]interface{}
 	StartDelay time.Duration
+	// PriorityClass of the pipeline definition when the job was scheduled
+	PriorityClass definition.PriorityClass
+	// Interpreter of the pipeline definition when the job was scheduled
+	Interpreter definition.Interpreter
+	// Weight of the pipeline definition when the job was scheduled
+	Weight int
+	// Timeout of the pipeline definition when the job was scheduled (0 for no timeout)
+	Timeout time.Duration
+	// Git checkout of the pipeline definition when the job was scheduled (nil for no checkout)
+	Git *definition.GitCheckoutDef
+	// GitCommit is the SHA of the commit that was checked out for the job
+	GitCommit string
+	// LogsPruned is set if the logs of the job were removed because the log quota of the pipeline was exceeded
+	LogsPruned bool
+	// Rehydrated is the time the job was restored from the archive (nil if it was never archived)
+	Rehydrated *time.Time
+	// SLABreached is set if the job ran longer than the SLA of the pipeline (even if it succeeded)
+	SLABreached bool
+	// ExpectedDuration is the median duration of previous successful jobs when the job started (0 if unknown)
+	ExpectedDuration time.Duration
+	// SlowAfter is the duration after which the job is unusually slow compared to previous jobs (0 if unknown)
+	SlowAfter time.Duration
+	// KeepTmpOnFailure of the pipeline definition when the job was scheduled
+	KeepTmpOnFailure bool
+	// Contexts of the pipeline definition when the job was scheduled
+	Contexts map[string]definition.ContextDef
+	// Services of the pipeline definition when the job was scheduled and their state (sorted by name)
+	Services jobServices
+	// DefinitionHash is the hash of the pipeline definition the job was scheduled with
+	DefinitionHash string
+	// Priority of the job on the wait list
+	Priority JobPriority
+	// Labels are arbitrary metadata of the job set when scheduling
+	Labels map[string]string
+	// CorrelationID is the ID of the request that scheduled the job (e.g. from X-Request-ID), it is added to log output
+	// of the job and exported to tasks
+	CorrelationID string
+	// Trigger is how the job was scheduled (e.g. TriggerAPI), empty for jobs scheduled before it was recorded
+	Trigger string
+	// RequeuedFrom is the id of the canceled or expired job this job was requeued from
+	RequeuedFrom *uuid.UUID
+	// ClonedFrom is the id of the job whose inputs this job was scheduled with
+	ClonedFrom *uuid.UUID
+	// Annotations are notes attached to the job after it was scheduled (see AnnotateJob)
+	Annotations []JobAnnotation
 
 	Completed bool
 	Canceled  bool
+	// Incomplete is set if the job was running when prunner stopped unexpectedly (e.g. a crash), so it never finished
+	Incomplete bool
+	// Expired is set if the job was canceled because it was on the wait list longer than the queue timeout
+	Expired bool
 	// Created is the schedule / queue time of the job. Always non-null
 	Created time.Time
 	// Start is the actual start time of the job. Could be nil if not yet started.
@@ -121,36 +360,188 @@ type PipelineJob struct {
 	// Tasks is an in-memory representation with state of tasks, sorted by dependencies
 	Tasks     jobTasks
 	LastError error
+	// Processes are the currently running processes started by tasks of the job
+	Processes []taskctl.Process
 
-	sched      *taskctl.Scheduler
-	taskRunner runner.Runner
+	// cancelFunc cancels the context of a running job, it is nil if the job is not running
+	cancelFunc context.CancelFunc
 	startTimer *time.Timer
+	// queueTimer expires the job if it is still on the wait list after the queue timeout
+	queueTimer *time.Timer
+	// slaTimer flags the running job if it exceeds the SLA of the pipeline
+	slaTimer *time.Timer
+	// replaceTimer cancels the running job after the grace period if it is replaced by a new job
+	replaceTimer *time.Timer
 }
 
-func (j *PipelineJob) isRunning() bool {
-	return j.Start != nil && !j.Completed && !j.Canceled
+// logFields returns the fields that identify the job in log output
+func (j *PipelineJob) logFields() log.Fields {
+	fields := log.Fields{"jobID": j.ID}
+	if j.CorrelationID != "" {
+		fields["correlationID"] = j.CorrelationID
+	}
+	return fields
+}
+
+// ProcessPriority returns the CPU and IO priority for processes started by tasks of the job
+func (j *PipelineJob) ProcessPriority() taskctl.ProcessPriority {
+	switch j.PriorityClass {
+	case definition.PriorityClassLow:
+		return taskctl.ProcessPriority{Niceness: 10, IOClass: taskctl.IOClassBestEffort, IOLevel: 7}
+	case definition.PriorityClassIdle:
+		return taskctl.ProcessPriority{Niceness: 19, IOClass: taskctl.IOClassIdle}
+	}
+	return taskctl.ProcessPriority{}
 }
 
-func (r *PipelineRunner) initScheduler(j *PipelineJob) {
-	// For correct cancellation of tasks a single task runner and scheduler per job is used
+// TaskInterpreters returns the external interpreters for tasks of the job by task name
+//
+// Tasks that are executed with the built-in shell interpreter are not included.
+func (j *PipelineJob) TaskInterpreters() map[string]taskctl.Interpreter {
+	interpreters := make(map[string]taskctl.Interpreter)
+	for _, t := range j.Tasks {
+		interpreter := t.Interpreter
+		if interpreter == definition.InterpreterDefault {
+			interpreter = j.Interpreter
+		}
+
+		switch interpreter {
+		case definition.InterpreterPowerShell:
+			interpreters[t.Name] = taskctl.InterpreterPowerShell
+		case definition.InterpreterPwsh:
+			interpreters[t.Name] = taskctl.InterpreterPwsh
+		case definition.InterpreterCmd:
+			interpreters[t.Name] = taskctl.InterpreterCmd
+		}
+	}
+	return interpreters
+}
 
-	taskRunner := r.createTaskRunner(j)
+// TaskOptions returns the options for executing the tasks of the job
+func (j *PipelineJob) TaskOptions() taskctl.JobOptions {
+	return taskctl.JobOptions{
+		Env:             j.taskEnv(),
+		ProcessPriority: j.ProcessPriority(),
+		Interpreters:    j.TaskInterpreters(),
+		Retries:         j.TaskRetries(),
+		NoOutput:        j.TaskNoOutputWatches(),
+		Conditions:      j.TaskConditions(),
+		ChangedPaths:    j.TaskChangedPaths(),
+		Contexts:        j.TaskContexts(),
+		EnvTemplateData: j.envTemplateData(),
+		WorkerWeight:    j.Weight,
+	}
+}
 
-	sched := taskctl.NewScheduler(taskRunner)
+// TaskRetries returns the number of retries after a failure for tasks of the job by task name
+func (j *PipelineJob) TaskRetries() map[string]int {
+	retries := make(map[string]int)
+	for _, t := range j.Tasks {
+		if retryCount := t.RetryCount(); retryCount > 0 {
+			retries[t.Name] = retryCount
+		}
+	}
+	return retries
+}
 
-	// Listen on task and stage changes for syncing the job / task state
-	taskRunner.SetOnTaskChange(r.HandleTaskChange)
-	sched.OnStageChange(r.HandleStageChange)
+// TaskNoOutputWatches returns the watchdog settings for stuck tasks of the job by task name
+func (j *PipelineJob) TaskNoOutputWatches() map[string]taskctl.NoOutputWatch {
+	watches := make(map[string]taskctl.NoOutputWatch)
+	for _, t := range j.Tasks {
+		if t.NoOutputTimeout > 0 {
+			watches[t.Name] = taskctl.NoOutputWatch{
+				Timeout: t.NoOutputTimeout,
+				Kill:    t.NoOutputAction == definition.NoOutputActionKill,
+			}
+		}
+	}
+	return watches
+}
+
+// TaskConditions returns the parsed when conditions of tasks of the job by task name
+func (j *PipelineJob) TaskConditions() map[string]*condition.Expr {
+	conditions := make(map[string]*condition.Expr)
+	for _, t := range j.Tasks {
+		if t.When == "" {
+			continue
+		}
+		// The condition was validated with the definition, an invalid condition of a restored job is skipped
+		expr, err := condition.Parse(t.When)
+		if err != nil {
+			continue
+		}
+		conditions[t.Name] = expr
+	}
+	return conditions
+}
+
+// TaskChangedPaths returns the changed paths conditions of tasks of the job by task name
+func (j *PipelineJob) TaskChangedPaths() map[string]taskctl.ChangedPaths {
+	changedPaths := make(map[string]taskctl.ChangedPaths)
+	for _, t := range j.Tasks {
+		if t.ChangedPaths != nil {
+			changedPaths[t.Name] = taskctl.ChangedPaths{
+				Base:  t.ChangedPaths.Base,
+				Paths: t.ChangedPaths.Paths,
+			}
+		}
+	}
+	return changedPaths
+}
+
+// Definition returns the snapshot of the pipeline definition the job was scheduled with.
+// It only contains the settings that affect the execution of a job (see definition.PipelineDef.Hash).
+func (j *PipelineJob) Definition() definition.PipelineDef {
+	tasks := make(map[string]definition.TaskDef, len(j.Tasks))
+	for _, t := range j.Tasks {
+		tasks[t.Name] = t.TaskDef
+	}
+	return definition.PipelineDef{
+		Env:           j.Env,
+		PriorityClass: j.PriorityClass,
+		Interpreter:   j.Interpreter,
+		Git:           j.Git,
+		Contexts:      j.Contexts,
+		Services:      j.Services.definitions(),
+		Tasks:         tasks,
+	}
+}
+
+func (j *PipelineJob) isRunning() bool {
+	return j.Start != nil && !j.Completed && !j.Canceled && !j.Incomplete
+}
+
+// isFinished returns true if the job is completed, incomplete or was canceled and is not running anymore
+func (j *PipelineJob) isFinished() bool {
+	// A canceled job that was started is finished when the scheduler completed (or it was restored from the store)
+	return j.Completed || j.Incomplete || (j.Canceled && (j.Start == nil || j.cancelFunc == nil))
+}
 
-	j.taskRunner = taskRunner
-	j.sched = sched
+// initContext creates the context of the job for the shared scheduler and task runner, canceling it (or reaching the
+// timeout of the job) cancels only the tasks of this job. It must be called with the lock held.
+func (r *PipelineRunner) initContext(j *PipelineJob) context.Context {
+	// The context is not derived from the context of the runner, jobs are canceled explicitly on a forced shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	if j.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), j.Timeout)
+	}
+	ctx = taskctl.WithJobOptions(ctx, j.TaskOptions())
+	for _, f := range r.jobContextFuncs {
+		ctx = f(ctx, j)
+	}
+
+	j.cancelFunc = cancel
+	return ctx
 }
 
-// deinitScheduler resets the scheduler and task runner for this job since they are no longer needed after a job is completed
-func (j *PipelineJob) deinitScheduler() {
-	j.sched.Finish()
-	j.sched = nil
-	j.taskRunner = nil
+// deinitContext releases the context of the job since it is no longer needed after a job is completed
+func (j *PipelineJob) deinitContext() {
+	j.cancelFunc()
+	j.cancelFunc = nil
+	if j.replaceTimer != nil {
+		j.replaceTimer.Stop()
+		j.replaceTimer = nil
+	}
 }
 
 func (j *PipelineJob) markAsCanceled() {
@@ -173,6 +564,32 @@ type jobTask struct {
 	Errored  bool
 	Error    error
 	Canceled bool
+
+	// ResourceUsage is the accumulated resource usage of all processes started by the task
+	ResourceUsage taskctl.ResourceUsage
+
+	// EnvSnapshot and VariablesSnapshot are the environment and variables the task received when it was started, values
+	// of sensitive names (e.g. containing "secret" or "token") are masked
+	EnvSnapshot       map[string]string
+	VariablesSnapshot map[string]interface{}
+
+	// Commands contains the status of each command of the script that was started (of the last attempt)
+	Commands []jobTaskCommand
+}
+
+// jobTaskCommand is the status of a single command of the script of a task
+type jobTaskCommand struct {
+	Command  string
+	Start    *time.Time
+	End      *time.Time
+	ExitCode int16
+	Error    error
+
+	// StdoutOffset and StderrOffset are the positions of the command output in the output of the task
+	StdoutOffset int64
+	StdoutLength int64
+	StderrOffset int64
+	StderrLength int64
 }
 
 type jobTasks []jobTask
@@ -188,13 +605,21 @@ const (
 	scheduleActionQueueFull
 )
 
-var errNoQueue = errors.New("concurrency exceeded and queueing disabled for pipeline")
-var errQueueFull = errors.New("concurrency exceeded and queue limit reached for pipeline")
+var ErrNoQueue = errors.New("concurrency exceeded and queueing disabled for pipeline")
+var ErrQueueFull = errors.New("concurrency exceeded and queue limit reached for pipeline")
 var ErrJobNotFound = errors.New("job not found")
+var ErrPipelineNotDefined = errors.New("pipeline is not defined")
 var errJobAlreadyCompleted = errors.New("job is already completed")
 var ErrShuttingDown = errors.New("runner is shutting down")
+var ErrScheduleRefused = errors.New("refusing to schedule job")
+var ErrJobTimeout = errors.New("job timed out")
 
 func (r *PipelineRunner) ScheduleAsync(pipeline string, opts ScheduleOpts) (*PipelineJob, error) {
+	err := r.runPreScheduleHooks(pipeline, &opts)
+	if err != nil {
+		return nil, err
+	}
+
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
@@ -204,38 +629,77 @@ func (r *PipelineRunner) ScheduleAsync(pipeline string, opts ScheduleOpts) (*Pip
 
 	pipelineDef, ok := r.defs.Pipelines[pipeline]
 	if !ok {
-		return nil, errors.Errorf("pipeline %q is not defined", pipeline)
+		return nil, fmt.Errorf("%w: %q", ErrPipelineNotDefined, pipeline)
+	}
+
+	if err := validateLabels(opts.Labels); err != nil {
+		return nil, err
+	}
+
+	if canSchedule, reason := r.checkScheduleGates(); !canSchedule {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleRefused, reason)
+	}
+
+	if quota, exceeded := r.exceededQuota(pipeline, definition.QuotaActionReject); exceeded {
+		r.recordQuotaExceeded(pipeline, quota)
+		return nil, fmt.Errorf("%w: %s", ErrQuotaExceeded, quota)
 	}
 
 	action := r.resolveScheduleAction(pipeline, false)
 
 	switch action {
 	case scheduleActionNoQueue:
-		return nil, errNoQueue
+		return nil, ErrNoQueue
 	case scheduleActionQueueFull:
-		return nil, errQueueFull
+		return nil, ErrQueueFull
 	}
 
-	id, err := uuid.NewV4()
+	id, err := r.newID()
 	if err != nil {
 		return nil, errors.Wrap(err, "generating job UUID")
 	}
 
-	defer r.requestPersist()
-
 	job := &PipelineJob{
 		ID:         id,
 		Pipeline:   pipeline,
-		Created:    time.Now(),
+		Created:    r.now(),
 		Tasks:      buildJobTasks(pipelineDef.Tasks),
 		Env:        pipelineDef.Env,
 		Variables:  opts.Variables,
 		User:       opts.User,
+		Labels:     opts.Labels,
+		Priority:   opts.Priority,
 		StartDelay: pipelineDef.StartDelay,
+
+		CorrelationID: opts.CorrelationID,
+		Trigger:       opts.Trigger,
+		RequeuedFrom:  opts.RequeuedFrom,
+		ClonedFrom:    opts.ClonedFrom,
+
+		PriorityClass:    pipelineDef.PriorityClass,
+		Interpreter:      pipelineDef.Interpreter,
+		Weight:           pipelineDef.Weight,
+		Timeout:          pipelineDef.JobTimeout,
+		Git:              pipelineDef.Git,
+		KeepTmpOnFailure: pipelineDef.KeepTmpOnFailure,
+		Contexts:         pipelineDef.Contexts,
+		Services:         buildJobServices(pipelineDef.Services),
+		DefinitionHash:   pipelineDef.Hash(),
+	}
+
+	// The slot of a job is claimed before it is added, so it is queued if other instances use all slots
+	if action == scheduleActionStart && !r.claimSlot(job) {
+		if pipelineDef.QueueLimit != nil && *pipelineDef.QueueLimit == 0 {
+			return nil, ErrNoQueue
+		}
+		action = scheduleActionQueue
 	}
 
-	r.jobsByID[id] = job
-	r.jobsByPipeline[pipeline] = append(r.jobsByPipeline[pipeline], job)
+	r.addJob(job)
+	r.publish(JobScheduled{
+		JobEvent: job.jobEvent(),
+		Queued:   action == scheduleActionQueue || action == scheduleActionReplace,
+	})
 
 	if job.StartDelay > 0 {
 		// A delayed job is a job on the wait list that is started by a function after a delay
@@ -243,49 +707,67 @@ func (r *PipelineRunner) ScheduleAsync(pipeline string, opts ScheduleOpts) (*Pip
 			r.StartDelayedJob(id)
 		})
 	}
+	if action != scheduleActionStart && pipelineDef.QueueTimeout > 0 {
+		r.startQueueTimer(job, pipelineDef.QueueTimeout)
+	}
+	if action != scheduleActionStart {
+		if quota, exceeded := r.exceededQuota(pipeline, definition.QuotaActionQueue); exceeded {
+			r.recordQuotaExceeded(pipeline, quota)
+		}
+	}
 
 	switch action {
 	case scheduleActionQueue:
-		r.waitListByPipeline[pipeline] = append(r.waitListByPipeline[pipeline], job)
+		r.waitListByPipeline[pipeline] = insertByPriority(r.waitListByPipeline[pipeline], job)
 
-		log.
+		r.logger.
 			WithField("component", "runner").
 			WithField("pipeline", job.Pipeline).
-			WithField("jobID", job.ID).
+			WithFields(job.logFields()).
 			WithField("variables", job.Variables).
 			Debugf("Queued: added job to wait list")
 
+		r.replaceRunningJob(pipeline)
+		r.armWindowTimer(pipeline)
+		r.armQuotaTimer(pipeline)
+
 		return job, nil
 	case scheduleActionReplace:
 		waitList := r.waitListByPipeline[pipeline]
 		previousJob := waitList[len(waitList)-1]
-		previousJob.Canceled = true
+		previousJob.stopQueueTimer()
+		previousJob.markAsCanceled()
 		if previousJob.startTimer != nil {
-			log.
+			r.logger.
 				WithField("previousJobID", previousJob.ID).
 				Debugf("Stopped start timer of previous job")
 			// Stop timer and unset reference for clean up
 			previousJob.startTimer.Stop()
 			previousJob.startTimer = nil
 		}
-		waitList[len(waitList)-1] = job
+		r.waitListByPipeline[pipeline] = insertByPriority(waitList[:len(waitList)-1], job)
+		r.publishJobCompleted(previousJob)
 
-		log.
+		r.logger.
 			WithField("component", "runner").
 			WithField("pipeline", job.Pipeline).
-			WithField("jobID", job.ID).
+			WithFields(job.logFields()).
 			WithField("variables", job.Variables).
 			Debugf("Queued: replaced job on wait list")
 
+		r.replaceRunningJob(pipeline)
+		r.armWindowTimer(pipeline)
+		r.armQuotaTimer(pipeline)
+
 		return job, nil
 	}
 
 	r.startJob(job)
 
-	log.
+	r.logger.
 		WithField("component", "runner").
 		WithField("pipeline", job.Pipeline).
-		WithField("jobID", job.ID).
+		WithFields(job.logFields()).
 		WithField("variables", job.Variables).
 		Debugf("Started: scheduled job execution")
 
@@ -308,25 +790,26 @@ func buildJobTasks(tasks map[string]definition.TaskDef) (result jobTasks) {
 	return result
 }
 
-func buildPipelineGraph(id uuid.UUID, tasks jobTasks, vars map[string]interface{}) (*scheduler.ExecutionGraph, error) {
+func buildPipelineGraph(id uuid.UUID, pipeline string, start time.Time, tasks jobTasks, vars map[string]interface{}, defaultDir string) (*scheduler.ExecutionGraph, error) {
 	var stages []*scheduler.Stage
 	for _, taskDef := range tasks {
 		t := task.FromCommands(taskDef.Script...)
 		t.Env = variables.FromMap(taskDef.Env)
 		t.Name = taskDef.Name
 		t.AllowFailure = taskDef.AllowFailure
+		t.Dir = taskDef.Dir
+		if t.Dir == "" {
+			t.Dir = defaultDir
+		}
+		t.Interactive = taskDef.Interactive
+		if taskDef.Timeout > 0 {
+			timeout := taskDef.Timeout
+			t.Timeout = &timeout
+		}
 
-		taskVariables := variables.FromMap(map[string]string{
-			// Inject job id for later use in the task runner (see HandleStageChange and HandleTaskChange)
-			taskctl.JobIDVariableName: id.String(),
-		})
-
-		for name, value := range vars {
-			if name == taskctl.JobIDVariableName {
-				return nil, errors.Errorf("variable name %s is reserved for internal use", taskctl.JobIDVariableName)
-			}
-
-			taskVariables.Set(name, value)
+		taskVariables, err := buildTaskVariables(id, pipeline, start, vars)
+		if err != nil {
+			return nil, err
 		}
 
 		s := &scheduler.Stage{
@@ -348,40 +831,245 @@ func buildPipelineGraph(id uuid.UUID, tasks jobTasks, vars map[string]interface{
 	return g, nil
 }
 
-func (r *PipelineRunner) ReadJob(id uuid.UUID, process func(j *PipelineJob)) error {
+// RenderedTask is a task of a pipeline with the rendered commands of its script
+type RenderedTask struct {
+	Name      string
+	DependsOn []string
+	Script    []string
+	// Error is set if a command could not be rendered
+	Error error
+}
+
+// RenderScripts renders the scripts of all tasks of a pipeline with the given variables without scheduling a job
+//
+// The built-in functions are rendered with a nil job id and the current time.
+func (r *PipelineRunner) RenderScripts(pipeline string, vars map[string]interface{}) ([]RenderedTask, error) {
+	r.mx.RLock()
+	pipelineDef, ok := r.defs.Pipelines[pipeline]
+	r.mx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrPipelineNotDefined, pipeline)
+	}
+
+	return renderTasks(pipeline, pipelineDef, vars)
+}
+
+func renderTasks(pipeline string, pipelineDef definition.PipelineDef, vars map[string]interface{}) ([]RenderedTask, error) {
+	taskVariables, err := buildTaskVariables(uuid.Nil, pipeline, time.Now(), vars)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := buildJobTasks(pipelineDef.Tasks)
+	result := make([]RenderedTask, 0, len(tasks))
+	for _, t := range tasks {
+		renderedTask := RenderedTask{Name: t.Name, DependsOn: t.DependsOn}
+		for _, command := range t.Script {
+			renderedCommand, err := taskctl.RenderCommand(command, taskVariables.Map())
+			if err != nil {
+				renderedTask.Error = err
+				break
+			}
+			renderedTask.Script = append(renderedTask.Script, renderedCommand)
+		}
+		result = append(result, renderedTask)
+	}
+
+	return result, nil
+}
+
+// ScheduleAction is the action taken when scheduling a job, as reported by a dry-run
+type ScheduleAction string
+
+const (
+	// ScheduleActionStart starts the job immediately
+	ScheduleActionStart ScheduleAction = "start"
+	// ScheduleActionQueue adds the job to the wait list (also if it is delayed by a start delay)
+	ScheduleActionQueue ScheduleAction = "queue"
+	// ScheduleActionReplace replaces the last job on the wait list
+	ScheduleActionReplace ScheduleAction = "replace"
+	// ScheduleActionRejected does not schedule the job
+	ScheduleActionRejected ScheduleAction = "rejected"
+)
+
+// ScheduleDryRunResult describes what scheduling a job would do
+type ScheduleDryRunResult struct {
+	Action ScheduleAction
+	// Reason is set if the job would be rejected
+	Reason string
+	// ReplacedJobID is the id of the job on the wait list that would be replaced
+	ReplacedJobID *uuid.UUID
+	// Tasks of the job with rendered scripts (see RenderScripts)
+	Tasks []RenderedTask
+}
+
+// ScheduleDryRun resolves the action and renders the tasks of a job for the pipeline without creating the job
+func (r *PipelineRunner) ScheduleDryRun(pipeline string, opts ScheduleOpts) (*ScheduleDryRunResult, error) {
 	r.mx.RLock()
 	defer r.mx.RUnlock()
 
+	if r.isShuttingDown {
+		return nil, ErrShuttingDown
+	}
+
+	pipelineDef, ok := r.defs.Pipelines[pipeline]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrPipelineNotDefined, pipeline)
+	}
+
+	if err := validateLabels(opts.Labels); err != nil {
+		return nil, err
+	}
+
+	tasks, err := renderTasks(pipeline, pipelineDef, opts.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ScheduleDryRunResult{
+		Tasks: tasks,
+	}
+
+	if canSchedule, reason := r.checkScheduleGates(); !canSchedule {
+		result.Action = ScheduleActionRejected
+		result.Reason = fmt.Sprintf("%v: %s", ErrScheduleRefused, reason)
+		return result, nil
+	}
+
+	if quota, exceeded := r.exceededQuota(pipeline, definition.QuotaActionReject); exceeded {
+		result.Action = ScheduleActionRejected
+		result.Reason = fmt.Sprintf("%v: %s", ErrQuotaExceeded, quota)
+		return result, nil
+	}
+
+	switch r.resolveScheduleAction(pipeline, false) {
+	case scheduleActionStart:
+		result.Action = ScheduleActionStart
+	case scheduleActionQueue, scheduleActionQueueDelay:
+		result.Action = ScheduleActionQueue
+	case scheduleActionReplace:
+		result.Action = ScheduleActionReplace
+		waitList := r.waitListByPipeline[pipeline]
+		replacedJobID := waitList[len(waitList)-1].ID
+		result.ReplacedJobID = &replacedJobID
+	case scheduleActionNoQueue:
+		result.Action = ScheduleActionRejected
+		result.Reason = ErrNoQueue.Error()
+	case scheduleActionQueueFull:
+		result.Action = ScheduleActionRejected
+		result.Reason = ErrQueueFull.Error()
+	}
+
+	return result, nil
+}
+
+var reservedVariableNames = []string{taskctl.JobIDVariableName, taskctl.PipelineVariableName, taskctl.TimestampVariableName}
+
+// buildTaskVariables merges the job variables with built-in variables that are used for rendering scripts
+func buildTaskVariables(id uuid.UUID, pipeline string, start time.Time, vars map[string]interface{}) (variables.Container, error) {
+	taskVariables := variables.FromMap(map[string]string{
+		// Inject job id for later use in the task runner (see HandleStageChange and HandleTaskChange)
+		taskctl.JobIDVariableName:    id.String(),
+		taskctl.PipelineVariableName: pipeline,
+	})
+	taskVariables.Set(taskctl.TimestampVariableName, start)
+
+	for name, value := range vars {
+		for _, reservedName := range reservedVariableNames {
+			if name == reservedName {
+				return nil, errors.Errorf("variable name %s is reserved for internal use", reservedName)
+			}
+		}
+
+		taskVariables.Set(name, value)
+	}
+
+	return taskVariables, nil
+}
+
+// ReadJob calls process for the job in a read lock, evicted jobs are loaded from the store (see WithMaxCachedJobs).
+// It is not safe to reference the job outside of the process function.
+func (r *PipelineRunner) ReadJob(id uuid.UUID, process func(j *PipelineJob)) error {
+	r.mx.RLock()
 	job, ok := r.jobsByID[id]
 	if !ok {
-		return ErrJobNotFound
+		_, evicted := r.evictedJobs[id]
+		r.mx.RUnlock()
+		if !evicted {
+			return ErrJobNotFound
+		}
+		return r.readEvictedJob(id, process)
 	}
+	defer r.mx.RUnlock()
 
 	process(job)
 
 	return nil
 }
 
+// CurrentTaskOutput returns the last lines of an output (stdout or stderr) of a running task from memory, ok is false if
+// the task is not running or the task runner keeps no live output (see WithLiveOutputLines)
+func (r *PipelineRunner) CurrentTaskOutput(jobID uuid.UUID, taskName, outputName string) (lines []string, ok bool) {
+	liveOutput, ok := r.taskRunner.(taskctl.LiveOutput)
+	if !ok {
+		return nil, false
+	}
+	return liveOutput.CurrentTaskOutput(jobID.String(), taskName, outputName)
+}
+
+// WriteTaskInput writes data to the stdin of a running interactive task, taskctl.ErrNoTaskInput is returned if the task
+// is not running or not interactive
+func (r *PipelineRunner) WriteTaskInput(jobID uuid.UUID, taskName string, data []byte) error {
+	taskInput, ok := r.taskRunner.(taskctl.TaskInput)
+	if !ok {
+		return taskctl.ErrNoTaskInput
+	}
+	return taskInput.WriteTaskInput(jobID.String(), taskName, data)
+}
+
+// CloseTaskInput closes the stdin of a running interactive task, so the process reads EOF
+func (r *PipelineRunner) CloseTaskInput(jobID uuid.UUID, taskName string) error {
+	taskInput, ok := r.taskRunner.(taskctl.TaskInput)
+	if !ok {
+		return taskctl.ErrNoTaskInput
+	}
+	return taskInput.CloseTaskInput(jobID.String(), taskName)
+}
+
+// SubscribeTaskOutput returns a channel that receives the output of a running task until the task finished or
+// unsubscribe is called, taskctl.ErrTaskNotRunning is returned if the task is not running
+func (r *PipelineRunner) SubscribeTaskOutput(jobID uuid.UUID, taskName string) (chunks <-chan taskctl.OutputChunk, unsubscribe func(), err error) {
+	outputStream, ok := r.taskRunner.(taskctl.OutputStream)
+	if !ok {
+		return nil, nil, taskctl.ErrTaskNotRunning
+	}
+	return outputStream.SubscribeTaskOutput(jobID.String(), taskName)
+}
+
 func (r *PipelineRunner) startJob(job *PipelineJob) {
 	// If the job was queued and marked as canceled, we don't start it
 	if job.Canceled {
 		return
 	}
+	job.stopQueueTimer()
 
-	defer r.requestPersist()
+	ctx := r.initContext(job)
 
-	r.initScheduler(job)
+	now := r.now()
 
-	graph, err := buildPipelineGraph(job.ID, job.Tasks, job.Variables)
+	checkoutDir := job.checkoutDir()
+	graph, err := buildPipelineGraph(job.ID, job.Pipeline, now, job.Tasks, job.Variables, checkoutDir)
 	if err != nil {
-		log.
+		r.logger.
 			WithError(err).
-			WithField("jobID", job.ID).
+			WithFields(job.logFields()).
 			WithField("pipeline", job.Pipeline).
 			Error("Failed to build pipeline graph")
 
 		job.LastError = err
 		job.Canceled = true
+		job.deinitContext()
+		r.publishJobCompleted(job)
 
 		// A job was canceled, so there might be room for other jobs to start
 		r.startJobsOnWaitList(job.Pipeline)
@@ -390,18 +1078,50 @@ func (r *PipelineRunner) startJob(job *PipelineJob) {
 	}
 
 	// Actually start job
-	now := time.Now()
 	job.Start = &now
+	r.applyDurationStats(job)
+	r.startSLATimer(job)
+	r.publish(JobStarted{JobEvent: job.jobEvent()})
 
 	// Run graph asynchronously
+	timeout := job.Timeout
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		lastErr := job.sched.Schedule(graph)
+		lastErr := r.runJob(ctx, job, graph, checkoutDir)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			lastErr = fmt.Errorf("%w after %s", ErrJobTimeout, timeout)
+		}
 		r.JobCompleted(job.ID, lastErr)
 	}()
 }
 
+// runJob prepares the temporary directory, git checkout and services of the job, runs the tasks and cleans up
+// afterwards. It must be called without the lock held.
+func (r *PipelineRunner) runJob(ctx context.Context, job *PipelineJob, graph *scheduler.ExecutionGraph, checkoutDir string) (lastErr error) {
+	if err := os.MkdirAll(job.tmpDir(), 0700); err != nil {
+		return errors.Wrap(err, "creating temporary directory")
+	}
+	defer func() {
+		r.removeTmpDir(job, lastErr)
+	}()
+
+	if job.Git != nil {
+		defer r.removeCheckout(job, checkoutDir)
+		if err := r.checkoutJob(ctx, job, checkoutDir); err != nil {
+			return err
+		}
+	}
+	services, err := r.startServices(ctx, job, checkoutDir)
+	if err != nil {
+		return err
+	}
+	defer r.stopContexts(ctx, job)
+	defer r.stopServices(job, services)
+
+	return r.sched.Schedule(ctx, graph)
+}
+
 // HandleTaskChange will be called when the task state changes in the task runner
 func (r *PipelineRunner) HandleTaskChange(t *task.Task) {
 	r.mx.Lock()
@@ -418,10 +1138,16 @@ func (r *PipelineRunner) HandleTaskChange(t *task.Task) {
 	if jt == nil {
 		return
 	}
+	started := jt.Start == nil && !t.Start.IsZero()
+	finished := jt.End == nil && !t.End.IsZero()
 	if !t.Start.IsZero() {
 		start := t.Start
 		jt.Start = &start
 	}
+	if started {
+		jt.EnvSnapshot = j.taskEnvSnapshot(jt)
+		jt.VariablesSnapshot = j.variablesSnapshot()
+	}
 	if !t.End.IsZero() {
 		end := t.End
 		jt.End = &end
@@ -440,13 +1166,14 @@ func (r *PipelineRunner) HandleTaskChange(t *task.Task) {
 	// if the task has errored, and we want to fail-fast (ContinueRunningTasksAfterFailure is set to FALSE),
 	// then we directly abort all other tasks of the job.
 	// NOTE: this is NOT the context.Canceled case from above (if a job is explicitly aborted), but only
-	// if one task failed, and we want to kill the other tasks.
-	if jt.Errored {
+	// if one task failed, and we want to kill the other tasks. A failure of a task with AllowFailure does not abort
+	// the job.
+	if jt.Errored && !jt.AllowFailure {
 		pipelineDef, found := r.defs.Pipelines[j.Pipeline]
 		if found && !pipelineDef.ContinueRunningTasksAfterFailure {
-			log.
+			r.logger.
 				WithField("component", "runner").
-				WithField("jobID", jobIDString).
+				WithFields(j.logFields()).
 				WithField("pipeline", j.Pipeline).
 				WithField("failedTaskName", t.Name).
 				Debug("Task failed - cancelling all other tasks of the job")
@@ -455,7 +1182,24 @@ func (r *PipelineRunner) HandleTaskChange(t *task.Task) {
 		}
 	}
 
-	r.requestPersist()
+	if finished {
+		r.recordTaskUsage(j.Pipeline, jt)
+	}
+
+	switch {
+	case finished:
+		r.publish(TaskFinished{
+			JobEvent: j.jobEvent(),
+			Task:     jt.Name,
+			Skipped:  jt.Skipped,
+			Errored:  jt.Errored,
+			ExitCode: jt.ExitCode,
+		})
+	case started:
+		r.publish(TaskStarted{JobEvent: j.jobEvent(), Task: jt.Name})
+	default:
+		r.publish(JobChanged{JobEvent: j.jobEvent()})
+	}
 }
 
 // HandleStageChange will be called when the stage state changes in the scheduler
@@ -475,54 +1219,150 @@ func (r *PipelineRunner) HandleStageChange(stage *scheduler.Stage) {
 		return
 	}
 
+	status := toStatus(stage.ReadStatus())
 	if jt.Canceled {
-		jt.Status = "canceled"
-	} else {
-		jt.Status = toStatus(stage.ReadStatus())
+		status = "canceled"
+	} else if jt.Skipped && status == "done" {
+		// The task runner skips tasks with a false condition, the stage is done anyway
+		status = "skipped"
+	}
+	if jt.Status == status {
+		return
 	}
+	jt.Status = status
 
-	r.requestPersist()
+	r.publish(TaskStatusChanged{JobEvent: j.jobEvent(), Task: jt.Name, Status: status})
 }
 
-func (r *PipelineRunner) JobCompleted(id uuid.UUID, err error) {
+// HandleProcessChange will be called when a process of a task was started or has exited
+func (r *PipelineRunner) HandleProcessChange(c taskctl.ProcessChange) {
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
-	job := r.jobsByID[id]
-	if job == nil {
+	jobID, _ := uuid.FromString(c.JobID)
+	j, ok := r.jobsByID[jobID]
+	if !ok {
 		return
 	}
 
-	job.deinitScheduler()
-
-	job.Completed = true
-	now := time.Now()
-	job.End = &now
-	job.LastError = err
+	if c.Exited {
+		for i, jp := range j.Processes {
+			if jp.Pid == c.Process.Pid {
+				j.Processes = append(j.Processes[:i], j.Processes[i+1:]...)
+				break
+			}
+		}
 
-	// Set canceled flag on the job if a task was canceled through the context
-	if errors.Is(err, context.Canceled) {
-		job.Canceled = true
+		if jt := j.Tasks.ByName(c.TaskName); jt != nil {
+			jt.ResourceUsage = jt.ResourceUsage.Add(c.Usage)
+		}
+	} else {
+		j.Processes = append(j.Processes, c.Process)
 	}
 
-	pipeline := job.Pipeline
-	log.
-		WithField("component", "runner").
-		WithField("jobID", id).
-		WithField("pipeline", pipeline).
-		Debug("Job completed")
-
-	// A job finished, so there might be room to start other jobs on the wait list
-	r.startJobsOnWaitList(pipeline)
-
-	r.requestPersist()
+	r.publish(JobChanged{JobEvent: j.jobEvent()})
 }
 
-func (r *PipelineRunner) startJobsOnWaitList(pipeline string) {
-	// Check wait list if another job is queued
-	waitList := r.waitListByPipeline[pipeline]
+// HandleCommandChange will be called when a command of the script of a task was started or has finished
+func (r *PipelineRunner) HandleCommandChange(c taskctl.CommandChange) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
 
-	// Schedule as many jobs as are schedulable (also process if the schedule action is start delay and check individual jobs if they can be started)
+	jobID, _ := uuid.FromString(c.JobID)
+	j, ok := r.jobsByID[jobID]
+	if !ok {
+		return
+	}
+	jt := j.Tasks.ByName(c.TaskName)
+	if jt == nil {
+		return
+	}
+
+	// A retry starts with the first command again, so only the commands of the last attempt are kept
+	if c.Index < len(jt.Commands) && !c.Finished() {
+		jt.Commands = jt.Commands[:c.Index]
+	}
+	if c.Index > len(jt.Commands) {
+		return
+	}
+
+	start := c.Start
+	cmd := jobTaskCommand{
+		Command:      c.Command,
+		Start:        &start,
+		ExitCode:     c.ExitCode,
+		Error:        c.Error,
+		StdoutOffset: c.StdoutOffset,
+		StdoutLength: c.StdoutLength,
+		StderrOffset: c.StderrOffset,
+		StderrLength: c.StderrLength,
+	}
+	if c.Finished() {
+		end := c.End
+		cmd.End = &end
+	}
+	if c.Index == len(jt.Commands) {
+		jt.Commands = append(jt.Commands, cmd)
+	} else {
+		jt.Commands[c.Index] = cmd
+	}
+
+	r.publish(JobChanged{JobEvent: j.jobEvent()})
+}
+
+func (r *PipelineRunner) JobCompleted(id uuid.UUID, err error) {
+	if r.flushOnCompletion && r.store != nil {
+		// Deferred before unlocking, so the state is saved after the lock was released
+		defer r.SaveToStore()
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	job := r.jobsByID[id]
+	if job == nil {
+		return
+	}
+
+	job.deinitContext()
+
+	job.Completed = true
+	now := r.now()
+	job.End = &now
+	job.LastError = err
+	r.stopSLATimer(job)
+
+	// Set canceled flag on the job if a task was canceled through the context
+	if errors.Is(err, context.Canceled) {
+		job.Canceled = true
+	}
+	r.publishJobCompleted(job)
+
+	pipeline := job.Pipeline
+	r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", pipeline).
+		Debug("Job completed")
+
+	// The failure rate changes with every finished job, so it is evaluated without waiting for the next check
+	if r.alertsWatched && r.defs.Pipelines[pipeline].Alerts.FailureRate.Runs > 0 {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.CheckAlerts()
+		}()
+	}
+
+	// A job finished, so there might be room to start other jobs on the wait list
+	r.startJobsOnWaitList(pipeline)
+}
+
+func (r *PipelineRunner) startJobsOnWaitList(pipeline string) {
+	// Check wait list if another job is queued
+	waitList := r.waitListByPipeline[pipeline]
+
+	// Schedule as many jobs as are schedulable (also process if the schedule action is start delay and check individual jobs if they can be started)
 	for len(waitList) > 0 && r.resolveDequeueJobAction(waitList[0]) == scheduleActionStart {
 		queuedJob := waitList[0]
 		// Queued job has a start delay timer set - wait for it to fire
@@ -531,17 +1371,77 @@ func (r *PipelineRunner) startJobsOnWaitList(pipeline string) {
 			break
 		}
 
+		if !r.claimSlot(queuedJob) {
+			break
+		}
+
 		waitList = waitList[1:]
 
 		r.startJob(queuedJob)
 
-		log.
+		r.logger.
 			WithField("component", "runner").
 			WithField("pipeline", queuedJob.Pipeline).
-			WithField("jobID", queuedJob.ID).
+			WithFields(queuedJob.logFields()).
 			Debugf("Dequeue: scheduled job execution")
 	}
 	r.waitListByPipeline[pipeline] = waitList
+
+	r.armWindowTimer(pipeline)
+	r.armQuotaTimer(pipeline)
+}
+
+// WaitForJob blocks until the job is finished (completed or canceled) or the context is done
+func (r *PipelineRunner) WaitForJob(ctx context.Context, id uuid.UUID) error {
+	r.mx.Lock()
+	job, ok := r.jobsByID[id]
+	if !ok {
+		job, ok = r.evictedJobs[id]
+	}
+	if !ok {
+		r.mx.Unlock()
+		return ErrJobNotFound
+	}
+	if job.isFinished() {
+		r.mx.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	r.jobWaiters[id] = append(r.jobWaiters[id], done)
+	r.mx.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		r.mx.Lock()
+		r.removeJobWaiter(id, done)
+		r.mx.Unlock()
+		return ctx.Err()
+	}
+}
+
+// notifyJobWaiters notifies all waiters of a finished job, it must be called with the lock held
+func (r *PipelineRunner) notifyJobWaiters(id uuid.UUID) {
+	for _, done := range r.jobWaiters[id] {
+		close(done)
+	}
+	delete(r.jobWaiters, id)
+}
+
+func (r *PipelineRunner) removeJobWaiter(id uuid.UUID, done chan struct{}) {
+	waiters := r.jobWaiters[id]
+	for i, waiter := range waiters {
+		if waiter == done {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(r.jobWaiters, id)
+	} else {
+		r.jobWaiters[id] = waiters
+	}
 }
 
 // IterateJobs calls process for each job in a read lock.
@@ -555,10 +1455,107 @@ func (r *PipelineRunner) IterateJobs(process func(j *PipelineJob)) {
 	}
 }
 
+// FindJobs calls process for each job matching the selector in a read lock, jobs are looked up in an index of labels
+// and variables. Variables are matched by their formatted value (elements of list variables are matched separately).
+// It is not safe to reference the job outside of the process function.
+func (r *PipelineRunner) FindJobs(selector JobSelector, process func(j *PipelineJob)) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	if selector.IsEmpty() {
+		for _, pJob := range r.jobsByID {
+			process(pJob)
+		}
+		return
+	}
+
+	for id := range r.jobIndex.find(selector) {
+		if pJob, ok := r.jobsByID[id]; ok {
+			process(pJob)
+		}
+	}
+}
+
+// ListJobsOpts selects and paginates jobs for ListJobs
+type ListJobsOpts struct {
+	// Pipeline only lists jobs of the pipeline if set
+	Pipeline string
+	// Selector only lists jobs matching the labels and variables (see FindJobs)
+	Selector JobSelector
+	// Offset is the number of newest matching jobs to skip
+	Offset int
+	// Limit is the maximum number of jobs to list (0 lists all jobs)
+	Limit int
+}
+
+// ListJobs calls process for each job matching the options ordered by creation time (newest first) in a read lock and
+// returns the total number of matching jobs. Jobs evicted from memory are not listed (see WithMaxCachedJobs).
+// It is not safe to reference the job outside of the process function.
+func (r *PipelineRunner) ListJobs(opts ListJobsOpts, process func(j *PipelineJob)) int {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	jobs := r.jobsByCreation
+	if opts.Pipeline != "" {
+		jobs = r.jobsByPipeline[opts.Pipeline]
+	}
+	var ids jobIDSet
+	if !opts.Selector.IsEmpty() {
+		ids = r.jobIndex.find(opts.Selector)
+	}
+
+	total := 0
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		if ids != nil {
+			if _, ok := ids[job.ID]; !ok {
+				continue
+			}
+		}
+		if total >= opts.Offset && (opts.Limit == 0 || total < opts.Offset+opts.Limit) {
+			process(job)
+		}
+		total++
+	}
+
+	return total
+}
+
+// CheckResponsive returns an error if the job state cannot be locked before the context is done, e.g. because of a
+// deadlock. It is used for watchdogs.
+func (r *PipelineRunner) CheckResponsive(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		r.mx.RLock()
+		r.mx.RUnlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "locking job state")
+	}
+}
+
 type PipelineInfo struct {
-	Pipeline    string
+	Pipeline string
+	// Description and Tags are taken from the definition of the pipeline
+	Description string
+	Tags        []string
 	Schedulable bool
 	Running     bool
+	// Drained is set if jobs of the pipeline are not started from the wait list (see DrainPipeline)
+	Drained bool
+	// DefinitionHash is the hash of the current definition of the pipeline, it changes if the definition changes
+	DefinitionHash string
+	// Durations are the statistics of the durations of the newest successful jobs
+	Durations DurationStats
+	// SourcePath is the definition file of the pipeline, ShadowedSourcePaths are files with ignored or overridden
+	// declarations of the pipeline (see definition.DuplicatePolicy)
+	SourcePath          string
+	ShadowedSourcePaths []string
 }
 
 // ListPipelines lists pipelines with status information about each pipeline (is it running, is it schedulable)
@@ -568,13 +1565,21 @@ func (r *PipelineRunner) ListPipelines() []PipelineInfo {
 
 	res := []PipelineInfo{}
 
-	for pipeline := range r.defs.Pipelines {
+	for pipeline, pipelineDef := range r.defs.Pipelines {
 		running := r.isRunning(pipeline)
 
 		res = append(res, PipelineInfo{
-			Pipeline:    pipeline,
-			Schedulable: r.isSchedulable(pipeline),
-			Running:     running,
+			Pipeline:       pipeline,
+			Description:    pipelineDef.Description,
+			Tags:           pipelineDef.Tags,
+			Schedulable:    r.isSchedulable(pipeline),
+			Running:        running,
+			Drained:        r.drainedPipelines[pipeline],
+			DefinitionHash: pipelineDef.Hash(),
+			Durations:      r.durationStats(pipeline),
+
+			SourcePath:          pipelineDef.SourcePath,
+			ShadowedSourcePaths: pipelineDef.ShadowedSourcePaths,
 		})
 	}
 
@@ -585,6 +1590,39 @@ func (r *PipelineRunner) ListPipelines() []PipelineInfo {
 	return res
 }
 
+// DefinitionDiff contains the changes from the pipeline definition of a job to the current definition
+type DefinitionDiff struct {
+	JobDefinitionHash     string
+	CurrentDefinitionHash string
+	Changes               []definition.Change
+}
+
+// DiffJobDefinition compares the definition a job was scheduled with to the current definition of its pipeline
+func (r *PipelineRunner) DiffJobDefinition(id uuid.UUID) (DefinitionDiff, error) {
+	var (
+		result  DefinitionDiff
+		diffErr error
+	)
+	err := r.ReadJob(id, func(job *PipelineJob) {
+		pipelineDef, ok := r.defs.Pipelines[job.Pipeline]
+		if !ok {
+			diffErr = ErrPipelineNotDefined
+			return
+		}
+
+		result = DefinitionDiff{
+			JobDefinitionHash:     job.DefinitionHash,
+			CurrentDefinitionHash: pipelineDef.Hash(),
+			Changes:               definition.Diff(job.Definition(), pipelineDef),
+		}
+	})
+	if err != nil {
+		return DefinitionDiff{}, err
+	}
+
+	return result, diffErr
+}
+
 func (r *PipelineRunner) isRunning(pipeline string) bool {
 	for _, job := range r.jobsByPipeline[pipeline] {
 		if job.isRunning() {
@@ -607,10 +1645,12 @@ func (r *PipelineRunner) runningJobsCount(pipeline string) int {
 func (r *PipelineRunner) resolveScheduleAction(pipeline string, ignoreStartDelay bool) scheduleAction {
 	pipelineDef := r.defs.Pipelines[pipeline]
 
-	// If a start delay is set, we will always queue the job, otherwise we check if the number of running jobs
-	// exceed the maximum concurrency
+	// If a start delay is set, the pipeline is drained, a start gate is closed, the schedule window is closed or a queue
+	// quota is exceeded, we will always queue the job, otherwise we check if the number of running jobs exceed the
+	// maximum concurrency
 	runningJobsCount := r.runningJobsCount(pipeline)
-	if runningJobsCount >= pipelineDef.Concurrency || (pipelineDef.StartDelay > 0 && !ignoreStartDelay) {
+	_, quotaExceeded := r.exceededQuota(pipeline, definition.QuotaActionQueue)
+	if runningJobsCount >= pipelineDef.Concurrency || (pipelineDef.StartDelay > 0 && !ignoreStartDelay) || r.drainedPipelines[pipeline] || !r.canStartJobs() || !r.isInScheduleWindow(pipelineDef) || quotaExceeded {
 		// Check if jobs should be queued if concurrency factor is exceeded
 		if pipelineDef.QueueLimit != nil && *pipelineDef.QueueLimit == 0 {
 			return scheduleActionNoQueue
@@ -618,7 +1658,8 @@ func (r *PipelineRunner) resolveScheduleAction(pipeline string, ignoreStartDelay
 
 		// Check if a queued job on the wait list should be replaced depending on queue strategy
 		waitList := r.waitListByPipeline[pipeline]
-		if pipelineDef.QueueStrategy == definition.QueueStrategyReplace && len(waitList) > 0 {
+		replaceQueued := pipelineDef.QueueStrategy == definition.QueueStrategyReplace || pipelineDef.QueueStrategy == definition.QueueStrategyReplaceRunning
+		if replaceQueued && len(waitList) > 0 {
 			return scheduleActionReplace
 		}
 
@@ -633,6 +1674,71 @@ func (r *PipelineRunner) resolveScheduleAction(pipeline string, ignoreStartDelay
 	return scheduleActionStart
 }
 
+func (r *PipelineRunner) canStartJobs() bool {
+	if r.isHandingOff {
+		return false
+	}
+	for _, gate := range r.startGates {
+		if canStart, _ := gate.CanStart(); !canStart {
+			return false
+		}
+	}
+	return true
+}
+
+// UseStartGate adds a gate that can defer the start of jobs.
+//
+// Jobs that could not be started because the gate was closed are kept on the wait list. The wait lists are
+// checked again every recheckInterval until the context is done.
+func (r *PipelineRunner) UseStartGate(ctx context.Context, gate StartGate, recheckInterval time.Duration) {
+	r.mx.Lock()
+	r.startGates = append(r.startGates, gate)
+	r.mx.Unlock()
+
+	go r.recheckWaitLists(ctx, recheckInterval)
+}
+
+// recheckWaitLists starts jobs on the wait lists every interval until the context is done, for conditions that
+// change without an event of the runner
+func (r *PipelineRunner) recheckWaitLists(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.mx.Lock()
+			if !r.isShuttingDown {
+				for pipeline := range r.waitListByPipeline {
+					r.startJobsOnWaitList(pipeline)
+				}
+			}
+			r.mx.Unlock()
+		}
+	}
+}
+
+func (r *PipelineRunner) checkScheduleGates() (bool, string) {
+	for _, gate := range r.scheduleGates {
+		if canStart, reason := gate.CanStart(); !canStart {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+// UseScheduleGate adds a gate that refuses scheduling new jobs while it is closed.
+//
+// Use UseStartGate with the same gate to also defer the start of jobs that are already on the wait list.
+func (r *PipelineRunner) UseScheduleGate(gate StartGate) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.scheduleGates = append(r.scheduleGates, gate)
+}
+
 func (r *PipelineRunner) resolveDequeueJobAction(job *PipelineJob) scheduleAction {
 	// Start the job if it had a start delay but the timer finished
 	ignoreStartDelay := job.StartDelay > 0 && job.startTimer == nil
@@ -640,6 +1746,13 @@ func (r *PipelineRunner) resolveDequeueJobAction(job *PipelineJob) scheduleActio
 }
 
 func (r *PipelineRunner) isSchedulable(pipeline string) bool {
+	if canSchedule, _ := r.checkScheduleGates(); !canSchedule {
+		return false
+	}
+	if _, exceeded := r.exceededQuota(pipeline, definition.QuotaActionReject); exceeded {
+		return false
+	}
+
 	action := r.resolveScheduleAction(pipeline, false)
 	switch action {
 	case scheduleActionReplace:
@@ -657,10 +1770,82 @@ func (r *PipelineRunner) isSchedulable(pipeline string) bool {
 type ScheduleOpts struct {
 	Variables map[string]interface{}
 	User      string
+	// Priority of the job on the wait list (defaults to normal)
+	Priority JobPriority
+	// Labels are arbitrary metadata of the job (e.g. a commit SHA or ticket id)
+	Labels map[string]string
+	// CorrelationID is an ID for tracing the job across systems (e.g. the request ID of the caller)
+	CorrelationID string
+	// Trigger is how the job was scheduled (e.g. TriggerAPI)
+	Trigger string
+	// RequeuedFrom is the id of the canceled job that is requeued (see RequeueJob)
+	RequeuedFrom *uuid.UUID
+	// ClonedFrom is the id of the job that is cloned (see CloneJob)
+	ClonedFrom *uuid.UUID
+}
+
+const (
+	// TriggerAPI is the trigger of jobs scheduled via the HTTP API
+	TriggerAPI = "api"
+	// TriggerRedis is the trigger of jobs scheduled from a Redis list
+	TriggerRedis = "redis"
+)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_./-]*$`)
+
+// validateLabels checks that label names can be used in label selectors
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) {
+			return errors.Errorf("invalid label name %q: must start with a letter or digit and only contain letters, digits, _, ., / and -", name)
+		}
+	}
+	return nil
+}
+
+// JobPriority controls the position of a job on the wait list, jobs with a higher priority are started first
+type JobPriority int
+
+const (
+	// JobPriorityNormal appends the job to the wait list
+	JobPriorityNormal JobPriority = 0
+	// JobPriorityHigh adds the job before all jobs with normal priority on the wait list
+	JobPriorityHigh JobPriority = 1
+)
+
+// ParseJobPriority parses a priority name ("normal" or "high"), an empty string is the normal priority
+func ParseJobPriority(name string) (JobPriority, error) {
+	switch name {
+	case "", "normal":
+		return JobPriorityNormal, nil
+	case "high":
+		return JobPriorityHigh, nil
+	}
+	return JobPriorityNormal, errors.Errorf("unknown priority: %q", name)
+}
+
+func (p JobPriority) String() string {
+	if p == JobPriorityHigh {
+		return "high"
+	}
+	return "normal"
+}
+
+// insertByPriority inserts the job on the wait list after all jobs with the same or a higher priority
+func insertByPriority(waitList []*PipelineJob, job *PipelineJob) []*PipelineJob {
+	for i, queuedJob := range waitList {
+		if queuedJob.Priority < job.Priority {
+			waitList = append(waitList, nil)
+			copy(waitList[i+1:], waitList[i:])
+			waitList[i] = job
+			return waitList
+		}
+	}
+	return append(waitList, job)
 }
 
 func (r *PipelineRunner) initialLoadFromStore() error {
-	log.
+	r.logger.
 		WithField("component", "runner").
 		Debug("Loading state from store")
 
@@ -669,11 +1854,26 @@ func (r *PipelineRunner) initialLoadFromStore() error {
 		return errors.Wrap(err, "loading data")
 	}
 
+	var handedOffJobs []*PipelineJob
+
 	for _, pJob := range data.Jobs {
 		job := buildJobFromPersistedJob(pJob)
 
 		// Cancel job with tasks if it appears to be still running (which it cannot if we initialize from the store)
 		if job.isRunning() {
+			// Processes of the job could still be running if prunner crashed, so we kill them
+			for _, p := range job.Processes {
+				if taskctl.KillOrphanedProcess(p) {
+					r.logger.
+						WithField("component", "runner").
+						WithFields(job.logFields()).
+						WithField("pipeline", job.Pipeline).
+						WithField("pid", p.Pid).
+						Warnf("Killed orphaned process of job when restoring state")
+				}
+			}
+			job.Processes = nil
+
 			for i := range job.Tasks {
 				jt := &job.Tasks[i]
 				if jt.Status == "waiting" || jt.Status == "running" {
@@ -681,31 +1881,38 @@ func (r *PipelineRunner) initialLoadFromStore() error {
 				}
 			}
 
-			// TODO Maybe add a new "Incomplete" flag?
-			job.Canceled = true
+			job.Incomplete = true
+			r.markChanged(job)
 
-			log.
+			r.logger.
 				WithField("component", "runner").
-				WithField("jobID", job.ID).
+				WithFields(job.logFields()).
 				WithField("pipeline", job.Pipeline).
-				Warnf("Found running job when restoring state, marked as canceled")
+				Warnf("Found running job when restoring state, marked as incomplete")
 		}
 
-		// Cancel jobs which have been scheduled on wait list but never been started or canceled
+		// Cancel jobs which have been scheduled on wait list but never been started or canceled, unless a previous
+		// instance handed off its state (they are queued again, see ResumeWaitList)
 		if job.Start == nil && !job.Canceled {
-			job.Canceled = true
+			if _, defined := r.defs.Pipelines[job.Pipeline]; data.HandedOff && defined {
+				handedOffJobs = append(handedOffJobs, job)
+			} else {
+				job.Canceled = true
+				r.markChanged(job)
 
-			log.
-				WithField("component", "runner").
-				WithField("jobID", job.ID).
-				WithField("pipeline", job.Pipeline).
-				Warnf("Found job on wait list when restoring state, marked as canceled")
+				r.logger.
+					WithField("component", "runner").
+					WithFields(job.logFields()).
+					WithField("pipeline", job.Pipeline).
+					Warnf("Found job on wait list when restoring state, marked as canceled")
+			}
 		}
 
-		r.jobsByID[pJob.ID] = job
-		r.jobsByPipeline[pJob.Pipeline] = append(r.jobsByPipeline[pJob.Pipeline], job)
+		r.addJob(job)
 	}
 
+	r.queueHandedOffJobs(handedOffJobs)
+
 	return nil
 }
 
@@ -713,102 +1920,244 @@ func (r *PipelineRunner) SaveToStore() {
 	r.wg.Add(1)
 	defer r.wg.Done()
 
-	log.
+	r.saveMx.Lock()
+	defer r.saveMx.Unlock()
+
+	r.logger.
 		WithField("component", "runner").
 		Debugf("Saving job state to data store")
 
-	r.mx.RLock()
-	data := &store.PersistedData{
-		Jobs: make([]store.PersistedJob, 0, len(r.jobsByID)),
+	r.mx.Lock()
+
+	// The state was handed off to a new instance which is now responsible for saving
+	if r.isHandedOff {
+		r.mx.Unlock()
+		return
 	}
 
 	// Remove jobs whose retention period has expired
-	for _, jobsInPipeline := range r.jobsByPipeline {
-		// Make a copy of the slice before sorting to prevent data races (we only have a read lock here)
-		sortedJobsInPipeline := make([]*PipelineJob, len(jobsInPipeline))
-		copy(sortedJobsInPipeline, jobsInPipeline)
-		pipelineJobBy(byCreationTimeDesc).Sort(sortedJobsInPipeline)
-
+	retainedJobsByPipeline := r.retainedJobsByPipeline()
+	for pipeline, sortedJobsInPipeline := range retainedJobsByPipeline {
+		retained := sortedJobsInPipeline[:0:0]
 		for i, job := range sortedJobsInPipeline {
 			shouldRemoveJob, removalReason := r.determineIfJobShouldBeRemoved(i, job)
+			if !shouldRemoveJob {
+				retained = append(retained, job)
+			}
 
 			if shouldRemoveJob {
-				delete(r.jobsByID, job.ID)
-				r.jobsByPipeline[job.Pipeline] = removeJobFromList(r.jobsByPipeline[job.Pipeline], job)
+				r.purgeJob(job, removalReason)
+			}
+		}
+		retainedJobsByPipeline[pipeline] = retained
+	}
+	r.enforceLogQuotas(retainedJobsByPipeline)
+
+	// Only changed and removed jobs are saved if the store supports it, until the saved changes exceed the number of
+	// jobs (then a full save is cheaper on load and compacts the changes)
+	incrementalStore, incremental := r.store.(store.IncrementalDataStore)
+	compactionDue := r.journaledChanges > len(r.jobsByID)+len(r.evictedJobs) && r.journaledChanges > minJournaledChanges
+	// Evicted jobs are not in memory, so the store compacts the changes itself
+	evicting := r.isEvicting()
+	fullSave := !incremental || (!evicting && (r.fullSaveRequired || compactionDue))
+
+	var (
+		data    *store.PersistedData
+		changed []store.PersistedJob
+		removed []uuid.UUID
+	)
+	if fullSave {
+		data = r.persistedData()
+	} else {
+		changedJobs := r.changedJobs
+		// All jobs in memory are saved again after a failed save if evicted jobs prevent a full save
+		if r.fullSaveRequired {
+			changedJobs = r.jobsByID
+		}
+		changed = make([]store.PersistedJob, 0, len(changedJobs))
+		for _, job := range changedJobs {
+			changed = append(changed, persistedJob(job))
+		}
+		removed = r.removedJobs
+	}
+	r.changedJobs = make(map[uuid.UUID]*PipelineJob)
+	r.removedJobs = nil
+	r.mx.Unlock()
 
-				err := r.outputStore.Remove(job.ID.String())
-				if err != nil {
-					log.
-						WithField("component", "runner").
-						WithField("jobID", job.ID.String()).
-						WithField("pipeline", job.Pipeline).
-						WithField("removalReason", removalReason).
-						WithError(err).
-						Errorf("Failed to remove logs from output store for job")
-				}
+	// We do not need to lock here, saveMx guarantees non-concurrent saves
 
-				log.
-					WithField("component", "runner").
-					WithField("jobID", job.ID.String()).
-					WithField("pipeline", job.Pipeline).
-					WithField("removalReason", removalReason).
-					Infof("Removing job")
+	var err error
+	if fullSave {
+		err = r.store.Save(data)
+		r.journaledChanges = 0
+	} else {
+		err = incrementalStore.SaveChanges(changed, removed)
+		r.journaledChanges += len(changed) + len(removed)
+		if err == nil && evicting && compactionDue {
+			err = r.store.(store.LazyDataStore).Compact()
+			if err == nil {
+				r.journaledChanges = 0
 			}
 		}
 	}
+	// Changes that could not be saved are only contained in the next full save
+	r.fullSaveRequired = err != nil
+	if err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithError(err).
+			Errorf("Error saving job state to data store")
+		return
+	}
+
+	// Jobs can only be evicted after they were saved
+	if evicting {
+		r.mx.Lock()
+		r.evictJobs()
+		r.mx.Unlock()
+	}
+}
+
+// minJournaledChanges is the minimum number of incrementally saved changes before a full save
+const minJournaledChanges = 100
+
+// markChanged marks a job to be saved by the next incremental save, it must be called with a lock
+func (r *PipelineRunner) markChanged(job *PipelineJob) {
+	r.changedJobs[job.ID] = job
+	r.stateVersion++
+}
+
+// StateVersion returns an opaque version of the job state that changes whenever a job is changed or removed (e.g. to
+// detect if a job listing changed)
+func (r *PipelineRunner) StateVersion() string {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	return fmt.Sprintf("%x-%d", r.stateEpoch, r.stateVersion)
+}
+
+// Snapshot returns a consistent copy of the job state in the on-disk representation (e.g. for backups)
+func (r *PipelineRunner) Snapshot() (*store.PersistedData, error) {
+	r.mx.RLock()
+	evicted := len(r.evictedJobs) > 0
+	r.mx.RUnlock()
+	if evicted {
+		return r.snapshotWithEvictedJobs()
+	}
+
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	return r.persistedData(), nil
+}
+
+// persistedData converts in-memory data to the on-disk representation, it must be called with a lock
+func (r *PipelineRunner) persistedData() *store.PersistedData {
+	data := &store.PersistedData{
+		Jobs: make([]store.PersistedJob, 0, len(r.jobsByID)),
+	}
 
-	// convert in-memory data to the on-disk representation
 	for _, job := range r.jobsByID {
-		tasks := make([]store.PersistedTask, len(job.Tasks))
-		for i, t := range job.Tasks {
-			tasks[i] = store.PersistedTask{
-				Name:         t.Name,
-				Script:       t.Script,
-				DependsOn:    t.DependsOn,
-				AllowFailure: t.AllowFailure,
-				Status:       t.Status,
-				Start:        t.Start,
-				End:          t.End,
-				Skipped:      t.Skipped,
-				ExitCode:     t.ExitCode,
-				Errored:      t.Errored,
-				Error:        helper.ErrToStrPtr(t.Error),
-			}
-		}
+		data.Jobs = append(data.Jobs, persistedJob(job))
+	}
+
+	return data
+}
 
-		data.Jobs = append(data.Jobs, store.PersistedJob{
-			ID:        job.ID,
-			Pipeline:  job.Pipeline,
-			Completed: job.Completed,
-			Canceled:  job.Canceled,
-			Created:   job.Created,
-			Start:     job.Start,
-			End:       job.End,
-			Tasks:     tasks,
-			Variables: job.Variables,
-			User:      job.User,
+// persistedJob converts a job to the on-disk representation, it must be called with a lock
+func persistedJob(job *PipelineJob) store.PersistedJob {
+	var processes []store.PersistedProcess
+	for _, p := range job.Processes {
+		processes = append(processes, store.PersistedProcess{
+			Pid:       p.Pid,
+			StartTime: p.StartTime,
+			BootID:    p.BootID,
 		})
 	}
-	r.mx.RUnlock()
 
-	// We do not need to lock here, the single save loops guarantees non-concurrent saves
+	tasks := make([]store.PersistedTask, len(job.Tasks))
+	for i, t := range job.Tasks {
+		tasks[i] = store.PersistedTask{
+			Name:            t.Name,
+			Script:          t.Script,
+			DependsOn:       t.DependsOn,
+			AllowFailure:    t.AllowFailure,
+			When:            t.When,
+			ChangedPaths:    persistedChangedPaths(t.ChangedPaths),
+			Context:         t.Context,
+			Env:             t.Env,
+			Interpreter:     int(t.Interpreter),
+			Dir:             t.Dir,
+			Timeout:         t.Timeout,
+			Retries:         t.Retries,
+			Agent:           t.Agent,
+			NoOutputTimeout: t.NoOutputTimeout,
+			NoOutputAction:  int(t.NoOutputAction),
+			Interactive:     t.Interactive,
+			Status:          t.Status,
+			Start:           t.Start,
+			End:             t.End,
+			Skipped:         t.Skipped,
+			ExitCode:        t.ExitCode,
+			Errored:         t.Errored,
+			Error:           helper.ErrToStrPtr(t.Error),
+			UserTime:        t.ResourceUsage.UserTime,
+			SystemTime:      t.ResourceUsage.SystemTime,
+			MaxRSS:          t.ResourceUsage.MaxRSS,
+
+			EnvSnapshot:       t.EnvSnapshot,
+			VariablesSnapshot: t.VariablesSnapshot,
+			Commands:          persistedCommands(t.Commands),
+		}
+	}
 
-	err := r.store.Save(data)
-	if err != nil {
-		log.
-			WithField("component", "runner").
-			WithError(err).
-			Errorf("Error saving job state to data store")
+	return store.PersistedJob{
+		ID:               job.ID,
+		Pipeline:         job.Pipeline,
+		Completed:        job.Completed,
+		Canceled:         job.Canceled,
+		Incomplete:       job.Incomplete,
+		Expired:          job.Expired,
+		Created:          job.Created,
+		Start:            job.Start,
+		End:              job.End,
+		Tasks:            tasks,
+		Variables:        job.Variables,
+		User:             job.User,
+		Priority:         int(job.Priority),
+		Labels:           job.Labels,
+		CorrelationID:    job.CorrelationID,
+		Trigger:          job.Trigger,
+		Env:              job.Env,
+		PriorityClass:    int(job.PriorityClass),
+		Interpreter:      int(job.Interpreter),
+		Weight:           job.Weight,
+		Timeout:          job.Timeout,
+		Git:              persistedGitCheckout(job.Git),
+		GitCommit:        job.GitCommit,
+		LogsPruned:       job.LogsPruned,
+		Rehydrated:       job.Rehydrated,
+		RequeuedFrom:     job.RequeuedFrom,
+		ClonedFrom:       job.ClonedFrom,
+		Annotations:      persistedAnnotations(job.Annotations),
+		SLABreached:      job.SLABreached,
+		ExpectedDuration: job.ExpectedDuration,
+		SlowAfter:        job.SlowAfter,
+		KeepTmpOnFailure: job.KeepTmpOnFailure,
+		Contexts:         persistedContexts(job.Contexts),
+		Services:         persistedServices(job.Services),
+		Processes:        processes,
 	}
 }
 
 func (r *PipelineRunner) Shutdown(ctx context.Context) error {
 	defer func() {
-		log.
+		r.logger.
 			WithField("component", "runner").
 			Debugf("Shutting down, waiting for pending operations...")
 		// Wait for all running jobs to have called JobCompleted
 		r.wg.Wait()
+		r.sched.Finish()
 
 		// Do a final save to include the state of recently completed jobs
 		r.SaveToStore()
@@ -820,9 +2169,10 @@ func (r *PipelineRunner) Shutdown(ctx context.Context) error {
 	for pipelineName, jobs := range r.waitListByPipeline {
 		for _, job := range jobs {
 			job.Canceled = true
-			log.
+			r.publishJobCompleted(job)
+			r.logger.
 				WithField("component", "runner").
-				WithField("jobID", job.ID).
+				WithFields(job.logFields()).
 				WithField("pipeline", pipelineName).
 				Warnf("Shutting down, marking job on wait list as canceled")
 		}
@@ -837,7 +2187,7 @@ func (r *PipelineRunner) Shutdown(ctx context.Context) error {
 		for pipelineName := range r.jobsByPipeline {
 			if r.isRunning(pipelineName) {
 				hasRunningPipelines = true
-				log.
+				r.logger.
 					WithField("component", "runner").
 					WithField("pipeline", pipelineName).
 					Debugf("Shutting down, waiting for pipeline to finish...")
@@ -846,7 +2196,7 @@ func (r *PipelineRunner) Shutdown(ctx context.Context) error {
 		r.mx.RUnlock()
 
 		if !hasRunningPipelines {
-			log.
+			r.logger.
 				WithField("component", "runner").
 				Debugf("Shutting down, all pipelines finished")
 			break
@@ -856,7 +2206,7 @@ func (r *PipelineRunner) Shutdown(ctx context.Context) error {
 		select {
 		case <-time.After(r.ShutdownPollInterval):
 		case <-ctx.Done():
-			log.
+			r.logger.
 				WithField("component", "runner").
 				Warnf("Forced shutdown, cancelling all jobs")
 
@@ -874,23 +2224,64 @@ func (r *PipelineRunner) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-// taken from https://stackoverflow.com/a/37335777
-func removeJobFromList(jobs []*PipelineJob, jobToRemove *PipelineJob) []*PipelineJob {
-	for index, job := range jobs {
-		if job.ID == jobToRemove.ID {
-			// taken from https://stackoverflow.com/a/37335777
-			jobs[index] = jobs[len(jobs)-1]
-			return jobs[:len(jobs)-1]
-		}
+// addJob adds the job to the lists and index of jobs in memory, it must be called with a lock
+func (r *PipelineRunner) addJob(job *PipelineJob) {
+	r.jobsByID[job.ID] = job
+	r.jobsByPipeline[job.Pipeline] = r.jobsByPipeline[job.Pipeline].insert(job)
+	r.jobsByCreation = r.jobsByCreation.insert(job)
+	r.jobIndex.add(job)
+}
+
+// removeJob removes the job from the lists and index of jobs in memory, it must be called with a lock
+func (r *PipelineRunner) removeJob(job *PipelineJob) {
+	delete(r.jobsByID, job.ID)
+	r.jobsByPipeline[job.Pipeline] = r.jobsByPipeline[job.Pipeline].remove(job)
+	r.jobsByCreation = r.jobsByCreation.remove(job)
+	r.jobIndex.remove(job)
+}
+
+// purgeJob removes a finished job from memory and its logs from the output store, the removal is saved with the next
+// save. It must be called with the lock held.
+func (r *PipelineRunner) purgeJob(job *PipelineJob, removalReason string) {
+	if _, evicted := r.evictedJobs[job.ID]; evicted {
+		delete(r.evictedJobs, job.ID)
+		r.evictedJobsByPipeline[job.Pipeline] = r.evictedJobsByPipeline[job.Pipeline].remove(job)
+	} else {
+		r.removeJob(job)
+		delete(r.changedJobs, job.ID)
+	}
+	r.removedJobs = append(r.removedJobs, job.ID)
+	r.stateVersion++
+	delete(r.logSizes, job.ID)
+
+	err := r.outputStore.Remove(job.ID.String())
+	if err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithField("pipeline", job.Pipeline).
+			WithField("removalReason", removalReason).
+			WithError(err).
+			Errorf("Failed to remove logs from output store for job")
 	}
 
-	// not found, we return the full list
-	return jobs
+	r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", job.Pipeline).
+		WithField("removalReason", removalReason).
+		Infof("Removing job")
 }
 
 // determineIfJobShouldBeRemoved implements the retention period handling.
 func (r *PipelineRunner) determineIfJobShouldBeRemoved(index int, job *PipelineJob) (bool, string) {
-	pipelineDef, pipelineDefExists := r.defs.Pipelines[job.Pipeline]
+	return determineIfJobShouldBeRemoved(r.defs, r.now(), index, job)
+}
+
+// determineIfJobShouldBeRemoved checks the retention settings of the pipeline, index is the position of the job in the
+// jobs of the pipeline sorted by creation time (newest first)
+func determineIfJobShouldBeRemoved(defs *definition.PipelinesDef, now time.Time, index int, job *PipelineJob) (bool, string) {
+	pipelineDef, pipelineDefExists := defs.Pipelines[job.Pipeline]
 	if !pipelineDefExists {
 		return true, "Pipeline definition not found"
 	}
@@ -900,16 +2291,21 @@ func (r *PipelineRunner) determineIfJobShouldBeRemoved(index int, job *PipelineJ
 		return false, "Keeping job on wait list"
 	}
 
-	if !job.Completed && !job.Canceled {
+	if !job.Completed && !job.Canceled && !job.Incomplete {
 		// always keep jobs which are not yet in some "finished" state
 		return false, "Keeping non-finished job"
 	}
 
-	if pipelineDef.RetentionPeriod > 0 && time.Since(job.Created) > pipelineDef.RetentionPeriod {
+	// Rehydrated jobs are retained as if they were created when they were restored from the archive
+	created := job.Created
+	if job.Rehydrated != nil {
+		created = *job.Rehydrated
+	}
+	if pipelineDef.RetentionPeriod > 0 && now.Sub(created) > pipelineDef.RetentionPeriod {
 		return true, fmt.Sprintf("Retention period of %s reached", pipelineDef.RetentionPeriod.String())
 	}
 
-	if pipelineDef.RetentionCount > 0 && index >= pipelineDef.RetentionCount {
+	if pipelineDef.RetentionCount > 0 && index >= pipelineDef.RetentionCount && job.Rehydrated == nil {
 		return true, fmt.Sprintf("Retention count of %d reached", pipelineDef.RetentionCount)
 	}
 
@@ -925,6 +2321,54 @@ func (r *PipelineRunner) requestPersist() {
 	}
 }
 
+// ErrJobNotFinished is returned if a job must be finished for an operation, but it is queued or running
+var ErrJobNotFinished = errors.New("job is not finished")
+
+// DeleteJob removes a finished job with its logs and temporary directory, e.g. if it captured sensitive data by
+// accident. Queued and running jobs must be canceled first, the removal is persisted with the next save.
+func (r *PipelineRunner) DeleteJob(id uuid.UUID) error {
+	r.mx.Lock()
+
+	job, ok := r.jobsByID[id]
+	if !ok {
+		job, ok = r.evictedJobs[id]
+	}
+	if !ok {
+		r.mx.Unlock()
+		return ErrJobNotFound
+	}
+	if !job.isFinished() {
+		r.mx.Unlock()
+		return ErrJobNotFinished
+	}
+
+	// Canceled jobs stay on the wait list until they would be started
+	waitList := r.waitListByPipeline[job.Pipeline]
+	for i, queuedJob := range waitList {
+		if queuedJob == job {
+			r.waitListByPipeline[job.Pipeline] = append(waitList[:i:i], waitList[i+1:]...)
+			break
+		}
+	}
+
+	r.purgeJob(job, "Deleted")
+	r.mx.Unlock()
+
+	// A kept temporary directory can contain the same data as the logs
+	if err := os.RemoveAll(job.tmpDir()); err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithField("pipeline", job.Pipeline).
+			WithError(err).
+			Warn("Failed to remove temporary directory")
+	}
+
+	r.requestPersist()
+
+	return nil
+}
+
 func (r *PipelineRunner) CancelJob(id uuid.UUID) error {
 	r.mx.Lock()
 	defer r.mx.Unlock()
@@ -932,14 +2376,109 @@ func (r *PipelineRunner) CancelJob(id uuid.UUID) error {
 	return r.cancelJobInternal(id)
 }
 
+// replaceRunningJob cancels the oldest running job of a pipeline with queue strategy replace_running after the grace
+// period, so the queued job can start. It must be called with the lock held.
+func (r *PipelineRunner) replaceRunningJob(pipeline string) {
+	pipelineDef := r.defs.Pipelines[pipeline]
+	if pipelineDef.QueueStrategy != definition.QueueStrategyReplaceRunning {
+		return
+	}
+	// Only jobs that exceed the concurrency are replaced (not jobs that wait for a start delay or a start gate), running
+	// jobs of a drained pipeline are allowed to finish
+	if r.runningJobsCount(pipeline) < pipelineDef.Concurrency || r.drainedPipelines[pipeline] {
+		return
+	}
+
+	var runningJob *PipelineJob
+	for _, job := range r.jobsByPipeline[pipeline] {
+		if job.Start != nil && job.cancelFunc != nil && (job.Canceled || job.replaceTimer != nil) {
+			// A job is already being replaced, the queued job starts when it is finished
+			return
+		}
+		if job.isRunning() && (runningJob == nil || job.Start.Before(*runningJob.Start)) {
+			runningJob = job
+		}
+	}
+	if runningJob == nil {
+		return
+	}
+
+	log := r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		WithFields(runningJob.logFields())
+
+	if pipelineDef.ReplaceGracePeriod == 0 {
+		_ = r.cancelJobInternal(runningJob.ID)
+		log.Debugf("Replaced: canceled running job")
+		return
+	}
+
+	id := runningJob.ID
+	runningJob.replaceTimer = time.AfterFunc(pipelineDef.ReplaceGracePeriod, func() {
+		r.mx.Lock()
+		defer r.mx.Unlock()
+
+		job, ok := r.jobsByID[id]
+		if !ok || job.replaceTimer == nil {
+			return
+		}
+		job.replaceTimer = nil
+
+		// The queued job could have been canceled in the meantime, then the running job continues
+		if !job.isRunning() || !r.hasQueuedJobs(job.Pipeline) {
+			return
+		}
+		_ = r.cancelJobInternal(id)
+		log.Debugf("Replaced: canceled running job after grace period")
+	})
+	log.Debugf("Replacing: canceling running job after grace period of %s", pipelineDef.ReplaceGracePeriod)
+}
+
+// CancelPipelineJobs cancels all queued jobs of the pipeline and also running jobs if includeRunning is set. It returns
+// the ids of the canceled jobs, running jobs are canceled asynchronously.
+func (r *PipelineRunner) CancelPipelineJobs(pipeline string, includeRunning bool) ([]uuid.UUID, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if _, ok := r.defs.Pipelines[pipeline]; !ok {
+		return nil, ErrPipelineNotDefined
+	}
+
+	canceled := []uuid.UUID{}
+	for _, job := range r.jobsByPipeline[pipeline] {
+		queued := job.Start == nil && !job.Canceled && !job.Completed && !job.Incomplete
+		if !queued && !(includeRunning && job.isRunning()) {
+			continue
+		}
+		err := r.cancelJobInternal(job.ID)
+		if err != nil {
+			return canceled, err
+		}
+		canceled = append(canceled, job.ID)
+	}
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		WithField("includeRunning", includeRunning).
+		WithField("canceled", len(canceled)).
+		Debugf("Canceled jobs of pipeline")
+
+	return canceled, nil
+}
+
 func (r *PipelineRunner) cancelJobInternal(id uuid.UUID) error {
 	job, ok := r.jobsByID[id]
+	if !ok {
+		job, ok = r.evictedJobs[id]
+	}
 	if !ok {
 		return ErrJobNotFound
 	}
 
-	if job.Canceled {
-		// Canceling an already canceled job is not an error
+	if job.Canceled || job.Incomplete {
+		// Canceling an already canceled job (or a job that cannot run anymore) is not an error
 		return nil
 	}
 
@@ -949,40 +2488,34 @@ func (r *PipelineRunner) cancelJobInternal(id uuid.UUID) error {
 
 	if job.Start == nil {
 		job.markAsCanceled()
+		r.publishJobCompleted(job)
 
-		log.
+		r.logger.
 			WithField("component", "runner").
 			WithField("pipeline", job.Pipeline).
-			WithField("jobID", job.ID).
+			WithFields(job.logFields()).
 			Debugf("Marked job as canceled, since it was not started")
 
-		r.requestPersist()
-
 		return nil
 	}
 
-	log.
+	r.logger.
 		WithField("component", "runner").
 		WithField("pipeline", job.Pipeline).
-		WithField("jobID", job.ID).
+		WithFields(job.logFields()).
 		Debugf("Canceling job")
 
-	if job.sched == nil {
-		log.
+	if job.cancelFunc == nil {
+		r.logger.
 			WithField("component", "runner").
 			WithField("pipeline", job.Pipeline).
-			WithField("jobID", job.ID).
-			Warnf("Failed assertion: scheduler of job is nil")
+			WithFields(job.logFields()).
+			Warnf("Failed assertion: context of job is nil")
 		return nil
 	}
 
-	cancelFunc := job.sched.Cancel
-
-	r.wg.Add(1)
-	go (func() {
-		cancelFunc()
-		r.wg.Done()
-	})()
+	// The scheduler stops the tasks of the job and calls JobCompleted asynchronously
+	job.cancelFunc()
 
 	return nil
 }
@@ -993,7 +2526,7 @@ func (r *PipelineRunner) StartDelayedJob(id uuid.UUID) {
 
 	job, ok := r.jobsByID[id]
 	if !ok {
-		log.
+		r.logger.
 			WithField("component", "runner").
 			WithField("jobID", id).
 			Error("Failed to find job to start after delay")
@@ -1023,17 +2556,99 @@ func (r *PipelineRunner) ReplaceDefinitions(defs *definition.PipelinesDef) {
 	r.defs = defs
 }
 
+func persistedChangedPaths(changedPaths *definition.ChangedPathsDef) *store.PersistedChangedPaths {
+	if changedPaths == nil {
+		return nil
+	}
+	return &store.PersistedChangedPaths{Base: changedPaths.Base, Paths: changedPaths.Paths}
+}
+
+func restoredChangedPaths(changedPaths *store.PersistedChangedPaths) *definition.ChangedPathsDef {
+	if changedPaths == nil {
+		return nil
+	}
+	return &definition.ChangedPathsDef{Base: changedPaths.Base, Paths: changedPaths.Paths}
+}
+
+func persistedCommands(commands []jobTaskCommand) []store.PersistedCommand {
+	if len(commands) == 0 {
+		return nil
+	}
+	result := make([]store.PersistedCommand, len(commands))
+	for i, c := range commands {
+		result[i] = store.PersistedCommand{
+			Command:      c.Command,
+			Start:        c.Start,
+			End:          c.End,
+			ExitCode:     c.ExitCode,
+			Error:        helper.ErrToStrPtr(c.Error),
+			StdoutOffset: c.StdoutOffset,
+			StdoutLength: c.StdoutLength,
+			StderrOffset: c.StderrOffset,
+			StderrLength: c.StderrLength,
+		}
+	}
+	return result
+}
+
+func restoredCommands(commands []store.PersistedCommand) []jobTaskCommand {
+	if len(commands) == 0 {
+		return nil
+	}
+	result := make([]jobTaskCommand, len(commands))
+	for i, c := range commands {
+		result[i] = jobTaskCommand{
+			Command:      c.Command,
+			Start:        c.Start,
+			End:          c.End,
+			ExitCode:     c.ExitCode,
+			Error:        helper.StrPtrToErr(c.Error),
+			StdoutOffset: c.StdoutOffset,
+			StdoutLength: c.StdoutLength,
+			StderrOffset: c.StderrOffset,
+			StderrLength: c.StderrLength,
+		}
+	}
+	return result
+}
+
 func buildJobFromPersistedJob(pJob store.PersistedJob) *PipelineJob {
 	job := &PipelineJob{
-		ID:        pJob.ID,
-		Pipeline:  pJob.Pipeline,
-		Completed: pJob.Completed,
-		Canceled:  pJob.Canceled,
-		Created:   pJob.Created,
-		Start:     pJob.Start,
-		End:       pJob.End,
-		Variables: pJob.Variables,
-		User:      pJob.User,
+		ID:         pJob.ID,
+		Pipeline:   pJob.Pipeline,
+		Completed:  pJob.Completed,
+		Canceled:   pJob.Canceled,
+		Incomplete: pJob.Incomplete,
+		Expired:    pJob.Expired,
+		Created:    pJob.Created,
+		Start:      pJob.Start,
+		End:        pJob.End,
+		Variables:  pJob.Variables,
+		User:       pJob.User,
+		Priority:   JobPriority(pJob.Priority),
+		Labels:     pJob.Labels,
+		Env:        pJob.Env,
+
+		CorrelationID: pJob.CorrelationID,
+		Trigger:       pJob.Trigger,
+		// The definition of the pipeline could have changed, so the snapshot of the job is restored
+		PriorityClass:    definition.PriorityClass(pJob.PriorityClass),
+		Interpreter:      definition.Interpreter(pJob.Interpreter),
+		Weight:           pJob.Weight,
+		Timeout:          pJob.Timeout,
+		Git:              restoredGitCheckout(pJob.Git),
+		GitCommit:        pJob.GitCommit,
+		LogsPruned:       pJob.LogsPruned,
+		Rehydrated:       pJob.Rehydrated,
+		RequeuedFrom:     pJob.RequeuedFrom,
+		ClonedFrom:       pJob.ClonedFrom,
+		Annotations:      restoredAnnotations(pJob.Annotations),
+		SLABreached:      pJob.SLABreached,
+		ExpectedDuration: pJob.ExpectedDuration,
+		SlowAfter:        pJob.SlowAfter,
+		KeepTmpOnFailure: pJob.KeepTmpOnFailure,
+		Contexts:         restoredContexts(pJob.Contexts),
+		Services:         restoredServices(pJob.Services),
 	}
 
 	tasks := make(jobTasks, len(pJob.Tasks))
@@ -1041,9 +2656,21 @@ func buildJobFromPersistedJob(pJob store.PersistedJob) *PipelineJob {
 		tasks[i] = jobTask{
 			Name: pJobTask.Name,
 			TaskDef: definition.TaskDef{
-				Script:       pJobTask.Script,
-				DependsOn:    pJobTask.DependsOn,
-				AllowFailure: pJobTask.AllowFailure,
+				Script:          pJobTask.Script,
+				DependsOn:       pJobTask.DependsOn,
+				AllowFailure:    pJobTask.AllowFailure,
+				When:            pJobTask.When,
+				ChangedPaths:    restoredChangedPaths(pJobTask.ChangedPaths),
+				Context:         pJobTask.Context,
+				Env:             pJobTask.Env,
+				Interpreter:     definition.Interpreter(pJobTask.Interpreter),
+				Dir:             pJobTask.Dir,
+				Timeout:         pJobTask.Timeout,
+				Retries:         pJobTask.Retries,
+				Agent:           pJobTask.Agent,
+				NoOutputTimeout: pJobTask.NoOutputTimeout,
+				NoOutputAction:  definition.NoOutputAction(pJobTask.NoOutputAction),
+				Interactive:     pJobTask.Interactive,
 			},
 			Status:   pJobTask.Status,
 			Start:    pJobTask.Start,
@@ -1052,9 +2679,26 @@ func buildJobFromPersistedJob(pJob store.PersistedJob) *PipelineJob {
 			ExitCode: pJobTask.ExitCode,
 			Errored:  pJobTask.Errored,
 			Error:    helper.StrPtrToErr(pJobTask.Error),
+			ResourceUsage: taskctl.ResourceUsage{
+				UserTime:   pJobTask.UserTime,
+				SystemTime: pJobTask.SystemTime,
+				MaxRSS:     pJobTask.MaxRSS,
+			},
+			EnvSnapshot:       pJobTask.EnvSnapshot,
+			VariablesSnapshot: pJobTask.VariablesSnapshot,
+			Commands:          restoredCommands(pJobTask.Commands),
 		}
 	}
 	job.Tasks = tasks
+	job.DefinitionHash = job.Definition().Hash()
+
+	for _, pProcess := range pJob.Processes {
+		job.Processes = append(job.Processes, taskctl.Process{
+			Pid:       pProcess.Pid,
+			StartTime: pProcess.StartTime,
+			BootID:    pProcess.BootID,
+		})
+	}
 
 	return job
 }
@@ -0,0 +1,300 @@
+package prunner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+// AlertKind is the condition of an alert
+type AlertKind string
+
+const (
+	// AlertQueueFull is raised if the queue limit of a pipeline is reached for at least alerts.queue_full_for
+	AlertQueueFull AlertKind = "queue_full"
+	// AlertNoSuccess is raised if no job of a pipeline succeeded for alerts.no_success_for
+	AlertNoSuccess AlertKind = "no_success"
+	// AlertSLABreached is raised while a job of a pipeline is running longer than alerts.sla
+	AlertSLABreached AlertKind = "sla_breached"
+	// AlertFailureRate is raised if the share of failed jobs of the last alerts.failure_rate.runs finished jobs exceeds
+	// alerts.failure_rate.percent
+	AlertFailureRate AlertKind = "failure_rate"
+)
+
+// Alert is an alert configured for a pipeline with its current state
+type Alert struct {
+	Pipeline string
+	Kind     AlertKind
+	// Active is set if the condition of the alert is met
+	Active bool
+	// Since is the time the condition is met since (only set if the alert is active)
+	Since *time.Time
+	// Message describes the condition of an active alert
+	Message string
+}
+
+// AlertHook is called when an alert is raised (Active is set) or resolved
+type AlertHook func(alert Alert)
+
+type alertKey struct {
+	pipeline string
+	kind     AlertKind
+}
+
+// UseAlerts checks the alerts of all pipelines every checkInterval until the context is done. The hooks are called
+// for raised and resolved alerts in the order they were given.
+func (r *PipelineRunner) UseAlerts(ctx context.Context, checkInterval time.Duration, hooks ...AlertHook) {
+	r.mx.Lock()
+	r.alertHooks = append(r.alertHooks, hooks...)
+	if r.alertsWatched {
+		r.mx.Unlock()
+		return
+	}
+	r.alertsWatched = true
+	// Pipelines without a successful job in memory are measured from now, so a restart does not raise alerts
+	r.alertsSince = r.now()
+	r.mx.Unlock()
+
+	go func() {
+		t := time.NewTicker(checkInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				r.CheckAlerts()
+			}
+		}
+	}()
+}
+
+// Alerts returns the alerts of all pipelines ordered by pipeline and kind with the state of the last check
+func (r *PipelineRunner) Alerts() []Alert {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	alerts := []Alert{}
+	for pipeline, pipelineDef := range r.defs.Pipelines {
+		for _, kind := range configuredAlerts(pipelineDef.Alerts) {
+			alert, active := r.activeAlerts[alertKey{pipeline: pipeline, kind: kind}]
+			if !active {
+				alert = Alert{Pipeline: pipeline, Kind: kind}
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Pipeline != alerts[j].Pipeline {
+			return alerts[i].Pipeline < alerts[j].Pipeline
+		}
+		return alerts[i].Kind < alerts[j].Kind
+	})
+	return alerts
+}
+
+// CheckAlerts evaluates the alerts of all pipelines and calls the alert hooks for alerts that were raised or resolved
+func (r *PipelineRunner) CheckAlerts() {
+	r.mx.Lock()
+	now := r.now()
+	if r.activeAlerts == nil {
+		r.activeAlerts = make(map[alertKey]Alert)
+		r.queueFullSince = make(map[string]time.Time)
+	}
+	if r.alertsSince.IsZero() {
+		r.alertsSince = now
+	}
+
+	var changed []Alert
+	current := make(map[alertKey]Alert)
+	for pipeline, pipelineDef := range r.defs.Pipelines {
+		for _, alert := range r.evaluateAlerts(pipeline, now) {
+			current[alertKey{pipeline: pipeline, kind: alert.Kind}] = alert
+		}
+		if pipelineDef.QueueLimit == nil || r.queuedJobsCount(pipeline) < *pipelineDef.QueueLimit {
+			delete(r.queueFullSince, pipeline)
+		}
+	}
+	for key, alert := range current {
+		if _, active := r.activeAlerts[key]; !active {
+			changed = append(changed, alert)
+		}
+	}
+	for key, alert := range r.activeAlerts {
+		if _, active := current[key]; !active {
+			alert.Active = false
+			alert.Since = nil
+			alert.Message = ""
+			changed = append(changed, alert)
+		}
+	}
+	r.activeAlerts = current
+	hooks := r.alertHooks
+	r.mx.Unlock()
+
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].Pipeline != changed[j].Pipeline {
+			return changed[i].Pipeline < changed[j].Pipeline
+		}
+		return changed[i].Kind < changed[j].Kind
+	})
+	for _, alert := range changed {
+		logger := r.logger.
+			WithField("component", "runner").
+			WithField("pipeline", alert.Pipeline).
+			WithField("alert", alert.Kind)
+		if alert.Active {
+			logger.Warnf("Alert raised: %s", alert.Message)
+		} else {
+			logger.Info("Alert resolved")
+		}
+		for _, hook := range hooks {
+			hook(alert)
+		}
+	}
+}
+
+// evaluateAlerts returns the active alerts of a pipeline, it must be called with the lock held
+func (r *PipelineRunner) evaluateAlerts(pipeline string, now time.Time) []Alert {
+	pipelineDef := r.defs.Pipelines[pipeline]
+	var alerts []Alert
+
+	if pipelineDef.Alerts.QueueFullFor > 0 && pipelineDef.QueueLimit != nil {
+		if r.queuedJobsCount(pipeline) >= *pipelineDef.QueueLimit {
+			since, ok := r.queueFullSince[pipeline]
+			if !ok {
+				since = now
+				r.queueFullSince[pipeline] = since
+			}
+			if now.Sub(since) >= pipelineDef.Alerts.QueueFullFor {
+				alerts = append(alerts, Alert{
+					Pipeline: pipeline,
+					Kind:     AlertQueueFull,
+					Active:   true,
+					Since:    &since,
+					Message:  fmt.Sprintf("queue limit of %d reached for %s", *pipelineDef.QueueLimit, now.Sub(since).Round(time.Second)),
+				})
+			}
+		}
+	}
+
+	if pipelineDef.Alerts.NoSuccessFor > 0 {
+		since := r.alertsSince
+		lastSuccess := r.lastSuccess(pipeline)
+		if lastSuccess != nil {
+			since = *lastSuccess
+		}
+		if now.Sub(since) >= pipelineDef.Alerts.NoSuccessFor {
+			message := fmt.Sprintf("no successful job for %s", now.Sub(since).Round(time.Second))
+			if lastSuccess == nil {
+				message = fmt.Sprintf("no successful job since %s", since.Format(time.RFC3339))
+			}
+			alerts = append(alerts, Alert{
+				Pipeline: pipeline,
+				Kind:     AlertNoSuccess,
+				Active:   true,
+				Since:    &since,
+				Message:  message,
+			})
+		}
+	}
+
+	if pipelineDef.Alerts.SLA > 0 {
+		if alert := r.slaAlert(pipeline, pipelineDef.Alerts.SLA); alert != nil {
+			alerts = append(alerts, *alert)
+		}
+	}
+
+	if pipelineDef.Alerts.FailureRate.Runs > 0 {
+		if alert := r.failureRateAlert(pipeline, pipelineDef.Alerts.FailureRate); alert != nil {
+			alerts = append(alerts, *alert)
+		}
+	}
+
+	return alerts
+}
+
+// failureRateAlert returns the alert if the failure rate of the last finished jobs of the pipeline exceeds the
+// threshold (nil otherwise or if fewer jobs finished), it must be called with the lock held. Canceled jobs are not
+// counted.
+func (r *PipelineRunner) failureRateAlert(pipeline string, failureRate definition.FailureRateDef) *Alert {
+	jobs := r.newestJobs(pipeline, failureRate.Runs, func(job *PipelineJob) bool {
+		return job.Completed && !job.Canceled && job.End != nil
+	})
+	if len(jobs) < failureRate.Runs {
+		return nil
+	}
+
+	failed := 0
+	for _, job := range jobs {
+		if job.LastError != nil {
+			failed++
+		}
+	}
+	percent := float64(failed) * 100 / float64(len(jobs))
+	if percent <= failureRate.Percent {
+		return nil
+	}
+
+	since := *jobs[0].End
+	return &Alert{
+		Pipeline: pipeline,
+		Kind:     AlertFailureRate,
+		Active:   true,
+		Since:    &since,
+		Message:  fmt.Sprintf("%d of the last %d jobs failed (%.0f%%, threshold %g%%)", failed, len(jobs), percent, failureRate.Percent),
+	}
+}
+
+// queuedJobsCount returns the number of jobs on the wait list that were not canceled, it must be called with the lock
+// held
+func (r *PipelineRunner) queuedJobsCount(pipeline string) int {
+	count := 0
+	for _, job := range r.waitListByPipeline[pipeline] {
+		if !job.Canceled {
+			count++
+		}
+	}
+	return count
+}
+
+// lastSuccess returns the end of the newest job of the pipeline that completed without an error, it must be called
+// with the lock held
+func (r *PipelineRunner) lastSuccess(pipeline string) *time.Time {
+	var last *time.Time
+	for _, jobs := range []jobList{r.jobsByPipeline[pipeline], r.evictedJobsByPipeline[pipeline]} {
+		for i := len(jobs) - 1; i >= 0; i-- {
+			job := jobs[i]
+			if !job.Completed || job.Canceled || job.LastError != nil || job.End == nil {
+				continue
+			}
+			if last == nil || job.End.After(*last) {
+				last = job.End
+			}
+			// Jobs are ordered by creation, so older jobs of the same list usually ended before
+			break
+		}
+	}
+	return last
+}
+
+func configuredAlerts(alertsDef definition.AlertsDef) []AlertKind {
+	var kinds []AlertKind
+	if alertsDef.NoSuccessFor > 0 {
+		kinds = append(kinds, AlertNoSuccess)
+	}
+	if alertsDef.QueueFullFor > 0 {
+		kinds = append(kinds, AlertQueueFull)
+	}
+	if alertsDef.SLA > 0 {
+		kinds = append(kinds, AlertSLABreached)
+	}
+	if alertsDef.FailureRate.Runs > 0 {
+		kinds = append(kinds, AlertFailureRate)
+	}
+	return kinds
+}
@@ -0,0 +1,92 @@
+package prunner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/store"
+)
+
+// maxAnnotationLength limits the text of an annotation in characters, annotations are notes and not a place for logs
+const maxAnnotationLength = 4000
+
+// ErrInvalidAnnotation is returned if the text of an annotation is empty or too long
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+// JobAnnotation is a free-text note attached to a job after it was scheduled (e.g. why a failure can be ignored)
+type JobAnnotation struct {
+	Text    string
+	User    string
+	Created time.Time
+}
+
+// AnnotateJob attaches a note to a job in any state and returns the annotation. Annotations are saved with the job.
+func (r *PipelineRunner) AnnotateJob(id uuid.UUID, user, text string) (JobAnnotation, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return JobAnnotation{}, fmt.Errorf("%w: text must not be empty", ErrInvalidAnnotation)
+	}
+	if utf8.RuneCountInString(text) > maxAnnotationLength {
+		return JobAnnotation{}, fmt.Errorf("%w: text must be at most %d characters long", ErrInvalidAnnotation, maxAnnotationLength)
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	job, ok := r.jobsByID[id]
+	if !ok {
+		if _, evicted := r.evictedJobs[id]; !evicted {
+			return JobAnnotation{}, ErrJobNotFound
+		}
+		var err error
+		job, err = r.unevictJob(id)
+		if err != nil {
+			return JobAnnotation{}, err
+		}
+	}
+
+	annotation := JobAnnotation{
+		Text:    text,
+		User:    user,
+		Created: r.now(),
+	}
+	job.Annotations = append(job.Annotations, annotation)
+	r.markChanged(job)
+	r.requestPersist()
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", job.Pipeline).
+		WithFields(job.logFields()).
+		WithField("user", user).
+		Debugf("Annotated job")
+
+	return annotation, nil
+}
+
+func persistedAnnotations(annotations []JobAnnotation) []store.PersistedAnnotation {
+	if len(annotations) == 0 {
+		return nil
+	}
+	result := make([]store.PersistedAnnotation, len(annotations))
+	for i, a := range annotations {
+		result[i] = store.PersistedAnnotation{Text: a.Text, User: a.User, Created: a.Created}
+	}
+	return result
+}
+
+func restoredAnnotations(annotations []store.PersistedAnnotation) []JobAnnotation {
+	if len(annotations) == 0 {
+		return nil
+	}
+	result := make([]JobAnnotation, len(annotations))
+	for i, a := range annotations {
+		result[i] = JobAnnotation{Text: a.Text, User: a.User, Created: a.Created}
+	}
+	return result
+}
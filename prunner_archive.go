@@ -0,0 +1,199 @@
+package prunner
+
+import (
+	"context"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/archive"
+	"github.com/Flowpack/prunner/store"
+)
+
+// ErrNoArchive is returned if archived jobs are accessed, but no archive is configured
+var ErrNoArchive = errors.New("no archive configured")
+
+// UseArchive moves finished jobs older than after (measured from the end of the job) with their logs to the archive
+// every checkInterval until the context is done. Archived jobs are removed from the data store and can be restored
+// with RehydrateJob.
+func (r *PipelineRunner) UseArchive(ctx context.Context, a *archive.Archive, after, checkInterval time.Duration) {
+	r.mx.Lock()
+	r.archive = a
+	r.archiveAfter = after
+	r.mx.Unlock()
+
+	go func() {
+		t := time.NewTicker(checkInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				_, err := r.ArchiveJobs(ctx)
+				if err != nil {
+					r.logger.
+						WithField("component", "runner").
+						WithError(err).
+						Error("Failed to archive jobs")
+				}
+			}
+		}
+	}()
+}
+
+// archiveReferenceTime returns the time the age of a job for archiving is measured from
+func (j *PipelineJob) archiveReferenceTime() time.Time {
+	t := j.Created
+	if j.End != nil {
+		t = *j.End
+	}
+	// A rehydrated job is not archived again right away
+	if j.Rehydrated != nil && j.Rehydrated.After(t) {
+		t = *j.Rehydrated
+	}
+	return t
+}
+
+// ArchiveJobs moves all finished jobs that are due for archiving to the archive and returns the number of archived
+// jobs. Jobs that could not be archived stay in the data store and are tried again with the next call.
+func (r *PipelineRunner) ArchiveJobs(ctx context.Context) (int, error) {
+	r.mx.RLock()
+	a := r.archive
+	if a == nil {
+		r.mx.RUnlock()
+		return 0, ErrNoArchive
+	}
+	threshold := r.now().Add(-r.archiveAfter)
+	var dueJobs []*PipelineJob
+	for _, jobsInPipeline := range r.retainedJobsByPipeline() {
+		for _, job := range jobsInPipeline {
+			if job.isFinished() && job.archiveReferenceTime().Before(threshold) {
+				dueJobs = append(dueJobs, job)
+			}
+		}
+	}
+	r.mx.RUnlock()
+
+	archived := 0
+	for _, dueJob := range dueJobs {
+		if err := ctx.Err(); err != nil {
+			return archived, err
+		}
+
+		var pJob store.PersistedJob
+		err := r.ReadJob(dueJob.ID, func(j *PipelineJob) {
+			pJob = persistedJob(j)
+		})
+		if errors.Is(err, ErrJobNotFound) {
+			continue
+		} else if err != nil {
+			return archived, errors.Wrapf(err, "reading job %s", dueJob.ID)
+		}
+
+		err = a.Put(ctx, pJob, r.outputStore)
+		if err != nil {
+			return archived, errors.Wrapf(err, "archiving job %s", dueJob.ID)
+		}
+
+		r.mx.Lock()
+		job, ok := r.jobsByID[dueJob.ID]
+		if !ok {
+			job, ok = r.evictedJobs[dueJob.ID]
+		}
+		if ok && job.isFinished() {
+			r.purgeJob(job, "Archived")
+			archived++
+		}
+		r.mx.Unlock()
+
+		// The job was removed while it was archived, so it must not be restored
+		if !ok {
+			err = a.Delete(ctx, dueJob.ID)
+			if err != nil {
+				return archived, errors.Wrapf(err, "removing job %s from archive", dueJob.ID)
+			}
+		}
+	}
+
+	if archived > 0 {
+		r.requestPersist()
+
+		r.logger.
+			WithField("component", "runner").
+			WithField("archivedJobs", archived).
+			Info("Archived jobs")
+	}
+
+	return archived, nil
+}
+
+// ArchivedJobs returns all jobs in the archive, the newest archived job first
+func (r *PipelineRunner) ArchivedJobs(ctx context.Context) ([]archive.Entry, error) {
+	r.mx.RLock()
+	a := r.archive
+	r.mx.RUnlock()
+
+	if a == nil {
+		return nil, ErrNoArchive
+	}
+	return a.List(ctx)
+}
+
+// RehydrateJob restores an archived job with its logs and removes it from the archive. The job is retained like a new
+// job from the time it was restored. ErrJobNotFound is returned if the job is not archived.
+func (r *PipelineRunner) RehydrateJob(ctx context.Context, id uuid.UUID) error {
+	r.mx.RLock()
+	a := r.archive
+	_, inMemory := r.jobsByID[id]
+	_, evicted := r.evictedJobs[id]
+	r.mx.RUnlock()
+
+	if a == nil {
+		return ErrNoArchive
+	}
+	// The job was already restored
+	if inMemory || evicted {
+		return nil
+	}
+
+	pJob, err := a.Restore(ctx, id, r.outputStore)
+	if errors.Is(err, archive.ErrNotFound) {
+		return ErrJobNotFound
+	} else if err != nil {
+		return errors.Wrap(err, "restoring job from archive")
+	}
+
+	job := buildJobFromPersistedJob(*pJob)
+
+	r.mx.Lock()
+	if _, exists := r.jobsByID[id]; !exists {
+		now := r.now()
+		job.Rehydrated = &now
+		r.addJob(job)
+		r.markChanged(job)
+	}
+	r.mx.Unlock()
+
+	r.requestPersist()
+
+	r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", job.Pipeline).
+		Info("Rehydrated job from archive")
+
+	// The job is saved in the data store with the next save, a failed removal only leaves a copy in the archive
+	err = a.Delete(ctx, id)
+	if err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithError(err).
+			Warn("Failed to remove rehydrated job from archive")
+	}
+
+	return nil
+}
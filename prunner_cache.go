@@ -0,0 +1,146 @@
+package prunner
+
+import (
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/store"
+)
+
+// isEvicting returns true if jobs are evicted from memory (see WithMaxCachedJobs)
+func (r *PipelineRunner) isEvicting() bool {
+	_, lazy := r.store.(store.LazyDataStore)
+	return lazy && r.maxCachedJobs > 0
+}
+
+// evictJobs evicts the oldest finished jobs from memory until at most maxCachedJobs jobs are left, it must be called
+// with a lock after the job state was saved. Jobs on the wait list or running jobs are never evicted.
+func (r *PipelineRunner) evictJobs() {
+	if len(r.jobsByID) <= r.maxCachedJobs {
+		return
+	}
+
+	n := len(r.jobsByID) - r.maxCachedJobs
+	var evicted []*PipelineJob
+	for _, job := range r.jobsByCreation {
+		if len(evicted) == n {
+			break
+		}
+		// Changed jobs are not saved yet
+		if _, changed := r.changedJobs[job.ID]; changed || !job.isFinished() {
+			continue
+		}
+		evicted = append(evicted, job)
+	}
+	for _, job := range evicted {
+		r.removeJob(job)
+		stub := job.evictedStub()
+		r.evictedJobs[job.ID] = stub
+		r.evictedJobsByPipeline[job.Pipeline] = r.evictedJobsByPipeline[job.Pipeline].insert(stub)
+	}
+
+	if len(evicted) > 0 {
+		r.logger.
+			WithField("component", "runner").
+			WithField("evictedJobs", len(evicted)).
+			Debugf("Evicted jobs from memory")
+	}
+}
+
+// evictedStub returns a copy of the job with only the fields needed for retention and the job state
+func (j *PipelineJob) evictedStub() *PipelineJob {
+	return &PipelineJob{
+		ID:         j.ID,
+		Pipeline:   j.Pipeline,
+		Completed:  j.Completed,
+		Canceled:   j.Canceled,
+		Incomplete: j.Incomplete,
+		Expired:    j.Expired,
+		Created:    j.Created,
+		Start:      j.Start,
+		End:        j.End,
+		// Rehydrated is kept for retention and archiving
+		Rehydrated: j.Rehydrated,
+		// The error is kept to find the last successful job (see lastSuccess)
+		LastError: j.LastError,
+	}
+}
+
+// readEvictedJob loads an evicted job from the store and calls process in a read lock
+func (r *PipelineRunner) readEvictedJob(id uuid.UUID, process func(j *PipelineJob)) error {
+	// Jobs are only evicted if the store can load them
+	pJob, err := r.store.(store.LazyDataStore).LoadJob(id)
+	if err != nil {
+		return errors.Wrap(err, "loading evicted job")
+	}
+	// The job could have been removed in the meantime
+	if pJob == nil {
+		return ErrJobNotFound
+	}
+
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	process(buildJobFromPersistedJob(*pJob))
+
+	return nil
+}
+
+// unevictJob loads an evicted job from the store back into memory, so it can be changed. It must be called with the
+// lock held, the job is evicted again after it was saved.
+func (r *PipelineRunner) unevictJob(id uuid.UUID) (*PipelineJob, error) {
+	stub := r.evictedJobs[id]
+	pJob, err := r.store.(store.LazyDataStore).LoadJob(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading evicted job")
+	}
+	if pJob == nil {
+		return nil, ErrJobNotFound
+	}
+
+	delete(r.evictedJobs, id)
+	r.evictedJobsByPipeline[stub.Pipeline] = r.evictedJobsByPipeline[stub.Pipeline].remove(stub)
+	job := buildJobFromPersistedJob(*pJob)
+	r.addJob(job)
+
+	return job, nil
+}
+
+// retainedJobsByPipeline returns all jobs including evicted jobs by pipeline ordered by creation time (newest first)
+// for the retention handling, it must be called with a lock
+func (r *PipelineRunner) retainedJobsByPipeline() map[string][]*PipelineJob {
+	result := make(map[string][]*PipelineJob, len(r.jobsByPipeline))
+	for pipeline, jobsInPipeline := range r.jobsByPipeline {
+		result[pipeline] = mergeJobLists(jobsInPipeline, r.evictedJobsByPipeline[pipeline])
+	}
+	for pipeline, evictedJobsInPipeline := range r.evictedJobsByPipeline {
+		if _, exists := result[pipeline]; !exists {
+			result[pipeline] = mergeJobLists(nil, evictedJobsInPipeline)
+		}
+	}
+	return result
+}
+
+// snapshotWithEvictedJobs returns the job state including evicted jobs, which are loaded from the store
+func (r *PipelineRunner) snapshotWithEvictedJobs() (*store.PersistedData, error) {
+	// Prevent concurrent saves, so the stored data is consistent with the evicted jobs
+	r.saveMx.Lock()
+	defer r.saveMx.Unlock()
+
+	storedData, err := r.store.Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "loading evicted jobs")
+	}
+
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	data := r.persistedData()
+	for _, pJob := range storedData.Jobs {
+		if _, evicted := r.evictedJobs[pJob.ID]; evicted {
+			data.Jobs = append(data.Jobs, pJob)
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,36 @@
+package prunner
+
+import (
+	"github.com/gofrs/uuid"
+)
+
+// CloneJob schedules a new job of the same pipeline with the variables, priority and labels of an existing job in any
+// state (e.g. to run a deployment again), the new job references the original job with ClonedFrom. The user,
+// correlation ID and trigger of the new job are taken from opts.
+func (r *PipelineRunner) CloneJob(id uuid.UUID, opts ScheduleOpts) (*PipelineJob, error) {
+	var pipeline string
+	err := r.ReadJob(id, func(j *PipelineJob) {
+		pipeline = j.Pipeline
+		opts.Variables = j.Variables
+		opts.Priority = j.Priority
+		opts.Labels = j.Labels
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts.ClonedFrom = &id
+
+	job, err := r.ScheduleAsync(pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		WithFields(job.logFields()).
+		WithField("clonedFrom", id).
+		Debugf("Cloned: scheduled job with inputs of existing job")
+
+	return job, nil
+}
@@ -0,0 +1,108 @@
+package prunner
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// CompactResult describes the entries removed by Compact
+type CompactResult struct {
+	// RemovedJobs is the number of jobs removed by retention settings or because their pipeline is not defined anymore
+	RemovedJobs int
+	// RemovedOrphanedLogs is the number of log directories of jobs that do not exist in the store
+	RemovedOrphanedLogs int
+}
+
+// Compact rewrites the persisted job data without jobs that exceed the retention settings of their pipeline and
+// removes their logs. If the output store can list jobs (see taskctl.JobLister), logs of jobs that are not in the
+// store anymore are removed as well.
+//
+// It must not be used on a store that is used by a running PipelineRunner, since the runner would overwrite the data.
+func Compact(defs *definition.PipelinesDef, dataStore store.DataStore, outputStore taskctl.OutputStore) (CompactResult, error) {
+	var result CompactResult
+
+	data, err := dataStore.Load()
+	if err != nil {
+		return result, errors.Wrap(err, "loading data")
+	}
+
+	jobsByPipeline := make(map[string][]*PipelineJob)
+	persistedJobsByID := make(map[string]store.PersistedJob, len(data.Jobs))
+	for _, pJob := range data.Jobs {
+		job := buildJobFromPersistedJob(pJob)
+		jobsByPipeline[job.Pipeline] = append(jobsByPipeline[job.Pipeline], job)
+		persistedJobsByID[job.ID.String()] = pJob
+	}
+
+	for _, jobsInPipeline := range jobsByPipeline {
+		pipelineJobBy(byCreationTimeDesc).Sort(jobsInPipeline)
+
+		for i, job := range jobsInPipeline {
+			shouldRemoveJob, removalReason := determineIfJobShouldBeRemoved(defs, time.Now(), i, job)
+			if !shouldRemoveJob {
+				continue
+			}
+
+			delete(persistedJobsByID, job.ID.String())
+			result.RemovedJobs++
+
+			err := outputStore.Remove(job.ID.String())
+			if err != nil {
+				return result, errors.Wrapf(err, "removing logs of job %s", job.ID)
+			}
+
+			log.
+				WithField("component", "compact").
+				WithField("jobID", job.ID.String()).
+				WithField("pipeline", job.Pipeline).
+				WithField("removalReason", removalReason).
+				Infof("Removing job")
+		}
+	}
+
+	if lister, ok := outputStore.(taskctl.JobLister); ok {
+		jobIDs, err := lister.JobIDs()
+		if err != nil {
+			return result, errors.Wrap(err, "listing logs")
+		}
+		for _, jobID := range jobIDs {
+			if _, exists := persistedJobsByID[jobID]; exists {
+				continue
+			}
+
+			err := outputStore.Remove(jobID)
+			if err != nil {
+				return result, errors.Wrapf(err, "removing orphaned logs of job %s", jobID)
+			}
+			result.RemovedOrphanedLogs++
+
+			log.
+				WithField("component", "compact").
+				WithField("jobID", jobID).
+				Infof("Removing orphaned logs")
+		}
+	}
+
+	// Keep the order of jobs in the store
+	compactedData := &store.PersistedData{
+		Jobs: make([]store.PersistedJob, 0, len(persistedJobsByID)),
+	}
+	for _, pJob := range data.Jobs {
+		if _, exists := persistedJobsByID[pJob.ID.String()]; exists {
+			compactedData.Jobs = append(compactedData.Jobs, pJob)
+		}
+	}
+
+	err = dataStore.Save(compactedData)
+	if err != nil {
+		return result, errors.Wrap(err, "saving data")
+	}
+
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package prunner
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// composeDownTimeout limits the time for stopping the services of a job, it does not use the context of the job since
+// that is already canceled if the job was canceled or timed out
+const composeDownTimeout = 2 * time.Minute
+
+// TaskContexts returns the docker compose projects of the job by task name, tasks using the same context share a
+// project. A compose file with a relative path is resolved against the git checkout of the job.
+func (j *PipelineJob) TaskContexts() map[string]*taskctl.ComposeProject {
+	projects := make(map[string]*taskctl.ComposeProject)
+	byContext := make(map[string]*taskctl.ComposeProject)
+	for _, t := range j.Tasks {
+		if t.Context == "" {
+			continue
+		}
+		contextDef, exists := j.Contexts[t.Context]
+		if !exists || contextDef.Compose == nil {
+			continue
+		}
+		project, exists := byContext[t.Context]
+		if !exists {
+			file := contextDef.Compose.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(j.checkoutDir(), file)
+			}
+			project = &taskctl.ComposeProject{
+				// The job ID makes the project unique, so concurrent jobs use their own containers and networks
+				Name:     "prunner-" + j.ID.String() + "-" + t.Context,
+				File:     file,
+				Services: contextDef.Compose.Services,
+			}
+			byContext[t.Context] = project
+		}
+		projects[t.Name] = project
+	}
+	return projects
+}
+
+// stopContexts stops the services of all contexts that were started by tasks of the job
+func (r *PipelineRunner) stopContexts(ctx context.Context, job *PipelineJob) {
+	projects := taskctl.JobOptionsFromContext(ctx).Contexts
+	if len(projects) == 0 {
+		return
+	}
+
+	downCtx, cancel := context.WithTimeout(context.Background(), composeDownTimeout)
+	defer cancel()
+
+	logger := r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", job.Pipeline)
+	stopped := make(map[*taskctl.ComposeProject]bool)
+	for _, project := range projects {
+		if stopped[project] {
+			continue
+		}
+		stopped[project] = true
+
+		var output bytes.Buffer
+		if err := project.Down(downCtx, &output, &output); err != nil {
+			logger.WithError(err).Warnf("Failed to stop services of %s", project.Name)
+			continue
+		}
+		logger.WithField("output", output.String()).Debugf("Stopped services of %s", project.Name)
+	}
+}
+
+func persistedContexts(contexts map[string]definition.ContextDef) map[string]store.PersistedContext {
+	if len(contexts) == 0 {
+		return nil
+	}
+	result := make(map[string]store.PersistedContext, len(contexts))
+	for name, contextDef := range contexts {
+		if contextDef.Compose == nil {
+			continue
+		}
+		result[name] = store.PersistedContext{
+			ComposeFile:     contextDef.Compose.File,
+			ComposeServices: contextDef.Compose.Services,
+		}
+	}
+	return result
+}
+
+func restoredContexts(contexts map[string]store.PersistedContext) map[string]definition.ContextDef {
+	if len(contexts) == 0 {
+		return nil
+	}
+	result := make(map[string]definition.ContextDef, len(contexts))
+	for name, persistedContext := range contexts {
+		result[name] = definition.ContextDef{
+			Compose: &definition.ComposeDef{
+				File:     persistedContext.ComposeFile,
+				Services: persistedContext.ComposeServices,
+			},
+		}
+	}
+	return result
+}
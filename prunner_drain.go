@@ -0,0 +1,32 @@
+package prunner
+
+// DrainPipeline stops starting jobs from the wait list of the pipeline if drained is set: running jobs finish and new
+// jobs are queued. Undraining the pipeline starts the queued jobs again. The drained state is not persisted.
+func (r *PipelineRunner) DrainPipeline(pipeline string, drained bool) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if _, ok := r.defs.Pipelines[pipeline]; !ok {
+		return ErrPipelineNotDefined
+	}
+	if r.drainedPipelines[pipeline] == drained {
+		return nil
+	}
+
+	if drained {
+		r.drainedPipelines[pipeline] = true
+	} else {
+		delete(r.drainedPipelines, pipeline)
+	}
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		WithField("drained", drained).
+		Info("Changed drained state of pipeline")
+
+	if !drained && !r.isShuttingDown {
+		r.startJobsOnWaitList(pipeline)
+	}
+
+	return nil
+}
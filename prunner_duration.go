@@ -0,0 +1,115 @@
+package prunner
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// durationSampleSize is the number of the newest successful jobs of a pipeline the duration statistics are
+	// computed from
+	durationSampleSize = 50
+	// minDurationSamples is the number of successful jobs needed for an ETA and for detecting unusually slow jobs
+	minDurationSamples = 5
+	// slowJobMargin is the margin above the 95th percentile of durations a job must exceed to be unusually slow
+	slowJobMargin = 0.25
+)
+
+// DurationStats are rolling statistics of the durations of the newest successful jobs of a pipeline
+type DurationStats struct {
+	// Samples is the number of jobs the statistics are computed from
+	Samples int
+	Median  time.Duration
+	P95     time.Duration
+}
+
+// durationStats computes the duration statistics of the pipeline from the newest successful jobs including evicted
+// jobs, it must be called with the lock held
+func (r *PipelineRunner) durationStats(pipeline string) DurationStats {
+	samples := r.newestJobs(pipeline, durationSampleSize, func(job *PipelineJob) bool {
+		return job.Completed && !job.Canceled && job.LastError == nil && job.Start != nil && job.End != nil
+	})
+	if len(samples) == 0 {
+		return DurationStats{}
+	}
+
+	durations := make([]time.Duration, len(samples))
+	for i, job := range samples {
+		durations[i] = job.End.Sub(*job.Start)
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	return DurationStats{
+		Samples: len(durations),
+		Median:  percentile(durations, 50),
+		P95:     percentile(durations, 95),
+	}
+}
+
+// newestJobs returns up to n of the newest jobs of the pipeline including evicted jobs that match the filter (newest
+// first), it must be called with the lock held
+func (r *PipelineRunner) newestJobs(pipeline string, n int, filter func(job *PipelineJob) bool) []*PipelineJob {
+	var result []*PipelineJob
+	for _, jobs := range []jobList{r.jobsByPipeline[pipeline], r.evictedJobsByPipeline[pipeline]} {
+		matched := 0
+		for i := len(jobs) - 1; i >= 0 && matched < n; i-- {
+			if filter(jobs[i]) {
+				result = append(result, jobs[i])
+				matched++
+			}
+		}
+	}
+
+	// Both lists contribute their newest jobs, only the newest of all are returned
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// applyDurationStats sets the expected duration and the threshold for unusually slow runs when the job starts, it
+// must be called with the lock held
+func (r *PipelineRunner) applyDurationStats(job *PipelineJob) {
+	stats := r.durationStats(job.Pipeline)
+	if stats.Samples < minDurationSamples {
+		return
+	}
+	job.ExpectedDuration = stats.Median
+	job.SlowAfter = stats.P95 + time.Duration(float64(stats.P95)*slowJobMargin)
+}
+
+// ETA returns the expected end of a running job based on the durations of previous jobs (nil if the job is not running
+// or not enough jobs succeeded before)
+func (j *PipelineJob) ETA() *time.Time {
+	if !j.isRunning() || j.ExpectedDuration == 0 {
+		return nil
+	}
+	eta := j.Start.Add(j.ExpectedDuration)
+	return &eta
+}
+
+// IsUnusuallySlow returns true if the job runs (or ran) longer than the 95th percentile of durations of previous jobs
+// plus a margin
+func (j *PipelineJob) IsUnusuallySlow(now time.Time) bool {
+	if j.SlowAfter == 0 || j.Start == nil {
+		return false
+	}
+	end := now
+	if j.End != nil {
+		end = *j.End
+	}
+	return end.Sub(*j.Start) > j.SlowAfter
+}
@@ -0,0 +1,72 @@
+package prunner
+
+import (
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// Environment variables that are set for all tasks and services of a job (see taskEnv), values of the pipeline env
+// with the same name are overridden
+const (
+	// JobIDEnvName is the environment variable with the ID of the job
+	JobIDEnvName = "PRUNNER_JOB_ID"
+	// PipelineEnvName is the environment variable with the name of the pipeline of the job
+	PipelineEnvName = "PRUNNER_PIPELINE"
+	// UserEnvName is the environment variable with the user that scheduled the job, it is only set if the job has a user
+	UserEnvName = "PRUNNER_USER"
+	// TriggerEnvName is the environment variable with how the job was scheduled (e.g. api), it is only set if the job
+	// has a trigger
+	TriggerEnvName = "PRUNNER_TRIGGER"
+	// CorrelationIDEnvName is the environment variable with the correlation ID of the job, it is only set if the job
+	// has a correlation ID
+	CorrelationIDEnvName = "PRUNNER_CORRELATION_ID"
+	// TaskEnvName is the environment variable with the name of the task, it is only set for tasks
+	TaskEnvName = taskctl.TaskEnvName
+)
+
+// taskEnv returns the environment of the pipeline for tasks and services of the job including the standard PRUNNER_*
+// variables of the job
+func (j *PipelineJob) taskEnv() map[string]string {
+	env := make(map[string]string, len(j.Env)+6)
+	for name, value := range j.Env {
+		env[name] = value
+	}
+	env[JobIDEnvName] = j.ID.String()
+	env[PipelineEnvName] = j.Pipeline
+	env[TmpDirEnvName] = j.tmpDir()
+	for name, value := range map[string]string{
+		UserEnvName:          j.User,
+		TriggerEnvName:       j.Trigger,
+		CorrelationIDEnvName: j.CorrelationID,
+	} {
+		if value != "" {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// envTemplateData returns the data for templates in env values of tasks: .job with fields of the job and .vars with the
+// job variables
+func (j *PipelineJob) envTemplateData() map[string]interface{} {
+	labels := make(map[string]interface{}, len(j.Labels))
+	for name, value := range j.Labels {
+		labels[name] = value
+	}
+	vars := make(map[string]interface{}, len(j.Variables))
+	for name, value := range j.Variables {
+		vars[name] = value
+	}
+
+	return map[string]interface{}{
+		"job": map[string]interface{}{
+			"id":            j.ID.String(),
+			"pipeline":      j.Pipeline,
+			"user":          j.User,
+			"trigger":       j.Trigger,
+			"correlationId": j.CorrelationID,
+			"created":       j.Created,
+			"labels":        labels,
+		},
+		"vars": vars,
+	}
+}
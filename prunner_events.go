@@ -0,0 +1,148 @@
+package prunner
+
+import (
+	"github.com/gofrs/uuid"
+)
+
+// Event is published by the runner when the state of a job changes (see Subscribe).
+//
+// Events are one of JobScheduled, JobStarted, TaskStarted, TaskFinished, TaskStatusChanged, JobChanged or
+// JobCompleted.
+type Event interface {
+	jobEvent() JobEvent
+}
+
+// JobEvent contains the fields that all events have in common
+type JobEvent struct {
+	JobID    uuid.UUID
+	Pipeline string
+}
+
+func (e JobEvent) jobEvent() JobEvent {
+	return e
+}
+
+// JobScheduled is published when a job was created, Queued is set if the job was added to the wait list
+type JobScheduled struct {
+	JobEvent
+	Queued bool
+}
+
+// JobStarted is published when the tasks of a job are started
+type JobStarted struct {
+	JobEvent
+}
+
+// TaskStarted is published when a task of a job was started
+type TaskStarted struct {
+	JobEvent
+	Task string
+}
+
+// TaskFinished is published when a task of a job has finished (or was skipped)
+type TaskFinished struct {
+	JobEvent
+	Task     string
+	Skipped  bool
+	Errored  bool
+	ExitCode int16
+}
+
+// TaskStatusChanged is published when the status of a task changed (e.g. from waiting to running)
+type TaskStatusChanged struct {
+	JobEvent
+	Task   string
+	Status string
+}
+
+// JobChanged is published for other changes of a job that are persisted (e.g. a process of a task exited)
+type JobChanged struct {
+	JobEvent
+}
+
+// JobCompleted is published when a job is finished (completed, failed or canceled). Job is a copy of the job, so it
+// can safely be referenced.
+type JobCompleted struct {
+	JobEvent
+	Job *PipelineJob
+}
+
+type subscription struct {
+	handle func(e Event)
+}
+
+// Subscribe adds a handler for events of type E to the runner, Subscribe[Event] receives all events.
+// It returns a function to remove the subscription.
+//
+// Handlers are called synchronously in the order they were added while the runner holds its lock, so they see events
+// in the order of the state changes. A handler must not block and must not call methods of the runner (e.g. send the
+// event to a buffered channel or start a goroutine).
+func Subscribe[E Event](r *PipelineRunner, handler func(e E)) (unsubscribe func()) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return r.subscribe(func(e Event) {
+		if typedEvent, ok := e.(E); ok {
+			handler(typedEvent)
+		}
+	})
+}
+
+// subscribe adds a subscription, it must be called with a lock
+func (r *PipelineRunner) subscribe(handle func(e Event)) (unsubscribe func()) {
+	s := &subscription{handle: handle}
+	r.subscriptions = append(r.subscriptions, s)
+
+	return func() {
+		r.mx.Lock()
+		defer r.mx.Unlock()
+
+		for i, existing := range r.subscriptions {
+			if existing == s {
+				r.subscriptions = append(r.subscriptions[:i], r.subscriptions[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// publish calls the handlers of all subscriptions for the event, it must be called with a lock
+func (r *PipelineRunner) publish(e Event) {
+	for _, s := range r.subscriptions {
+		s.handle(e)
+	}
+}
+
+// publishJobCompleted publishes JobCompleted if the job is finished, it must be called with a lock
+func (r *PipelineRunner) publishJobCompleted(job *PipelineJob) {
+	if !job.isFinished() {
+		return
+	}
+	r.publish(JobCompleted{JobEvent: job.jobEvent(), Job: job.copy()})
+}
+
+func (j *PipelineJob) jobEvent() JobEvent {
+	return JobEvent{JobID: j.ID, Pipeline: j.Pipeline}
+}
+
+// subscribeInternalConsumers wires the persister and the notifications for finished jobs to the events
+func (r *PipelineRunner) subscribeInternalConsumers() {
+	// Every event is a change of the job that needs to be saved
+	r.subscribe(func(e Event) {
+		job, ok := r.jobsByID[e.jobEvent().JobID]
+		if !ok {
+			return
+		}
+		r.markChanged(job)
+		r.requestPersist()
+	})
+
+	r.subscribe(func(e Event) {
+		completed, ok := e.(JobCompleted)
+		if !ok {
+			return
+		}
+		r.notifyJobWaiters(completed.JobID)
+		r.runPostCompleteHooks(completed.Job)
+	})
+}
@@ -0,0 +1,178 @@
+package prunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// defaultGitUsername is used for HTTP authentication with a token if no username is set (supported by GitHub and
+// accepted by most other hosts)
+const defaultGitUsername = "x-access-token"
+
+// checkoutDir returns the directory of the git checkout of the job, it is a temporary directory of the job if the
+// definition has no dir
+func (j *PipelineJob) checkoutDir() string {
+	if j.Git == nil {
+		return ""
+	}
+	if j.Git.Dir != "" {
+		return j.Git.Dir
+	}
+	return filepath.Join(os.TempDir(), "prunner-checkout-"+j.ID.String())
+}
+
+// checkoutJob checks out the repository of the job into dir and records the commit on the job
+func (r *PipelineRunner) checkoutJob(ctx context.Context, job *PipelineJob, dir string) error {
+	logger := r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", job.Pipeline)
+	logger.Debugf("Checking out %s", job.Git)
+
+	// Variables and the git settings are not changed after scheduling, so they are read without the lock
+	commit, err := gitCheckout(ctx, *job.Git, dir, job.Variables)
+	if err != nil {
+		logger.WithError(err).Error("Failed to check out git repository")
+		return errors.Wrap(err, "checking out git repository")
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	job.GitCommit = commit
+	r.publish(JobChanged{JobEvent: job.jobEvent()})
+	return nil
+}
+
+// removeCheckout removes the temporary checkout of a job after it finished, checkouts in a dir of the definition are
+// kept for the next job
+func (r *PipelineRunner) removeCheckout(job *PipelineJob, dir string) {
+	if job.Git.Dir != "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithError(err).
+			Warn("Failed to remove git checkout")
+	}
+}
+
+func persistedGitCheckout(def *definition.GitCheckoutDef) *store.PersistedGitCheckout {
+	if def == nil {
+		return nil
+	}
+	return &store.PersistedGitCheckout{
+		URL:         def.URL,
+		Ref:         def.Ref,
+		Depth:       def.Depth,
+		Dir:         def.Dir,
+		Username:    def.Username,
+		PasswordEnv: def.PasswordEnv,
+	}
+}
+
+func restoredGitCheckout(checkout *store.PersistedGitCheckout) *definition.GitCheckoutDef {
+	if checkout == nil {
+		return nil
+	}
+	return &definition.GitCheckoutDef{
+		URL:         checkout.URL,
+		Ref:         checkout.Ref,
+		Depth:       checkout.Depth,
+		Dir:         checkout.Dir,
+		Username:    checkout.Username,
+		PasswordEnv: checkout.PasswordEnv,
+	}
+}
+
+// gitCheckout fetches the ref of the repository into dir and checks it out, it returns the commit SHA
+func gitCheckout(ctx context.Context, def definition.GitCheckoutDef, dir string, vars map[string]interface{}) (string, error) {
+	ref, err := taskctl.RenderCommand(def.Ref, vars)
+	if err != nil {
+		return "", errors.Wrap(err, "rendering ref")
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	env, err := gitAuthEnv(def)
+	if err != nil {
+		return "", err
+	}
+	git := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "creating checkout directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if _, err := git("init", "-q"); err != nil {
+			return "", err
+		}
+		if _, err := git("remote", "add", "origin", def.URL); err != nil {
+			return "", err
+		}
+	} else if _, err := git("remote", "set-url", "origin", def.URL); err != nil {
+		return "", err
+	}
+
+	fetchArgs := []string{"fetch", "-q", "--no-tags"}
+	if def.Depth > 0 {
+		fetchArgs = append(fetchArgs, "--depth", strconv.Itoa(def.Depth))
+	}
+	fetchArgs = append(fetchArgs, "origin", ref)
+	if _, err := git(fetchArgs...); err != nil {
+		return "", err
+	}
+	if _, err := git("checkout", "-q", "--force", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+	return git("rev-parse", "HEAD")
+}
+
+// gitAuthEnv returns environment variables that configure HTTP authentication for git, so the password is neither
+// part of the command line nor the URL
+func gitAuthEnv(def definition.GitCheckoutDef) ([]string, error) {
+	if def.PasswordEnv == "" {
+		return nil, nil
+	}
+	password, ok := os.LookupEnv(def.PasswordEnv)
+	if !ok {
+		return nil, errors.Errorf("environment variable %s for the git password is not set", def.PasswordEnv)
+	}
+	username := def.Username
+	if username == "" {
+		username = defaultGitUsername
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		fmt.Sprintf("GIT_CONFIG_VALUE_0=Authorization: Basic %s", credentials),
+		"GIT_TERMINAL_PROMPT=0",
+	}, nil
+}
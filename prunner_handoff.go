@@ -0,0 +1,123 @@
+package prunner
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// PrepareHandOff stops starting jobs and waits until all running jobs are finished, so the state can be handed off to
+// a new instance (see HandOff). Jobs can still be scheduled and are kept on the wait list in the meantime.
+//
+// If the context is done before all jobs are finished, jobs are started again and the context error is returned.
+func (r *PipelineRunner) PrepareHandOff(ctx context.Context) error {
+	r.mx.Lock()
+	r.isHandingOff = true
+	r.mx.Unlock()
+
+	for {
+		r.mx.RLock()
+		hasRunningPipelines := false
+		for pipelineName := range r.jobsByPipeline {
+			if r.isRunning(pipelineName) {
+				hasRunningPipelines = true
+				break
+			}
+		}
+		r.mx.RUnlock()
+
+		if !hasRunningPipelines {
+			return nil
+		}
+
+		r.logger.
+			WithField("component", "runner").
+			Debugf("Handing off, waiting for running jobs to finish...")
+
+		select {
+		case <-time.After(r.ShutdownPollInterval):
+		case <-ctx.Done():
+			r.CancelHandOff()
+			return errors.Wrap(ctx.Err(), "waiting for running jobs")
+		}
+	}
+}
+
+// CancelHandOff starts jobs again after PrepareHandOff, e.g. if the new instance could not be started
+func (r *PipelineRunner) CancelHandOff() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.isHandingOff = false
+	for pipeline := range r.waitListByPipeline {
+		r.startJobsOnWaitList(pipeline)
+	}
+}
+
+// HandOff saves the state for a new instance that takes over the data directory. In contrast to Shutdown, jobs on the
+// wait list are not canceled but started by the new instance (see ResumeWaitList).
+//
+// It must be called after PrepareHandOff and when no more jobs are scheduled, the runner must not be used afterwards.
+func (r *PipelineRunner) HandOff() error {
+	r.mx.Lock()
+	r.isShuttingDown = true
+	r.isHandedOff = true
+	data := r.persistedData()
+	data.HandedOff = true
+	r.mx.Unlock()
+
+	// Wait for a save that could be running, further saves are skipped
+	r.saveMx.Lock()
+	err := r.store.Save(data)
+	r.saveMx.Unlock()
+	if err != nil {
+		r.mx.Lock()
+		r.isShuttingDown = false
+		r.isHandedOff = false
+		r.mx.Unlock()
+
+		return errors.Wrap(err, "saving job state")
+	}
+
+	r.logger.
+		WithField("component", "runner").
+		Infof("Handed off job state with %d jobs", len(data.Jobs))
+
+	return nil
+}
+
+// queueHandedOffJobs puts jobs of a previous instance back on the wait list in the order they were scheduled
+func (r *PipelineRunner) queueHandedOffJobs(jobs []*PipelineJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+
+	for _, job := range jobs {
+		// The remaining start delay is not persisted, so a delayed job is started without further delay
+		job.StartDelay = r.defs.Pipelines[job.Pipeline].StartDelay
+		r.waitListByPipeline[job.Pipeline] = insertByPriority(r.waitListByPipeline[job.Pipeline], job)
+		// The queue timeout counts from the time the job was scheduled on the previous instance
+		if queueTimeout := r.defs.Pipelines[job.Pipeline].QueueTimeout; queueTimeout > 0 {
+			r.startQueueTimer(job, job.Created.Add(queueTimeout).Sub(r.now()))
+		}
+
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithField("pipeline", job.Pipeline).
+			Infof("Found handed off job on wait list when restoring state, queued again")
+	}
+}
+
+// ResumeWaitList starts jobs on the wait lists that were handed off by a previous instance.
+// It should be called after all start gates are added, so they apply to the resumed jobs.
+func (r *PipelineRunner) ResumeWaitList() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for pipeline := range r.waitListByPipeline {
+		r.startJobsOnWaitList(pipeline)
+	}
+}
@@ -0,0 +1,103 @@
+package prunner
+
+import (
+	"fmt"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// ErrJobRejected is returned by ScheduleAsync if a pre-schedule hook rejected the job
+var ErrJobRejected = errors.New("job rejected")
+
+// PreScheduleHook is called before a job is scheduled. It can change the schedule options (e.g. set variables or
+// labels) or reject the job by returning an error.
+type PreScheduleHook func(pipeline string, opts *ScheduleOpts) error
+
+// PostCompleteHook is called after a job is finished (completed, failed or canceled) with a copy of the job, so it
+// can safely be referenced and methods of the runner can be called.
+type PostCompleteHook func(job *PipelineJob)
+
+// UsePreScheduleHook adds a hook that is called before a job is scheduled, hooks are called in the order they were
+// added. Dry runs do not call hooks.
+func (r *PipelineRunner) UsePreScheduleHook(hook PreScheduleHook) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.preScheduleHooks = append(r.preScheduleHooks, hook)
+}
+
+// UsePostCompleteHook adds a hook that is called after a job is finished. Hooks are called asynchronously in the
+// order they were added, Shutdown waits for them.
+func (r *PipelineRunner) UsePostCompleteHook(hook PostCompleteHook) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.postCompleteHooks = append(r.postCompleteHooks, hook)
+}
+
+// runPreScheduleHooks calls the pre-schedule hooks without a lock, so hooks can call methods of the runner
+func (r *PipelineRunner) runPreScheduleHooks(pipeline string, opts *ScheduleOpts) error {
+	r.mx.RLock()
+	hooks := r.preScheduleHooks
+	r.mx.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(pipeline, opts); err != nil {
+			return fmt.Errorf("%w: %v", ErrJobRejected, err)
+		}
+	}
+	return nil
+}
+
+// runPostCompleteHooks calls the post-complete hooks with a copy of a finished job, it must be called with the lock held
+func (r *PipelineRunner) runPostCompleteHooks(jobCopy *PipelineJob) {
+	if len(r.postCompleteHooks) == 0 {
+		return
+	}
+
+	hooks := r.postCompleteHooks
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for _, hook := range hooks {
+			hook(jobCopy)
+		}
+	}()
+}
+
+// copy returns a copy of the job state that can be read without a lock
+func (j *PipelineJob) copy() *PipelineJob {
+	return &PipelineJob{
+		ID:             j.ID,
+		Pipeline:       j.Pipeline,
+		Env:            j.Env,
+		Variables:      j.Variables,
+		StartDelay:     j.StartDelay,
+		PriorityClass:  j.PriorityClass,
+		Interpreter:    j.Interpreter,
+		DefinitionHash: j.DefinitionHash,
+		GitCommit:      j.GitCommit,
+		LogsPruned:     j.LogsPruned,
+		SLABreached:    j.SLABreached,
+		Priority:       j.Priority,
+		Labels:         j.Labels,
+		Completed:      j.Completed,
+		Canceled:       j.Canceled,
+		Incomplete:     j.Incomplete,
+		Expired:        j.Expired,
+		Created:        j.Created,
+		Start:          j.Start,
+		End:            j.End,
+		User:           j.User,
+		Tasks:          append(jobTasks(nil), j.Tasks...),
+		Services:       append(jobServices(nil), j.Services...),
+		LastError:      j.LastError,
+		Processes:      append([]taskctl.Process(nil), j.Processes...),
+
+		ExpectedDuration: j.ExpectedDuration,
+		SlowAfter:        j.SlowAfter,
+	}
+}
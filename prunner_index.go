@@ -0,0 +1,156 @@
+package prunner
+
+import (
+	"fmt"
+
+	"github.com/gofrs/uuid"
+)
+
+// Selector matches jobs with a label or variable, the value is only compared if HasValue is set
+type Selector struct {
+	Name     string
+	Value    string
+	HasValue bool
+}
+
+// JobSelector selects jobs by labels and variables, all selectors must match (an empty selector matches all jobs)
+type JobSelector struct {
+	Labels    []Selector
+	Variables []Selector
+}
+
+// IsEmpty returns true if the selector has no label or variable selectors
+func (s JobSelector) IsEmpty() bool {
+	return len(s.Labels) == 0 && len(s.Variables) == 0
+}
+
+type jobIDSet map[uuid.UUID]struct{}
+
+// valueIndex maps names and values to job ids
+type valueIndex map[string]map[string]jobIDSet
+
+func (idx valueIndex) add(name, value string, id uuid.UUID) {
+	values, ok := idx[name]
+	if !ok {
+		values = make(map[string]jobIDSet)
+		idx[name] = values
+	}
+	ids, ok := values[value]
+	if !ok {
+		ids = make(jobIDSet)
+		values[value] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (idx valueIndex) remove(name, value string, id uuid.UUID) {
+	values := idx[name]
+	ids := values[value]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(values, value)
+	}
+	if len(values) == 0 {
+		delete(idx, name)
+	}
+}
+
+// lookup returns the ids of jobs matching the selector
+func (idx valueIndex) lookup(selector Selector) jobIDSet {
+	values := idx[selector.Name]
+	if selector.HasValue {
+		return values[selector.Value]
+	}
+
+	result := make(jobIDSet)
+	for _, ids := range values {
+		for id := range ids {
+			result[id] = struct{}{}
+		}
+	}
+	return result
+}
+
+// jobIndex is an in-memory index of jobs by label and variable values for finding jobs without iterating all jobs
+type jobIndex struct {
+	labels    valueIndex
+	variables valueIndex
+}
+
+func newJobIndex() *jobIndex {
+	return &jobIndex{
+		labels:    make(valueIndex),
+		variables: make(valueIndex),
+	}
+}
+
+func (idx *jobIndex) add(job *PipelineJob) {
+	for name, value := range job.Labels {
+		idx.labels.add(name, value, job.ID)
+	}
+	for name, value := range job.Variables {
+		for _, v := range indexedVariableValues(value) {
+			idx.variables.add(name, v, job.ID)
+		}
+	}
+}
+
+func (idx *jobIndex) remove(job *PipelineJob) {
+	for name, value := range job.Labels {
+		idx.labels.remove(name, value, job.ID)
+	}
+	for name, value := range job.Variables {
+		for _, v := range indexedVariableValues(value) {
+			idx.variables.remove(name, v, job.ID)
+		}
+	}
+}
+
+// find returns the ids of jobs matching all selectors
+func (idx *jobIndex) find(selector JobSelector) jobIDSet {
+	var result jobIDSet
+	intersect := func(ids jobIDSet) {
+		if result == nil {
+			result = make(jobIDSet, len(ids))
+			for id := range ids {
+				result[id] = struct{}{}
+			}
+			return
+		}
+		for id := range result {
+			if _, ok := ids[id]; !ok {
+				delete(result, id)
+			}
+		}
+	}
+
+	for _, s := range selector.Labels {
+		intersect(idx.labels.lookup(s))
+	}
+	for _, s := range selector.Variables {
+		intersect(idx.variables.lookup(s))
+	}
+
+	return result
+}
+
+// indexedVariableValues formats a variable value for the index, each element of a list is indexed separately
+// so a job with a list variable is found by any of its elements. Other values are not indexed.
+func indexedVariableValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case bool, int, int64, float64:
+		return []string{fmt.Sprint(v)}
+	case []interface{}:
+		var values []string
+		for _, element := range v {
+			switch element.(type) {
+			case string, bool, int, int64, float64:
+				values = append(values, fmt.Sprint(element))
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package prunner
+
+import (
+	"github.com/Flowpack/prunner/definition"
+)
+
+// LintDefinitions checks the definitions for settings that are valid but most likely a mistake (see definition.PipelinesDef.Lint)
+func LintDefinitions(defs *definition.PipelinesDef) []definition.LintWarning {
+	return defs.Lint(definition.LintOpts{
+		JobEnv:  []string{JobIDEnvName, PipelineEnvName, UserEnvName, TriggerEnvName, CorrelationIDEnvName, TmpDirEnvName},
+		TaskEnv: []string{TaskEnvName, "TASK_NAME"},
+	})
+}
+
+// LintDefinitions checks the current definitions for settings that are valid but most likely a mistake
+func (r *PipelineRunner) LintDefinitions() []definition.LintWarning {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	return LintDefinitions(r.defs)
+}
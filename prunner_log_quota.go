@@ -0,0 +1,82 @@
+package prunner
+
+import (
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// enforceLogQuotas removes the logs of the oldest finished jobs of pipelines whose stored output exceeds the log quota.
+// It must be called with the lock held.
+//
+// The size of finished jobs is cached, since their output does not change anymore. Jobs evicted from memory are counted
+// and their logs are removed, but they are not marked as pruned in the store.
+func (r *PipelineRunner) enforceLogQuotas(jobsByPipeline map[string][]*PipelineJob) {
+	sizer, ok := r.outputStore.(taskctl.JobOutputSizer)
+	if !ok {
+		return
+	}
+
+	for pipeline, jobsInPipeline := range jobsByPipeline {
+		pipelineDef, exists := r.defs.Pipelines[pipeline]
+		if !exists || pipelineDef.LogQuota <= 0 {
+			continue
+		}
+		quota := int64(pipelineDef.LogQuota)
+
+		sizes := make([]int64, len(jobsInPipeline))
+		var total int64
+		for i, job := range jobsInPipeline {
+			sizes[i] = r.jobLogSize(sizer, job)
+			total += sizes[i]
+		}
+
+		// Jobs are sorted by creation time (newest first), so the oldest jobs are pruned first
+		for i := len(jobsInPipeline) - 1; i >= 0 && total > quota; i-- {
+			job := jobsInPipeline[i]
+			if sizes[i] == 0 || !job.isFinished() {
+				continue
+			}
+
+			logger := r.logger.
+				WithField("component", "runner").
+				WithFields(job.logFields()).
+				WithField("pipeline", pipeline).
+				WithField("logSize", sizes[i]).
+				WithField("logQuota", pipelineDef.LogQuota.String())
+			if err := r.outputStore.Remove(job.ID.String()); err != nil {
+				logger.WithError(err).Error("Failed to remove logs of job exceeding the log quota")
+				continue
+			}
+			logger.Warn("Log quota of pipeline exceeded, removed logs of oldest job")
+
+			total -= sizes[i]
+			r.logSizes[job.ID] = 0
+			if _, evicted := r.evictedJobs[job.ID]; !evicted {
+				job.LogsPruned = true
+				r.markChanged(job)
+			}
+		}
+	}
+}
+
+// jobLogSize returns the size of the stored output of the job, it must be called with the lock held
+func (r *PipelineRunner) jobLogSize(sizer taskctl.JobOutputSizer, job *PipelineJob) int64 {
+	if size, cached := r.logSizes[job.ID]; cached {
+		return size
+	}
+	if job.LogsPruned {
+		return 0
+	}
+	size, err := sizer.JobOutputSize(job.ID.String())
+	if err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithError(err).
+			Warn("Failed to read size of job logs")
+		return 0
+	}
+	if job.isFinished() {
+		r.logSizes[job.ID] = size
+	}
+	return size
+}
@@ -0,0 +1,372 @@
+package prunner
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+// QueuedJob is a job on the wait list of a pipeline
+type QueuedJob struct {
+	ID uuid.UUID
+	// Position of the job on the wait list, starting at 1 for the next job to start
+	Position int
+	// Queued is the time the job was scheduled
+	Queued   time.Time
+	Priority JobPriority
+	User     string
+	Labels   map[string]string
+	// StartAt is the earliest start of a job with a start delay (nil if the job has no pending start delay)
+	StartAt *time.Time
+}
+
+// WaitListInfo describes the wait list of a pipeline and why queued jobs are not started
+type WaitListInfo struct {
+	Pipeline      string
+	QueueStrategy definition.QueueStrategy
+	// QueueLimit is the maximum number of queued jobs (nil if unbounded)
+	QueueLimit  *int
+	Concurrency int
+	// Running is the number of running jobs of the pipeline
+	Running int
+	// Reason why queued jobs are not started (empty if they can start, e.g. after a start delay)
+	Reason string
+	// Jobs on the wait list in the order they will be started
+	Jobs []QueuedJob
+}
+
+// WaitList returns the queued jobs of a pipeline in order
+func (r *PipelineRunner) WaitList(pipeline string) (WaitListInfo, error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	pipelineDef, ok := r.defs.Pipelines[pipeline]
+	if !ok {
+		return WaitListInfo{}, ErrPipelineNotDefined
+	}
+
+	running := r.runningJobsCount(pipeline)
+	info := WaitListInfo{
+		Pipeline:      pipeline,
+		QueueStrategy: pipelineDef.QueueStrategy,
+		QueueLimit:    pipelineDef.QueueLimit,
+		Concurrency:   pipelineDef.Concurrency,
+		Running:       running,
+		Reason:        r.waitReason(pipeline, pipelineDef, running),
+		Jobs:          []QueuedJob{},
+	}
+
+	for _, job := range r.waitListByPipeline[pipeline] {
+		// Canceled jobs stay on the wait list until they would be started
+		if job.Canceled {
+			continue
+		}
+		queuedJob := QueuedJob{
+			ID:       job.ID,
+			Position: len(info.Jobs) + 1,
+			Queued:   job.Created,
+			Priority: job.Priority,
+			User:     job.User,
+			Labels:   job.Labels,
+		}
+		if job.startTimer != nil {
+			startAt := job.Created.Add(job.StartDelay)
+			queuedJob.StartAt = &startAt
+		}
+		info.Jobs = append(info.Jobs, queuedJob)
+	}
+
+	return info, nil
+}
+
+// ErrJobNotQueued is returned if a job is expected on the wait list, but it was already started or finished
+var ErrJobNotQueued = errors.New("job is not queued")
+
+// QueuePosition describes where a queued job is on the wait list of its pipeline and when it is expected to start
+type QueuePosition struct {
+	// Position of the job on the wait list, starting at 1 for the next job to start
+	Position int
+	// Running is the number of running jobs of the pipeline
+	Running int
+	// EstimatedStart is the expected start of the job based on the durations of previous jobs (nil if not enough jobs
+	// succeeded before or the job waits for something else than running jobs, e.g. a closed schedule window)
+	EstimatedStart *time.Time
+}
+
+// QueuePosition returns the position of a queued job on the wait list and its estimated start
+func (r *PipelineRunner) QueuePosition(id uuid.UUID) (QueuePosition, error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	job, ok := r.jobsByID[id]
+	if !ok {
+		return QueuePosition{}, ErrJobNotFound
+	}
+	pipelineDef, ok := r.defs.Pipelines[job.Pipeline]
+	if !ok {
+		return QueuePosition{}, ErrPipelineNotDefined
+	}
+
+	position := 0
+	for _, queuedJob := range r.waitListByPipeline[job.Pipeline] {
+		if queuedJob.Canceled {
+			continue
+		}
+		position++
+		if queuedJob == job {
+			return QueuePosition{
+				Position:       position,
+				Running:        r.runningJobsCount(job.Pipeline),
+				EstimatedStart: r.estimateStart(job, pipelineDef, position),
+			}, nil
+		}
+	}
+	return QueuePosition{}, ErrJobNotQueued
+}
+
+// estimateStart computes the expected start of a queued job at the position on the wait list: running jobs and the
+// jobs before it are expected to run for the median duration of previous jobs. It must be called with the lock held.
+func (r *PipelineRunner) estimateStart(job *PipelineJob, pipelineDef definition.PipelineDef, position int) *time.Time {
+	// Only waiting for running jobs can be estimated
+	if pipelineDef.Concurrency < 1 || r.waitReason(job.Pipeline, pipelineDef, 0) != "" {
+		return nil
+	}
+	stats := r.durationStats(job.Pipeline)
+	if stats.Samples < minDurationSamples {
+		return nil
+	}
+
+	now := r.now()
+	// Each slot is the time a job of the pipeline is expected to end, so the next job can start
+	var slots []time.Time
+	for _, runningJob := range r.jobsByPipeline[job.Pipeline] {
+		if !runningJob.isRunning() {
+			continue
+		}
+		end := runningJob.Start.Add(stats.Median)
+		if eta := runningJob.ETA(); eta != nil {
+			end = *eta
+		}
+		// Jobs running longer than expected could end any moment
+		if end.Before(now) {
+			end = now
+		}
+		slots = append(slots, end)
+	}
+	for len(slots) < pipelineDef.Concurrency {
+		slots = append(slots, now)
+	}
+	sortTimes(slots)
+	// If more jobs are running than allowed (e.g. after lowering the concurrency), the earliest jobs must end first
+	slots = slots[len(slots)-pipelineDef.Concurrency:]
+
+	for i := 1; i < position; i++ {
+		slots[0] = slots[0].Add(stats.Median)
+		sortTimes(slots)
+	}
+
+	start := slots[0]
+	if job.startTimer != nil {
+		if startAt := job.Created.Add(job.StartDelay); startAt.After(start) {
+			start = startAt
+		}
+	}
+	return &start
+}
+
+func sortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+}
+
+// PromoteJob moves a queued job to the front of the wait list of its pipeline and returns its previous position.
+// The job gets a high priority, so it is not overtaken by jobs that are scheduled with a high priority later.
+func (r *PipelineRunner) PromoteJob(id uuid.UUID) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	job, ok := r.jobsByID[id]
+	if !ok {
+		return 0, ErrJobNotFound
+	}
+
+	waitList := r.waitListByPipeline[job.Pipeline]
+	index := -1
+	position := 0
+	for i, queuedJob := range waitList {
+		if queuedJob.Canceled {
+			continue
+		}
+		position++
+		if queuedJob == job {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return 0, ErrJobNotQueued
+	}
+
+	copy(waitList[1:index+1], waitList[:index])
+	waitList[0] = job
+	job.Priority = JobPriorityHigh
+	r.markChanged(job)
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", job.Pipeline).
+		WithFields(job.logFields()).
+		WithField("previousPosition", position).
+		Debugf("Promoted: moved job to front of wait list")
+
+	// The promoted job could start right away, e.g. if the previous first job is waiting for its start delay
+	r.startJobsOnWaitList(job.Pipeline)
+	r.requestPersist()
+
+	return position, nil
+}
+
+// ErrJobNotCanceled is returned if a canceled or expired job is expected, but it was not canceled
+var ErrJobNotCanceled = errors.New("job is not canceled")
+
+// RequeueJob schedules a new job with the variables, labels and priority of a canceled or expired job (e.g. after a
+// queue timeout), the new job references the original job with RequeuedFrom. It is scheduled like any other job, so it
+// is added to the wait list or started right away.
+func (r *PipelineRunner) RequeueJob(id uuid.UUID, user string) (*PipelineJob, error) {
+	var (
+		pipeline string
+		canceled bool
+		opts     ScheduleOpts
+	)
+	err := r.ReadJob(id, func(j *PipelineJob) {
+		pipeline = j.Pipeline
+		canceled = j.Canceled
+		opts = ScheduleOpts{
+			Variables:     j.Variables,
+			User:          user,
+			Priority:      j.Priority,
+			Labels:        j.Labels,
+			CorrelationID: j.CorrelationID,
+			Trigger:       j.Trigger,
+			RequeuedFrom:  &id,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !canceled {
+		return nil, ErrJobNotCanceled
+	}
+
+	job, err := r.ScheduleAsync(pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		WithFields(job.logFields()).
+		WithField("requeuedFrom", id).
+		Debugf("Requeued: scheduled canceled job again")
+
+	return job, nil
+}
+
+// ErrJobExpired is the error of a job that was canceled because it was on the wait list longer than the queue timeout
+var ErrJobExpired = errors.New("job expired on wait list")
+
+// startQueueTimer expires the job after the timeout if it was not started until then, it must be called with the lock
+// held
+func (r *PipelineRunner) startQueueTimer(job *PipelineJob, timeout time.Duration) {
+	id := job.ID
+	job.queueTimer = time.AfterFunc(timeout, func() {
+		r.expireJob(id)
+	})
+}
+
+func (j *PipelineJob) stopQueueTimer() {
+	if j.queueTimer != nil {
+		j.queueTimer.Stop()
+		j.queueTimer = nil
+	}
+}
+
+// expireJob cancels a job that is still on the wait list after the queue timeout
+func (r *PipelineRunner) expireJob(id uuid.UUID) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	job, ok := r.jobsByID[id]
+	if !ok || job.queueTimer == nil {
+		return
+	}
+	job.queueTimer = nil
+	if job.Start != nil || job.Canceled {
+		return
+	}
+
+	waitList := r.waitListByPipeline[job.Pipeline]
+	for i, queuedJob := range waitList {
+		if queuedJob == job {
+			r.waitListByPipeline[job.Pipeline] = append(waitList[:i:i], waitList[i+1:]...)
+			break
+		}
+	}
+	if job.startTimer != nil {
+		job.startTimer.Stop()
+		job.startTimer = nil
+	}
+
+	job.markAsCanceled()
+	job.Expired = true
+	job.LastError = fmt.Errorf("%w after %s", ErrJobExpired, r.now().Sub(job.Created).Round(time.Second))
+	r.publishJobCompleted(job)
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", job.Pipeline).
+		WithFields(job.logFields()).
+		Infof("Expired: canceled job after queue timeout")
+}
+
+// hasQueuedJobs returns true if a job that was not canceled is on the wait list, it must be called with the lock held
+func (r *PipelineRunner) hasQueuedJobs(pipeline string) bool {
+	for _, job := range r.waitListByPipeline[pipeline] {
+		if !job.Canceled {
+			return true
+		}
+	}
+	return false
+}
+
+// waitReason returns why no queued job of the pipeline can start, it must be called with the lock held
+func (r *PipelineRunner) waitReason(pipeline string, pipelineDef definition.PipelineDef, running int) string {
+	if r.isHandingOff {
+		return "handing off jobs to another instance"
+	}
+	if r.drainedPipelines[pipeline] {
+		return "pipeline is drained"
+	}
+	for _, gate := range r.startGates {
+		if canStart, reason := gate.CanStart(); !canStart {
+			return fmt.Sprintf("start gate closed: %s", reason)
+		}
+	}
+	if !r.isInScheduleWindow(pipelineDef) {
+		return fmt.Sprintf("outside of schedule window %s", r.scheduleWindow(pipelineDef))
+	}
+	if quota, exceeded := r.exceededQuota(pipeline, definition.QuotaActionQueue); exceeded {
+		return fmt.Sprintf("schedule quota of %s reached", quota)
+	}
+	if running >= pipelineDef.Concurrency {
+		return fmt.Sprintf("concurrency of %d reached", pipelineDef.Concurrency)
+	}
+	return ""
+}
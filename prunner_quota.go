@@ -0,0 +1,154 @@
+package prunner
+
+import (
+	"sort"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+// ErrQuotaExceeded is returned by ScheduleAsync if a schedule quota of the pipeline with action reject is exceeded
+var ErrQuotaExceeded = errors.New("schedule quota exceeded")
+
+// QuotaUsage is the current usage of a schedule quota of a pipeline
+type QuotaUsage struct {
+	Pipeline string
+	Quota    definition.ScheduleQuotaDef
+	// Used is the number of jobs in the current period (scheduled jobs for action reject, started jobs for action queue)
+	Used int
+	// Exceeded is the number of jobs rejected or queued because the quota was exceeded since the runner started
+	Exceeded int
+}
+
+type quotaKey struct {
+	pipeline string
+	quota    definition.ScheduleQuotaDef
+}
+
+// QuotaUsage returns the usage of the schedule quotas of all pipelines ordered by pipeline and the order of definition
+func (r *PipelineRunner) QuotaUsage() []QuotaUsage {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	usage := []QuotaUsage{}
+	for pipeline, pipelineDef := range r.defs.Pipelines {
+		for _, quota := range pipelineDef.ScheduleQuotas {
+			usage = append(usage, QuotaUsage{
+				Pipeline: pipeline,
+				Quota:    quota,
+				Used:     r.quotaJobsCount(pipeline, quota),
+				Exceeded: r.quotaExceeded[quotaKey{pipeline: pipeline, quota: quota}],
+			})
+		}
+	}
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Pipeline < usage[j].Pipeline
+	})
+	return usage
+}
+
+// quotaJobsCount returns the number of jobs counted for the quota in its current period, it must be called with the
+// lock held
+func (r *PipelineRunner) quotaJobsCount(pipeline string, quota definition.ScheduleQuotaDef) int {
+	return len(r.quotaJobTimes(pipeline, quota))
+}
+
+// quotaJobTimes returns the times of jobs in the current period of the quota (creation for action reject, start for
+// action queue) in no particular order, it must be called with the lock held
+func (r *PipelineRunner) quotaJobTimes(pipeline string, quota definition.ScheduleQuotaDef) []time.Time {
+	since := r.now().Add(-quota.Per)
+	var times []time.Time
+	for _, jobs := range []jobList{r.jobsByPipeline[pipeline], r.evictedJobsByPipeline[pipeline]} {
+		if quota.Action == definition.QuotaActionQueue {
+			// Jobs can start in a different order than they were created
+			for _, job := range jobs {
+				if job.Start != nil && job.Start.After(since) {
+					times = append(times, *job.Start)
+				}
+			}
+			continue
+		}
+		for i := len(jobs) - 1; i >= 0 && jobs[i].Created.After(since); i-- {
+			times = append(times, jobs[i].Created)
+		}
+	}
+	return times
+}
+
+// exceededQuota returns the first quota of the pipeline with the action that allows no more jobs in its current
+// period, it must be called with the lock held
+func (r *PipelineRunner) exceededQuota(pipeline string, action definition.QuotaAction) (definition.ScheduleQuotaDef, bool) {
+	for _, quota := range r.defs.Pipelines[pipeline].ScheduleQuotas {
+		if quota.Action == action && r.quotaJobsCount(pipeline, quota) >= quota.Max {
+			return quota, true
+		}
+	}
+	return definition.ScheduleQuotaDef{}, false
+}
+
+// recordQuotaExceeded counts a job that was rejected or queued because of the quota, it must be called with the lock
+// held
+func (r *PipelineRunner) recordQuotaExceeded(pipeline string, quota definition.ScheduleQuotaDef) {
+	r.quotaExceeded[quotaKey{pipeline: pipeline, quota: quota}]++
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		WithField("action", quota.Action.String()).
+		Warnf("Schedule quota of %s exceeded", quota)
+}
+
+// armQuotaTimer starts the jobs on the wait list of a pipeline when the period of its exceeded queue quotas allows
+// starting a job again, it must be called with the lock held
+func (r *PipelineRunner) armQuotaTimer(pipeline string) {
+	if _, armed := r.quotaTimerByPipeline[pipeline]; armed || !r.hasQueuedJobs(pipeline) {
+		return
+	}
+
+	now := r.now()
+	var freesAt time.Time
+	for _, quota := range r.defs.Pipelines[pipeline].ScheduleQuotas {
+		if quota.Action != definition.QuotaActionQueue {
+			continue
+		}
+		starts := r.quotaJobTimes(pipeline, quota)
+		if len(starts) < quota.Max {
+			continue
+		}
+		// A job can start when enough of the oldest starts left the period
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i].Before(starts[j])
+		})
+		if t := starts[len(starts)-quota.Max].Add(quota.Per); t.After(freesAt) {
+			freesAt = t
+		}
+	}
+	if freesAt.IsZero() {
+		return
+	}
+
+	freesIn := freesAt.Sub(now)
+	r.quotaTimerByPipeline[pipeline] = time.AfterFunc(freesIn, func() {
+		r.releaseQuota(pipeline)
+	})
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		Debugf("Schedule quota exceeded: starting queued jobs in %s", freesIn.Round(time.Second))
+}
+
+// releaseQuota starts the jobs on the wait list of a pipeline after the period of its queue quotas allows it
+func (r *PipelineRunner) releaseQuota(pipeline string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	delete(r.quotaTimerByPipeline, pipeline)
+	if r.isShuttingDown {
+		return
+	}
+	// The timer is armed again if a quota is still exceeded (e.g. the definition changed)
+	r.startJobsOnWaitList(pipeline)
+}
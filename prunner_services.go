@@ -0,0 +1,247 @@
+package prunner
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/helper"
+	"github.com/Flowpack/prunner/store"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// Status values of a service of a job
+const (
+	ServiceStatusWaiting  = "waiting"
+	ServiceStatusStarting = "starting"
+	ServiceStatusRunning  = "running"
+	// ServiceStatusExited is set if the command of the service exited while the job was running
+	ServiceStatusExited  = "exited"
+	ServiceStatusFailed  = "failed"
+	ServiceStatusStopped = "stopped"
+)
+
+// jobService is a long-running command of the PipelineJob that runs alongside the tasks
+type jobService struct {
+	definition.ServiceDef
+	Name string
+
+	Status string
+	Start  *time.Time
+	End    *time.Time
+	Error  error
+}
+
+type jobServices []jobService
+
+func buildJobServices(services map[string]definition.ServiceDef) jobServices {
+	if len(services) == 0 {
+		return nil
+	}
+	result := make(jobServices, 0, len(services))
+	for name, serviceDef := range services {
+		result = append(result, jobService{
+			ServiceDef: serviceDef,
+			Name:       name,
+			Status:     ServiceStatusWaiting,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (js jobServices) ByName(name string) *jobService {
+	for i := range js {
+		if js[i].Name == name {
+			return &js[i]
+		}
+	}
+	return nil
+}
+
+// definitions returns the service definitions by name
+func (js jobServices) definitions() map[string]definition.ServiceDef {
+	if len(js) == 0 {
+		return nil
+	}
+	result := make(map[string]definition.ServiceDef, len(js))
+	for _, s := range js {
+		result[s.Name] = s.ServiceDef
+	}
+	return result
+}
+
+// startServices starts the services of the job one after another and waits until each is healthy. If a service cannot
+// be started, the services that are already running are stopped.
+func (r *PipelineRunner) startServices(ctx context.Context, job *PipelineJob, defaultDir string) ([]*taskctl.RunningService, error) {
+	if len(job.Services) == 0 {
+		return nil, nil
+	}
+	serviceRunner, ok := r.taskRunner.(taskctl.ServiceRunner)
+	if !ok {
+		return nil, errors.New("task runner cannot start services")
+	}
+
+	// The state of started services is changed concurrently, so the definitions are copied with the lock held. The
+	// environment and variables are not changed after scheduling and read without the lock.
+	r.mx.Lock()
+	services := append(jobServices(nil), job.Services...)
+	r.mx.Unlock()
+
+	var running []*taskctl.RunningService
+	for _, s := range services {
+		env := job.taskEnv()
+		for k, v := range s.Env {
+			env[k] = v
+		}
+		dir := s.Dir
+		if dir == "" {
+			dir = defaultDir
+		}
+
+		r.updateService(job, s.Name, func(js *jobService) {
+			js.Status = ServiceStatusStarting
+			now := r.now()
+			js.Start = &now
+		})
+		rs, err := serviceRunner.StartService(ctx, job.ID.String(), taskctl.Service{
+			Name:                s.Name,
+			Command:             s.Command,
+			Dir:                 dir,
+			Env:                 env,
+			HealthCheck:         s.HealthCheck,
+			HealthCheckInterval: s.HealthCheckInterval,
+			StartTimeout:        s.StartTimeout,
+		}, job.Variables, job.ProcessPriority())
+		if err != nil {
+			r.updateService(job, s.Name, func(js *jobService) {
+				js.Status = ServiceStatusFailed
+				now := r.now()
+				js.End = &now
+				js.Error = err
+			})
+			r.stopServices(job, running)
+			return nil, errors.Wrapf(err, "starting service %s", s.Name)
+		}
+
+		r.updateService(job, s.Name, func(js *jobService) {
+			js.Status = ServiceStatusRunning
+		})
+		running = append(running, rs)
+
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.watchService(job, rs)
+		}()
+	}
+	return running, nil
+}
+
+// watchService records the exit of a service while the job is running, a service that was stopped at the end of the
+// job is not changed
+func (r *PipelineRunner) watchService(job *PipelineJob, rs *taskctl.RunningService) {
+	<-rs.Done()
+	r.updateService(job, rs.Name, func(js *jobService) {
+		if js.Status != ServiceStatusRunning {
+			return
+		}
+		r.logger.
+			WithField("component", "runner").
+			WithFields(job.logFields()).
+			WithField("pipeline", job.Pipeline).
+			WithError(rs.Err()).
+			Warnf("Service %s exited while the job was running", rs.Name)
+		js.Status = ServiceStatusExited
+		now := r.now()
+		js.End = &now
+		js.Error = rs.Err()
+	})
+}
+
+// stopServices terminates the services in reverse order of starting them
+func (r *PipelineRunner) stopServices(job *PipelineJob, running []*taskctl.RunningService) {
+	for i := len(running) - 1; i >= 0; i-- {
+		rs := running[i]
+		stopped := false
+		r.updateService(job, rs.Name, func(js *jobService) {
+			if js.Status == ServiceStatusRunning {
+				js.Status = ServiceStatusStopped
+				stopped = true
+			}
+		})
+		rs.Stop()
+		if stopped {
+			r.updateService(job, rs.Name, func(js *jobService) {
+				now := r.now()
+				js.End = &now
+			})
+		}
+	}
+}
+
+// updateService changes the state of a service of the job with the lock held
+func (r *PipelineRunner) updateService(job *PipelineJob, name string, f func(js *jobService)) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	js := job.Services.ByName(name)
+	if js == nil {
+		return
+	}
+	f(js)
+	r.publish(JobChanged{JobEvent: job.jobEvent()})
+}
+
+func persistedServices(services jobServices) []store.PersistedService {
+	if len(services) == 0 {
+		return nil
+	}
+	result := make([]store.PersistedService, len(services))
+	for i, s := range services {
+		result[i] = store.PersistedService{
+			Name:                s.Name,
+			Command:             s.Command,
+			Env:                 s.Env,
+			Dir:                 s.Dir,
+			HealthCheck:         s.HealthCheck,
+			HealthCheckInterval: s.HealthCheckInterval,
+			StartTimeout:        s.StartTimeout,
+			Status:              s.Status,
+			Start:               s.Start,
+			End:                 s.End,
+			Error:               helper.ErrToStrPtr(s.Error),
+		}
+	}
+	return result
+}
+
+func restoredServices(services []store.PersistedService) jobServices {
+	if len(services) == 0 {
+		return nil
+	}
+	result := make(jobServices, len(services))
+	for i, s := range services {
+		result[i] = jobService{
+			ServiceDef: definition.ServiceDef{
+				Command:             s.Command,
+				Env:                 s.Env,
+				Dir:                 s.Dir,
+				HealthCheck:         s.HealthCheck,
+				HealthCheckInterval: s.HealthCheckInterval,
+				StartTimeout:        s.StartTimeout,
+			},
+			Name:   s.Name,
+			Status: s.Status,
+			Start:  s.Start,
+			End:    s.End,
+			Error:  helper.StrPtrToErr(s.Error),
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package prunner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// startSLATimer flags the job as breaching the SLA of its pipeline if it is still running when the SLA is exceeded, it
+// must be called with the lock held after the job started
+func (r *PipelineRunner) startSLATimer(job *PipelineJob) {
+	sla := r.defs.Pipelines[job.Pipeline].Alerts.SLA
+	if sla <= 0 {
+		return
+	}
+	id := job.ID
+	job.slaTimer = time.AfterFunc(sla, func() {
+		r.breachSLA(id, sla)
+	})
+}
+
+// stopSLATimer stops the SLA timer after the job finished and flags the job if it ran longer than the SLA, it must be
+// called with the lock held
+func (r *PipelineRunner) stopSLATimer(job *PipelineJob) {
+	if job.slaTimer == nil {
+		return
+	}
+	job.slaTimer.Stop()
+	job.slaTimer = nil
+
+	sla := r.defs.Pipelines[job.Pipeline].Alerts.SLA
+	if sla > 0 && job.Start != nil && job.End != nil && job.End.Sub(*job.Start) > sla {
+		job.SLABreached = true
+	}
+}
+
+// breachSLA flags a running job that exceeded the SLA and raises the alert immediately if alerts are checked
+func (r *PipelineRunner) breachSLA(id uuid.UUID, sla time.Duration) {
+	r.mx.Lock()
+	job := r.jobsByID[id]
+	if job == nil || !job.isRunning() || job.SLABreached {
+		r.mx.Unlock()
+		return
+	}
+	job.slaTimer = nil
+	job.SLABreached = true
+	r.publish(JobChanged{JobEvent: job.jobEvent()})
+
+	r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", job.Pipeline).
+		Warnf("Job is running longer than the SLA of %s", sla)
+
+	alertsWatched := r.alertsWatched
+	r.mx.Unlock()
+
+	if alertsWatched {
+		r.CheckAlerts()
+	}
+}
+
+// slaAlert returns the alert for running jobs of the pipeline that breached the SLA (nil if there are none), it must be
+// called with the lock held
+func (r *PipelineRunner) slaAlert(pipeline string, sla time.Duration) *Alert {
+	var breached []*PipelineJob
+	for _, job := range r.jobsByPipeline[pipeline] {
+		if job.isRunning() && job.SLABreached {
+			breached = append(breached, job)
+		}
+	}
+	if len(breached) == 0 {
+		return nil
+	}
+
+	since := breached[0].Start.Add(sla)
+	for _, job := range breached[1:] {
+		if t := job.Start.Add(sla); t.Before(since) {
+			since = t
+		}
+	}
+	message := fmt.Sprintf("job %s running longer than SLA of %s", breached[0].ID, sla)
+	if len(breached) > 1 {
+		message = fmt.Sprintf("%d jobs running longer than SLA of %s", len(breached), sla)
+	}
+	return &Alert{
+		Pipeline: pipeline,
+		Kind:     AlertSLABreached,
+		Active:   true,
+		Since:    &since,
+		Message:  message,
+	}
+}
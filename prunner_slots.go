@@ -0,0 +1,77 @@
+package prunner
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// SlotClaimer shares the concurrency of pipelines between multiple prunner instances (e.g. with a shared store), so
+// instances that serve the same pipelines do not start more jobs than the concurrency of a pipeline allows.
+//
+// Claim and Release are called with the lock of the runner held, they should use short timeouts.
+type SlotClaimer interface {
+	// Claim reserves a slot of the pipeline for the job, it returns false if all slots are used by other jobs
+	Claim(pipeline string, jobID uuid.UUID, concurrency int) (bool, error)
+	// Release frees the slot of a finished job (also if the job did not claim a slot)
+	Release(pipeline string, jobID uuid.UUID) error
+}
+
+// UseSlotClaimer claims a slot for every job before it is started. Jobs that could not claim a slot are kept on the
+// wait list, the wait lists are checked again every recheckInterval until the context is done (slots of other
+// instances are freed without notifying this runner).
+//
+// If a slot cannot be claimed because of an error, the job is kept on the wait list as well.
+func (r *PipelineRunner) UseSlotClaimer(ctx context.Context, claimer SlotClaimer, recheckInterval time.Duration) {
+	r.mx.Lock()
+	r.slotClaimer = claimer
+	r.mx.Unlock()
+
+	Subscribe(r, func(e JobCompleted) {
+		r.releaseSlot(e.Job)
+	})
+
+	go r.recheckWaitLists(ctx, recheckInterval)
+}
+
+// claimSlot returns true if the job can be started, it must be called with the lock held
+func (r *PipelineRunner) claimSlot(job *PipelineJob) bool {
+	if r.slotClaimer == nil {
+		return true
+	}
+
+	concurrency := r.defs.Pipelines[job.Pipeline].Concurrency
+	claimed, err := r.slotClaimer.Claim(job.Pipeline, job.ID, concurrency)
+	if err != nil {
+		r.logger.
+			WithField("component", "runner").
+			WithField("pipeline", job.Pipeline).
+			WithFields(job.logFields()).
+			WithError(err).
+			Warn("Failed to claim slot for job, keeping it on the wait list")
+		return false
+	}
+	if !claimed {
+		r.logger.
+			WithField("component", "runner").
+			WithField("pipeline", job.Pipeline).
+			WithFields(job.logFields()).
+			Debug("All slots of pipeline are used by other instances, keeping job on the wait list")
+	}
+	return claimed
+}
+
+// releaseSlot frees the slot of a finished job, it must be called with the lock held
+func (r *PipelineRunner) releaseSlot(job *PipelineJob) {
+	err := r.slotClaimer.Release(job.Pipeline, job.ID)
+	if err != nil {
+		// The slot is freed by the claimer after its lease expired
+		r.logger.
+			WithField("component", "runner").
+			WithField("pipeline", job.Pipeline).
+			WithFields(job.logFields()).
+			WithError(err).
+			Warn("Failed to release slot of job")
+	}
+}
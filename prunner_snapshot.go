@@ -0,0 +1,70 @@
+package prunner
+
+import (
+	"regexp"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// maskedValue replaces values of sensitive environment variables and variables in snapshots
+const maskedValue = "***"
+
+// sensitiveNamePattern matches names of environment variables and variables whose values are masked in snapshots
+var sensitiveNamePattern = regexp.MustCompile(`(?i)(secret|passw|token|key|credential|auth|private|cert)`)
+
+// isSensitiveName returns true if the value of a variable with the name must not be recorded
+func isSensitiveName(name string) bool {
+	return sensitiveNamePattern.MatchString(name)
+}
+
+// taskEnvSnapshot returns the environment the task receives from prunner (job env, TASK_NAME and task env in the order
+// they are merged by the task runner) with sensitive values masked. The environment of the prunner process is not
+// included.
+func (j *PipelineJob) taskEnvSnapshot(jt *jobTask) map[string]string {
+	env := j.taskEnv()
+	env["TASK_NAME"] = jt.Name
+	env[TaskEnvName] = jt.Name
+	taskEnv := make(map[string]interface{}, len(jt.Env))
+	for name, value := range jt.Env {
+		taskEnv[name] = value
+	}
+	// Templates are rendered with the masked variables, so a sensitive variable is not recorded in a value of an
+	// environment variable with an insensitive name
+	data := j.envTemplateData()
+	data["vars"] = j.maskedVariables()
+	rendered, err := taskctl.RenderEnv(taskEnv, data)
+	if err != nil {
+		// The task fails if its env cannot be rendered, so the snapshot keeps the templates
+		rendered = jt.Env
+	}
+	for name, value := range rendered {
+		env[name] = value
+	}
+	for name := range env {
+		if isSensitiveName(name) {
+			env[name] = maskedValue
+		}
+	}
+	return env
+}
+
+// variablesSnapshot returns the variables of the job with sensitive values masked
+func (j *PipelineJob) variablesSnapshot() map[string]interface{} {
+	if len(j.Variables) == 0 {
+		return nil
+	}
+	return j.maskedVariables()
+}
+
+// maskedVariables returns a copy of the variables of the job with sensitive values masked
+func (j *PipelineJob) maskedVariables() map[string]interface{} {
+	vars := make(map[string]interface{}, len(j.Variables))
+	for name, value := range j.Variables {
+		if isSensitiveName(name) {
+			vars[name] = maskedValue
+		} else {
+			vars[name] = value
+		}
+	}
+	return vars
+}
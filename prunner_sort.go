@@ -38,3 +38,47 @@ func (s *pipelineJobsSorter) Less(i, j int) bool {
 func byCreationTimeDesc(p1, p2 *PipelineJob) bool {
 	return p2.Created.Before(p1.Created)
 }
+
+// jobList is a list of jobs ordered by creation time (oldest first). Jobs are inserted and removed by a binary search
+// on the creation time, so the list never needs to be sorted.
+type jobList []*PipelineJob
+
+// insert adds the job after all jobs created before or at the same time, so new jobs are appended
+func (l jobList) insert(job *PipelineJob) jobList {
+	i := sort.Search(len(l), func(i int) bool {
+		return l[i].Created.After(job.Created)
+	})
+	l = append(l, nil)
+	copy(l[i+1:], l[i:])
+	l[i] = job
+	return l
+}
+
+// remove removes the job with the same id and keeps the order of the other jobs
+func (l jobList) remove(job *PipelineJob) jobList {
+	i := sort.Search(len(l), func(i int) bool {
+		return !l[i].Created.Before(job.Created)
+	})
+	for ; i < len(l) && l[i].Created.Equal(job.Created); i++ {
+		if l[i].ID == job.ID {
+			return append(l[:i], l[i+1:]...)
+		}
+	}
+	return l
+}
+
+// mergeJobLists merges two job lists into a new list ordered by creation time (newest first)
+func mergeJobLists(a, b jobList) []*PipelineJob {
+	result := make([]*PipelineJob, 0, len(a)+len(b))
+	i, j := len(a)-1, len(b)-1
+	for i >= 0 || j >= 0 {
+		if j < 0 || (i >= 0 && !a[i].Created.Before(b[j].Created)) {
+			result = append(result, a[i])
+			i--
+		} else {
+			result = append(result, b[j])
+			j--
+		}
+	}
+	return result
+}
@@ -35,3 +35,35 @@ func TestSortJobsByCreationDate_ShouldSortDescending(t *testing.T) {
 	assert.Equal(t, u2, jobs[1].ID, "Jobs[1] mismatch")
 	assert.Equal(t, u1, jobs[2].ID, "Jobs[2] mismatch")
 }
+
+func TestJobList_InsertAndRemove(t *testing.T) {
+	var t1 = time.Date(2020, 05, 03, 0, 0, 0, 0, time.UTC)
+	var t2 = time.Date(2020, 05, 04, 0, 0, 0, 0, time.UTC)
+	var job1 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: t1}
+	var job2 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: t2}
+	var job3 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: t2}
+	var job4 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: t1}
+
+	var l jobList
+	l = l.insert(job2)
+	l = l.insert(job1)
+	l = l.insert(job3)
+	l = l.insert(job4)
+	assert.Equal(t, jobList{job1, job4, job2, job3}, l, "ordered by creation time, same creation time in insertion order")
+
+	l = l.remove(&PipelineJob{ID: job2.ID, Created: t2})
+	assert.Equal(t, jobList{job1, job4, job3}, l, "removed by id")
+
+	l = l.remove(&PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: t1})
+	assert.Equal(t, jobList{job1, job4, job3}, l, "unknown job is ignored")
+}
+
+func TestMergeJobLists(t *testing.T) {
+	var job1 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: time.Date(2020, 05, 03, 0, 0, 0, 0, time.UTC)}
+	var job2 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: time.Date(2020, 05, 04, 0, 0, 0, 0, time.UTC)}
+	var job3 = &PipelineJob{ID: uuid.Must(uuid.NewV4()), Created: time.Date(2020, 05, 05, 0, 0, 0, 0, time.UTC)}
+
+	assert.Equal(t, []*PipelineJob{job3, job2, job1}, mergeJobLists(jobList{job1, job3}, jobList{job2}))
+	assert.Equal(t, []*PipelineJob{job2, job1}, mergeJobLists(nil, jobList{job1, job2}))
+	assert.Equal(t, []*PipelineJob{}, mergeJobLists(nil, nil))
+}
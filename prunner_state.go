@@ -0,0 +1,98 @@
+package prunner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// RunnerState is a snapshot of the runtime state of the runner for debugging (e.g. of stuck instances)
+type RunnerState struct {
+	Jobs           int
+	ShuttingDown   bool
+	JobWaiters     int
+	StartGatesOpen bool
+	Pipelines      []PipelineState
+}
+
+// PipelineState is the runtime state of a pipeline with its running and waiting jobs
+type PipelineState struct {
+	Pipeline    string
+	Jobs        int
+	RunningJobs []RunningJobState
+	WaitList    []uuid.UUID
+}
+
+// RunningJobState is the state of a running job with the tasks that are currently running
+type RunningJobState struct {
+	ID           uuid.UUID
+	Start        time.Time
+	RunningTasks []string
+	Processes    []int
+}
+
+// State returns a snapshot of the runtime state, pipelines are sorted by name
+func (r *PipelineRunner) State() RunnerState {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	state := RunnerState{
+		Jobs:           len(r.jobsByID),
+		ShuttingDown:   r.isShuttingDown,
+		StartGatesOpen: r.canStartJobs(),
+	}
+	for _, waiters := range r.jobWaiters {
+		state.JobWaiters += len(waiters)
+	}
+
+	for pipeline, jobs := range r.jobsByPipeline {
+		pipelineState := PipelineState{
+			Pipeline: pipeline,
+			Jobs:     len(jobs),
+		}
+		for _, job := range jobs {
+			if !job.isRunning() {
+				continue
+			}
+			jobState := RunningJobState{
+				ID:    job.ID,
+				Start: *job.Start,
+			}
+			for _, t := range job.Tasks {
+				if t.Status == "running" {
+					jobState.RunningTasks = append(jobState.RunningTasks, t.Name)
+				}
+			}
+			for _, p := range job.Processes {
+				jobState.Processes = append(jobState.Processes, p.Pid)
+			}
+			pipelineState.RunningJobs = append(pipelineState.RunningJobs, jobState)
+		}
+		for _, job := range r.waitListByPipeline[pipeline] {
+			pipelineState.WaitList = append(pipelineState.WaitList, job.ID)
+		}
+		state.Pipelines = append(state.Pipelines, pipelineState)
+	}
+	sort.Slice(state.Pipelines, func(i, j int) bool {
+		return state.Pipelines[i].Pipeline < state.Pipelines[j].Pipeline
+	})
+
+	return state
+}
+
+// Write writes the state in a human readable format
+func (s RunnerState) Write(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "jobs: %d, shutting down: %t, start gates open: %t, job waiters: %d\n", s.Jobs, s.ShuttingDown, s.StartGatesOpen, s.JobWaiters)
+	for _, p := range s.Pipelines {
+		_, _ = fmt.Fprintf(w, "pipeline %s: %d jobs, %d running, %d waiting\n", p.Pipeline, p.Jobs, len(p.RunningJobs), len(p.WaitList))
+		for _, j := range p.RunningJobs {
+			_, _ = fmt.Fprintf(w, "  running job %s since %s, tasks: %v, pids: %v\n", j.ID, j.Start.Format(time.RFC3339), j.RunningTasks, j.Processes)
+		}
+		for i, id := range p.WaitList {
+			_, _ = fmt.Fprintf(w, "  waiting job %s at position %d\n", id, i+1)
+		}
+	}
+}
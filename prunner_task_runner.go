@@ -0,0 +1,60 @@
+package prunner
+
+import (
+	"io"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// TaskRunnerOpts is a configuration function for NewTaskRunner
+type TaskRunnerOpts func(*taskRunnerConfig)
+
+type taskRunnerConfig struct {
+	workerPool      *taskctl.WorkerPool
+	liveOutputLines int
+}
+
+// WithWorkerPool bounds the total weight of concurrently executing tasks of all jobs, every task of a job uses
+// workers with the weight of its pipeline
+func WithWorkerPool(pool *taskctl.WorkerPool) TaskRunnerOpts {
+	return func(c *taskRunnerConfig) {
+		c.workerPool = pool
+	}
+}
+
+// WithLiveOutputLines keeps the last lines of stdout and stderr of running tasks in memory, so they can be read with
+// PipelineRunner.CurrentTaskOutput without reading the output store (0 disables the live output)
+func WithLiveOutputLines(lines int) TaskRunnerOpts {
+	return func(c *taskRunnerConfig) {
+		c.liveOutputLines = lines
+	}
+}
+
+// NewTaskRunner returns the task runner for NewPipelineRunner that executes the tasks of all jobs. The environment,
+// process priority, interpreters and retries of a job are passed with the context of the job (see
+// PipelineJob.TaskOptions). The output of tasks is only written to the output store, input for interactive tasks is
+// sent with PipelineRunner.WriteTaskInput.
+func NewTaskRunner(outputStore taskctl.OutputStore, opts ...TaskRunnerOpts) taskctl.Runner {
+	c := &taskRunnerConfig{}
+	for _, o := range opts {
+		o(c)
+	}
+
+	// Interactive tasks read from their own stdin pipe, prunner never passes its own stdin to tasks
+	taskRunnerOpts := []taskctl.Opts{taskctl.WithTaskInput()}
+	if c.workerPool != nil {
+		taskRunnerOpts = append(taskRunnerOpts, taskctl.WithWorkerPool(c.workerPool))
+	}
+	if c.liveOutputLines > 0 {
+		taskRunnerOpts = append(taskRunnerOpts, taskctl.WithLiveOutputLines(c.liveOutputLines))
+	}
+
+	// taskctl.NewTaskRunner never actually returns an error
+	taskRunner, _ := taskctl.NewTaskRunner(outputStore, taskRunnerOpts...)
+
+	// Do not output task stdout / stderr to the server process. NOTE: Before/After execution logs won't be visible because of this
+	taskRunner.Stdout = io.Discard
+	taskRunner.Stderr = io.Discard
+
+	return taskRunner
+}
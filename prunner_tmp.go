@@ -0,0 +1,33 @@
+package prunner
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// TmpDirEnvName is the environment variable with the temporary directory of the job, it is set for all tasks and
+// services of a job
+const TmpDirEnvName = "PRUNNER_TMP"
+
+// tmpDir returns the temporary directory of the job, it is created when the job starts and removed after it finished
+func (j *PipelineJob) tmpDir() string {
+	return filepath.Join(os.TempDir(), "prunner-tmp-"+j.ID.String())
+}
+
+// removeTmpDir removes the temporary directory after the job finished, it is kept for inspection if the job failed and
+// the pipeline keeps it on failure
+func (r *PipelineRunner) removeTmpDir(job *PipelineJob, jobErr error) {
+	logger := r.logger.
+		WithField("component", "runner").
+		WithFields(job.logFields()).
+		WithField("pipeline", job.Pipeline)
+
+	// The setting is not changed after scheduling, so it is read without the lock
+	if jobErr != nil && job.KeepTmpOnFailure {
+		logger.Infof("Keeping temporary directory %s of failed job", job.tmpDir())
+		return
+	}
+	if err := os.RemoveAll(job.tmpDir()); err != nil {
+		logger.WithError(err).Warn("Failed to remove temporary directory")
+	}
+}
@@ -0,0 +1,121 @@
+package prunner
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Flowpack/prunner/hoststat"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// PipelineDataUsage is the storage used by the retained jobs of a pipeline
+type PipelineDataUsage struct {
+	// Jobs is the number of retained jobs including jobs evicted from memory
+	Jobs int
+	// LogBytes is the size of the stored output of the jobs
+	LogBytes int64
+	// ArtifactBytes is the size of temporary directories of jobs that are kept on failure or in use by running jobs
+	ArtifactBytes int64
+}
+
+// DataUsage returns the storage used by the retained jobs by pipeline. Log sizes are only reported if the output store
+// can determine them.
+func (r *PipelineRunner) DataUsage() map[string]PipelineDataUsage {
+	r.mx.Lock()
+	sizer, _ := r.outputStore.(taskctl.JobOutputSizer)
+	usage := make(map[string]PipelineDataUsage)
+	tmpDirsByPipeline := make(map[string][]string)
+	for pipeline, jobsInPipeline := range r.retainedJobsByPipeline() {
+		var pipelineUsage PipelineDataUsage
+		for _, job := range jobsInPipeline {
+			pipelineUsage.Jobs++
+			if sizer != nil {
+				pipelineUsage.LogBytes += r.jobLogSize(sizer, job)
+			}
+			// The temporary directory of successful jobs is always removed
+			if !job.isFinished() || job.LastError != nil {
+				tmpDirsByPipeline[pipeline] = append(tmpDirsByPipeline[pipeline], job.tmpDir())
+			}
+		}
+		usage[pipeline] = pipelineUsage
+	}
+	r.mx.Unlock()
+
+	// Walking the temporary directories can take a while, so it is done without the lock
+	for pipeline, tmpDirs := range tmpDirsByPipeline {
+		pipelineUsage := usage[pipeline]
+		for _, tmpDir := range tmpDirs {
+			size, err := hoststat.DirSize(tmpDir)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					r.logger.
+						WithField("component", "runner").
+						WithField("pipeline", pipeline).
+						WithError(err).
+						Warnf("Failed to read size of temporary directory %s", tmpDir)
+				}
+				continue
+			}
+			pipelineUsage.ArtifactBytes += size
+		}
+		usage[pipeline] = pipelineUsage
+	}
+
+	return usage
+}
+
+// TaskResourceUsage is the accumulated resource usage of all finished runs of a task since prunner was started
+type TaskResourceUsage struct {
+	Pipeline string
+	Task     string
+	// Runs is the number of finished runs of the task, skipped tasks are not counted
+	Runs int
+	// WallTime, UserTime and SystemTime are the sums of all runs
+	WallTime   time.Duration
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the maximum resident set size of a process of the last run in bytes
+	MaxRSS int64
+}
+
+type taskUsageKey struct {
+	pipeline string
+	task     string
+}
+
+// TaskResourceUsage returns the resource usage of the tasks of all pipelines ordered by pipeline and task
+func (r *PipelineRunner) TaskResourceUsage() []TaskResourceUsage {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	usage := make([]TaskResourceUsage, 0, len(r.taskUsage))
+	for _, u := range r.taskUsage {
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Pipeline != usage[j].Pipeline {
+			return usage[i].Pipeline < usage[j].Pipeline
+		}
+		return usage[i].Task < usage[j].Task
+	})
+	return usage
+}
+
+// recordTaskUsage adds the resource usage of a finished task, it must be called with the lock held
+func (r *PipelineRunner) recordTaskUsage(pipeline string, jt *jobTask) {
+	if jt.Skipped || jt.Start == nil || jt.End == nil {
+		return
+	}
+
+	key := taskUsageKey{pipeline: pipeline, task: jt.Name}
+	u := r.taskUsage[key]
+	u.Pipeline = pipeline
+	u.Task = jt.Name
+	u.Runs++
+	u.WallTime += jt.End.Sub(*jt.Start)
+	u.UserTime += jt.ResourceUsage.UserTime
+	u.SystemTime += jt.ResourceUsage.SystemTime
+	u.MaxRSS = jt.ResourceUsage.MaxRSS
+	r.taskUsage[key] = u
+}
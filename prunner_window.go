@@ -0,0 +1,72 @@
+package prunner
+
+import (
+	"time"
+
+	"github.com/Flowpack/prunner/definition"
+)
+
+// WithTimezone sets the default timezone for schedule windows of pipelines without a timezone (defaults to the local
+// timezone)
+func WithTimezone(loc *time.Location) Opts {
+	return func(r *PipelineRunner) {
+		r.timezone = loc
+	}
+}
+
+// scheduleWindow returns the schedule window of the pipeline in the timezone of the pipeline or the default timezone
+// (nil if the pipeline has no schedule window)
+func (r *PipelineRunner) scheduleWindow(pipelineDef definition.PipelineDef) *definition.ScheduleWindow {
+	if pipelineDef.ScheduleWindow == nil {
+		return nil
+	}
+	loc := r.timezone
+	if pipelineDef.Timezone.Location != nil {
+		loc = pipelineDef.Timezone.Location
+	}
+	window := pipelineDef.ScheduleWindow.InLocation(loc)
+	return &window
+}
+
+// isInScheduleWindow returns true if jobs of the pipeline can start at the current time
+func (r *PipelineRunner) isInScheduleWindow(pipelineDef definition.PipelineDef) bool {
+	window := r.scheduleWindow(pipelineDef)
+	return window == nil || window.Contains(r.now())
+}
+
+// armWindowTimer starts the jobs on the wait list of a pipeline when its schedule window opens, it must be called with
+// the lock held
+func (r *PipelineRunner) armWindowTimer(pipeline string) {
+	pipelineDef := r.defs.Pipelines[pipeline]
+	if r.isInScheduleWindow(pipelineDef) || !r.hasQueuedJobs(pipeline) {
+		return
+	}
+	if _, armed := r.windowTimerByPipeline[pipeline]; armed {
+		return
+	}
+
+	window := r.scheduleWindow(pipelineDef)
+	now := r.now()
+	opensIn := window.NextOpen(now).Sub(now)
+	r.windowTimerByPipeline[pipeline] = time.AfterFunc(opensIn, func() {
+		r.openScheduleWindow(pipeline)
+	})
+
+	r.logger.
+		WithField("component", "runner").
+		WithField("pipeline", pipeline).
+		Debugf("Outside of schedule window %s: starting queued jobs in %s", window, opensIn.Round(time.Second))
+}
+
+// openScheduleWindow starts the jobs on the wait list of a pipeline after its schedule window opened
+func (r *PipelineRunner) openScheduleWindow(pipeline string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	delete(r.windowTimerByPipeline, pipeline)
+	if r.isShuttingDown {
+		return
+	}
+	// The timer is armed again if the window is still closed (e.g. the definition changed)
+	r.startJobsOnWaitList(pipeline)
+}
@@ -0,0 +1,197 @@
+// Package redistrigger schedules jobs from schedule requests in a Redis list, for callers that cannot make HTTP
+// requests to the API.
+//
+// Producers push requests with LPUSH to the list. A request is moved to a processing list while it is scheduled and
+// removed after the job was accepted, so requests are not lost if prunner stops. Requests that cannot be scheduled
+// are moved to a list with the suffix ":failed".
+package redistrigger
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/resp"
+)
+
+const (
+	// DefaultUser is used for the job if the request has no user
+	DefaultUser = "redis"
+
+	dialTimeout = 5 * time.Second
+	// pollTimeout is the timeout of BRPOPLPUSH, so the consumer can stop
+	pollTimeout = 1 * time.Second
+	// retryInterval is the time to wait after a connection error or a job that cannot be scheduled right now
+	retryInterval = 5 * time.Second
+)
+
+// Request is the JSON payload of a schedule request in the list, the fields are the same as for the HTTP API
+type Request struct {
+	Pipeline  string                 `json:"pipeline"`
+	Variables map[string]interface{} `json:"variables"`
+	Labels    map[string]string      `json:"labels"`
+	Priority  string                 `json:"priority"`
+	User      string                 `json:"user"`
+}
+
+// Scheduler schedules jobs, it is implemented by prunner.PipelineRunner
+type Scheduler interface {
+	ScheduleAsync(pipeline string, opts prunner.ScheduleOpts) (*prunner.PipelineJob, error)
+}
+
+// Consumer reads schedule requests from a Redis list
+type Consumer struct {
+	opts  resp.Options
+	queue string
+
+	scheduler Scheduler
+}
+
+// NewConsumer parses the URL (redis://[:password@]host[:port][/db]) for reading requests from the queue list
+func NewConsumer(rawURL string, queue string, scheduler Scheduler) (*Consumer, error) {
+	opts, err := resp.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if queue == "" {
+		return nil, errors.New("queue must not be empty")
+	}
+
+	return &Consumer{
+		opts:      opts,
+		queue:     queue,
+		scheduler: scheduler,
+	}, nil
+}
+
+func (c *Consumer) processingQueue() string {
+	return c.queue + ":processing"
+}
+
+func (c *Consumer) failedQueue() string {
+	return c.queue + ":failed"
+}
+
+// Run consumes requests until the context is done, connection errors are logged and the connection is retried
+func (c *Consumer) Run(ctx context.Context) {
+	logger := log.
+		WithField("component", "redistrigger").
+		WithField("queue", c.queue)
+
+	for ctx.Err() == nil {
+		err := c.consume(ctx)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		logger.
+			WithError(err).
+			Warn("Error consuming schedule requests from Redis, retrying")
+
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (c *Consumer) consume(ctx context.Context) error {
+	conn, err := resp.Connect(c.opts, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Requests that were not acknowledged by a previous run are scheduled again
+	for {
+		_, err := conn.Do(dialTimeout, "RPOPLPUSH", c.processingQueue(), c.queue)
+		if err == resp.ErrNil {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "requeueing unacknowledged requests")
+		}
+	}
+
+	for ctx.Err() == nil {
+		reply, err := conn.Do(pollTimeout+dialTimeout, "BRPOPLPUSH", c.queue, c.processingQueue(), strconv.Itoa(int(pollTimeout.Seconds())))
+		if err == resp.ErrNil {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading request")
+		}
+		payload, _ := reply.(string)
+
+		retry, err := c.schedule(payload)
+		if retry {
+			// Leave the request on the processing list, it is requeued after reconnecting
+			return err
+		}
+		if err != nil {
+			log.
+				WithField("component", "redistrigger").
+				WithField("queue", c.queue).
+				WithError(err).
+				Error("Failed to schedule job from Redis, moving request to failed list")
+
+			_, err = conn.Do(dialTimeout, "LPUSH", c.failedQueue(), payload)
+			if err != nil {
+				return errors.Wrap(err, "moving request to failed list")
+			}
+		}
+
+		// Acknowledge the request
+		_, err = conn.Do(dialTimeout, "LREM", c.processingQueue(), "1", payload)
+		if err != nil {
+			return errors.Wrap(err, "acknowledging request")
+		}
+	}
+
+	return nil
+}
+
+// schedule schedules a job for the payload, it returns true if scheduling should be retried later
+func (c *Consumer) schedule(payload string) (bool, error) {
+	var req Request
+	err := json.Unmarshal([]byte(payload), &req)
+	if err != nil {
+		return false, errors.Wrap(err, "decoding request")
+	}
+
+	priority, err := prunner.ParseJobPriority(req.Priority)
+	if err != nil {
+		return false, err
+	}
+	user := req.User
+	if user == "" {
+		user = DefaultUser
+	}
+
+	job, err := c.scheduler.ScheduleAsync(req.Pipeline, prunner.ScheduleOpts{
+		Variables: req.Variables,
+		User:      user,
+		Priority:  priority,
+		Labels:    req.Labels,
+		Trigger:   prunner.TriggerRedis,
+	})
+	if errors.Is(err, prunner.ErrShuttingDown) || errors.Is(err, prunner.ErrScheduleRefused) {
+		return true, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	log.
+		WithField("component", "redistrigger").
+		WithField("jobID", job.ID).
+		WithField("pipeline", req.Pipeline).
+		WithField("user", user).
+		Info("Job scheduled")
+
+	return false, nil
+}
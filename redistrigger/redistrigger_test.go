@@ -0,0 +1,272 @@
+package redistrigger
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/resp"
+	"github.com/Flowpack/prunner/test"
+)
+
+// fakeRedis implements the list commands used by the consumer
+type fakeRedis struct {
+	mx    sync.Mutex
+	lists map[string][]string
+	auth  string
+}
+
+func (f *fakeRedis) list(key string) []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string(nil), f.lists[key]...)
+}
+
+func (f *fakeRedis) lpush(key, value string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.lists[key] = append([]string{value}, f.lists[key]...)
+}
+
+func (f *fakeRedis) rpoplpush(src, dst string) (string, bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	values := f.lists[src]
+	if len(values) == 0 {
+		return "", false
+	}
+	value := values[len(values)-1]
+	f.lists[src] = values[:len(values)-1]
+	f.lists[dst] = append([]string{value}, f.lists[dst]...)
+	return value, true
+}
+
+func (f *fakeRedis) serve(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			f.mx.Lock()
+			f.auth = args[1]
+			f.mx.Unlock()
+			fmt.Fprint(conn, "+OK\r\n")
+		case "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case "RPOPLPUSH", "BRPOPLPUSH":
+			value, ok := f.rpoplpush(args[1], args[2])
+			if !ok {
+				if args[0] == "BRPOPLPUSH" {
+					time.Sleep(10 * time.Millisecond)
+				}
+				fmt.Fprint(conn, "$-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+		case "LPUSH":
+			f.lpush(args[1], args[2])
+			fmt.Fprint(conn, ":1\r\n")
+		case "LREM":
+			f.mx.Lock()
+			values := f.lists[args[1]]
+			for i, value := range values {
+				if value == args[3] {
+					f.lists[args[1]] = append(values[:i], values[i+1:]...)
+					break
+				}
+			}
+			f.mx.Unlock()
+			fmt.Fprint(conn, ":1\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+type mockScheduler struct {
+	mx        sync.Mutex
+	scheduled []prunner.ScheduleOpts
+}
+
+func (m *mockScheduler) ScheduleAsync(pipeline string, opts prunner.ScheduleOpts) (*prunner.PipelineJob, error) {
+	if pipeline != "deploy" {
+		return nil, errors.Errorf("pipeline %q is not defined", pipeline)
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.scheduled = append(m.scheduled, opts)
+	return &prunner.PipelineJob{ID: uuid.Must(uuid.NewV4()), Pipeline: pipeline}, nil
+}
+
+func (m *mockScheduler) scheduledJobs() []prunner.ScheduleOpts {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]prunner.ScheduleOpts(nil), m.scheduled...)
+}
+
+func TestConsumer_Run(t *testing.T) {
+	redis := &fakeRedis{lists: make(map[string][]string)}
+	address := redis.serve(t)
+
+	// A request that was not acknowledged before a restart
+	redis.lpush("prunner:processing", `{"pipeline": "deploy", "variables": {"tag": "v1.0.0"}}`)
+	redis.lpush("prunner", `{"pipeline": "deploy", "user": "ci", "labels": {"commit": "a1b2c3d"}}`)
+	redis.lpush("prunner", `{"pipeline": "unknown"}`)
+
+	scheduler := &mockScheduler{}
+	consumer, err := NewConsumer("redis://:secret@"+address+"/2", "prunner", scheduler)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	test.WaitForCondition(t, func() bool {
+		return len(scheduler.scheduledJobs()) == 2 && len(redis.list("prunner:processing")) == 0
+	}, 10*time.Millisecond, "requests are scheduled and acknowledged")
+
+	scheduled := scheduler.scheduledJobs()
+	require.Len(t, scheduled, 2)
+	assert.Equal(t, map[string]string{"commit": "a1b2c3d"}, scheduled[0].Labels)
+	assert.Equal(t, "ci", scheduled[0].User)
+	assert.Equal(t, map[string]interface{}{"tag": "v1.0.0"}, scheduled[1].Variables)
+	assert.Equal(t, DefaultUser, scheduled[1].User)
+
+	assert.Empty(t, redis.list("prunner"))
+	assert.Equal(t, []string{`{"pipeline": "unknown"}`}, redis.list("prunner:failed"))
+	redis.mx.Lock()
+	assert.Equal(t, "secret", redis.auth)
+	redis.mx.Unlock()
+}
+
+func TestNewConsumer_InvalidURL(t *testing.T) {
+	_, err := NewConsumer("amqp://localhost", "prunner", &mockScheduler{})
+	assert.EqualError(t, err, `unsupported Redis URL scheme "amqp", expected redis`)
+
+	_, err = NewConsumer("redis://localhost/db", "prunner", &mockScheduler{})
+	assert.EqualError(t, err, `invalid Redis database "db"`)
+}
+
+// TestConsumer_Run_Redis consumes requests from a real Redis server, it is only run if PRUNNER_TEST_REDIS_URL is set
+// (see resp.TestConn_Redis)
+func TestConsumer_Run_Redis(t *testing.T) {
+	rawURL := os.Getenv("PRUNNER_TEST_REDIS_URL")
+	if rawURL == "" {
+		t.Skip("PRUNNER_TEST_REDIS_URL is not set")
+	}
+	opts, err := resp.ParseURL(rawURL)
+	require.NoError(t, err)
+	conn, err := resp.Connect(opts, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	queue := fmt.Sprintf("prunner-test:%d", time.Now().UnixNano())
+	defer func() {
+		_, _ = conn.Do(time.Second, "DEL", queue, queue+":processing", queue+":failed")
+	}()
+	list := func(key string) []interface{} {
+		reply, err := conn.Do(time.Second, "LRANGE", key, "0", "-1")
+		require.NoError(t, err)
+		return reply.([]interface{})
+	}
+
+	_, err = conn.Do(time.Second, "LPUSH", queue+":processing", `{"pipeline": "deploy", "variables": {"tag": "v1.0.0"}}`)
+	require.NoError(t, err)
+	_, err = conn.Do(time.Second, "LPUSH", queue, `{"pipeline": "deploy", "user": "ci"}`, `{"pipeline": "unknown"}`)
+	require.NoError(t, err)
+
+	scheduler := &mockScheduler{}
+	consumer, err := NewConsumer(rawURL, queue, scheduler)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	test.WaitForCondition(t, func() bool {
+		return len(scheduler.scheduledJobs()) == 2 && len(list(queue+":processing")) == 0
+	}, 10*time.Millisecond, "requests are scheduled and acknowledged")
+
+	scheduled := scheduler.scheduledJobs()
+	require.Len(t, scheduled, 2)
+	assert.Equal(t, "ci", scheduled[0].User)
+	assert.Equal(t, map[string]interface{}{"tag": "v1.0.0"}, scheduled[1].Variables)
+	assert.Empty(t, list(queue))
+	assert.Equal(t, []interface{}{`{"pipeline": "unknown"}`}, list(queue+":failed"))
+}
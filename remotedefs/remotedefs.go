@@ -0,0 +1,258 @@
+// Package remotedefs fetches a bundle of pipeline definitions from an HTTP(S) URL, so a central team can distribute
+// pipelines to many prunner hosts.
+//
+// A bundle is a gzipped tarball with definition files (and script files they reference). It must be signed with an
+// ed25519 key: the base64 encoded signature of the bundle is fetched from the URL of the bundle with the suffix .sig.
+// Verified bundles are extracted to a local directory, so the last bundle is still available if the URL cannot be
+// reached (e.g. after a restart).
+package remotedefs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// maxBundleSize limits the size of a downloaded bundle and of all extracted files
+const maxBundleSize = 64 << 20
+
+// etagFile stores the ETag of the extracted bundle next to it
+const etagFile = "etag"
+
+// Source fetches a bundle from a URL and extracts it to a directory
+type Source struct {
+	url       string
+	dir       string
+	publicKey ed25519.PublicKey
+	client    *http.Client
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("public key must be %d bytes long, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return key, nil
+}
+
+// NewSource builds a source for the bundle at bundleURL that is extracted below dir, bundles must be signed with the
+// private key of publicKey
+func NewSource(bundleURL, dir string, publicKey ed25519.PublicKey) (*Source, error) {
+	u, err := url.Parse(bundleURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing URL")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.Errorf("URL must use https or http, got %q", u.Scheme)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("public key for verifying bundles is required")
+	}
+
+	return &Source{
+		url:       bundleURL,
+		dir:       dir,
+		publicKey: publicKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// CurrentDir returns the directory with the definitions of the last extracted bundle below dir
+func CurrentDir(dir string) string {
+	return filepath.Join(dir, "current")
+}
+
+// Dir returns the directory with the definitions of the last extracted bundle, it does not exist before the first
+// successful Fetch
+func (s *Source) Dir() string {
+	return CurrentDir(s.dir)
+}
+
+// Fetch downloads the bundle unless it has the ETag of the extracted bundle, verifies the signature and replaces the
+// extracted definitions. It returns true if the definitions changed.
+func (s *Source) Fetch(ctx context.Context) (bool, error) {
+	etag, err := os.ReadFile(filepath.Join(s.dir, etagFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, errors.Wrap(err, "reading ETag")
+	}
+	if _, err := os.Stat(s.Dir()); err != nil {
+		// Without extracted bundle the ETag must not prevent the download
+		etag = nil
+	}
+
+	bundle, newETag, err := s.get(ctx, s.url, string(etag))
+	if err != nil {
+		return false, errors.Wrap(err, "fetching bundle")
+	}
+	if bundle == nil {
+		return false, nil
+	}
+
+	encodedSignature, _, err := s.get(ctx, s.url+".sig", "")
+	if err != nil {
+		return false, errors.Wrap(err, "fetching signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSignature)))
+	if err != nil {
+		return false, errors.Wrap(err, "decoding signature")
+	}
+	if !ed25519.Verify(s.publicKey, bundle, signature) {
+		return false, errors.New("invalid signature of bundle")
+	}
+
+	err = s.replace(bundle)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.WriteFile(filepath.Join(s.dir, etagFile), []byte(newETag), 0666)
+	if err != nil {
+		return true, errors.Wrap(err, "writing ETag")
+	}
+
+	return true, nil
+}
+
+// get fetches the URL, the returned data is nil if the server responds with 304 Not Modified for the ETag
+func (s *Source) get(ctx context.Context, u, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, etag, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("unexpected status %s", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "reading response")
+	}
+	if len(data) > maxBundleSize {
+		return nil, "", errors.Errorf("response is larger than %d bytes", maxBundleSize)
+	}
+	return data, res.Header.Get("ETag"), nil
+}
+
+// replace extracts the bundle to a temporary directory and swaps it with the current directory, so definitions are
+// never loaded from a partially extracted bundle
+func (s *Source) replace(bundle []byte) error {
+	err := os.MkdirAll(s.dir, 0777)
+	if err != nil {
+		return errors.Wrap(err, "creating directory")
+	}
+
+	tmpDir, err := os.MkdirTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = extract(bundle, tmpDir)
+	if err != nil {
+		return errors.Wrap(err, "extracting bundle")
+	}
+
+	previousDir := filepath.Join(s.dir, "previous")
+	_ = os.RemoveAll(previousDir)
+	err = os.Rename(s.Dir(), previousDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "moving current definitions")
+	}
+	err = os.Rename(tmpDir, s.Dir())
+	if err != nil {
+		return errors.Wrap(err, "moving extracted definitions")
+	}
+	_ = os.RemoveAll(previousDir)
+
+	return nil
+}
+
+// extract writes the regular files of the gzipped tarball to dir, entries outside of dir are rejected
+func extract(bundle []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return errors.Wrap(err, "opening gzip")
+	}
+	defer gz.Close()
+
+	var extracted int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "reading tar")
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return errors.Errorf("invalid path %q", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return errors.Errorf("unsupported type of %q, only regular files are allowed", hdr.Name)
+		}
+
+		extracted += hdr.Size
+		if extracted > maxBundleSize {
+			return errors.Errorf("extracted files are larger than %d bytes", maxBundleSize)
+		}
+
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filename), 0777)
+		if err != nil {
+			return errors.Wrap(err, "creating directory")
+		}
+		err = writeFile(filename, tr, hdr.FileInfo().Mode().Perm())
+		if err != nil {
+			return errors.Wrapf(err, "writing %s", name)
+		}
+	}
+}
+
+func writeFile(filename string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package remotedefs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildBundle(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// bundleServer serves a bundle with its signature at /pipelines.tar.gz and counts the full downloads of the bundle
+type bundleServer struct {
+	mx        sync.Mutex
+	bundle    []byte
+	signature []byte
+	etag      string
+	downloads int
+}
+
+func (b *bundleServer) set(bundle []byte, privateKey ed25519.PrivateKey, etag string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.bundle = bundle
+	b.signature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, bundle)))
+	b.etag = etag
+}
+
+func (b *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch r.URL.Path {
+	case "/pipelines.tar.gz":
+		if r.Header.Get("If-None-Match") == b.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		b.downloads++
+		w.Header().Set("ETag", b.etag)
+		_, _ = w.Write(b.bundle)
+	case "/pipelines.tar.gz.sig":
+		_, _ = w.Write(b.signature)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSource_Fetch(t *testing.T) {
+	ctx := context.Background()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	bs := &bundleServer{}
+	bs.set(buildBundle(t, map[string]string{
+		"pipelines.yml":      "pipelines: {}\n",
+		"./deploy/deploy.sh": "echo deploy\n",
+	}), privateKey, `"v1"`)
+	srv := httptest.NewServer(bs)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	source, err := NewSource(srv.URL+"/pipelines.tar.gz", dir, publicKey)
+	require.NoError(t, err)
+
+	changed, err := source.Fetch(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	content, err := os.ReadFile(filepath.Join(source.Dir(), "deploy", "deploy.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, "echo deploy\n", string(content))
+
+	changed, err = source.Fetch(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed, "bundle with same ETag is not downloaded again")
+	assert.Equal(t, 1, bs.downloads)
+
+	// A new source (e.g. after a restart) uses the ETag of the extracted bundle
+	source, err = NewSource(srv.URL+"/pipelines.tar.gz", dir, publicKey)
+	require.NoError(t, err)
+	changed, err = source.Fetch(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	bs.set(buildBundle(t, map[string]string{"pipelines.yml": "pipelines: {}\n"}), privateKey, `"v2"`)
+	changed, err = source.Fetch(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.NoFileExists(t, filepath.Join(source.Dir(), "deploy", "deploy.sh"), "files of the previous bundle are removed")
+	assert.FileExists(t, filepath.Join(source.Dir(), "pipelines.yml"))
+}
+
+func TestSource_Fetch_InvalidSignature(t *testing.T) {
+	ctx := context.Background()
+
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	bs := &bundleServer{}
+	bs.set(buildBundle(t, map[string]string{"pipelines.yml": "pipelines: {}\n"}), otherPrivateKey, `"v1"`)
+	srv := httptest.NewServer(bs)
+	defer srv.Close()
+
+	source, err := NewSource(srv.URL+"/pipelines.tar.gz", t.TempDir(), publicKey)
+	require.NoError(t, err)
+
+	_, err = source.Fetch(ctx)
+	assert.EqualError(t, err, "invalid signature of bundle")
+	assert.NoDirExists(t, source.Dir())
+}
+
+func TestExtract_RejectsPathsOutsideDir(t *testing.T) {
+	for _, name := range []string{"../pipelines.yml", "/etc/pipelines.yml", "defs/../../pipelines.yml"} {
+		err := extract(buildBundle(t, map[string]string{name: "pipelines: {}\n"}), t.TempDir())
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(publicKey) + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, key)
+
+	_, err = ParsePublicKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+// Package resp is a minimal client for the Redis protocol (RESP2), it is used for the Redis integrations of prunner
+// without depending on a full Redis client.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+const defaultPort = "6379"
+
+// ErrNil is returned for a nil reply (e.g. BRPOPLPUSH timed out)
+var ErrNil = errors.New("nil reply")
+
+// Conn is a connection that sends commands and reads replies one at a time, it is not safe for concurrent use
+type Conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+// Options are the connection settings of a Redis URL
+type Options struct {
+	Address  string
+	Password string
+	// DB is the number of the database, empty for the default database
+	DB string
+}
+
+// ParseURL parses a Redis URL in the format redis://[:password@]host[:port][/db]
+func ParseURL(rawURL string) (Options, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Options{}, errors.Wrap(err, "parsing Redis URL")
+	}
+	if u.Scheme != "redis" {
+		return Options{}, errors.Errorf("unsupported Redis URL scheme %q, expected redis", u.Scheme)
+	}
+	if u.Host == "" {
+		return Options{}, errors.New("Redis URL must contain a host")
+	}
+
+	opts := Options{Address: u.Host}
+	if u.Port() == "" {
+		opts.Address = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	if u.User != nil {
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return Options{}, errors.Errorf("invalid Redis database %q", db)
+		}
+		opts.DB = db
+	}
+	return opts, nil
+}
+
+// Connect dials the server of the options, authenticates and selects the database
+func Connect(opts Options, timeout time.Duration) (*Conn, error) {
+	conn, err := Dial(opts.Address, timeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting")
+	}
+	if opts.Password != "" {
+		_, err = conn.Do(timeout, "AUTH", opts.Password)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "authenticating")
+		}
+	}
+	if opts.DB != "" {
+		_, err = conn.Do(timeout, "SELECT", opts.DB)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "selecting database")
+		}
+	}
+	return conn, nil
+}
+
+// Dial connects to the Redis server at the address
+func Dial(address string, timeout time.Duration) (*Conn, error) {
+	netConn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+	}, nil
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.netConn.Close()
+}
+
+// Do sends a command and returns the reply, which is a string, int64, []interface{} or ErrNil.
+// The deadline must cover blocking commands.
+func (c *Conn) Do(deadline time.Duration, args ...string) (interface{}, error) {
+	_ = c.netConn.SetDeadline(time.Now().Add(deadline))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.netConn.Write(buf.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "writing command")
+	}
+
+	return c.readReply()
+}
+
+func (c *Conn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing bulk string length")
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(c.reader, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading bulk string")
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing array length")
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		elements := make([]interface{}, size)
+		for i := range elements {
+			elements[i], err = c.readReply()
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	default:
+		return nil, errors.Errorf("unexpected reply %q", line)
+	}
+}
+
+func (c *Conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "reading reply")
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.Errorf("invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,98 @@
+package resp
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	opts, err := ParseURL("redis://:secret@redis.example.com/2")
+	require.NoError(t, err)
+	assert.Equal(t, Options{Address: "redis.example.com:6379", Password: "secret", DB: "2"}, opts)
+
+	opts, err = ParseURL("redis://localhost:6380")
+	require.NoError(t, err)
+	assert.Equal(t, Options{Address: "localhost:6380"}, opts)
+
+	_, err = ParseURL("rediss://localhost")
+	assert.EqualError(t, err, `unsupported Redis URL scheme "rediss", expected redis`)
+	_, err = ParseURL("redis:///2")
+	assert.EqualError(t, err, "Redis URL must contain a host")
+	_, err = ParseURL("redis://localhost/db")
+	assert.EqualError(t, err, `invalid Redis database "db"`)
+}
+
+// TestConn_Redis checks the client against a real Redis server, it is only run if PRUNNER_TEST_REDIS_URL is set
+// (e.g. redis://localhost:6379/15, the database must not be used otherwise)
+func TestConn_Redis(t *testing.T) {
+	rawURL := os.Getenv("PRUNNER_TEST_REDIS_URL")
+	if rawURL == "" {
+		t.Skip("PRUNNER_TEST_REDIS_URL is not set")
+	}
+	opts, err := ParseURL(rawURL)
+	require.NoError(t, err)
+
+	conn, err := Connect(opts, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	key := fmt.Sprintf("prunner-test:%d", time.Now().UnixNano())
+	defer func() {
+		_, _ = conn.Do(time.Second, "DEL", key, key+":list", key+":processing")
+	}()
+
+	reply, err := conn.Do(time.Second, "PING")
+	require.NoError(t, err)
+	assert.Equal(t, "PONG", reply)
+
+	// Bulk strings are binary safe
+	value := "line 1\r\nline 2 with ümlauts\x00"
+	reply, err = conn.Do(time.Second, "SET", key, value)
+	require.NoError(t, err)
+	assert.Equal(t, "OK", reply)
+	reply, err = conn.Do(time.Second, "GET", key)
+	require.NoError(t, err)
+	assert.Equal(t, value, reply)
+
+	reply, err = conn.Do(time.Second, "DEL", key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reply)
+	_, err = conn.Do(time.Second, "GET", key)
+	assert.ErrorIs(t, err, ErrNil)
+
+	// Missing fields are nil elements of arrays
+	_, err = conn.Do(time.Second, "HSET", key, "version", "1")
+	require.NoError(t, err)
+	reply, err = conn.Do(time.Second, "HMGET", key, "version", "value")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"1", nil}, reply)
+
+	reply, err = conn.Do(time.Second, "EVAL", "return {1, 'two', {3}}", "0")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1), "two", []interface{}{int64(3)}}, reply)
+
+	// Errors are returned and the connection is still usable
+	_, err = conn.Do(time.Second, "LPUSH", key, "value")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "WRONGTYPE")
+
+	_, err = conn.Do(time.Second, "LPUSH", key+":list", "first", "second")
+	require.NoError(t, err)
+	reply, err = conn.Do(2*time.Second, "BRPOPLPUSH", key+":list", key+":processing", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "first", reply)
+	reply, err = conn.Do(time.Second, "LRANGE", key+":processing", "0", "-1")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"first"}, reply)
+
+	// A blocking command that times out returns a nil array
+	_, err = conn.Do(time.Second, "RPOP", key+":list")
+	require.NoError(t, err)
+	_, err = conn.Do(2*time.Second, "BRPOPLPUSH", key+":list", key+":processing", "1")
+	assert.ErrorIs(t, err, ErrNil)
+}
@@ -0,0 +1,81 @@
+// Package sdnotify implements the systemd service notification protocol (see sd_notify(3)) for Type=notify units
+// and the service watchdog.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	// Ready tells systemd that the service startup is finished
+	Ready = "READY=1"
+	// Stopping tells systemd that the service is beginning its shutdown
+	Stopping = "STOPPING=1"
+	// Watchdog keeps the service alive if the watchdog is enabled
+	Watchdog = "WATCHDOG=1"
+)
+
+// MainPID tells systemd that another process is the main process of the service (e.g. after a hand-off)
+func MainPID(pid int) string {
+	return fmt.Sprintf("MAINPID=%d", pid)
+}
+
+// Notify sends a state to the socket of the service manager in NOTIFY_SOCKET.
+// It returns false if the process was not started by a service manager with notification support.
+func Notify(state string) (bool, error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return false, nil
+	}
+	// Abstract sockets are given with a leading @
+	if socketAddr[0] == '@' {
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return false, errors.Wrap(err, "connecting to notify socket")
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return false, errors.Wrap(err, "writing to notify socket")
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval for sending watchdog notifications (half of the timeout configured by
+// WatchdogSec in the unit). It returns false if the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool, error) {
+	usecValue := os.Getenv("WATCHDOG_USEC")
+	if usecValue == "" {
+		return 0, false, nil
+	}
+	// The watchdog could be meant for another process (e.g. a parent shell)
+	if pidValue := os.Getenv("WATCHDOG_PID"); pidValue != "" {
+		pid, err := strconv.Atoi(pidValue)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "parsing WATCHDOG_PID")
+		}
+		if pid != os.Getpid() {
+			return 0, false, nil
+		}
+	}
+
+	usec, err := strconv.ParseInt(usecValue, 10, 64)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "parsing WATCHDOG_USEC")
+	}
+	if usec <= 0 {
+		return 0, false, errors.Errorf("invalid WATCHDOG_USEC %d", usec)
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2, true, nil
+}
@@ -0,0 +1,326 @@
+// Package sentry reports errors logged by prunner and (optionally) failed tasks with an excerpt of their output to
+// Sentry (https://sentry.io). It implements sending events to the store endpoint of the Sentry API with a DSN, so no
+// SDK is needed.
+package sentry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+const (
+	// queueSize is the number of events that are buffered while sending, further events are dropped
+	queueSize = 100
+	// sendTimeout is the timeout for sending a single event
+	sendTimeout = 10 * time.Second
+	// maxLineLength is the maximum length of a line of the output of a failed task, the excerpt ends at longer lines
+	maxLineLength = 64 * 1024
+)
+
+// Event is sent as JSON to the store endpoint (see https://develop.sentry.dev/sdk/event-payloads/)
+type Event struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger"`
+	Message     string                 `json:"message"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	Fingerprint []string               `json:"fingerprint,omitempty"`
+}
+
+// Client sends events to Sentry asynchronously
+type Client struct {
+	endpoint    string
+	authHeader  string
+	environment string
+	serverName  string
+	httpClient  *http.Client
+
+	// mx guards queuing events and closing, so events after Close are ignored
+	mx     sync.Mutex
+	closed bool
+	events chan *Event
+	done   chan struct{}
+}
+
+// New builds a client for the DSN (https://<key>@<host>/<project>), environment is added to all events if set
+func New(dsn string, environment string) (*Client, error) {
+	endpoint, authHeader, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	serverName, _ := os.Hostname()
+
+	c := &Client{
+		endpoint:    endpoint,
+		authHeader:  authHeader,
+		environment: environment,
+		serverName:  serverName,
+		httpClient:  &http.Client{Timeout: sendTimeout},
+		events:      make(chan *Event, queueSize),
+		done:        make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+// parseDSN returns the URL of the store endpoint and the value of the X-Sentry-Auth header
+func parseDSN(dsn string) (endpoint string, authHeader string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid Sentry DSN")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", errors.Errorf("invalid Sentry DSN: unsupported scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("invalid Sentry DSN: missing public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	projectID := path[i+1:]
+	if projectID == "" {
+		return "", "", errors.New("invalid Sentry DSN: missing project ID")
+	}
+
+	endpoint = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:i], projectID)
+	authHeader = fmt.Sprintf("Sentry sentry_version=7, sentry_client=prunner/1.0, sentry_key=%s", u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		authHeader += ", sentry_secret=" + secret
+	}
+
+	return endpoint, authHeader, nil
+}
+
+// Capture queues the event for sending without blocking, missing fields (ID, timestamp, ...) are set.
+// The event is dropped if the queue is full.
+func (c *Client) Capture(e *Event) {
+	if e.EventID == "" {
+		id := uuid.Must(uuid.NewV4())
+		e.EventID = hex.EncodeToString(id.Bytes())
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	if e.Platform == "" {
+		e.Platform = "go"
+	}
+	if e.Level == "" {
+		e.Level = "error"
+	}
+	if e.Logger == "" {
+		e.Logger = "prunner"
+	}
+	if e.ServerName == "" {
+		e.ServerName = c.serverName
+	}
+	if e.Environment == "" {
+		e.Environment = c.environment
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.events <- e:
+	default:
+		// Warnings are not reported, so this does not add more events
+		log.
+			WithField("component", "sentry").
+			Warn("Queue of Sentry events is full, dropping event")
+	}
+}
+
+// Close sends the queued events, later events are ignored.
+// Events that are not sent until the timeout are dropped.
+func (c *Client) Close(timeout time.Duration) error {
+	c.mx.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.events)
+	}
+	c.mx.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout sending queued Sentry events")
+	}
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	for e := range c.events {
+		err := c.send(e)
+		if err != nil {
+			log.
+				WithField("component", "sentry").
+				WithError(err).
+				Warn("Error sending event to Sentry")
+		}
+	}
+}
+
+func (c *Client) send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encoding event")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.authHeader)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Handler returns a log handler that passes entries to next and reports entries with level error or fatal
+func (c *Client) Handler(next log.Handler) log.Handler {
+	return log.HandlerFunc(func(entry *log.Entry) error {
+		if entry.Level >= log.ErrorLevel {
+			c.Capture(eventFromLogEntry(entry))
+		}
+		return next.HandleLog(entry)
+	})
+}
+
+func eventFromLogEntry(entry *log.Entry) *Event {
+	e := &Event{
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Extra:   make(map[string]interface{}, len(entry.Fields)),
+	}
+	if !entry.Timestamp.IsZero() {
+		e.Timestamp = entry.Timestamp.UTC()
+	}
+	for name, value := range entry.Fields {
+		// Errors (and other values without JSON representation) are sent as their string
+		switch v := value.(type) {
+		case error:
+			e.Extra[name] = v.Error()
+		case fmt.Stringer:
+			e.Extra[name] = v.String()
+		default:
+			e.Extra[name] = v
+		}
+	}
+	if component, ok := entry.Fields["component"].(string); ok {
+		e.Tags = map[string]string{"component": component}
+	}
+	return e
+}
+
+// TaskFailureReporter reports each errored task of a finished job with the last lines of its output (stderr, or
+// stdout if stderr is empty), it can be used as a subscription of the runner. Canceled jobs are not reported.
+func (c *Client) TaskFailureReporter(outputStore taskctl.OutputStore, excerptLines int) func(e prunner.JobCompleted) {
+	return func(e prunner.JobCompleted) {
+		job := e.Job
+		if job.Canceled {
+			return
+		}
+		for _, t := range job.Tasks {
+			if !t.Errored || t.Canceled {
+				continue
+			}
+			event := &Event{
+				Message: fmt.Sprintf("Task %s of pipeline %s failed", t.Name, job.Pipeline),
+				Tags: map[string]string{
+					"pipeline":  job.Pipeline,
+					"task":      t.Name,
+					"exit_code": fmt.Sprintf("%d", t.ExitCode),
+				},
+				Extra: map[string]interface{}{
+					"job_id": job.ID.String(),
+				},
+				// Group failures of the same task instead of by the message
+				Fingerprint: []string{"task-failure", job.Pipeline, t.Name},
+			}
+			if t.Error != nil {
+				event.Extra["error"] = t.Error.Error()
+			}
+
+			// Reading the output must not block the runner
+			jobID, taskName := job.ID.String(), t.Name
+			go func() {
+				if excerpt := outputExcerpt(outputStore, jobID, taskName, excerptLines); excerpt != "" {
+					event.Extra["output"] = excerpt
+				}
+				c.Capture(event)
+			}()
+		}
+	}
+}
+
+// outputExcerpt returns the last lines of stderr of the task or of stdout if stderr is empty
+func outputExcerpt(outputStore taskctl.OutputStore, jobID, taskName string, lines int) string {
+	if lines <= 0 {
+		return ""
+	}
+	for _, outputName := range []string{"stderr", "stdout"} {
+		if excerpt := lastLines(outputStore, jobID, taskName, outputName, lines); excerpt != "" {
+			return excerpt
+		}
+	}
+	return ""
+}
+
+func lastLines(outputStore taskctl.OutputStore, jobID, taskName, outputName string, n int) string {
+	r, err := outputStore.Reader(jobID, taskName, outputName)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+
+	var result []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineLength)
+	for scanner.Scan() {
+		result = append(result, scanner.Text())
+		if len(result) > n {
+			result = result[1:]
+		}
+	}
+	return strings.Join(result, "\n")
+}
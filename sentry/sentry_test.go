@@ -0,0 +1,132 @@
+package sentry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+type receivedEvent struct {
+	authHeader string
+	event      Event
+}
+
+// listenFakeSentry accepts events on the store endpoint of project 42 and sends them to the channel
+func listenFakeSentry(t *testing.T) (string, <-chan receivedEvent) {
+	t.Helper()
+
+	events := make(chan receivedEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/store/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- receivedEvent{authHeader: r.Header.Get("X-Sentry-Auth"), event: e}
+	}))
+	t.Cleanup(srv.Close)
+
+	dsn := strings.Replace(srv.URL, "http://", "http://public-key@", 1) + "/42"
+	return dsn, events
+}
+
+func receiveEvent(t *testing.T, events <-chan receivedEvent) receivedEvent {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("expected event")
+		return receivedEvent{}
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	endpoint, authHeader, err := parseDSN("https://abc@o123.ingest.sentry.io/prefix/456")
+	require.NoError(t, err)
+	assert.Equal(t, "https://o123.ingest.sentry.io/prefix/api/456/store/", endpoint)
+	assert.Equal(t, "Sentry sentry_version=7, sentry_client=prunner/1.0, sentry_key=abc", authHeader)
+
+	for _, dsn := range []string{"ftp://abc@example.com/1", "https://example.com/1", "https://abc@example.com/"} {
+		_, _, err := parseDSN(dsn)
+		assert.Error(t, err, dsn)
+	}
+}
+
+func TestClient_Handler(t *testing.T) {
+	dsn, events := listenFakeSentry(t)
+
+	c, err := New(dsn, "test")
+	require.NoError(t, err)
+
+	var handled []string
+	logger := &log.Logger{
+		Level: log.InfoLevel,
+		Handler: c.Handler(log.HandlerFunc(func(entry *log.Entry) error {
+			handled = append(handled, entry.Message)
+			return nil
+		})),
+	}
+	logger.Info("Started")
+	logger.
+		WithField("component", "runner").
+		WithError(fmt.Errorf("disk full")).
+		Error("Error saving job state")
+
+	require.NoError(t, c.Close(time.Second))
+
+	assert.Equal(t, []string{"Started", "Error saving job state"}, handled)
+
+	received := receiveEvent(t, events)
+	assert.Equal(t, "Sentry sentry_version=7, sentry_client=prunner/1.0, sentry_key=public-key", received.authHeader)
+	assert.Equal(t, "error", received.event.Level)
+	assert.Equal(t, "test", received.event.Environment)
+	assert.Equal(t, "Error saving job state", received.event.Message)
+	assert.Equal(t, map[string]string{"component": "runner"}, received.event.Tags)
+	assert.Equal(t, "disk full", received.event.Extra["error"])
+	assert.Len(t, received.event.EventID, 32)
+
+	select {
+	case e := <-events:
+		t.Fatalf("expected only one event, got %v", e.event)
+	default:
+	}
+}
+
+func TestOutputExcerpt(t *testing.T) {
+	outputStore, err := taskctl.NewOutputStore(t.TempDir())
+	require.NoError(t, err)
+
+	jobID := uuid.Must(uuid.NewV4()).String()
+	writeOutput := func(taskName, outputName, output string) {
+		w, err := outputStore.Writer(jobID, taskName, outputName)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(output))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	writeOutput("build", "stdout", "compiling\n")
+	writeOutput("build", "stderr", "warning: foo\nwarning: bar\nerror: baz\n")
+	writeOutput("test", "stdout", "running\nFAIL\n")
+
+	assert.Equal(t, "warning: bar\nerror: baz", outputExcerpt(outputStore, jobID, "build", 2))
+	// The excerpt falls back to stdout if there is no output on stderr
+	assert.Equal(t, "running\nFAIL", outputExcerpt(outputStore, jobID, "test", 5))
+	assert.Equal(t, "", outputExcerpt(outputStore, jobID, "build", 0))
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Flowpack/prunner/agent"
+)
+
+// WithCoordinator serves the endpoints for agents that run tasks on other hosts
+func WithCoordinator(coordinator *agent.Coordinator) Opts {
+	return func(s *server) {
+		s.coordinator = coordinator
+	}
+}
+
+func (s *server) agentRoutes(r chi.Router) {
+	r.Get("/", s.agentsList)
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireScope(ScopeAgent))
+		r.Post("/poll", s.agentsPoll)
+		r.Post("/assignments/{id}/heartbeat", s.agentsHeartbeat)
+		r.Post("/assignments/{id}/result", s.agentsResult)
+	})
+}
+
+// swagger:response
+type agentsListResponse struct {
+	// in: body
+	Body struct {
+		Agents []agent.Info `json:"agents"`
+	}
+}
+
+// swagger:route GET /agents agentsList
+//
+// List agents
+//
+// Lists agents that polled for assignments and agents with pending assignments.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: agentsListResponse
+func (s *server) agentsList(w http.ResponseWriter, r *http.Request) {
+	var resp agentsListResponse
+	resp.Body.Agents = s.coordinator.Agents()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// agentsPoll returns the next assignment for the agent with the name in the query (200) or 204 if no assignment was
+// available before the poll timeout. Requires the agent scope.
+func (s *server) agentsPoll(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Missing name parameter")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), agent.PollTimeout)
+	defer cancel()
+
+	assignment := s.coordinator.Poll(ctx, name)
+	if assignment == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(assignment)
+}
+
+// agentsHeartbeat appends the output of a running assignment. It responds with 404 if the assignment is unknown or was
+// canceled, the agent stops the task then. Requires the agent scope.
+func (s *server) agentsHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var heartbeat agent.Heartbeat
+	err := json.NewDecoder(r.Body).Decode(&heartbeat)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidBody, fmt.Sprintf("Error decoding JSON: %v", err))
+		return
+	}
+
+	err = s.coordinator.Heartbeat(chi.URLParam(r, "id"), heartbeat.Stdout, heartbeat.Stderr)
+	s.sendAgentResponse(w, err)
+}
+
+// agentsResult reports the result of an assignment. Requires the agent scope.
+func (s *server) agentsResult(w http.ResponseWriter, r *http.Request) {
+	var result agent.Result
+	err := json.NewDecoder(r.Body).Decode(&result)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidBody, fmt.Sprintf("Error decoding JSON: %v", err))
+		return
+	}
+
+	err = s.coordinator.Complete(chi.URLParam(r, "id"), result)
+	s.sendAgentResponse(w, err)
+}
+
+func (s *server) sendAgentResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, agent.ErrAssignmentNotFound):
+		s.sendError(w, http.StatusNotFound, ProblemAssignmentNotFound, "Assignment not found")
+	case err != nil:
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, fmt.Sprintf("Error handling assignment: %v", err))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
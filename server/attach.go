@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/taskctl"
+	"github.com/Flowpack/prunner/websocket"
+)
+
+// ScopeAdmin allows controlling running tasks (attaching and writing to stdin), deleting jobs and using the admin
+// endpoints (/admin/...)
+const ScopeAdmin = "admin"
+
+// attachMessage is a message of the attach protocol, it is sent as JSON in WebSocket text messages
+type attachMessage struct {
+	// Type is stdout, stderr, exit or error (sent by prunner) or stdin, close_stdin or resize (sent by the client)
+	Type string `json:"type"`
+	// Data is the output of the task or input for the task
+	Data string `json:"data,omitempty"`
+	// Message describes an error
+	Message string `json:"message,omitempty"`
+	// Cols and Rows are the terminal size of a resize message
+	Cols int `json:"cols,omitempty"`
+	Rows int `json:"rows,omitempty"`
+}
+
+// swagger:parameters jobAttach
+type jobAttachParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+
+	// Task name
+	//
+	// required: true
+	// in: query
+	// example: deploy
+	Task string `json:"task"`
+}
+
+// swagger:route GET /v1/job/attach jobAttach
+//
+// Attach to a running task
+//
+// Upgrades the connection to a WebSocket that streams the output of a running task and sends input to interactive
+// tasks. Requires the admin scope. The token can also be passed as query parameter "jwt".
+//
+//     Responses:
+//       101:
+//       400: genericErrorResponse
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) jobAttach(w http.ResponseWriter, r *http.Request) {
+	var params jobAttachParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+	params.Task = vars.Get("task")
+	if params.Task == "" {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid task name")
+		return
+	}
+
+	chunks, unsubscribe, err := s.pRunner.SubscribeTaskOutput(jobID, params.Task)
+	if errors.Is(err, taskctl.ErrTaskNotRunning) {
+		s.sendError(w, http.StatusNotFound, ProblemTaskNotRunning, "Task is not running")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			WithField("task", params.Task).
+			Errorf("Error attaching to task")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error attaching to task")
+		return
+	}
+	defer unsubscribe()
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	logger := log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		WithField("task", params.Task)
+	logger.Info("Attached to task")
+	defer logger.Info("Detached from task")
+
+	// Input is read in a separate goroutine, unsubscribing ends the output loop if the client disconnects
+	go func() {
+		defer unsubscribe()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if errMsg := s.handleAttachInput(jobID, params.Task, data); errMsg != "" {
+				_ = s.writeAttachMessage(conn, attachMessage{Type: "error", Message: errMsg})
+			}
+		}
+	}()
+
+	for chunk := range chunks {
+		err := s.writeAttachMessage(conn, attachMessage{Type: chunk.Stream, Data: string(chunk.Data)})
+		if err != nil {
+			return
+		}
+	}
+	_ = s.writeAttachMessage(conn, attachMessage{Type: "exit"})
+}
+
+// handleAttachInput handles a message of the client and returns an error message for the client if it failed
+func (s *server) handleAttachInput(jobID uuid.UUID, task string, data []byte) string {
+	var msg attachMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return "Invalid message"
+	}
+
+	var err error
+	switch msg.Type {
+	case "stdin":
+		err = s.pRunner.WriteTaskInput(jobID, task, []byte(msg.Data))
+	case "close_stdin":
+		err = s.pRunner.CloseTaskInput(jobID, task)
+	case "resize":
+		// Tasks are not executed in a terminal, so the size cannot be applied
+		log.
+			WithField("component", "api").
+			WithField("jobID", jobID).
+			WithField("task", task).
+			Debugf("Ignoring resize to %dx%d", msg.Cols, msg.Rows)
+	default:
+		return "Unknown message type"
+	}
+
+	if errors.Is(err, taskctl.ErrNoTaskInput) {
+		return "Task is not running or not interactive"
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			WithField("task", task).
+			Warn("Error writing to stdin of attached task")
+		return "Error writing to stdin of task"
+	}
+	return ""
+}
+
+func (s *server) writeAttachMessage(conn *websocket.Conn, msg attachMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/Flowpack/prunner"
+)
+
+type badgeStatus struct {
+	text  string
+	color string
+}
+
+var (
+	badgePassing  = badgeStatus{text: "passing", color: "#4c1"}
+	badgeFailing  = badgeStatus{text: "failing", color: "#e05d44"}
+	badgeRunning  = badgeStatus{text: "running", color: "#007ec6"}
+	badgeCanceled = badgeStatus{text: "canceled", color: "#9f9f9f"}
+	badgeUnknown  = badgeStatus{text: "unknown", color: "#9f9f9f"}
+)
+
+// swagger:parameters pipelinesBadge
+type pipelinesBadgeParams struct {
+	// Pipeline name
+	//
+	// required: true
+	// in: path
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+
+	// JWT for embedding the badge as an image (instead of the Authorization header), it must only have the scope
+	// pipelines:badge
+	//
+	// in: query
+	Jwt string `json:"jwt"`
+}
+
+// swagger:route GET /v1/pipelines/{pipeline}/badge.svg pipelinesBadge
+//
+// Get a status badge of a pipeline
+//
+// Returns an SVG badge with the status of the last started job of the pipeline (passing, failing, running, canceled
+// or unknown if no job was started). A token with only the scope pipelines:badge can be passed as query parameter jwt
+// for embedding the badge.
+//
+//     Produces:
+//     - image/svg+xml
+//
+//     Responses:
+//       200:
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesBadge(w http.ResponseWriter, r *http.Request) {
+	var params pipelinesBadgeParams
+	params.Pipeline = chi.URLParam(r, "pipeline")
+
+	// Tokens in the query string end up in READMEs and logs, so only badge tokens without other privileges are accepted
+	if jwtauth.TokenFromHeader(r) == "" {
+		_, claims, _ := jwtauth.FromContext(r.Context())
+		if !isBadgeToken(claims) {
+			s.sendError(w, http.StatusForbidden, ProblemMissingScope, fmt.Sprintf("Tokens passed as query parameter must only have the scope %s", ScopeBadge))
+			return
+		}
+	}
+
+	if !s.pipelineExists(params.Pipeline) {
+		s.sendError(w, http.StatusNotFound, ProblemPipelineNotFound, "Pipeline not found")
+		return
+	}
+
+	status := badgeUnknown
+	found := false
+	s.pRunner.ListJobs(prunner.ListJobsOpts{Pipeline: params.Pipeline}, func(j *prunner.PipelineJob) {
+		// Jobs are listed from the newest to the oldest, queued jobs are skipped
+		if found || j.Start == nil {
+			return
+		}
+		found = true
+		status = badgeStatusOfJob(j)
+	})
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// Badges are embedded in pages, so they must not be cached by browsers or image proxies
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(renderBadge(params.Pipeline, status))
+}
+
+func (s *server) pipelineExists(pipeline string) bool {
+	for _, p := range s.pRunner.ListPipelines() {
+		if p.Pipeline == pipeline {
+			return true
+		}
+	}
+	return false
+}
+
+func badgeStatusOfJob(j *prunner.PipelineJob) badgeStatus {
+	result := jobToResult(j)
+	switch {
+	case result.Canceled:
+		return badgeCanceled
+	case result.Incomplete, result.Errored, result.LastError != nil:
+		return badgeFailing
+	case result.Completed:
+		return badgePassing
+	}
+	return badgeRunning
+}
+
+const badgeCharWidth = 7
+
+// renderBadge renders a flat badge with the label on the left and the status on the right
+func renderBadge(label string, status badgeStatus) []byte {
+	labelWidth := len(label)*badgeCharWidth + 10
+	statusWidth := len(status.text)*badgeCharWidth + 10
+	width := labelWidth + statusWidth
+	escapedLabel := html.EscapeString(label)
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<rect width="%[2]d" height="20" fill="#555"/>
+<rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, width, labelWidth, statusWidth, escapedLabel, status.text, status.color, labelWidth/2, labelWidth+statusWidth/2))
+}
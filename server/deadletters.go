@@ -0,0 +1,166 @@
+package server
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/Flowpack/prunner/exechook"
+)
+
+// swagger:model deadLetter
+type deadLetterResult struct {
+	// Dead letter id
+	// example: 0d6e1c3a-1e7f-4f6b-9a59-3f0f2b8d6c11
+	ID string `json:"id"`
+	// Id of the job the notification was sent for
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	JobID string `json:"jobId"`
+	// Pipeline of the job
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+	// Hook command the notification was sent to
+	// example: ./hooks/notify.sh
+	Command string `json:"command"`
+	// Number of failed attempts (including redeliveries)
+	// example: 4
+	Attempts int `json:"attempts"`
+	// Error of the last attempt
+	LastError string `json:"lastError"`
+	// When the last attempt failed
+	Failed time.Time `json:"failed"`
+	// Job as passed to the hook command
+	Payload stdjson.RawMessage `json:"payload"`
+}
+
+// swagger:response
+type adminDeadLettersResponse struct {
+	// in: body
+	Body struct {
+		DeadLetters []deadLetterResult `json:"deadLetters"`
+	}
+}
+
+// swagger:route GET /v1/admin/dead-letters adminDeadLetters
+//
+// List dead letters
+//
+// Lists notifications of the on-complete hook that failed after all retries (oldest first).
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: adminDeadLettersResponse
+//       403: genericErrorResponse
+//       500: genericErrorResponse
+func (s *server) adminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.deadLetters.List()
+	if err != nil {
+		log.
+			WithError(err).
+			Errorf("Error listing dead letters")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error listing dead letters")
+		return
+	}
+
+	var resp adminDeadLettersResponse
+	resp.Body.DeadLetters = make([]deadLetterResult, len(letters))
+	for i, letter := range letters {
+		resp.Body.DeadLetters[i] = deadLetterResult{
+			ID:        letter.ID,
+			JobID:     letter.JobID,
+			Pipeline:  letter.Pipeline,
+			Command:   letter.Command,
+			Attempts:  letter.Attempts,
+			LastError: letter.LastError,
+			Failed:    letter.Failed,
+			Payload:   letter.Payload,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters adminDeadLettersRedeliver adminDeadLettersDelete
+type adminDeadLetterParams struct {
+	// Dead letter id
+	//
+	// required: true
+	// in: query
+	// example: 0d6e1c3a-1e7f-4f6b-9a59-3f0f2b8d6c11
+	Id string `json:"id"`
+}
+
+// swagger:route POST /v1/admin/dead-letters/redeliver adminDeadLettersRedeliver
+//
+// Redeliver a dead letter
+//
+// Runs the hook command of a dead letter again. The dead letter is removed if the command succeeds, otherwise the
+// status is 502 and the dead letter is kept with the new error.
+//
+//     Responses:
+//       204:
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+//       502: genericErrorResponse
+func (s *server) adminDeadLettersRedeliver(w http.ResponseWriter, r *http.Request) {
+	var params adminDeadLetterParams
+	params.Id = r.URL.Query().Get("id")
+
+	err := s.deadLetters.Redeliver(params.Id, s.deadLetterTimeout)
+	if errors.Is(err, exechook.ErrDeadLetterNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemDeadLetterNotFound, "Dead letter not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("deadLetterID", params.Id).
+			Warn("Redelivery of dead letter failed")
+		s.sendError(w, http.StatusBadGateway, ProblemRedeliveryFailed, fmt.Sprintf("Redelivery failed: %v", err))
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("deadLetterID", params.Id).
+		Info("Dead letter redelivered")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:route DELETE /v1/admin/dead-letters adminDeadLettersDelete
+//
+// Delete a dead letter
+//
+// Removes a dead letter without redelivering it, e.g. after it was handled manually.
+//
+//     Responses:
+//       204:
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) adminDeadLettersDelete(w http.ResponseWriter, r *http.Request) {
+	var params adminDeadLetterParams
+	params.Id = r.URL.Query().Get("id")
+
+	err := s.deadLetters.Remove(params.Id)
+	if errors.Is(err, exechook.ErrDeadLetterNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemDeadLetterNotFound, "Dead letter not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("deadLetterID", params.Id).
+			Errorf("Error removing dead letter")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error removing dead letter")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
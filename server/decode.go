@@ -0,0 +1,37 @@
+package server
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is the default maximum size of JSON request bodies (1 MiB)
+const DefaultMaxBodySize = 1 << 20
+
+// decodeJSONBody decodes the JSON request body into v. Unknown fields (e.g. typos) and bodies larger than the maximum
+// body size are rejected. If the body is invalid, an error is sent and false is returned.
+func (s *server) decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	data, err := io.ReadAll(io.LimitReader(r.Body, s.maxBodySize+1))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidBody, fmt.Sprintf("Error reading body: %v", err))
+		return false
+	}
+	if int64(len(data)) > s.maxBodySize {
+		s.sendError(w, http.StatusRequestEntityTooLarge, ProblemBodyTooLarge, fmt.Sprintf("Body exceeds the maximum size of %d bytes", s.maxBodySize))
+		return false
+	}
+
+	// The standard library reports unknown fields with their name only, which is easier to understand
+	dec := stdjson.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidBody, fmt.Sprintf("Error decoding JSON: %s", strings.TrimPrefix(err.Error(), "json: ")))
+		return false
+	}
+	return true
+}
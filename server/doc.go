@@ -2,6 +2,8 @@
 //
 // A REST API for scheduling pipelines and managing jobs in prunner, an embedded task runner.
 //
+// Routes are versioned under /v1, the unversioned paths are deprecated aliases of the first version.
+//
 //     Schemes: http
 //     Host: localhost:8080
 //     BasePath: /
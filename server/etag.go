@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// jobsETag builds a strong ETag for a job listing from the version of the job state and the listed pipelines, since
+// pipelines also change without a change of jobs (e.g. after reloading definitions or when a schedule window closes)
+func jobsETag(stateVersion string, pipelines []pipelineResult) string {
+	h := fnv.New64a()
+	_ = json.NewEncoder(h).Encode(pipelines)
+	return fmt.Sprintf(`"%s-%x"`, stateVersion, h.Sum64())
+}
+
+// etagMatches checks if the value of an If-None-Match header matches the ETag (weak comparison, see RFC 7232)
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
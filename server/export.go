@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatCSV    = "csv"
+
+	// exportFlushInterval is the number of exported jobs after which the output is flushed to the client
+	exportFlushInterval = 100
+)
+
+// jobExporter writes job results in an export format
+type jobExporter interface {
+	Export(job pipelineJobResult) error
+	// Flush writes buffered data to the underlying writer
+	Flush()
+}
+
+type ndjsonJobExporter struct {
+	w *bufio.Writer
+}
+
+func newNDJSONJobExporter(w io.Writer) *ndjsonJobExporter {
+	return &ndjsonJobExporter{w: bufio.NewWriter(w)}
+}
+
+func (e *ndjsonJobExporter) Export(job pipelineJobResult) error {
+	// Encode writes a newline after each value
+	return json.NewEncoder(e.w).Encode(job)
+}
+
+func (e *ndjsonJobExporter) Flush() {
+	_ = e.w.Flush()
+}
+
+var csvJobExportHeader = []string{
+	"id",
+	"pipeline",
+	"created",
+	"start",
+	"end",
+	"completed",
+	"canceled",
+	"incomplete",
+	"errored",
+	"lastError",
+	"user",
+	"priority",
+	"definitionHash",
+	"labels",
+	"variables",
+}
+
+type csvJobExporter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVJobExporter(w io.Writer) *csvJobExporter {
+	return &csvJobExporter{w: csv.NewWriter(w)}
+}
+
+func (e *csvJobExporter) Export(job pipelineJobResult) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	labels, err := encodeCSVObject(job.Labels, len(job.Labels) == 0)
+	if err != nil {
+		return err
+	}
+	variables, err := encodeCSVObject(job.Variables, len(job.Variables) == 0)
+	if err != nil {
+		return err
+	}
+	var lastError string
+	if job.LastError != nil {
+		lastError = *job.LastError
+	}
+
+	return e.w.Write([]string{
+		job.ID,
+		job.Pipeline,
+		formatCSVTime(&job.Created),
+		formatCSVTime(job.Start),
+		formatCSVTime(job.End),
+		strconv.FormatBool(job.Completed),
+		strconv.FormatBool(job.Canceled),
+		strconv.FormatBool(job.Incomplete),
+		strconv.FormatBool(job.Errored),
+		lastError,
+		job.User,
+		job.Priority,
+		job.DefinitionHash,
+		labels,
+		variables,
+	})
+}
+
+// Flush writes the header (also for an empty export) and buffered rows
+func (e *csvJobExporter) Flush() {
+	_ = e.writeHeader()
+	e.w.Flush()
+}
+
+func (e *csvJobExporter) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	return e.w.Write(csvJobExportHeader)
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func encodeCSVObject(v interface{}, empty bool) (string, error) {
+	if empty {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Flowpack/prunner"
+)
+
+// parseJobSelector parses label and variable selectors in the form name=value or name
+func parseJobSelector(labels []string, variables []string) (prunner.JobSelector, error) {
+	var (
+		selector prunner.JobSelector
+		err      error
+	)
+	selector.Labels, err = parseSelectors(labels)
+	if err != nil {
+		return selector, fmt.Errorf("invalid label selector: %w", err)
+	}
+	selector.Variables, err = parseSelectors(variables)
+	if err != nil {
+		return selector, fmt.Errorf("invalid variable selector: %w", err)
+	}
+	return selector, nil
+}
+
+func parseSelectors(values []string) ([]prunner.Selector, error) {
+	var selectors []prunner.Selector
+	for _, v := range values {
+		name, value, hasValue := strings.Cut(v, "=")
+		if name == "" {
+			return nil, fmt.Errorf("name of %q must not be empty", v)
+		}
+		selectors = append(selectors, prunner.Selector{Name: name, Value: value, HasValue: hasValue})
+	}
+	return selectors, nil
+}
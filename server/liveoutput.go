@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/gofrs/uuid"
+)
+
+// swagger:parameters jobLiveOutput
+type jobLiveOutputParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+
+	// Task name
+	//
+	// required: true
+	// in: query
+	// example: deploy
+	Task string `json:"task"`
+}
+
+// swagger:response
+type jobLiveOutputResponse struct {
+	// in: body
+	Body struct {
+		// Last lines of STDOUT of the task
+		Stdout []string `json:"stdout"`
+		// Last lines of STDERR of the task
+		Stderr []string `json:"stderr"`
+	}
+}
+
+// swagger:route GET /v1/job/live-output jobLiveOutput
+//
+// Get the live output of a running task
+//
+// Returns the last lines of STDOUT / STDERR of a running task that are kept in memory (see --live-output-lines). The
+// full output of a task is read with jobLogs.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: jobLiveOutputResponse
+//       400: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) jobLiveOutput(w http.ResponseWriter, r *http.Request) {
+	var params jobLiveOutputParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+	params.Task = vars.Get("task")
+	if params.Task == "" {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid task name")
+		return
+	}
+
+	var resp jobLiveOutputResponse
+	var ok bool
+	resp.Body.Stdout, ok = s.pRunner.CurrentTaskOutput(jobID, params.Task, "stdout")
+	if !ok {
+		s.sendError(w, http.StatusNotFound, ProblemTaskNotRunning, "Task is not running or live output is disabled")
+		return
+	}
+	// The task could have finished in between, then only the lines of stdout are returned
+	resp.Body.Stderr, _ = s.pRunner.CurrentTaskOutput(jobID, params.Task, "stderr")
+	if resp.Body.Stderr == nil {
+		resp.Body.Stderr = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
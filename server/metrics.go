@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Flowpack/prunner"
+)
+
+// swagger:route GET /metrics metrics
+//
+// Get metrics in the Prometheus text format
+//
+// Exposes the gauge prunner_pipeline_alert for all alerts configured in pipeline definitions, the value is 1 while
+// the alert is active and 0 otherwise. For schedule quotas the gauges prunner_pipeline_quota_used and
+// prunner_pipeline_quota_max and the counter prunner_pipeline_quota_exceeded_total are exposed. The resource usage of
+// finished tasks since the start is exposed by pipeline and task with the counters prunner_task_runs_total,
+// prunner_task_wall_seconds_total and prunner_task_cpu_seconds_total and the gauge prunner_task_max_rss_bytes.
+//
+//     Produces:
+//     - text/plain
+//
+//     Responses:
+//       200:
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("# HELP prunner_pipeline_alert Alert of a pipeline (1 if the alert is active).\n")
+	b.WriteString("# TYPE prunner_pipeline_alert gauge\n")
+	for _, alert := range s.pRunner.Alerts() {
+		value := 0
+		if alert.Active {
+			value = 1
+		}
+		fmt.Fprintf(&b, "prunner_pipeline_alert{pipeline=%s,alert=%s} %d\n", prometheusLabelValue(alert.Pipeline), prometheusLabelValue(string(alert.Kind)), value)
+	}
+
+	quotaUsage := s.pRunner.QuotaUsage()
+	quotaLabels := func(usage prunner.QuotaUsage) string {
+		return fmt.Sprintf("pipeline=%s,per=%s,action=%s", prometheusLabelValue(usage.Pipeline), prometheusLabelValue(usage.Quota.Per.String()), prometheusLabelValue(usage.Quota.Action.String()))
+	}
+	b.WriteString("# HELP prunner_pipeline_quota_used Jobs counted in the current period of a schedule quota.\n")
+	b.WriteString("# TYPE prunner_pipeline_quota_used gauge\n")
+	for _, usage := range quotaUsage {
+		fmt.Fprintf(&b, "prunner_pipeline_quota_used{%s} %d\n", quotaLabels(usage), usage.Used)
+	}
+	b.WriteString("# HELP prunner_pipeline_quota_max Jobs allowed in the period of a schedule quota.\n")
+	b.WriteString("# TYPE prunner_pipeline_quota_max gauge\n")
+	for _, usage := range quotaUsage {
+		fmt.Fprintf(&b, "prunner_pipeline_quota_max{%s} %d\n", quotaLabels(usage), usage.Quota.Max)
+	}
+	b.WriteString("# HELP prunner_pipeline_quota_exceeded_total Jobs rejected or queued because a schedule quota was exceeded.\n")
+	b.WriteString("# TYPE prunner_pipeline_quota_exceeded_total counter\n")
+	for _, usage := range quotaUsage {
+		fmt.Fprintf(&b, "prunner_pipeline_quota_exceeded_total{%s} %d\n", quotaLabels(usage), usage.Exceeded)
+	}
+
+	taskUsage := s.pRunner.TaskResourceUsage()
+	taskLabels := func(usage prunner.TaskResourceUsage) string {
+		return fmt.Sprintf("pipeline=%s,task=%s", prometheusLabelValue(usage.Pipeline), prometheusLabelValue(usage.Task))
+	}
+	b.WriteString("# HELP prunner_task_runs_total Finished runs of a task (without skipped runs).\n")
+	b.WriteString("# TYPE prunner_task_runs_total counter\n")
+	for _, usage := range taskUsage {
+		fmt.Fprintf(&b, "prunner_task_runs_total{%s} %d\n", taskLabels(usage), usage.Runs)
+	}
+	b.WriteString("# HELP prunner_task_wall_seconds_total Wall clock time of finished runs of a task.\n")
+	b.WriteString("# TYPE prunner_task_wall_seconds_total counter\n")
+	for _, usage := range taskUsage {
+		fmt.Fprintf(&b, "prunner_task_wall_seconds_total{%s} %g\n", taskLabels(usage), usage.WallTime.Seconds())
+	}
+	b.WriteString("# HELP prunner_task_cpu_seconds_total CPU time of the processes of finished runs of a task.\n")
+	b.WriteString("# TYPE prunner_task_cpu_seconds_total counter\n")
+	for _, usage := range taskUsage {
+		fmt.Fprintf(&b, "prunner_task_cpu_seconds_total{%s,mode=\"user\"} %g\n", taskLabels(usage), usage.UserTime.Seconds())
+		fmt.Fprintf(&b, "prunner_task_cpu_seconds_total{%s,mode=\"system\"} %g\n", taskLabels(usage), usage.SystemTime.Seconds())
+	}
+	b.WriteString("# HELP prunner_task_max_rss_bytes Maximum resident set size of a process of the last run of a task.\n")
+	b.WriteString("# TYPE prunner_task_max_rss_bytes gauge\n")
+	for _, usage := range taskUsage {
+		fmt.Fprintf(&b, "prunner_task_max_rss_bytes{%s} %d\n", taskLabels(usage), usage.MaxRSS)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+var prometheusLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusLabelValue quotes a label value for the Prometheus text format
+func prometheusLabelValue(value string) string {
+	return `"` + prometheusLabelReplacer.Replace(value) + `"`
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/Flowpack/prunner"
+)
+
+// ProblemType identifies the kind of error in an error response (see RFC 7807). Problem types are stable, so clients
+// can branch on them instead of parsing messages.
+type ProblemType string
+
+const (
+	// ProblemInvalidRequest is returned for invalid or missing parameters
+	ProblemInvalidRequest ProblemType = "urn:prunner:problem:invalid-request"
+	// ProblemInvalidBody is returned if the request body is not valid JSON or has unknown fields
+	ProblemInvalidBody ProblemType = "urn:prunner:problem:invalid-body"
+	// ProblemBodyTooLarge is returned if the request body exceeds the maximum size
+	ProblemBodyTooLarge ProblemType = "urn:prunner:problem:body-too-large"
+	// ProblemUnauthorized is returned if the JWT is missing or invalid
+	ProblemUnauthorized ProblemType = "urn:prunner:problem:unauthorized"
+	// ProblemMissingScope is returned if the JWT does not have a required scope
+	ProblemMissingScope ProblemType = "urn:prunner:problem:missing-scope"
+	// ProblemPipelineNotFound is returned if the pipeline is not defined
+	ProblemPipelineNotFound ProblemType = "urn:prunner:problem:pipeline-not-found"
+	// ProblemJobNotFound is returned if the job does not exist (anymore)
+	ProblemJobNotFound ProblemType = "urn:prunner:problem:job-not-found"
+	// ProblemJobNotQueued is returned if a queued job is expected, but it was already started or finished
+	ProblemJobNotQueued ProblemType = "urn:prunner:problem:job-not-queued"
+	// ProblemJobNotFinished is returned if a finished job is expected, but it is queued or running
+	ProblemJobNotFinished ProblemType = "urn:prunner:problem:job-not-finished"
+	// ProblemJobNotCanceled is returned if a canceled or expired job is expected (e.g. for requeuing)
+	ProblemJobNotCanceled ProblemType = "urn:prunner:problem:job-not-canceled"
+	// ProblemArchiveDisabled is returned if archived jobs are accessed, but no archive is configured
+	ProblemArchiveDisabled ProblemType = "urn:prunner:problem:archive-disabled"
+	// ProblemTaskNotFound is returned if the job has no task with the name
+	ProblemTaskNotFound ProblemType = "urn:prunner:problem:task-not-found"
+	// ProblemTaskNotRunning is returned if the task must be running (e.g. for attaching or writing input)
+	ProblemTaskNotRunning ProblemType = "urn:prunner:problem:task-not-running"
+	// ProblemServiceNotFound is returned if the job has no service with the name
+	ProblemServiceNotFound ProblemType = "urn:prunner:problem:service-not-found"
+	// ProblemQueueFull is returned if the concurrency is exceeded and the wait list of the pipeline is full
+	ProblemQueueFull ProblemType = "urn:prunner:problem:queue-full"
+	// ProblemQueueDisabled is returned if the concurrency is exceeded and the pipeline does not queue jobs
+	ProblemQueueDisabled ProblemType = "urn:prunner:problem:queue-disabled"
+	// ProblemQuotaExceeded is returned if a schedule quota of the pipeline is exceeded
+	ProblemQuotaExceeded ProblemType = "urn:prunner:problem:quota-exceeded"
+	// ProblemScheduleRefused is returned if scheduling is refused (e.g. on low disk space)
+	ProblemScheduleRefused ProblemType = "urn:prunner:problem:schedule-refused"
+	// ProblemJobRejected is returned if a pre-schedule hook rejected the job
+	ProblemJobRejected ProblemType = "urn:prunner:problem:job-rejected"
+	// ProblemScheduleFailed is returned for other errors scheduling a job (e.g. invalid labels)
+	ProblemScheduleFailed ProblemType = "urn:prunner:problem:schedule-failed"
+	// ProblemRenderFailed is returned if the scripts of a pipeline cannot be rendered with the variables
+	ProblemRenderFailed ProblemType = "urn:prunner:problem:render-failed"
+	// ProblemShuttingDown is returned while the server is shutting down
+	ProblemShuttingDown ProblemType = "urn:prunner:problem:shutting-down"
+	// ProblemAssignmentNotFound is returned to agents if the task assignment does not exist (anymore)
+	ProblemAssignmentNotFound ProblemType = "urn:prunner:problem:assignment-not-found"
+	// ProblemDeadLetterNotFound is returned if the dead letter does not exist (anymore)
+	ProblemDeadLetterNotFound ProblemType = "urn:prunner:problem:dead-letter-not-found"
+	// ProblemRedeliveryFailed is returned if redelivering a dead letter failed
+	ProblemRedeliveryFailed ProblemType = "urn:prunner:problem:redelivery-failed"
+	// ProblemRouteNotFound is returned if no endpoint matches the path
+	ProblemRouteNotFound ProblemType = "urn:prunner:problem:route-not-found"
+	// ProblemMethodNotAllowed is returned if the endpoint does not support the method
+	ProblemMethodNotAllowed ProblemType = "urn:prunner:problem:method-not-allowed"
+	// ProblemInternalError is returned for unexpected errors
+	ProblemInternalError ProblemType = "urn:prunner:problem:internal-error"
+)
+
+var problemTitles = map[ProblemType]string{
+	ProblemInvalidRequest:     "Invalid request",
+	ProblemInvalidBody:        "Invalid request body",
+	ProblemBodyTooLarge:       "Request body too large",
+	ProblemUnauthorized:       "Unauthorized",
+	ProblemMissingScope:       "Missing scope",
+	ProblemPipelineNotFound:   "Pipeline not found",
+	ProblemJobNotFound:        "Job not found",
+	ProblemJobNotQueued:       "Job not queued",
+	ProblemJobNotFinished:     "Job not finished",
+	ProblemJobNotCanceled:     "Job not canceled",
+	ProblemArchiveDisabled:    "Archive disabled",
+	ProblemTaskNotFound:       "Task not found",
+	ProblemTaskNotRunning:     "Task not running",
+	ProblemServiceNotFound:    "Service not found",
+	ProblemQueueFull:          "Queue full",
+	ProblemQueueDisabled:      "Queue disabled",
+	ProblemQuotaExceeded:      "Quota exceeded",
+	ProblemScheduleRefused:    "Schedule refused",
+	ProblemJobRejected:        "Job rejected",
+	ProblemScheduleFailed:     "Schedule failed",
+	ProblemRenderFailed:       "Render failed",
+	ProblemShuttingDown:       "Shutting down",
+	ProblemAssignmentNotFound: "Assignment not found",
+	ProblemDeadLetterNotFound: "Dead letter not found",
+	ProblemRedeliveryFailed:   "Redelivery failed",
+	ProblemRouteNotFound:      "Route not found",
+	ProblemMethodNotAllowed:   "Method not allowed",
+	ProblemInternalError:      "Internal error",
+}
+
+// sendScheduleError sends the problem for an error of scheduling a job
+func (s *server) sendScheduleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, prunner.ErrShuttingDown):
+		s.sendError(w, http.StatusServiceUnavailable, ProblemShuttingDown, "Server is shutting down")
+	case errors.Is(err, prunner.ErrScheduleRefused):
+		s.sendError(w, http.StatusServiceUnavailable, ProblemScheduleRefused, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	case errors.Is(err, prunner.ErrQuotaExceeded):
+		s.sendError(w, http.StatusTooManyRequests, ProblemQuotaExceeded, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	case errors.Is(err, prunner.ErrPipelineNotDefined):
+		s.sendError(w, http.StatusBadRequest, ProblemPipelineNotFound, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	case errors.Is(err, prunner.ErrQueueFull):
+		s.sendError(w, http.StatusBadRequest, ProblemQueueFull, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	case errors.Is(err, prunner.ErrNoQueue):
+		s.sendError(w, http.StatusBadRequest, ProblemQueueDisabled, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	case errors.Is(err, prunner.ErrJobRejected):
+		s.sendError(w, http.StatusBadRequest, ProblemJobRejected, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	default:
+		s.sendError(w, http.StatusBadRequest, ProblemScheduleFailed, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	}
+}
+
+// authenticator responds with 401 Unauthorized if the request has no valid JWT (see jwtauth.Verifier), it replaces
+// jwtauth.Authenticator to send a problem. Tokens with ScopeBadge are rejected with 403 Forbidden, they are only
+// accepted by badgeAuthenticator.
+func (s *server) authenticator(next http.Handler) http.Handler {
+	return s.authenticate(next, false)
+}
+
+// badgeAuthenticator is the authenticator of status badges, which also accepts badge tokens
+func (s *server) badgeAuthenticator(next http.Handler) http.Handler {
+	return s.authenticate(next, true)
+}
+
+func (s *server) authenticate(next http.Handler, acceptBadgeTokens bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, claims, err := jwtauth.FromContext(r.Context())
+		if err != nil {
+			s.sendError(w, http.StatusUnauthorized, ProblemUnauthorized, err.Error())
+			return
+		}
+		if token == nil {
+			s.sendError(w, http.StatusUnauthorized, ProblemUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		if !acceptBadgeTokens && hasScope(claims, ScopeBadge) {
+			s.sendError(w, http.StatusForbidden, ProblemMissingScope, fmt.Sprintf("Tokens with scope %s can only be used for status badges", ScopeBadge))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) routeNotFound(w http.ResponseWriter, r *http.Request) {
+	s.sendError(w, http.StatusNotFound, ProblemRouteNotFound, fmt.Sprintf("No endpoint for %s", r.URL.Path))
+}
+
+func (s *server) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	s.sendError(w, http.StatusMethodNotAllowed, ProblemMethodNotAllowed, fmt.Sprintf("Method %s is not allowed for %s", r.Method, r.URL.Path))
+}
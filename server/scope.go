@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+// ScopeSchedulePriority allows scheduling jobs with a high priority
+const ScopeSchedulePriority = "pipelines:priority"
+
+// ScopeAgent allows agents to poll for assignments and report results of tasks
+const ScopeAgent = "agent"
+
+// ScopeBadge is the scope of tokens for status badges. Badge tokens are passed as query parameter (e.g. in READMEs), so
+// they must not have other scopes and are not accepted by other endpoints.
+const ScopeBadge = "pipelines:badge"
+
+// tokenScopes returns the scopes of the "scope" claim of a JWT.
+// The claim can be a space separated string (see RFC 8693) or a list of strings.
+func tokenScopes(claims map[string]interface{}) []string {
+	switch scopes := claims["scope"].(type) {
+	case string:
+		return strings.Fields(scopes)
+	case []interface{}:
+		var result []string
+		for _, s := range scopes {
+			if str, ok := s.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// hasScope checks if the "scope" claim of a JWT contains the scope
+func hasScope(claims map[string]interface{}, scope string) bool {
+	for _, s := range tokenScopes(claims) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// isBadgeToken checks if the JWT only has ScopeBadge
+func isBadgeToken(claims map[string]interface{}) bool {
+	scopes := tokenScopes(claims)
+	return len(scopes) == 1 && scopes[0] == ScopeBadge
+}
+
+// requireScope responds with 403 Forbidden if the token of a request does not have the scope
+func (s *server) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, _ := jwtauth.FromContext(r.Context())
+			if !hasScope(claims, scope) {
+				s.sendError(w, http.StatusForbidden, ProblemMissingScope, fmt.Sprintf("Scope %s is required", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
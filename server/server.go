@@ -1,11 +1,15 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/apex/log"
@@ -16,7 +20,11 @@ import (
 	jsontime "github.com/liamylian/jsontime/v2/v2"
 
 	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/agent"
+	"github.com/Flowpack/prunner/backup"
+	"github.com/Flowpack/prunner/exechook"
 	"github.com/Flowpack/prunner/helper"
+	"github.com/Flowpack/prunner/hoststat"
 	"github.com/Flowpack/prunner/taskctl"
 )
 
@@ -31,17 +39,109 @@ type server struct {
 	pRunner     *prunner.PipelineRunner
 	handler     http.Handler
 	outputStore taskctl.OutputStore
+	dataDir     string
+	// requiredScope is checked for all authenticated requests if set
+	requiredScope string
+	// withoutAdmin disables the admin endpoints (e.g. if they are served on a separate listener)
+	withoutAdmin bool
+	coordinator  *agent.Coordinator
+	// deadLetters of the on-complete hook are listed and redelivered via the admin API if set
+	deadLetters       *exechook.DeadLetterStore
+	deadLetterTimeout time.Duration
+	// compressionLevel of gzip and deflate compressed responses (0 disables compression)
+	compressionLevel int
+	// maxBodySize is the maximum size of JSON request bodies in bytes
+	maxBodySize int64
 }
 
-func NewServer(pRunner *prunner.PipelineRunner, outputStore taskctl.OutputStore, logger func(http.Handler) http.Handler, tokenAuth *jwtauth.JWTAuth, enableProfiling bool) *server {
+// DefaultCompressionLevel is a good trade-off between size and speed for compressing responses
+const DefaultCompressionLevel = 5
+
+// compressibleContentTypes are compressed if the client accepts it, backups are already compressed
+var compressibleContentTypes = []string{
+	"application/json",
+	"application/x-ndjson",
+	"text/csv",
+	"text/plain",
+	"image/svg+xml",
+}
+
+// Opts is a server configuration function.
+type Opts func(*server)
+
+// WithDataDir sets the data directory for reporting disk usage via the admin API
+func WithDataDir(dataDir string) Opts {
+	return func(s *server) {
+		s.dataDir = dataDir
+	}
+}
+
+// WithDeadLetters enables the admin endpoints for listing and redelivering failed notifications of the on-complete
+// hook, redeliveries are killed after the timeout
+func WithDeadLetters(store *exechook.DeadLetterStore, timeout time.Duration) Opts {
+	return func(s *server) {
+		s.deadLetters = store
+		s.deadLetterTimeout = timeout
+	}
+}
+
+// WithCompression sets the level (1-9) for compressing responses with gzip or deflate, 0 disables compression
+func WithCompression(level int) Opts {
+	return func(s *server) {
+		s.compressionLevel = level
+	}
+}
+
+// WithMaxBodySize sets the maximum size of JSON request bodies in bytes, larger requests are rejected
+func WithMaxBodySize(size int64) Opts {
+	return func(s *server) {
+		s.maxBodySize = size
+	}
+}
+
+// WithoutAdminEndpoints does not serve the admin endpoints, e.g. if they are served by another server on a separate
+// listener
+func WithoutAdminEndpoints() Opts {
+	return func(s *server) {
+		s.withoutAdmin = true
+	}
+}
+
+// WithRequiredScope requires the scope in the token of all authenticated requests (e.g. for a separate admin listener)
+func WithRequiredScope(scope string) Opts {
+	return func(s *server) {
+		s.requiredScope = scope
+	}
+}
+
+func NewServer(pRunner *prunner.PipelineRunner, outputStore taskctl.OutputStore, logger func(http.Handler) http.Handler, tokenAuth *jwtauth.JWTAuth, enableProfiling bool, opts ...Opts) *server {
 	srv := &server{
-		pRunner:     pRunner,
-		outputStore: outputStore,
+		pRunner:          pRunner,
+		outputStore:      outputStore,
+		compressionLevel: DefaultCompressionLevel,
+		maxBodySize:      DefaultMaxBodySize,
+	}
+
+	for _, o := range opts {
+		o(srv)
 	}
 
 	r := chi.NewRouter()
+	r.NotFound(srv.routeNotFound)
+	r.MethodNotAllowed(srv.methodNotAllowed)
 	r.Use(logger)
 	r.Use(middleware.Recoverer)
+	if srv.compressionLevel > 0 {
+		// The encoding is negotiated by the Accept-Encoding header
+		r.Use(middleware.Compress(srv.compressionLevel, compressibleContentTypes...))
+	}
+
+	// The REST API is versioned, the unversioned paths of the first version are kept as deprecated aliases
+	r.Route("/v1", srv.routesV1(tokenAuth))
+	r.Group(func(r chi.Router) {
+		r.Use(deprecatedAlias("/v1"))
+		srv.routesV1(tokenAuth)(r)
+	})
 
 	// we do not want JWT authentication for /debug/pprof,
 	// that's why we need to create a new handler group (to scope the authentication middlewares)
@@ -49,18 +149,16 @@ func NewServer(pRunner *prunner.PipelineRunner, outputStore taskctl.OutputStore,
 		// Seek, verify and validate JWT tokens
 		r.Use(jwtauth.Verifier(tokenAuth))
 		// Handle valid / invalid tokens
-		r.Use(jwtauth.Authenticator)
+		r.Use(srv.authenticator)
+		if srv.requiredScope != "" {
+			r.Use(srv.requireScope(srv.requiredScope))
+		}
 
-		r.Route("/pipelines", func(r chi.Router) {
-			r.Get("/", srv.pipelines)
-			r.Get("/jobs", srv.pipelinesJobs)
-			r.Post("/schedule", srv.pipelinesSchedule)
-		})
-		r.Route("/job", func(r chi.Router) {
-			r.Get("/detail", srv.jobDetail)
-			r.Get("/logs", srv.jobLogs)
-			r.Post("/cancel", srv.jobCancel)
-		})
+		// Metrics and the agent protocol are not versioned with the REST API
+		r.Get("/metrics", srv.metrics)
+		if srv.coordinator != nil {
+			r.Route("/agents", srv.agentRoutes)
+		}
 	})
 
 	if enableProfiling {
@@ -72,6 +170,109 @@ func NewServer(pRunner *prunner.PipelineRunner, outputStore taskctl.OutputStore,
 	return srv
 }
 
+// routesV1 registers the routes of version 1 of the REST API. A new version gets its own routes function that reuses
+// the handlers of unchanged endpoints and registers new handlers (with new request and response types) for endpoints
+// that change, so the responses of previous versions stay stable.
+func (s *server) routesV1(tokenAuth *jwtauth.JWTAuth) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			// Seek, verify and validate JWT tokens
+			r.Use(jwtauth.Verifier(tokenAuth))
+			// Handle valid / invalid tokens
+			r.Use(s.authenticator)
+			if s.requiredScope != "" {
+				r.Use(s.requireScope(s.requiredScope))
+			}
+
+			r.Route("/pipelines", func(r chi.Router) {
+				r.Get("/", s.pipelines)
+				r.Get("/jobs", s.pipelinesJobs)
+				r.Get("/jobs/export", s.pipelinesJobsExport)
+				r.Get("/jobs/archived", s.pipelinesJobsArchived)
+				r.Post("/jobs/{id}/rehydrate", s.pipelinesJobRehydrate)
+				r.Post("/jobs/{id}/requeue", s.pipelinesJobRequeue)
+				r.Post("/jobs/{id}/clone", s.pipelinesJobClone)
+				r.Post("/jobs/{id}/annotations", s.pipelinesJobAnnotate)
+				r.Get("/jobs/{id}/wait", s.pipelinesJobWait)
+				r.With(s.requireScope(ScopeAdmin)).Delete("/jobs/{id}", s.pipelinesJobDelete)
+				r.Get("/{pipeline}/queue", s.pipelinesQueue)
+				r.Post("/{pipeline}/cancel", s.pipelinesCancel)
+				r.Post("/{pipeline}/drain", s.pipelinesDrain)
+				r.Delete("/{pipeline}/drain", s.pipelinesDrain)
+				r.Post("/schedule", s.pipelinesSchedule)
+				r.Post("/render", s.pipelinesRender)
+			})
+			r.Route("/job", func(r chi.Router) {
+				r.Get("/detail", s.jobDetail)
+				r.Get("/logs", s.jobLogs)
+				r.Get("/live-output", s.jobLiveOutput)
+				r.Post("/cancel", s.jobCancel)
+				r.Post("/promote", s.jobPromote)
+				r.With(s.requireScope(ScopeAdmin)).Post("/stdin", s.jobStdin)
+				r.Get("/definition-diff", s.jobDefinitionDiff)
+			})
+			if !s.withoutAdmin {
+				r.Route("/admin", func(r chi.Router) {
+					r.Use(s.requireScope(ScopeAdmin))
+
+					// Linting only needs the definitions, so it is also available without a data directory
+					r.Get("/lint", s.adminLint)
+					if s.dataDir != "" {
+						r.Get("/disk-usage", s.adminDiskUsage)
+						r.Get("/data-usage", s.adminDataUsage)
+						r.Get("/backup", s.adminBackup)
+						if s.deadLetters != nil {
+							r.Get("/dead-letters", s.adminDeadLetters)
+							r.Post("/dead-letters/redeliver", s.adminDeadLettersRedeliver)
+							r.Delete("/dead-letters", s.adminDeadLettersDelete)
+						}
+					}
+				})
+			}
+		})
+
+		// Badges are embedded as images where no header can be set, so the token can also be passed as query parameter.
+		// Tokens in the query string are checked to only have the badge scope by the handler.
+		r.Group(func(r chi.Router) {
+			r.Use(jwtauth.Verify(tokenAuth, jwtauth.TokenFromHeader, jwtauth.TokenFromQuery))
+			r.Use(s.badgeAuthenticator)
+			if s.requiredScope != "" {
+				r.Use(s.requireScope(s.requiredScope))
+			}
+
+			r.Get("/pipelines/{pipeline}/badge.svg", s.pipelinesBadge)
+		})
+
+		// WebSockets are opened by browsers where no header can be set, so the token can also be passed as query
+		// parameter
+		r.Group(func(r chi.Router) {
+			r.Use(jwtauth.Verify(tokenAuth, jwtauth.TokenFromHeader, jwtauth.TokenFromQuery))
+			r.Use(s.authenticator)
+			if s.requiredScope != "" {
+				r.Use(s.requireScope(s.requiredScope))
+			}
+
+			r.With(s.requireScope(ScopeAdmin)).Get("/job/attach", s.jobAttach)
+		})
+	}
+}
+
+// deprecatedAlias marks responses of unversioned paths as deprecated (see RFC 8594) and links to the same path under
+// the prefix of the successor version
+func deprecatedAlias(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			successor := prefix + r.URL.Path
+			if r.URL.RawQuery != "" {
+				successor += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handler.ServeHTTP(w, r)
 }
@@ -90,7 +291,60 @@ type pipelinesScheduleRequest struct {
 		// Job variables
 		// example: {"tag_name": "v1.17.4", "databases": ["mysql", "postgresql"]}
 		Variables map[string]interface{} `json:"variables"`
+
+		// Labels to attach to the job, they can be used to filter jobs
+		// example: {"commit": "a1b2c3d", "ticket": "OPS-123"}
+		Labels map[string]string `json:"labels"`
+
+		// Priority of the job on the wait list, a high priority job is started before jobs with normal priority.
+		// Requires the pipelines:priority scope in the JWT for high priority.
+		// enum: normal,high
+		// example: high
+		Priority string `json:"priority"`
+	}
+
+	// Only resolve the schedule action and render the tasks without creating a job
+	//
+	// in: query
+	DryRun bool `json:"dryRun"`
+
+	// Wait until the job is finished and return the job
+	//
+	// in: query
+	Wait bool `json:"wait"`
+
+	// Maximum time to wait for the job as duration (defaults to 60s, at most 1h)
+	//
+	// in: query
+	// example: 300s
+	Timeout string `json:"timeout"`
+
+	// ID for tracing the job across systems (X-Request-ID is used if not set), it is added to the log output of the
+	// job and exported to tasks as PRUNNER_CORRELATION_ID
+	//
+	// in: header
+	// example: 7f3c2a1e-checkout-42
+	CorrelationID string `json:"X-Correlation-ID"`
+}
+
+// maxCorrelationIDLength limits correlation IDs, since they are added to log output and the environment of tasks
+const maxCorrelationIDLength = 128
+
+// correlationIDFromRequest returns the X-Correlation-ID or X-Request-ID header of a schedule request (or an empty string)
+func correlationIDFromRequest(r *http.Request) (string, error) {
+	correlationID := r.Header.Get("X-Correlation-ID")
+	if correlationID == "" {
+		correlationID = r.Header.Get("X-Request-ID")
+	}
+	if len(correlationID) > maxCorrelationIDLength {
+		return "", fmt.Errorf("must be at most %d characters long", maxCorrelationIDLength)
 	}
+	for _, c := range correlationID {
+		if c < 0x21 || c > 0x7e {
+			return "", errors.New("must only contain printable ASCII characters without spaces")
+		}
+	}
+	return correlationID, nil
 }
 
 // swagger:response
@@ -102,16 +356,61 @@ type pipelinesScheduleResponse struct {
 		// swagger:strfmt uuid4
 		// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
 		JobID string `json:"jobId"`
+		// Position on the wait list if the job was queued (not set if the job was started)
+		Queue *scheduleQueueResult `json:"queue,omitempty"`
+	}
+}
+
+// swagger:model scheduleQueue
+type scheduleQueueResult struct {
+	// Position on the wait list, starting at 1 for the next job to start
+	// example: 2
+	Position int `json:"position"`
+	// Number of running jobs of the pipeline
+	// example: 1
+	Running int `json:"running"`
+	// Expected start of the job based on the durations of previous jobs (not set if not enough jobs succeeded
+	// before or the job waits for something else than running jobs)
+	// example: 2021-10-11T09:15:00Z
+	EstimatedStart *time.Time `json:"estimatedStart,omitempty"`
+}
+
+// swagger:response
+type pipelinesScheduleDryRunResponse struct {
+	// in: body
+	Body struct {
+		// Action that would be taken when scheduling the job
+		// enum: start,queue,replace,rejected
+		// example: queue
+		Action string `json:"action"`
+		// Reason why the job would be rejected
+		// example: concurrency exceeded and queue limit reached for pipeline
+		Reason string `json:"reason,omitempty"`
+		// Id of the queued job that would be replaced
+		//
+		// swagger:strfmt uuid4
+		ReplacedJobID *string `json:"replacedJobId,omitempty"`
+		// Tasks of the job with rendered scripts (ordered topologically by dependencies and task name)
+		Tasks []renderedTaskResult `json:"tasks"`
 	}
 }
 
-// swagger:route POST /pipelines/schedule pipelinesSchedule
+// swagger:route POST /v1/pipelines/schedule pipelinesSchedule
 //
 // Schedule a pipeline execution
 //
 // This will create a job for execution of the specified pipeline and variables.
 // If the pipeline is not schedulable (running and no queue / limit or concurrency exceeded) it will error.
 //
+// With dryRun=true no job is created, instead the action that would be taken (start, queue, replace or rejected)
+// and the tasks with rendered scripts are returned.
+//
+// With wait=true the request blocks until the job is finished or the timeout elapsed and returns the job.
+// The status is 200 if the job is finished and 202 if it is still queued or running.
+//
+// If the job is queued, the response contains its position on the wait list, the number of running jobs and the
+// estimated start based on the durations of previous jobs.
+//
 //     Consumes:
 //     - application/json
 //
@@ -120,7 +419,11 @@ type pipelinesScheduleResponse struct {
 //
 //     Responses:
 //       default: pipelinesScheduleResponse
+//       200: pipelinesScheduleDryRunResponse
+//       202: jobDetailResponse
 //       400: genericErrorResponse
+//       403: genericErrorResponse
+//       503: genericErrorResponse
 func (s *server) pipelinesSchedule(w http.ResponseWriter, r *http.Request) {
 	_, claims, _ := jwtauth.FromContext(r.Context())
 	var user string
@@ -129,21 +432,58 @@ func (s *server) pipelinesSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var in pipelinesScheduleRequest
-	err := json.NewDecoder(r.Body).Decode(&in.Body)
-	if err != nil {
-		s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Error decoding JSON: %v", err))
+	if !s.decodeJSONBody(w, r, &in.Body) {
 		return
 	}
 
-	pJob, err := s.pRunner.ScheduleAsync(in.Body.Pipeline, prunner.ScheduleOpts{Variables: in.Body.Variables, User: user})
+	priority, err := prunner.ParseJobPriority(in.Body.Priority)
 	if err != nil {
-		// TODO Send JSON error and include expected errors (see resolveScheduleAction)
-		if errors.Is(err, prunner.ErrShuttingDown) {
-			s.sendError(w, http.StatusServiceUnavailable, "Server is shutting down")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid priority: %v", err))
+		return
+	}
+	if priority != prunner.JobPriorityNormal && !hasScope(claims, ScopeSchedulePriority) {
+		s.sendError(w, http.StatusForbidden, ProblemMissingScope, fmt.Sprintf("Scope %s is required for priority %s", ScopeSchedulePriority, priority))
+		return
+	}
+	in.CorrelationID, err = correlationIDFromRequest(r)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid correlation ID: %v", err))
+		return
+	}
+	scheduleOpts := prunner.ScheduleOpts{Variables: in.Body.Variables, User: user, Priority: priority, Labels: in.Body.Labels, CorrelationID: in.CorrelationID, Trigger: prunner.TriggerAPI}
+
+	if dryRun := r.URL.Query().Get("dryRun"); dryRun != "" {
+		in.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid dryRun parameter")
+			return
+		}
+	}
+	if in.DryRun {
+		s.pipelinesScheduleDryRun(w, in.Body.Pipeline, scheduleOpts)
+		return
+	}
+
+	if wait := r.URL.Query().Get("wait"); wait != "" {
+		in.Wait, err = strconv.ParseBool(wait)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid wait parameter")
+			return
+		}
+	}
+	in.Timeout = r.URL.Query().Get("timeout")
+	var waitTimeout time.Duration
+	if in.Wait {
+		waitTimeout, err = parseWaitTimeout(in.Timeout)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid timeout parameter: %v", err))
 			return
 		}
+	}
 
-		s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Error scheduling pipeline: %v", err))
+	pJob, err := s.pRunner.ScheduleAsync(in.Body.Pipeline, scheduleOpts)
+	if err != nil {
+		s.sendScheduleError(w, err)
 		return
 	}
 
@@ -152,23 +492,207 @@ func (s *server) pipelinesSchedule(w http.ResponseWriter, r *http.Request) {
 		WithField("jobID", pJob.ID).
 		WithField("pipeline", in.Body.Pipeline).
 		WithField("user", user).
+		WithField("priority", priority).
+		WithField("correlationID", in.CorrelationID).
 		Info("Job scheduled")
 
+	if in.Wait {
+		s.waitForJob(w, r, pJob.ID, waitTimeout)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 
 	var resp pipelinesScheduleResponse
 	resp.Body.JobID = pJob.ID.String()
+	// The job could already be started in the meantime, so the queue is only added if it is still queued
+	if queuePosition, err := s.pRunner.QueuePosition(pJob.ID); err == nil {
+		resp.Body.Queue = &scheduleQueueResult{
+			Position:       queuePosition.Position,
+			Running:        queuePosition.Running,
+			EstimatedStart: queuePosition.EstimatedStart,
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+const (
+	defaultWaitTimeout = 60 * time.Second
+	maxWaitTimeout     = time.Hour
+)
+
+// parseWaitTimeout parses the timeout for waiting on a job (a Go duration or seconds)
+func parseWaitTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return defaultWaitTimeout, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		seconds, atoiErr := strconv.Atoi(timeout)
+		if atoiErr != nil {
+			return 0, err
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d <= 0 || d > maxWaitTimeout {
+		return 0, fmt.Errorf("must be greater than 0 and at most %s", maxWaitTimeout)
+	}
+	return d, nil
+}
+
+// waitForJob waits until the job is finished or the timeout elapsed and responds with the job.
+// The status is 200 if the job is finished, 202 if it is still queued or running.
+func (s *server) waitForJob(w http.ResponseWriter, r *http.Request, jobID uuid.UUID, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	status := http.StatusOK
+	err := s.pRunner.WaitForJob(ctx, jobID)
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusAccepted
+	} else if err != nil {
+		// The client closed the request
+		return
+	}
+
+	var result pipelineJobResult
+	err = s.pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+		result = jobToResult(j)
+	})
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	}
+
+	var resp jobDetailResponse
+	resp.Body = result
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+func (s *server) pipelinesScheduleDryRun(w http.ResponseWriter, pipeline string, opts prunner.ScheduleOpts) {
+	result, err := s.pRunner.ScheduleDryRun(pipeline, opts)
+	if err != nil {
+		s.sendScheduleError(w, err)
+		return
+	}
+
+	var resp pipelinesScheduleDryRunResponse
+	resp.Body.Action = string(result.Action)
+	resp.Body.Reason = result.Reason
+	if result.ReplacedJobID != nil {
+		replacedJobID := result.ReplacedJobID.String()
+		resp.Body.ReplacedJobID = &replacedJobID
+	}
+	resp.Body.Tasks = toRenderedTaskResults(result.Tasks)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters pipelinesRender
+type pipelinesRenderRequest struct {
+	// in: body
+	Body struct {
+		// Pipeline name
+		// required: true
+		// example: my_pipeline
+		Pipeline string `json:"pipeline"`
+
+		// Job variables
+		// example: {"tag_name": "v1.17.4", "databases": ["mysql", "postgresql"]}
+		Variables map[string]interface{} `json:"variables"`
+	}
+}
+
+// swagger:model renderedTask
+type renderedTaskResult struct {
+	// Task name
+	// example: task_name
+	Name string `json:"name"`
+	// Task names this task depends on
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Rendered commands of the task script
+	// example: ["echo v1.17.4"]
+	Script []string `json:"script"`
+	// Error message if the script could not be rendered
+	Error *string `json:"error,omitempty"`
+}
+
+// swagger:response
+type pipelinesRenderResponse struct {
+	// in: body
+	Body struct {
+		// Tasks of the pipeline (ordered topologically by dependencies and task name)
+		Tasks []renderedTaskResult `json:"tasks"`
+	}
+}
+
+// swagger:route POST /v1/pipelines/render pipelinesRender
+//
+// Render the scripts of a pipeline
+//
+// This renders the task scripts of the specified pipeline with the given variables as a preview (dry-run).
+// No job will be created. Built-in functions are rendered with a nil job id and the current time.
+//
+//     Consumes:
+//     - application/json
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: pipelinesRenderResponse
+//       400: genericErrorResponse
+func (s *server) pipelinesRender(w http.ResponseWriter, r *http.Request) {
+	var in pipelinesRenderRequest
+	if !s.decodeJSONBody(w, r, &in.Body) {
+		return
+	}
+
+	renderedTasks, err := s.pRunner.RenderScripts(in.Body.Pipeline, in.Body.Variables)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemRenderFailed, fmt.Sprintf("Error rendering pipeline: %v", err))
+		return
+	}
 
+	var resp pipelinesRenderResponse
+	resp.Body.Tasks = toRenderedTaskResults(renderedTasks)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp.Body)
 }
 
+func toRenderedTaskResults(renderedTasks []prunner.RenderedTask) []renderedTaskResult {
+	res := make([]renderedTaskResult, len(renderedTasks))
+	for i, renderedTask := range renderedTasks {
+		res[i] = renderedTaskResult{
+			Name:      renderedTask.Name,
+			DependsOn: renderedTask.DependsOn,
+			Script:    renderedTask.Script,
+			Error:     helper.ErrToStrPtr(renderedTask.Error),
+		}
+	}
+	return res
+}
+
 // swagger:response
 type pipelinesJobsResponse struct {
 	// in: body
 	Body struct {
 		Pipelines []pipelineResult    `json:"pipelines"`
 		Jobs      []pipelineJobResult `json:"jobs"`
+		// Total number of matching jobs (jobs can be paginated by limit and offset)
+		Total int `json:"total"`
 	}
 }
 
@@ -192,8 +716,68 @@ type taskResult struct {
 	ExitCode int16 `json:"exitCode"`
 	// If the task had an error
 	Errored bool `json:"errored"`
+	// If the task had an error that is allowed by allow_failure, the job is not errored because of this task
+	AllowedFailure bool `json:"allowedFailure"`
 	// Error message of task when an error occured
 	Error *string `json:"error,omitempty"`
+	// Resources used by the task (only set if the task was started)
+	ResourceUsage *taskResourceUsageResult `json:"resourceUsage,omitempty"`
+	// Environment the task received from prunner when it was started (sensitive values are masked)
+	// example: {"TASK_NAME": "deploy", "API_TOKEN": "***"}
+	Env map[string]string `json:"env,omitempty"`
+	// Variables the task received when it was started (sensitive values are masked)
+	// example: {"tag": "v1.2.0"}
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// Status of each command of the script that was started (of the last attempt)
+	Commands []taskCommandResult `json:"commands,omitempty"`
+}
+
+// swagger:model taskCommand
+type taskCommandResult struct {
+	// Position of the command in the script of the task
+	// example: 0
+	Index int `json:"index"`
+	// Command as defined in the script
+	// example: npm ci
+	Command string `json:"command"`
+	// Status of the command
+	// enum: running,done,error
+	Status string `json:"status"`
+	// When the command was started
+	Start *time.Time `json:"start,omitempty"`
+	// When the command was finished
+	End *time.Time `json:"end,omitempty"`
+	// Duration of the command in seconds (only set if the command is finished)
+	// example: 3.2
+	Duration *float64 `json:"duration,omitempty"`
+	// Exit code of the command
+	ExitCode int16 `json:"exitCode"`
+	// Error message of the command
+	Error *string `json:"error,omitempty"`
+	// Byte offset of the command output in the STDOUT output of the task
+	StdoutOffset int64 `json:"stdoutOffset"`
+	// Number of bytes written to STDOUT by the command
+	StdoutLength int64 `json:"stdoutLength"`
+	// Byte offset of the command output in the STDERR output of the task
+	StderrOffset int64 `json:"stderrOffset"`
+	// Number of bytes written to STDERR by the command
+	StderrLength int64 `json:"stderrLength"`
+}
+
+// swagger:model taskResourceUsage
+type taskResourceUsageResult struct {
+	// Wall clock time of the task in seconds (only set if the task is finished)
+	// example: 12.5
+	WallTime *float64 `json:"wallTime,omitempty"`
+	// CPU time spent in user mode by all processes of the task in seconds
+	// example: 8.23
+	UserTime float64 `json:"userTime"`
+	// CPU time spent in kernel mode by all processes of the task in seconds
+	// example: 0.42
+	SystemTime float64 `json:"systemTime"`
+	// Maximum resident set size of a process of the task in bytes (0 if not supported by the OS)
+	// example: 104857600
+	MaxRSS int64 `json:"maxRss"`
 }
 
 // swagger:model job
@@ -211,6 +795,10 @@ type pipelineJobResult struct {
 	Completed bool `json:"completed"`
 	// If the job was canceled
 	Canceled bool `json:"canceled"`
+	// If the job was running when prunner stopped unexpectedly (e.g. a crash) and never finished
+	Incomplete bool `json:"incomplete"`
+	// If the job was canceled because it was queued longer than the queue timeout of the pipeline
+	Expired bool `json:"expired"`
 	// If the job had an error
 	Errored bool `json:"errored"`
 	// When the job was created
@@ -228,6 +816,66 @@ type pipelineJobResult struct {
 	// User that scheduled the job
 	// example: j.doe
 	User string `json:"user"`
+	// Priority of the job on the wait list
+	// enum: normal,high
+	Priority string `json:"priority"`
+	// Labels attached to the job when scheduling
+	// example: {"commit": "a1b2c3d"}
+	Labels map[string]string `json:"labels,omitempty"`
+	// Correlation ID of the request that scheduled the job
+	// example: 7f3c2a1e-checkout-42
+	CorrelationID string `json:"correlationId,omitempty"`
+	// How the job was scheduled
+	// enum: api,redis
+	// example: api
+	Trigger string `json:"trigger,omitempty"`
+	// Id of the canceled or expired job this job was requeued from
+	//
+	// swagger:strfmt uuid4
+	RequeuedFrom *string `json:"requeuedFrom,omitempty"`
+	// Id of the job whose inputs this job was scheduled with
+	//
+	// swagger:strfmt uuid4
+	ClonedFrom *string `json:"clonedFrom,omitempty"`
+	// Notes attached to the job after it was scheduled (oldest first)
+	Annotations []annotationResult `json:"annotations,omitempty"`
+
+	// Hash of the pipeline definition the job was scheduled with
+	// example: 3f2a9c81b7d0
+	DefinitionHash string `json:"definitionHash"`
+	// SHA of the commit that was checked out for the job (only set if the pipeline has a git checkout)
+	// example: 9fceb02d0ae598e95dc970b74767f19372d61af8
+	GitCommit string `json:"gitCommit,omitempty"`
+	// If the logs of the job were removed because the log quota of the pipeline was exceeded
+	LogsPruned bool `json:"logsPruned,omitempty"`
+	// When the job was restored from the archive
+	Rehydrated *time.Time `json:"rehydrated,omitempty"`
+	// If the job ran longer than the SLA of the pipeline (even if it succeeded)
+	SLABreached bool `json:"slaBreached,omitempty"`
+	// Expected end of a running job based on the median duration of previous successful jobs
+	ETA *time.Time `json:"eta,omitempty"`
+	// Median duration of previous successful jobs in seconds when the job started
+	// example: 93.5
+	ExpectedDuration *float64 `json:"expectedDuration,omitempty"`
+	// If the job runs (or ran) longer than the 95th percentile of durations of previous successful jobs plus a margin
+	UnusuallySlow bool `json:"unusuallySlow,omitempty"`
+	// Services of the job (sorted by name)
+	Services []serviceResult `json:"services,omitempty"`
+}
+
+type serviceResult struct {
+	// Service name
+	// example: dev_server
+	Name string `json:"name"`
+	// Status of the service
+	// enum: waiting,starting,running,exited,failed,stopped
+	Status string `json:"status"`
+	// When the service was started
+	Start *time.Time `json:"start,omitempty"`
+	// When the service was stopped or exited
+	End *time.Time `json:"end,omitempty"`
+	// Error message if the service failed to start or exited with an error
+	Error *string `json:"error,omitempty"`
 }
 
 func jobToResult(j *prunner.PipelineJob) pipelineJobResult {
@@ -245,143 +893,1683 @@ func jobToResult(j *prunner.PipelineJob) pipelineJobResult {
 			ExitCode:  t.ExitCode,
 			Errored:   t.Errored,
 			Error:     helper.ErrToStrPtr(t.Error),
+			Env:       t.EnvSnapshot,
+			Variables: t.VariablesSnapshot,
+
+			AllowedFailure: t.Errored && t.AllowFailure,
+		}
+		if t.Start != nil {
+			res.ResourceUsage = &taskResourceUsageResult{
+				UserTime:   t.ResourceUsage.UserTime.Seconds(),
+				SystemTime: t.ResourceUsage.SystemTime.Seconds(),
+				MaxRSS:     t.ResourceUsage.MaxRSS,
+			}
+			if t.End != nil {
+				wallTime := t.End.Sub(*t.Start).Seconds()
+				res.ResourceUsage.WallTime = &wallTime
+			}
+		}
+		for i, c := range t.Commands {
+			cmdRes := taskCommandResult{
+				Index:        i,
+				Command:      c.Command,
+				Status:       "running",
+				Start:        c.Start,
+				End:          c.End,
+				ExitCode:     c.ExitCode,
+				Error:        helper.ErrToStrPtr(c.Error),
+				StdoutOffset: c.StdoutOffset,
+				StdoutLength: c.StdoutLength,
+				StderrOffset: c.StderrOffset,
+				StderrLength: c.StderrLength,
+			}
+			if c.End != nil {
+				cmdRes.Status = "done"
+				if c.Error != nil {
+					cmdRes.Status = "error"
+				}
+				if c.Start != nil {
+					duration := c.End.Sub(*c.Start).Seconds()
+					cmdRes.Duration = &duration
+				}
+			}
+			res.Commands = append(res.Commands, cmdRes)
 		}
 		taskResults = append(taskResults, res)
-		// Collect if job had a errored task
-		// TODO Check if this works if AllowFailure is true!
-		errored = errored || t.Errored
+		// Collect if job had a errored task, failures of tasks with allow_failure do not count
+		errored = errored || (t.Errored && !t.AllowFailure)
+	}
+
+	var expectedDuration *float64
+	if j.ExpectedDuration > 0 {
+		seconds := j.ExpectedDuration.Seconds()
+		expectedDuration = &seconds
+	}
+
+	var requeuedFrom, clonedFrom *string
+	if j.RequeuedFrom != nil {
+		id := j.RequeuedFrom.String()
+		requeuedFrom = &id
+	}
+	if j.ClonedFrom != nil {
+		id := j.ClonedFrom.String()
+		clonedFrom = &id
 	}
 
 	return pipelineJobResult{
-		Tasks:     taskResults,
-		ID:        j.ID.String(),
-		Pipeline:  j.Pipeline,
-		Completed: j.Completed,
-		Canceled:  j.Canceled,
-		Errored:   errored,
-		Created:   j.Created,
-		Start:     j.Start,
-		End:       j.End,
-		LastError: helper.ErrToStrPtr(j.LastError),
+		Tasks:      taskResults,
+		ID:         j.ID.String(),
+		Pipeline:   j.Pipeline,
+		Completed:  j.Completed,
+		Canceled:   j.Canceled,
+		Incomplete: j.Incomplete,
+		Expired:    j.Expired,
+		Errored:    errored,
+		Created:    j.Created,
+		Start:      j.Start,
+		End:        j.End,
+		LastError:  helper.ErrToStrPtr(j.LastError),
 
 		Variables: j.Variables,
 		User:      j.User,
+		Priority:  j.Priority.String(),
+		Labels:    j.Labels,
+
+		CorrelationID: j.CorrelationID,
+		Trigger:       j.Trigger,
+		RequeuedFrom:  requeuedFrom,
+		ClonedFrom:    clonedFrom,
+		Annotations:   annotationsToResult(j.Annotations),
+
+		DefinitionHash: j.DefinitionHash,
+		GitCommit:      j.GitCommit,
+		LogsPruned:     j.LogsPruned,
+		Rehydrated:     j.Rehydrated,
+		SLABreached:    j.SLABreached,
+		Services:       servicesToResult(j),
+
+		ETA:              j.ETA(),
+		ExpectedDuration: expectedDuration,
+		UnusuallySlow:    j.IsUnusuallySlow(time.Now()),
+	}
+}
+
+func servicesToResult(j *prunner.PipelineJob) []serviceResult {
+	var results []serviceResult
+	for _, s := range j.Services {
+		results = append(results, serviceResult{
+			Name:   s.Name,
+			Status: s.Status,
+			Start:  s.Start,
+			End:    s.End,
+			Error:  helper.ErrToStrPtr(s.Error),
+		})
 	}
+	return results
+}
+
+// swagger:parameters pipelinesJobs
+type pipelinesJobsParams struct {
+	// Only return jobs with the label (name=value or name to check if the label is set), can be repeated
+	//
+	// in: query
+	// example: ["commit=a1b2c3d"]
+	Label []string `json:"label"`
+
+	// Only return jobs with the variable (name=value or name to check if the variable is set), can be repeated.
+	// Elements of list variables are matched separately.
+	//
+	// in: query
+	// example: ["tag=v1.2.0"]
+	Variable []string `json:"variable"`
+
+	// Only return jobs of the pipeline
+	//
+	// in: query
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+
+	// Maximum number of jobs to return (newest first), all jobs are returned if not set
+	//
+	// in: query
+	// example: 50
+	Limit int `json:"limit"`
+
+	// Number of newest jobs to skip for pagination
+	//
+	// in: query
+	// example: 50
+	Offset int `json:"offset"`
 }
 
-// swagger:route GET /pipelines/jobs pipelinesJobs
+// swagger:route GET /v1/pipelines/jobs pipelinesJobs
 //
 // Get pipelines and jobs
 //
 // This is a combined operation to fetch pipelines and jobs in one request.
+// Jobs can be filtered by pipeline, labels and variables, all given selectors must match.
+// Jobs are ordered by creation time (newest first) and can be paginated by limit and offset.
+//
+// The response has an ETag that changes with every change of a job or pipeline. If it matches the If-None-Match
+// header, the status is 304 without a body.
 //
 //     Produces:
 //     - application/json
 //
 //     Responses:
 //       default: pipelinesJobsResponse
+//       304:
+//       400: genericErrorResponse
 func (s *server) pipelinesJobs(w http.ResponseWriter, r *http.Request) {
-	pipelinesRes := s.listPipelines()
-	jobsRes := s.listPipelineJobs()
+	var params pipelinesJobsParams
+	vars := r.URL.Query()
+	params.Label = vars["label"]
+	params.Variable = vars["variable"]
+	params.Pipeline = vars.Get("pipeline")
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{
+		{"limit", &params.Limit},
+		{"offset", &params.Offset},
+	} {
+		v := vars.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid %s parameter", p.name))
+			return
+		}
+		*p.value = n
+	}
+
+	selector, err := parseJobSelector(params.Label, params.Variable)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid job selector: %v", err))
+		return
+	}
+
+	// The version is read before listing, so a change while listing results in a new ETag for the next request
+	stateVersion := s.pRunner.StateVersion()
+	pipelinesRes := s.listPipelines()
+
+	etag := jobsETag(stateVersion, pipelinesRes)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	jobsRes, total := s.listPipelineJobs(prunner.ListJobsOpts{
+		Pipeline: params.Pipeline,
+		Selector: selector,
+		Offset:   params.Offset,
+		Limit:    params.Limit,
+	})
 
 	var resp pipelinesJobsResponse
 	resp.Body.Pipelines = pipelinesRes
 	resp.Body.Jobs = jobsRes
+	resp.Body.Total = total
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters pipelinesJobsExport
+type pipelinesJobsExportParams struct {
+	// Export format
+	//
+	// in: query
+	// enum: ndjson,csv
+	// default: ndjson
+	Format string `json:"format"`
+
+	// Only export jobs of the pipeline
+	//
+	// in: query
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+
+	// Only export jobs with the label (name=value or name to check if the label is set), can be repeated
+	//
+	// in: query
+	// example: ["commit=a1b2c3d"]
+	Label []string `json:"label"`
+
+	// Only export jobs with the variable (name=value or name to check if the variable is set), can be repeated
+	//
+	// in: query
+	// example: ["tag=v1.2.0"]
+	Variable []string `json:"variable"`
+}
+
+// swagger:route GET /v1/pipelines/jobs/export pipelinesJobsExport
+//
+// Export job history
+//
+// Streams all retained jobs ordered by creation time (oldest first), e.g. for ingestion into BI tools or for archival.
+// With format ndjson each line is a job result as returned by /job/detail, with format csv each row contains the
+// job fields without tasks (labels and variables are encoded as JSON objects).
+// Jobs can be filtered by pipeline, labels and variables, all given selectors must match.
+//
+//     Produces:
+//     - application/x-ndjson
+//     - text/csv
+//
+//     Responses:
+//       200:
+//       400: genericErrorResponse
+func (s *server) pipelinesJobsExport(w http.ResponseWriter, r *http.Request) {
+	var params pipelinesJobsExportParams
+	vars := r.URL.Query()
+	params.Format = vars.Get("format")
+	params.Pipeline = vars.Get("pipeline")
+	params.Label = vars["label"]
+	params.Variable = vars["variable"]
+
+	if params.Format == "" {
+		params.Format = exportFormatNDJSON
+	}
+	if params.Format != exportFormatNDJSON && params.Format != exportFormatCSV {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid format %q, must be one of ndjson, csv", params.Format))
+		return
+	}
+
+	selector, err := parseJobSelector(params.Label, params.Variable)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid job selector: %v", err))
+		return
+	}
+
+	jobs, _ := s.listPipelineJobs(prunner.ListJobsOpts{
+		Pipeline: params.Pipeline,
+		Selector: selector,
+	})
+	// Export the history in chronological order
+	for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+		jobs[i], jobs[j] = jobs[j], jobs[i]
+	}
+
+	if params.Format == exportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"jobs.%s\"", params.Format))
+	w.WriteHeader(http.StatusOK)
+
+	var exporter jobExporter
+	if params.Format == exportFormatCSV {
+		exporter = newCSVJobExporter(w)
+	} else {
+		exporter = newNDJSONJobExporter(w)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for i, job := range jobs {
+		if err := exporter.Export(job); err != nil {
+			// The status was already sent, so we can only stop the export
+			log.
+				WithError(err).
+				Warnf("Error exporting jobs")
+			return
+		}
+		if flusher != nil && (i+1)%exportFlushInterval == 0 {
+			exporter.Flush()
+			flusher.Flush()
+		}
+	}
+	exporter.Flush()
+}
+
+// swagger:response
+type pipelinesResponse struct {
+	// in: body
+	Body struct {
+		Pipelines []pipelineResult `json:"pipelines"`
+	}
+}
+
+// swagger:model pipeline
+type pipelineResult struct {
+	// Pipeline name
+	//
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+
+	// Description of the pipeline from the definition
+	// example: Builds and deploys a release
+	Description string `json:"description,omitempty"`
+
+	// Tags of the pipeline from the definition
+	// example: ["deployment","production"]
+	Tags []string `json:"tags,omitempty"`
+
+	// Definition file of the pipeline
+	// example: pipelines/release.yml
+	SourcePath string `json:"sourcePath,omitempty"`
+
+	// Definition files with other declarations of the pipeline that were ignored or overridden
+	// example: ["pipelines/defaults.yml"]
+	ShadowedSourcePaths []string `json:"shadowedSourcePaths,omitempty"`
+
+	// Is a new job for the pipeline schedulable
+	Schedulable bool `json:"schedulable"`
+
+	// Is a job for the pipeline running
+	Running bool `json:"running"`
+
+	// Is the pipeline drained (queued jobs are not started)
+	Drained bool `json:"drained"`
+
+	// Hash of the current pipeline definition, changes if the definition changes
+	// example: 3f2a9c81b7d0
+	DefinitionHash string `json:"definitionHash"`
+
+	// Number of the newest successful jobs the duration statistics are computed from
+	// example: 50
+	DurationSamples int `json:"durationSamples,omitempty"`
+	// Median duration of the newest successful jobs in seconds
+	// example: 93.5
+	DurationMedian *float64 `json:"durationMedian,omitempty"`
+	// 95th percentile of the duration of the newest successful jobs in seconds
+	// example: 140.2
+	DurationP95 *float64 `json:"durationP95,omitempty"`
+}
+
+// swagger:parameters pipelines
+type pipelinesParams struct {
+	// Only list pipelines with the tag, can be repeated to list pipelines with all of the tags
+	//
+	// in: query
+	// example: ["deployment"]
+	Tag []string `json:"tag"`
+}
+
+// swagger:route GET /v1/pipelines/ pipelines
+//
+// List pipelines
+//
+// This will show all defined pipelines with included information about running state or if it is possible to schedule
+// a job for this pipeline.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: pipelinesResponse
+func (s *server) pipelines(w http.ResponseWriter, r *http.Request) {
+	var params pipelinesParams
+	params.Tag = r.URL.Query()["tag"]
+
+	res := s.listPipelines()
+	if len(params.Tag) > 0 {
+		filtered := make([]pipelineResult, 0, len(res))
+		for _, p := range res {
+			if hasAllTags(p.Tags, params.Tag) {
+				filtered = append(filtered, p)
+			}
+		}
+		res = filtered
+	}
+
+	var resp pipelinesResponse
+	resp.Body.Pipelines = res
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters pipelinesJobWait
+type pipelinesJobWaitParams struct {
+	// Job id
+	//
+	// required: true
+	// in: path
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+
+	// Maximum time to wait for the job as duration (defaults to 60s, at most 1h)
+	//
+	// in: query
+	// example: 60s
+	Timeout string `json:"timeout"`
+}
+
+// swagger:route GET /v1/pipelines/jobs/{id}/wait pipelinesJobWait
+//
+// Wait for a job
+//
+// Returns the job as soon as it is finished (completed or canceled) or the timeout elapsed.
+// The status is 200 if the job is finished and 202 if it is still queued or running.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: jobDetailResponse
+//       202: jobDetailResponse
+//       400: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesJobWait(w http.ResponseWriter, r *http.Request) {
+	var params pipelinesJobWaitParams
+
+	params.Id = chi.URLParam(r, "id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	params.Timeout = r.URL.Query().Get("timeout")
+	timeout, err := parseWaitTimeout(params.Timeout)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid timeout parameter: %v", err))
+		return
+	}
+
+	s.waitForJob(w, r, jobID, timeout)
+}
+
+// swagger:parameters pipelinesJobDelete
+type pipelinesJobDeleteParams struct {
+	// Job id
+	//
+	// required: true
+	// in: path
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
+// swagger:route DELETE /v1/pipelines/jobs/{id} pipelinesJobDelete
+//
+// Delete a job
+//
+// Removes a finished job with its logs and temporary directory, e.g. if it captured sensitive data by accident.
+// Queued or running jobs must be canceled first. Requires the admin scope.
+//
+//     Responses:
+//       204:
+//       400: genericErrorResponse
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+//       409: genericErrorResponse
+func (s *server) pipelinesJobDelete(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var params pipelinesJobDeleteParams
+
+	params.Id = chi.URLParam(r, "id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	err = s.pRunner.DeleteJob(jobID)
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if errors.Is(err, prunner.ErrJobNotFinished) {
+		s.sendError(w, http.StatusConflict, ProblemJobNotFinished, "Job is not finished, cancel it first")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error deleting job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error deleting job")
+		return
+	}
+
+	// Deleting jobs is logged for auditing
+	log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		WithField("user", user).
+		Info("Deleted job")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:model archivedJob
+type archivedJobResult struct {
+	// Id of the job
+	//
+	// swagger:strfmt uuid4
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	ID string `json:"id"`
+	// Pipeline name
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+	// Size of the compressed job and logs in bytes
+	// example: 5120
+	Size int64 `json:"size"`
+	// When the job was archived
+	Archived time.Time `json:"archived"`
+}
+
+// swagger:response
+type pipelinesJobsArchivedResponse struct {
+	// in: body
+	Body struct {
+		// Archived jobs (the newest archived job first)
+		Jobs []archivedJobResult `json:"jobs"`
+	}
+}
+
+// swagger:route GET /v1/pipelines/jobs/archived pipelinesJobsArchived
+//
+// Get archived jobs
+//
+// Lists the jobs that were moved to the archive. They can be restored with the rehydrate endpoint.
+//
+//     Responses:
+//       200: pipelinesJobsArchivedResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesJobsArchived(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.pRunner.ArchivedJobs(r.Context())
+	if errors.Is(err, prunner.ErrNoArchive) {
+		s.sendError(w, http.StatusNotFound, ProblemArchiveDisabled, "No archive configured")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			Errorf("Error listing archived jobs")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error listing archived jobs")
+		return
+	}
+
+	var resp pipelinesJobsArchivedResponse
+	resp.Body.Jobs = make([]archivedJobResult, len(entries))
+	for i, entry := range entries {
+		resp.Body.Jobs[i] = archivedJobResult{
+			ID:       entry.JobID.String(),
+			Pipeline: entry.Pipeline,
+			Size:     entry.Size,
+			Archived: entry.Archived,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters pipelinesJobRehydrate
+type pipelinesJobRehydrateParams struct {
+	// Job id
+	//
+	// required: true
+	// in: path
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
+// swagger:route POST /v1/pipelines/jobs/{id}/rehydrate pipelinesJobRehydrate
+//
+// Rehydrate an archived job
+//
+// Restores an archived job with its logs, so it is listed and can be inspected like other jobs. The job is removed from
+// the archive and retained again from now on. Rehydrating a job that was already restored has no effect.
+//
+//     Responses:
+//       204:
+//       400: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesJobRehydrate(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var params pipelinesJobRehydrateParams
+
+	params.Id = chi.URLParam(r, "id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	err = s.pRunner.RehydrateJob(r.Context(), jobID)
+	if errors.Is(err, prunner.ErrNoArchive) {
+		s.sendError(w, http.StatusNotFound, ProblemArchiveDisabled, "No archive configured")
+		return
+	} else if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found in archive")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error rehydrating job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error rehydrating job")
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		WithField("user", user).
+		Info("Rehydrated job")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:parameters pipelinesJobRequeue
+type pipelinesJobRequeueParams struct {
+	// Id of the canceled or expired job
+	//
+	// required: true
+	// in: path
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
+// swagger:route POST /v1/pipelines/jobs/{id}/requeue pipelinesJobRequeue
+//
+// Requeue a canceled job
+//
+// Schedules a new job with the variables, labels and priority of a canceled or expired job. The new job references
+// the original job with requeuedFrom and is scheduled like any other job (it is added to the wait list or started).
+//
+//     Responses:
+//       202: pipelinesScheduleResponse
+//       400: genericErrorResponse
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+//       409: genericErrorResponse
+//       503: genericErrorResponse
+func (s *server) pipelinesJobRequeue(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var params pipelinesJobRequeueParams
+
+	params.Id = chi.URLParam(r, "id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	if !s.checkJobPriorityScope(w, claims, jobID) {
+		return
+	}
+
+	pJob, err := s.pRunner.RequeueJob(jobID, user)
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if errors.Is(err, prunner.ErrJobNotCanceled) {
+		s.sendError(w, http.StatusConflict, ProblemJobNotCanceled, "Job was not canceled or expired")
+		return
+	} else if err != nil {
+		s.sendScheduleError(w, err)
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("jobID", pJob.ID).
+		WithField("requeuedFrom", jobID).
+		WithField("pipeline", pJob.Pipeline).
+		WithField("user", user).
+		Info("Job requeued")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	var resp pipelinesScheduleResponse
+	resp.Body.JobID = pJob.ID.String()
+	if queuePosition, err := s.pRunner.QueuePosition(pJob.ID); err == nil {
+		resp.Body.Queue = &scheduleQueueResult{
+			Position:       queuePosition.Position,
+			Running:        queuePosition.Running,
+			EstimatedStart: queuePosition.EstimatedStart,
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// checkJobPriorityScope sends an error and returns false if a job that is scheduled again has a priority the user is
+// not allowed to schedule (as for pipelinesSchedule)
+func (s *server) checkJobPriorityScope(w http.ResponseWriter, claims map[string]interface{}, jobID uuid.UUID) bool {
+	var priority prunner.JobPriority
+	err := s.pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+		priority = j.Priority
+	})
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return false
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error reading job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading job")
+		return false
+	}
+	if priority != prunner.JobPriorityNormal && !hasScope(claims, ScopeSchedulePriority) {
+		s.sendError(w, http.StatusForbidden, ProblemMissingScope, fmt.Sprintf("Scope %s is required for priority %s", ScopeSchedulePriority, priority))
+		return false
+	}
+	return true
+}
+
+// swagger:parameters pipelinesJobClone
+type pipelinesJobCloneParams struct {
+	// Id of the job to clone
+	//
+	// required: true
+	// in: path
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
+// swagger:route POST /v1/pipelines/jobs/{id}/clone pipelinesJobClone
+//
+// Clone a job
+//
+// Schedules a new job of the same pipeline with the variables, priority and labels of an existing job, e.g. to run
+// the exact same deployment again. The new job references the original job with clonedFrom. A correlation ID can be
+// set with the X-Request-ID or X-Correlation-ID header as for scheduling.
+//
+//     Responses:
+//       202: pipelinesScheduleResponse
+//       400: genericErrorResponse
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+//       503: genericErrorResponse
+func (s *server) pipelinesJobClone(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var params pipelinesJobCloneParams
+
+	params.Id = chi.URLParam(r, "id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+	correlationID, err := correlationIDFromRequest(r)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Invalid correlation ID: %v", err))
+		return
+	}
+
+	if !s.checkJobPriorityScope(w, claims, jobID) {
+		return
+	}
+
+	pJob, err := s.pRunner.CloneJob(jobID, prunner.ScheduleOpts{User: user, CorrelationID: correlationID, Trigger: prunner.TriggerAPI})
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if err != nil {
+		s.sendScheduleError(w, err)
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("jobID", pJob.ID).
+		WithField("clonedFrom", jobID).
+		WithField("pipeline", pJob.Pipeline).
+		WithField("user", user).
+		WithField("correlationID", correlationID).
+		Info("Job cloned")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	var resp pipelinesScheduleResponse
+	resp.Body.JobID = pJob.ID.String()
+	if queuePosition, err := s.pRunner.QueuePosition(pJob.ID); err == nil {
+		resp.Body.Queue = &scheduleQueueResult{
+			Position:       queuePosition.Position,
+			Running:        queuePosition.Running,
+			EstimatedStart: queuePosition.EstimatedStart,
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:model annotation
+type annotationResult struct {
+	// Text of the note
+	// example: Failed due to registry outage, safe to ignore
+	Text string `json:"text"`
+	// User that added the note
+	// example: j.doe
+	User string `json:"user"`
+	// When the note was added
+	Created time.Time `json:"created"`
+}
+
+func annotationsToResult(annotations []prunner.JobAnnotation) []annotationResult {
+	if len(annotations) == 0 {
+		return nil
+	}
+	result := make([]annotationResult, len(annotations))
+	for i, a := range annotations {
+		result[i] = annotationResult{Text: a.Text, User: a.User, Created: a.Created}
+	}
+	return result
+}
+
+// swagger:parameters pipelinesJobAnnotate
+type pipelinesJobAnnotateRequest struct {
+	// Job id
+	//
+	// required: true
+	// in: path
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+
+	// in: body
+	Body struct {
+		// Text of the note
+		// required: true
+		// example: Failed due to registry outage, safe to ignore
+		Text string `json:"text"`
+	}
+}
+
+// swagger:response
+type pipelinesJobAnnotateResponse struct {
+	// in: body
+	Body annotationResult
+}
+
+// swagger:route POST /v1/pipelines/jobs/{id}/annotations pipelinesJobAnnotate
+//
+// Annotate a job
+//
+// Attaches a free-text note to a job in any state (e.g. why a failure can be ignored). Annotations are persisted with
+// the job and returned in the job details.
+//
+//     Responses:
+//       201: pipelinesJobAnnotateResponse
+//       400: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesJobAnnotate(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var in pipelinesJobAnnotateRequest
+
+	in.Id = chi.URLParam(r, "id")
+	jobID, err := uuid.FromString(in.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", in.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+	if !s.decodeJSONBody(w, r, &in.Body) {
+		return
+	}
+
+	annotation, err := s.pRunner.AnnotateJob(jobID, user, in.Body.Text)
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if errors.Is(err, prunner.ErrInvalidAnnotation) {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, fmt.Sprintf("Error annotating job: %v", err))
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error annotating job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error annotating job")
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		WithField("user", user).
+		Info("Job annotated")
+
+	var resp pipelinesJobAnnotateResponse
+	resp.Body = annotationResult{Text: annotation.Text, User: annotation.User, Created: annotation.Created}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters pipelinesQueue
+type pipelinesQueueParams struct {
+	// Pipeline name
+	//
+	// required: true
+	// in: path
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+}
+
+// swagger:model queuedJob
+type queuedJobResult struct {
+	// Job id
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	JobID string `json:"jobId"`
+	// Position on the wait list, starting at 1 for the next job to start
+	// example: 1
+	Position int `json:"position"`
+	// Time the job was queued
+	// example: 2021-10-11T09:05:00Z
+	Queued time.Time `json:"queued"`
+	// Priority of the job (normal or high)
+	// example: normal
+	Priority string `json:"priority"`
+	// User that scheduled the job
+	// example: j.doe
+	User string `json:"user,omitempty"`
+	// Labels of the job
+	Labels map[string]string `json:"labels,omitempty"`
+	// Earliest start of the job if it has a pending start delay
+	// example: 2021-10-11T09:05:10Z
+	StartAt *time.Time `json:"startAt,omitempty"`
+}
+
+// swagger:response
+type pipelinesQueueResponse struct {
+	// in: body
+	Body struct {
+		// Pipeline name
+		// example: my_pipeline
+		Pipeline string `json:"pipeline"`
+		// Queue strategy of the pipeline (append, replace or replace_running)
+		// example: append
+		QueueStrategy string `json:"queueStrategy"`
+		// Maximum number of queued jobs (not set if unbounded)
+		// example: 5
+		QueueLimit *int `json:"queueLimit,omitempty"`
+		// Maximum number of concurrently running jobs
+		// example: 1
+		Concurrency int `json:"concurrency"`
+		// Number of running jobs
+		// example: 1
+		Running int `json:"running"`
+		// Reason why queued jobs are not started
+		// example: concurrency of 1 reached
+		Reason string `json:"reason,omitempty"`
+		// Queued jobs in the order they will be started
+		Jobs []queuedJobResult `json:"jobs"`
+	}
+}
+
+// swagger:route GET /v1/pipelines/{pipeline}/queue pipelinesQueue
+//
+// Get the wait list of a pipeline
+//
+// Returns the queued jobs of the pipeline in the order they will be started, with the queue strategy in effect and
+// the reason why they are not started yet.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: pipelinesQueueResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesQueue(w http.ResponseWriter, r *http.Request) {
+	var params pipelinesQueueParams
+	params.Pipeline = chi.URLParam(r, "pipeline")
+
+	waitList, err := s.pRunner.WaitList(params.Pipeline)
+	if errors.Is(err, prunner.ErrPipelineNotDefined) {
+		s.sendError(w, http.StatusNotFound, ProblemPipelineNotFound, "Pipeline not found")
+		return
+	} else if err != nil {
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading wait list")
+		return
+	}
+
+	var resp pipelinesQueueResponse
+	resp.Body.Pipeline = waitList.Pipeline
+	resp.Body.QueueStrategy = waitList.QueueStrategy.String()
+	resp.Body.QueueLimit = waitList.QueueLimit
+	resp.Body.Concurrency = waitList.Concurrency
+	resp.Body.Running = waitList.Running
+	resp.Body.Reason = waitList.Reason
+	resp.Body.Jobs = make([]queuedJobResult, len(waitList.Jobs))
+	for i, job := range waitList.Jobs {
+		resp.Body.Jobs[i] = queuedJobResult{
+			JobID:    job.ID.String(),
+			Position: job.Position,
+			Queued:   job.Queued,
+			Priority: job.Priority.String(),
+			User:     job.User,
+			Labels:   job.Labels,
+			StartAt:  job.StartAt,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters jobLogs
+type jobLogsParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+
+	// Task name (either task or service is required)
+	//
+	// in: query
+	// example: my_task
+	Task string `json:"task"`
+
+	// Service name to fetch the output of a service instead of a task
+	//
+	// in: query
+	// example: dev_server
+	Service string `json:"service"`
+}
+
+// swagger:response
+type jobLogsResponse struct {
+	// in: body
+	Body struct {
+		// STDOUT output of task
+		Stdout string `json:"stdout"`
+		// STDERR output of task
+		Stderr string `json:"stderr"`
+	}
+}
+
+// swagger:route GET /v1/job/logs jobLogs
+//
+// Get job logs
+//
+// Task output for the given job and task (or service) will be fetched and returned for STDOUT / STDERR.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: jobLogsResponse
+//       400: genericErrorResponse
+//       404:
+//       500:
+func (s *server) jobLogs(w http.ResponseWriter, r *http.Request) {
+	var params jobLogsParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+	params.Task = vars.Get("task")
+	params.Service = vars.Get("service")
+	if params.Task == "" && params.Service == "" {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid task name")
+		return
+	}
+
+	// The output of a service is stored like the output of a task
+	outputName := params.Task
+	if params.Service != "" {
+		outputName = taskctl.ServiceOutputName(params.Service)
+	}
+
+	var taskExists bool
+	err = s.pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+		if params.Service != "" {
+			taskExists = j.Services.ByName(params.Service) != nil
+		} else if task := j.Tasks.ByName(params.Task); task != nil {
+			taskExists = true
+		}
+	})
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error reading job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading job")
+	}
+
+	if !taskExists && params.Service != "" {
+		s.sendError(w, http.StatusNotFound, ProblemServiceNotFound, "Service not found")
+		return
+	}
+	if !taskExists {
+		s.sendError(w, http.StatusNotFound, ProblemTaskNotFound, "Task not found")
+		return
+	}
+
+	var (
+		stdout []byte
+		stderr []byte
+	)
+	stdoutReader, err := s.outputStore.Reader(jobID.String(), outputName, "stdout")
+	if err != nil {
+		log.
+			WithError(err).
+			Errorf("failed to read output store")
+	} else {
+		stdout, _ = ioutil.ReadAll(stdoutReader)
+		stdoutReader.Close()
+	}
+
+	stderrReader, err := s.outputStore.Reader(jobID.String(), outputName, "stderr")
+	if err != nil {
+		log.
+			WithError(err).
+			Errorf("failed to read output store")
+	} else {
+		stderr, _ = ioutil.ReadAll(stderrReader)
+		stderrReader.Close()
+	}
+
+	var resp jobLogsResponse
+	resp.Body.Stdout = string(stdout)
+	resp.Body.Stderr = string(stderr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters jobDetail
+type jobDetailParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
+// swagger:response
+type jobDetailResponse struct {
+	// in: body
+	Body pipelineJobResult
+}
+
+// swagger:route GET /v1/job/detail jobDetail
+//
+// Get job details
+//
+// Get details about a single job.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: jobDetailResponse
+//       400: genericErrorResponse
+//       404:
+func (s *server) jobDetail(w http.ResponseWriter, r *http.Request) {
+	var params jobDetailParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	var result pipelineJobResult
+
+	err = s.pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+		result = jobToResult(j)
+	})
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error reading job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading job")
+	}
+
+	var resp jobDetailResponse
+	resp.Body = result
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters jobCancel
+type jobCancelParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
+// swagger:route POST /v1/job/cancel jobCancel
+//
+// Cancel a running job
+//
+// Cancels the job and all tasks, but does not wait until all tasks are canceled.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default:
+//       400: genericErrorResponse
+//       404:
+func (s *server) jobCancel(w http.ResponseWriter, r *http.Request) {
+	var params jobCancelParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		Info("Canceling job")
+
+	err = s.pRunner.CancelJob(jobID)
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error canceling job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error canceling job")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(true)
+}
+
+// swagger:parameters pipelinesCancel
+type pipelinesCancelParams struct {
+	// Pipeline name
+	//
+	// required: true
+	// in: path
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+
+	// Also cancel running jobs
+	//
+	// in: query
+	Running bool `json:"running"`
+}
+
+// swagger:response
+type pipelinesCancelResponse struct {
+	// in: body
+	Body struct {
+		// Ids of the canceled jobs
+		// example: ["52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8"]
+		CanceledJobIDs []string `json:"canceledJobIds"`
+	}
+}
+
+// swagger:route POST /v1/pipelines/{pipeline}/cancel pipelinesCancel
+//
+// Cancel all jobs of a pipeline
+//
+// Cancels all queued jobs of the pipeline, with running=true running jobs are canceled as well (without waiting until
+// their tasks are canceled).
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: pipelinesCancelResponse
+//       400: genericErrorResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesCancel(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var params pipelinesCancelParams
+	params.Pipeline = chi.URLParam(r, "pipeline")
+	if running := r.URL.Query().Get("running"); running != "" {
+		var err error
+		params.Running, err = strconv.ParseBool(running)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid running parameter")
+			return
+		}
+	}
+
+	canceledJobIDs, err := s.pRunner.CancelPipelineJobs(params.Pipeline, params.Running)
+	if errors.Is(err, prunner.ErrPipelineNotDefined) {
+		s.sendError(w, http.StatusNotFound, ProblemPipelineNotFound, "Pipeline not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("pipeline", params.Pipeline).
+			Errorf("Error canceling jobs of pipeline")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error canceling jobs")
+		return
+	}
+
+	// Canceling all jobs of a pipeline is logged for auditing
+	log.
+		WithField("component", "api").
+		WithField("pipeline", params.Pipeline).
+		WithField("user", user).
+		WithField("running", params.Running).
+		WithField("canceled", len(canceledJobIDs)).
+		Info("Canceled jobs of pipeline")
+
+	var resp pipelinesCancelResponse
+	resp.Body.CanceledJobIDs = make([]string, len(canceledJobIDs))
+	for i, id := range canceledJobIDs {
+		resp.Body.CanceledJobIDs[i] = id.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:parameters pipelinesDrain
+type pipelinesDrainParams struct {
+	// Pipeline name
+	//
+	// required: true
+	// in: path
+	// example: my_pipeline
+	Pipeline string `json:"pipeline"`
+}
+
+// swagger:response
+type pipelinesDrainResponse struct {
+	// in: body
+	Body struct {
+		// Pipeline name
+		// example: my_pipeline
+		Pipeline string `json:"pipeline"`
+		// Is the pipeline drained
+		Drained bool `json:"drained"`
+	}
+}
+
+// swagger:route POST /v1/pipelines/{pipeline}/drain pipelinesDrain
+//
+// Drain a pipeline
+//
+// Stops starting queued jobs of the pipeline, running jobs finish and new jobs are queued. A DELETE request to the
+// same path undrains the pipeline and starts the queued jobs.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: pipelinesDrainResponse
+//       404: genericErrorResponse
+func (s *server) pipelinesDrain(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
+
+	var params pipelinesDrainParams
+	params.Pipeline = chi.URLParam(r, "pipeline")
+	drained := r.Method != http.MethodDelete
+
+	err := s.pRunner.DrainPipeline(params.Pipeline, drained)
+	if errors.Is(err, prunner.ErrPipelineNotDefined) {
+		s.sendError(w, http.StatusNotFound, ProblemPipelineNotFound, "Pipeline not found")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("pipeline", params.Pipeline).
+			Errorf("Error draining pipeline")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error draining pipeline")
+		return
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("pipeline", params.Pipeline).
+		WithField("user", user).
+		WithField("drained", drained).
+		Info("Changed drained state of pipeline")
+
+	var resp pipelinesDrainResponse
+	resp.Body.Pipeline = params.Pipeline
+	resp.Body.Drained = drained
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp.Body)
 }
 
+// swagger:parameters jobPromote
+type jobPromoteParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+}
+
 // swagger:response
-type pipelinesResponse struct {
+type jobPromoteResponse struct {
 	// in: body
 	Body struct {
-		Pipelines []pipelineResult `json:"pipelines"`
+		// Position of the job on the wait list before it was promoted
+		// example: 3
+		PreviousPosition int `json:"previousPosition"`
 	}
 }
 
-// swagger:model pipeline
-type pipelineResult struct {
-	// Pipeline name
-	//
-	// example: my_pipeline
-	Pipeline string `json:"pipeline"`
-
-	// Is a new job for the pipeline schedulable
-	Schedulable bool `json:"schedulable"`
-
-	// Is a job for the pipeline running
-	Running bool `json:"running"`
-}
-
-// swagger:route GET /pipelines/ pipelines
+// swagger:route POST /v1/job/promote jobPromote
 //
-// List pipelines
+// Promote a queued job
 //
-// This will show all defined pipelines with included information about running state or if it is possible to schedule
-// a job for this pipeline.
+// Moves a queued job to the front of the wait list of its pipeline, other queued jobs keep their order.
 //
 //     Produces:
 //     - application/json
 //
 //     Responses:
-//       default: pipelinesResponse
-func (s *server) pipelines(w http.ResponseWriter, r *http.Request) {
-	res := s.listPipelines()
+//       default: jobPromoteResponse
+//       400: genericErrorResponse
+//       404: genericErrorResponse
+//       409: genericErrorResponse
+func (s *server) jobPromote(w http.ResponseWriter, r *http.Request) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	var user string
+	if sub, ok := claims["sub"].(string); ok {
+		user = sub
+	}
 
-	var resp pipelinesResponse
-	resp.Body.Pipelines = res
+	var params jobPromoteParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+
+	previousPosition, err := s.pRunner.PromoteJob(jobID)
+	if errors.Is(err, prunner.ErrJobNotFound) {
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if errors.Is(err, prunner.ErrJobNotQueued) {
+		s.sendError(w, http.StatusConflict, ProblemJobNotQueued, "Job is not queued")
+		return
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			Errorf("Error promoting job")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error promoting job")
+		return
+	}
+
+	// Promoting a job delays all other queued jobs, so it is logged for auditing
+	log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		WithField("user", user).
+		WithField("previousPosition", previousPosition).
+		Info("Job promoted to front of wait list")
+
+	var resp jobPromoteResponse
+	resp.Body.PreviousPosition = previousPosition
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp.Body)
 }
 
-// swagger:parameters jobLogs
-type jobLogsParams struct {
+// swagger:parameters jobDefinitionDiff
+type jobDefinitionDiffParams struct {
 	// Job id
 	//
 	// required: true
 	// in: query
 	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
 	Id string `json:"id"`
+}
 
-	// Task name
-	//
-	// required: true
-	// in: query
-	// example: my_task
-	Task string `json:"task"`
+// swagger:model definitionChange
+type definitionChangeResult struct {
+	// Name of the changed task, empty for settings of the pipeline
+	// example: deploy
+	Task string `json:"task,omitempty"`
+	// Name of the changed setting, environment variables are named env.NAME (empty if a whole task was added or removed)
+	// example: script
+	Field string `json:"field,omitempty"`
+	// Kind of change: added, removed or changed
+	// example: changed
+	Kind string `json:"kind"`
+	// Value in the definition of the job
+	Old string `json:"old,omitempty"`
+	// Value in the current definition
+	New string `json:"new,omitempty"`
 }
 
 // swagger:response
-type jobLogsResponse struct {
+type jobDefinitionDiffResponse struct {
 	// in: body
 	Body struct {
-		// STDOUT output of task
-		Stdout string `json:"stdout"`
-		// STDERR output of task
-		Stderr string `json:"stderr"`
+		// Hash of the pipeline definition the job was scheduled with
+		// example: 3f2a9c81b7d0
+		JobDefinitionHash string `json:"jobDefinitionHash"`
+		// Hash of the current pipeline definition
+		// example: 8c1e0b5d2a47
+		CurrentDefinitionHash string `json:"currentDefinitionHash"`
+		// Changes from the definition of the job to the current definition
+		Changes []definitionChangeResult `json:"changes"`
 	}
 }
 
-// swagger:route GET /job/logs jobLogs
+// swagger:route GET /v1/job/definition-diff jobDefinitionDiff
 //
-// Get job logs
+// Diff job definition
 //
-// Task output for the given job and task will be fetched and returned for STDOUT / STDERR.
+// Compares the pipeline definition a job was scheduled with to the current definition of the pipeline.
 //
 //     Produces:
 //     - application/json
 //
 //     Responses:
-//       default: jobLogsResponse
+//       default: jobDefinitionDiffResponse
 //       400: genericErrorResponse
-//       404:
-//       500:
-func (s *server) jobLogs(w http.ResponseWriter, r *http.Request) {
-	var params jobLogsParams
+//       404: genericErrorResponse
+func (s *server) jobDefinitionDiff(w http.ResponseWriter, r *http.Request) {
+	var params jobDefinitionDiffParams
 
 	vars := r.URL.Query()
 	params.Id = vars.Get("id")
@@ -389,210 +2577,354 @@ func (s *server) jobLogs(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.
 			WithError(err).
-			WithField("jobID", params.Id).
+			WithField("jobIdString", params.Id).
 			Warn("Invalid job ID")
-		s.sendError(w, http.StatusBadRequest, "Invalid job id")
-		return
-	}
-	params.Task = vars.Get("task")
-	if params.Task == "" {
-		s.sendError(w, http.StatusBadRequest, "Invalid task name")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
 		return
 	}
 
-	var taskExists bool
-	err = s.pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
-		if task := j.Tasks.ByName(params.Task); task != nil {
-			taskExists = true
-		}
-	})
+	diff, err := s.pRunner.DiffJobDefinition(jobID)
 	if errors.Is(err, prunner.ErrJobNotFound) {
-		s.sendError(w, http.StatusNotFound, "Job not found")
+		s.sendError(w, http.StatusNotFound, ProblemJobNotFound, "Job not found")
+		return
+	} else if errors.Is(err, prunner.ErrPipelineNotDefined) {
+		s.sendError(w, http.StatusNotFound, ProblemPipelineNotFound, "Pipeline of job is not defined anymore")
 		return
 	} else if err != nil {
 		log.
 			WithError(err).
 			WithField("jobID", jobID).
-			Errorf("Error reading job")
-		s.sendError(w, http.StatusInternalServerError, "Error reading job")
+			Errorf("Error diffing job definition")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error diffing job definition")
+		return
 	}
 
-	if !taskExists {
-		s.sendError(w, http.StatusNotFound, "Task not found")
-		return
+	var resp jobDefinitionDiffResponse
+	resp.Body.JobDefinitionHash = diff.JobDefinitionHash
+	resp.Body.CurrentDefinitionHash = diff.CurrentDefinitionHash
+	resp.Body.Changes = make([]definitionChangeResult, len(diff.Changes))
+	for i, change := range diff.Changes {
+		resp.Body.Changes[i] = definitionChangeResult{
+			Task:  change.Task,
+			Field: change.Field,
+			Kind:  string(change.Kind),
+			Old:   change.Old,
+			New:   change.New,
+		}
 	}
 
-	var (
-		stdout []byte
-		stderr []byte
-	)
-	stdoutReader, err := s.outputStore.Reader(jobID.String(), params.Task, "stdout")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp.Body)
+}
+
+// swagger:response
+type adminDiskUsageResponse struct {
+	// in: body
+	Body struct {
+		// Data directory of prunner
+		// example: .prunner
+		Path string `json:"path"`
+		// Size of all files in the data directory (job metadata and logs) in bytes
+		// example: 52428800
+		DataBytes int64 `json:"dataBytes"`
+		// Size of the volume containing the data directory in bytes
+		// example: 107374182400
+		TotalBytes uint64 `json:"totalBytes"`
+		// Free bytes of the volume containing the data directory
+		// example: 53687091200
+		FreeBytes uint64 `json:"freeBytes"`
+		// Free bytes of the volume available to unprivileged users
+		// example: 48318382080
+		AvailableBytes uint64 `json:"availableBytes"`
+		// Used space of the volume in percent
+		// example: 50
+		UsedPercent float64 `json:"usedPercent"`
+	}
+}
+
+// swagger:route GET /v1/admin/disk-usage adminDiskUsage
+//
+// Get disk usage of the data directory
+//
+// Reports the size of the data directory and the usage of the volume containing it.
+// Volume statistics are only reported on Linux, macOS and FreeBSD.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: adminDiskUsageResponse
+//       403: genericErrorResponse
+//       500: genericErrorResponse
+func (s *server) adminDiskUsage(w http.ResponseWriter, r *http.Request) {
+	var resp adminDiskUsageResponse
+	resp.Body.Path = s.dataDir
+
+	dataBytes, err := hoststat.DirSize(s.dataDir)
 	if err != nil {
 		log.
 			WithError(err).
-			Errorf("failed to read output store")
-	} else {
-		stdout, _ = ioutil.ReadAll(stdoutReader)
-		stdoutReader.Close()
+			WithField("path", s.dataDir).
+			Errorf("Error reading data directory size")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading data directory size")
+		return
 	}
+	resp.Body.DataBytes = dataBytes
 
-	stderrReader, err := s.outputStore.Reader(jobID.String(), params.Task, "stderr")
-	if err != nil {
+	usage, err := hoststat.ReadDiskUsage(s.dataDir)
+	if err != nil && !errors.Is(err, hoststat.ErrNotSupported) {
 		log.
 			WithError(err).
-			Errorf("failed to read output store")
-	} else {
-		stderr, _ = ioutil.ReadAll(stderrReader)
-		stderrReader.Close()
+			WithField("path", s.dataDir).
+			Errorf("Error reading disk usage")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading disk usage")
+		return
 	}
-
-	var resp jobLogsResponse
-	resp.Body.Stdout = string(stdout)
-	resp.Body.Stderr = string(stderr)
+	resp.Body.TotalBytes = usage.Total
+	resp.Body.FreeBytes = usage.Free
+	resp.Body.AvailableBytes = usage.Available
+	resp.Body.UsedPercent = usage.UsedPercent()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp.Body)
 }
 
-// swagger:parameters jobDetail
-type jobDetailParams struct {
-	// Job id
-	//
-	// required: true
-	// in: query
-	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
-	Id string `json:"id"`
+type pipelineDataUsageResult struct {
+	Pipeline string `json:"pipeline"`
+	// Number of retained jobs of the pipeline
+	// example: 10
+	Jobs int `json:"jobs"`
+	// Size of the logs of the retained jobs in bytes
+	// example: 1048576
+	LogBytes int64 `json:"logBytes"`
+	// Size of temporary directories of failed jobs (kept with keep_tmp_on_failure) and running jobs in bytes
+	// example: 0
+	ArtifactBytes int64 `json:"artifactBytes"`
 }
 
 // swagger:response
-type jobDetailResponse struct {
+type adminDataUsageResponse struct {
 	// in: body
-	Body pipelineJobResult
+	Body struct {
+		// Data directory of prunner
+		// example: .prunner
+		Path string `json:"path"`
+		// Size of the job store (all files of the data directory except logs) in bytes
+		// example: 524288
+		StoreBytes int64 `json:"storeBytes"`
+		// Size of all logs in the data directory in bytes (including logs of jobs that are not retained anymore)
+		// example: 52428800
+		LogBytes int64 `json:"logBytes"`
+		// Size of all artifacts of retained jobs in bytes
+		// example: 0
+		ArtifactBytes int64 `json:"artifactBytes"`
+		// Number of retained jobs of all pipelines
+		// example: 42
+		Jobs int `json:"jobs"`
+		// Usage by pipeline ordered by name
+		Pipelines []pipelineDataUsageResult `json:"pipelines"`
+	}
 }
 
-// swagger:route GET /job/detail jobDetail
+// swagger:route GET /v1/admin/data-usage adminDataUsage
 //
-// Get job details
+// Get usage of the data directory by pipeline
 //
-// Get details about a single job.
+// Reports the size of the job store and logs in the data directory, the size of artifacts and the number of retained
+// jobs. Log and artifact sizes are also reported by pipeline.
 //
 //     Produces:
 //     - application/json
 //
 //     Responses:
-//       default: jobDetailResponse
-//       400: genericErrorResponse
-//       404:
-func (s *server) jobDetail(w http.ResponseWriter, r *http.Request) {
-	var params jobDetailParams
-
-	vars := r.URL.Query()
-	params.Id = vars.Get("id")
-	jobID, err := uuid.FromString(params.Id)
+//       default: adminDataUsageResponse
+//       403: genericErrorResponse
+//       500: genericErrorResponse
+func (s *server) adminDataUsage(w http.ResponseWriter, r *http.Request) {
+	var resp adminDataUsageResponse
+	resp.Body.Path = s.dataDir
+
+	dataBytes, err := hoststat.DirSize(s.dataDir)
 	if err != nil {
 		log.
 			WithError(err).
-			WithField("jobID", params.Id).
-			Warn("Invalid job ID")
-		s.sendError(w, http.StatusBadRequest, "Invalid job id")
+			WithField("path", s.dataDir).
+			Errorf("Error reading data directory size")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading data directory size")
 		return
 	}
-
-	var result pipelineJobResult
-
-	err = s.pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
-		result = jobToResult(j)
-	})
-	if errors.Is(err, prunner.ErrJobNotFound) {
-		s.sendError(w, http.StatusNotFound, "Job not found")
-		return
-	} else if err != nil {
+	logsDir := filepath.Join(s.dataDir, "logs")
+	logBytes, err := hoststat.DirSize(logsDir)
+	if err != nil && !os.IsNotExist(err) {
 		log.
 			WithError(err).
-			WithField("jobID", jobID).
-			Errorf("Error reading job")
-		s.sendError(w, http.StatusInternalServerError, "Error reading job")
+			WithField("path", logsDir).
+			Errorf("Error reading logs directory size")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error reading logs directory size")
+		return
 	}
-
-	var resp jobDetailResponse
-	resp.Body = result
+	resp.Body.StoreBytes = dataBytes - logBytes
+	resp.Body.LogBytes = logBytes
+
+	usage := s.pRunner.DataUsage()
+	resp.Body.Pipelines = make([]pipelineDataUsageResult, 0, len(usage))
+	for pipeline, pipelineUsage := range usage {
+		resp.Body.Jobs += pipelineUsage.Jobs
+		resp.Body.ArtifactBytes += pipelineUsage.ArtifactBytes
+		resp.Body.Pipelines = append(resp.Body.Pipelines, pipelineDataUsageResult{
+			Pipeline:      pipeline,
+			Jobs:          pipelineUsage.Jobs,
+			LogBytes:      pipelineUsage.LogBytes,
+			ArtifactBytes: pipelineUsage.ArtifactBytes,
+		})
+	}
+	sort.Slice(resp.Body.Pipelines, func(i, j int) bool {
+		return resp.Body.Pipelines[i].Pipeline < resp.Body.Pipelines[j].Pipeline
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp.Body)
 }
 
-// swagger:parameters jobCancel
-type jobCancelParams struct {
-	// Job id
+// swagger:parameters adminBackup
+type adminBackupParams struct {
+	// Do not include job logs
 	//
-	// required: true
 	// in: query
-	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
-	Id string `json:"id"`
+	WithoutLogs bool `json:"withoutLogs"`
+
+	// Only include logs of jobs of the pipeline, can be repeated
+	//
+	// in: query
+	// example: ["my_pipeline"]
+	Pipeline []string `json:"pipeline"`
 }
 
-// swagger:route POST /job/cancel jobCancel
+// swagger:route GET /v1/admin/backup adminBackup
 //
-// Cancel a running job
+// Download a backup
 //
-// Cancels the job and all tasks, but does not wait until all tasks are canceled.
+// Streams a gzipped tarball with the current job state and logs, which can be restored with `prunner restore`.
+// The job state is a consistent snapshot of the runner, logs of running jobs contain the output until the backup.
+// Requires the admin scope.
 //
 //     Produces:
-//     - application/json
+//     - application/gzip
 //
 //     Responses:
-//       default:
+//       200:
 //       400: genericErrorResponse
-//       404:
-func (s *server) jobCancel(w http.ResponseWriter, r *http.Request) {
-	var params jobCancelParams
-
+//       403: genericErrorResponse
+func (s *server) adminBackup(w http.ResponseWriter, r *http.Request) {
+	var params adminBackupParams
 	vars := r.URL.Query()
-	params.Id = vars.Get("id")
-	jobID, err := uuid.FromString(params.Id)
+	if v := vars.Get("withoutLogs"); v != "" {
+		withoutLogs, err := strconv.ParseBool(v)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid withoutLogs parameter")
+			return
+		}
+		params.WithoutLogs = withoutLogs
+	}
+	params.Pipeline = vars["pipeline"]
+
+	data, err := s.pRunner.Snapshot()
 	if err != nil {
 		log.
 			WithError(err).
-			WithField("jobIdString", params.Id).
-			Warn("Invalid job ID")
-		s.sendError(w, http.StatusBadRequest, "Invalid job id")
+			Errorf("Error creating snapshot for backup")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error creating snapshot")
 		return
 	}
 
-	log.
-		WithField("component", "api").
-		WithField("jobID", jobID).
-		Info("Canceling job")
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"prunner-backup-%s.tar.gz\"", time.Now().UTC().Format("20060102-150405")))
+	w.WriteHeader(http.StatusOK)
 
-	err = s.pRunner.CancelJob(jobID)
-	if errors.Is(err, prunner.ErrJobNotFound) {
-		s.sendError(w, http.StatusNotFound, "Job not found")
-		return
-	} else if err != nil {
+	result, err := backup.Write(w, data, filepath.Join(s.dataDir, "logs"), backup.Options{
+		SkipLogs:  params.WithoutLogs,
+		Pipelines: params.Pipeline,
+	})
+	if err != nil {
+		// The status was already sent, so the client gets a truncated backup
 		log.
 			WithError(err).
-			WithField("jobID", jobID).
-			Errorf("Error canceling job")
-		s.sendError(w, http.StatusInternalServerError, "Error canceling job")
+			Errorf("Error writing backup")
 		return
 	}
 
+	log.
+		WithField("jobs", result.Jobs).
+		WithField("logFiles", result.LogFiles).
+		Info("Backup written")
+}
+
+// swagger:model lintWarning
+type lintWarningResult struct {
+	// Pipeline of the warning
+	// example: release_it
+	Pipeline string `json:"pipeline"`
+	// Task of the warning, empty for warnings about the pipeline
+	// example: notify
+	Task string `json:"task,omitempty"`
+	// Kind of the warning: unreachable-task, isolated-task, shadowed-env or missing-description
+	// example: isolated-task
+	Check string `json:"check"`
+	// Description of the warning
+	// example: task has no dependencies and no dependents
+	Message string `json:"message"`
+}
+
+// swagger:response
+type adminLintResponse struct {
+	// in: body
+	Body struct {
+		// Warnings ordered by pipeline and task
+		Warnings []lintWarningResult `json:"warnings"`
+	}
+}
+
+// swagger:route GET /v1/admin/lint adminLint
+//
+// Lint the pipeline definitions
+//
+// Checks the current pipeline definitions for settings that are valid but most likely a mistake: unreachable tasks,
+// isolated tasks in pipelines with dependencies, shadowed environment variables and pipelines without description.
+//
+//     Produces:
+//     - application/json
+//
+//     Responses:
+//       default: adminLintResponse
+//       403: genericErrorResponse
+func (s *server) adminLint(w http.ResponseWriter, r *http.Request) {
+	warnings := s.pRunner.LintDefinitions()
+
+	var resp adminLintResponse
+	resp.Body.Warnings = make([]lintWarningResult, len(warnings))
+	for i, warning := range warnings {
+		resp.Body.Warnings[i] = lintWarningResult{
+			Pipeline: warning.Pipeline,
+			Task:     warning.Task,
+			Check:    string(warning.Check),
+			Message:  warning.Message,
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(true)
+	_ = json.NewEncoder(w).Encode(resp.Body)
 }
 
-func (s *server) listPipelineJobs() []pipelineJobResult {
+func (s *server) listPipelineJobs(opts prunner.ListJobsOpts) ([]pipelineJobResult, int) {
 	res := []pipelineJobResult{}
-	s.pRunner.IterateJobs(func(j *prunner.PipelineJob) {
+	total := s.pRunner.ListJobs(opts, func(j *prunner.PipelineJob) {
 		res = append(res, jobToResult(j))
 	})
-	sort.Slice(res, func(i, j int) bool {
-		return !res[i].Created.Before(res[j].Created)
-	})
-	return res
+	return res, total
 }
 
 func (s *server) listPipelines() []pipelineResult {
@@ -602,19 +2934,56 @@ func (s *server) listPipelines() []pipelineResult {
 	for i, pipelineInfo := range pipelineInfos {
 		res[i] = pipelineResult{
 			Pipeline:    pipelineInfo.Pipeline,
+			Description: pipelineInfo.Description,
+			Tags:        pipelineInfo.Tags,
 			Schedulable: pipelineInfo.Schedulable,
 			Running:     pipelineInfo.Running,
+			Drained:     pipelineInfo.Drained,
+
+			DefinitionHash: pipelineInfo.DefinitionHash,
+
+			SourcePath:          pipelineInfo.SourcePath,
+			ShadowedSourcePaths: pipelineInfo.ShadowedSourcePaths,
+
+			DurationSamples: pipelineInfo.Durations.Samples,
+		}
+		if pipelineInfo.Durations.Samples > 0 {
+			median := pipelineInfo.Durations.Median.Seconds()
+			p95 := pipelineInfo.Durations.P95.Seconds()
+			res[i].DurationMedian = &median
+			res[i].DurationP95 = &p95
 		}
 	}
 
 	return res
 }
 
-func (s *server) sendError(w http.ResponseWriter, code int, msg string) {
-	w.Header().Set("Content-Type", "application/json")
+func hasAllTags(tags []string, required []string) bool {
+	for _, requiredTag := range required {
+		found := false
+		for _, tag := range tags {
+			if tag == requiredTag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sendError sends an error as problem (see RFC 7807)
+func (s *server) sendError(w http.ResponseWriter, code int, problemType ProblemType, msg string) {
+	w.Header().Set("Content-Type", "application/problem+json")
 	w.WriteHeader(code)
 
 	var resp genericErrorResponse
+	resp.Body.Type = string(problemType)
+	resp.Body.Title = problemTitles[problemType]
+	resp.Body.Status = code
+	resp.Body.Detail = msg
 	resp.Body.Error = msg
 
 	_ = json.NewEncoder(w).Encode(resp.Body)
@@ -624,7 +2993,19 @@ func (s *server) sendError(w http.ResponseWriter, code int, msg string) {
 type genericErrorResponse struct {
 	// in: body
 	Body struct {
+		// Stable identifier of the kind of error
+		// example: urn:prunner:problem:pipeline-not-found
+		Type string `json:"type"`
+		// Short summary of the kind of error
+		// example: Pipeline not found
+		Title string `json:"title"`
+		// HTTP status code
+		// example: 404
+		Status int `json:"status"`
 		// Error message
+		// example: Pipeline not found
+		Detail string `json:"detail"`
+		// Error message (same as detail, for compatibility)
 		Error string `json:"error"`
 	}
 }
@@ -1,13 +1,22 @@
 package server
 
 import (
+	"compress/flate"
+	"compress/gzip"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
@@ -17,9 +26,12 @@ import (
 	"github.com/taskctl/taskctl/pkg/task"
 
 	"github.com/Flowpack/prunner"
+	"github.com/Flowpack/prunner/archive"
 	"github.com/Flowpack/prunner/definition"
+	"github.com/Flowpack/prunner/exechook"
 	"github.com/Flowpack/prunner/taskctl"
 	"github.com/Flowpack/prunner/test"
+	"github.com/Flowpack/prunner/websocket"
 )
 
 var defs = &definition.PipelinesDef{
@@ -60,9 +72,7 @@ func TestServer_Pipelines(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
-	}, nil, nil)
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
 	require.NoError(t, err)
 
 	outputStore := test.NewMockOutputStore()
@@ -82,22 +92,145 @@ func TestServer_Pipelines(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, rec.Code)
 
-	assert.JSONEq(t, `{
+	assert.JSONEq(t, fmt.Sprintf(`{
 		"pipelines": [{
 			"pipeline": "release_it",
 			"running": false,
-			"schedulable": true
+			"schedulable": true,
+			"drained": false,
+			"definitionHash": %q
 		}]
-	}`, rec.Body.String())
+	}`, defs.Pipelines["release_it"].Hash()), rec.Body.String())
+}
+
+func TestServer_PipelinesWithTags(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	taggedDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Description: "Builds and deploys a release",
+				Tags:        []string{"deployment", "production"},
+				Concurrency: 1,
+				Tasks:       defs.Pipelines["release_it"].Tasks,
+
+				SourcePath:          "pipelines/release.yml",
+				ShadowedSourcePaths: []string{"pipelines/defaults.yml"},
+			},
+			"staging_it": {
+				Tags:        []string{"deployment"},
+				Concurrency: 1,
+				Tasks:       defs.Pipelines["release_it"].Tasks,
+			},
+			"cleanup_it": {
+				Concurrency: 1,
+				Tasks:       defs.Pipelines["release_it"].Tasks,
+			},
+		},
+	}
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, taggedDefs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	listPipelines := func(target string) []string {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var result struct {
+			Pipelines []struct {
+				Pipeline            string   `json:"pipeline"`
+				Description         string   `json:"description"`
+				Tags                []string `json:"tags"`
+				SourcePath          string   `json:"sourcePath"`
+				ShadowedSourcePaths []string `json:"shadowedSourcePaths"`
+			} `json:"pipelines"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+
+		var pipelines []string
+		for _, p := range result.Pipelines {
+			if p.Pipeline == "release_it" {
+				assert.Equal(t, "Builds and deploys a release", p.Description)
+				assert.Equal(t, []string{"deployment", "production"}, p.Tags)
+				assert.Equal(t, "pipelines/release.yml", p.SourcePath)
+				assert.Equal(t, []string{"pipelines/defaults.yml"}, p.ShadowedSourcePaths)
+			}
+			pipelines = append(pipelines, p.Pipeline)
+		}
+		return pipelines
+	}
+
+	assert.Equal(t, []string{"cleanup_it", "release_it", "staging_it"}, listPipelines("/pipelines"))
+	assert.Equal(t, []string{"release_it", "staging_it"}, listPipelines("/pipelines?tag=deployment"))
+	assert.Equal(t, []string{"release_it"}, listPipelines("/pipelines?tag=deployment&tag=production"))
+	assert.Empty(t, listPipelines("/pipelines?tag=nightly"))
+}
+
+func TestServer_VersionedRoutes(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	request := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("/v1/pipelines/jobs?pipeline=release_it")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+	versionedBody := rec.Body.String()
+
+	// Unversioned paths are deprecated aliases of the first version
+	rec = request("/pipelines/jobs?pipeline=release_it")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v1/pipelines/jobs?pipeline=release_it>; rel="successor-version"`, rec.Header().Get("Link"))
+	assert.Equal(t, versionedBody, rec.Body.String())
+
+	// Metrics are not versioned
+	rec = request("/metrics")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+
+	rec = request("/v1/metrics")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
 }
 
 func TestServer_PipelinesCanNotBeAccessedWithWrongJwtToken(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
-	}, nil, nil)
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
 	require.NoError(t, err)
 
 	outputStore := test.NewMockOutputStore()
@@ -119,13 +252,108 @@ func TestServer_PipelinesCanNotBeAccessedWithWrongJwtToken(t *testing.T) {
 	require.Equal(t, http.StatusUnauthorized, rec.Code)
 }
 
+func TestServer_ProblemResponses(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		token          string
+		expectedStatus int
+		expectedType   ProblemType
+	}{
+		{
+			name:           "missing token",
+			method:         http.MethodGet,
+			target:         "/v1/pipelines",
+			expectedStatus: http.StatusUnauthorized,
+			expectedType:   ProblemUnauthorized,
+		},
+		{
+			name:           "unknown pipeline",
+			method:         http.MethodPost,
+			target:         "/v1/pipelines/schedule",
+			body:           `{"pipeline": "unknown"}`,
+			token:          tokenString,
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   ProblemPipelineNotFound,
+		},
+		{
+			name:           "unknown job",
+			method:         http.MethodGet,
+			target:         "/v1/job/detail?id=52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8",
+			token:          tokenString,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   ProblemJobNotFound,
+		},
+		{
+			name:           "invalid parameter",
+			method:         http.MethodGet,
+			target:         "/v1/pipelines/jobs?limit=-1",
+			token:          tokenString,
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   ProblemInvalidRequest,
+		},
+		{
+			name:           "unknown route",
+			method:         http.MethodGet,
+			target:         "/v1/unknown",
+			token:          tokenString,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   ProblemRouteNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tt.token))
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+			var problem struct {
+				Type   string
+				Title  string
+				Status int
+				Detail string
+				Error  string
+			}
+			err := json.NewDecoder(rec.Body).Decode(&problem)
+			require.NoError(t, err)
+			assert.Equal(t, string(tt.expectedType), problem.Type)
+			assert.NotEmpty(t, problem.Title)
+			assert.Equal(t, tt.expectedStatus, problem.Status)
+			assert.NotEmpty(t, problem.Detail)
+			assert.Equal(t, problem.Detail, problem.Error)
+		})
+	}
+}
+
 func TestServer_PipelinesSchedule(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
-	}, nil, nil)
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
 	require.NoError(t, err)
 
 	outputStore := test.NewMockOutputStore()
@@ -167,12 +395,68 @@ func TestServer_PipelinesSchedule(t *testing.T) {
 	}, 50*time.Millisecond, "job exists and is completed")
 }
 
-func TestServer_JobCreationTimeIsRoundedForPhpCompatibility(t *testing.T) {
+func TestServer_PipelinesSchedule_RejectsInvalidBody(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithMaxBodySize(64))
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	schedule := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := schedule(`{"pipelne": "release_it"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:prunner:problem:invalid-body",
+		"title": "Invalid request body",
+		"status": 400,
+		"detail": "Error decoding JSON: unknown field \"pipelne\"",
+		"error": "Error decoding JSON: unknown field \"pipelne\""
+	}`, rec.Body.String())
+
+	rec = schedule(fmt.Sprintf(`{"pipeline": "release_it", "variables": {"notes": %q}}`, strings.Repeat("x", 64)))
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{
+		"type": "urn:prunner:problem:body-too-large",
+		"title": "Request body too large",
+		"status": 413,
+		"detail": "Body exceeds the maximum size of 64 bytes",
+		"error": "Body exceeds the maximum size of 64 bytes"
+	}`, rec.Body.String())
+
+	rec = schedule(`{"pipeline": "release_it"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func TestServer_PipelinesSchedule_WhenQueued(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
+	release := make(chan struct{})
+	defer close(release)
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(tsk *task.Task) error {
+			<-release
+			return nil
+		},
 	}, nil, nil)
 	require.NoError(t, err)
 
@@ -186,6 +470,90 @@ func TestServer_JobCreationTimeIsRoundedForPhpCompatibility(t *testing.T) {
 	jwtauth.SetIssuedNow(claims)
 	_, tokenString, _ := tokenAuth.Encode(claims)
 
+	schedule := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{
+			"pipeline": "release_it"
+		}`))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		return rec.Body.String()
+	}
+
+	// The first job is started, so there is no queue position
+	body := schedule()
+	assert.NotContains(t, body, "queue")
+
+	// Without previous jobs no start can be estimated
+	body = schedule()
+	var result struct{ JobID string }
+	err = json.Unmarshal([]byte(body), &result)
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{
+		"jobId": %q,
+		"queue": {"position": 1, "running": 1}
+	}`, result.JobID), body)
+}
+
+func TestServer_PipelinesSchedule_WithCorrelationID(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, test.NewMockOutputStore(), noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	schedule := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{"pipeline": "release_it"}`))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		req.Header.Set(header, value)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := schedule("X-Request-ID", "checkout-42")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var result struct{ JobID string }
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+
+	err = pRunner.ReadJob(uuid.Must(uuid.FromString(result.JobID)), func(j *prunner.PipelineJob) {
+		assert.Equal(t, "checkout-42", j.CorrelationID)
+	})
+	require.NoError(t, err)
+
+	rec = schedule("X-Correlation-ID", "not valid")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_JobCreationTimeIsRoundedForPhpCompatibility(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
 	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{
 		"pipeline": "release_it"
 	}`))
@@ -223,6 +591,116 @@ func TestServer_JobCreationTimeIsRoundedForPhpCompatibility(t *testing.T) {
 	require.Equal(t, jobCreated.In(time.UTC).Format(time.RFC3339), result2.Jobs[0].Created)
 }
 
+func TestServer_PipelinesJobs_ETag(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	listJobs := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/pipelines/jobs", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := listJobs("")
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rec = listJobs(etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+	rec = listJobs(`"other", W/` + etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// Scheduling a job changes the ETag
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	err = pRunner.WaitForJob(ctx, job.ID)
+	require.NoError(t, err)
+
+	rec = listJobs(etag)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), job.ID.String())
+}
+
+func TestServer_Compression(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	listPipelines := func(srv http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/pipelines", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	rec := listPipelines(srv, "")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	uncompressedBody := rec.Body.String()
+
+	rec = listPipelines(srv, "gzip, deflate")
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+	r, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, uncompressedBody, string(body))
+
+	rec = listPipelines(srv, "deflate")
+	require.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+	body, err = io.ReadAll(flate.NewReader(rec.Body))
+	require.NoError(t, err)
+	assert.Equal(t, uncompressedBody, string(body))
+
+	// Compression can be disabled
+	srv = NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithCompression(0))
+
+	rec = listPipelines(srv, "gzip")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, uncompressedBody, rec.Body.String())
+}
+
 func TestServer_JobCancel(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
@@ -231,16 +709,14 @@ func TestServer_JobCancel(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{
-			OnRun: func(t *task.Task) error {
-				wg.Wait()
-				return nil
-			},
-			OnCancel: func() {
-				wg.Done()
-			},
-		}
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			wg.Wait()
+			return nil
+		},
+		OnCancel: func() {
+			wg.Done()
+		},
 	}, nil, nil)
 	require.NoError(t, err)
 
@@ -297,12 +773,19 @@ func TestServer_JobCancel(t *testing.T) {
 	}, 50*time.Millisecond, "job exists and was completed")
 }
 
-func TestServer_NoAccessToProfilingRoutesIfDisabled(t *testing.T) {
+func TestServer_PipelinesCancel(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
+	// Block the tasks of the first job, so the second job stays queued
+	wait := make(chan struct{})
+	defer close(wait)
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			<-wait
+			return nil
+		},
 	}, nil, nil)
 	require.NoError(t, err)
 
@@ -312,64 +795,1555 @@ func TestServer_NoAccessToProfilingRoutesIfDisabled(t *testing.T) {
 	noopMiddleware := func(next http.Handler) http.Handler { return next }
 	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
 
-	req := httptest.NewRequest(http.MethodGet, "/debug/pprof", nil)
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	_, err = pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	queuedJob, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
 
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/release_it/cancel", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
 	rec := httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
 
-	require.Equal(t, http.StatusNotFound, rec.Code)
-}
-
-func TestServer_AccessToProfilingWorksIfEnabledWithoutToken(t *testing.T) {
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
+	require.Equal(t, http.StatusOK, rec.Code)
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
-	}, nil, nil)
+	var result struct {
+		CanceledJobIDs []string `json:"canceledJobIds"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&result)
 	require.NoError(t, err)
+	assert.Equal(t, []string{queuedJob.ID.String()}, result.CanceledJobIDs)
 
-	outputStore := test.NewMockOutputStore()
+	// Unknown pipeline
 
-	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
-	noopMiddleware := func(next http.Handler) http.Handler { return next }
-	// !!! the last parameter is "enableProfiling: true"
-	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, true)
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/unknown/cancel", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
 
-	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
 
-	rec := httptest.NewRecorder()
+	// Invalid running parameter
+
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/release_it/cancel?running=maybe", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
 
-	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 }
 
-func TestServer_JobLogs(t *testing.T) {
+func TestServer_PipelinesJobRequeue(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
+	// Block the tasks of the first job, so the other jobs stay queued
+	wait := make(chan struct{})
+	defer close(wait)
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			<-wait
+			return nil
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := map[string]interface{}{"sub": "j.doe"}
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	runningJob, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	canceledJob, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{Variables: map[string]interface{}{"version": "1.2.3"}})
+	require.NoError(t, err)
+	require.NoError(t, pRunner.CancelJob(canceledJob.ID))
+
+	requeue := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/pipelines/jobs/%s/requeue", id), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := requeue(canceledJob.ID.String())
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var result struct {
+		JobID string `json:"jobId"`
+		Queue *struct {
+			Position int `json:"position"`
+		} `json:"queue"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+	require.NotNil(t, result.Queue, "requeued job is on the wait list")
+	assert.Equal(t, 1, result.Queue.Position)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/job/detail?id=%s", result.JobID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), fmt.Sprintf(`"requeuedFrom":"%s"`, canceledJob.ID))
+	assert.Contains(t, rec.Body.String(), `"version":"1.2.3"`)
+	assert.Contains(t, rec.Body.String(), `"user":"j.doe"`)
+
+	rec = requeue(runningJob.ID.String())
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = requeue(uuid.Must(uuid.NewV4()).String())
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = requeue("invalid")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_PipelinesJobClone(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := map[string]interface{}{"sub": "j.doe"}
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{Variables: map[string]interface{}{"version": "1.2.3"}})
+	require.NoError(t, err)
+	highPriorityJob, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{Priority: prunner.JobPriorityHigh})
+	require.NoError(t, err)
+
+	clone := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/pipelines/jobs/%s/clone", id), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		req.Header.Set("X-Request-ID", "clone-request")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := clone(job.ID.String())
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var result struct {
+		JobID string `json:"jobId"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/job/detail?id=%s", result.JobID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), fmt.Sprintf(`"clonedFrom":"%s"`, job.ID))
+	assert.Contains(t, rec.Body.String(), `"version":"1.2.3"`)
+	assert.Contains(t, rec.Body.String(), `"user":"j.doe"`)
+	assert.Contains(t, rec.Body.String(), `"correlationId":"clone-request"`)
+
+	rec = clone(highPriorityJob.ID.String())
+	assert.Equal(t, http.StatusForbidden, rec.Code, "scope is required to clone a job with high priority")
+
+	rec = clone(uuid.Must(uuid.NewV4()).String())
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = clone("invalid")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_PipelinesJobAnnotate(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := map[string]interface{}{"sub": "j.doe"}
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	annotate := func(id string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/pipelines/jobs/%s/annotations", id), strings.NewReader(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := annotate(job.ID.String(), `{"text": "Failed due to registry outage, safe to ignore"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"user":"j.doe"`)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/job/detail?id=%s", job.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"annotations":[{"text":"Failed due to registry outage, safe to ignore","user":"j.doe"`)
+
+	rec = annotate(job.ID.String(), `{"text": ""}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = annotate(job.ID.String(), `{"txt": "Typo"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = annotate(uuid.Must(uuid.NewV4()).String(), `{"text": "Note"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = annotate("invalid", `{"text": "Note"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_PipelinesDrain(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	drained := func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/pipelines/", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var result struct {
+			Pipelines []struct {
+				Pipeline string `json:"pipeline"`
+				Drained  bool   `json:"drained"`
+			} `json:"pipelines"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+		require.Len(t, result.Pipelines, 1)
+		return result.Pipelines[0].Drained
+	}
+
+	assert.False(t, drained())
+
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/release_it/drain", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"pipeline":"release_it","drained":true}`, rec.Body.String())
+	assert.True(t, drained())
+
+	req = httptest.NewRequest(http.MethodDelete, "/pipelines/release_it/drain", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, drained())
+
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/unknown/drain", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_PipelinesJobDelete(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	outputStore := test.NewMockOutputStore()
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeAdmin
+	_, adminTokenString, _ := tokenAuth.Encode(claims)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(job.ID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 10*time.Millisecond, "job completed")
+
+	deleteJob := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/pipelines/jobs/%s", job.ID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := deleteJob(tokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "admin scope is required")
+
+	rec = deleteJob(adminTokenString)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	err = pRunner.ReadJob(job.ID, func(j *prunner.PipelineJob) {})
+	assert.ErrorIs(t, err, prunner.ErrJobNotFound)
+
+	rec = deleteJob(adminTokenString)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_PipelinesJobsArchived(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	outputStore := test.NewMockOutputStore()
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	request := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/pipelines/jobs/archived")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "urn:prunner:problem:archive-disabled")
+
+	storage, err := archive.NewDirStorage(t.TempDir())
+	require.NoError(t, err)
+	pRunner.UseArchive(ctx, archive.New(storage), time.Nanosecond, time.Hour)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(job.ID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 10*time.Millisecond, "job completed")
+
+	archived, err := pRunner.ArchiveJobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, archived)
+
+	rec = request(http.MethodGet, "/pipelines/jobs/archived")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), fmt.Sprintf(`"id":"%s","pipeline":"release_it"`, job.ID))
+
+	rec = request(http.MethodPost, "/pipelines/jobs/invalid/rehydrate")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = request(http.MethodPost, fmt.Sprintf("/pipelines/jobs/%s/rehydrate", uuid.Must(uuid.NewV4())))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = request(http.MethodPost, fmt.Sprintf("/pipelines/jobs/%s/rehydrate", job.ID))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = request(http.MethodGet, fmt.Sprintf("/job/detail?id=%s", job.ID))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"rehydrated":`)
+
+	rec = request(http.MethodGet, "/pipelines/jobs/archived")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"jobs": []}`, rec.Body.String())
+}
+
+func TestServer_JobStdin(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeAdmin
+	_, adminTokenString, _ := tokenAuth.Encode(claims)
+
+	sendInput := func(query string, body io.Reader, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/job/stdin?"+query, body)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	jobQuery := fmt.Sprintf("id=%s&task=deploy", uuid.Must(uuid.NewV4()))
+
+	// Writing to stdin is as powerful as attaching and requires the admin scope
+	rec := sendInput(jobQuery, strings.NewReader("yes\n"), tokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = sendInput("id=invalid&task=deploy", strings.NewReader("yes\n"), adminTokenString)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = sendInput(jobQuery, strings.NewReader(strings.Repeat("y", maxStdinSize+1)), adminTokenString)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Errors reading the body other than the size limit are no client errors about the size
+	rec = sendInput(jobQuery, iotest.ErrReader(errors.New("connection reset")), adminTokenString)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "connection reset")
+
+	// The mock runner has no interactive tasks
+	rec = sendInput(jobQuery+"&close=true", strings.NewReader("yes\n"), adminTokenString)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Task is not running or not interactive")
+}
+
+func TestServer_JobLiveOutput(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	liveDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"confirm_it": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"confirm": {
+						Script:      []string{"echo first", "echo second", "echo warning >&2", "read answer"},
+						Interactive: true,
+					},
+				},
+			},
+		},
+	}
+
+	outputStore := test.NewMockOutputStore()
+	pRunner, err := prunner.NewPipelineRunner(ctx, liveDefs, prunner.NewTaskRunner(outputStore, prunner.WithLiveOutputLines(1)), nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	getLiveOutput := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/job/live-output?"+query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := getLiveOutput("id=invalid&task=confirm")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = getLiveOutput(fmt.Sprintf("id=%s&task=confirm", uuid.Must(uuid.NewV4())))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	job, err := pRunner.ScheduleAsync("confirm_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	jobQuery := fmt.Sprintf("id=%s&task=confirm", job.ID)
+
+	// The task waits for input, so it is still running and only the last line of each output is kept
+	test.WaitForCondition(t, func() bool {
+		rec = getLiveOutput(jobQuery)
+		return rec.Code == http.StatusOK && strings.Contains(rec.Body.String(), "warning")
+	}, 10*time.Millisecond, "live output of running task")
+	assert.JSONEq(t, `{"stdout": ["second"], "stderr": ["warning"]}`, rec.Body.String())
+
+	require.NoError(t, pRunner.CloseTaskInput(job.ID, "confirm"))
+	require.NoError(t, pRunner.WaitForJob(ctx, job.ID))
+
+	rec = getLiveOutput(jobQuery)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_JobAttach(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	attachDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"confirm_it": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"confirm": {
+						Script:      []string{"read answer", "echo got $answer"},
+						Interactive: true,
+					},
+				},
+			},
+		},
+	}
+
+	outputStore := test.NewMockOutputStore()
+	pRunner, err := prunner.NewPipelineRunner(ctx, attachDefs, prunner.NewTaskRunner(outputStore), nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	httpSrv := httptest.NewServer(NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false))
+	defer httpSrv.Close()
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeAdmin
+	_, adminTokenString, _ := tokenAuth.Encode(claims)
+
+	job, err := pRunner.ScheduleAsync("confirm_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	attachURL := fmt.Sprintf("%s/job/attach?id=%s&task=confirm", httpSrv.URL, job.ID)
+
+	_, err = websocket.Dial(attachURL, http.Header{"Authorization": []string{"Bearer " + tokenString}})
+	var handshakeErr *websocket.HandshakeError
+	require.ErrorAs(t, err, &handshakeErr)
+	assert.Equal(t, http.StatusForbidden, handshakeErr.StatusCode)
+
+	// The token can be passed as query parameter, the task waits for input so it is still running
+	var conn *websocket.Conn
+	test.WaitForCondition(t, func() bool {
+		conn, err = websocket.Dial(attachURL+"&jwt="+adminTokenString, nil)
+		return err == nil
+	}, 10*time.Millisecond, "attached to running task")
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"resize","cols":120,"rows":40}`)))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"stdin","data":"yes\n"}`)))
+
+	// Output is sent in chunks as written by the task
+	var stdout string
+	for {
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var msg attachMessage
+		require.NoError(t, json.Unmarshal(data, &msg))
+		if msg.Type == "exit" {
+			break
+		}
+		require.Equal(t, "stdout", msg.Type, "unexpected message %s", data)
+		stdout += msg.Data
+	}
+	assert.Equal(t, "got yes\n", stdout)
+}
+
+func TestServer_NoAccessToProfilingRoutesIfDisabled(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof", nil)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_AccessToProfilingWorksIfEnabledWithoutToken(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	// !!! the last parameter is "enableProfiling: true"
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, true)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestServer_JobLogs(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	outputStore := test.NewMockOutputStore()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			jobID := t.Variables.Get(taskctl.JobIDVariableName).(string)
+			// We make an explicit call to the output store here, since we do not use a real executor for these tests
+			w, err := outputStore.Writer(jobID, t.Name, "stdout")
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(w, "out from %s", t.Name)
+			w, err = outputStore.Writer(jobID, t.Name, "stderr")
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(w, "err from %s", t.Name)
+			return nil
+		},
+	}, nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{
+		"pipeline": "release_it"
+	}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var result struct{ JobID string }
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, result.JobID)
+	jobID := uuid.Must(uuid.FromString(result.JobID))
+
+	err = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {})
+	require.NoError(t, err)
+
+	// Wait until job is completed (busy waiting style)
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 50*time.Millisecond, "job exists and is completed")
+
+	// Get logs
+	req = httptest.NewRequest(http.MethodGet, "/job/logs?id="+jobID.String()+"&task=lint", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var logs struct {
+		Stdout string `json:"stdout"`
+		Stderr string `json:"stderr"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&logs)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, logs.Stdout)
+	assert.NotEmpty(t, logs.Stderr)
+}
+
+func TestServer_JobDetail(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	outputStore := test.NewMockOutputStore()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := map[string]interface{}{
+		"sub": "jane.doe",
+	}
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{
+		"pipeline": "release_it"
+	}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var result struct{ JobID string }
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, result.JobID)
+	jobID := uuid.Must(uuid.FromString(result.JobID))
+
+	err = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {})
+	require.NoError(t, err)
+
+	// Wait until job is completed (busy waiting style)
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 50*time.Millisecond, "job exists and is completed")
+
+	// Get details
+	req = httptest.NewRequest(http.MethodGet, "/job/detail?id="+jobID.String(), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var details struct {
+		ID        string `json:"id"`
+		Pipeline  string `json:"pipeline"`
+		Completed bool   `json:"completed"`
+		User      string `json:"user"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&details)
+	require.NoError(t, err)
+
+	assert.Equal(t, jobID.String(), details.ID)
+	assert.Equal(t, "release_it", details.Pipeline)
+	assert.True(t, details.Completed)
+	assert.Equal(t, "jane.doe", details.User)
+}
+
+func TestServer_JobDetail_WithAllowedFailure(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	var defs = &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"check_it": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"lint": {
+						Script:       []string{"exit 1"},
+						AllowFailure: true,
+					},
+					"test": {
+						Script: []string{"go test"},
+					},
+				},
+				SourcePath: "fixtures",
+			},
+		},
+	}
+
+	outputStore := test.NewMockOutputStore()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			if t.Name == "lint" {
+				t.Errored = true
+				t.Error = errors.New("exit 1")
+				return t.Error
+			}
+			return nil
+		},
+	}, nil, outputStore)
+	require.NoError(t, err)
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := map[string]interface{}{
+		"sub": "jane.doe",
+	}
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	job, err := pRunner.ScheduleAsync("check_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	jobID := job.ID
+
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 50*time.Millisecond, "job exists and is completed")
+
+	req := httptest.NewRequest(http.MethodGet, "/job/detail?id="+jobID.String(), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var details struct {
+		Errored bool `json:"errored"`
+		Tasks   []struct {
+			Name           string `json:"name"`
+			Status         string `json:"status"`
+			Errored        bool   `json:"errored"`
+			AllowedFailure bool   `json:"allowedFailure"`
+		} `json:"tasks"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&details)
+	require.NoError(t, err)
+
+	assert.False(t, details.Errored, "job is not errored by a task with allow_failure")
+	require.Len(t, details.Tasks, 2)
+	for _, taskDetails := range details.Tasks {
+		switch taskDetails.Name {
+		case "lint":
+			assert.True(t, taskDetails.Errored)
+			assert.True(t, taskDetails.AllowedFailure)
+		case "test":
+			assert.Equal(t, "done", taskDetails.Status, "test is not canceled by the allowed failure")
+			assert.False(t, taskDetails.Errored)
+			assert.False(t, taskDetails.AllowedFailure)
+		}
+	}
+}
+
+func TestServer_AdminDiskUsage(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "data.json"), make([]byte, 42), 0644))
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithDataDir(dataDir))
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/disk-usage", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusForbidden, rec.Code, "admin scope is required")
+
+	claims["scope"] = ScopeAdmin
+	_, tokenString, _ = tokenAuth.Encode(claims)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/disk-usage", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Path      string `json:"path"`
+		DataBytes int64  `json:"dataBytes"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, dataDir, resp.Path)
+	assert.Equal(t, int64(42), resp.DataBytes)
+}
+
+func TestServer_AdminDataUsage(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "data.json"), make([]byte, 42), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, "logs", "some-job"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "logs", "some-job", "echo-stdout.log"), make([]byte, 10), 0644))
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithDataDir(dataDir))
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/data-usage", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusForbidden, rec.Code, "admin scope is required")
+
+	claims["scope"] = ScopeAdmin
+	_, tokenString, _ = tokenAuth.Encode(claims)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/data-usage", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Path       string        `json:"path"`
+		StoreBytes int64         `json:"storeBytes"`
+		LogBytes   int64         `json:"logBytes"`
+		Jobs       int           `json:"jobs"`
+		Pipelines  []interface{} `json:"pipelines"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, dataDir, resp.Path)
+	assert.Equal(t, int64(42), resp.StoreBytes)
+	assert.Equal(t, int64(10), resp.LogBytes)
+	assert.Equal(t, 0, resp.Jobs)
+	assert.Empty(t, resp.Pipelines)
+}
+
+func TestServer_AdminBackup_RequiresAdminScope(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithDataDir(t.TempDir()))
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeAdmin
+	_, adminTokenString, _ := tokenAuth.Encode(claims)
+
+	backup := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/admin/backup?withoutLogs=true", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := backup(tokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "admin scope is required")
+
+	rec = backup(adminTokenString)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/gzip", rec.Header().Get("Content-Type"))
+}
+
+func TestServer_AdminLint(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	lintDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Description: "Builds and deploys a release",
+				Concurrency: 1,
+				Env:         map[string]string{"PRUNNER_PIPELINE": "other"},
+				Tasks: map[string]definition.TaskDef{
+					"build":  {Script: []string{"go build"}},
+					"notify": {Script: []string{"./notify.sh"}, When: "false"},
+				},
+			},
+		},
+	}
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, lintDefs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	// Without a data directory (memory store)
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	claims["scope"] = ScopeAdmin
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/lint", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"warnings": [
+			{"pipeline": "release_it", "check": "shadowed-env", "message": "env PRUNNER_PIPELINE is overridden by prunner"},
+			{"pipeline": "release_it", "task": "notify", "check": "unreachable-task", "message": "task is always skipped, when condition \"false\" is never true"}
+		]
+	}`, rec.Body.String())
+
+	// The admin endpoints are not served if they are moved to a separate listener
+	srv = NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithoutAdminEndpoints())
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/lint", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_AdminDeadLetters(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("false is not an executable on Windows")
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	dataDir := t.TempDir()
+	deadLetters := exechook.NewDeadLetterStore(filepath.Join(dataDir, "dead-letters.json"))
+	require.NoError(t, deadLetters.Add(exechook.DeadLetter{
+		ID:        "failed-notification",
+		JobID:     "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8",
+		Pipeline:  "release_it",
+		Command:   "false",
+		Payload:   []byte(`{"id":"52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8"}`),
+		Attempts:  4,
+		LastError: "hook false failed: exit status 1",
+		Failed:    time.Date(2021, 11, 22, 10, 0, 0, 0, time.UTC),
+	}))
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithDataDir(dataDir), WithDeadLetters(deadLetters, time.Second))
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeAdmin
+	_, adminTokenString, _ := tokenAuth.Encode(claims)
+
+	requestWithToken := func(token, method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	request := func(method, target string) *httptest.ResponseRecorder {
+		return requestWithToken(adminTokenString, method, target)
+	}
+
+	// Dead letters contain hook payloads and are the only record of failed notifications
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := requestWithToken(tokenString, method, "/admin/dead-letters?id=failed-notification")
+		assert.Equal(t, http.StatusForbidden, rec.Code, "admin scope is required for %s", method)
+	}
+	rec := requestWithToken(tokenString, http.MethodPost, "/admin/dead-letters/redeliver?id=failed-notification")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "admin scope is required for redelivery")
+	letters, err := deadLetters.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1, "dead letter is not deleted without admin scope")
+	assert.Equal(t, 4, letters[0].Attempts, "dead letter is not redelivered without admin scope")
+
+	rec = request(http.MethodGet, "/admin/dead-letters")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"deadLetters": [{
+		"id": "failed-notification",
+		"jobId": "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8",
+		"pipeline": "release_it",
+		"command": "false",
+		"attempts": 4,
+		"lastError": "hook false failed: exit status 1",
+		"failed": "2021-11-22T10:00:00Z",
+		"payload": {"id": "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8"}
+	}]}`, rec.Body.String())
+
+	rec = request(http.MethodPost, "/admin/dead-letters/redeliver?id=failed-notification")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	letters, err = deadLetters.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, 5, letters[0].Attempts)
+
+	rec = request(http.MethodDelete, "/admin/dead-letters?id=failed-notification")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	letters, err = deadLetters.List()
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+
+	rec = request(http.MethodPost, "/admin/dead-letters/redeliver?id=failed-notification")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_Metrics(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	alertDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Concurrency: 1,
+				Alerts: definition.AlertsDef{
+					NoSuccessFor: time.Nanosecond,
+				},
+				Tasks: map[string]definition.TaskDef{
+					"test": {
+						Script: []string{"go test"},
+					},
+				},
+				SourcePath: "fixtures",
+			},
+		},
+	}
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, alertDefs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	requestMetrics := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	assert.Contains(t, requestMetrics(), `prunner_pipeline_alert{pipeline="release_it",alert="no_success"} 0`)
+
+	pRunner.CheckAlerts()
+	time.Sleep(time.Millisecond)
+	pRunner.CheckAlerts()
+	assert.Contains(t, requestMetrics(), `prunner_pipeline_alert{pipeline="release_it",alert="no_success"} 1`)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	test.WaitForCondition(t, func() bool {
+		var completed bool
+		_ = pRunner.ReadJob(job.ID, func(j *prunner.PipelineJob) {
+			completed = j.Completed
+		})
+		return completed
+	}, 50*time.Millisecond, "job is completed")
+
+	metrics := requestMetrics()
+	assert.Contains(t, metrics, `prunner_task_runs_total{pipeline="release_it",task="test"} 1`)
+	assert.Contains(t, metrics, `prunner_task_wall_seconds_total{pipeline="release_it",task="test"} `)
+	assert.Contains(t, metrics, `prunner_task_cpu_seconds_total{pipeline="release_it",task="test",mode="user"} 0`)
+	assert.Contains(t, metrics, `prunner_task_max_rss_bytes{pipeline="release_it",task="test"} 0`)
+}
+
+func TestServer_PipelinesRender(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	renderDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"tag": {
+						Script: []string{
+							`git tag {{ .tag_name | trimPrefix "v" }}`,
+							`echo "{{ pipeline }}"`,
+						},
+					},
+					"broken": {
+						Script: []string{"echo {{ .missing }}"},
+					},
+				},
+				SourcePath: "fixtures",
+			},
+		},
+	}
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, renderDefs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/render", strings.NewReader(`{
+		"pipeline": "release_it",
+		"variables": {"tag_name": "v1.2.3"}
+	}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result struct {
+		Tasks []struct {
+			Name   string
+			Script []string
+			Error  *string
+		}
+	}
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+
+	require.Len(t, result.Tasks, 2)
+	assert.Equal(t, "broken", result.Tasks[0].Name)
+	assert.NotNil(t, result.Tasks[0].Error)
+	assert.Equal(t, "tag", result.Tasks[1].Name)
+	assert.Equal(t, []string{`git tag 1.2.3`, `echo "release_it"`}, result.Tasks[1].Script)
+	assert.Nil(t, result.Tasks[1].Error)
+
+	var jobCount int
+	pRunner.IterateJobs(func(j *prunner.PipelineJob) {
+		jobCount++
+	})
+	assert.Equal(t, 0, jobCount, "no job is created")
+}
+
+func TestServer_JobDefinitionDiff(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	diffDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"tag": {
+						Script: []string{"git tag v1"},
+					},
+				},
+				SourcePath: "fixtures",
+			},
+		},
+	}
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, diffDefs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	changedDefs := &definition.PipelinesDef{
+		Pipelines: map[string]definition.PipelineDef{
+			"release_it": {
+				Concurrency: 1,
+				Tasks: map[string]definition.TaskDef{
+					"tag": {
+						Script: []string{"git tag v2"},
+					},
+					"push": {
+						Script:    []string{"git push --tags"},
+						DependsOn: []string{"tag"},
+					},
+				},
+				SourcePath: "fixtures",
+			},
+		},
+	}
+	pRunner.ReplaceDefinitions(changedDefs)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/job/definition-diff?id=%s", job.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.JSONEq(t, fmt.Sprintf(`{
+		"jobDefinitionHash": %q,
+		"currentDefinitionHash": %q,
+		"changes": [
+			{"task": "push", "kind": "added"},
+			{"task": "tag", "field": "script", "kind": "changed", "old": "git tag v1", "new": "git tag v2"}
+		]
+	}`, diffDefs.Pipelines["release_it"].Hash(), changedDefs.Pipelines["release_it"].Hash()), rec.Body.String())
+}
+
+func TestServer_PipelinesQueue(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(tsk *task.Task) error {
+			<-release
+			return nil
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	_, err = pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	queuedJob, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{User: "j.doe"})
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, "/pipelines/release_it/queue", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.JSONEq(t, fmt.Sprintf(`{
+		"pipeline": "release_it",
+		"queueStrategy": "append",
+		"concurrency": 1,
+		"running": 1,
+		"reason": "concurrency of 1 reached",
+		"jobs": [
+			{"jobId": %q, "position": 1, "queued": %q, "priority": "normal", "user": "j.doe", "startAt": null}
+		]
+	}`, queuedJob.ID, queuedJob.Created.UTC().Format(time.RFC3339)), rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/pipelines/unknown/queue", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_PipelinesBadge(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
 	outputStore := test.NewMockOutputStore()
 
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{
-			OnRun: func(t *task.Task) error {
-				// We make an explicit call to the output store here, since we do not use a real executor for these tests
-				w, err := outputStore.Writer(j.ID.String(), t.Name, "stdout")
-				if err != nil {
-					return err
-				}
-				_, _ = fmt.Fprintf(w, "out from %s", t.Name)
-				w, err = outputStore.Writer(j.ID.String(), t.Name, "stderr")
-				if err != nil {
-					return err
-				}
-				_, _ = fmt.Fprintf(w, "err from %s", t.Name)
-				return nil
-			},
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeBadge
+	_, badgeTokenString, _ := tokenAuth.Encode(claims)
+	claims["scope"] = ScopeBadge + " " + ScopeAdmin
+	_, badgeAndAdminTokenString, _ := tokenAuth.Encode(claims)
+
+	requestBadge := func(pipeline string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/pipelines/%s/badge.svg?jwt=%s", pipeline, token), nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	request := func(method, target string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := requestBadge("release_it", badgeTokenString)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "release_it: unknown")
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+	err = pRunner.WaitForJob(ctx, job.ID)
+	require.NoError(t, err)
+
+	rec = requestBadge("release_it", badgeTokenString)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "release_it: passing")
+
+	rec = requestBadge("release_it", "invalid")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = requestBadge("unknown", badgeTokenString)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Tokens in the query string must only have the badge scope
+	rec = requestBadge("release_it", tokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "token without badge scope")
+	rec = requestBadge("release_it", badgeAndAdminTokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "token with other scopes")
+
+	// Other tokens can be sent in the header
+	rec = request(http.MethodGet, "/pipelines/release_it/badge.svg", tokenString)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Badge tokens are not accepted by other endpoints
+	rec = request(http.MethodGet, "/pipelines/jobs", badgeTokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = request(http.MethodPost, "/pipelines/release_it/cancel", badgeTokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = request(http.MethodGet, "/v1/admin/lint", badgeAndAdminTokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = request(http.MethodGet, fmt.Sprintf("/job/attach?id=%s&task=build", job.ID), badgeAndAdminTokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = request(http.MethodGet, "/metrics", badgeTokenString)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestServer_PipelinesSchedule_DryRun(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule?dryRun=true", strings.NewReader(`{"pipeline": "release_it"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result struct {
+		Action string
+		Tasks  []struct {
+			Name string
 		}
-	}, nil, outputStore)
+	}
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	require.NoError(t, err)
+	assert.Equal(t, "start", result.Action)
+	assert.NotEmpty(t, result.Tasks)
+
+	var jobCount int
+	pRunner.IterateJobs(func(j *prunner.PipelineJob) {
+		jobCount++
+	})
+	assert.Equal(t, 0, jobCount, "no job is created")
+}
+
+func TestServer_PipelinesSchedule_Wait(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	release := make(chan struct{})
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			<-release
+			return nil
+		},
+	}, nil, nil)
 	require.NoError(t, err)
 
+	outputStore := test.NewMockOutputStore()
+
 	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
 	noopMiddleware := func(next http.Handler) http.Handler { return next }
 	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
@@ -378,119 +2352,360 @@ func TestServer_JobLogs(t *testing.T) {
 	jwtauth.SetIssuedNow(claims)
 	_, tokenString, _ := tokenAuth.Encode(claims)
 
-	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{
-		"pipeline": "release_it"
-	}`))
+	// The job is still running when the timeout elapsed
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule?wait=true&timeout=50ms", strings.NewReader(`{"pipeline": "release_it"}`))
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
 	rec := httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
 
 	require.Equal(t, http.StatusAccepted, rec.Code)
 
-	var result struct{ JobID string }
+	var result struct {
+		ID        string
+		Completed bool
+	}
 	err = json.NewDecoder(rec.Body).Decode(&result)
 	require.NoError(t, err)
+	assert.NotEmpty(t, result.ID)
+	assert.False(t, result.Completed)
 
-	assert.NotEmpty(t, result.JobID)
-	jobID := uuid.Must(uuid.FromString(result.JobID))
+	close(release)
 
-	err = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {})
+	// The next job completes before the timeout
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/schedule?wait=true&timeout=5s", strings.NewReader(`{"pipeline": "release_it"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	err = json.NewDecoder(rec.Body).Decode(&result)
 	require.NoError(t, err)
+	assert.True(t, result.Completed)
 
-	// Wait until job is completed (busy waiting style)
-	test.WaitForCondition(t, func() bool {
-		var completed bool
-		_ = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
-			completed = j.Completed
-		})
-		return completed
-	}, 50*time.Millisecond, "job exists and is completed")
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/schedule?wait=true&timeout=2h", strings.NewReader(`{"pipeline": "release_it"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
 
-	// Get logs
-	req = httptest.NewRequest(http.MethodGet, "/job/logs?id="+jobID.String()+"&task=lint", nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_PipelinesJobWait(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	release := make(chan struct{})
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{
+		OnRun: func(t *task.Task) error {
+			<-release
+			return nil
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	job, err := pRunner.ScheduleAsync("release_it", prunner.ScheduleOpts{})
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/pipelines/jobs/%s/wait?timeout=50ms", job.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/pipelines/jobs/%s/wait?timeout=5s", job.ID), nil)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
 	rec = httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
 
 	require.Equal(t, http.StatusOK, rec.Code)
-	var logs struct {
-		Stdout string `json:"stdout"`
-		Stderr string `json:"stderr"`
+
+	var result struct {
+		ID        string
+		Completed bool
 	}
-	err = json.NewDecoder(rec.Body).Decode(&logs)
+	err = json.NewDecoder(rec.Body).Decode(&result)
 	require.NoError(t, err)
+	assert.Equal(t, job.ID.String(), result.ID)
+	assert.True(t, result.Completed)
 
-	assert.NotEmpty(t, logs.Stdout)
-	assert.NotEmpty(t, logs.Stderr)
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/pipelines/jobs/%s/wait", uuid.Must(uuid.NewV4())), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
 }
 
-func TestServer_JobDetail(t *testing.T) {
+func TestServer_PipelinesSchedule_WithPriorityRequiresScope(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	outputStore := test.NewMockOutputStore()
-
-	pRunner, err := prunner.NewPipelineRunner(ctx, defs, func(j *prunner.PipelineJob) taskctl.Runner {
-		return &test.MockRunner{}
-	}, nil, outputStore)
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
 	require.NoError(t, err)
 
+	outputStore := test.NewMockOutputStore()
+
 	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
 	noopMiddleware := func(next http.Handler) http.Handler { return next }
 	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
 
-	claims := map[string]interface{}{
-		"sub": "jane.doe",
-	}
+	claims := make(map[string]interface{})
 	jwtauth.SetIssuedNow(claims)
 	_, tokenString, _ := tokenAuth.Encode(claims)
 
-	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{
-		"pipeline": "release_it"
-	}`))
+	req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{"pipeline": "release_it", "priority": "high"}`))
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
 	rec := httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
 
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	claims["scope"] = "pipelines:read pipelines:priority"
+	_, tokenString, _ = tokenAuth.Encode(claims)
+
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{"pipeline": "release_it", "priority": "high"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
 	require.Equal(t, http.StatusAccepted, rec.Code)
 
 	var result struct{ JobID string }
 	err = json.NewDecoder(rec.Body).Decode(&result)
 	require.NoError(t, err)
 
-	assert.NotEmpty(t, result.JobID)
-	jobID := uuid.Must(uuid.FromString(result.JobID))
+	err = pRunner.ReadJob(uuid.Must(uuid.FromString(result.JobID)), func(j *prunner.PipelineJob) {
+		assert.Equal(t, prunner.JobPriorityHigh, j.Priority)
+	})
+	require.NoError(t, err)
 
-	err = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {})
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{"pipeline": "release_it", "priority": "urgent"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_PipelinesJobs_FilterByLabel(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
 	require.NoError(t, err)
 
-	// Wait until job is completed (busy waiting style)
-	test.WaitForCondition(t, func() bool {
-		var completed bool
-		_ = pRunner.ReadJob(jobID, func(j *prunner.PipelineJob) {
-			completed = j.Completed
-		})
-		return completed
-	}, 50*time.Millisecond, "job exists and is completed")
+	outputStore := test.NewMockOutputStore()
 
-	// Get details
-	req = httptest.NewRequest(http.MethodGet, "/job/detail?id="+jobID.String(), nil)
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	for _, body := range []string{
+		`{"pipeline": "release_it", "labels": {"commit": "abc123", "ticket": "OPS-1"}, "variables": {"tag": "v1.0.0"}}`,
+		`{"pipeline": "release_it", "labels": {"commit": "def456"}, "variables": {"tag": "v1.1.0", "targets": ["staging", "production"]}}`,
+		`{"pipeline": "release_it"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+	}
+
+	listJobs := func(query string) []map[string]string {
+		req := httptest.NewRequest(http.MethodGet, "/pipelines/jobs?"+query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var result struct {
+			Jobs []struct {
+				Labels map[string]string
+			}
+		}
+		err := json.NewDecoder(rec.Body).Decode(&result)
+		require.NoError(t, err)
+
+		var labels []map[string]string
+		for _, job := range result.Jobs {
+			labels = append(labels, job.Labels)
+		}
+		return labels
+	}
+
+	assert.Len(t, listJobs(""), 3)
+	assert.Equal(t, []map[string]string{{"commit": "abc123", "ticket": "OPS-1"}}, listJobs("label=commit=abc123"))
+	assert.Len(t, listJobs("label=commit"), 2)
+	assert.Len(t, listJobs("label=commit&label=ticket=OPS-1"), 1)
+	assert.Empty(t, listJobs("label=commit=unknown"))
+	assert.Equal(t, []map[string]string{{"commit": "def456"}}, listJobs("variable=tag=v1.1.0"))
+	assert.Equal(t, []map[string]string{{"commit": "def456"}}, listJobs("variable=targets=production"))
+	assert.Len(t, listJobs("variable=tag"), 2)
+	assert.Empty(t, listJobs("variable=tag=v1.0.0&label=commit=def456"))
+
+	req := httptest.NewRequest(http.MethodGet, "/pipelines/jobs?variable==x", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(`{"pipeline": "release_it", "labels": {"in valid": "x"}}`))
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
 	rec = httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_PipelinesJobsExport(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false)
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	var jobIDs []string
+	for _, body := range []string{
+		`{"pipeline": "release_it", "labels": {"commit": "abc123"}, "variables": {"tag": "v1.0.0"}}`,
+		`{"pipeline": "release_it", "labels": {"commit": "def456"}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/schedule", strings.NewReader(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+
+		var result struct {
+			JobID string
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+		jobIDs = append(jobIDs, result.JobID)
+	}
 
+	export := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/pipelines/jobs/export?"+query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := export("")
 	require.Equal(t, http.StatusOK, rec.Code)
-	var details struct {
-		ID        string `json:"id"`
-		Pipeline  string `json:"pipeline"`
-		Completed bool   `json:"completed"`
-		User      string `json:"user"`
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		var job struct {
+			ID    string
+			Tasks []interface{}
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &job))
+		assert.Equal(t, jobIDs[i], job.ID, "jobs are exported oldest first")
+		assert.NotEmpty(t, job.Tasks)
 	}
-	err = json.NewDecoder(rec.Body).Decode(&details)
+
+	rec = export("format=csv&label=commit=abc123")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "id", rows[0][0])
+	assert.Equal(t, jobIDs[0], rows[1][0])
+	assert.Equal(t, "release_it", rows[1][1])
+	assert.Equal(t, "incomplete", rows[0][7])
+	assert.Equal(t, "false", rows[1][7])
+	assert.Equal(t, `{"commit":"abc123"}`, rows[1][13])
+	assert.Equal(t, `{"tag":"v1.0.0"}`, rows[1][14])
+
+	rec = export("format=csv&pipeline=unknown")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rows, err = csv.NewReader(rec.Body).ReadAll()
 	require.NoError(t, err)
+	assert.Len(t, rows, 1, "only header is exported")
 
-	assert.Equal(t, jobID.String(), details.ID)
-	assert.Equal(t, "release_it", details.Pipeline)
-	assert.True(t, details.Completed)
-	assert.Equal(t, "jane.doe", details.User)
+	rec = export("format=xml")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_WithRequiredScope(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	pRunner, err := prunner.NewPipelineRunner(ctx, defs, &test.MockRunner{}, nil, nil)
+	require.NoError(t, err)
+
+	outputStore := test.NewMockOutputStore()
+
+	tokenAuth := jwtauth.New("HS256", []byte("not-very-secret"), nil)
+	noopMiddleware := func(next http.Handler) http.Handler { return next }
+	srv := NewServer(pRunner, outputStore, noopMiddleware, tokenAuth, false, WithDataDir(t.TempDir()), WithRequiredScope("admin"))
+
+	claims := make(map[string]interface{})
+	jwtauth.SetIssuedNow(claims)
+	_, tokenString, _ := tokenAuth.Encode(claims)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/disk-usage", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	claims["scope"] = "admin"
+	_, tokenString, _ = tokenAuth.Encode(claims)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/disk-usage", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestProblemTypes_AreDocumented(t *testing.T) {
+	readme, err := os.ReadFile(filepath.Join("..", "README.md"))
+	require.NoError(t, err)
+	section := string(readme)
+	start := strings.Index(section, "### Error responses")
+	require.NotEqual(t, -1, start, "README has a section about error responses")
+	section = section[start:]
+	section = section[:strings.Index(section[1:], "\n### ")+1]
+
+	for problemType := range problemTitles {
+		name := strings.TrimPrefix(string(problemType), "urn:prunner:problem:")
+		assert.Contains(t, section, "`"+name+"`", "problem type %s is documented", problemType)
+	}
 }
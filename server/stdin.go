@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/gofrs/uuid"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+// maxStdinSize limits the data of a single request to the stdin of a task
+const maxStdinSize = 1 << 20
+
+// swagger:parameters jobStdin
+type jobStdinParams struct {
+	// Job id
+	//
+	// required: true
+	// in: query
+	// example: 52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8
+	Id string `json:"id"`
+
+	// Task name
+	//
+	// required: true
+	// in: query
+	// example: deploy
+	Task string `json:"task"`
+
+	// Close stdin after writing the data, so the process reads EOF
+	//
+	// in: query
+	// example: true
+	Close bool `json:"close"`
+
+	// Data for stdin (sent as is, a trailing newline is not added)
+	//
+	// in: body
+	Body string
+}
+
+// swagger:route POST /v1/job/stdin jobStdin
+//
+// Send input to a task
+//
+// Writes the request body to the stdin of a running task that is marked as interactive. Like attaching to a task,
+// this requires a JWT with the admin scope.
+//
+//     Consumes:
+//     - application/octet-stream
+//     - text/plain
+//
+//     Responses:
+//       204:
+//       400: genericErrorResponse
+//       403: genericErrorResponse
+//       404: genericErrorResponse
+//       413: genericErrorResponse
+func (s *server) jobStdin(w http.ResponseWriter, r *http.Request) {
+	var params jobStdinParams
+
+	vars := r.URL.Query()
+	params.Id = vars.Get("id")
+	jobID, err := uuid.FromString(params.Id)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("jobIdString", params.Id).
+			Warn("Invalid job ID")
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid job id")
+		return
+	}
+	params.Task = vars.Get("task")
+	if params.Task == "" {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidRequest, "Invalid task name")
+		return
+	}
+	params.Close = vars.Get("close") == "true" || vars.Get("close") == "1"
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxStdinSize+1))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, ProblemInvalidBody, fmt.Sprintf("Error reading input: %v", err))
+		return
+	}
+	if len(data) > maxStdinSize {
+		s.sendError(w, http.StatusRequestEntityTooLarge, ProblemBodyTooLarge, fmt.Sprintf("Input exceeds the maximum size of %d bytes", maxStdinSize))
+		return
+	}
+
+	if len(data) > 0 {
+		err = s.pRunner.WriteTaskInput(jobID, params.Task, data)
+		if s.handleStdinError(w, jobID, params.Task, err) {
+			return
+		}
+	}
+	if params.Close {
+		err = s.pRunner.CloseTaskInput(jobID, params.Task)
+		if s.handleStdinError(w, jobID, params.Task, err) {
+			return
+		}
+	}
+
+	log.
+		WithField("component", "api").
+		WithField("jobID", jobID).
+		WithField("task", params.Task).
+		WithField("bytes", len(data)).
+		WithField("closed", params.Close).
+		Info("Sent input to task")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStdinError sends an error response and returns true if err is not nil
+func (s *server) handleStdinError(w http.ResponseWriter, jobID uuid.UUID, task string, err error) bool {
+	if errors.Is(err, taskctl.ErrNoTaskInput) {
+		s.sendError(w, http.StatusNotFound, ProblemTaskNotRunning, "Task is not running or not interactive")
+		return true
+	} else if err != nil {
+		log.
+			WithError(err).
+			WithField("jobID", jobID).
+			WithField("task", task).
+			Errorf("Error writing to stdin of task")
+		s.sendError(w, http.StatusInternalServerError, ProblemInternalError, "Error writing to stdin of task")
+		return true
+	}
+	return false
+}
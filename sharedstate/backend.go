@@ -0,0 +1,67 @@
+// Package sharedstate shares the concurrency of pipelines between prunner instances that run the same pipelines, so
+// they do not start more jobs of a pipeline than its concurrency allows. Jobs and queues are not shared, they are kept
+// by the instance that accepted the job.
+//
+// State is stored as versioned values in a backend with compare-and-swap semantics: a value is only written if its
+// version was not changed by another instance since it was read.
+package sharedstate
+
+import (
+	"sync"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ErrVersionConflict is returned by CompareAndSwap if the value was changed since it was read
+var ErrVersionConflict = errors.New("version conflict")
+
+// Backend stores versioned values that are shared by prunner instances
+type Backend interface {
+	// Get returns the value and version of the key, the version is 0 if the key does not exist
+	Get(key string) ([]byte, int64, error)
+	// CompareAndSwap writes the value if the key still has the version, it returns ErrVersionConflict otherwise.
+	// The version of the key is incremented by a successful write.
+	CompareAndSwap(key string, version int64, value []byte) error
+}
+
+// MemoryBackend stores values in memory, it can be used for tests or multiple runners in one process
+type MemoryBackend struct {
+	mx     sync.Mutex
+	values map[string]versionedValue
+}
+
+type versionedValue struct {
+	value   []byte
+	version int64
+}
+
+var _ Backend = &MemoryBackend{}
+
+// NewMemoryBackend creates an empty memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		values: make(map[string]versionedValue),
+	}
+}
+
+func (m *MemoryBackend) Get(key string) ([]byte, int64, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	v := m.values[key]
+	return v.value, v.version, nil
+}
+
+func (m *MemoryBackend) CompareAndSwap(key string, version int64, value []byte) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.values[key].version != version {
+		return ErrVersionConflict
+	}
+	m.values[key] = versionedValue{
+		value:   append([]byte(nil), value...),
+		version: version + 1,
+	}
+	return nil
+}
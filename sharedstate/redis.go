@@ -0,0 +1,118 @@
+package sharedstate
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner/resp"
+)
+
+const (
+	// redisTimeout is the timeout for connecting and commands, it is short since slots are claimed while the runner is locked
+	redisTimeout = 2 * time.Second
+)
+
+// compareAndSwapScript sets the value of a hash with a version field if the version matches (scripts are atomic)
+const compareAndSwapScript = `
+local version = redis.call('HGET', KEYS[1], 'version') or '0'
+if version ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'version', tostring(tonumber(version) + 1), 'value', ARGV[2])
+return 1
+`
+
+// RedisBackend stores values as hashes with a version and value field in Redis
+type RedisBackend struct {
+	opts   resp.Options
+	prefix string
+
+	// mx guards the connection, it is re-established after errors
+	mx   sync.Mutex
+	conn *resp.Conn
+}
+
+var _ Backend = &RedisBackend{}
+
+// NewRedisBackend parses the URL (redis://[:password@]host[:port][/db]) for storing values with keys prefixed by prefix
+func NewRedisBackend(rawURL string, prefix string) (*RedisBackend, error) {
+	opts, err := resp.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisBackend{
+		opts:   opts,
+		prefix: prefix,
+	}, nil
+}
+
+func (b *RedisBackend) Get(key string) ([]byte, int64, error) {
+	reply, err := b.do("HMGET", b.prefix+key, "version", "value")
+	if err != nil {
+		return nil, 0, err
+	}
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields) != 2 {
+		return nil, 0, errors.Errorf("unexpected reply %v", reply)
+	}
+
+	versionField, _ := fields[0].(string)
+	if versionField == "" {
+		return nil, 0, nil
+	}
+	version, err := strconv.ParseInt(versionField, 10, 64)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "parsing version")
+	}
+	value, _ := fields[1].(string)
+	return []byte(value), version, nil
+}
+
+func (b *RedisBackend) CompareAndSwap(key string, version int64, value []byte) error {
+	reply, err := b.do("EVAL", compareAndSwapScript, "1", b.prefix+key, strconv.FormatInt(version, 10), string(value))
+	if err != nil {
+		return err
+	}
+	if swapped, _ := reply.(int64); swapped != 1 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Close closes the connection
+func (b *RedisBackend) Close() error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+func (b *RedisBackend) do(args ...string) (interface{}, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.conn == nil {
+		conn, err := resp.Connect(b.opts, redisTimeout)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+	}
+
+	reply, err := b.conn.Do(redisTimeout, args...)
+	if err != nil && err != resp.ErrNil {
+		// The connection could be in an undefined state, e.g. after a timeout
+		b.conn.Close()
+		b.conn = nil
+		return nil, err
+	}
+	return reply, nil
+}
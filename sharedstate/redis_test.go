@@ -0,0 +1,166 @@
+package sharedstate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis implements HMGET and the compare-and-swap script for hashes with version and value fields
+type fakeRedis struct {
+	mx     sync.Mutex
+	hashes map[string]map[string]string
+}
+
+func (f *fakeRedis) serve(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		f.mx.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "HMGET":
+			fmt.Fprintf(conn, "*%d\r\n", len(args)-2)
+			for _, field := range args[2:] {
+				value, exists := f.hashes[args[1]][field]
+				if !exists {
+					fmt.Fprint(conn, "$-1\r\n")
+					continue
+				}
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			}
+		case "EVAL":
+			key, version, value := args[3], args[4], args[5]
+			hash := f.hashes[key]
+			if hash == nil {
+				hash = map[string]string{"version": "0"}
+				f.hashes[key] = hash
+			}
+			if hash["version"] != version {
+				fmt.Fprint(conn, ":0\r\n")
+				break
+			}
+			v, _ := strconv.Atoi(version)
+			hash["version"] = strconv.Itoa(v + 1)
+			hash["value"] = value
+			fmt.Fprint(conn, ":1\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mx.Unlock()
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisBackend(t *testing.T) {
+	redis := &fakeRedis{hashes: make(map[string]map[string]string)}
+	address := redis.serve(t)
+
+	backend, err := NewRedisBackend("redis://"+address, "prunner:")
+	require.NoError(t, err)
+	defer backend.Close()
+
+	value, version, err := backend.Get("slots:deploy")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+	assert.Equal(t, int64(0), version)
+
+	require.NoError(t, backend.CompareAndSwap("slots:deploy", 0, []byte(`{"jobs": {}}`)))
+	assert.ErrorIs(t, backend.CompareAndSwap("slots:deploy", 0, []byte(`{}`)), ErrVersionConflict)
+
+	value, version, err = backend.Get("slots:deploy")
+	require.NoError(t, err)
+	assert.Equal(t, `{"jobs": {}}`, string(value))
+	assert.Equal(t, int64(1), version)
+
+	redis.mx.Lock()
+	assert.Contains(t, redis.hashes, "prunner:slots:deploy")
+	redis.mx.Unlock()
+}
+
+// TestRedisBackend_Redis runs the compare-and-swap script on a real Redis server, it is only run if
+// PRUNNER_TEST_REDIS_URL is set (see resp.TestConn_Redis)
+func TestRedisBackend_Redis(t *testing.T) {
+	rawURL := os.Getenv("PRUNNER_TEST_REDIS_URL")
+	if rawURL == "" {
+		t.Skip("PRUNNER_TEST_REDIS_URL is not set")
+	}
+
+	prefix := fmt.Sprintf("prunner-test:%d:", time.Now().UnixNano())
+	backend, err := NewRedisBackend(rawURL, prefix)
+	require.NoError(t, err)
+	defer func() {
+		_, _ = backend.do("DEL", prefix+"slots:deploy")
+		backend.Close()
+	}()
+
+	value, version, err := backend.Get("slots:deploy")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+	assert.Equal(t, int64(0), version)
+
+	require.NoError(t, backend.CompareAndSwap("slots:deploy", 0, []byte(`{"jobs": {}}`)))
+	assert.ErrorIs(t, backend.CompareAndSwap("slots:deploy", 0, []byte(`{}`)), ErrVersionConflict)
+	require.NoError(t, backend.CompareAndSwap("slots:deploy", 1, []byte(`{"jobs": {"a": 1}}`)))
+
+	value, version, err = backend.Get("slots:deploy")
+	require.NoError(t, err)
+	assert.Equal(t, `{"jobs": {"a": 1}}`, string(value))
+	assert.Equal(t, int64(2), version)
+}
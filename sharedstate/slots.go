@@ -0,0 +1,223 @@
+package sharedstate
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// DefaultLeaseDuration is the time after which the slot of a job is freed if its instance does not renew the lease
+	// (e.g. because it crashed)
+	DefaultLeaseDuration = 30 * time.Second
+	// maxAttempts is the number of attempts to write a value if other instances changed it concurrently
+	maxAttempts = 10
+)
+
+// slotsValue is stored as JSON for each pipeline, it contains the leases of the running jobs of all instances
+type slotsValue struct {
+	Jobs map[string]lease `json:"jobs"`
+}
+
+type lease struct {
+	Instance string    `json:"instance"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Slots shares the concurrency of pipelines between instances, it implements prunner.SlotClaimer.
+//
+// A claimed slot is a lease that expires if it is not renewed by Run, so slots of crashed instances are freed.
+type Slots struct {
+	backend       Backend
+	instanceID    string
+	leaseDuration time.Duration
+	now           func() time.Time
+
+	mx sync.Mutex
+	// claimed contains the pipeline of jobs with a slot claimed by this instance by job id
+	claimed map[uuid.UUID]string
+}
+
+// Opts is a slots configuration function.
+type Opts func(*Slots)
+
+// WithLeaseDuration sets the time after which a slot is freed if the lease is not renewed
+func WithLeaseDuration(leaseDuration time.Duration) Opts {
+	return func(s *Slots) {
+		s.leaseDuration = leaseDuration
+	}
+}
+
+// NewSlots creates slots for the instance, the id must be unique for all instances using the backend
+func NewSlots(backend Backend, instanceID string, opts ...Opts) *Slots {
+	s := &Slots{
+		backend:       backend,
+		instanceID:    instanceID,
+		leaseDuration: DefaultLeaseDuration,
+		now:           time.Now,
+		claimed:       make(map[uuid.UUID]string),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Claim claims a slot of the pipeline for the job if less than concurrency jobs hold a slot
+func (s *Slots) Claim(pipeline string, jobID uuid.UUID, concurrency int) (bool, error) {
+	claimed := false
+	err := s.update(pipeline, func(v *slotsValue) bool {
+		if _, exists := v.Jobs[jobID.String()]; exists {
+			claimed = true
+			return false
+		}
+		if len(v.Jobs) >= concurrency {
+			claimed = false
+			return false
+		}
+		v.Jobs[jobID.String()] = s.newLease()
+		claimed = true
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if claimed {
+		s.mx.Lock()
+		s.claimed[jobID] = pipeline
+		s.mx.Unlock()
+	}
+	return claimed, nil
+}
+
+// Release frees the slot of the job
+func (s *Slots) Release(pipeline string, jobID uuid.UUID) error {
+	s.mx.Lock()
+	_, claimed := s.claimed[jobID]
+	delete(s.claimed, jobID)
+	s.mx.Unlock()
+	if !claimed {
+		return nil
+	}
+
+	return s.update(pipeline, func(v *slotsValue) bool {
+		if _, exists := v.Jobs[jobID.String()]; !exists {
+			return false
+		}
+		delete(v.Jobs, jobID.String())
+		return true
+	})
+}
+
+// Run renews the leases of claimed slots until the context is done
+func (s *Slots) Run(ctx context.Context) {
+	t := time.NewTicker(s.leaseDuration / 3)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := s.renew()
+			if err != nil {
+				log.
+					WithField("component", "sharedstate").
+					WithError(err).
+					Warn("Failed to renew leases of slots")
+			}
+		}
+	}
+}
+
+func (s *Slots) renew() error {
+	s.mx.Lock()
+	jobsByPipeline := make(map[string][]uuid.UUID)
+	for jobID, pipeline := range s.claimed {
+		jobsByPipeline[pipeline] = append(jobsByPipeline[pipeline], jobID)
+	}
+	s.mx.Unlock()
+
+	for pipeline, jobIDs := range jobsByPipeline {
+		err := s.update(pipeline, func(v *slotsValue) bool {
+			for _, jobID := range jobIDs {
+				// A lease that expired could have been taken by another instance, but the job is still running
+				v.Jobs[jobID.String()] = s.newLease()
+			}
+			return true
+		})
+		if err != nil {
+			return errors.Wrapf(err, "renewing leases of pipeline %s", pipeline)
+		}
+	}
+	return nil
+}
+
+func (s *Slots) newLease() lease {
+	return lease{
+		Instance: s.instanceID,
+		Expires:  s.now().Add(s.leaseDuration),
+	}
+}
+
+// update reads the value of the pipeline without expired leases, calls modify and writes the value if it was
+// changed. It is retried if the value was changed by another instance concurrently.
+func (s *Slots) update(pipeline string, modify func(v *slotsValue) bool) error {
+	key := "slots:" + pipeline
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		data, version, err := s.backend.Get(key)
+		if err != nil {
+			return errors.Wrap(err, "reading slots")
+		}
+
+		var v slotsValue
+		if len(data) > 0 {
+			err = json.Unmarshal(data, &v)
+			if err != nil {
+				return errors.Wrap(err, "decoding slots")
+			}
+		}
+		if v.Jobs == nil {
+			v.Jobs = make(map[string]lease)
+		}
+
+		changed := false
+		now := s.now()
+		for jobID, l := range v.Jobs {
+			if now.After(l.Expires) {
+				delete(v.Jobs, jobID)
+				changed = true
+			}
+		}
+		if modify(&v) {
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+
+		data, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		err = s.backend.CompareAndSwap(key, version, data)
+		if err == ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "writing slots")
+		}
+		return nil
+	}
+
+	return errors.Errorf("slots of pipeline %s were changed concurrently %d times", pipeline, maxAttempts)
+}
@@ -0,0 +1,108 @@
+package sharedstate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlots_Claim(t *testing.T) {
+	backend := NewMemoryBackend()
+	slotsA := NewSlots(backend, "a")
+	slotsB := NewSlots(backend, "b")
+
+	job1 := uuid.Must(uuid.NewV4())
+	job2 := uuid.Must(uuid.NewV4())
+	job3 := uuid.Must(uuid.NewV4())
+
+	claimed, err := slotsA.Claim("deploy", job1, 2)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Claiming again is idempotent
+	claimed, err = slotsA.Claim("deploy", job1, 2)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = slotsB.Claim("deploy", job2, 2)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = slotsB.Claim("deploy", job3, 2)
+	require.NoError(t, err)
+	assert.False(t, claimed, "all slots are used")
+
+	// Other pipelines have their own slots
+	claimed, err = slotsB.Claim("build", job3, 1)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	require.NoError(t, slotsB.Release("build", job3))
+
+	require.NoError(t, slotsA.Release("deploy", job1))
+
+	claimed, err = slotsB.Claim("deploy", job3, 2)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestSlots_ExpiredLeases(t *testing.T) {
+	backend := NewMemoryBackend()
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	slotsA := NewSlots(backend, "a", WithLeaseDuration(30*time.Second))
+	slotsA.now = func() time.Time { return now }
+	slotsB := NewSlots(backend, "b", WithLeaseDuration(30*time.Second))
+	slotsB.now = func() time.Time { return now }
+
+	job1 := uuid.Must(uuid.NewV4())
+	job2 := uuid.Must(uuid.NewV4())
+
+	claimed, err := slotsA.Claim("deploy", job1, 1)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	// A renewed lease does not expire
+	now = now.Add(20 * time.Second)
+	require.NoError(t, slotsA.renew())
+	now = now.Add(20 * time.Second)
+
+	claimed, err = slotsB.Claim("deploy", job2, 1)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	// Instance a stopped renewing its lease (e.g. it crashed)
+	now = now.Add(31 * time.Second)
+
+	claimed, err = slotsB.Claim("deploy", job2, 1)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+// conflictingBackend changes the value before the first write of a key to simulate a concurrent write
+type conflictingBackend struct {
+	*MemoryBackend
+	conflicts int
+}
+
+func (b *conflictingBackend) CompareAndSwap(key string, version int64, value []byte) error {
+	if b.conflicts > 0 {
+		b.conflicts--
+		_ = b.MemoryBackend.CompareAndSwap(key, version, []byte(`{"jobs": {}}`))
+	}
+	return b.MemoryBackend.CompareAndSwap(key, version, value)
+}
+
+func TestSlots_RetriesConflicts(t *testing.T) {
+	backend := &conflictingBackend{MemoryBackend: NewMemoryBackend(), conflicts: 2}
+	slots := NewSlots(backend, "a")
+
+	claimed, err := slots.Claim("deploy", uuid.Must(uuid.NewV4()), 1)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	backend.conflicts = maxAttempts
+	_, err = slots.Claim("deploy", uuid.Must(uuid.NewV4()), 2)
+	assert.EqualError(t, err, "slots of pipeline deploy were changed concurrently 10 times")
+}
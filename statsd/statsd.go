@@ -0,0 +1,250 @@
+// Package statsd pushes metrics of jobs and tasks to a StatsD server with tags in the DogStatsD format (e.g. for a
+// Datadog agent), as an addition to scraping /metrics.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+
+	"github.com/Flowpack/prunner"
+)
+
+// DefaultPrefix is the default prefix of metric names
+const DefaultPrefix = "prunner"
+
+const (
+	// queueSize is the number of metrics that are buffered while sending, further metrics are dropped
+	queueSize = 4096
+	// maxPacketSize keeps packets below the usual MTU, so they are not fragmented
+	maxPacketSize = 1432
+)
+
+// Sink sends metrics for events of the runner asynchronously via UDP, HandleEvent can be used as a subscription of
+// the runner.
+//
+// The following metrics are sent (with the tags pipeline, task and status where applicable):
+//
+//	<prefix>.job.scheduled (counter, tag queued)
+//	<prefix>.job.started (counter)
+//	<prefix>.job.completed (counter, status done, error, canceled or expired)
+//	<prefix>.job.duration (timer in ms from start to end)
+//	<prefix>.job.queue_time (timer in ms from creation to start)
+//	<prefix>.task.finished (counter, status done, error or skipped)
+//	<prefix>.task.duration (timer in ms, sent when the job completed)
+type Sink struct {
+	prefix string
+	tags   []string
+
+	// mx guards sending metrics and closing, so events after Close are ignored
+	mx      sync.Mutex
+	closed  bool
+	metrics chan string
+	done    chan struct{}
+	dropped uint64
+
+	conn net.Conn
+}
+
+// New builds a sink sending to the address (host:port) with the global tags (name:value) added to all metrics
+func New(address string, prefix string, tags []string) (*Sink, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, errors.Wrap(err, "invalid StatsD address")
+	}
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, ",|#") {
+			return nil, errors.Errorf("invalid StatsD tag %q", tag)
+		}
+	}
+	// UDP is connectionless, dialing only resolves the address
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving StatsD address")
+	}
+
+	s := &Sink{
+		prefix:  prefix,
+		tags:    tags,
+		metrics: make(chan string, queueSize),
+		done:    make(chan struct{}),
+		conn:    conn,
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// HandleEvent converts the event to metrics and queues them for sending without blocking.
+// Metrics are dropped if the queue is full.
+func (s *Sink) HandleEvent(e prunner.Event) {
+	metrics := s.metricsFromEvent(e)
+	if len(metrics) == 0 {
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.closed {
+		return
+	}
+
+	for _, metric := range metrics {
+		select {
+		case s.metrics <- metric:
+		default:
+			if atomic.AddUint64(&s.dropped, 1) == 1 {
+				log.
+					WithField("component", "statsd").
+					Warn("Queue of StatsD metrics is full, dropping metrics")
+			}
+		}
+	}
+}
+
+// Close sends the queued metrics, later events are ignored.
+// Metrics that are not sent until the timeout are dropped.
+func (s *Sink) Close(timeout time.Duration) error {
+	s.mx.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.metrics)
+	}
+	s.mx.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout sending queued StatsD metrics")
+	}
+}
+
+// run sends the queued metrics, metrics that are queued at the same time are combined into one packet
+func (s *Sink) run() {
+	defer close(s.done)
+	defer s.conn.Close()
+
+	for metric := range s.metrics {
+		packet := metric
+	batch:
+		for {
+			select {
+			case next, ok := <-s.metrics:
+				if !ok {
+					break batch
+				}
+				if len(packet)+1+len(next) > maxPacketSize {
+					s.send(packet)
+					packet = next
+					continue
+				}
+				packet += "\n" + next
+			default:
+				break batch
+			}
+		}
+		s.send(packet)
+	}
+}
+
+func (s *Sink) send(packet string) {
+	_, err := s.conn.Write([]byte(packet))
+	if err != nil {
+		log.
+			WithField("component", "statsd").
+			WithError(err).
+			Warn("Error sending StatsD metrics")
+		return
+	}
+	if dropped := atomic.SwapUint64(&s.dropped, 0); dropped > 0 {
+		log.
+			WithField("component", "statsd").
+			WithField("dropped", dropped).
+			Warn("Dropped StatsD metrics while the queue was full")
+	}
+}
+
+func (s *Sink) metricsFromEvent(e prunner.Event) []string {
+	switch e := e.(type) {
+	case prunner.JobScheduled:
+		return []string{s.format("job.scheduled", "1", "c", "pipeline:"+e.Pipeline, fmt.Sprintf("queued:%t", e.Queued))}
+	case prunner.JobStarted:
+		return []string{s.format("job.started", "1", "c", "pipeline:"+e.Pipeline)}
+	case prunner.TaskFinished:
+		status := "done"
+		switch {
+		case e.Skipped:
+			status = "skipped"
+		case e.Errored:
+			status = "error"
+		}
+		return []string{s.format("task.finished", "1", "c", "pipeline:"+e.Pipeline, "task:"+e.Task, "status:"+status)}
+	case prunner.JobCompleted:
+		// The job is only read while the runner holds its lock
+		job := e.Job
+		status := "done"
+		switch {
+		case job.Expired:
+			status = "expired"
+		case job.Canceled:
+			status = "canceled"
+		case job.LastError != nil:
+			status = "error"
+		}
+		metrics := []string{s.format("job.completed", "1", "c", "pipeline:"+e.Pipeline, "status:"+status)}
+		if job.Start != nil {
+			metrics = append(metrics, s.format("job.queue_time", milliseconds(job.Start.Sub(job.Created)), "ms", "pipeline:"+e.Pipeline))
+			if job.End != nil {
+				metrics = append(metrics, s.format("job.duration", milliseconds(job.End.Sub(*job.Start)), "ms", "pipeline:"+e.Pipeline, "status:"+status))
+			}
+		}
+		for _, t := range job.Tasks {
+			if t.Start == nil || t.End == nil {
+				continue
+			}
+			taskStatus := "done"
+			if t.Errored {
+				taskStatus = "error"
+			}
+			metrics = append(metrics, s.format("task.duration", milliseconds(t.End.Sub(*t.Start)), "ms", "pipeline:"+e.Pipeline, "task:"+t.Name, "status:"+taskStatus))
+		}
+		return metrics
+	}
+	// Other changes (e.g. processes) are not sent
+	return nil
+}
+
+// format returns a metric in the DogStatsD format <prefix>.<name>:<value>|<type>|#<tags>
+func (s *Sink) format(name string, value string, typ string, tags ...string) string {
+	var b strings.Builder
+	if s.prefix != "" {
+		b.WriteString(s.prefix)
+		b.WriteString(".")
+	}
+	fmt.Fprintf(&b, "%s:%s|%s", name, value, typ)
+
+	allTags := append(append([]string(nil), s.tags...), tags...)
+	for i, tag := range allTags {
+		if i == 0 {
+			b.WriteString("|#")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(tagReplacer.Replace(tag))
+	}
+	return b.String()
+}
+
+// tagReplacer replaces characters of tag values (e.g. in pipeline names) that are part of the format
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%d", d.Milliseconds())
+}
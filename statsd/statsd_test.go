@@ -0,0 +1,93 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner"
+)
+
+// listenFakeStatsD receives packets and sends the contained metrics to the channel
+func listenFakeStatsD(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	metrics := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, metric := range strings.Split(string(buf[:n]), "\n") {
+				metrics <- metric
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String(), metrics
+}
+
+func receiveMetrics(t *testing.T, metrics <-chan string, n int) []string {
+	t.Helper()
+
+	var result []string
+	for len(result) < n {
+		select {
+		case metric := <-metrics:
+			result = append(result, metric)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d metrics, got %v", n, result)
+		}
+	}
+	return result
+}
+
+func TestSink_HandleEvent(t *testing.T) {
+	address, metrics := listenFakeStatsD(t)
+
+	s, err := New(address, "ci.prunner", []string{"env:test"})
+	require.NoError(t, err)
+
+	jobID := uuid.Must(uuid.NewV4())
+	jobEvent := prunner.JobEvent{JobID: jobID, Pipeline: "deploy"}
+	s.HandleEvent(prunner.JobScheduled{JobEvent: jobEvent, Queued: true})
+	s.HandleEvent(prunner.TaskFinished{JobEvent: jobEvent, Task: "build", Errored: true})
+
+	created := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	start := created.Add(2 * time.Second)
+	end := start.Add(1500 * time.Millisecond)
+	s.HandleEvent(prunner.JobCompleted{JobEvent: jobEvent, Job: &prunner.PipelineJob{
+		ID:        jobID,
+		Pipeline:  "deploy",
+		Completed: true,
+		Created:   created,
+		Start:     &start,
+		End:       &end,
+	}})
+
+	require.NoError(t, s.Close(time.Second))
+
+	assert.Equal(t, []string{
+		"ci.prunner.job.scheduled:1|c|#env:test,pipeline:deploy,queued:true",
+		"ci.prunner.task.finished:1|c|#env:test,pipeline:deploy,task:build,status:error",
+		"ci.prunner.job.completed:1|c|#env:test,pipeline:deploy,status:done",
+		"ci.prunner.job.queue_time:2000|ms|#env:test,pipeline:deploy",
+		"ci.prunner.job.duration:1500|ms|#env:test,pipeline:deploy,status:done",
+	}, receiveMetrics(t, metrics, 5))
+}
+
+func TestNew_WithInvalidTag(t *testing.T) {
+	_, err := New("127.0.0.1:8125", DefaultPrefix, []string{"team:a,b"})
+	assert.Error(t, err)
+}
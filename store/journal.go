@@ -0,0 +1,175 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+)
+
+// journalEntry is a line in the journal, either a changed or a removed job
+type journalEntry struct {
+	Job     *PersistedJob `json:",omitempty"`
+	Removed *uuid.UUID    `json:",omitempty"`
+}
+
+// SaveChanges appends changed and removed jobs to the journal of the current data file
+func (j *JsonDataStore) SaveChanges(changed []PersistedJob, removed []uuid.UUID) error {
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for i := range changed {
+		err := enc.Encode(journalEntry{Job: &changed[i]})
+		if err != nil {
+			return errors.Wrap(err, "encoding JSON")
+		}
+	}
+	for i := range removed {
+		err := enc.Encode(journalEntry{Removed: &removed[i]})
+		if err != nil {
+			return errors.Wrap(err, "encoding JSON")
+		}
+	}
+
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	f, err := os.OpenFile(j.journalFilename(j.generation), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return errors.Wrap(err, "opening journal")
+	}
+	// An incomplete entry of an interrupted write is terminated, so it does not corrupt the new entries
+	if !endsWithNewline(f) {
+		_, err = f.Write([]byte{'\n'})
+		if err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "writing journal")
+		}
+	}
+	// All entries are written at once, so only the last entry could be incomplete after a crash
+	_, err = f.Write(buf.Bytes())
+	closeErr := f.Close()
+	if err != nil {
+		return errors.Wrap(err, "writing journal")
+	}
+	if closeErr != nil {
+		return errors.Wrap(closeErr, "closing journal")
+	}
+
+	return nil
+}
+
+// applyJournal applies the changes in the journal of the generation of the data
+func (j *JsonDataStore) applyJournal(data *PersistedData) error {
+	jobIndexByID := make(map[uuid.UUID]int, len(data.Jobs))
+	for i, job := range data.Jobs {
+		jobIndexByID[job.ID] = i
+	}
+	removedJobs := make(map[uuid.UUID]struct{})
+
+	err := j.readJournal(data.Generation, func(entry journalEntry) {
+		switch {
+		case entry.Job != nil:
+			delete(removedJobs, entry.Job.ID)
+			if i, exists := jobIndexByID[entry.Job.ID]; exists {
+				data.Jobs[i] = *entry.Job
+			} else {
+				jobIndexByID[entry.Job.ID] = len(data.Jobs)
+				data.Jobs = append(data.Jobs, *entry.Job)
+			}
+		case entry.Removed != nil:
+			removedJobs[*entry.Removed] = struct{}{}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(removedJobs) > 0 {
+		jobs := data.Jobs[:0]
+		for _, job := range data.Jobs {
+			if _, removed := removedJobs[job.ID]; !removed {
+				jobs = append(jobs, job)
+			}
+		}
+		data.Jobs = jobs
+	}
+
+	return nil
+}
+
+// readJournal calls process for each entry in the journal of the generation
+func (j *JsonDataStore) readJournal(generation int, process func(entry journalEntry)) error {
+	f, err := os.Open(j.journalFilename(generation))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "opening journal")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Jobs with many tasks and variables can exceed the default line limit
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// Incomplete entries of an interrupted write are skipped
+			continue
+		}
+		process(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "reading journal")
+	}
+
+	return nil
+}
+
+func endsWithNewline(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return true
+	}
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, info.Size()-1)
+	return err != nil || last[0] == '\n'
+}
+
+func (j *JsonDataStore) journalFilename(generation int) string {
+	return filepath.Join(j.path, fmt.Sprintf("data.%d.journal", generation))
+}
+
+func (j *JsonDataStore) removeStaleJournals() error {
+	filenames, err := filepath.Glob(filepath.Join(j.path, "data.*.journal"))
+	if err != nil {
+		return errors.Wrap(err, "finding journals")
+	}
+	current := j.journalFilename(j.generation)
+	for _, filename := range filenames {
+		if filename == current {
+			continue
+		}
+		err := os.Remove(filename)
+		if err != nil {
+			return errors.Wrap(err, "removing stale journal")
+		}
+	}
+	return nil
+}
+
+func removeIfExists(filename string) error {
+	err := os.Remove(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrapf(err, "removing %s", filepath.Base(filename))
+	}
+	return nil
+}
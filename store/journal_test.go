@@ -0,0 +1,73 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJsonDataStore_SaveChanges(t *testing.T) {
+	dataDir := t.TempDir()
+	dataStore, err := NewJSONDataStore(dataDir)
+	require.NoError(t, err)
+
+	created := time.Now().UTC().Truncate(time.Second)
+	job1 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: created}
+	job2 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: created}
+	require.NoError(t, dataStore.Save(&PersistedData{Jobs: []PersistedJob{job1, job2}}))
+
+	job1.Completed = true
+	job3 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "cleanup", Created: created}
+	require.NoError(t, dataStore.SaveChanges([]PersistedJob{job1, job3}, nil))
+	require.NoError(t, dataStore.SaveChanges(nil, []uuid.UUID{job2.ID}))
+	assert.FileExists(t, filepath.Join(dataDir, "data.1.journal"))
+
+	data, err := loadDataStore(t, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, []PersistedJob{job1, job3}, data.Jobs)
+
+	// A full save compacts the changes
+	require.NoError(t, dataStore.Save(data))
+	assert.NoFileExists(t, filepath.Join(dataDir, "data.1.journal"))
+
+	data, err = loadDataStore(t, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, data.Generation)
+	assert.Equal(t, []PersistedJob{job1, job3}, data.Jobs)
+}
+
+func TestJsonDataStore_SaveChanges_AfterInterruptedWrite(t *testing.T) {
+	dataDir := t.TempDir()
+	dataStore, err := NewJSONDataStore(dataDir)
+	require.NoError(t, err)
+
+	job := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: time.Now().UTC().Truncate(time.Second)}
+	require.NoError(t, dataStore.Save(&PersistedData{Jobs: []PersistedJob{job}}))
+
+	// Simulate a crash while writing an entry
+	f, err := os.OpenFile(filepath.Join(dataDir, "data.1.journal"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"Job":{"ID":"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	job.Completed = true
+	require.NoError(t, dataStore.SaveChanges([]PersistedJob{job}, nil))
+
+	data, err := loadDataStore(t, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, []PersistedJob{job}, data.Jobs)
+}
+
+func loadDataStore(t *testing.T, dataDir string) (*PersistedData, error) {
+	t.Helper()
+
+	dataStore, err := NewJSONDataStore(dataDir)
+	require.NoError(t, err)
+	return dataStore.Load()
+}
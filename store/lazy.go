@@ -0,0 +1,179 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/friendsofgo/errors"
+	"github.com/gofrs/uuid"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// LoadJob loads a single job from the data file and its journal, it returns nil if the job does not exist
+func (j *JsonDataStore) LoadJob(id uuid.UUID) (*PersistedJob, error) {
+	j.mx.RLock()
+	defer j.mx.RUnlock()
+
+	var result *PersistedJob
+	generation, err := j.readDataFile(func(job *PersistedJob) {
+		if job.ID == id {
+			result = job
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.readJournal(generation, func(entry journalEntry) {
+		switch {
+		case entry.Job != nil && entry.Job.ID == id:
+			result = entry.Job
+		case entry.Removed != nil && *entry.Removed == id:
+			result = nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Compact writes a new data file with the changes of the journal, jobs are read and written one by one
+func (j *JsonDataStore) Compact() error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	// Only the changes are kept in memory
+	var (
+		changedJobs = make(map[uuid.UUID]*PersistedJob)
+		removedJobs = make(map[uuid.UUID]struct{})
+		addedJobs   []uuid.UUID
+	)
+	err := j.readJournal(j.generation, func(entry journalEntry) {
+		switch {
+		case entry.Job != nil:
+			if _, exists := changedJobs[entry.Job.ID]; !exists {
+				addedJobs = append(addedJobs, entry.Job.ID)
+			}
+			changedJobs[entry.Job.ID] = entry.Job
+			delete(removedJobs, entry.Job.ID)
+		case entry.Removed != nil:
+			removedJobs[*entry.Removed] = struct{}{}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	generation := j.generation + 1
+	err = removeIfExists(j.journalFilename(generation))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(j.path, "data.*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	tmpFilename := f.Name()
+
+	stream := jsoniter.NewStream(json, f, 64*1024)
+	stream.WriteObjectStart()
+	stream.WriteObjectField("Jobs")
+	stream.WriteArrayStart()
+	first := true
+	writeJob := func(job *PersistedJob) {
+		if _, removed := removedJobs[job.ID]; removed {
+			return
+		}
+		if !first {
+			stream.WriteMore()
+		}
+		first = false
+		stream.WriteVal(job)
+		// Flush regularly, so the buffer does not grow with the number of jobs
+		_ = stream.Flush()
+	}
+
+	_, err = j.readDataFile(func(job *PersistedJob) {
+		if changedJob, changed := changedJobs[job.ID]; changed {
+			job = changedJob
+			delete(changedJobs, job.ID)
+		}
+		writeJob(job)
+	})
+	if err == nil {
+		// Jobs that are not in the data file yet are appended in the order they were added to the journal
+		for _, id := range addedJobs {
+			if job, notWritten := changedJobs[id]; notWritten {
+				writeJob(job)
+			}
+		}
+		stream.WriteArrayEnd()
+		stream.WriteMore()
+		stream.WriteObjectField("Generation")
+		stream.WriteInt(generation)
+		stream.WriteObjectEnd()
+		stream.WriteRaw("\n")
+		err = stream.Flush()
+		if err == nil {
+			err = stream.Error
+		}
+		if err != nil {
+			err = errors.Wrap(err, "encoding JSON")
+		}
+	}
+	// In any case close the file
+	f.Close()
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+
+	err = os.Rename(tmpFilename, filepath.Join(j.path, "data.json"))
+	if err != nil {
+		return errors.Wrap(err, "replacing data file by rename")
+	}
+	j.generation = generation
+
+	return j.removeStaleJournals()
+}
+
+// readDataFile calls process for each job in the data file without decoding all jobs at once, it returns the
+// generation of the data file
+func (j *JsonDataStore) readDataFile(process func(job *PersistedJob)) (int, error) {
+	f, err := os.Open(filepath.Join(j.path, "data.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "opening file")
+	}
+	defer f.Close()
+
+	var generation int
+	iter := jsoniter.Parse(json, f, 64*1024)
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
+		switch field {
+		case "Jobs":
+			iter.ReadArrayCB(func(iter *jsoniter.Iterator) bool {
+				var job PersistedJob
+				iter.ReadVal(&job)
+				if iter.Error == nil {
+					process(&job)
+				}
+				return iter.Error == nil
+			})
+		case "Generation":
+			generation = iter.ReadInt()
+		default:
+			iter.Skip()
+		}
+		return iter.Error == nil
+	})
+	if iter.Error != nil {
+		return 0, errors.Wrap(iter.Error, "decoding JSON")
+	}
+
+	return generation, nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJsonDataStore_LoadJob(t *testing.T) {
+	dataDir := t.TempDir()
+	dataStore, err := NewJSONDataStore(dataDir)
+	require.NoError(t, err)
+
+	created := time.Now().UTC().Truncate(time.Second)
+	job1 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: created}
+	job2 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: created}
+	job3 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "cleanup", Created: created}
+	require.NoError(t, dataStore.Save(&PersistedData{Jobs: []PersistedJob{job1, job2}}))
+
+	job1.Completed = true
+	require.NoError(t, dataStore.SaveChanges([]PersistedJob{job1, job3}, []uuid.UUID{job2.ID}))
+
+	job, err := dataStore.LoadJob(job1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &job1, job, "changed job is loaded from journal")
+
+	job, err = dataStore.LoadJob(job2.ID)
+	require.NoError(t, err)
+	assert.Nil(t, job, "removed job")
+
+	job, err = dataStore.LoadJob(job3.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &job3, job, "added job is loaded from journal")
+
+	job, err = dataStore.LoadJob(uuid.Must(uuid.NewV4()))
+	require.NoError(t, err)
+	assert.Nil(t, job, "unknown job")
+}
+
+func TestJsonDataStore_Compact(t *testing.T) {
+	dataDir := t.TempDir()
+	dataStore, err := NewJSONDataStore(dataDir)
+	require.NoError(t, err)
+
+	created := time.Now().UTC().Truncate(time.Second)
+	job1 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: created}
+	job2 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "deploy", Created: created}
+	job3 := PersistedJob{ID: uuid.Must(uuid.NewV4()), Pipeline: "cleanup", Created: created}
+	require.NoError(t, dataStore.Save(&PersistedData{Jobs: []PersistedJob{job1, job2}}))
+
+	job1.Completed = true
+	require.NoError(t, dataStore.SaveChanges([]PersistedJob{job1, job3}, []uuid.UUID{job2.ID}))
+
+	require.NoError(t, dataStore.Compact())
+	assert.NoFileExists(t, filepath.Join(dataDir, "data.1.journal"))
+
+	data, err := loadDataStore(t, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, data.Generation)
+	assert.Equal(t, []PersistedJob{job1, job3}, data.Jobs)
+
+	// Changes after compacting are saved to the journal of the new generation
+	job3.Canceled = true
+	require.NoError(t, dataStore.SaveChanges([]PersistedJob{job3}, nil))
+	assert.FileExists(t, filepath.Join(dataDir, "data.2.journal"))
+
+	data, err = loadDataStore(t, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, []PersistedJob{job1, job3}, data.Jobs)
+}
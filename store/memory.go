@@ -0,0 +1,38 @@
+package store
+
+import (
+	"sync"
+)
+
+// MemoryDataStore keeps the job state in memory, e.g. for embedding prunner without persisting jobs across restarts
+type MemoryDataStore struct {
+	data *PersistedData
+	mx   sync.Mutex
+}
+
+var _ DataStore = &MemoryDataStore{}
+
+func NewMemoryDataStore() *MemoryDataStore {
+	return &MemoryDataStore{}
+}
+
+func (m *MemoryDataStore) Load() (*PersistedData, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.data == nil {
+		return &PersistedData{}, nil
+	}
+	// The runner builds new data on every save, so the jobs can be shared
+	data := *m.data
+	data.Jobs = append([]PersistedJob(nil), m.data.Jobs...)
+	return &data, nil
+}
+
+func (m *MemoryDataStore) Save(data *PersistedData) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.data = data
+	return nil
+}
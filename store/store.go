@@ -1,8 +1,10 @@
 package store
 
 import (
+	"io"
 	"os"
-	"path"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/friendsofgo/errors"
@@ -16,8 +18,10 @@ type PersistedJob struct {
 	ID       uuid.UUID
 	Pipeline string
 
-	Completed bool `json:",omitempty"`
-	Canceled  bool `json:",omitempty"`
+	Completed  bool `json:",omitempty"`
+	Canceled   bool `json:",omitempty"`
+	Incomplete bool `json:",omitempty"`
+	Expired    bool `json:",omitempty"`
 	// Created is the schedule / queue time of the job
 	Created time.Time
 	// Start is the actual start time of the job
@@ -27,26 +31,159 @@ type PersistedJob struct {
 
 	Variables map[string]interface{} `json:",omitempty"`
 	User      string                 `json:",omitempty"`
+	Priority  int                    `json:",omitempty"`
+	Labels    map[string]string      `json:",omitempty"`
+	// CorrelationID is the ID of the request that scheduled the job
+	CorrelationID string `json:",omitempty"`
+	// Trigger is how the job was scheduled (e.g. api)
+	Trigger string `json:",omitempty"`
+
+	// Env, PriorityClass, Interpreter, Weight, Timeout and Git are a snapshot of the pipeline definition when the job
+	// was scheduled
+	Env           map[string]string     `json:",omitempty"`
+	PriorityClass int                   `json:",omitempty"`
+	Interpreter   int                   `json:",omitempty"`
+	Weight        int                   `json:",omitempty"`
+	Timeout       time.Duration         `json:",omitempty"`
+	Git           *PersistedGitCheckout `json:",omitempty"`
+	// GitCommit is the SHA of the commit that was checked out for the job
+	GitCommit string `json:",omitempty"`
+	// LogsPruned is set if the logs were removed because of the log quota of the pipeline
+	LogsPruned bool `json:",omitempty"`
+	// Rehydrated is the time the job was restored from the archive
+	Rehydrated *time.Time `json:",omitempty"`
+	// RequeuedFrom is the id of the canceled job this job was requeued from
+	RequeuedFrom *uuid.UUID `json:",omitempty"`
+	// ClonedFrom is the id of the job whose inputs this job was scheduled with
+	ClonedFrom *uuid.UUID `json:",omitempty"`
+	// Annotations are notes attached to the job after it was scheduled
+	Annotations []PersistedAnnotation `json:",omitempty"`
+	// SLABreached is set if the job ran longer than the SLA of the pipeline
+	SLABreached bool `json:",omitempty"`
+	// ExpectedDuration and SlowAfter are computed from the durations of previous jobs when the job started
+	ExpectedDuration time.Duration `json:",omitempty"`
+	SlowAfter        time.Duration `json:",omitempty"`
+	// KeepTmpOnFailure keeps the temporary directory of the job if it failed
+	KeepTmpOnFailure bool `json:",omitempty"`
+	// Contexts are the contexts of the pipeline definition when the job was scheduled
+	Contexts map[string]PersistedContext `json:",omitempty"`
+	// Services are the services of the pipeline definition when the job was scheduled and their state
+	Services []PersistedService `json:",omitempty"`
 
 	Tasks []PersistedTask
+
+	// Processes are the running processes of the job, used to kill orphaned processes after a crash
+	Processes []PersistedProcess `json:",omitempty"`
+}
+
+// PersistedGitCheckout is the git checkout of a job
+type PersistedGitCheckout struct {
+	URL         string
+	Ref         string `json:",omitempty"`
+	Depth       int    `json:",omitempty"`
+	Dir         string `json:",omitempty"`
+	Username    string `json:",omitempty"`
+	PasswordEnv string `json:",omitempty"`
+}
+
+// PersistedContext is a context of the tasks of a job
+type PersistedContext struct {
+	ComposeFile     string
+	ComposeServices []string `json:",omitempty"`
+}
+
+// PersistedService is a service of a job
+type PersistedService struct {
+	Name                string
+	Command             string
+	Env                 map[string]string `json:",omitempty"`
+	Dir                 string            `json:",omitempty"`
+	HealthCheck         string            `json:",omitempty"`
+	HealthCheckInterval time.Duration     `json:",omitempty"`
+	StartTimeout        time.Duration     `json:",omitempty"`
+	Status              string            `json:",omitempty"`
+	Start               *time.Time        `json:",omitempty"`
+	End                 *time.Time        `json:",omitempty"`
+	Error               *string           `json:",omitempty"`
+}
+
+type PersistedProcess struct {
+	Pid       int
+	StartTime uint64 `json:",omitempty"`
+	BootID    string `json:",omitempty"`
 }
 
 type PersistedTask struct {
 	Name         string
 	Script       []string
-	DependsOn    []string   `json:",omitempty"`
-	AllowFailure bool       `json:",omitempty"`
-	Status       string     `json:",omitempty"`
+	DependsOn    []string `json:",omitempty"`
+	AllowFailure bool     `json:",omitempty"`
+	// When, Env, Interpreter, Dir, Timeout, Retries, Agent, the no output settings and Interactive are a snapshot of the
+	// task definition when the job was scheduled
+	When            string                 `json:",omitempty"`
+	ChangedPaths    *PersistedChangedPaths `json:",omitempty"`
+	Context         string                 `json:",omitempty"`
+	Env             map[string]string      `json:",omitempty"`
+	Interpreter     int                    `json:",omitempty"`
+	Dir             string                 `json:",omitempty"`
+	Timeout         time.Duration          `json:",omitempty"`
+	Retries         *int                   `json:",omitempty"`
+	Agent           string                 `json:",omitempty"`
+	NoOutputTimeout time.Duration          `json:",omitempty"`
+	NoOutputAction  int                    `json:",omitempty"`
+	Interactive     bool                   `json:",omitempty"`
+	Status          string                 `json:",omitempty"`
+	Start           *time.Time             `json:",omitempty"`
+	End             *time.Time             `json:",omitempty"`
+	Skipped         bool                   `json:",omitempty"`
+	ExitCode        int16                  `json:",omitempty"`
+	Errored         bool                   `json:",omitempty"`
+	Error           *string                `json:",omitempty"`
+
+	UserTime   time.Duration `json:",omitempty"`
+	SystemTime time.Duration `json:",omitempty"`
+	MaxRSS     int64         `json:",omitempty"`
+
+	// EnvSnapshot and VariablesSnapshot are the masked environment and variables the task received when it was started
+	EnvSnapshot       map[string]string      `json:",omitempty"`
+	VariablesSnapshot map[string]interface{} `json:",omitempty"`
+
+	Commands []PersistedCommand `json:",omitempty"`
+}
+
+// PersistedChangedPaths is the changed paths condition of a task
+type PersistedChangedPaths struct {
+	Base  string `json:",omitempty"`
+	Paths []string
+}
+
+// PersistedCommand is the status of a single command of the script of a task
+type PersistedCommand struct {
+	Command      string
 	Start        *time.Time `json:",omitempty"`
 	End          *time.Time `json:",omitempty"`
-	Skipped      bool       `json:",omitempty"`
 	ExitCode     int16      `json:",omitempty"`
-	Errored      bool       `json:",omitempty"`
 	Error        *string    `json:",omitempty"`
+	StdoutOffset int64      `json:",omitempty"`
+	StdoutLength int64      `json:",omitempty"`
+	StderrOffset int64      `json:",omitempty"`
+	StderrLength int64      `json:",omitempty"`
+}
+
+// PersistedAnnotation is a note attached to a job
+type PersistedAnnotation struct {
+	Text    string
+	User    string `json:",omitempty"`
+	Created time.Time
 }
 
 type PersistedData struct {
 	Jobs []PersistedJob
+
+	// HandedOff is set if the state was saved for a new instance taking over, jobs on the wait list are kept
+	HandedOff bool `json:",omitempty"`
+	// Generation of the data file, changes since the data file was written are in the journal of the same generation
+	Generation int `json:",omitempty"`
 }
 
 type DataStore interface {
@@ -54,11 +191,30 @@ type DataStore interface {
 	Save(data *PersistedData) error
 }
 
+// IncrementalDataStore can save changed and removed jobs without writing all jobs.
+// Save writes the complete state and should be called regularly to compact the changes.
+type IncrementalDataStore interface {
+	DataStore
+	SaveChanges(changed []PersistedJob, removed []uuid.UUID) error
+}
+
+// LazyDataStore can load single jobs on demand, so not all jobs need to be kept in memory.
+// Compact merges the saved changes into the complete state without loading all jobs.
+type LazyDataStore interface {
+	IncrementalDataStore
+	LoadJob(id uuid.UUID) (*PersistedJob, error)
+	Compact() error
+}
+
 type JsonDataStore struct {
 	path string
+	// mx serializes writes, jobs can be loaded concurrently (see LoadJob)
+	mx sync.RWMutex
+	// generation of the last loaded or saved data file
+	generation int
 }
 
-var _ DataStore = &JsonDataStore{}
+var _ LazyDataStore = &JsonDataStore{}
 
 func NewJSONDataStore(path string) (*JsonDataStore, error) {
 	// Make sure directory for store file exists
@@ -73,7 +229,26 @@ func NewJSONDataStore(path string) (*JsonDataStore, error) {
 }
 
 func (j *JsonDataStore) Load() (*PersistedData, error) {
-	f, err := os.Open(path.Join(j.path, "data.json"))
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	data, err := j.loadDataFile()
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.applyJournal(data)
+	if err != nil {
+		return nil, err
+	}
+	j.generation = data.Generation
+
+	return data, nil
+}
+
+func (j *JsonDataStore) loadDataFile() (*PersistedData, error) {
+	f, err := os.Open(filepath.Join(j.path, "data.json"))
+	// Changes before the first full save are in the journal of generation 0
 	if errors.Is(err, os.ErrNotExist) {
 		return &PersistedData{}, nil
 	} else if err != nil {
@@ -81,17 +256,21 @@ func (j *JsonDataStore) Load() (*PersistedData, error) {
 	}
 	defer f.Close()
 
-	var result PersistedData
+	return ReadData(f)
+}
 
-	err = json.NewDecoder(f).Decode(&result)
+// Save writes the complete state to the data file and removes the journal with changes of the previous data file
+func (j *JsonDataStore) Save(data *PersistedData) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	data.Generation = j.generation + 1
+	// A journal of the new generation could be left over from an older data directory (e.g. after a restore)
+	err := removeIfExists(j.journalFilename(data.Generation))
 	if err != nil {
-		return nil, errors.Wrap(err, "decoding JSON")
+		return err
 	}
 
-	return &result, nil
-}
-
-func (j *JsonDataStore) Save(data *PersistedData) error {
 	// Use a temporary file for writing data to be crash resistant
 	f, err := os.CreateTemp(j.path, "data.*.tmp")
 	if err != nil {
@@ -99,18 +278,56 @@ func (j *JsonDataStore) Save(data *PersistedData) error {
 	}
 	tmpFilename := f.Name()
 
-	err = json.NewEncoder(f).Encode(data)
+	err = WriteData(f, data)
 	// In any case close the file
 	f.Close()
 	if err != nil {
-		return errors.Wrap(err, "encoding JSON")
+		return err
 	}
 
 	// Rename the tmp file to the data file to have something more atomic than writing directly to the data file
-	err = os.Rename(tmpFilename, path.Join(j.path, "data.json"))
+	err = os.Rename(tmpFilename, filepath.Join(j.path, "data.json"))
 	if err != nil {
 		return errors.Wrap(err, "replacing data file by rename")
 	}
+	j.generation = data.Generation
 
+	// The changes in journals of older generations are contained in the data file now
+	return j.removeStaleJournals()
+}
+
+// RemoveTempFiles removes temporary files of saves that were interrupted (e.g. by a crash) and returns their number
+func (j *JsonDataStore) RemoveTempFiles() (int, error) {
+	filenames, err := filepath.Glob(filepath.Join(j.path, "data.*.tmp"))
+	if err != nil {
+		return 0, errors.Wrap(err, "finding temporary files")
+	}
+	for i, filename := range filenames {
+		err := os.Remove(filename)
+		if err != nil {
+			return i, errors.Wrap(err, "removing temporary file")
+		}
+	}
+	return len(filenames), nil
+}
+
+// ReadData decodes persisted data in the format of the JSON data store
+func ReadData(r io.Reader) (*PersistedData, error) {
+	var result PersistedData
+
+	err := json.NewDecoder(r).Decode(&result)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding JSON")
+	}
+
+	return &result, nil
+}
+
+// WriteData encodes persisted data in the format of the JSON data store
+func WriteData(w io.Writer, data *PersistedData) error {
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		return errors.Wrap(err, "encoding JSON")
+	}
 	return nil
 }
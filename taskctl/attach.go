@@ -0,0 +1,114 @@
+package taskctl
+
+import (
+	"io"
+	"sync"
+
+	"github.com/friendsofgo/errors"
+)
+
+// OutputChunk is data written by a running task to stdout or stderr
+type OutputChunk struct {
+	// Stream is the name of the output (stdout or stderr)
+	Stream string
+	Data   []byte
+}
+
+// OutputStream is implemented by runners that stream the output of running tasks to subscribers
+type OutputStream interface {
+	// SubscribeTaskOutput returns a channel that receives the output of a running task. The channel is closed when the
+	// task finished or unsubscribe is called.
+	SubscribeTaskOutput(jobID, taskName string) (chunks <-chan OutputChunk, unsubscribe func(), err error)
+}
+
+// ErrTaskNotRunning is returned when subscribing to the output of a task that is not running
+var ErrTaskNotRunning = errors.New("task is not running")
+
+// outputSubscriberBuffer is the number of chunks that are buffered for a subscriber, a subscriber that does not
+// receive fast enough misses output
+const outputSubscriberBuffer = 256
+
+// outputBroadcast sends the output of a running task to all subscribers without blocking the task
+type outputBroadcast struct {
+	mx          sync.Mutex
+	subscribers map[chan OutputChunk]struct{}
+	closed      bool
+}
+
+func newOutputBroadcast() *outputBroadcast {
+	return &outputBroadcast{subscribers: make(map[chan OutputChunk]struct{})}
+}
+
+func (b *outputBroadcast) writer(stream string) io.Writer {
+	return &broadcastWriter{broadcast: b, stream: stream}
+}
+
+func (b *outputBroadcast) subscribe() (<-chan OutputChunk, func(), bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.closed {
+		return nil, nil, false
+	}
+	ch := make(chan OutputChunk, outputSubscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}, true
+}
+
+// close closes the channels of all subscribers after the task finished
+func (b *outputBroadcast) close() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+type broadcastWriter struct {
+	broadcast *outputBroadcast
+	stream    string
+}
+
+func (w *broadcastWriter) Write(p []byte) (n int, err error) {
+	w.broadcast.mx.Lock()
+	defer w.broadcast.mx.Unlock()
+
+	if len(w.broadcast.subscribers) == 0 {
+		return len(p), nil
+	}
+	// The buffer is reused by the writer, so subscribers get a copy
+	chunk := OutputChunk{Stream: w.stream, Data: append([]byte(nil), p...)}
+	for ch := range w.broadcast.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// SubscribeTaskOutput returns a channel that receives the output of a running task until the task finished or
+// unsubscribe is called. ErrTaskNotRunning is returned if the task is not running.
+func (r *TaskRunner) SubscribeTaskOutput(jobID, taskName string) (<-chan OutputChunk, func(), error) {
+	b, ok := r.outputs.Load(taskKey(jobID, taskName))
+	if !ok {
+		return nil, nil, ErrTaskNotRunning
+	}
+	chunks, unsubscribe, ok := b.(*outputBroadcast).subscribe()
+	if !ok {
+		return nil, nil, ErrTaskNotRunning
+	}
+	return chunks, unsubscribe, nil
+}
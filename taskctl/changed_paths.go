@@ -0,0 +1,64 @@
+package taskctl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"github.com/mattn/go-zglob"
+)
+
+// DefaultChangedPathsBase is the git ref the changed paths are compared with if no base is set
+const DefaultChangedPathsBase = "HEAD~1"
+
+// ChangedPaths skips a task unless files matching one of the path globs changed compared to a git ref
+type ChangedPaths struct {
+	// Base is the git ref to compare with, it is rendered with the variables of the job
+	Base string `json:"base,omitempty"`
+	// Paths are globs relative to the root of the repository, ** matches any number of directories (a trailing /**
+	// matches all files in the directory)
+	Paths []string `json:"paths"`
+}
+
+// changed runs git diff --name-only in the directory and returns true if a changed file matches one of the paths
+func (c ChangedPaths) changed(ctx context.Context, dir string, vars map[string]interface{}) (bool, error) {
+	base := c.Base
+	if base == "" {
+		base = DefaultChangedPathsBase
+	}
+	base, err := RenderCommand(base, vars)
+	if err != nil {
+		return false, errors.Wrap(err, "rendering base of changed paths")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", base, "--")
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return false, errors.Wrapf(err, "running git diff against %s: %s", base, strings.TrimSpace(stderr.String()))
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		file := scanner.Text()
+		for _, pattern := range c.Paths {
+			// zglob does not match files in subdirectories with a trailing **
+			if strings.HasSuffix(pattern, "/**") {
+				pattern += "/*"
+			}
+			matched, err := zglob.Match(pattern, file)
+			if err != nil {
+				return false, errors.Wrapf(err, "matching path %q", pattern)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, scanner.Err()
+}
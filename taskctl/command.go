@@ -0,0 +1,56 @@
+package taskctl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CommandChange is reported when a command of the script of a task was started or has finished
+type CommandChange struct {
+	// JobID and TaskName are set by the TaskRunner for the task that executes the command
+	JobID    string
+	TaskName string
+
+	// Index is the position of the command in the script of the task
+	Index   int
+	Command string
+
+	Start time.Time
+	// End is zero while the command is running
+	End      time.Time
+	ExitCode int16
+	// Error is set if the command failed (only set if finished)
+	Error error
+
+	// StdoutOffset and StderrOffset are the positions in the output of the task where the output of the command starts
+	StdoutOffset int64
+	StderrOffset int64
+	// StdoutLength and StderrLength are the number of bytes written by the command (only set if finished)
+	StdoutLength int64
+	StderrLength int64
+}
+
+// Finished returns true if the command has finished
+func (c CommandChange) Finished() bool {
+	return !c.End.IsZero()
+}
+
+// outputCounters count the bytes written to stdout and stderr of a task to compute the output offsets of commands
+type outputCounters struct {
+	stdout outputCounter
+	stderr outputCounter
+}
+
+// outputCounter counts the bytes written to an output of a task
+type outputCounter struct {
+	n int64
+}
+
+func (c *outputCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+func (c *outputCounter) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
@@ -0,0 +1,82 @@
+package taskctl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ComposeProject is a docker compose project of a job, its services are started before the first task using it runs
+// and stopped after the job with Down
+type ComposeProject struct {
+	// Name of the project, it must be unique for each job so jobs running concurrently do not share services
+	Name string
+	// File is the path of the compose file, commands are executed in its directory
+	File string
+	// Services are started (all services of the compose file if empty)
+	Services []string
+
+	mx      sync.Mutex
+	started bool
+	err     error
+}
+
+// Env returns environment variables for tasks using the project, so docker compose commands of a task address its
+// services
+func (p *ComposeProject) Env() map[string]string {
+	return map[string]string{
+		"COMPOSE_FILE":         p.File,
+		"COMPOSE_PROJECT_NAME": p.Name,
+	}
+}
+
+// up starts the services and waits until they are running (or healthy), only the first call starts the services and
+// later calls return its error
+func (p *ComposeProject) up(ctx context.Context, stdout, stderr io.Writer) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.started {
+		return p.err
+	}
+	p.started = true
+	p.err = p.compose(ctx, stdout, stderr, append([]string{"up", "--detach", "--wait"}, p.Services...)...)
+	return p.err
+}
+
+// Down stops and removes the services, volumes and networks of the project if it was started
+func (p *ComposeProject) Down(ctx context.Context, stdout, stderr io.Writer) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if !p.started {
+		return nil
+	}
+	// Services are also removed if up failed, since some of them might be running
+	p.started = false
+	return p.compose(ctx, stdout, stderr, "down", "--volumes", "--remove-orphans")
+}
+
+func (p *ComposeProject) compose(ctx context.Context, stdout, stderr io.Writer, args ...string) error {
+	var errOutput bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"compose", "--file", p.File, "--project-name", p.Name}, args...)...)
+	cmd.Dir = filepath.Dir(p.File)
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, &errOutput)
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "docker compose %s: %s", args[0], lastLine(errOutput.String()))
+	}
+	return nil
+}
+
+// lastLine returns the last non-empty line of the output, it usually contains the reason of an error
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
@@ -17,22 +17,56 @@ import (
 	"github.com/taskctl/taskctl/pkg/utils"
 )
 
-// PgidExecutor is an executor that starts commands in a new process group (if supported by OS)
+// PgidExecutor is an executor that starts commands in a new session and process group (if supported by OS)
 //
-// It is based on executor.DefaultExecutor but uses an exec handler that sets setpgid to
-// start child processes in a new process group (with negative pid). It is used to prevent
+// It is based on executor.DefaultExecutor but uses an exec handler that sets setsid to
+// start child processes in a new session (with a process group id equal to the pid). It is used to prevent
 // signals from propagating to children and to kill all descendant processes e.g. when forked by a shell process.
 type PgidExecutor struct {
 	dir    string
 	env    []string
 	interp *interp.Runner
+
+	killTimeout     time.Duration
+	priority        ProcessPriority
+	interpreter     Interpreter
+	onProcessChange func(c ProcessChange)
+}
+
+// ExecutorOpts is a pgid executor configuration function.
+type ExecutorOpts func(*PgidExecutor)
+
+// WithPriority sets the CPU and IO priority of processes started by the executor
+func WithPriority(priority ProcessPriority) ExecutorOpts {
+	return func(e *PgidExecutor) {
+		e.priority = priority
+	}
+}
+
+// WithInterpreter sets an external interpreter for executing commands instead of the built-in shell interpreter
+func WithInterpreter(interpreter Interpreter) ExecutorOpts {
+	return func(e *PgidExecutor) {
+		e.interpreter = interpreter
+	}
+}
+
+// WithOnProcessChange sets a callback that is called when a process was started or has exited
+func WithOnProcessChange(f func(c ProcessChange)) ExecutorOpts {
+	return func(e *PgidExecutor) {
+		e.onProcessChange = f
+	}
 }
 
 // NewPgidExecutor creates new pgid executor
-func NewPgidExecutor(stdin io.Reader, stdout, stderr io.Writer, killTimeout time.Duration) (*PgidExecutor, error) {
+func NewPgidExecutor(stdin io.Reader, stdout, stderr io.Writer, killTimeout time.Duration, opts ...ExecutorOpts) (*PgidExecutor, error) {
 	var err error
 	e := &PgidExecutor{
-		env: os.Environ(),
+		env:         os.Environ(),
+		killTimeout: killTimeout,
+	}
+
+	for _, o := range opts {
+		o(e)
 	}
 
 	e.dir, err = os.Getwd()
@@ -61,7 +95,7 @@ func NewPgidExecutor(stdin io.Reader, stdout, stderr io.Writer, killTimeout time
 		// the stdout/stderr files.
 		interp.StdIO(stdin, stdout, stderr),
 		// we use a custom ExecHandler for overriding the process group handling
-		interp.ExecHandler(createExecHandler(killTimeout)),
+		interp.ExecHandler(e.createExecHandler()),
 
 		// END MODIFICATION
 	)
@@ -75,15 +109,25 @@ func NewPgidExecutor(stdin io.Reader, stdout, stderr io.Writer, killTimeout time
 // Execute executes given job with provided context
 // Returns job output
 func (e *PgidExecutor) Execute(ctx context.Context, job *executor.Job) ([]byte, error) {
-	command, err := utils.RenderString(job.Command, job.Vars.Map())
+	// BEGIN MODIFICATION compared to taskctl/pkg/executor/executor.go
+	// render with sprig functions and built-in job functions
+	command, err := RenderCommand(job.Command, job.Vars.Map())
 	if err != nil {
 		return nil, err
 	}
+	// END MODIFICATION
 
-	cmd, err := syntax.NewParser(syntax.KeepComments(true)).Parse(strings.NewReader(command), "")
-	if err != nil {
-		return nil, err
+	// BEGIN MODIFICATION compared to taskctl/pkg/executor/executor.go
+	var cmd *syntax.File
+	if e.interpreter.IsDefault() {
+		cmd, err = syntax.NewParser(syntax.KeepComments(true)).Parse(strings.NewReader(command), "")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cmd = e.interpreter.program(command)
 	}
+	// END MODIFICATION
 
 	env := e.env
 	env = append(env, utils.ConvertEnv(utils.ConvertToMapOfStrings(job.Env.Map()))...)
@@ -126,6 +170,12 @@ func (e *PgidExecutor) Execute(ctx context.Context, job *executor.Job) ([]byte,
 	// END MODIFICATION
 }
 
+func (e *PgidExecutor) notifyProcessChange(c ProcessChange) {
+	if e.onProcessChange != nil {
+		e.onProcessChange(c)
+	}
+}
+
 func execEnv(env expand.Environ) []string {
 	list := make([]string, 0, 64)
 	env.Each(func(name string, vr expand.Variable) bool {
@@ -0,0 +1,69 @@
+//go:build linux
+
+package taskctl_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/taskctl/taskctl/pkg/executor"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+func TestPgidExecutor_Execute_KillsBackgroundChildrenOnCancel(t *testing.T) {
+	e, err := taskctl.NewPgidExecutor(nil, ioutil.Discard, ioutil.Discard, 200*time.Millisecond)
+	require.NoError(t, err)
+
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	// The background child ignores SIGINT (like background jobs of non-interactive shells) and uses its own process group
+	job := executor.NewJobFromCommand("sh -c 'set -m; (trap \"\" INT; exec sleep 30) & echo $! > " + pidFile + "; wait'")
+	_, err = e.Execute(ctx, job)
+	require.Error(t, err)
+
+	pidBytes, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	childPid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !isProcessAlive(childPid)
+	}, 2*time.Second, 50*time.Millisecond, "background child process should be killed")
+}
+
+func isProcessAlive(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestPgidExecutor_Execute_FailsIfPriorityCanNotBeApplied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root is allowed to lower the niceness")
+	}
+
+	var stdout bytes.Buffer
+	e, err := taskctl.NewPgidExecutor(nil, &stdout, ioutil.Discard, 2*time.Second, taskctl.WithPriority(taskctl.ProcessPriority{Niceness: -20}))
+	require.NoError(t, err)
+
+	_, err = e.Execute(context.Background(), executor.NewJobFromCommand("sh -c 'echo started'"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "applying process priority")
+	assert.Empty(t, stdout.String(), "command is not started")
+}
@@ -1,8 +1,11 @@
 package taskctl_test
 
 import (
+	"bytes"
 	"context"
 	"io/ioutil"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -54,3 +57,48 @@ func TestPgidExecutor_Execute(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestPgidExecutor_Execute_WithInterpreter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("echo is not an executable on Windows")
+	}
+
+	var stdout bytes.Buffer
+	e, err := taskctl.NewPgidExecutor(nil, &stdout, ioutil.Discard, 2*time.Second, taskctl.WithInterpreter(taskctl.Interpreter{Bin: "echo", Args: []string{"-n"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The command is passed as a single argument to the interpreter without shell parsing or expansion
+	command := `Write-Output "$env:HOME" | Out-File 'out.txt'; exit 1`
+	_, err = e.Execute(context.Background(), executor.NewJobFromCommand(command))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != command {
+		t.Errorf("expected command to be passed as is, got %q", stdout.String())
+	}
+}
+
+func TestPgidExecutor_Execute_WithPriority(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process priorities are not supported on Windows")
+	}
+
+	var stdout bytes.Buffer
+	e, err := taskctl.NewPgidExecutor(nil, &stdout, ioutil.Discard, 2*time.Second, taskctl.WithPriority(taskctl.ProcessPriority{Niceness: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The process is started with the priority, so child processes started right away by the shell inherit it
+	_, err = e.Execute(context.Background(), executor.NewJobFromCommand("sh -c 'nice'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if niceness := strings.TrimSpace(stdout.String()); niceness != "10" {
+		t.Errorf("expected niceness 10, got %q", niceness)
+	}
+}
@@ -12,7 +12,13 @@ import (
 	"mvdan.cc/sh/v3/interp"
 )
 
-func createExecHandler(killTimeout time.Duration) interp.ExecHandlerFunc {
+// killPollInterval is the interval for checking if all processes of a session exited after sending SIGINT
+const killPollInterval = 50 * time.Millisecond
+
+func (e *PgidExecutor) createExecHandler() interp.ExecHandlerFunc {
+	killTimeout := e.killTimeout
+	priority := e.priority
+
 	return func(ctx context.Context, args []string) error {
 		hc := interp.HandlerCtx(ctx)
 		path, err := interp.LookPathDir(hc.Dir, hc.Env, args[0])
@@ -29,35 +35,45 @@ func createExecHandler(killTimeout time.Duration) interp.ExecHandlerFunc {
 			Stdout: hc.Stdout,
 			Stderr: hc.Stderr,
 			SysProcAttr: &syscall.SysProcAttr{
-				// Added for prunner: Using a process group for the new child process fixes 2 things:
+				// Added for prunner: Using a new session (which also creates a new process group) for the new child process fixes 2 things:
 				// 1. We can handle signals like SIGINT in the prunner main process and gracefully shutdown running tasks
-				// 2. Bash scripts will not forward signals by default, so using process groups can be used to send signals to all children in the group
+				// 2. Bash scripts will not forward signals by default, so using sessions / process groups can be used to send signals to all children
 				//    (https://unix.stackexchange.com/questions/146756/forward-sigterm-to-child-in-bash)
-				Setpgid: true,
+				// The session is kept by descendants that create their own process group (e.g. with job control), so they are killed as well.
+				Setsid: true,
 			},
 		}
 
-		err = cmd.Start()
+		if priority.IsDefault() {
+			err = cmd.Start()
+		} else {
+			// A priority that can not be applied fails the task with the error
+			err = startWithPriority(&cmd, priority)
+		}
 		if err == nil {
+			process := newProcess(cmd.Process.Pid)
+			e.notifyProcessChange(ProcessChange{Process: process})
+
+			waitDone := make(chan struct{})
 			if done := ctx.Done(); done != nil {
 				go func() {
-					<-done
-
-					if killTimeout <= 0 {
-						_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-						return
+					select {
+					case <-done:
+						// The session id equals the pid of the started process, it stays valid while other processes of the session are alive
+						terminateSession(cmd.Process.Pid, killTimeout)
+					case <-waitDone:
+						// Process exited without cancellation, stop waiting for the context
 					}
-
-					// TODO: don't temporarily leak this goroutine if the program stops itself with the interrupt. (from github.com/mvdan/sh)
-					go func() {
-						time.Sleep(killTimeout)
-						_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-					}()
-					_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
 				}()
 			}
 
 			err = cmd.Wait()
+			close(waitDone)
+			e.notifyProcessChange(ProcessChange{
+				Process: process,
+				Exited:  true,
+				Usage:   usageFromProcessState(cmd.ProcessState),
+			})
 		}
 
 		switch x := err.(type) {
@@ -82,3 +98,23 @@ func createExecHandler(killTimeout time.Duration) interp.ExecHandlerFunc {
 		}
 	}
 }
+
+// terminateSession interrupts all processes of the session and kills remaining processes after killTimeout.
+//
+// This also kills background children of shell scripts that ignore SIGINT, even if the started process already exited.
+func terminateSession(sid int, killTimeout time.Duration) {
+	if killTimeout > 0 {
+		signalSession(sid, syscall.SIGINT)
+
+		deadline := time.Now().Add(killTimeout)
+		for time.Now().Before(deadline) {
+			time.Sleep(killPollInterval)
+			// Signal 0 only checks for processes that are still alive
+			if !signalSession(sid, 0) {
+				return
+			}
+		}
+	}
+
+	signalSession(sid, syscall.SIGKILL)
+}
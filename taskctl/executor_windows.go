@@ -3,11 +3,129 @@
 package taskctl
 
 import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/apex/log"
 	"mvdan.cc/sh/v3/interp"
 )
 
-func createExecHandler(killTimeout time.Duration) interp.ExecHandlerFunc {
-	return interp.DefaultExecHandler(killTimeout)
+// ctrlBreakEvent is the CTRL_BREAK_EVENT for GenerateConsoleCtrlEvent
+const ctrlBreakEvent = 1
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// createExecHandler returns an exec handler that starts processes in a new process group and terminates the whole
+// process tree on cancellation. Process priorities are not supported on Windows.
+func (e *PgidExecutor) createExecHandler() interp.ExecHandlerFunc {
+	killTimeout := e.killTimeout
+
+	return func(ctx context.Context, args []string) error {
+		hc := interp.HandlerCtx(ctx)
+		path, err := interp.LookPathDir(hc.Dir, hc.Env, args[0])
+		if err != nil {
+			_, _ = fmt.Fprintln(hc.Stderr, err)
+			return interp.NewExitStatus(127)
+		}
+		cmd := exec.Cmd{
+			Path:   path,
+			Args:   args,
+			Env:    execEnv(hc.Env),
+			Dir:    hc.Dir,
+			Stdin:  hc.Stdin,
+			Stdout: hc.Stdout,
+			Stderr: hc.Stderr,
+			SysProcAttr: &syscall.SysProcAttr{
+				// A new process group receives CTRL_BREAK events separately from prunner
+				CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+			},
+		}
+		if isCmdInterpreter(path) && len(args) > 1 {
+			// cmd.exe does its own parsing of the command line, so the command must not be escaped
+			cmd.SysProcAttr.CmdLine = cmdCommandLine(args)
+		}
+
+		err = cmd.Start()
+		if err == nil {
+			e.notifyProcessChange(ProcessChange{Process: newProcess(cmd.Process.Pid)})
+
+			waitDone := make(chan struct{})
+			if done := ctx.Done(); done != nil {
+				go func() {
+					select {
+					case <-done:
+						terminateProcessTree(cmd.Process.Pid, killTimeout, waitDone)
+					case <-waitDone:
+						// Process exited without cancellation, stop waiting for the context
+					}
+				}()
+			}
+
+			err = cmd.Wait()
+			close(waitDone)
+			e.notifyProcessChange(ProcessChange{
+				Process: newProcess(cmd.Process.Pid),
+				Exited:  true,
+				Usage:   usageFromProcessState(cmd.ProcessState),
+			})
+		}
+
+		switch x := err.(type) {
+		case *exec.ExitError:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return interp.NewExitStatus(uint8(x.ExitCode()))
+		case *exec.Error:
+			// did not start
+			_, _ = fmt.Fprintf(hc.Stderr, "%v\n", err)
+			return interp.NewExitStatus(127)
+		default:
+			return err
+		}
+	}
+}
+
+// terminateProcessTree sends CTRL_BREAK to the process group and kills the process with all descendants after killTimeout.
+//
+// CTRL_BREAK is only delivered if prunner is attached to a console, otherwise the process tree is killed immediately.
+func terminateProcessTree(pid int, killTimeout time.Duration, waitDone <-chan struct{}) {
+	if killTimeout > 0 {
+		if r, _, _ := procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(pid)); r != 0 {
+			select {
+			case <-waitDone:
+				return
+			case <-time.After(killTimeout):
+			}
+		}
+	}
+
+	// taskkill is the only built-in way to kill a process with all descendants
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+	if err != nil {
+		log.
+			WithField("component", "executor").
+			WithError(err).
+			Warnf("Failed to kill process tree of process %d", pid)
+	}
+}
+
+func isCmdInterpreter(path string) bool {
+	return strings.EqualFold(filepath.Base(path), "cmd.exe")
+}
+
+// cmdCommandLine builds a command line for cmd.exe that passes the last argument (the command) unescaped
+func cmdCommandLine(args []string) string {
+	escaped := make([]string, 0, len(args))
+	for _, arg := range args[:len(args)-1] {
+		escaped = append(escaped, syscall.EscapeArg(arg))
+	}
+	// With /S cmd.exe strips the outer quotes and keeps the command as is
+	return strings.Join(escaped, " ") + ` "` + args[len(args)-1] + `"`
 }
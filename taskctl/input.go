@@ -0,0 +1,70 @@
+package taskctl
+
+import (
+	"os"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// TaskInput is implemented by runners that can send data to the stdin of running interactive tasks
+type TaskInput interface {
+	// WriteTaskInput writes data to the stdin of a running interactive task
+	WriteTaskInput(jobID, taskName string, data []byte) error
+	// CloseTaskInput closes the stdin of a running interactive task, so the process reads EOF
+	CloseTaskInput(jobID, taskName string) error
+}
+
+// ErrNoTaskInput is returned if a task is not running or not interactive
+var ErrNoTaskInput = errors.New("task is not running or not interactive")
+
+// taskInputWriteTimeout limits the time for writing to stdin, e.g. if the process does not read and the pipe is full
+const taskInputWriteTimeout = 5 * time.Second
+
+// openTaskInput creates a pipe for the stdin of an interactive task. The read end is passed to the processes of the
+// task, the write end is registered for WriteTaskInput. The returned function closes both ends.
+func (r *TaskRunner) openTaskInput(jobID, taskName string) (stdin *os.File, closeFunc func(), err error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating stdin pipe")
+	}
+
+	key := taskKey(jobID, taskName)
+	r.inputs.Store(key, pw)
+
+	return pr, func() {
+		r.inputs.Delete(key)
+		_ = pw.Close()
+		_ = pr.Close()
+	}, nil
+}
+
+// WriteTaskInput writes data to the stdin of a running interactive task (see WithTaskInput)
+func (r *TaskRunner) WriteTaskInput(jobID, taskName string, data []byte) error {
+	pw, ok := r.inputs.Load(taskKey(jobID, taskName))
+	if !ok {
+		return ErrNoTaskInput
+	}
+
+	f := pw.(*os.File)
+	// Deadlines are not supported by all platforms, writing blocks until the process reads in that case
+	_ = f.SetWriteDeadline(time.Now().Add(taskInputWriteTimeout))
+	_, err := f.Write(data)
+	if errors.Is(err, os.ErrClosed) {
+		return ErrNoTaskInput
+	}
+	return err
+}
+
+// CloseTaskInput closes the stdin of a running interactive task, further writes fail with ErrNoTaskInput
+func (r *TaskRunner) CloseTaskInput(jobID, taskName string) error {
+	pw, ok := r.inputs.LoadAndDelete(taskKey(jobID, taskName))
+	if !ok {
+		return ErrNoTaskInput
+	}
+	return pw.(*os.File).Close()
+}
+
+func taskKey(jobID, taskName string) string {
+	return jobID + "/" + taskName
+}
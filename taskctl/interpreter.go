@@ -0,0 +1,42 @@
+package taskctl
+
+import (
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Interpreter is an external program that executes task commands instead of the built-in POSIX shell interpreter
+//
+// The command is passed as the last argument without being parsed by the shell interpreter.
+type Interpreter struct {
+	Bin  string
+	Args []string
+}
+
+var (
+	// InterpreterPowerShell executes commands with Windows PowerShell
+	InterpreterPowerShell = Interpreter{Bin: "powershell", Args: []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command"}}
+	// InterpreterPwsh executes commands with PowerShell (Core)
+	InterpreterPwsh = Interpreter{Bin: "pwsh", Args: []string{"-NoProfile", "-NonInteractive", "-Command"}}
+	// InterpreterCmd executes commands with the Windows command interpreter
+	InterpreterCmd = Interpreter{Bin: "cmd", Args: []string{"/D", "/S", "/C"}}
+)
+
+// IsDefault returns true if commands are executed by the built-in shell interpreter
+func (i Interpreter) IsDefault() bool {
+	return i.Bin == ""
+}
+
+// program builds a shell program that calls the interpreter with the command as a single argument
+func (i Interpreter) program(command string) *syntax.File {
+	args := make([]*syntax.Word, 0, len(i.Args)+2)
+	for _, arg := range append(append([]string{i.Bin}, i.Args...), command) {
+		// A single quoted word is passed without any expansion
+		args = append(args, &syntax.Word{Parts: []syntax.WordPart{&syntax.SglQuoted{Value: arg}}})
+	}
+
+	return &syntax.File{
+		Stmts: []*syntax.Stmt{
+			{Cmd: &syntax.CallExpr{Args: args}},
+		},
+	}
+}
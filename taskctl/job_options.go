@@ -0,0 +1,46 @@
+package taskctl
+
+import (
+	"context"
+
+	"github.com/Flowpack/prunner/condition"
+)
+
+// JobOptions are the settings for executing the tasks of a single job, they are passed with the context of the job to
+// the scheduler and task runner that are shared by all jobs
+type JobOptions struct {
+	// Env sets environment variables for all tasks of the job
+	Env map[string]string
+	// ProcessPriority is the CPU and IO priority for processes started by tasks
+	ProcessPriority ProcessPriority
+	// Interpreters are external interpreters for executing the commands of tasks by task name
+	Interpreters map[string]Interpreter
+	// Retries is the number of retries after a failure for tasks by task name
+	Retries map[string]int
+	// NoOutput configures the watchdog for stuck tasks by task name
+	NoOutput map[string]NoOutputWatch
+	// Conditions are evaluated with the variables of the job to skip tasks without running a command by task name
+	Conditions map[string]*condition.Expr
+	// ChangedPaths skip tasks unless files changed in git by task name
+	ChangedPaths map[string]ChangedPaths
+	// Contexts are the docker compose projects that are started before a task runs by task name, tasks using the same
+	// context share the project
+	Contexts map[string]*ComposeProject
+	// EnvTemplateData is the data for rendering templates in env values of tasks when they are started (see RenderEnv)
+	EnvTemplateData map[string]interface{}
+	// WorkerWeight is the number of workers a task uses from the worker pool of the task runner
+	WorkerWeight int
+}
+
+type jobOptionsKey struct{}
+
+// WithJobOptions returns a context for executing the tasks of a job with the given options
+func WithJobOptions(ctx context.Context, opts JobOptions) context.Context {
+	return context.WithValue(ctx, jobOptionsKey{}, opts)
+}
+
+// JobOptionsFromContext returns the options of the job the context belongs to (or empty options)
+func JobOptionsFromContext(ctx context.Context) JobOptions {
+	opts, _ := ctx.Value(jobOptionsKey{}).(JobOptions)
+	return opts
+}
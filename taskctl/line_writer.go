@@ -5,12 +5,22 @@ import (
 	"sync"
 )
 
-// LineWriter splits written data into lines
-// This is not used yet but prepared for streaming output later.
+// LineWriter splits written data into lines, it keeps all lines or the last lines if created by NewLineWriter with a
+// limit. The task runner uses it for the live output of running tasks (see TaskRunner.CurrentTaskOutput).
 type LineWriter struct {
 	mx        sync.RWMutex
 	lines     [][]byte
 	remainder []byte
+
+	// maxLines is the maximum number of kept lines, 0 keeps all lines
+	maxLines int
+	// first is the index of the oldest line in lines if the ring buffer is full
+	first int
+}
+
+// NewLineWriter returns a line writer that keeps the last maxLines lines in a ring buffer (all lines if maxLines is 0)
+func NewLineWriter(maxLines int) *LineWriter {
+	return &LineWriter{maxLines: maxLines}
 }
 
 func (l *LineWriter) Write(p []byte) (n int, err error) {
@@ -34,7 +44,7 @@ func (l *LineWriter) Write(p []byte) (n int, err error) {
 
 		line := append(l.remainder, token...)
 		l.remainder = nil
-		l.lines = append(l.lines, line)
+		l.appendLine(line)
 
 		i += adv
 	}
@@ -48,7 +58,10 @@ func (l *LineWriter) Lines() [][]byte {
 	l.mx.RLock()
 	defer l.mx.RUnlock()
 
-	return l.lines
+	lines := make([][]byte, 0, len(l.lines))
+	lines = append(lines, l.lines[l.first:]...)
+	lines = append(lines, l.lines[:l.first]...)
+	return lines
 }
 
 func (l *LineWriter) Finish() {
@@ -56,7 +69,17 @@ func (l *LineWriter) Finish() {
 	defer l.mx.Unlock()
 
 	if l.remainder != nil {
-		l.lines = append(l.lines, l.remainder)
+		l.appendLine(l.remainder)
 		l.remainder = nil
 	}
 }
+
+// appendLine adds a line and replaces the oldest line if the ring buffer is full, it must be called with the lock held
+func (l *LineWriter) appendLine(line []byte) {
+	if l.maxLines <= 0 || len(l.lines) < l.maxLines {
+		l.lines = append(l.lines, line)
+		return
+	}
+	l.lines[l.first] = line
+	l.first = (l.first + 1) % len(l.lines)
+}
@@ -41,3 +41,32 @@ func TestLineWriter_Write(t *testing.T) {
 		[]byte("Last line"),
 	}, lw.lines)
 }
+
+func TestLineWriter_WithMaxLines(t *testing.T) {
+	lw := NewLineWriter(3)
+
+	_, err := lw.Write([]byte("Line 1\nLine 2\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]byte{
+		[]byte("Line 1"),
+		[]byte("Line 2"),
+	}, lw.Lines())
+
+	_, err = lw.Write([]byte("Line 3\nLine 4\nLine 5\nLast line"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]byte{
+		[]byte("Line 3"),
+		[]byte("Line 4"),
+		[]byte("Line 5"),
+	}, lw.Lines())
+
+	lw.Finish()
+
+	assert.Equal(t, [][]byte{
+		[]byte("Line 4"),
+		[]byte("Line 5"),
+		[]byte("Last line"),
+	}, lw.Lines())
+}
@@ -0,0 +1,59 @@
+package taskctl
+
+import (
+	"io"
+
+	"github.com/apex/log"
+	"github.com/sirupsen/logrus"
+)
+
+// ForwardLogrus sends the entries of the standard logrus logger (used by the taskctl packages) to logger with the
+// component taskctl instead of writing them to stderr, so all log output has the same format and level.
+// Calling it again replaces the previous logger.
+func ForwardLogrus(logger log.Interface, level log.Level) {
+	std := logrus.StandardLogger()
+	std.SetOutput(io.Discard)
+	std.SetLevel(logrusLevel(level))
+	std.ReplaceHooks(logrus.LevelHooks{})
+	std.AddHook(&forwardHook{logger: logger.WithField("component", "taskctl")})
+}
+
+// logrusLevel returns the logrus level that logs the same entries as the apex level
+func logrusLevel(level log.Level) logrus.Level {
+	switch level {
+	case log.DebugLevel:
+		return logrus.DebugLevel
+	case log.WarnLevel:
+		return logrus.WarnLevel
+	case log.ErrorLevel:
+		return logrus.ErrorLevel
+	case log.FatalLevel:
+		return logrus.FatalLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
+
+type forwardHook struct {
+	logger log.Interface
+}
+
+func (h *forwardHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *forwardHook) Fire(entry *logrus.Entry) error {
+	logger := h.logger.WithFields(log.Fields(entry.Data))
+	switch entry.Level {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		logger.Debug(entry.Message)
+	case logrus.InfoLevel:
+		logger.Info(entry.Message)
+	case logrus.WarnLevel:
+		logger.Warn(entry.Message)
+	default:
+		// Fatal and panic are handled by logrus after the hooks (apex would exit on fatal)
+		logger.Error(entry.Message)
+	}
+	return nil
+}
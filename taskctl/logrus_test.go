@@ -0,0 +1,41 @@
+package taskctl
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardLogrus(t *testing.T) {
+	std := logrus.StandardLogger()
+	prevOut, prevLevel := std.Out, std.GetLevel()
+	t.Cleanup(func() {
+		std.SetOutput(prevOut)
+		std.SetLevel(prevLevel)
+		std.ReplaceHooks(logrus.LevelHooks{})
+	})
+
+	memHandler := memory.New()
+	logger := &log.Logger{
+		Handler: memHandler,
+		Level:   log.InfoLevel,
+	}
+	ForwardLogrus(logger, log.InfoLevel)
+	// Forwarding again must not duplicate entries
+	ForwardLogrus(logger, log.InfoLevel)
+
+	logrus.Debug("not forwarded")
+	logrus.WithField("task", "build").Infof("task %s was skipped", "build")
+	logrus.Warning("context cleanup failed")
+
+	require.Len(t, memHandler.Entries, 2)
+	assert.Equal(t, log.InfoLevel, memHandler.Entries[0].Level)
+	assert.Equal(t, "task build was skipped", memHandler.Entries[0].Message)
+	assert.Equal(t, "taskctl", memHandler.Entries[0].Fields["component"])
+	assert.Equal(t, "build", memHandler.Entries[0].Fields["task"])
+	assert.Equal(t, log.WarnLevel, memHandler.Entries[1].Level)
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/friendsofgo/errors"
 )
@@ -15,6 +17,17 @@ type OutputStore interface {
 	Remove(jobID string) error
 }
 
+// JobLister is implemented by output stores that can list the jobs with stored output
+type JobLister interface {
+	JobIDs() ([]string, error)
+}
+
+// JobOutputSizer is implemented by output stores that can report the size of the stored output of a job
+type JobOutputSizer interface {
+	// JobOutputSize returns the size of all outputs of the job in bytes (0 if the job has no output)
+	JobOutputSize(jobID string) (int64, error)
+}
+
 type FileOutputStore struct {
 	path string
 }
@@ -31,7 +44,7 @@ func NewOutputStore(path string) (*FileOutputStore, error) {
 }
 
 func (s *FileOutputStore) Writer(jobID string, taskName string, outputName string) (io.WriteCloser, error) {
-	err := os.MkdirAll(path.Join(s.path, jobID), 0777)
+	err := os.MkdirAll(filepath.Join(s.path, jobID), 0777)
 	if err != nil {
 		return nil, errors.Wrap(err, "creating job logs directory")
 	}
@@ -54,9 +67,63 @@ func (s *FileOutputStore) Reader(jobID string, taskName string, outputName strin
 }
 
 func (s *FileOutputStore) buildPath(jobID string, taskName string, outputName string) string {
-	return path.Join(s.path, jobID, fmt.Sprintf("%s-%s.log", taskName, outputName))
+	return filepath.Join(s.path, jobID, fmt.Sprintf("%s-%s.log", sanitizeFilename(taskName), outputName))
+}
+
+// sanitizeFilename replaces path separators and (on Windows) characters that are not allowed in filenames
+func sanitizeFilename(name string) string {
+	invalidChars := "/"
+	if runtime.GOOS == "windows" {
+		invalidChars = `<>:"/\|?*`
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(invalidChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
 }
 
 func (s *FileOutputStore) Remove(jobID string) error {
-	return os.RemoveAll(path.Join(s.path, jobID))
+	return os.RemoveAll(filepath.Join(s.path, jobID))
+}
+
+// JobIDs returns the ids of all jobs with a log directory
+func (s *FileOutputStore) JobIDs() ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading base directory")
+	}
+
+	var jobIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			jobIDs = append(jobIDs, entry.Name())
+		}
+	}
+	return jobIDs, nil
+}
+
+// JobOutputSize returns the size of all log files of the job
+func (s *FileOutputStore) JobOutputSize(jobID string) (int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.path, jobID))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "reading job logs directory")
+	}
+
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// The file was removed concurrently
+			continue
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
 }
@@ -0,0 +1,30 @@
+package taskctl
+
+// IOClass is the IO scheduling class of a process (see ioprio_set(2))
+type IOClass int
+
+const (
+	// IOClassDefault keeps the IO scheduling class of the prunner process
+	IOClassDefault IOClass = 0
+	// IOClassBestEffort is the best-effort IO scheduling class with a priority level (0-7, lower is higher priority)
+	IOClassBestEffort IOClass = 2
+	// IOClassIdle only gets disk time if no other process needs IO
+	IOClassIdle IOClass = 3
+)
+
+// ProcessPriority defines CPU and IO scheduling priorities for processes started by a task.
+//
+// The zero value keeps the priorities of the prunner process.
+type ProcessPriority struct {
+	// Niceness is the CPU niceness (-20 to 19) of started processes, 0 keeps the default
+	Niceness int
+	// IOClass is the IO scheduling class of started processes
+	IOClass IOClass
+	// IOLevel is the priority level inside the IO scheduling class (only used for IOClassBestEffort)
+	IOLevel int
+}
+
+// IsDefault returns true if the priority does not need to be applied to started processes
+func (p ProcessPriority) IsDefault() bool {
+	return p.Niceness == 0 && p.IOClass == IOClassDefault
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package taskctl
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// startWithPriority starts the command with the priority already applied, so no process of the task runs with the
+// default priority.
+//
+// Niceness and IO priority are attributes of a thread on Linux and are inherited by processes forked from it. They are
+// set on a locked OS thread that starts the command. The thread is never unlocked, so it terminates with the goroutine
+// and does not run other goroutines with the changed priority.
+func startWithPriority(cmd *exec.Cmd, priority ProcessPriority) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		err := applyThreadPriority(syscall.Gettid(), priority)
+		if err != nil {
+			errCh <- errors.Wrap(err, "applying process priority")
+			return
+		}
+		errCh <- cmd.Start()
+	}()
+	return <-errCh
+}
+
+// applyThreadPriority sets niceness and IO scheduling class of the thread with the given id.
+// Processes forked by this thread afterwards will inherit the priorities.
+func applyThreadPriority(tid int, priority ProcessPriority) error {
+	if priority.Niceness != 0 {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, priority.Niceness)
+		if err != nil {
+			return errors.Wrap(err, "setting niceness")
+		}
+	}
+
+	if priority.IOClass != IOClassDefault {
+		ioprio := int(priority.IOClass)<<ioprioClassShift | priority.IOLevel
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio))
+		if errno != 0 {
+			return errors.Wrap(errno, "setting IO priority")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build !linux && !windows
+
+package taskctl
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/friendsofgo/errors"
+)
+
+// startWithPriority starts the command with nice(1), so no process of the task runs with the default niceness.
+// The niceness is a process attribute on other platforms and can not be set for a single thread before forking.
+// IO scheduling classes are only supported on Linux and are ignored.
+func startWithPriority(cmd *exec.Cmd, priority ProcessPriority) error {
+	if priority.Niceness == 0 {
+		return cmd.Start()
+	}
+
+	current, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return errors.Wrap(err, "getting niceness")
+	}
+	// nice only warns if it is not allowed to lower the niceness and runs the command anyway
+	increment := priority.Niceness - current
+	if increment < 0 && os.Geteuid() != 0 {
+		return errors.Errorf("applying process priority: lowering niceness to %d requires root", priority.Niceness)
+	}
+	nicePath, err := exec.LookPath("nice")
+	if err != nil {
+		return errors.Wrap(err, "applying process priority")
+	}
+
+	cmd.Args = append([]string{nicePath, "-n", strconv.Itoa(increment), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = nicePath
+	return cmd.Start()
+}
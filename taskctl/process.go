@@ -0,0 +1,37 @@
+package taskctl
+
+// Process identifies a process started by a task, it is persisted to kill orphaned processes after a crash
+type Process struct {
+	// Pid of the started process, which is also the id of its session
+	Pid int
+	// StartTime is the OS specific start time of the process, used to detect re-use of the pid (0 if unknown)
+	StartTime uint64
+	// BootID identifies the system boot the process was started in (empty if unknown)
+	BootID string
+}
+
+// ProcessChange is passed to callbacks when a process of a task was started or has exited
+type ProcessChange struct {
+	// JobID and TaskName are set by the TaskRunner for the task that started the process
+	JobID    string
+	TaskName string
+
+	Process Process
+	Exited  bool
+	// Usage contains the resources used by the process (only set if exited)
+	Usage ResourceUsage
+}
+
+// KillOrphanedProcess kills all processes of the session of a process that was started by a previous prunner instance,
+// also if the process itself already exited and only its descendants survived.
+//
+// Processes are only killed if it can be verified that they belong to the session of the process and not to a session
+// of another process that re-used the pid (which is only supported on Linux). It returns true if a process was found and
+// killed.
+func KillOrphanedProcess(p Process) bool {
+	if p.StartTime == 0 || p.BootID == "" {
+		return false
+	}
+
+	return killOrphanedSession(p)
+}
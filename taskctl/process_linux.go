@@ -0,0 +1,55 @@
+//go:build linux
+
+package taskctl
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// newProcess returns a process with the start time and boot id (if available)
+func newProcess(pid int) Process {
+	p := Process{Pid: pid}
+
+	stat, ok := readProcStat(pid)
+	if !ok {
+		return p
+	}
+	p.StartTime = stat.startTime
+	p.BootID = bootID()
+
+	return p
+}
+
+var (
+	bootIDOnce  sync.Once
+	bootIDValue string
+)
+
+// bootID returns the id of the current system boot, it is read only once since it cannot change while running
+func bootID() string {
+	bootIDOnce.Do(func() {
+		content, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+		if err == nil {
+			bootIDValue = strings.TrimSpace(string(content))
+		}
+	})
+	return bootIDValue
+}
+
+// killOrphanedSession kills the processes of the session of p (the session id is the pid of p) that started no earlier
+// than p. The id of a session is not re-used while processes of the session are alive, so processes of the session are
+// descendants of p even if p already exited.
+func killOrphanedSession(p Process) bool {
+	if p.BootID != bootID() {
+		return false
+	}
+	if stat, ok := readProcStat(p.Pid); ok && stat.startTime != p.StartTime {
+		// The pid was re-used by another process, so the session of p has no processes left
+		return false
+	}
+
+	return signalSessionStartedSince(p.Pid, p.StartTime, syscall.SIGKILL)
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package taskctl
+
+import (
+	"bufio"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillOrphanedProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+	}()
+
+	p := newProcess(cmd.Process.Pid)
+	require.NotZero(t, p.StartTime)
+	require.NotEmpty(t, p.BootID)
+
+	reusedPid := p
+	reusedPid.StartTime++
+	assert.False(t, KillOrphanedProcess(reusedPid), "process with other start time should not be killed")
+
+	assert.True(t, KillOrphanedProcess(p), "process should be killed")
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, syscall.SIGKILL, exitErr.Sys().(syscall.WaitStatus).Signal())
+}
+
+func TestKillOrphanedProcess_LeaderExitedBeforeChild(t *testing.T) {
+	// The session leader starts a background child and exits when its stdin is closed
+	cmd := exec.Command("sh", "-c", "sleep 30 & echo $!; read _ || true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+
+	p := newProcess(cmd.Process.Pid)
+	require.NotZero(t, p.StartTime)
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	childPid, err := strconv.Atoi(strings.TrimSpace(line))
+	require.NoError(t, err)
+	defer func() {
+		_ = syscall.Kill(childPid, syscall.SIGKILL)
+	}()
+
+	require.NoError(t, stdin.Close())
+	require.NoError(t, cmd.Wait())
+	_, ok := readProcStat(cmd.Process.Pid)
+	require.False(t, ok, "session leader exited")
+
+	otherBoot := p
+	otherBoot.BootID = "other-boot"
+	assert.False(t, KillOrphanedProcess(otherBoot), "processes of another boot should not be killed")
+	require.True(t, isAlive(childPid))
+
+	assert.True(t, KillOrphanedProcess(p), "surviving child should be killed")
+	assert.Eventually(t, func() bool {
+		return !isAlive(childPid)
+	}, 2*time.Second, 50*time.Millisecond, "child should be killed")
+}
+
+func isAlive(pid int) bool {
+	stat, ok := readProcStat(pid)
+	return ok && stat.state != 'Z'
+}
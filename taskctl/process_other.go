@@ -0,0 +1,12 @@
+//go:build !linux
+
+package taskctl
+
+// newProcess returns a process without a start time, so orphaned processes cannot be verified and are not killed
+func newProcess(pid int) Process {
+	return Process{Pid: pid}
+}
+
+func killOrphanedSession(_ Process) bool {
+	return false
+}
@@ -18,13 +18,29 @@ import (
 
 const JobIDVariableName = "__jobID"
 
-// Runner extends runner.Runner (from taskctl) to implement additional features:
+// TaskEnvName is the environment variable with the name of the task, it is set for all tasks in addition to TASK_NAME
+const TaskEnvName = "PRUNNER_TASK"
+
+// Runner replaces runner.Runner (from taskctl) to implement additional features:
+// - a single runner executes the tasks of all jobs, a task is canceled with the context of its job
 // - storage of outputs
 // - callback on finished task (so we can f.e. schedule the next task when one is on the wait-queue)
 // The file https://github.com/taskctl/taskctl/blob/master/pkg/runner/runner.go is the original basis of this file.
 type Runner interface {
-	runner.Runner
+	// Run executes the task with the options of the job in the context (see WithJobOptions) until the context is done
+	Run(ctx context.Context, t *task.Task) error
+	// Finish cleans up after all tasks were executed
+	Finish()
 	SetOnTaskChange(func(t *task.Task))
+	SetOnProcessChange(func(c ProcessChange))
+	SetOnCommandChange(func(c CommandChange))
+}
+
+// LiveOutput is implemented by runners that keep the last lines of the output of running tasks in memory
+type LiveOutput interface {
+	// CurrentTaskOutput returns the last lines of an output (stdout or stderr) of a running task, ok is false if the
+	// task is not running
+	CurrentTaskOutput(jobID, taskName, outputName string) (lines []string, ok bool)
 }
 
 // TaskRunner run tasks
@@ -33,12 +49,6 @@ type TaskRunner struct {
 	variables variables.Container
 	env       variables.Container
 
-	ctx         context.Context
-	cancelFunc  context.CancelFunc
-	cancelMutex sync.RWMutex
-	canceling   bool
-	wg          sync.WaitGroup
-
 	compiler *runner.TaskCompiler
 
 	Stdin          io.Reader
@@ -50,11 +60,31 @@ type TaskRunner struct {
 	// Additional output store to store task outputs
 	outputStore OutputStore
 
-	onTaskChange func(t *task.Task)
+	onTaskChange    func(t *task.Task)
+	onProcessChange func(c ProcessChange)
+	onCommandChange func(c CommandChange)
 
 	killTimeout time.Duration
+
+	workerPool *WorkerPool
+
+	// liveOutputLines is the number of lines kept of each output of a running task, 0 disables the live output
+	liveOutputLines int
+	// liveOutputs contains a *LineWriter for each output of running tasks by liveOutputKey
+	liveOutputs sync.Map
+
+	// taskInput connects the stdin of interactive tasks to pipes instead of Stdin (see WithTaskInput)
+	taskInput bool
+	// inputs contains the write end (*os.File) of the stdin pipe of running interactive tasks by taskKey
+	inputs sync.Map
+	// outputs contains the *outputBroadcast of running tasks by taskKey
+	outputs sync.Map
 }
 
+var _ LiveOutput = &TaskRunner{}
+var _ TaskInput = &TaskRunner{}
+var _ OutputStream = &TaskRunner{}
+
 // NewTaskRunner creates new TaskRunner instance
 func NewTaskRunner(outputStore OutputStore, opts ...Opts) (*TaskRunner, error) {
 	r := &TaskRunner{
@@ -71,8 +101,6 @@ func NewTaskRunner(outputStore OutputStore, opts ...Opts) (*TaskRunner, error) {
 		killTimeout: 2 * time.Second,
 	}
 
-	r.ctx, r.cancelFunc = context.WithCancel(context.Background())
-
 	for _, o := range opts {
 		o(r)
 	}
@@ -88,6 +116,18 @@ func (r *TaskRunner) SetOnTaskChange(f func(t *task.Task)) {
 	r.onTaskChange = f
 }
 
+// SetOnProcessChange sets a callback that is called when a process of a task was started or has exited.
+// It is called concurrently for tasks that run in parallel.
+func (r *TaskRunner) SetOnProcessChange(f func(c ProcessChange)) {
+	r.onProcessChange = f
+}
+
+// SetOnCommandChange sets a callback that is called when a command of the script of a task was started or has
+// finished. It is called concurrently for tasks that run in parallel.
+func (r *TaskRunner) SetOnCommandChange(f func(c CommandChange)) {
+	r.onCommandChange = f
+}
+
 // SetContexts sets task runner's contexts
 func (r *TaskRunner) SetContexts(contexts map[string]*runner.ExecutionContext) *TaskRunner {
 	r.contexts = contexts
@@ -104,23 +144,30 @@ func (r *TaskRunner) SetVariables(vars variables.Container) *TaskRunner {
 
 // Run run provided task -> highly modified from taskctl/runner/runner.go
 // TaskRunner first compiles task into linked list of Jobs, then passes those jobs to Executor
-func (r *TaskRunner) Run(t *task.Task) error {
-	// Keep track of running tasks for graceful shutdown and waiting until all tasks are canceled
-	r.wg.Add(1)
-	defer r.wg.Done()
-
-	if err := r.ctx.Err(); err != nil {
+func (r *TaskRunner) Run(ctx context.Context, t *task.Task) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	jobOpts := JobOptionsFromContext(ctx)
 
 	execContext, err := r.contextForTask(t)
 	if err != nil {
 		return err
 	}
 
+	jobID := t.Variables.Get(JobIDVariableName).(string)
+
 	var stdin io.Reader
 	if t.Interactive {
 		stdin = r.Stdin
+		if r.taskInput {
+			input, closeInput, err := r.openTaskInput(jobID, t.Name)
+			if err != nil {
+				return err
+			}
+			defer closeInput()
+			stdin = input
+		}
 	}
 
 	defer func() {
@@ -136,13 +183,16 @@ func (r *TaskRunner) Run(t *task.Task) error {
 
 	vars := r.variables.Merge(t.Variables)
 
-	env := r.env.Merge(execContext.Env)
+	env := r.env.Merge(variables.FromMap(jobOpts.Env))
+	env = env.Merge(execContext.Env)
+	project := jobOpts.Contexts[t.Name]
+	if project != nil {
+		env = env.Merge(variables.FromMap(project.Env()))
+	}
 	env = env.With("TASK_NAME", t.Name)
-	env = env.Merge(t.Env)
-
-	jobID := t.Variables.Get(JobIDVariableName).(string)
+	env = env.With(TaskEnvName, t.Name)
 
-	meets, err := r.checkTaskCondition(t)
+	meets, err := r.checkTaskCondition(ctx, jobOpts, t)
 	if err != nil {
 		return err
 	}
@@ -153,10 +203,31 @@ func (r *TaskRunner) Run(t *task.Task) error {
 			WithField("jobID", jobID).
 			Infof("Task %s was skipped", t.Name)
 		t.Skipped = true
+		r.notifyTaskChange(t)
 		return nil
 	}
 
-	err = r.before(r.ctx, t, env, vars)
+	// Templates in env values are rendered on every start, so a missing variable fails the task before running a command
+	if t.Env != nil {
+		taskEnv, err := RenderEnv(t.Env.Map(), jobOpts.EnvTemplateData)
+		if err != nil {
+			t.Errored = true
+			t.Error = fmt.Errorf("rendering env: %w", err)
+			r.notifyTaskChange(t)
+			return t.Error
+		}
+		env = env.Merge(variables.FromMap(taskEnv))
+	}
+
+	if r.workerPool != nil {
+		err = r.workerPool.Acquire(ctx, jobOpts.WorkerWeight)
+		if err != nil {
+			return err
+		}
+		defer r.workerPool.Release(jobOpts.WorkerWeight)
+	}
+
+	err = r.before(ctx, jobOpts, t, env, vars)
 	if err != nil {
 		return err
 	}
@@ -170,7 +241,36 @@ func (r *TaskRunner) Run(t *task.Task) error {
 		// into a Buffer, but directly to a file.
 		stdoutWriter []io.Writer
 		stderrWriter []io.Writer
+
+		activity = newOutputActivity()
+		counters = &outputCounters{}
 	)
+	stdoutWriter = append(stdoutWriter, activity, &counters.stdout)
+	stderrWriter = append(stderrWriter, activity, &counters.stderr)
+
+	broadcast := newOutputBroadcast()
+	r.outputs.Store(taskKey(jobID, t.Name), broadcast)
+	defer func() {
+		r.outputs.Delete(taskKey(jobID, t.Name))
+		broadcast.close()
+	}()
+	stdoutWriter = append(stdoutWriter, broadcast.writer("stdout"))
+	stderrWriter = append(stderrWriter, broadcast.writer("stderr"))
+
+	if r.liveOutputLines > 0 {
+		stdoutLive := NewLineWriter(r.liveOutputLines)
+		stderrLive := NewLineWriter(r.liveOutputLines)
+		stdoutKey := liveOutputKey(jobID, t.Name, "stdout")
+		stderrKey := liveOutputKey(jobID, t.Name, "stderr")
+		r.liveOutputs.Store(stdoutKey, stdoutLive)
+		r.liveOutputs.Store(stderrKey, stderrLive)
+		defer func() {
+			r.liveOutputs.Delete(stdoutKey)
+			r.liveOutputs.Delete(stderrKey)
+		}()
+		stdoutWriter = append(stdoutWriter, stdoutLive)
+		stderrWriter = append(stderrWriter, stderrLive)
+	}
 	if r.outputStore != nil {
 		{
 			stdoutStorer, err := r.outputStore.Writer(jobID, t.Name, "stdout")
@@ -195,6 +295,16 @@ func (r *TaskRunner) Run(t *task.Task) error {
 		}
 	}
 
+	// The output of starting the services is part of the output of the first task using the context
+	if project != nil {
+		if err := project.up(ctx, io.MultiWriter(stdoutWriter...), io.MultiWriter(stderrWriter...)); err != nil {
+			t.Errored = true
+			t.Error = fmt.Errorf("starting context: %w", err)
+			r.notifyTaskChange(t)
+			return t.Error
+		}
+	}
+
 	job, err := r.compiler.CompileTask(
 		t,
 		execContext,
@@ -209,7 +319,7 @@ func (r *TaskRunner) Run(t *task.Task) error {
 	}
 
 	if job != nil {
-		err = r.execute(r.ctx, t, job)
+		err = r.execute(ctx, jobOpts, t, job, activity, counters)
 		if err != nil {
 			return err
 		}
@@ -237,19 +347,26 @@ func (r *TaskRunner) Run(t *task.Task) error {
 
 	}
 
-	return r.after(r.ctx, t, env, vars)
+	return r.after(ctx, jobOpts, t, env, vars)
 }
 
-// Cancel cancels execution
-func (r *TaskRunner) Cancel() {
-	r.cancelMutex.Lock()
-	if !r.canceling {
-		r.canceling = true
-		r.cancelFunc()
+// CurrentTaskOutput returns the last lines of an output (stdout or stderr) of a running task, the number of lines is set
+// with WithLiveOutputLines. The full output is only written to the output store.
+func (r *TaskRunner) CurrentTaskOutput(jobID, taskName, outputName string) ([]string, bool) {
+	lw, ok := r.liveOutputs.Load(liveOutputKey(jobID, taskName, outputName))
+	if !ok {
+		return nil, false
 	}
-	r.cancelMutex.Unlock()
-	// Wait until all running tasks are canceled and return
-	r.wg.Wait()
+	lines := lw.(*LineWriter).Lines()
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = string(line)
+	}
+	return result, true
+}
+
+func liveOutputKey(jobID, taskName, outputName string) string {
+	return taskKey(jobID, taskName) + "/" + outputName
 }
 
 // Finish makes cleanup tasks over contexts
@@ -269,7 +386,7 @@ func (r *TaskRunner) WithVariable(key, value string) *TaskRunner {
 	return r
 }
 
-func (r *TaskRunner) before(ctx context.Context, t *task.Task, env, vars variables.Container) error {
+func (r *TaskRunner) before(ctx context.Context, jobOpts JobOptions, t *task.Task, env, vars variables.Container) error {
 	if len(t.Before) == 0 {
 		return nil
 	}
@@ -285,7 +402,7 @@ func (r *TaskRunner) before(ctx context.Context, t *task.Task, env, vars variabl
 			return fmt.Errorf("\"before\" command compilation failed: %w", err)
 		}
 
-		exec, err := NewPgidExecutor(job.Stdin, job.Stdout, job.Stderr, r.killTimeout)
+		exec, err := r.newExecutor(jobOpts, t, job)
 		if err != nil {
 			return err
 		}
@@ -299,7 +416,7 @@ func (r *TaskRunner) before(ctx context.Context, t *task.Task, env, vars variabl
 	return nil
 }
 
-func (r *TaskRunner) after(ctx context.Context, t *task.Task, env, vars variables.Container) error {
+func (r *TaskRunner) after(ctx context.Context, jobOpts JobOptions, t *task.Task, env, vars variables.Container) error {
 	if len(t.After) == 0 {
 		return nil
 	}
@@ -315,7 +432,7 @@ func (r *TaskRunner) after(ctx context.Context, t *task.Task, env, vars variable
 			return fmt.Errorf("\"after\" command compilation failed: %w", err)
 		}
 
-		exec, err := NewPgidExecutor(job.Stdin, job.Stdout, job.Stderr, r.killTimeout)
+		exec, err := r.newExecutor(jobOpts, t, job)
 		if err != nil {
 			return err
 		}
@@ -358,7 +475,17 @@ func (r *TaskRunner) contextForTask(t *task.Task) (c *runner.ExecutionContext, e
 	return c, nil
 }
 
-func (r *TaskRunner) checkTaskCondition(t *task.Task) (bool, error) {
+func (r *TaskRunner) checkTaskCondition(ctx context.Context, jobOpts JobOptions, t *task.Task) (bool, error) {
+	// A condition over variables is evaluated without spawning a shell
+	if expr, ok := jobOpts.Conditions[t.Name]; ok && !expr.Evaluate(t.Variables.Map()) {
+		return false, nil
+	}
+	if changedPaths, ok := jobOpts.ChangedPaths[t.Name]; ok {
+		changed, err := changedPaths.changed(ctx, t.Dir, r.variables.Merge(t.Variables).Map())
+		if err != nil || !changed {
+			return false, err
+		}
+	}
 	if t.Condition == "" {
 		return true, nil
 	}
@@ -373,12 +500,12 @@ func (r *TaskRunner) checkTaskCondition(t *task.Task) (bool, error) {
 		return false, err
 	}
 
-	exec, err := NewPgidExecutor(job.Stdin, job.Stdout, job.Stderr, r.killTimeout)
+	exec, err := r.newExecutor(jobOpts, t, job)
 	if err != nil {
 		return false, err
 	}
 
-	_, err = exec.Execute(context.Background(), job)
+	_, err = exec.Execute(ctx, job)
 	if err != nil {
 		if _, ok := executor.IsExitStatus(err); ok {
 			return false, nil
@@ -390,8 +517,8 @@ func (r *TaskRunner) checkTaskCondition(t *task.Task) (bool, error) {
 	return true, nil
 }
 
-func (r *TaskRunner) execute(ctx context.Context, t *task.Task, job *executor.Job) error {
-	exec, err := NewPgidExecutor(job.Stdin, job.Stdout, job.Stderr, r.killTimeout)
+func (r *TaskRunner) execute(ctx context.Context, jobOpts JobOptions, t *task.Task, job *executor.Job, activity *outputActivity, counters *outputCounters) error {
+	exec, err := r.newExecutor(jobOpts, t, job)
 	if err != nil {
 		return err
 	}
@@ -399,15 +526,79 @@ func (r *TaskRunner) execute(ctx context.Context, t *task.Task, job *executor.Jo
 	t.Start = time.Now()
 	r.notifyTaskChange(t)
 
-	for nextJob := job; nextJob != nil; nextJob = nextJob.Next {
-		var err error
+	retries := jobOpts.Retries[t.Name]
+	noOutput, watched := jobOpts.NoOutput[t.Name]
+	for attempt := 0; ; attempt++ {
+		if watched && noOutput.Timeout > 0 {
+			logger := log.
+				WithField("component", "runner").
+				WithField("jobID", job.Vars.Get(JobIDVariableName)).
+				WithField("task", t.Name)
+			attemptCtx, stop := watchNoOutput(ctx, noOutput, activity, logger)
+			err = r.executeJobs(attemptCtx, t, exec, job, counters)
+			if stuckErr := stop(); stuckErr != nil {
+				err = stuckErr
+			}
+		} else {
+			err = r.executeJobs(ctx, t, exec, job, counters)
+		}
+		if err == nil || attempt >= retries || ctx.Err() != nil {
+			break
+		}
+
+		// The task is not marked as errored before the last attempt, since this would cancel other tasks of the job
+		log.
+			WithField("component", "runner").
+			WithField("jobID", job.Vars.Get(JobIDVariableName)).
+			WithError(err).
+			Infof("Task %s failed, retrying (%d/%d)", t.Name, attempt+1, retries)
+	}
+	if err != nil {
+		t.Errored = true
+		t.Error = err
+		r.notifyTaskChange(t)
+		return t.Error
+	}
+
+	t.End = time.Now()
+	r.notifyTaskChange(t)
+
+	return nil
+}
+
+func (r *TaskRunner) executeJobs(ctx context.Context, t *task.Task, exec *PgidExecutor, job *executor.Job, counters *outputCounters) error {
+	jobID, _ := job.Vars.Get(JobIDVariableName).(string)
+	for i, nextJob := 0, job; nextJob != nil; i, nextJob = i+1, nextJob.Next {
+		c := CommandChange{
+			JobID:        jobID,
+			TaskName:     t.Name,
+			Index:        i,
+			Command:      nextJob.Command,
+			Start:        time.Now(),
+			StdoutOffset: counters.stdout.count(),
+			StderrOffset: counters.stderr.count(),
+		}
+		r.notifyCommandChange(c)
+
 		// NOTE: in the original taskctl code, there was a line nextJob.Vars.Set("Output", string(prevOutput))
 		// here, which made {{.Output}} available.
 		// prevOutput was the result of the previous exec.Execute call; but we disabled that feature completely.
 		//
 		// We disable this for memory reasons; as otherwise we had huge memory leaks in prunner because all content
 		// was stored in RAM.
-		_, err = exec.Execute(ctx, nextJob)
+		_, err := exec.Execute(ctx, nextJob)
+
+		c.End = time.Now()
+		c.StdoutLength = counters.stdout.count() - c.StdoutOffset
+		c.StderrLength = counters.stderr.count() - c.StderrOffset
+		if err != nil {
+			c.Error = err
+			if status, ok := executor.IsExitStatus(err); ok {
+				c.ExitCode = int16(status)
+			}
+		}
+		r.notifyCommandChange(c)
+
 		if err != nil {
 			if status, ok := executor.IsExitStatus(err); ok {
 				t.ExitCode = int16(status)
@@ -416,18 +607,35 @@ func (r *TaskRunner) execute(ctx context.Context, t *task.Task, job *executor.Jo
 					continue
 				}
 			}
-			t.Errored = true
-			t.Error = err
-			r.notifyTaskChange(t)
-			return t.Error
+			return err
 		}
 	}
-	t.End = time.Now()
-	r.notifyTaskChange(t)
-
 	return nil
 }
 
+func (r *TaskRunner) newExecutor(jobOpts JobOptions, t *task.Task, job *executor.Job) (*PgidExecutor, error) {
+	opts := []ExecutorOpts{WithPriority(jobOpts.ProcessPriority)}
+	if interpreter, ok := jobOpts.Interpreters[t.Name]; ok {
+		opts = append(opts, WithInterpreter(interpreter))
+	}
+	if r.onProcessChange != nil {
+		jobID, _ := job.Vars.Get(JobIDVariableName).(string)
+		opts = append(opts, WithOnProcessChange(func(c ProcessChange) {
+			c.JobID = jobID
+			c.TaskName = t.Name
+			r.onProcessChange(c)
+		}))
+	}
+
+	return NewPgidExecutor(job.Stdin, job.Stdout, job.Stderr, r.killTimeout, opts...)
+}
+
+func (r *TaskRunner) notifyCommandChange(c CommandChange) {
+	if r.onCommandChange != nil {
+		r.onCommandChange(c)
+	}
+}
+
 func (r *TaskRunner) notifyTaskChange(t *task.Task) {
 	if r.onTaskChange != nil {
 		r.onTaskChange(t)
@@ -466,3 +674,25 @@ func WithKillTimeout(killTimeout time.Duration) Opts {
 		runner.killTimeout = killTimeout
 	}
 }
+
+// WithWorkerPool acquires workers from the pool for executing each task, the weight is set by the job options
+func WithWorkerPool(pool *WorkerPool) Opts {
+	return func(runner *TaskRunner) {
+		runner.workerPool = pool
+	}
+}
+
+// WithLiveOutputLines keeps the last lines of the outputs of running tasks in memory (see TaskRunner.CurrentTaskOutput)
+func WithLiveOutputLines(lines int) Opts {
+	return func(runner *TaskRunner) {
+		runner.liveOutputLines = lines
+	}
+}
+
+// WithTaskInput connects the stdin of each interactive task to its own pipe instead of Stdin, data is sent to a running
+// task with TaskRunner.WriteTaskInput
+func WithTaskInput() Opts {
+	return func(runner *TaskRunner) {
+		runner.taskInput = true
+	}
+}
@@ -1,8 +1,13 @@
 package taskctl
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -70,7 +75,7 @@ func TestTaskRunner(t *testing.T) {
 	}
 
 	for _, testCase := range cases {
-		err = runnr.Run(testCase.t)
+		err = runnr.Run(context.Background(), testCase.t)
 		if err != nil && !testCase.errored && !testCase.skipped {
 			t.Fatal(err)
 		}
@@ -100,13 +105,308 @@ func TestTaskRunner(t *testing.T) {
 	runnr.Finish()
 }
 
+func TestTaskRunner_WithRetries(t *testing.T) {
+	dir := t.TempDir()
+
+	runnr, err := NewTaskRunner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnr.Stdout, runnr.Stderr = ioutil.Discard, ioutil.Discard
+	ctx := WithJobOptions(context.Background(), JobOptions{Retries: map[string]int{"flaky": 1}})
+
+	// The task fails on the first attempt and succeeds on the retry
+	flakyCommand := "test -f attempted || { touch attempted; exit 1; }"
+
+	task1 := task.FromCommands(flakyCommand)
+	task1.Name = "flaky"
+	task1.Dir = dir
+
+	err = runnr.Run(ctx, task1)
+	if err != nil {
+		t.Fatalf("expected task to succeed after retry, got %v", err)
+	}
+	if task1.Errored {
+		t.Error("expected task not to be errored")
+	}
+
+	task2 := task.FromCommands(flakyCommand)
+	task2.Name = "not_retried"
+	task2.Dir = t.TempDir()
+
+	err = runnr.Run(ctx, task2)
+	if err == nil {
+		t.Error("expected task without retries to fail")
+	}
+	if !task2.Errored {
+		t.Error("expected task to be errored")
+	}
+}
+
+func TestTaskRunner_WithNoOutputTimeout(t *testing.T) {
+	runnr, err := NewTaskRunner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnr.Stdout, runnr.Stderr = ioutil.Discard, ioutil.Discard
+	runnr.killTimeout = 100 * time.Millisecond
+	ctx := WithJobOptions(context.Background(), JobOptions{NoOutput: map[string]NoOutputWatch{
+		"stuck":   {Timeout: 200 * time.Millisecond, Kill: true},
+		"talking": {Timeout: 200 * time.Millisecond, Kill: true},
+		"slow":    {Timeout: 100 * time.Millisecond},
+	}})
+
+	task1 := task.FromCommands("sleep 10")
+	task1.Name = "stuck"
+
+	start := time.Now()
+	err = runnr.Run(ctx, task1)
+	if !errors.Is(err, ErrNoOutput) {
+		t.Fatalf("expected stuck task to be killed with ErrNoOutput, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected stuck task to be killed after the no output timeout, took %s", time.Since(start))
+	}
+	if !task1.Errored {
+		t.Error("expected stuck task to be errored")
+	}
+
+	// The task runs longer than the timeout but keeps writing output
+	task2 := task.FromCommands("for i in 1 2 3 4 5 6; do echo $i; sleep 0.1; done")
+	task2.Name = "talking"
+
+	err = runnr.Run(ctx, task2)
+	if err != nil {
+		t.Fatalf("expected task with output to succeed, got %v", err)
+	}
+
+	// A stuck task is only logged with the warn action
+	task3 := task.FromCommands("sleep 0.4")
+	task3.Name = "slow"
+
+	err = runnr.Run(ctx, task3)
+	if err != nil {
+		t.Fatalf("expected task with warn action to succeed, got %v", err)
+	}
+}
+
+func TestTaskRunner_CurrentTaskOutput(t *testing.T) {
+	runnr, err := NewTaskRunner(nil, WithLiveOutputLines(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnr.Stdout, runnr.Stderr = ioutil.Discard, ioutil.Discard
+
+	task1 := task.FromCommands("echo 1; echo 2; echo 3; echo error >&2; sleep 0.5")
+	task1.Name = "chatty"
+	task1.Variables.Set(JobIDVariableName, "job1")
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- runnr.Run(context.Background(), task1)
+	}()
+
+	var stdout []string
+	for i := 0; i < 40; i++ {
+		var ok bool
+		stdout, ok = runnr.CurrentTaskOutput("job1", "chatty", "stdout")
+		if ok && len(stdout) == 2 && stdout[1] == "3" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stdout) != 2 || stdout[0] != "2" || stdout[1] != "3" {
+		t.Errorf("expected last 2 lines of stdout, got %v", stdout)
+	}
+	stderr, _ := runnr.CurrentTaskOutput("job1", "chatty", "stderr")
+	if len(stderr) != 1 || stderr[0] != "error" {
+		t.Errorf("expected stderr line, got %v", stderr)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := runnr.CurrentTaskOutput("job1", "chatty", "stdout"); ok {
+		t.Error("expected no live output after the task finished")
+	}
+}
+
+func TestTaskRunner_SetOnCommandChange(t *testing.T) {
+	runnr, err := NewTaskRunner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnr.Stdout, runnr.Stderr = ioutil.Discard, ioutil.Discard
+
+	var finished []CommandChange
+	runnr.SetOnCommandChange(func(c CommandChange) {
+		if c.Finished() {
+			finished = append(finished, c)
+		}
+	})
+
+	task1 := task.FromCommands("echo one", "echo two; echo oops >&2", "exit 3", "echo never")
+	task1.Name = "multi"
+	task1.Variables.Set(JobIDVariableName, "job1")
+
+	err = runnr.Run(context.Background(), task1)
+	if err == nil {
+		t.Fatal("expected task to fail")
+	}
+
+	if len(finished) != 3 {
+		t.Fatalf("expected 3 finished commands, got %d", len(finished))
+	}
+	first, second, third := finished[0], finished[1], finished[2]
+	if first.Index != 0 || first.Command != "echo one" || first.Error != nil || first.StdoutOffset != 0 || first.StdoutLength != 4 {
+		t.Errorf("unexpected first command: %+v", first)
+	}
+	if second.Index != 1 || second.StdoutOffset != 4 || second.StdoutLength != 4 || second.StderrOffset != 0 || second.StderrLength != 5 {
+		t.Errorf("unexpected second command: %+v", second)
+	}
+	if third.Index != 2 || third.Command != "exit 3" || third.ExitCode != 3 || third.Error == nil || third.StdoutOffset != 8 {
+		t.Errorf("unexpected third command: %+v", third)
+	}
+	if third.JobID != "job1" || third.TaskName != "multi" {
+		t.Errorf("expected job id and task name to be set, got %q and %q", third.JobID, third.TaskName)
+	}
+}
+
+func TestTaskRunner_WithChangedPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	writeFile := func(name string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	writeFile("services/api/main.go")
+	writeFile("services/web/index.js")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	writeFile("services/api/handler/user.go")
+	git("add", ".")
+	git("commit", "-q", "-m", "change api")
+
+	runnr, err := NewTaskRunner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnr.Stdout, runnr.Stderr = ioutil.Discard, ioutil.Discard
+	ctx := WithJobOptions(context.Background(), JobOptions{ChangedPaths: map[string]ChangedPaths{
+		"api": {Paths: []string{"services/api/**"}},
+		"web": {Paths: []string{"services/web/**", "*.md"}},
+		"all": {Base: "{{ .base }}", Paths: []string{"services/web/**"}},
+	}})
+
+	for name, skipped := range map[string]bool{"api": false, "web": true} {
+		tsk := task.FromCommands("true")
+		tsk.Name = name
+		tsk.Dir = dir
+		tsk.Variables.Set(JobIDVariableName, "job1")
+		if err := runnr.Run(ctx, tsk); err != nil {
+			t.Fatal(err)
+		}
+		if tsk.Skipped != skipped {
+			t.Errorf("expected task %s skipped to be %v", name, skipped)
+		}
+	}
+
+	// The base is rendered with the variables, the empty tree contains no files so everything changed
+	tsk := task.FromCommands("true")
+	tsk.Name = "all"
+	tsk.Dir = dir
+	tsk.Variables.Set(JobIDVariableName, "job1")
+	tsk.Variables.Set("base", "4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+	if err := runnr.Run(ctx, tsk); err != nil {
+		t.Fatal(err)
+	}
+	if tsk.Skipped {
+		t.Error("expected task compared with the empty tree not to be skipped")
+	}
+}
+
+func TestTaskRunner_WithTaskInput(t *testing.T) {
+	runnr, err := NewTaskRunner(nil, WithTaskInput(), WithLiveOutputLines(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnr.Stdout, runnr.Stderr = ioutil.Discard, ioutil.Discard
+
+	if err := runnr.WriteTaskInput("job1", "confirm", []byte("yes\n")); !errors.Is(err, ErrNoTaskInput) {
+		t.Fatalf("expected ErrNoTaskInput for a task that is not running, got %v", err)
+	}
+
+	// The built-in read and an external process (cat) read from the stdin pipe
+	task1 := task.FromCommands("read answer; echo got $answer; cat")
+	task1.Name = "confirm"
+	task1.Interactive = true
+	task1.Variables.Set(JobIDVariableName, "job1")
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- runnr.Run(context.Background(), task1)
+	}()
+
+	for i := 0; i < 100; i++ {
+		err = runnr.WriteTaskInput("job1", "confirm", []byte("yes\nmore input\n"))
+		if !errors.Is(err, ErrNoTaskInput) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("expected input to be written, got %v", err)
+	}
+
+	var stdout []string
+	for i := 0; i < 100; i++ {
+		stdout, _ = runnr.CurrentTaskOutput("job1", "confirm", "stdout")
+		if len(stdout) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stdout) != 2 || stdout[0] != "got yes" || stdout[1] != "more input" {
+		t.Errorf("expected output of input, got %v", stdout)
+	}
+
+	// Closing stdin ends cat
+	if err := runnr.CloseTaskInput("job1", "confirm"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected task to finish after stdin was closed")
+	}
+}
+
 func ExampleTaskRunner_Run() {
 	t := task.FromCommands("go fmt ./...", "go build ./..")
 	r, err := NewTaskRunner(nil)
 	if err != nil {
 		return
 	}
-	err = r.Run(t)
+	err = r.Run(context.Background(), t)
 	if err != nil {
 		fmt.Println(err, t.ExitCode, t.ErrorMessage())
 	}
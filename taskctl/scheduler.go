@@ -1,29 +1,26 @@
 package taskctl
 
 import (
+	"context"
 	"os/exec"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/apex/log"
-	"github.com/taskctl/taskctl/pkg/runner"
 	"github.com/taskctl/taskctl/pkg/scheduler"
 	"github.com/taskctl/taskctl/pkg/utils"
 )
 
-// Scheduler executes ExecutionGraph
+// Scheduler executes ExecutionGraph, a single scheduler can execute the graphs of multiple jobs concurrently
 type Scheduler struct {
-	taskRunner runner.Runner
+	taskRunner Runner
 	pause      time.Duration
 
-	cancelled int32
-
 	onStageChange func(stage *scheduler.Stage)
 }
 
 // NewScheduler create new Scheduler instance
-func NewScheduler(r runner.Runner) *Scheduler {
+func NewScheduler(r Runner) *Scheduler {
 	s := &Scheduler{
 		pause:      50 * time.Millisecond,
 		taskRunner: r,
@@ -36,10 +33,17 @@ func (s *Scheduler) OnStageChange(f func(stage *scheduler.Stage)) {
 	s.onStageChange = f
 }
 
-// Schedule starts execution of the given ExecutionGraph
+// Schedule starts execution of the given ExecutionGraph and waits until it is finished. Running tasks are canceled and
+// no more tasks are started when the context is done.
 //
-// Modified to notify on stage changes
-func (s *Scheduler) Schedule(g *scheduler.ExecutionGraph) error {
+// Modified to notify on stage changes and for cancellation with a context
+func (s *Scheduler) Schedule(ctx context.Context, g *scheduler.ExecutionGraph) error {
+	// A failed stage condition cancels only the tasks of this graph. Conditions are checked with the context of the job,
+	// so conditions of other stages checked in the same iteration do not fail because of the cancellation.
+	jobCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	var wg sync.WaitGroup
 
 	var (
@@ -48,7 +52,7 @@ func (s *Scheduler) Schedule(g *scheduler.ExecutionGraph) error {
 	)
 
 	for !s.isDone(g) {
-		if atomic.LoadInt32(&s.cancelled) == 1 {
+		if ctx.Err() != nil {
 			break
 		}
 
@@ -59,14 +63,14 @@ func (s *Scheduler) Schedule(g *scheduler.ExecutionGraph) error {
 			}
 
 			if stage.Condition != "" {
-				meets, err := checkStageCondition(stage.Condition)
+				meets, err := checkStageCondition(jobCtx, stage.Condition)
 				if err != nil {
 					log.
 						WithField("component", "runner").
 						Errorf("Failed to check stage condition: %v", err)
 					stage.UpdateStatus(scheduler.StatusError)
 					s.notifyStageChange(stage)
-					s.Cancel()
+					cancel()
 					continue
 				}
 
@@ -92,7 +96,7 @@ func (s *Scheduler) Schedule(g *scheduler.ExecutionGraph) error {
 
 				stage.Start = time.Now()
 
-				err := s.runStage(stage)
+				err := s.runStage(ctx, stage)
 				if err != nil {
 					stage.UpdateStatus(scheduler.StatusError)
 					s.notifyStageChange(stage)
@@ -118,12 +122,6 @@ func (s *Scheduler) Schedule(g *scheduler.ExecutionGraph) error {
 	return lastErr
 }
 
-// Cancel cancels executing tasks
-func (s *Scheduler) Cancel() {
-	atomic.StoreInt32(&s.cancelled, 1)
-	s.taskRunner.Cancel()
-}
-
 // Finish finishes scheduler's TaskRunner
 func (s *Scheduler) Finish() {
 	s.taskRunner.Finish()
@@ -140,9 +138,9 @@ func (s *Scheduler) isDone(p *scheduler.ExecutionGraph) bool {
 	return true
 }
 
-func (s *Scheduler) runStage(stage *scheduler.Stage) error {
+func (s *Scheduler) runStage(ctx context.Context, stage *scheduler.Stage) error {
 	if stage.Pipeline != nil {
-		return s.Schedule(stage.Pipeline)
+		return s.Schedule(ctx, stage.Pipeline)
 	}
 
 	t := stage.Task
@@ -162,7 +160,7 @@ func (s *Scheduler) runStage(stage *scheduler.Stage) error {
 		}
 	}
 
-	return s.taskRunner.Run(stage.Task)
+	return s.taskRunner.Run(ctx, stage.Task)
 }
 
 func (s *Scheduler) notifyStageChange(stage *scheduler.Stage) {
@@ -199,8 +197,8 @@ func checkStatus(p *scheduler.ExecutionGraph, stage *scheduler.Stage) (ready boo
 	return ready
 }
 
-func checkStageCondition(condition string) (bool, error) {
-	cmd := exec.Command(condition)
+func checkStageCondition(ctx context.Context, condition string) (bool, error) {
+	cmd := exec.CommandContext(ctx, condition)
 	err := cmd.Run()
 	if err != nil {
 		if utils.IsExitError(err) {
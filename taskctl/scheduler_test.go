@@ -1,12 +1,12 @@
 package taskctl
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/taskctl/taskctl/pkg/runner"
 	"github.com/taskctl/taskctl/pkg/scheduler"
 	"github.com/taskctl/taskctl/pkg/task"
 	"github.com/taskctl/taskctl/pkg/variables"
@@ -17,7 +17,11 @@ import (
 type mockTaskRunner struct {
 }
 
-func (t2 mockTaskRunner) Run(t *task.Task) error {
+func (t2 mockTaskRunner) Run(ctx context.Context, t *task.Task) error {
+	if t.Commands[0] == "sleep 60" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
 	if t.Commands[0] == "/usr/bin/false" {
 		t.ExitCode = 1
 		t.Errored = true
@@ -27,10 +31,14 @@ func (t2 mockTaskRunner) Run(t *task.Task) error {
 	return nil
 }
 
-func (t2 mockTaskRunner) Cancel() {}
-
 func (t2 mockTaskRunner) Finish() {}
 
+func (t2 mockTaskRunner) SetOnTaskChange(func(t *task.Task)) {}
+
+func (t2 mockTaskRunner) SetOnProcessChange(func(c ProcessChange)) {}
+
+func (t2 mockTaskRunner) SetOnCommandChange(func(c CommandChange)) {}
+
 func TestExecutionGraph_Scheduler(t *testing.T) {
 	stage1 := &scheduler.Stage{
 		Name: "stage1",
@@ -60,7 +68,7 @@ func TestExecutionGraph_Scheduler(t *testing.T) {
 	taskRunner := mockTaskRunner{}
 
 	schdlr := NewScheduler(taskRunner)
-	err = schdlr.Schedule(graph)
+	err = schdlr.Schedule(context.Background(), graph)
 	if err == nil {
 		t.Fatal(err)
 	}
@@ -101,7 +109,7 @@ func TestExecutionGraph_Scheduler_AllowFailure(t *testing.T) {
 	taskRunner := mockTaskRunner{}
 
 	schdlr := NewScheduler(taskRunner)
-	err = schdlr.Schedule(graph)
+	err = schdlr.Schedule(context.Background(), graph)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -139,7 +147,7 @@ func TestSkippedStage(t *testing.T) {
 	taskRunner := mockTaskRunner{}
 
 	schdlr := NewScheduler(taskRunner)
-	err = schdlr.Schedule(graph)
+	err = schdlr.Schedule(context.Background(), graph)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -163,18 +171,65 @@ func TestScheduler_Cancel(t *testing.T) {
 	taskRunner := mockTaskRunner{}
 
 	schdlr := NewScheduler(taskRunner)
-	go func() {
-		schdlr.Cancel()
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	err = schdlr.Schedule(graph)
+	err = schdlr.Schedule(ctx, graph)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if atomic.LoadInt32(&schdlr.cancelled) != 1 {
-		t.Error()
+	if stage1.ReadStatus() != scheduler.StatusWaiting {
+		t.Error("stage1 should not be started")
+	}
+}
+
+func TestScheduler_Cancel_OnlyCancelsGraphOfContext(t *testing.T) {
+	newGraph := func() (*scheduler.ExecutionGraph, *scheduler.Stage) {
+		stage := &scheduler.Stage{
+			Name: "stage1",
+			Task: task.FromCommands("sleep 60"),
+		}
+		graph, err := scheduler.NewExecutionGraph(stage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return graph, stage
+	}
+	canceledGraph, canceledStage := newGraph()
+	runningGraph, runningStage := newGraph()
+
+	// A single scheduler executes both graphs
+	schdlr := NewScheduler(mockTaskRunner{})
+
+	canceledCtx, cancelCanceled := context.WithCancel(context.Background())
+	runningCtx, cancelRunning := context.WithCancel(context.Background())
+	defer cancelRunning()
+
+	canceledErr := make(chan error)
+	go func() {
+		canceledErr <- schdlr.Schedule(canceledCtx, canceledGraph)
+	}()
+	runningErr := make(chan error)
+	go func() {
+		runningErr <- schdlr.Schedule(runningCtx, runningGraph)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancelCanceled()
+
+	if err := <-canceledErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+	if canceledStage.ReadStatus() != scheduler.StatusError {
+		t.Errorf("expected canceled stage to be errored, got %v", canceledStage.ReadStatus())
 	}
+	if runningStage.ReadStatus() != scheduler.StatusRunning {
+		t.Errorf("expected other stage to be running, got %v", runningStage.ReadStatus())
+	}
+
+	cancelRunning()
+	<-runningErr
 }
 
 func TestConditionErroredStage(t *testing.T) {
@@ -199,7 +254,7 @@ func TestConditionErroredStage(t *testing.T) {
 	taskRunner := mockTaskRunner{}
 
 	schdlr := NewScheduler(taskRunner)
-	err = schdlr.Schedule(graph)
+	err = schdlr.Schedule(context.Background(), graph)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -212,7 +267,7 @@ func TestConditionErroredStage(t *testing.T) {
 func ExampleScheduler_Schedule() {
 	format := task.FromCommands("go fmt ./...")
 	build := task.FromCommands("go build ./..")
-	r, _ := runner.NewTaskRunner()
+	r, _ := NewTaskRunner(nil)
 	s := NewScheduler(r)
 
 	graph, err := scheduler.NewExecutionGraph(
@@ -223,7 +278,7 @@ func ExampleScheduler_Schedule() {
 		return
 	}
 
-	err = s.Schedule(graph)
+	err = s.Schedule(context.Background(), graph)
 	if err != nil {
 		fmt.Println(err)
 	}
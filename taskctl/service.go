@@ -0,0 +1,189 @@
+package taskctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/taskctl/taskctl/pkg/executor"
+	"github.com/taskctl/taskctl/pkg/variables"
+)
+
+const (
+	// DefaultHealthCheckInterval is the time between health checks of a service if no interval is set
+	DefaultHealthCheckInterval = time.Second
+	// DefaultServiceStartTimeout is the time a service has to become healthy if no start timeout is set
+	DefaultServiceStartTimeout = time.Minute
+)
+
+// ErrServiceExited is returned if the command of a service exited before it was healthy
+var ErrServiceExited = errors.New("service exited before it was healthy")
+
+// ServiceOutputName returns the name the output of a service is stored with in the output store (like a task name)
+func ServiceOutputName(serviceName string) string {
+	return "service:" + serviceName
+}
+
+// Service is a long-running command of a job (e.g. a dev server) that runs alongside the tasks
+type Service struct {
+	Name    string
+	Command string
+	Dir     string
+	Env     map[string]string
+	// HealthCheck is a command that is repeated until it succeeds, the service is healthy once started if empty
+	HealthCheck         string
+	HealthCheckInterval time.Duration
+	StartTimeout        time.Duration
+}
+
+// ServiceRunner is implemented by runners that can start services of jobs
+type ServiceRunner interface {
+	// StartService starts the command of the service and waits until its health check succeeds. The service runs
+	// until ctx is canceled or it is stopped.
+	StartService(ctx context.Context, jobID string, s Service, vars map[string]interface{}, priority ProcessPriority) (*RunningService, error)
+}
+
+// RunningService is a started service
+type RunningService struct {
+	Name string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Done is closed when the command of the service exited
+func (s *RunningService) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error of the command, it must only be called after Done is closed
+func (s *RunningService) Err() error {
+	return s.err
+}
+
+// Stop terminates all processes of the service and waits until the command exited
+func (s *RunningService) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+var _ ServiceRunner = &TaskRunner{}
+
+// StartService starts the command of the service, its output is written to the output store like the output of a task
+// (see ServiceOutputName)
+func (r *TaskRunner) StartService(ctx context.Context, jobID string, s Service, vars map[string]interface{}, priority ProcessPriority) (*RunningService, error) {
+	outputName := ServiceOutputName(s.Name)
+	var stdout, stderr io.Writer = io.Discard, io.Discard
+	var closers []io.Closer
+	if r.outputStore != nil {
+		stdoutStorer, err := r.outputStore.Writer(jobID, outputName, "stdout")
+		if err != nil {
+			return nil, err
+		}
+		stderrStorer, err := r.outputStore.Writer(jobID, outputName, "stderr")
+		if err != nil {
+			_ = stdoutStorer.Close()
+			return nil, err
+		}
+		stdout, stderr = stdoutStorer, stderrStorer
+		closers = append(closers, stdoutStorer, stderrStorer)
+	}
+
+	opts := []ExecutorOpts{WithPriority(priority)}
+	if r.onProcessChange != nil {
+		opts = append(opts, WithOnProcessChange(func(c ProcessChange) {
+			c.JobID = jobID
+			c.TaskName = outputName
+			r.onProcessChange(c)
+		}))
+	}
+	exec, err := NewPgidExecutor(nil, stdout, stderr, r.killTimeout, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	jobVars := variables.NewVariables()
+	for name, value := range vars {
+		jobVars.Set(name, value)
+	}
+	jobVars.Set(JobIDVariableName, jobID)
+	env := r.env.Merge(variables.FromMap(s.Env))
+
+	serviceCtx, cancel := context.WithCancel(ctx)
+	rs := &RunningService{
+		Name:   s.Name,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(rs.done)
+		defer func() {
+			for _, c := range closers {
+				_ = c.Close()
+			}
+		}()
+		_, rs.err = exec.Execute(serviceCtx, &executor.Job{
+			Command: s.Command,
+			Dir:     s.Dir,
+			Env:     env,
+			Vars:    jobVars,
+		})
+	}()
+
+	if s.HealthCheck != "" {
+		if err := r.waitHealthy(serviceCtx, rs, s, env, jobVars); err != nil {
+			rs.Stop()
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// waitHealthy repeats the health check of the service until it succeeds, the command of the service exits or the start
+// timeout is reached
+func (r *TaskRunner) waitHealthy(ctx context.Context, rs *RunningService, s Service, env, vars variables.Container) error {
+	interval := s.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	startTimeout := s.StartTimeout
+	if startTimeout <= 0 {
+		startTimeout = DefaultServiceStartTimeout
+	}
+	deadline := time.NewTimer(startTimeout)
+	defer deadline.Stop()
+
+	var lastErr error
+	for {
+		// The executor keeps state of the shell, so each check uses a new one
+		check, err := NewPgidExecutor(nil, nil, nil, r.killTimeout)
+		if err != nil {
+			return err
+		}
+		_, lastErr = check.Execute(ctx, &executor.Job{
+			Command: s.HealthCheck,
+			Dir:     s.Dir,
+			Env:     env,
+			Vars:    vars,
+		})
+		if lastErr == nil {
+			return nil
+		}
+
+		select {
+		case <-rs.done:
+			if rs.err != nil {
+				return fmt.Errorf("%w: %v", ErrServiceExited, rs.err)
+			}
+			return ErrServiceExited
+		case <-deadline.C:
+			return errors.Wrapf(lastErr, "service not healthy after %s", startTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
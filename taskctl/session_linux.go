@@ -0,0 +1,92 @@
+//go:build linux
+
+package taskctl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// signalSession sends a signal to all processes of the session with the given id.
+// It returns true if a process that is not a zombie was found in the session.
+//
+// The process list is read from /proc, so processes that moved to another process group of the session are found as well.
+func signalSession(sid int, sig syscall.Signal) bool {
+	return signalSessionStartedSince(sid, 0, sig)
+}
+
+// signalSessionStartedSince sends a signal to all processes of the session that started at or after startTime (in clock
+// ticks after system boot, see procStat). It returns true if a process that is not a zombie was found.
+func signalSessionStartedSince(sid int, startTime uint64, sig syscall.Signal) bool {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		// Signal the process group of the session leader directly, since /proc is not available
+		return syscall.Kill(-sid, sig) == nil
+	}
+
+	// Collect the processes before sending any signal, a killed process would otherwise already be a zombie
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		stat, ok := readProcStat(pid)
+		if !ok || stat.session != sid || stat.state == 'Z' || stat.startTime < startTime {
+			continue
+		}
+
+		pids = append(pids, pid)
+	}
+
+	// Signal the process group of the session leader first, so it cannot fork new processes
+	_ = syscall.Kill(-sid, sig)
+	for _, pid := range pids {
+		_ = syscall.Kill(pid, sig)
+	}
+
+	return len(pids) > 0
+}
+
+// procStat contains the fields of /proc/[pid]/stat used by prunner (see proc(5))
+type procStat struct {
+	state   byte
+	session int
+	// startTime is the time the process started after system boot in clock ticks
+	startTime uint64
+}
+
+// readProcStat reads the status of a process from /proc/[pid]/stat
+func readProcStat(pid int) (stat procStat, ok bool) {
+	content, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return stat, false
+	}
+
+	// The command name is in parentheses and can contain spaces, so the fields are parsed after the last ")"
+	i := bytes.LastIndexByte(content, ')')
+	if i < 0 {
+		return stat, false
+	}
+	// Fields after the command name start with field 3 (state), so field n has index n-3
+	fields := bytes.Fields(content[i+1:])
+	if len(fields) < 20 || len(fields[0]) == 0 {
+		return stat, false
+	}
+
+	stat.state = fields[0][0]
+	stat.session, err = strconv.Atoi(string(fields[3]))
+	if err != nil {
+		return stat, false
+	}
+	stat.startTime, err = strconv.ParseUint(string(fields[19]), 10, 64)
+	if err != nil {
+		return stat, false
+	}
+
+	return stat, true
+}
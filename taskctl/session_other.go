@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package taskctl
+
+import (
+	"syscall"
+)
+
+// signalSession sends a signal to the process group of the session leader with the given id.
+// It returns true if a process was found in the process group.
+//
+// Without /proc, processes that moved to another process group of the session cannot be found.
+func signalSession(sid int, sig syscall.Signal) bool {
+	return syscall.Kill(-sid, sig) == nil
+}
@@ -0,0 +1,75 @@
+package taskctl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// PipelineVariableName is the variable for the pipeline name of a job
+const PipelineVariableName = "__pipeline"
+
+// TimestampVariableName is the variable for the start time of a job
+const TimestampVariableName = "__timestamp"
+
+// RenderCommand renders a command as Go template with the given variables.
+//
+// In addition to the sprig functions (https://masterminds.github.io/sprig/) the built-in functions jobID, pipeline
+// and timestamp return information about the job.
+func RenderCommand(command string, vars map[string]interface{}) (string, error) {
+	funcMap := sprig.TxtFuncMap()
+	funcMap["jobID"] = func() string {
+		jobID, _ := vars[JobIDVariableName].(string)
+		return jobID
+	}
+	funcMap["pipeline"] = func() string {
+		pipeline, _ := vars[PipelineVariableName].(string)
+		return pipeline
+	}
+	funcMap["timestamp"] = func() time.Time {
+		timestamp, _ := vars[TimestampVariableName].(time.Time)
+		return timestamp
+	}
+
+	t, err := template.New("command").Funcs(funcMap).Option("missingkey=error").Parse(command)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, vars)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// RenderEnv renders the values of environment variables that contain a template as Go template with the given data
+// (see JobOptions.EnvTemplateData) and the sprig functions. A missing key in the data is an error.
+func RenderEnv(env map[string]interface{}, data map[string]interface{}) (map[string]string, error) {
+	rendered := make(map[string]string, len(env))
+	for name, value := range env {
+		s := fmt.Sprint(value)
+		if !strings.Contains(s, "{{") {
+			rendered[name] = s
+			continue
+		}
+
+		t, err := template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", name, err)
+		}
+		var buf bytes.Buffer
+		err = t.Execute(&buf, data)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", name, err)
+		}
+		rendered[name] = buf.String()
+	}
+	return rendered, nil
+}
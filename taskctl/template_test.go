@@ -0,0 +1,69 @@
+package taskctl_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Flowpack/prunner/taskctl"
+)
+
+func TestRenderCommand(t *testing.T) {
+	vars := map[string]interface{}{
+		taskctl.JobIDVariableName:     "52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8",
+		taskctl.PipelineVariableName:  "release_it",
+		taskctl.TimestampVariableName: time.Date(2022, 5, 17, 10, 30, 0, 0, time.UTC),
+		"tag_name":                    "v1.17.4",
+		"databases":                   []interface{}{"mysql", "postgresql"},
+	}
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{`echo {{ .tag_name }}`, `echo v1.17.4`},
+		{`echo {{ .tag_name | trimPrefix "v" | replace "." "-" }}`, `echo 1-17-4`},
+		{`echo {{ join "," .databases }}`, `echo mysql,postgresql`},
+		{`echo {{ pipeline }}-{{ jobID }}`, `echo release_it-52a5cb79-7556-4c52-8e6f-dd6aaf1bc4c8`},
+		{`echo {{ timestamp | date "20060102-1504" }}`, `echo 20220517-1030`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			rendered, err := taskctl.RenderCommand(tt.command, vars)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, rendered)
+		})
+	}
+
+	_, err := taskctl.RenderCommand("echo {{ .not_defined }}", vars)
+	assert.Error(t, err)
+}
+
+func TestRenderEnv(t *testing.T) {
+	data := map[string]interface{}{
+		"job": map[string]interface{}{
+			"pipeline": "release_it",
+			"labels":   map[string]interface{}{"commit": "a1b2c3d"},
+		},
+		"vars": map[string]interface{}{
+			"tag": "v1.17.4",
+		},
+	}
+
+	rendered, err := taskctl.RenderEnv(map[string]interface{}{
+		"PLAIN":      "no template",
+		"DEPLOY_TAG": `{{ .vars.tag }}`,
+		"RELEASE":    `{{ .job.pipeline }}-{{ .vars.tag | trimPrefix "v" }}-{{ .job.labels.commit }}`,
+	}, data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"PLAIN":      "no template",
+		"DEPLOY_TAG": "v1.17.4",
+		"RELEASE":    "release_it-1.17.4-a1b2c3d",
+	}, rendered)
+
+	_, err = taskctl.RenderEnv(map[string]interface{}{"DEPLOY_TAG": `{{ .vars.not_defined }}`}, data)
+	assert.ErrorContains(t, err, "env DEPLOY_TAG")
+}
@@ -0,0 +1,41 @@
+package taskctl
+
+import (
+	"os"
+	"time"
+)
+
+// ResourceUsage contains the resources used by processes of a task
+type ResourceUsage struct {
+	// UserTime is the CPU time spent in user mode
+	UserTime time.Duration
+	// SystemTime is the CPU time spent in kernel mode
+	SystemTime time.Duration
+	// MaxRSS is the maximum resident set size in bytes (0 if not supported by the OS)
+	MaxRSS int64
+}
+
+// Add combines the usage of two processes: CPU times are summed up and the maximum resident set size is kept
+func (u ResourceUsage) Add(other ResourceUsage) ResourceUsage {
+	result := ResourceUsage{
+		UserTime:   u.UserTime + other.UserTime,
+		SystemTime: u.SystemTime + other.SystemTime,
+		MaxRSS:     u.MaxRSS,
+	}
+	if other.MaxRSS > result.MaxRSS {
+		result.MaxRSS = other.MaxRSS
+	}
+	return result
+}
+
+// usageFromProcessState gets the resource usage of an exited process (including its waited-for children)
+func usageFromProcessState(state *os.ProcessState) ResourceUsage {
+	if state == nil {
+		return ResourceUsage{}
+	}
+	return ResourceUsage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		MaxRSS:     maxRSS(state),
+	}
+}
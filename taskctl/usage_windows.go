@@ -0,0 +1,12 @@
+//go:build windows
+
+package taskctl
+
+import (
+	"os"
+)
+
+func maxRSS(_ *os.ProcessState) int64 {
+	// Resident set size is not reported for processes on Windows
+	return 0
+}
@@ -0,0 +1,106 @@
+package taskctl
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/friendsofgo/errors"
+)
+
+// NoOutputWatch configures the watchdog of a task that marks the task as stuck if it writes no output for Timeout
+type NoOutputWatch struct {
+	Timeout time.Duration `json:"timeout"`
+	// Kill cancels a stuck task, otherwise only a warning is logged
+	Kill bool `json:"kill,omitempty"`
+}
+
+// ErrNoOutput is the error of a task that was killed by the watchdog because it was stuck
+var ErrNoOutput = errors.New("task produced no output")
+
+// outputActivity is written to with the output of a task to track the time of the last output
+type outputActivity struct {
+	// last is the time of the last write in nanoseconds since epoch, it must be accessed atomically
+	last int64
+}
+
+func newOutputActivity() *outputActivity {
+	a := &outputActivity{}
+	a.touch()
+	return a
+}
+
+func (a *outputActivity) Write(p []byte) (n int, err error) {
+	if len(p) > 0 {
+		a.touch()
+	}
+	return len(p), nil
+}
+
+func (a *outputActivity) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+func (a *outputActivity) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// watchNoOutput starts the watchdog for an attempt of a task. The returned context is canceled if the task is stuck and
+// should be killed. Calling stop ends the watchdog and returns ErrNoOutput (with the timeout) if the task was killed.
+func watchNoOutput(ctx context.Context, watch NoOutputWatch, activity *outputActivity, logger log.Interface) (watchCtx context.Context, stop func() error) {
+	activity.touch()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var killed int32
+
+	interval := watch.Timeout / 10
+	if interval > time.Second {
+		interval = time.Second
+	} else if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		warned := false
+		for {
+			select {
+			case <-done:
+				return
+			case <-watchCtx.Done():
+				return
+			case <-t.C:
+			}
+
+			idle := activity.idle()
+			if idle < watch.Timeout {
+				warned = false
+				continue
+			}
+			if watch.Kill {
+				logger.Warnf("Task is stuck, no output for %s, killing it", idle.Round(time.Millisecond))
+				atomic.StoreInt32(&killed, 1)
+				cancel()
+				return
+			}
+			if !warned {
+				logger.Warnf("Task is stuck, no output for %s", idle.Round(time.Millisecond))
+				warned = true
+			}
+		}
+	}()
+
+	return watchCtx, func() error {
+		close(done)
+		cancel()
+		if atomic.LoadInt32(&killed) == 1 {
+			return fmt.Errorf("%w for %s", ErrNoOutput, watch.Timeout)
+		}
+		return nil
+	}
+}
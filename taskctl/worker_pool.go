@@ -0,0 +1,111 @@
+package taskctl
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// WorkerPool bounds the total weight of concurrently executing tasks of all jobs (and task runners).
+//
+// Tasks acquire workers in the order they are ready to execute, a task with a high weight is not overtaken by tasks
+// with a lower weight.
+type WorkerPool struct {
+	size int
+
+	mx      sync.Mutex
+	used    int
+	waiters list.List
+}
+
+type poolWaiter struct {
+	weight int
+	ready  chan struct{}
+}
+
+// NewWorkerPool creates a worker pool with the given size (total weight)
+func NewWorkerPool(size int) *WorkerPool {
+	return &WorkerPool{
+		size: size,
+	}
+}
+
+// Acquire blocks until workers with the given weight are available or the context is done.
+// The weight is limited to the size of the pool, so a task with a higher weight uses the whole pool.
+func (p *WorkerPool) Acquire(ctx context.Context, weight int) error {
+	weight = p.normalizeWeight(weight)
+
+	p.mx.Lock()
+	if p.waiters.Len() == 0 && p.used+weight <= p.size {
+		p.used += weight
+		p.mx.Unlock()
+		return nil
+	}
+
+	w := &poolWaiter{weight: weight, ready: make(chan struct{})}
+	elem := p.waiters.PushBack(w)
+	p.mx.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		p.mx.Lock()
+		select {
+		case <-w.ready:
+			// The workers were acquired concurrently, so they are released again
+			p.used -= weight
+		default:
+			p.waiters.Remove(elem)
+		}
+		p.notifyWaiters()
+		p.mx.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Release frees workers with the given weight that were acquired before
+func (p *WorkerPool) Release(weight int) {
+	weight = p.normalizeWeight(weight)
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.used -= weight
+	p.notifyWaiters()
+}
+
+// Usage returns the weight of executing tasks, the number of waiting tasks and the size of the pool
+func (p *WorkerPool) Usage() (used int, waiting int, size int) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	return p.used, p.waiters.Len(), p.size
+}
+
+func (p *WorkerPool) normalizeWeight(weight int) int {
+	if weight < 1 {
+		return 1
+	}
+	if weight > p.size {
+		return p.size
+	}
+	return weight
+}
+
+// notifyWaiters hands out workers to waiters in order, it must be called with the lock held
+func (p *WorkerPool) notifyWaiters() {
+	for {
+		front := p.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*poolWaiter)
+		if p.used+w.weight > p.size {
+			return
+		}
+		p.used += w.weight
+		p.waiters.Remove(front)
+		close(w.ready)
+	}
+}
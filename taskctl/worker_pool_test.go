@@ -0,0 +1,100 @@
+package taskctl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool_Acquire(t *testing.T) {
+	pool := NewWorkerPool(3)
+	ctx := context.Background()
+
+	require.NoError(t, pool.Acquire(ctx, 2))
+	require.NoError(t, pool.Acquire(ctx, 1))
+
+	acquired := make(chan int, 2)
+	go func() {
+		_ = pool.Acquire(ctx, 2)
+		acquired <- 2
+	}()
+	waitForWaiters(t, pool, 1)
+	// A task with a lower weight must not overtake the waiting task
+	go func() {
+		_ = pool.Acquire(ctx, 1)
+		acquired <- 1
+	}()
+	waitForWaiters(t, pool, 2)
+
+	pool.Release(1)
+	assertNotAcquired(t, acquired)
+
+	// Both waiting tasks fit after the release
+	pool.Release(2)
+	assert.ElementsMatch(t, []int{2, 1}, []int{<-acquired, <-acquired})
+
+	used, waiting, size := pool.Usage()
+	assert.Equal(t, 3, used)
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 3, size)
+}
+
+func TestWorkerPool_Acquire_NormalizesWeight(t *testing.T) {
+	pool := NewWorkerPool(2)
+	ctx := context.Background()
+
+	// A weight above the size uses the whole pool instead of blocking forever
+	require.NoError(t, pool.Acquire(ctx, 5))
+	used, _, _ := pool.Usage()
+	assert.Equal(t, 2, used)
+	pool.Release(5)
+
+	require.NoError(t, pool.Acquire(ctx, 0))
+	used, _, _ = pool.Usage()
+	assert.Equal(t, 1, used)
+}
+
+func TestWorkerPool_Acquire_Canceled(t *testing.T) {
+	pool := NewWorkerPool(1)
+	require.NoError(t, pool.Acquire(context.Background(), 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- pool.Acquire(ctx, 1)
+	}()
+	waitForWaiters(t, pool, 1)
+
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	used, waiting, _ := pool.Usage()
+	assert.Equal(t, 1, used)
+	assert.Equal(t, 0, waiting)
+
+	pool.Release(1)
+	used, _, _ = pool.Usage()
+	assert.Equal(t, 0, used)
+}
+
+func waitForWaiters(t *testing.T, pool *WorkerPool, expected int) {
+	t.Helper()
+
+	assert.Eventually(t, func() bool {
+		_, waiting, _ := pool.Usage()
+		return waiting == expected
+	}, time.Second, time.Millisecond)
+}
+
+func assertNotAcquired(t *testing.T, acquired chan int) {
+	t.Helper()
+
+	select {
+	case weight := <-acquired:
+		t.Fatalf("workers with weight %d should not be acquired", weight)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,6 @@
+#!/bin/sh
+set -e
+
+for target in staging production; do
+  echo "Deploying to $target"
+done
@@ -62,4 +62,4 @@ func (m *mockOutputStore) GetBytes(jobID string, taskName string, outputName str
 		return nil
 	}
 	return buf.Bytes()
-}
\ No newline at end of file
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"time"
 
 	"github.com/apex/log"
@@ -12,16 +13,40 @@ import (
 type MockRunner struct {
 	onTaskChange func(t *task.Task)
 	OnRun        func(t *task.Task) error
-	OnCancel     func()
+	// OnCancel is called if the context of the job is canceled while a task is running
+	OnCancel func()
 }
 
 func (m *MockRunner) SetOnTaskChange(f func(t *task.Task)) {
 	m.onTaskChange = f
 }
 
+func (m *MockRunner) SetOnProcessChange(f func(c taskctl.ProcessChange)) {
+}
+
+func (m *MockRunner) SetOnCommandChange(f func(c taskctl.CommandChange)) {
+}
+
 var _ taskctl.Runner = &MockRunner{}
 
-func (m *MockRunner) Run(t *task.Task) error {
+func (m *MockRunner) Run(ctx context.Context, t *task.Task) error {
+	if m.OnCancel != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				select {
+				case <-done:
+					// The job was canceled after the task was finished
+				default:
+					m.OnCancel()
+				}
+			case <-done:
+			}
+		}()
+	}
+
 	t.Start = time.Now()
 	if m.onTaskChange != nil {
 		m.onTaskChange(t)
@@ -44,11 +69,5 @@ func (m *MockRunner) Run(t *task.Task) error {
 	return err
 }
 
-func (m *MockRunner) Cancel() {
-	if m.OnCancel != nil {
-		m.OnCancel()
-	}
-}
-
 func (m *MockRunner) Finish() {
 }
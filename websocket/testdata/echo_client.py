@@ -0,0 +1,47 @@
+"""Checks an echo server of the websocket package with the client of the Python websockets library (>= 11).
+
+Used by TestUpgrade_PythonClient, the server prefixes every message with "echo: " and closes the connection after
+receiving the message "close".
+"""
+import sys
+
+from websockets.exceptions import ConnectionClosedOK
+from websockets.sync.client import connect
+
+
+def main(url):
+    # The client offers permessage-deflate, which the server must decline
+    with connect(url, open_timeout=5, close_timeout=5) as ws:
+        ws.send("hello")
+        assert ws.recv(timeout=5) == "echo: hello"
+
+        ws.send("ümlauts")
+        assert ws.recv(timeout=5) == "echo: ümlauts"
+
+        # Messages with a 64 bit payload length
+        large = b"x" * 70000
+        ws.send(large)
+        assert ws.recv(timeout=5) == b"echo: " + large
+
+        # Fragmented messages
+        ws.send(["Hel", "lo"])
+        assert ws.recv(timeout=5) == "echo: Hello"
+
+        assert ws.ping(b"ping").wait(5), "no pong received"
+
+    # The server replied to the close frame of the client
+    assert ws.protocol.close_rcvd is not None, "no close frame received"
+    assert ws.protocol.close_rcvd.code == 1000, ws.protocol.close_rcvd
+
+    # The server closes the connection
+    with connect(url, open_timeout=5, close_timeout=5) as ws:
+        ws.send("close")
+        try:
+            ws.recv(timeout=5)
+            raise AssertionError("connection was not closed")
+        except ConnectionClosedOK as e:
+            assert e.rcvd.code == 1000, e.rcvd
+
+
+if __name__ == "__main__":
+    main(sys.argv[1])
@@ -0,0 +1,388 @@
+// Package websocket is a minimal implementation of the WebSocket protocol (RFC 6455), it is used for attaching to
+// running tasks without depending on a full WebSocket library. Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// MessageType is the type of a data message
+type MessageType int
+
+const (
+	// TextMessage is a message with UTF-8 encoded text
+	TextMessage MessageType = 1
+	// BinaryMessage is a message with binary data
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes of close frames
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseTooLarge      = 1009
+)
+
+// MaxMessageSize limits the size of received messages
+const MaxMessageSize = 1 << 20
+
+// acceptGUID is appended to the key of the client to compute the accept header
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// writeTimeout limits the time for writing a frame to a client that does not read
+const writeTimeout = 10 * time.Second
+
+// ErrClosed is returned by ReadMessage after the client closed the connection
+var ErrClosed = errors.New("websocket closed")
+
+// ErrNotWebSocket is returned by Upgrade if the request is not a WebSocket handshake
+var ErrNotWebSocket = errors.New("not a websocket handshake")
+
+// Conn is a WebSocket connection. Messages can be written concurrently, but must only be read by one goroutine.
+type Conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	// client is set for connections opened with Dial, frames of clients are masked
+	client bool
+
+	writeMx sync.Mutex
+	closed  bool
+}
+
+// Upgrade performs the handshake for a WebSocket request and takes over the connection. If the request is not a valid
+// handshake, an error response is sent and ErrNotWebSocket is returned.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection does not support WebSocket", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "hijacking connection")
+	}
+
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, errors.Wrap(err, "writing handshake response")
+	}
+	// Deadlines of the HTTP server must not end the connection
+	_ = netConn.SetDeadline(time.Time{})
+
+	return &Conn{netConn: netConn, reader: rw.Reader}, nil
+}
+
+// Dial opens a client connection to a WebSocket URL (ws:// or http:// scheme, TLS is not supported), the header is
+// sent with the handshake (e.g. for authorization)
+func Dial(rawURL string, header http.Header) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing url")
+	}
+	if u.Scheme != "ws" && u.Scheme != "http" {
+		return nil, errors.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	netConn, err := net.DialTimeout("tcp", host, writeTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting")
+	}
+
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	u.Scheme = "http"
+	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	_ = netConn.SetDeadline(time.Now().Add(writeTimeout))
+	if err := req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, errors.Wrap(err, "writing handshake")
+	}
+	reader := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		netConn.Close()
+		return nil, errors.Wrap(err, "reading handshake response")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		netConn.Close()
+		return nil, &HandshakeError{StatusCode: resp.StatusCode}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		netConn.Close()
+		return nil, errors.New("invalid Sec-WebSocket-Accept header")
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	return &Conn{netConn: netConn, reader: reader, client: true}, nil
+}
+
+// HandshakeError is returned by Dial if the server did not switch protocols
+type HandshakeError struct {
+	StatusCode int
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed with status %d", e.StatusCode)
+}
+
+// AcceptKey returns the value of the Sec-WebSocket-Accept header for the key of the client
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage reads the next data message. Pings are answered and fragmented messages are joined. ErrClosed is
+// returned if the client closed the connection.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		message     []byte
+	)
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			_ = c.CloseWithCode(CloseNormal)
+			return 0, nil, ErrClosed
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.protocolError("new message before the fragmented message was finished")
+			}
+			messageType = MessageType(opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.protocolError("continuation frame without a message")
+			}
+		default:
+			return 0, nil, c.protocolError("unknown opcode")
+		}
+
+		if len(message)+len(payload) > MaxMessageSize {
+			_ = c.CloseWithCode(CloseTooLarge)
+			return 0, nil, errors.New("message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageType, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.protocolError("reserved bits are set")
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, c.protocolError("frames of clients must be masked, frames of servers must not")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, c.protocolError("invalid control frame")
+	}
+	if length > MaxMessageSize {
+		_ = c.CloseWithCode(CloseTooLarge)
+		return false, 0, nil, errors.New("frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(payload, mask)
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) protocolError(msg string) error {
+	_ = c.CloseWithCode(CloseProtocolError)
+	return errors.New("websocket protocol error: " + msg)
+}
+
+// WriteMessage writes a data message in a single frame
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	return c.writeFrame(byte(messageType), data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFragment(opcode, payload, true)
+}
+
+// writeFragment writes a frame that is the last frame of a message if fin is set, it must be called with the write lock
+// held
+func (c *Conn) writeFragment(opcode byte, payload []byte, fin bool) error {
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	if fin {
+		frame = append(frame, 0x80|opcode)
+	} else {
+		frame = append(frame, opcode)
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	if c.client {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(frame[start:], mask)
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_ = c.netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.netConn.Write(frame)
+	return err
+}
+
+func maskBytes(data []byte, mask [4]byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+// Close sends a normal close frame and closes the connection
+func (c *Conn) Close() error {
+	return c.CloseWithCode(CloseNormal)
+}
+
+// CloseWithCode sends a close frame with the code and closes the connection, further calls have no effect
+func (c *Conn) CloseWithCode(code int) error {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	_ = c.writeFragment(opClose, payload, true)
+	return c.netConn.Close()
+}
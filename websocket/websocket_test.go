@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestConn_Echo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(messageType, append([]byte("echo: "), data...))
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := Dial(strings.Replace(srv.URL, "http://", "ws://", 1), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("hello")))
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "echo: hello", string(data))
+
+	// A large message uses an extended payload length
+	large := bytes.Repeat([]byte("x"), 70000)
+	require.NoError(t, conn.WriteMessage(BinaryMessage, large))
+	messageType, data, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, append([]byte("echo: "), large...), data)
+
+	// Pings are answered, the pong is skipped when reading the next message
+	require.NoError(t, conn.writeFrame(opPing, []byte("ping")))
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("after ping")))
+	_, data, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "echo: after ping", string(data))
+}
+
+func TestConn_Fragmented(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_, data, err := conn.ReadMessage()
+		if err == nil {
+			received <- string(data)
+		}
+		_, _, err = conn.ReadMessage()
+		if errors.Is(err, ErrClosed) {
+			received <- "closed"
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := Dial(srv.URL, nil)
+	require.NoError(t, err)
+
+	conn.writeMx.Lock()
+	require.NoError(t, conn.writeFragment(opText, []byte("Hel"), false))
+	require.NoError(t, conn.writeFragment(opContinuation, []byte("lo"), true))
+	conn.writeMx.Unlock()
+	assert.Equal(t, "Hello", <-received)
+
+	require.NoError(t, conn.Close())
+	assert.Equal(t, "closed", <-received)
+}
+
+func TestUpgrade_WithoutHandshake(t *testing.T) {
+	rec := httptest.NewRecorder()
+	_, err := Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.ErrorIs(t, err, ErrNotWebSocket)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestUpgrade_PythonClient checks the server side with the client of the Python websockets library (see
+// testdata/echo_client.py), it is only run if PRUNNER_TEST_WEBSOCKET_PYTHON is set to a Python interpreter with the
+// library installed
+func TestUpgrade_PythonClient(t *testing.T) {
+	python := os.Getenv("PRUNNER_TEST_WEBSOCKET_PYTHON")
+	if python == "" {
+		t.Skip("PRUNNER_TEST_WEBSOCKET_PYTHON is not set")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "close" {
+				return
+			}
+			_ = conn.WriteMessage(messageType, append([]byte("echo: "), data...))
+		}
+	}))
+	defer srv.Close()
+
+	cmd := exec.Command(python, "testdata/echo_client.py", strings.Replace(srv.URL, "http://", "ws://", 1))
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output of client:\n%s", output)
+}